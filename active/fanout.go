@@ -0,0 +1,153 @@
+package active
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+
+	"github.com/m-lab/go/cloud/gcs"
+
+	"github.com/m-lab/etl/retry"
+)
+
+// datePrefix matches prefixes ending in a day directory, e.g.
+// ndt/ndt7/2022/07/01/
+var datePrefix = regexp.MustCompile(`(\d{4})/(\d{2})/(\d{2})/$`)
+
+// maxListErrors is the number of iterator errors tolerated per name range,
+// matching gcs.BucketHandle.GetFilesSince.
+const maxListErrors = 5
+
+// listBackoff is the delay between retries of object iterator errors.  The
+// number of errors is limited by maxListErrors, rather than by attempts.
+var listBackoff = retry.Backoff{
+	Base:   time.Second,
+	Max:    10 * time.Second,
+	Jitter: 0.1,
+}
+
+// nameRange selects the objects with names in [start, end).  An empty start or
+// end leaves that side of the range unbounded.
+type nameRange struct {
+	start, end string
+}
+
+// contains reports whether the name is in the range.  GCS applies the range
+// server side, but some implementations of the storage interfaces, such as
+// gcsfake, do not.
+func (r nameRange) contains(name string) bool {
+	return name >= r.start && (r.end == "" || name < r.end)
+}
+
+// hourRanges splits a day prefix into contiguous name ranges, one per hour,
+// using the archive naming convention <prefix>YYYYMMDDTHHMMSS.ffffffZ-...
+// The first and last ranges are unbounded, so together the ranges cover every
+// object under the prefix, whether or not it follows the convention.  A
+// prefix that is not a day directory yields a single unbounded range.
+func hourRanges(prefix string) []nameRange {
+	m := datePrefix.FindStringSubmatch(prefix)
+	if m == nil {
+		return []nameRange{{}}
+	}
+	ranges := make([]nameRange, 0, 24)
+	start := ""
+	for h := 1; h < 24; h++ {
+		end := fmt.Sprintf("%s%s%s%sT%02d", prefix, m[1], m[2], m[3], h)
+		ranges = append(ranges, nameRange{start, end})
+		start = end
+	}
+	return append(ranges, nameRange{start, ""})
+}
+
+// listRange returns the normal file objects in the name range that match the
+// filter, and their total size.  Like GetFilesSince, it does not traverse
+// subdirectories, and may return partial results with an error.
+func listRange(ctx context.Context, bh *gcs.BucketHandle, prefix string, r nameRange, filter *regexp.Regexp) ([]*storage.ObjectAttrs, int64, error) {
+	qry := storage.Query{
+		Delimiter:   "/",
+		Prefix:      prefix,
+		StartOffset: r.start,
+		EndOffset:   r.end,
+	}
+	it := bh.Objects(ctx, &qry)
+	if it == nil {
+		return nil, 0, fmt.Errorf("nil object iterator for prefix %s", prefix)
+	}
+	files := []*storage.ObjectAttrs{}
+	byteCount := int64(0)
+	errCount := 0
+	for {
+		var o *storage.ObjectAttrs
+		err := listBackoff.Do(ctx, func(int) error {
+			var err error
+			o, err = it.Next()
+			if err == nil || err == iterator.Done ||
+				err == context.Canceled || err == context.DeadlineExceeded {
+				return retry.Stop(err)
+			}
+			errCount++
+			if errCount > maxListErrors {
+				return retry.Stop(err)
+			}
+			log.Println(err, "when listing", r.start, "to", r.end)
+			return err
+		})
+		switch {
+		case err == iterator.Done:
+			return files, byteCount, nil
+		case err == context.Canceled || err == context.DeadlineExceeded:
+			return nil, 0, err
+		case err != nil:
+			return files, byteCount, err
+		}
+		if len(o.Prefix) > 0 || !r.contains(o.Name) {
+			continue
+		}
+		if filter != nil && !filter.MatchString(o.Name) {
+			continue
+		}
+		byteCount += o.Size
+		files = append(files, o)
+	}
+}
+
+// FanOutFileListerFunc creates a FileLister that splits a day prefix into
+// hourly name ranges and lists them concurrently.  For days with tens of
+// thousands of archives, this is much faster than a single object iterator.
+// Results are in name order, as for FileListerFunc.  If any range fails, it
+// returns the objects from all ranges, and the first error.
+func FanOutFileListerFunc(bh *gcs.BucketHandle, prefix string, filter *regexp.Regexp) FileLister {
+	return func(ctx context.Context) ([]*storage.ObjectAttrs, int64, error) {
+		ranges := hourRanges(prefix)
+		files := make([][]*storage.ObjectAttrs, len(ranges))
+		bytes := make([]int64, len(ranges))
+		errs := make([]error, len(ranges))
+		wg := sync.WaitGroup{}
+		for i := range ranges {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				files[i], bytes[i], errs[i] = listRange(ctx, bh, prefix, ranges[i], filter)
+			}(i)
+		}
+		wg.Wait()
+
+		all := []*storage.ObjectAttrs{}
+		byteCount := int64(0)
+		var err error
+		for i := range ranges {
+			all = append(all, files[i]...)
+			byteCount += bytes[i]
+			if err == nil {
+				err = errs[i]
+			}
+		}
+		return all, byteCount, err
+	}
+}
@@ -0,0 +1,103 @@
+package active_test
+
+import (
+	"context"
+	"regexp"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/googleapis/google-cloud-go-testing/storage/stiface"
+
+	"github.com/m-lab/etl/active"
+	"github.com/m-lab/go/cloud/gcs"
+	"github.com/m-lab/go/cloudtest/gcsfake"
+)
+
+// countingBucket counts the object listings.
+type countingBucket struct {
+	*gcsfake.BucketHandle
+	listings int32
+}
+
+func (b *countingBucket) Objects(ctx context.Context, q *storage.Query) stiface.ObjectIterator {
+	atomic.AddInt32(&b.listings, 1)
+	return b.BucketHandle.Objects(ctx, q)
+}
+
+func fanOutBucket() (*countingBucket, *gcs.BucketHandle) {
+	now := time.Now()
+	names := []string{
+		"ndt/ndt7/2022/07/01/20220701T000001.000000Z-ndt7-mlab1-foo01-ndt.tgz",
+		"ndt/ndt7/2022/07/01/20220701T005959.000000Z-ndt7-mlab1-foo01-ndt.tgz",
+		"ndt/ndt7/2022/07/01/20220701T010000.000000Z-ndt7-mlab1-foo01-ndt.tgz",
+		"ndt/ndt7/2022/07/01/20220701T120000.000000Z-ndt7-mlab1-foo01-ndt.tgz",
+		"ndt/ndt7/2022/07/01/20220701T120000.000000Z-ndt7-mlab2-foo01-ndt.tgz",
+		"ndt/ndt7/2022/07/01/20220701T235959.000000Z-ndt7-mlab1-foo01-ndt.tgz",
+		"ndt/ndt7/2022/07/01/unconventional.tgz",
+		"ndt/ndt7/2022/07/02/20220702T000000.000000Z-ndt7-mlab1-foo01-ndt.tgz",
+	}
+	fake := gcsfake.NewBucketHandle()
+	for _, n := range names {
+		fake.ObjAttrs = append(fake.ObjAttrs,
+			&storage.ObjectAttrs{Bucket: "foobar", Name: n, Size: 10, Updated: now})
+	}
+	rb := &countingBucket{BucketHandle: fake}
+	return rb, &gcs.BucketHandle{BucketHandle: rb}
+}
+
+func TestFanOutFileListerFunc(t *testing.T) {
+	tests := []struct {
+		name     string
+		prefix   string
+		filter   *regexp.Regexp
+		want     int
+		listings int32
+	}{
+		{"day", "ndt/ndt7/2022/07/01/", nil, 7, 24},
+		{"filtered", "ndt/ndt7/2022/07/01/", regexp.MustCompile(`mlab1`), 5, 24},
+		{"not-a-day", "ndt/ndt7/2022/07/", nil, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rb, bh := fanOutBucket()
+			ctx := context.Background()
+			got, bytes, err := active.FanOutFileListerFunc(bh, tt.prefix, tt.filter)(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != tt.want || bytes != int64(10*tt.want) {
+				t.Errorf("FanOutFileListerFunc() = %d files, %d bytes, want %d files",
+					len(got), bytes, tt.want)
+			}
+			if rb.listings != tt.listings {
+				t.Errorf("FanOutFileListerFunc() made %d listings, want %d", rb.listings, tt.listings)
+			}
+
+			// The results should match a single listing.
+			want, wantBytes, err := active.FileListerFunc(bh, tt.prefix, tt.filter)(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(want) || bytes != wantBytes {
+				t.Fatalf("FanOutFileListerFunc() = %d files, FileListerFunc() = %d", len(got), len(want))
+			}
+			for i := range want {
+				if got[i].Name != want[i].Name {
+					t.Errorf("FanOutFileListerFunc()[%d] = %s, want %s", i, got[i].Name, want[i].Name)
+				}
+			}
+		})
+	}
+}
+
+func TestFanOutFileListerFunc_Canceled(t *testing.T) {
+	_, bh := fanOutBucket()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err := active.FanOutFileListerFunc(bh, "ndt/ndt7/2022/07/01/", nil)(ctx)
+	if err != context.Canceled {
+		t.Errorf("FanOutFileListerFunc() error = %v, want %v", err, context.Canceled)
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"github.com/m-lab/go/cloud/gcs"
 	"github.com/m-lab/go/rtx"
 
+	"github.com/m-lab/etl/etl"
 	"github.com/m-lab/etl/metrics"
 )
 
@@ -45,6 +46,10 @@ type GardenerAPI struct {
 	trackerBase url.URL
 	gcs         stiface.Client
 	jobs        *gardener.JobClient
+
+	// Fraction of workers reserved for jobs with dates less than freshAge old.
+	reserved float64
+	freshAge time.Duration
 }
 
 // NewGardenerAPI creates a GardenerAPI.
@@ -53,6 +58,14 @@ func NewGardenerAPI(trackerBase url.URL, gcs stiface.Client) *GardenerAPI {
 	return &GardenerAPI{trackerBase: trackerBase, gcs: gcs, jobs: c}
 }
 
+// ReserveForFresh reserves a fraction of the workers used by Poll for jobs
+// with dates less than age old, so that backfill jobs cannot use every worker
+// while current daily data waits.  By default, nothing is reserved.
+func (g *GardenerAPI) ReserveForFresh(fraction float64, age time.Duration) {
+	g.reserved = fraction
+	g.freshAge = age
+}
+
 // MustStorageClient creates a default GCS client.
 func MustStorageClient(ctx context.Context) stiface.Client {
 	c, err := storage.NewClient(ctx, option.WithScopes(storage.ScopeReadOnly))
@@ -135,7 +148,7 @@ func (g *GardenerAPI) JobFileSource(ctx context.Context, job tracker.Job,
 		failMetric(job, "prefix")
 		return nil, err
 	}
-	lister := FileListerFunc(bh, prefix, filter)
+	lister := FanOutFileListerFunc(bh, prefix, filter)
 	gcsSource, err := NewGCSSource(ctx, job, lister, toRunnable)
 	if err != nil {
 		failMetric(job, "GCSSource")
@@ -150,7 +163,7 @@ func (g *GardenerAPI) NextJob(ctx context.Context) (*tracker.JobWithTarget, erro
 }
 
 func (g *GardenerAPI) pollAndRun(ctx context.Context,
-	toRunnable func(o *storage.ObjectAttrs) Runnable, tokens TokenSource) error {
+	toRunnable func(o *storage.ObjectAttrs) Runnable, tokens *PriorityTokens) error {
 	jt, err := g.jobs.Next(ctx)
 	if err != nil {
 		log.Println(err, "on Gardener client.NextJob()")
@@ -163,7 +176,9 @@ func (g *GardenerAPI) pollAndRun(ctx context.Context,
 		log.Println(err, "on JobFileSource")
 		return err
 	}
-	src := Throttle(gcsSource, tokens)
+	// Each task holds tokens in proportion to the CPU weight of its datatype.
+	weight := etl.DataType(jt.Job.Datatype).Profile().CPUWeight
+	src := Throttle(gcsSource, tokens.For(jt.Job.Date, weight))
 
 	log.Println("Running", jt.Job.Path())
 	if err := g.jobs.Update(ctx, jt.ID, tracker.Parsing, "starting tasks"); err != nil {
@@ -198,7 +213,7 @@ func (g *GardenerAPI) Poll(ctx context.Context,
 	toRunnable func(o *storage.ObjectAttrs) Runnable, maxWorkers int, period time.Duration) {
 	// Poll no faster than period.
 	ticker := time.NewTicker(period)
-	throttle := NewWSTokenSource(maxWorkers)
+	throttle := NewPriorityTokens(maxWorkers, g.reserved, g.freshAge)
 	for {
 		select {
 		case <-ctx.Done():
@@ -2,6 +2,7 @@ package active
 
 import (
 	"context"
+	"time"
 
 	"golang.org/x/sync/semaphore"
 )
@@ -14,22 +15,100 @@ type TokenSource interface {
 
 // wsTokenSource is a simple token source for initial testing.
 type wsTokenSource struct {
-	sem *semaphore.Weighted
+	sem    *semaphore.Weighted
+	weight int64 // Tokens acquired for each admission.
 }
 
 // Acquire acquires an admission token.
 func (ts *wsTokenSource) Acquire(ctx context.Context) error {
-	return ts.sem.Acquire(ctx, 1)
+	return ts.sem.Acquire(ctx, ts.weight)
 }
 
 // Release releases an admission token.
 func (ts *wsTokenSource) Release() {
-	ts.sem.Release(1)
+	ts.sem.Release(ts.weight)
 }
 
 // NewWSTokenSource returns a TokenSource based on semaphore.Weighted.
 func NewWSTokenSource(n int) TokenSource {
-	return &wsTokenSource{semaphore.NewWeighted(int64(n))}
+	return &wsTokenSource{sem: semaphore.NewWeighted(int64(n)), weight: 1}
+}
+
+// backfillTokenSource admits a backfill task only when a token is available
+// both from the tokens shared with fresh tasks, and from the smaller number of
+// tokens that backfill tasks may hold at once.
+type backfillTokenSource struct {
+	backfill *semaphore.Weighted
+	all      *semaphore.Weighted
+	weight   int64 // Tokens acquired for each admission.
+}
+
+// Acquire acquires an admission token.
+func (ts *backfillTokenSource) Acquire(ctx context.Context) error {
+	if err := ts.backfill.Acquire(ctx, ts.weight); err != nil {
+		return err
+	}
+	if err := ts.all.Acquire(ctx, ts.weight); err != nil {
+		ts.backfill.Release(ts.weight)
+		return err
+	}
+	return nil
+}
+
+// Release releases an admission token.
+func (ts *backfillTokenSource) Release() {
+	ts.all.Release(ts.weight)
+	ts.backfill.Release(ts.weight)
+}
+
+// PriorityTokens shares a fixed number of tokens between jobs for recent
+// dates and backfill jobs for older dates.  A fraction of the tokens is
+// reserved for recent dates, so that backfills cannot starve the processing
+// of current daily data.
+type PriorityTokens struct {
+	all      *semaphore.Weighted
+	backfill *semaphore.Weighted
+	n, b     int64 // Sizes of all and backfill.
+	freshAge time.Duration
+	now      func() time.Time // for testing.
+}
+
+// NewPriorityTokens returns PriorityTokens with n tokens, of which the given
+// fraction is reserved for jobs with dates less than freshAge old.  Backfill
+// jobs always get at least one token.
+func NewPriorityTokens(n int, reserved float64, freshAge time.Duration) *PriorityTokens {
+	b := n - int(reserved*float64(n))
+	if b < 1 {
+		b = 1
+	}
+	return &PriorityTokens{
+		all:      semaphore.NewWeighted(int64(n)),
+		backfill: semaphore.NewWeighted(int64(b)),
+		n:        int64(n),
+		b:        int64(b),
+		freshAge: freshAge,
+		now:      time.Now,
+	}
+}
+
+// For returns the TokenSource for tasks of a job for the given date, each of
+// which holds weight tokens while it runs.  The weight is limited to the
+// tokens available to the job, so that every task can eventually run.
+func (p *PriorityTokens) For(date time.Time, weight int) TokenSource {
+	w := int64(weight)
+	if w < 1 {
+		w = 1
+	}
+	if p.now().Sub(date) < p.freshAge {
+		if w > p.n {
+			w = p.n
+		}
+		return &wsTokenSource{sem: p.all, weight: w}
+	}
+	if w > p.b {
+		w = p.b
+	}
+	return &backfillTokenSource{backfill: p.backfill, all: p.all, weight: w}
 }
 
 // throttedSource encapsulates a Source and a throttling mechanism.
@@ -114,3 +114,71 @@ func TestThrottledSource(t *testing.T) {
 		t.Error("Max running != 2", src.stats.max())
 	}
 }
+
+func TestPriorityTokens(t *testing.T) {
+	tokens := active.NewPriorityTokens(4, 0.5, 48*time.Hour)
+	fresh := tokens.For(time.Now(), 1)
+	backfill := tokens.For(time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC), 1)
+
+	acquire := func(ts active.TokenSource) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		return ts.Acquire(ctx)
+	}
+
+	// Backfill may only use the unreserved half of the tokens.
+	for i := 0; i < 2; i++ {
+		if err := acquire(backfill); err != nil {
+			t.Fatal("backfill Acquire()", i, err)
+		}
+	}
+	if err := acquire(backfill); err == nil {
+		t.Error("backfill Acquire() should block on reserved tokens")
+	}
+	// Fresh jobs get the reserved tokens.
+	for i := 0; i < 2; i++ {
+		if err := acquire(fresh); err != nil {
+			t.Fatal("fresh Acquire()", i, err)
+		}
+	}
+	if err := acquire(fresh); err == nil {
+		t.Error("fresh Acquire() should block when all tokens are in use")
+	}
+
+	// A backfill release makes room for either kind.
+	backfill.Release()
+	if err := acquire(fresh); err != nil {
+		t.Error("fresh Acquire() after Release()", err)
+	}
+	fresh.Release()
+	if err := acquire(backfill); err != nil {
+		t.Error("backfill Acquire() after Release()", err)
+	}
+}
+
+func TestPriorityTokensWeight(t *testing.T) {
+	tokens := active.NewPriorityTokens(4, 0.5, 48*time.Hour)
+	acquire := func(ts active.TokenSource) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		return ts.Acquire(ctx)
+	}
+
+	// A backfill weight larger than the backfill tokens is limited to them.
+	heavy := tokens.For(time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC), 10)
+	if err := acquire(heavy); err != nil {
+		t.Fatal("heavy backfill Acquire()", err)
+	}
+	fresh := tokens.For(time.Now(), 2)
+	if err := acquire(fresh); err != nil {
+		t.Fatal("fresh Acquire()", err)
+	}
+	if err := acquire(tokens.For(time.Now(), 1)); err == nil {
+		t.Error("Acquire() should block when weighted tokens are in use")
+	}
+	heavy.Release()
+	fresh.Release()
+	if err := acquire(tokens.For(time.Now(), 4)); err != nil {
+		t.Error("Acquire() after Release()", err)
+	}
+}
@@ -0,0 +1,156 @@
+// Package anonymize removes the host portion of client IP addresses from
+// parsed rows, consistent with the M-Lab privacy policy.  IPv4 addresses are
+// truncated to the /24 netblock, and IPv6 addresses to the /48 netblock.
+//
+// Anonymization is applied as a row.Transformer, so it is enabled per
+// deployment by registering the transformer for each datatype before any
+// parsers are created.  Transformers are only applied to parsers created by
+// parser.NewSinkParser, so the legacy NDT web100 and paris-traceroute
+// datatypes, which it does not create, are not covered.
+package anonymize
+
+import (
+	"net"
+	"strings"
+
+	"github.com/m-lab/etl/etl"
+	"github.com/m-lab/etl/row"
+	"github.com/m-lab/etl/schema"
+)
+
+// Method identifies an anonymization method.  The method name is recorded in
+// the ParseInfo of each anonymized row.
+type Method string
+
+const (
+	// None leaves IP addresses unchanged.
+	None = Method("none")
+	// Netblock truncates IPv4 addresses to /24 and IPv6 addresses to /48.
+	Netblock = Method("netblock")
+)
+
+var (
+	ipv4Mask = net.CIDRMask(24, 32)
+	ipv6Mask = net.CIDRMask(48, 128)
+)
+
+// clientDataTypes lists the datatypes, created by parser.NewSinkParser, whose
+// rows contain client IP addresses.
+var clientDataTypes = []etl.DataType{
+	etl.NDT5, etl.NDT7, etl.PCAP, etl.SS, etl.TCPINFO, etl.SCAMPER1,
+}
+
+// IP returns the anonymized form of the ip string.  Empty strings are
+// returned unchanged.  Strings that cannot be parsed as IP addresses are
+// replaced with the empty string, so that unrecognized values never leak.
+func (m Method) IP(ip string) string {
+	if m != Netblock || ip == "" {
+		return ip
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return ""
+	}
+	if v4 := addr.To4(); v4 != nil {
+		return v4.Mask(ipv4Mask).String()
+	}
+	return addr.Mask(ipv6Mask).String()
+}
+
+// Row anonymizes the client IP fields of a parsed row in place, and records
+// the method in the row's ParseInfo.  Rows of unknown types are unchanged.
+func (m Method) Row(r interface{}) {
+	if m != Netblock {
+		return
+	}
+	switch v := r.(type) {
+	case *schema.NDT5ResultRowV2:
+		v.Raw.ClientIP = m.IP(v.Raw.ClientIP)
+		if v.Raw.C2S != nil {
+			v.Raw.C2S.ClientIP = m.IP(v.Raw.C2S.ClientIP)
+		}
+		if v.Raw.S2C != nil {
+			v.Raw.S2C.ClientIP = m.IP(v.Raw.S2C.ClientIP)
+		}
+		v.Parser.Anonymization = string(m)
+	case *schema.NDT7ResultRow:
+		v.Raw.ClientIP = m.IP(v.Raw.ClientIP)
+		v.Parser.Anonymization = string(m)
+	case *schema.SSRow:
+		// Sidestream snapshots are logged on the server, so the remote
+		// address is always the client.
+		if v.A != nil {
+			v.A.ClientIP = m.IP(v.A.ClientIP)
+		}
+		if v.Raw != nil {
+			v.Raw.Connection_spec.Remote_ip = m.IP(v.Raw.Connection_spec.Remote_ip)
+			v.Raw.Snap.RemAddress = m.IP(v.Raw.Snap.RemAddress)
+		}
+		v.Parser.Anonymization = string(m)
+	case *schema.TCPInfoRow:
+		// The tcp-info collector runs on the server, so the destination is
+		// always the remote client.
+		if v.A != nil {
+			v.A.SockID.DstIP = m.IP(v.A.SockID.DstIP)
+		}
+		v.Parser.Anonymization = string(m)
+	case *schema.PCAPRow:
+		// Either end of the captured flow may be the client, so both are
+		// truncated.
+		if v.A != nil {
+			v.A.SrcIP = m.IP(v.A.SrcIP)
+			v.A.DstIP = m.IP(v.A.DstIP)
+		}
+		v.Parser.Anonymization = string(m)
+	case *schema.Scamper1Row:
+		m.tracelb(&v.Raw.Tracelb)
+		v.Parser.Anonymization = string(m)
+	}
+}
+
+// tracelb anonymizes the destination of a traceroute, which is the client,
+// and the hops that reached it, whose addresses, hop IDs, and names would
+// otherwise reveal the client address.
+func (m Method) tracelb(t *schema.BQTracelbLine) {
+	dst := t.Dst
+	anon := m.IP(dst)
+	t.Dst = anon
+	if dst == "" {
+		return
+	}
+	for i := range t.Nodes {
+		n := &t.Nodes[i]
+		if n.Addr == dst {
+			n.Addr = anon
+			n.HopID = strings.TrimSuffix(n.HopID, dst) + anon
+			n.Name = ""
+		}
+		for j := range n.Links {
+			for k := range n.Links[j].Links {
+				if l := &n.Links[j].Links[k]; l.Addr == dst {
+					l.Addr = anon
+				}
+			}
+		}
+	}
+}
+
+// Transformer returns a row.Transformer that applies the method to each row.
+func (m Method) Transformer() row.Transformer {
+	return row.TransformerFunc(func(r interface{}) (interface{}, error) {
+		m.Row(r)
+		return r, nil
+	})
+}
+
+// Enable registers the method's Transformer for every datatype with client
+// IP fields.  It must be called before parsers are created, and is a no-op
+// for None.
+func Enable(m Method) {
+	if m != Netblock {
+		return
+	}
+	for _, dt := range clientDataTypes {
+		row.RegisterTransformer(string(dt), m.Transformer())
+	}
+}
@@ -0,0 +1,134 @@
+package anonymize_test
+
+import (
+	"testing"
+
+	"github.com/m-lab/ndt-server/data"
+	"github.com/m-lab/ndt-server/ndt5/c2s"
+	"github.com/m-lab/tcp-info/inetdiag"
+	"github.com/m-lab/traceroute-caller/parser"
+
+	"github.com/m-lab/etl/anonymize"
+	"github.com/m-lab/etl/etl"
+	"github.com/m-lab/etl/row"
+	"github.com/m-lab/etl/schema"
+)
+
+func TestMethod_IP(t *testing.T) {
+	tests := []struct {
+		name   string
+		method anonymize.Method
+		ip     string
+		want   string
+	}{
+		{"none", anonymize.None, "192.168.1.2", "192.168.1.2"},
+		{"ipv4", anonymize.Netblock, "192.168.1.2", "192.168.1.0"},
+		{"ipv6", anonymize.Netblock, "2001:db8:1234:5678::1", "2001:db8:1234::"},
+		{"ipv4-mapped", anonymize.Netblock, "::ffff:10.1.2.3", "10.1.2.0"},
+		{"empty", anonymize.Netblock, "", ""},
+		{"invalid", anonymize.Netblock, "not-an-ip", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.method.IP(tt.ip); got != tt.want {
+				t.Errorf("IP(%q) = %q, want %q", tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMethod_Row(t *testing.T) {
+	ndt5 := &schema.NDT5ResultRowV2{
+		Raw: data.NDT5Result{
+			ClientIP: "10.1.2.3",
+			C2S:      &c2s.ArchivalData{ClientIP: "10.1.2.3"},
+		},
+	}
+	anonymize.Netblock.Row(ndt5)
+	if ndt5.Raw.ClientIP != "10.1.2.0" || ndt5.Raw.C2S.ClientIP != "10.1.2.0" {
+		t.Errorf("Row() did not anonymize ndt5 row: %+v", ndt5.Raw)
+	}
+	if ndt5.Parser.Anonymization != "netblock" {
+		t.Errorf("Row() Anonymization = %q, want netblock", ndt5.Parser.Anonymization)
+	}
+
+	tcp := &schema.TCPInfoRow{
+		A: &schema.TCPInfoSummary{
+			SockID: inetdiag.SockID{SrcIP: "10.0.0.1", DstIP: "2001:db8:1:2::3"},
+		},
+	}
+	anonymize.Netblock.Row(tcp)
+	if tcp.A.SockID.SrcIP != "10.0.0.1" || tcp.A.SockID.DstIP != "2001:db8:1::" {
+		t.Errorf("Row() did not anonymize tcpinfo row: %+v", tcp.A.SockID)
+	}
+
+	ss := &schema.SSRow{
+		A:   &schema.SSSummary{ServerIP: "10.0.0.1", ClientIP: "10.1.2.3"},
+		Raw: &schema.Web100LogEntry{},
+	}
+	ss.Raw.Connection_spec.Local_ip = "10.0.0.1"
+	ss.Raw.Connection_spec.Remote_ip = "2001:db8:1:2::3"
+	ss.Raw.Snap.RemAddress = "2001:db8:1:2::3"
+	anonymize.Netblock.Row(ss)
+	if ss.A.ServerIP != "10.0.0.1" || ss.A.ClientIP != "10.1.2.0" ||
+		ss.Raw.Connection_spec.Local_ip != "10.0.0.1" ||
+		ss.Raw.Connection_spec.Remote_ip != "2001:db8:1::" ||
+		ss.Raw.Snap.RemAddress != "2001:db8:1::" || ss.Parser.Anonymization != "netblock" {
+		t.Errorf("Row() did not anonymize ss row: %+v %+v", ss.A, ss.Raw.Connection_spec)
+	}
+
+	scamper := &schema.Scamper1Row{}
+	scamper.Raw.Tracelb.Dst = "10.1.2.3"
+	scamper.Raw.Tracelb.Nodes = []schema.BQScamperNode{
+		{HopID: "20220701_host_192.0.2.1", Addr: "192.0.2.1", Name: "router",
+			Links: []schema.BQScamperLinkArray{{Links: []parser.ScamperLink{{Addr: "10.1.2.3"}}}}},
+		{HopID: "20220701_host_10.1.2.3", Addr: "10.1.2.3", Name: "client.example.com"},
+	}
+	anonymize.Netblock.Row(scamper)
+	router, client := scamper.Raw.Tracelb.Nodes[0], scamper.Raw.Tracelb.Nodes[1]
+	if scamper.Raw.Tracelb.Dst != "10.1.2.0" || scamper.Parser.Anonymization != "netblock" ||
+		router.Addr != "192.0.2.1" || router.Name != "router" || router.Links[0].Links[0].Addr != "10.1.2.0" ||
+		client.Addr != "10.1.2.0" || client.HopID != "20220701_host_10.1.2.0" || client.Name != "" {
+		t.Errorf("Row() did not anonymize scamper1 row: %+v", scamper.Raw.Tracelb)
+	}
+
+	pcap := &schema.PCAPRow{A: &schema.PCAPSummary{SrcIP: "10.1.2.3", DstIP: "2001:db8:1:2::3"}}
+	anonymize.Netblock.Row(pcap)
+	if pcap.A.SrcIP != "10.1.2.0" || pcap.A.DstIP != "2001:db8:1::" || pcap.Parser.Anonymization != "netblock" {
+		t.Errorf("Row() did not anonymize pcap row: %+v", pcap.A)
+	}
+
+	ndt7 := &schema.NDT7ResultRow{Raw: data.NDT7Result{ClientIP: "10.1.2.3"}}
+	anonymize.None.Row(ndt7)
+	if ndt7.Raw.ClientIP != "10.1.2.3" || ndt7.Parser.Anonymization != "" {
+		t.Errorf("Row() with None modified row: %+v", ndt7)
+	}
+}
+
+func TestEnable(t *testing.T) {
+	defer row.ResetTransformers()
+
+	anonymize.Enable(anonymize.None)
+	if len(row.Transformers(string(etl.NDT7))) != 0 {
+		t.Error("Enable(None) registered transformers")
+	}
+
+	anonymize.Enable(anonymize.Netblock)
+	ts := row.Transformers(string(etl.NDT7))
+	if len(ts) != 1 {
+		t.Fatalf("Enable(Netblock) registered %d transformers, want 1", len(ts))
+	}
+	r := &schema.NDT7ResultRow{Raw: data.NDT7Result{ClientIP: "10.1.2.3"}}
+	out, err := ts[0].Transform(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.(*schema.NDT7ResultRow).Raw.ClientIP != "10.1.2.0" {
+		t.Errorf("Transform() = %+v", out)
+	}
+	for _, dt := range []etl.DataType{etl.NDT5, etl.PCAP, etl.SS, etl.TCPINFO, etl.SCAMPER1} {
+		if len(row.Transformers(string(dt))) != 1 {
+			t.Errorf("Enable(Netblock) did not register a transformer for %s", dt)
+		}
+	}
+}
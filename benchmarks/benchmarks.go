@@ -0,0 +1,268 @@
+// Package benchmarks measures the throughput of each parser over canonical
+// testdata, and compares it against committed baselines, so that performance
+// regressions are caught before deploy.
+//
+// To check for regressions against baseline.json:
+//
+//	go test ./benchmarks -check
+//
+// To record new baselines after an intentional change in performance:
+//
+//	go test ./benchmarks -update
+package benchmarks
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+
+	"github.com/m-lab/etl/etl"
+	"github.com/m-lab/etl/parser"
+)
+
+// ErrRegression is returned by Compare when throughput falls below the baseline
+// by more than the allowed threshold.
+var ErrRegression = errors.New("throughput regression")
+
+// Case describes the canonical testdata for one parser.
+type Case struct {
+	DataType etl.DataType
+	// Paths to test files, or to .tgz archives of test files, relative to the
+	// testdata root.
+	Paths []string
+}
+
+// Cases are the canonical benchmark cases, relative to parser/testdata.
+var Cases = []Case{
+	{etl.ANNOTATION, []string{"Annotation/ndt-njp6l_1585004303_00000000000170FA.json"}},
+	{etl.HOPANNOTATION1, []string{"HopAnnotation1/20210818T174432Z_1e0b318cf3c2_91.189.88.152.json"}},
+	{etl.NDT5, []string{
+		"NDT5Result/ndt-5hkck_1566219987_000000000000017D.json",
+		"NDT5Result/ndt-m9pcq_1652405655_000000000014FD22.json",
+		"NDT5Result/ndt-vscqp_1565987984_000000000001A1C2.json",
+		"NDT5Result/ndt-x5dms_1589313593_0000000000024063.json",
+	}},
+	{etl.NDT7, []string{
+		"NDT7Result/ndt7-download-20200318T000657.568382877Z.ndt-knwp4_1583603744_000000000000590E.json",
+		"NDT7Result/ndt7-upload-20200318T001352.496224022Z.ndt-knwp4_1583603744_0000000000005CF2.json",
+	}},
+	{etl.PCAP, []string{
+		"PCAP/ndt-4c6fb_1625899199_000000000121C1A0.pcap.gz",
+		"PCAP/ndt-nnwk2_1611335823_00000000000C2DA8.pcap.gz",
+		"PCAP/ndt-nnwk2_1611335823_00000000000C2DA9.pcap.gz",
+		"PCAP/ndt-nnwk2_1611335823_00000000000C2DFE.pcap.gz",
+	}},
+	{etl.SCAMPER1, []string{"Scamper1/valid.jsonl"}},
+	{etl.SW, []string{"Switch/discov1-switch.json.gz", "Switch/discov2-switch.jsonl"}},
+	{etl.TCPINFO, []string{"20190516T013026.744845Z-tcpinfo-mlab4-arn02-ndt.tgz"}},
+}
+
+// Result holds the throughput of a parser over its benchmark case.
+type Result struct {
+	Rows    int64         `json:"rows"`
+	Bytes   int64         `json:"bytes"`
+	Elapsed time.Duration `json:"elapsed"`
+}
+
+// RowsPerSec returns the rate of rows emitted.
+func (r Result) RowsPerSec() float64 {
+	return float64(r.Rows) / r.Elapsed.Seconds()
+}
+
+// BytesPerSec returns the rate of test bytes parsed.
+func (r Result) BytesPerSec() float64 {
+	return float64(r.Bytes) / r.Elapsed.Seconds()
+}
+
+func (r Result) String() string {
+	return fmt.Sprintf("%.0f rows/s, %.0f bytes/s", r.RowsPerSec(), r.BytesPerSec())
+}
+
+// test is a single test file, decompressed as a GCSSource would.
+type test struct {
+	name string
+	data []byte
+}
+
+// gunzip decompresses .gz test files, as GCSSource does.
+func gunzip(name string, data []byte) ([]byte, error) {
+	if !strings.HasSuffix(strings.ToLower(name), "gz") {
+		return data, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return ioutil.ReadAll(zr)
+}
+
+// readArchive returns all regular files in the .tgz archive.
+func readArchive(path string) ([]test, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	tests := []test{}
+	tr := tar.NewReader(zr)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return tests, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = gunzip(h.Name, data); err != nil {
+			return nil, err
+		}
+		tests = append(tests, test{name: h.Name, data: data})
+	}
+}
+
+// load reads all tests for the case from the testdata root.
+func (c Case) load(root string) ([]test, error) {
+	tests := []test{}
+	for _, p := range c.Paths {
+		p = filepath.Join(root, p)
+		if strings.HasSuffix(p, ".tgz") {
+			t, err := readArchive(p)
+			if err != nil {
+				return nil, err
+			}
+			tests = append(tests, t...)
+			continue
+		}
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = gunzip(p, data); err != nil {
+			return nil, err
+		}
+		tests = append(tests, test{name: filepath.Base(p), data: data})
+	}
+	return tests, nil
+}
+
+// countingSink discards rows, counting them.
+type countingSink struct {
+	rows int64
+}
+
+func (s *countingSink) Commit(rows []interface{}, label string) (int, error) {
+	atomic.AddInt64(&s.rows, int64(len(rows)))
+	return len(rows), nil
+}
+
+func (s *countingSink) Close() error { return nil }
+
+// Run parses all tests for the case at least once, and repeatedly until at
+// least minTime has elapsed, and returns the throughput.  Test load time is excluded.
+func (c Case) Run(root string, minTime time.Duration) (Result, error) {
+	tests, err := c.load(root)
+	if err != nil {
+		return Result{}, err
+	}
+	if len(tests) == 0 {
+		return Result{}, fmt.Errorf("no tests for %s", c.DataType)
+	}
+	meta := map[string]bigquery.Value{
+		"filename": "gs://benchmarks/" + string(c.DataType),
+		"date":     civil.Date{Year: 2020, Month: 1, Day: 1},
+	}
+	sink := &countingSink{}
+	p := parser.NewSinkParser(c.DataType, sink, string(c.DataType), "")
+	if p == nil {
+		return Result{}, fmt.Errorf("no parser for %s", c.DataType)
+	}
+
+	r := Result{}
+	start := time.Now()
+	for pass := 0; pass == 0 || time.Since(start) < minTime; pass++ {
+		for _, t := range tests {
+			if _, ok := p.IsParsable(t.name, t.data); !ok {
+				continue
+			}
+			if err := p.ParseAndInsert(meta, t.name, t.data); err != nil {
+				return Result{}, fmt.Errorf("%s: %w", t.name, err)
+			}
+			r.Bytes += int64(len(t.data))
+		}
+		if err := p.Flush(); err != nil {
+			return Result{}, err
+		}
+	}
+	r.Elapsed = time.Since(start)
+	r.Rows = atomic.LoadInt64(&sink.rows)
+	return r, nil
+}
+
+// Baseline maps datatype to the committed throughput for the datatype.
+type Baseline map[etl.DataType]Result
+
+// ReadBaseline reads a Baseline from a JSON file.
+func ReadBaseline(path string) (Baseline, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	base := Baseline{}
+	err = json.Unmarshal(b, &base)
+	return base, err
+}
+
+// Write writes the Baseline as JSON to path.
+func (b Baseline) Write(path string) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// DataTypes returns the datatypes in the Baseline, in sorted order.
+func (b Baseline) DataTypes() []etl.DataType {
+	dts := make([]etl.DataType, 0, len(b))
+	for dt := range b {
+		dts = append(dts, dt)
+	}
+	sort.Slice(dts, func(i, j int) bool { return dts[i] < dts[j] })
+	return dts
+}
+
+// Compare returns ErrRegression if either the row or byte rate of got is less
+// than the baseline by more than threshold, a fraction between 0 and 1.
+func Compare(got, base Result, threshold float64) error {
+	if got.RowsPerSec() < (1-threshold)*base.RowsPerSec() ||
+		got.BytesPerSec() < (1-threshold)*base.BytesPerSec() {
+		return fmt.Errorf("%w: got %v, baseline %v", ErrRegression, got, base)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package benchmarks_test
+
+import (
+	"errors"
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/m-lab/etl/benchmarks"
+)
+
+var (
+	check     = flag.Bool("check", false, "Fail if throughput regresses from baseline.json")
+	update    = flag.Bool("update", false, "Write measured throughput to baseline.json")
+	threshold = flag.Float64("threshold", 0.2, "Allowed fractional throughput regression")
+	minTime   = flag.Duration("min_time", time.Second, "Minimum time to run each parser, with -check or -update")
+)
+
+const (
+	testdata     = "../parser/testdata"
+	baselineFile = "baseline.json"
+)
+
+func TestThroughput(t *testing.T) {
+	base, err := benchmarks.ReadBaseline(baselineFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := *minTime
+	if !*check && !*update {
+		// Just verify that the harness works.
+		d = 0
+	}
+	got := benchmarks.Baseline{}
+	for _, c := range benchmarks.Cases {
+		r, err := c.Run(testdata, d)
+		if err != nil {
+			t.Fatal(c.DataType, err)
+		}
+		if r.Rows == 0 {
+			t.Error(c.DataType, "produced no rows")
+		}
+		got[c.DataType] = r
+	}
+	for _, dt := range got.DataTypes() {
+		t.Logf("%-15s %v", dt, got[dt])
+		b, ok := base[dt]
+		if !ok && !*update {
+			t.Errorf("%s missing from %s", dt, baselineFile)
+			continue
+		}
+		if *check {
+			if err := benchmarks.Compare(got[dt], b, *threshold); err != nil {
+				t.Error(dt, err)
+			}
+		}
+	}
+	if *update {
+		if err := got.Write(baselineFile); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	base := benchmarks.Result{Rows: 100, Bytes: 1000, Elapsed: time.Second}
+	tests := []struct {
+		name string
+		got  benchmarks.Result
+		want error
+	}{
+		{"same", base, nil},
+		{"faster", benchmarks.Result{Rows: 200, Bytes: 2000, Elapsed: time.Second}, nil},
+		{"within-threshold", benchmarks.Result{Rows: 90, Bytes: 900, Elapsed: time.Second}, nil},
+		{"slower-rows", benchmarks.Result{Rows: 50, Bytes: 1000, Elapsed: time.Second}, benchmarks.ErrRegression},
+		{"slower-bytes", benchmarks.Result{Rows: 100, Bytes: 1000, Elapsed: 2 * time.Second}, benchmarks.ErrRegression},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := benchmarks.Compare(tt.got, base, 0.2); !errors.Is(err, tt.want) {
+				t.Errorf("Compare() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func BenchmarkParsers(b *testing.B) {
+	for _, c := range benchmarks.Cases {
+		b.Run(string(c.DataType), func(b *testing.B) {
+			var r benchmarks.Result
+			var err error
+			for i := 0; i < b.N; i++ {
+				if r, err = c.Run(testdata, 0); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.SetBytes(r.Bytes)
+			b.ReportMetric(float64(r.Rows), "rows/op")
+		})
+	}
+}
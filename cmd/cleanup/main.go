@@ -0,0 +1,213 @@
+// cleanup deletes the template tables and/or destination partitions of a
+// datatype for a range of dates, and reports the deleted dates to the gardener
+// tracker, so that days parsed by a bad parser version can be removed and
+// re-parsed.
+//
+// Template tables are named <table>_YYYYMMDD in -template_dataset, and
+// partitions are <table>$YYYYMMDD in -partition_dataset.  Either dataset may be
+// empty to leave those tables alone.  Unless -yes is given, the tables are
+// listed and the deletion must be confirmed by typing "yes".
+//
+// Example:
+//
+//	go run ./cmd/cleanup -project=mlab-sandbox -datatype=ndt/ndt7 \
+//	    -start=2022-07-01 -end=2022-07-04 \
+//	    -template_dataset=batch -partition_dataset=raw_ndt \
+//	    -gardener=http://localhost:8080 -bucket=archive-measurement-lab -dry_run
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+	"google.golang.org/api/googleapi"
+
+	gardener "github.com/m-lab/etl-gardener/client/v2"
+	"github.com/m-lab/etl-gardener/tracker"
+	"github.com/m-lab/go/flagx"
+	"github.com/m-lab/go/rtx"
+
+	"github.com/m-lab/etl/etl"
+)
+
+var (
+	project          = flag.String("project", "", "Project containing the datasets")
+	datatype         = flag.String("datatype", "", "Datatype to delete, as experiment/datatype")
+	table            = flag.String("table", "", "Base name of the tables. Default is the datatype")
+	start            = flag.String("start", "", "First date to delete, as YYYY-MM-DD")
+	end              = flag.String("end", "", "Last date to delete, as YYYY-MM-DD. Default is the start date")
+	templateDataset  = flag.String("template_dataset", "", "Dataset of the _YYYYMMDD template tables to delete. Default is to keep them")
+	partitionDataset = flag.String("partition_dataset", "", "Dataset of the table whose $YYYYMMDD partitions to delete. Default is to keep them")
+	gardenerAddr     = flag.String("gardener", "", "Base URL of the gardener whose jobs for the deleted dates are failed. Default is not to update jobs")
+	bucket           = flag.String("bucket", "", "Archive bucket of the gardener jobs")
+	reason           = flag.String("reason", "deleted by cleanup", "Error reported to gardener for each deleted date")
+	dryRun           = flag.Bool("dry_run", false, "List the tables without deleting them")
+	yes              = flag.Bool("yes", false, "Delete without asking for confirmation")
+	timeout          = flag.Duration("timeout", 10*time.Minute, "Timeout for all deletions and updates")
+	region           = flag.String("region", "", "Run BigQuery jobs in this location, e.g. 'europe-west1', as required for datasets outside the US")
+)
+
+// target is a template table or partition to delete.
+type target struct {
+	date    civil.Date
+	dataset string
+	table   string // Including the _YYYYMMDD suffix or $YYYYMMDD decorator.
+}
+
+func (t target) String() string {
+	return t.dataset + "." + t.table
+}
+
+// targets returns the template tables in templateDS and the partitions in
+// partitionDS of the base table, for each date from first to last.  Empty
+// datasets are skipped.
+func targets(base string, first, last civil.Date, templateDS, partitionDS string) []target {
+	var ts []target
+	for d := first; !d.After(last); d = d.AddDays(1) {
+		if templateDS != "" {
+			ts = append(ts, target{date: d, dataset: templateDS, table: base + etl.TemplateSuffix.Suffix(d)})
+		}
+		if partitionDS != "" {
+			ts = append(ts, target{date: d, dataset: partitionDS, table: base + etl.PartitionSuffix.Suffix(d)})
+		}
+	}
+	return ts
+}
+
+// confirm lists the targets on w, and reports whether the answer read from r
+// is "yes".
+func confirm(r io.Reader, w io.Writer, ts []target) bool {
+	for _, t := range ts {
+		fmt.Fprintln(w, t)
+	}
+	fmt.Fprintf(w, "Delete %d tables? Type 'yes' to continue: ", len(ts))
+	answer, _ := bufio.NewReader(r).ReadString('\n')
+	return strings.TrimSpace(answer) == "yes"
+}
+
+// deleter deletes a table or partition.
+type deleter interface {
+	Delete(ctx context.Context, dataset, table string) error
+}
+
+// bqDeleter deletes BigQuery tables, and partitions given by decorators.
+type bqDeleter struct {
+	client *bigquery.Client
+}
+
+func (d *bqDeleter) Delete(ctx context.Context, dataset, table string) error {
+	return d.client.Dataset(dataset).Table(table).Delete(ctx)
+}
+
+// jobErrorer reports job errors to the gardener tracker.
+type jobErrorer interface {
+	Error(ctx context.Context, id tracker.Key, errString string) error
+}
+
+// isNotFound reports whether err is a BigQuery not found error.
+func isNotFound(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
+
+// deleteAll deletes the targets, ignoring those that do not exist, and returns
+// the dates with a deleted target, and the number of failed deletions.
+func deleteAll(ctx context.Context, d deleter, ts []target) ([]civil.Date, int) {
+	var dates []civil.Date
+	failed := 0
+	for _, t := range ts {
+		err := d.Delete(ctx, t.dataset, t.table)
+		switch {
+		case isNotFound(err):
+			log.Println("Not found", t)
+			continue
+		case err != nil:
+			log.Printf("Failed to delete %s: %v", t, err)
+			failed++
+			continue
+		}
+		log.Println("Deleted", t)
+		if len(dates) == 0 || dates[len(dates)-1] != t.date {
+			dates = append(dates, t.date)
+		}
+	}
+	return dates, failed
+}
+
+// failJobs reports an error for the gardener job of each date, so that the
+// tracker does not consider the deleted dates complete.
+func failJobs(ctx context.Context, jobs jobErrorer, job tracker.Job, dates []civil.Date, reason string) int {
+	failed := 0
+	for _, d := range dates {
+		job.Date = d.In(time.UTC)
+		if err := jobs.Error(ctx, job.Key(), reason); err != nil {
+			log.Printf("Failed to update job %s: %v", job.Key(), err)
+			failed++
+		}
+	}
+	return failed
+}
+
+func main() {
+	flag.Parse()
+	rtx.Must(flagx.ArgsFromEnv(flag.CommandLine), "Could not get args from env")
+	etl.Region = *region
+	if *project == "" {
+		log.Fatal("-project is required")
+	}
+	if *templateDataset == "" && *partitionDataset == "" {
+		log.Fatal("One of -template_dataset or -partition_dataset is required")
+	}
+	experiment, dt, err := etl.SplitExperimentDatatype(*datatype)
+	rtx.Must(err, "Invalid -datatype")
+	job := tracker.Job{Bucket: *bucket, Experiment: experiment, Datatype: dt}
+	base := *table
+	if base == "" {
+		base = job.Datatype
+	}
+	first, err := civil.ParseDate(*start)
+	rtx.Must(err, "Invalid -start %q", *start)
+	last := first
+	if *end != "" {
+		last, err = civil.ParseDate(*end)
+		rtx.Must(err, "Invalid -end %q", *end)
+	}
+
+	ts := targets(base, first, last, *templateDataset, *partitionDataset)
+	if *dryRun {
+		for _, t := range ts {
+			fmt.Println(t)
+		}
+		return
+	}
+	if !*yes && !confirm(os.Stdin, os.Stdout, ts) {
+		log.Fatal("Cancelled")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	client, err := etl.NewBigQueryClient(ctx, *project)
+	rtx.Must(err, "NewClient")
+	dates, failed := deleteAll(ctx, &bqDeleter{client: client}, ts)
+
+	if *gardenerAddr != "" {
+		u, err := url.Parse(*gardenerAddr)
+		rtx.Must(err, "Invalid -gardener %q", *gardenerAddr)
+		failed += failJobs(ctx, gardener.NewJobClient(*u), job, dates, *reason)
+	}
+	if failed != 0 {
+		log.Fatalf("%d of %d deletions and updates failed", failed, len(ts)+len(dates))
+	}
+}
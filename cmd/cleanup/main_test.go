@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/civil"
+	"github.com/go-test/deep"
+	"google.golang.org/api/googleapi"
+
+	"github.com/m-lab/etl-gardener/tracker"
+)
+
+func Test_targets(t *testing.T) {
+	first := civil.Date{Year: 2022, Month: 6, Day: 30}
+	last := civil.Date{Year: 2022, Month: 7, Day: 1}
+	var got []string
+	for _, tg := range targets("ndt7", first, last, "batch", "raw_ndt") {
+		got = append(got, tg.String())
+	}
+	want := []string{
+		"batch.ndt7_20220630", "raw_ndt.ndt7$20220630",
+		"batch.ndt7_20220701", "raw_ndt.ndt7$20220701",
+	}
+	if diff := deep.Equal(got, want); diff != nil {
+		t.Errorf("targets() = %v, diff %v", got, diff)
+	}
+	if got := targets("ndt7", first, last, "", "raw_ndt"); len(got) != 2 {
+		t.Errorf("targets() without template dataset = %v, want 2 partitions", got)
+	}
+}
+
+func Test_confirm(t *testing.T) {
+	ts := targets("ndt7", civil.Date{Year: 2022, Month: 7, Day: 1}, civil.Date{Year: 2022, Month: 7, Day: 1}, "batch", "")
+	var out bytes.Buffer
+	if !confirm(strings.NewReader("yes\n"), &out, ts) {
+		t.Error("confirm(yes) = false")
+	}
+	if !strings.Contains(out.String(), "batch.ndt7_20220701") {
+		t.Errorf("confirm() did not list the tables: %q", out.String())
+	}
+	for _, answer := range []string{"no\n", "y\n", ""} {
+		if confirm(strings.NewReader(answer), &out, ts) {
+			t.Errorf("confirm(%q) = true", answer)
+		}
+	}
+}
+
+type fakeDeleter struct {
+	errs    map[string]error
+	deleted []string
+}
+
+func (f *fakeDeleter) Delete(ctx context.Context, dataset, table string) error {
+	if err := f.errs[dataset+"."+table]; err != nil {
+		return err
+	}
+	f.deleted = append(f.deleted, dataset+"."+table)
+	return nil
+}
+
+func Test_deleteAll(t *testing.T) {
+	first := civil.Date{Year: 2022, Month: 7, Day: 1}
+	ts := targets("ndt7", first, first.AddDays(2), "batch", "raw_ndt")
+	d := &fakeDeleter{errs: map[string]error{
+		// Both tables of the second day are missing.
+		"batch.ndt7_20220702":   &googleapi.Error{Code: 404},
+		"raw_ndt.ndt7$20220702": &googleapi.Error{Code: 404},
+		"raw_ndt.ndt7$20220703": errors.New("permission denied"),
+	}}
+	dates, failed := deleteAll(context.Background(), d, ts)
+	if failed != 1 {
+		t.Errorf("deleteAll() failed = %d, want 1", failed)
+	}
+	want := []civil.Date{first, first.AddDays(2)}
+	if diff := deep.Equal(dates, want); diff != nil {
+		t.Errorf("deleteAll() dates = %v, diff %v", dates, diff)
+	}
+	if len(d.deleted) != 3 {
+		t.Errorf("deleteAll() deleted %v, want 3 tables", d.deleted)
+	}
+}
+
+type fakeJobs struct {
+	errs map[tracker.Key]string
+}
+
+func (f *fakeJobs) Error(ctx context.Context, id tracker.Key, errString string) error {
+	if strings.HasSuffix(string(id), "20220702") {
+		return errors.New("unknown job")
+	}
+	f.errs[id] = errString
+	return nil
+}
+
+func Test_failJobs(t *testing.T) {
+	jobs := &fakeJobs{errs: map[tracker.Key]string{}}
+	job := tracker.Job{Bucket: "archive", Experiment: "ndt", Datatype: "ndt7"}
+	first := civil.Date{Year: 2022, Month: 7, Day: 1}
+	failed := failJobs(context.Background(), jobs, job, []civil.Date{first, first.AddDays(1)}, "bad parser")
+	if failed != 1 {
+		t.Errorf("failJobs() = %d, want 1", failed)
+	}
+	want := map[tracker.Key]string{"archive/ndt/ndt7/20220701": "bad parser"}
+	if diff := deep.Equal(jobs.errs, want); diff != nil {
+		t.Errorf("failJobs() reported %v, diff %v", jobs.errs, diff)
+	}
+}
@@ -2,17 +2,26 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"runtime"
+	"strings"
+	"syscall"
 	"time"
 
+	"cloud.google.com/go/bigquery/storage/managedwriter"
+	"cloud.google.com/go/datastore"
 	gcs "cloud.google.com/go/storage"
+	"github.com/googleapis/google-cloud-go-testing/storage/stiface"
 	"golang.org/x/sync/errgroup"
+	"google.golang.org/api/option"
 
 	"github.com/m-lab/go/flagx"
 	"github.com/m-lab/go/httpx"
@@ -20,9 +29,12 @@ import (
 	"github.com/m-lab/go/rtx"
 
 	"github.com/m-lab/etl/active"
+	"github.com/m-lab/etl/anonymize"
 	"github.com/m-lab/etl/etl"
 	"github.com/m-lab/etl/factory"
 	"github.com/m-lab/etl/metrics"
+	"github.com/m-lab/etl/parser"
+	"github.com/m-lab/etl/row"
 	"github.com/m-lab/etl/storage"
 	"github.com/m-lab/etl/task"
 	"github.com/m-lab/etl/worker"
@@ -38,21 +50,86 @@ import (
 // Flags.
 var (
 	outputType = flagx.Enum{
-		Options: []string{"gcs", "local"},
+		Options: []string{"gcs", "parquet", "avro", "local", "bigquery", "pubsub"},
 		Value:   "gcs",
 	}
+	dateRouting = flagx.Enum{
+		Options: etl.SuffixStrategies(),
+		Value:   string(etl.NoSuffix),
+	}
+	dateSource = flagx.Enum{
+		Options: []string{"row", "archive"},
+		Value:   "row",
+	}
+	environment = flagx.Enum{
+		Options: etl.Environments(),
+		Value:   "",
+	}
+	duplicateTasks = flagx.Enum{
+		Options: []string{"reject", "serialize"},
+		Value:   "reject",
+	}
+	duplicateRowIDs = flagx.Enum{
+		Options: row.IDPolicies(),
+		Value:   string(row.IDCheckOff),
+	}
+	anonymizeIP = flagx.Enum{
+		Options: []string{string(anonymize.None), string(anonymize.Netblock)},
+		Value:   string(anonymize.None),
+	}
+	warmup = flagx.Enum{
+		Options: []string{"off", "degrade", "fail"},
+		Value:   "degrade",
+	}
+	sourceBuckets flagx.StringArray
 
 	maxActiveTasks = flag.Int64("max_active", 1, "Maximum number of active tasks")
 	gardenerAddr   = flag.String("gardener_addr", ":8080", "Use this address for the gardener jobs service")
+	freshReserved  = flag.Float64("fresh_reserved", 0, "Fraction of -max_active tasks reserved for jobs with dates newer than -fresh_age, so that backfills don't starve daily processing")
+	freshAge       = flag.Duration("fresh_age", 48*time.Hour, "Jobs for dates newer than this are considered fresh daily data")
 
 	servicePort     = flag.String("service_port", ":8080", "The main (private) service port")
+	parseMemLimit   = flag.Int64("parse_memory_limit", 0, "Maximum estimated bytes of memory for tests parsed concurrently, or 0 for no limit")
+	memoryLimit     = flag.Uint64("memory_limit", 0, "Memory limit of the process in bytes. When memory in use nears the limit, row buffers are flushed early. 0 disables")
+	memoryFlushAt   = flag.Float64("memory_flush_fraction", 0.8, "Fraction of -memory_limit at which row buffers are flushed early")
+	testTimeout     = flag.Duration("test_timeout", 0, "Maximum time to parse a single test with a parser that can be stopped, or 0 for no limit")
+	maxTestErrors   = flag.Int("max_test_errors", -1, "Fail a task if more than this many of its tests fail to parse, or -1 for no limit")
+	maxTestErrRatio = flag.Float64("max_test_error_ratio", 1, "Fail a task if more than this fraction of its tests fail to parse, or 1 for no limit")
 	shutdownTimeout = flag.Duration("shutdown_timeout", 1*time.Minute, "Graceful shutdown time allowance")
 	gcloudProject   = flag.String("gcloud_project", "", "GCP Project id")
 	isBatch         = flag.Bool("batch_service", false, "Whether to run the parser in batch mode")
 	omitDeltas      = flag.Bool("ndt_omit_deltas", false, "Whether to skip ndt.web100 snapshot deltas")
 	bigqueryProject = flag.String("bigquery_project", "", "Override GCLOUD_PROJECT for BigQuery operations")
 	bigqueryDataset = flag.String("bigquery_dataset", "", "Override the BigQuery dataset for output tables")
-	outputLocation  = flag.String("output_location", "", "If output type is 'gcs', write to this GCS bucket. If output type is 'local', write to this directory")
+	billingProject  = flag.String("billing_project", "", "Bill GCS requests to this project, as required to read requester-pays buckets")
+	region          = flag.String("region", "", "If set, only read archives from, and write output to, GCS buckets in this location, e.g. 'europe-west1', to avoid cross-region egress")
+	dedupWindow     = flag.Duration("dedup_window", 0, "If non-zero, drop deliveries of an archive already processed successfully by this parser version within this window")
+	dedupProject    = flag.String("dedup_datastore_project", "", "If set, share the dedup window across workers using Datastore in this project, instead of memory")
+	leaseTTL        = flag.Duration("lease_ttl", 0, "If non-zero, lease each archive in Datastore while it is processed, renewing the lease before this ttl, so that other workers do not process it concurrently")
+	leaseProject    = flag.String("lease_datastore_project", "", "Datastore project for the -lease_ttl leases")
+	budgetProject   = flag.String("budget_datastore_project", "", "Datastore project of the BigQuery job budget shared with other processes, such as update-summaries. Required with -budget_limit")
+	budgetLimit     = flag.Int("budget_limit", 0, "If positive, run each task that writes to BigQuery (-output=bigquery) with one of the first -budget_limit tokens of the fleet-wide -budget_datastore_project budget. The tokens are shared by all workers and jobs using the budget, not counted per worker. Giving reprocessing workers fewer tokens than the daily jobs reserves the rest for the daily jobs. 0 disables the budget")
+	checkpointProj  = flag.String("checkpoint_datastore_project", "", "If set, checkpoint the progress of each archive in Datastore in this project, so that a retry after a crash skips the tests already committed. Requires -output=gcs")
+	checkpointEvery = flag.Int("checkpoint_entries", 1000, "With -checkpoint_datastore_project, checkpoint every this many archive entries, each time closing the gcs objects and continuing in new ones named for the entry")
+	skipProcessed   = flag.Bool("skip_processed", false, "Skip archives whose -summary_datastore_project summary shows their content was already processed successfully by this parser version")
+	summaryProject  = flag.String("summary_datastore_project", "", "If set, record the task summary and content hash of each archive processed successfully in Datastore in this project. Required with -skip_processed")
+	outputLocation  = flag.String("output_location", "", "If output type is 'gcs', 'parquet' or 'avro', write to this GCS bucket. If output type is 'local', write to this directory")
+	gcsGzipLevel    = flag.Int("gcs_gzip_level", 0, "If output type is 'gcs', gzip output objects at this compression level (1-9, or -2 for Huffman only). 0 disables compression")
+	gcsWriteBuffer  = flag.Int("gcs_write_buffer", 0, "Size in bytes of the buffer in front of the gzip writer for gcs output, or 0 for none")
+	gcsFlushBytes   = flag.Int("gcs_flush_bytes", 0, "Flush the gzip stream for gcs output after this many uncompressed bytes, or 0 to flush only on close")
+	gcsGzipProcs    = flag.Int("gcs_gzip_concurrency", 0, "Compress gcs output in this many parallel blocks, or 0 to compress on the writing goroutine")
+	gcsGzipBlock    = flag.Int("gcs_gzip_block_size", 0, "Size in bytes of each block compressed in parallel, or 0 for 1MB")
+	gcsChunkSize    = flag.Int("gcs_chunk_size", storage.DefaultWriterOptions.ChunkSize, "Upload chunk size in bytes for gcs output")
+	gcsRotateBytes  = flag.Int("gcs_rotate_bytes", 0, "Continue gcs output in a new object, named with a -00001 style part number, after this many uncompressed bytes, or 0 to write one object per table")
+	pubsubTopic     = flag.String("pubsub_topic", "", "With -output=pubsub, publish each row as a JSON message to this Pub/Sub topic, as projects/p/topics/t, with the archive URL as its ordering key")
+	gcsManifest     = flag.Bool("gcs_manifest", false, "For gcs output, write a <archive>.manifest.json object listing the objects of each successful task, after they are all closed")
+	configLocation  = flag.String("config", "", "Per datatype config file, as a local path or gs://bucket/object URL. Reloaded on SIGHUP, and every -config_poll. Changes apply to new tasks")
+	configPoll      = flag.Duration("config_poll", 0, "Reload -config at this interval, or 0 to reload only on SIGHUP")
+	parseTime       = flag.String("parse_time", "", "Parse time recorded in rows: empty for the time each row is parsed, 'task' for the start time of its task, or an RFC3339 timestamp for every row, e.g. for reproducible canary runs")
+	warmupTimeout   = flag.Duration("warmup_timeout", 30*time.Second, "Time allowed for the startup warm-up of clients")
+	uuidMapLocation = flag.String("uuid_map_location", "", "If set, write filename to UUID mapping rows, used as join hints across datatypes, for tcpinfo, ndt5, ndt7, pcap, and annotation tests to this GCS bucket (or directory, if output type is 'local')")
+	deadLetterLoc   = flag.String("dead_letter_location", "", "If set, write the content of each test that fails to parse, with the error in the object metadata, to this GCS bucket, or bucket/prefix")
+	statsLocation   = flag.String("file_stats_location", "", "If set, profile the size and compression ratio of the test files in each archive, and write the histograms to this GCS bucket (or directory, if output type is 'local')")
 )
 
 // Other global values.
@@ -62,13 +139,64 @@ var (
 	// In active polling mode, this holds the GardenerAPI.
 	// TODO: eliminate this global by making the Status handler a receiver.
 	gardenerAPI *active.GardenerAPI
+
+	// inFlight detects duplicate deliveries of archives already in progress,
+	// on this worker, or on any worker if --lease_ttl is set.
+	inFlight = worker.NewInFlight(false)
+
+	// memoryGate limits the estimated memory of tests parsed concurrently
+	// across all tasks, if --parse_memory_limit is set.
+	memoryGate *task.MemoryGate
+
+	// dedup drops duplicate deliveries of recently processed archives, if
+	// --dedup_window is set.
+	dedup *worker.DedupWindow
+
+	// budget limits the BigQuery tasks running concurrently across
+	// processes, if --budget_limit is positive.
+	budget *worker.Budget
+	// checkpoints records the progress of archives, if
+	// --checkpoint_datastore_project is set.
+	checkpoints task.CheckpointStore
+
+	// storageClient is the GCS client constructed by the startup warm-up.
+	// If it is nil, a client is constructed for each task.
+	storageClient stiface.Client
+
+	// parseClock returns the parse time Clock for each task, if --parse_time
+	// is set.
+	parseClock func() row.Clock
+
+	// processed records the task summaries and hashes of archives, if
+	// --summary_datastore_project is set.
+	processed *worker.ProcessedArchives
+
+	// bigquerySinks writes rows with the Storage Write API, if --output is
+	// 'bigquery'.
+	bigquerySinks factory.SinkFactory
+
+	// pubsubSinks publishes rows to the --pubsub_topic, if --output is
+	// 'pubsub'.
+	pubsubSinks factory.SinkFactory
+
+	// sourceClients holds the clients for the buckets allowed by
+	// --source_bucket, or nil to read any bucket with the default client.
+	sourceClients *storage.SourceClients
 )
 
 func init() {
 	// Always prepend the filename and line number.
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
-	flag.Var(&outputType, "output", "Output to bigquery or gcs.")
+	flag.Var(&outputType, "output", "Output to 'gcs' JSONL objects, 'parquet' or 'avro' objects in GCS (one per task), 'local' files, 'bigquery' tables with the Storage Write API, committing the rows of each task atomically when it succeeds, or a 'pubsub' topic.")
+	flag.Var(&environment, "environment", "Select BigQuery output destinations for this environment; -bigquery_project and -bigquery_dataset take precedence.")
+	flag.Var(&duplicateTasks, "duplicate_tasks", "Whether to 'reject' or 'serialize' a task for an archive that is already being processed.")
+	flag.Var(&duplicateRowIDs, "duplicate_row_ids", "Whether to ignore ('off'), 'flag', or 'drop' rows with IDs already emitted by the same task. Flagged tasks fail.")
+	flag.Var(&anonymizeIP, "anonymize_ip", "Anonymize client IPs in parsed rows: 'none' or 'netblock' (/24 IPv4, /48 IPv6).")
+	flag.Var(&warmup, "warmup", "Construct and validate clients, and smoke test the parsers, at startup: 'off' to construct clients lazily, 'degrade' to log failures and construct clients lazily, or 'fail' to exit on failure.")
+	flag.Var(&sourceBuckets, "source_bucket", "Allow archives from this source bucket, given as 'bucket', or 'bucket=key.json' to read it with the service account key in key.json. May be repeated. If unset, archives from any bucket are read with the default credentials.")
+	flag.Var(&dateRouting, "date_routing", "Route gcs output rows to per-date objects by template (_YYYYMMDD) or partition ($YYYYMMDD) suffix, or by 'mode' to use template suffixes with -batch_service and partition suffixes otherwise.")
+	flag.Var(&dateSource, "date_source", "With -date_routing, route each row by its own Date ('row'), or all rows by the archive date ('archive'), so that late archives still land in the partition of the day they were collected.")
 }
 
 // Task Queue can always submit to an admin restricted URL.
@@ -104,8 +232,10 @@ func Status(w http.ResponseWriter, r *http.Request) {
 	switch outputType.Value {
 	case "bigquery":
 		fmt.Fprintf(w, "Writing output to BigQuery\n")
-	case "gcs":
-		fmt.Fprintf(w, "Writing output to %s\n", *outputLocation)
+	case "pubsub":
+		fmt.Fprintf(w, "Publishing output to %s\n", *pubsubTopic)
+	case "gcs", "parquet", "avro":
+		fmt.Fprintf(w, "Writing %s output to %s\n", outputType.Value, *outputLocation)
 	}
 	env := os.Environ()
 	for i := range env {
@@ -114,6 +244,17 @@ func Status(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "</body></html>\n")
 }
 
+// handleSmokeTest runs the parser smoke tests, and writes their results as
+// JSON.  The status is 500 if any parser failed its test.
+func handleSmokeTest(rw http.ResponseWriter, req *http.Request) {
+	results := parser.RunSmokeTests()
+	rw.Header().Set("Content-Type", "application/json")
+	if parser.SmokeError(results) != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+	}
+	json.NewEncoder(rw).Encode(results)
+}
+
 // handleLocalRequest is a handler for v2 parse tasks, typically for testing or debugging.
 func handleLocalRequest(rw http.ResponseWriter, req *http.Request) {
 	fn, err := etl.GetFilename(req.FormValue("filename"))
@@ -130,13 +271,22 @@ func handleLocalRequest(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	c, err := storage.GetStorageClient(false)
+	c, err := getStorageClient()
 	if err != nil {
 		rw.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprintf(rw, "failed to get storage client")
 		return
 	}
 
+	if sourceClients != nil {
+		c, err = sourceClients.Client(dp.Bucket)
+		if err != nil {
+			rw.WriteHeader(http.StatusForbidden)
+			fmt.Fprintf(rw, "source bucket %s is not allowed", dp.Bucket)
+			return
+		}
+	}
+
 	ctx := context.Background()
 	obj, err := c.Bucket(dp.Bucket).Object(dp.Path).Attrs(ctx)
 	if err != nil {
@@ -146,8 +296,13 @@ func handleLocalRequest(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	r := toRunnable(obj)
-	err = r.Run(ctx)
+	runLocal(ctx, rw, toRunnable(obj), dp)
+}
+
+// runLocal runs r and writes the outcome to rw, with a non-200 status if the
+// task failed.
+func runLocal(ctx context.Context, rw http.ResponseWriter, r active.Runnable, dp etl.DataPath) {
+	err := r.Run(ctx)
 	if err != nil {
 		rw.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprintf(rw, "runnable failed to run on %s / %s", dp.Bucket, dp.Path)
@@ -176,18 +331,70 @@ func (r *runnable) Run(ctx context.Context) error {
 		return err
 	}
 
+	// Known bad archives are completed without processing, so that they are
+	// not retried on every cycle.
+	if reason, ok := etl.DeniedArchive(path); ok {
+		log.Println("Skipping denied archive", path, reason)
+		metrics.TaskTotal.WithLabelValues(dp.DataType, "Denied").Inc()
+		return nil
+	}
+
+	if pErr := worker.Preflight(dp, &r.ObjectAttrs); pErr != nil {
+		return pErr
+	}
+
+	hash := worker.HashOf(&r.ObjectAttrs)
+	if *skipProcessed && processed.Contains(ctx, hash, parser.Version()) {
+		log.Println("Skipping unchanged", path, hash)
+		metrics.TaskTotal.WithLabelValues(dp.DataType, "Unchanged").Inc()
+		return nil
+	}
+
+	if dedup.Duplicate(ctx, path, parser.Version()) {
+		log.Println("Skipping duplicate delivery", path)
+		metrics.TaskTotal.WithLabelValues(dp.DataType, "DuplicateDelivery").Inc()
+		return nil
+	}
+
+	if writesBigQuery() {
+		releaseBudget, err := budget.Acquire(ctx)
+		if err != nil {
+			metrics.TaskTotal.WithLabelValues(dp.DataType, "Budget").Inc()
+			return factory.NewError(dp.DataType, "Budget", http.StatusServiceUnavailable, err)
+		}
+		defer releaseBudget()
+	}
+
 	start := time.Now()
-	log.Println("Processing", path)
+	log.Println("Processing", path, hash)
 
 	statusCode := http.StatusOK
-	pErr := worker.ProcessGKETask(ctx, dp, r.tf)
+	tf := &worker.SummaryFactory{Factory: r.tf}
+	pErr := inFlight.ProcessGKETask(ctx, dp, tf)
 	if pErr != nil {
 		statusCode = pErr.Code()
+	} else {
+		// A serialized duplicate completes without a task of its own.
+		if s, ok := tf.Summary(); ok {
+			processed.Add(ctx, hash, parser.Version(), s)
+		}
+		dedup.Done(ctx, path, parser.Version())
 	}
 	metrics.DurationHistogram.WithLabelValues(
 		dp.DataType, http.StatusText(statusCode)).Observe(
 		time.Since(start).Seconds())
-	return err
+	log.Println("Completed", path, hash, http.StatusText(statusCode))
+	// pErr is an interface, so a nil pErr must not be returned as an error.
+	if pErr != nil {
+		return pErr
+	}
+	return nil
+}
+
+// writesBigQuery returns whether tasks write BigQuery streams, and so must
+// hold a token of the budget.  Avro output is loaded later, by load-avro.
+func writesBigQuery() bool {
+	return outputType.Value == "bigquery"
 }
 
 func (r *runnable) Info() string {
@@ -195,8 +402,79 @@ func (r *runnable) Info() string {
 	return r.Name
 }
 
+// getStorageClient returns the GCS client from the warm-up, or a new client if
+// there is none.
+func getStorageClient() (stiface.Client, error) {
+	if storageClient != nil {
+		return storageClient, nil
+	}
+	return storage.GetStorageClient(false)
+}
+
+// deadLetterBucket returns the bucket and object prefix of the
+// -dead_letter_location.
+func deadLetterBucket() (string, string) {
+	bucket, prefix, _ := strings.Cut(strings.TrimPrefix(*deadLetterLoc, "gs://"), "/")
+	return bucket, prefix
+}
+
+// warmupChecks returns the checks that construct the GCS client, into *c, and
+// validate the output buckets, and that they are in the -region.
+func warmupChecks(c *stiface.Client) []worker.WarmupCheck {
+	switch outputType.Value {
+	case "local", "bigquery", "pubsub":
+		return nil
+	}
+	checks := []worker.WarmupCheck{{
+		Name: "gcs",
+		Run: func(ctx context.Context) error {
+			var err error
+			*c, err = storage.GetStorageClient(false)
+			if err != nil {
+				return err
+			}
+			return storage.CheckBucketRegion(ctx, *c, *outputLocation)
+		},
+	}}
+	if *uuidMapLocation != "" {
+		checks = append(checks, worker.WarmupCheck{
+			Name: "uuid_map",
+			Run: func(ctx context.Context) error {
+				if *c == nil {
+					return errors.New("no GCS client")
+				}
+				return storage.CheckBucketRegion(ctx, *c, *uuidMapLocation)
+			},
+		})
+	}
+	if *deadLetterLoc != "" {
+		checks = append(checks, worker.WarmupCheck{
+			Name: "dead_letter",
+			Run: func(ctx context.Context) error {
+				if *c == nil {
+					return errors.New("no GCS client")
+				}
+				bucket, _ := deadLetterBucket()
+				return storage.CheckBucketRegion(ctx, *c, bucket)
+			},
+		})
+	}
+	if *statsLocation != "" {
+		checks = append(checks, worker.WarmupCheck{
+			Name: "file_stats",
+			Run: func(ctx context.Context) error {
+				if *c == nil {
+					return errors.New("no GCS client")
+				}
+				return storage.CheckBucketRegion(ctx, *c, *statsLocation)
+			},
+		})
+	}
+	return checks
+}
+
 func toRunnable(obj *gcs.ObjectAttrs) active.Runnable {
-	c, err := storage.GetStorageClient(false)
+	c, err := getStorageClient()
 	if err != nil {
 		return nil // TODO add an error?
 	}
@@ -204,24 +482,116 @@ func toRunnable(obj *gcs.ObjectAttrs) active.Runnable {
 	var sink factory.SinkFactory
 	switch outputType.Value {
 	case "gcs":
-		sink = storage.NewSinkFactory(c, *outputLocation)
+		sink = storage.NewSuffixSinkFactory(c, *outputLocation,
+			etl.SuffixStrategy(dateRouting.Value), dateSource.Value == "archive")
+	case "parquet":
+		sink = storage.NewParquetSinkFactory(c, *outputLocation)
+	case "avro":
+		sink = storage.NewAvroSinkFactory(c, *outputLocation)
 	case "local":
 		sink = storage.NewLocalFactory(*outputLocation)
+	case "bigquery":
+		sink = bigquerySinks
+	case "pubsub":
+		sink = pubsubSinks
+	}
+
+	var uuidMap factory.SinkFactory
+	if *uuidMapLocation != "" {
+		switch outputType.Value {
+		case "gcs":
+			uuidMap = storage.NewSinkFactory(c, *uuidMapLocation)
+		case "parquet":
+			uuidMap = storage.NewParquetSinkFactory(c, *uuidMapLocation)
+		case "avro":
+			uuidMap = storage.NewAvroSinkFactory(c, *uuidMapLocation)
+		case "local":
+			uuidMap = storage.NewLocalFactory(*uuidMapLocation)
+		}
+	}
+
+	var fileStats factory.SinkFactory
+	if *statsLocation != "" {
+		switch outputType.Value {
+		case "gcs":
+			fileStats = storage.NewSinkFactory(c, *statsLocation)
+		case "parquet":
+			fileStats = storage.NewParquetSinkFactory(c, *statsLocation)
+		case "avro":
+			fileStats = storage.NewAvroSinkFactory(c, *statsLocation)
+		case "local":
+			fileStats = storage.NewLocalFactory(*statsLocation)
+		}
+	}
+
+	var deadLetter task.DeadLetter
+	if *deadLetterLoc != "" {
+		bucket, prefix := deadLetterBucket()
+		deadLetter = storage.NewDeadLetterWriter(c, bucket, prefix)
+	}
+
+	source := storage.GCSSourceFactory(c)
+	if sourceClients != nil {
+		source = storage.MultiBucketSourceFactory(sourceClients)
 	}
 
 	taskFactory := worker.StandardTaskFactory{
-		Sink:   sink,
-		Source: storage.GCSSourceFactory(c),
+		Sink:              sink,
+		Source:            source,
+		TestTimeout:       *testTimeout,
+		MemoryGate:        memoryGate,
+		UUIDMap:           uuidMap,
+		FileStats:         fileStats,
+		Suffix:            etl.SuffixStrategy(dateRouting.Value),
+		Clock:             parseClock,
+		Checkpoints:       checkpoints,
+		CheckpointEntries: *checkpointEvery,
+		DeadLetter:        deadLetter,
 	}
 	return &runnable{&taskFactory, *obj}
 }
 
+// newParseClock returns a function providing the parse time Clock for each
+// task, as specified by the -parse_time flag, or nil for the default Clock.
+func newParseClock(value string) (func() row.Clock, error) {
+	switch value {
+	case "":
+		return nil, nil
+	case "task":
+		return func() row.Clock { return row.FixedClock(time.Now()) }, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return func() row.Clock { return row.FixedClock(t) }, nil
+}
+
+// mustSourceClients creates the clients for reading the source buckets given
+// by specs, in the form accepted by storage.ParseSourceBucket.
+func mustSourceClients(specs []string) *storage.SourceClients {
+	def, err := storage.GetStorageClient(false)
+	rtx.Must(err, "Failed to create storage client")
+	sc := storage.NewSourceClients(def)
+	for _, spec := range specs {
+		bucket, creds, err := storage.ParseSourceBucket(spec)
+		rtx.Must(err, "Invalid -source_bucket")
+		var c stiface.Client
+		if creds != "" {
+			c, err = storage.GetStorageClient(false, option.WithCredentialsFile(creds))
+			rtx.Must(err, "Failed to create storage client for "+bucket)
+		}
+		sc.Add(bucket, c)
+	}
+	return sc
+}
+
 func mustGardenerAPI(ctx context.Context, jobServer string) *active.GardenerAPI {
 	rawBase := fmt.Sprintf("http://%s", jobServer)
 	base, err := url.Parse(rawBase)
 	rtx.Must(err, "Invalid jobServer: "+rawBase)
 
-	return active.NewGardenerAPI(*base, active.MustStorageClient(ctx))
+	return active.NewGardenerAPI(*base, storage.WithBillingProject(active.MustStorageClient(ctx), storage.BillingProject))
 }
 
 // Used for testing.
@@ -280,14 +650,165 @@ func main() {
 	// TODO: eliminate global variables in favor of config/env object.
 	etl.IsBatch = *isBatch
 	etl.OmitDeltas = *omitDeltas
+	parser.MaxTestErrors = *maxTestErrors
+	parser.MaxTestErrorRatio = *maxTestErrRatio
 	etl.GCloudProject = *gcloudProject
 	etl.BigqueryProject = *bigqueryProject
 	etl.BigqueryDataset = *bigqueryDataset
+	etl.Region = *region
+	storage.BillingProject = *billingProject
+	storage.DefaultWriterOptions = storage.WriterOptions{
+		GzipLevel:       *gcsGzipLevel,
+		BufferSize:      *gcsWriteBuffer,
+		FlushBytes:      *gcsFlushBytes,
+		ChunkSize:       *gcsChunkSize,
+		GzipConcurrency: *gcsGzipProcs,
+		GzipBlockSize:   *gcsGzipBlock,
+		RotateBytes:     *gcsRotateBytes,
+		Manifest:        *gcsManifest,
+	}
+	rtx.Must(storage.DefaultWriterOptions.Validate(), "Invalid -gcs output flags")
+	etl.Environment = environment.Value
+
+	inFlight = worker.NewInFlight(duplicateTasks.Value == "serialize")
+	clock, err := newParseClock(*parseTime)
+	rtx.Must(err, "Invalid -parse_time %q", *parseTime)
+	parseClock = clock
+	if *parseMemLimit > 0 {
+		memoryGate = task.NewMemoryGate(*parseMemLimit)
+	}
+	if *memoryLimit > 0 {
+		go worker.NewPressureMonitor(*memoryLimit, *memoryFlushAt, time.Second).Run(mainCtx)
+	}
+	if *dedupWindow > 0 {
+		var store worker.DedupStore = worker.NewMemoryStore()
+		if *dedupProject != "" {
+			client, err := datastore.NewClient(mainCtx, *dedupProject)
+			rtx.Must(err, "Failed to create datastore client")
+			store = worker.NewDatastoreStore(client, "etl")
+		}
+		dedup = worker.NewDedupWindow(store, *dedupWindow)
+	}
+	if *skipProcessed && *summaryProject == "" {
+		log.Fatal("-summary_datastore_project is required with -skip_processed")
+	}
+	if *summaryProject != "" {
+		client, err := datastore.NewClient(mainCtx, *summaryProject)
+		rtx.Must(err, "Failed to create datastore client")
+		processed = worker.NewProcessedArchives(worker.NewDatastoreSummaryStore(client, "etl"))
+	}
+	if *leaseTTL > 0 {
+		if *leaseProject == "" {
+			log.Fatal("-lease_datastore_project is required with -lease_ttl")
+		}
+		client, err := datastore.NewClient(mainCtx, *leaseProject)
+		rtx.Must(err, "Failed to create datastore client")
+		host, err := os.Hostname()
+		rtx.Must(err, "Failed to get hostname")
+		holder := fmt.Sprintf("%s-%d", host, os.Getpid())
+		// Deliveries of archives leased by other workers are rejected.
+		inFlight.SetTracker(worker.NewLeases(worker.NewDatastoreLeaseStore(client, "etl"), holder, *leaseTTL))
+	}
+	if *budgetLimit < 0 {
+		log.Fatal("-budget_limit must not be negative")
+	}
+	if *budgetLimit > 0 {
+		if *budgetProject == "" {
+			log.Fatal("-budget_datastore_project is required with -budget_limit")
+		}
+		client, err := datastore.NewClient(mainCtx, *budgetProject)
+		rtx.Must(err, "Failed to create datastore client")
+		host, err := os.Hostname()
+		rtx.Must(err, "Failed to get hostname")
+		holder := fmt.Sprintf("%s-%d", host, os.Getpid())
+		budget = worker.NewBudget(worker.NewDatastoreLeaseStore(client, "etl"), "bigquery", holder, *budgetLimit, time.Minute)
+	}
+	if outputType.Value == "bigquery" {
+		bq, err := etl.NewBigQueryClient(mainCtx, *gcloudProject)
+		rtx.Must(err, "Failed to create BigQuery client")
+		w, err := managedwriter.NewClient(mainCtx, *gcloudProject)
+		rtx.Must(err, "Failed to create BigQuery write client")
+		bigquerySinks = storage.NewWriteAPISinkFactory(bq, w)
+	}
+	if outputType.Value == "pubsub" {
+		if *pubsubTopic == "" {
+			log.Fatal("-output=pubsub requires -pubsub_topic")
+		}
+		pub, err := storage.NewPublisher(mainCtx, *pubsubTopic)
+		rtx.Must(err, "Failed to create Pub/Sub client")
+		pubsubSinks = storage.NewPubSubSinkFactory(pub)
+	}
+	if *checkpointProj != "" {
+		// Only gcs sinks can publish the rows of each checkpoint before the
+		// task ends.  Other sinks, manifests, UUID maps and file stats
+		// would all hold only the rows of the attempt that completes.
+		if outputType.Value != "gcs" {
+			log.Fatal("-checkpoint_datastore_project requires -output=gcs")
+		}
+		if *gcsManifest || *uuidMapLocation != "" || *statsLocation != "" {
+			log.Fatal("-checkpoint_datastore_project cannot be used with -gcs_manifest, -uuid_map_location or -file_stats_location")
+		}
+		if *checkpointEvery < 1 {
+			log.Fatal("-checkpoint_entries must be positive")
+		}
+		client, err := datastore.NewClient(mainCtx, *checkpointProj)
+		rtx.Must(err, "Failed to create datastore client")
+		checkpoints = task.NewDatastoreCheckpointStore(client, "etl")
+	}
+
+	if len(sourceBuckets) > 0 {
+		sourceClients = mustSourceClients(sourceBuckets)
+	}
+
+	if warmup.Value != "off" {
+		// A broken parser build fails here, before it consumes real tasks.
+		err := worker.Warmup(mainCtx, []worker.WarmupCheck{{
+			Name: "parsers",
+			Run: func(ctx context.Context) error {
+				return parser.SmokeError(parser.RunSmokeTests())
+			},
+		}})
+		if warmup.Value == "fail" {
+			rtx.Must(err, "Parser smoke test failed")
+		}
+	}
+	if warmup.Value != "off" {
+		var c stiface.Client
+		ctx, cancel := context.WithTimeout(mainCtx, *warmupTimeout)
+		err := worker.Warmup(ctx, warmupChecks(&c))
+		cancel()
+		if warmup.Value == "fail" {
+			rtx.Must(err, "Warm-up failed")
+		}
+		if err != nil {
+			log.Println("Continuing with lazily constructed clients:", err)
+		} else {
+			// Use the warm client only if all of its buckets are usable.
+			storageClient = c
+		}
+	}
+
+	if *configLocation != "" {
+		var client stiface.Client
+		if strings.HasPrefix(*configLocation, "gs://") {
+			client = storage.WithBillingProject(active.MustStorageClient(mainCtx), storage.BillingProject)
+		}
+		w := worker.NewConfigWatcher(worker.NewConfigLoader(client, *configLocation))
+		rtx.Must(w.Reload(mainCtx), "Could not load -config %q", *configLocation)
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go w.Watch(mainCtx, hup, *configPoll)
+	}
+
+	// Must be enabled before any parsers are created.
+	anonymize.Enable(anonymize.Method(anonymizeIP.Value))
+	row.DefaultIDPolicy = row.IDPolicy(duplicateRowIDs.Value)
 
 	if len(*gardenerAddr) > 0 {
 		log.Println("Using", *gardenerAddr)
 		minPollingInterval := 10 * time.Second
 		gardenerAPI = mustGardenerAPI(mainCtx, *gardenerAddr)
+		gardenerAPI.ReserveForFresh(*freshReserved, *freshAge)
 		// Note that this does not currently track duration metric.
 		go gardenerAPI.Poll(mainCtx, toRunnable, (int)(*maxActiveTasks), minPollingInterval)
 	} else {
@@ -303,6 +824,10 @@ func main() {
 
 	// Registers handler for v2 datatypes. Works with "local" output for local development.
 	mux.HandleFunc("/v2/worker", handleLocalRequest)
+	// Lists the tasks in flight, for the gardener.
+	mux.HandleFunc("/v2/tasks", inFlight.ServeTasks)
+	// Runs the parser smoke tests, e.g. for deployment checks.
+	mux.HandleFunc("/v2/smoke", handleSmokeTest)
 
 	_ = startServers(mainCtx, mux)
 }
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	gcs "cloud.google.com/go/storage"
+
+	"github.com/m-lab/etl/etl"
+	"github.com/m-lab/etl/factory"
+	"github.com/m-lab/etl/parser"
+	"github.com/m-lab/etl/task"
+)
+
+// failingFactory fails to create every task.
+type failingFactory struct{}
+
+func (failingFactory) Get(ctx context.Context, dp etl.DataPath) (*task.Task, etl.ProcessingError) {
+	return nil, factory.NewError(dp.DataType, "TestFailure",
+		http.StatusInternalServerError, errors.New("task failed"))
+}
+
+func TestRunLocal_TaskFailure(t *testing.T) {
+	obj := gcs.ObjectAttrs{
+		Bucket: "archive-mlab-testing",
+		Name:   "ndt/ndt7/2021/06/17/20210617T003002.410133Z-ndt7-mlab1-foo01-ndt.tgz",
+	}
+	dp, err := etl.ValidateTestPath("gs://" + obj.Bucket + "/" + obj.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &runnable{failingFactory{}, obj}
+
+	if err := r.Run(context.Background()); err == nil {
+		t.Error("Run() returned nil for a failed task")
+	}
+
+	rw := httptest.NewRecorder()
+	runLocal(context.Background(), rw, r, dp)
+	if rw.Code == http.StatusOK {
+		t.Errorf("runLocal() status = %d, want non-200 for a failed task", rw.Code)
+	}
+}
+
+func TestHandleSmokeTest(t *testing.T) {
+	rw := httptest.NewRecorder()
+	handleSmokeTest(rw, httptest.NewRequest(http.MethodGet, "/v2/smoke", nil))
+	if rw.Code != http.StatusOK {
+		t.Errorf("handleSmokeTest() status = %d, want %d: %s", rw.Code, http.StatusOK, rw.Body)
+	}
+	var results []parser.SmokeResult
+	if err := json.Unmarshal(rw.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) == 0 {
+		t.Error("handleSmokeTest() returned no results")
+	}
+}
@@ -0,0 +1,268 @@
+// freshness reports how far the parsed data in BigQuery lags behind the
+// archives in GCS, for each datatype, and exits with a non-zero status if any
+// lag exceeds its threshold.  It is intended to run periodically, e.g. as a
+// Kubernetes CronJob, pushing the lags to a Prometheus Pushgateway, so that
+// alerts can fire on stale tables.
+//
+// Each -datatype is given as experiment/datatype.  The date lag is the number
+// of days between the latest archive date in gs://<bucket>/<experiment>/<datatype>/
+// and the latest Date in <project>.<dataset_prefix><experiment>.<datatype>.  The
+// parse lag is the time since the latest Parser.Time in that table.
+//
+// Example:
+//
+//	go run ./cmd/freshness -project=mlab-oti -bucket=archive-measurement-lab \
+//	    -datatype=ndt/ndt7 -datatype=ndt/tcpinfo \
+//	    -max_date_lag=2 -max_parse_lag=36h
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+	gcs "cloud.google.com/go/storage"
+	"github.com/googleapis/google-cloud-go-testing/storage/stiface"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"google.golang.org/api/iterator"
+
+	"github.com/m-lab/go/flagx"
+	"github.com/m-lab/go/rtx"
+
+	"github.com/m-lab/etl/etl"
+	"github.com/m-lab/etl/storage"
+)
+
+var (
+	project       = flag.String("project", "", "Project containing the BigQuery datasets")
+	bucket        = flag.String("bucket", "", "GCS bucket containing the archives")
+	datasetPrefix = flag.String("dataset_prefix", "raw_", "Prefix of the dataset for each experiment")
+	window        = flag.Int("window", 30, "Number of days to search back for archives and rows")
+	maxDateLag    = flag.Int("max_date_lag", 1, "Largest acceptable date lag, in days")
+	maxParseLag   = flag.Duration("max_parse_lag", 48*time.Hour, "Largest acceptable time since the latest parse")
+	pushgateway   = flag.String("pushgateway", "", "Pushgateway URL for the lag metrics. Default is not to push")
+	timeout       = flag.Duration("timeout", 10*time.Minute, "Timeout for all queries")
+	region        = flag.String("region", "", "Run BigQuery jobs in this location, e.g. 'europe-west1', as required for datasets outside the US")
+	datatypes     flagx.StringArray
+)
+
+func init() {
+	flag.Var(&datatypes, "datatype", "Datatype to check, as experiment/datatype. May be repeated")
+}
+
+var (
+	// DateLag is the number of days that the latest date in BigQuery lags
+	// behind the latest archive date.
+	//
+	// Provides metrics:
+	//   etl_freshness_date_lag_days{datatype}
+	DateLag = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "etl_freshness_date_lag_days",
+			Help: "Days between the latest archive date and the latest date in BigQuery.",
+		},
+		[]string{"datatype"},
+	)
+
+	// ParseLag is the time since the latest row was parsed into BigQuery.
+	//
+	// Provides metrics:
+	//   etl_freshness_parse_lag_seconds{datatype}
+	ParseLag = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "etl_freshness_parse_lag_seconds",
+			Help: "Seconds since the latest Parser.Time in BigQuery.",
+		},
+		[]string{"datatype"},
+	)
+)
+
+// source identifies the archives and table of a datatype.
+type source struct {
+	experiment string
+	datatype   string
+}
+
+// parseSource parses experiment/datatype.
+func parseSource(s string) (source, error) {
+	experiment, datatype, err := etl.SplitExperimentDatatype(s)
+	if err != nil {
+		return source{}, err
+	}
+	return source{experiment: experiment, datatype: datatype}, nil
+}
+
+func (s source) String() string {
+	return s.experiment + "/" + s.datatype
+}
+
+// table returns the fully qualified table for the source.
+func (s source) table(project, prefix string) string {
+	return fmt.Sprintf("%s.%s%s.%s", project, prefix, s.experiment, s.datatype)
+}
+
+// prefix returns the GCS object prefix for the source's archives on a date.
+func (s source) prefix(d civil.Date) string {
+	return fmt.Sprintf("%s/%s/%04d/%02d/%02d/", s.experiment, s.datatype, d.Year, d.Month, d.Day)
+}
+
+// freshness is the latest archive date, table date and parse time of a
+// source.  Zero values mean that none were found within the window.
+type freshness struct {
+	since       civil.Date // First date of the window.
+	archiveDate civil.Date
+	tableDate   civil.Date
+	parseTime   time.Time
+}
+
+// dateLag returns the number of days that the table date lags behind the
+// archive date.  An empty table lags from the start of the window, and a
+// source without archives does not lag.
+func (f freshness) dateLag() int {
+	if !f.archiveDate.IsValid() {
+		return 0
+	}
+	latest := f.tableDate
+	if !latest.IsValid() {
+		latest = f.since
+	}
+	if lag := f.archiveDate.DaysSince(latest); lag > 0 {
+		return lag
+	}
+	return 0
+}
+
+// parseLag returns the time from the latest parse time to now.  An empty
+// table lags from the start of the window.
+func (f freshness) parseLag(now time.Time) time.Duration {
+	latest := f.parseTime
+	if latest.IsZero() {
+		latest = f.since.In(time.UTC)
+	}
+	return now.Sub(latest)
+}
+
+// query returns the SQL for the latest date and parse time in a table, for
+// rows with dates from @since.
+func query(table string) string {
+	return fmt.Sprintf("SELECT\n  MAX(date) AS date,\n  MAX(parser.Time) AS parse_time\n"+
+		"FROM `%s`\nWHERE date >= @since", table)
+}
+
+// latestRow is the result row of query.
+type latestRow struct {
+	Date      bigquery.NullDate      `bigquery:"date"`
+	ParseTime bigquery.NullTimestamp `bigquery:"parse_time"`
+}
+
+// latestTable sets the table date and parse time of f from the query result.
+func latestTable(ctx context.Context, q *bigquery.Query, f *freshness) error {
+	it, err := q.Read(ctx)
+	if err != nil {
+		return err
+	}
+	var row latestRow
+	err = it.Next(&row)
+	if err == iterator.Done {
+		return errors.New("no result row")
+	}
+	if err != nil {
+		return err
+	}
+	if row.Date.Valid {
+		f.tableDate = row.Date.Date
+	}
+	if row.ParseTime.Valid {
+		f.parseTime = row.ParseTime.Timestamp
+	}
+	return nil
+}
+
+// latestArchive sets the archive date of f to the latest date, from today
+// back to the start of the window, with at least one archive.
+func latestArchive(ctx context.Context, client stiface.Client, bucket string, s source, today civil.Date, f *freshness) error {
+	for d := today; !d.Before(f.since); d = d.AddDays(-1) {
+		it := client.Bucket(bucket).Objects(ctx, &gcs.Query{Prefix: s.prefix(d)})
+		_, err := it.Next()
+		if err == iterator.Done {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		f.archiveDate = d
+		return nil
+	}
+	return nil
+}
+
+// check returns a description of each lag of f that exceeds its threshold.
+func check(s source, f freshness, now time.Time, maxDate int, maxParse time.Duration) []string {
+	var problems []string
+	if lag := f.dateLag(); lag > maxDate {
+		problems = append(problems, fmt.Sprintf("%s: date lag %d days (archive %s, table %s) exceeds %d",
+			s, lag, f.archiveDate, f.tableDate, maxDate))
+	}
+	if lag := f.parseLag(now); lag > maxParse {
+		problems = append(problems, fmt.Sprintf("%s: parse lag %v exceeds %v",
+			s, lag.Round(time.Second), maxParse))
+	}
+	return problems
+}
+
+func main() {
+	flag.Parse()
+	rtx.Must(flagx.ArgsFromEnv(flag.CommandLine), "Could not get args from env")
+	etl.Region = *region
+	if *project == "" || *bucket == "" {
+		log.Fatal("-project and -bucket are required")
+	}
+	sources := make([]source, 0, len(datatypes))
+	for _, dt := range datatypes {
+		s, err := parseSource(dt)
+		rtx.Must(err, "Invalid -datatype")
+		sources = append(sources, s)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	bq, err := etl.NewBigQueryClient(ctx, *project)
+	rtx.Must(err, "NewClient")
+	sc, err := storage.GetStorageClient(false)
+	rtx.Must(err, "GetStorageClient")
+
+	now := time.Now().UTC()
+	today := civil.DateOf(now)
+	since := today.AddDays(-*window)
+	var problems []string
+	for _, s := range sources {
+		f := freshness{since: since}
+		rtx.Must(latestArchive(ctx, sc, *bucket, s, today, &f), "Failed to list archives for %s", s)
+		q := bq.Query(query(s.table(*project, *datasetPrefix)))
+		q.Parameters = []bigquery.QueryParameter{{Name: "since", Value: since}}
+		rtx.Must(latestTable(ctx, q, &f), "Failed to query %s", s)
+
+		DateLag.WithLabelValues(s.String()).Set(float64(f.dateLag()))
+		ParseLag.WithLabelValues(s.String()).Set(f.parseLag(now).Seconds())
+		log.Printf("%s: archive %s, table %s, parsed %s", s, f.archiveDate, f.tableDate, f.parseTime)
+		problems = append(problems, check(s, f, now, *maxDateLag, *maxParseLag)...)
+	}
+
+	if *pushgateway != "" {
+		err := push.New(*pushgateway, "etl_freshness").Collector(DateLag).Collector(ParseLag).Push()
+		rtx.Must(err, "Failed to push metrics")
+	}
+	for _, p := range problems {
+		log.Println(p)
+	}
+	if len(problems) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/civil"
+
+	"github.com/m-lab/etl/etl"
+)
+
+func Test_parseSource(t *testing.T) {
+	s, err := parseSource("ndt/ndt7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.table("mlab-oti", "raw_"); got != "mlab-oti.raw_ndt.ndt7" {
+		t.Errorf("table() = %q", got)
+	}
+	if got := s.prefix(civil.Date{Year: 2022, Month: 7, Day: 1}); got != "ndt/ndt7/2022/07/01/" {
+		t.Errorf("prefix() = %q", got)
+	}
+	for _, bad := range []string{"ndt", "ndt/", "/ndt7", "ndt/ndt7/x"} {
+		if _, err := parseSource(bad); !errors.Is(err, etl.ErrBadExperimentDatatype) {
+			t.Errorf("parseSource(%q) error = %v, want %v", bad, err, etl.ErrBadExperimentDatatype)
+		}
+	}
+}
+
+func Test_query(t *testing.T) {
+	want := "SELECT\n" +
+		"  MAX(date) AS date,\n" +
+		"  MAX(parser.Time) AS parse_time\n" +
+		"FROM `p.d.t`\n" +
+		"WHERE date >= @since"
+	if got := query("p.d.t"); got != want {
+		t.Errorf("query() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func Test_check(t *testing.T) {
+	s := source{experiment: "ndt", datatype: "ndt7"}
+	now := time.Date(2022, 7, 10, 12, 0, 0, 0, time.UTC)
+	since := civil.Date{Year: 2022, Month: 6, Day: 10}
+	tests := []struct {
+		name      string
+		f         freshness
+		dateLag   int
+		parseLag  time.Duration
+		wantCount int
+	}{
+		{
+			name: "fresh",
+			f: freshness{since: since,
+				archiveDate: civil.Date{Year: 2022, Month: 7, Day: 10},
+				tableDate:   civil.Date{Year: 2022, Month: 7, Day: 9},
+				parseTime:   now.Add(-time.Hour)},
+			dateLag:  1,
+			parseLag: time.Hour,
+		},
+		{
+			name: "stale",
+			f: freshness{since: since,
+				archiveDate: civil.Date{Year: 2022, Month: 7, Day: 10},
+				tableDate:   civil.Date{Year: 2022, Month: 7, Day: 5},
+				parseTime:   now.Add(-72 * time.Hour)},
+			dateLag:   5,
+			parseLag:  72 * time.Hour,
+			wantCount: 2,
+		},
+		{
+			name: "empty-table",
+			f: freshness{since: since,
+				archiveDate: civil.Date{Year: 2022, Month: 7, Day: 10}},
+			dateLag:   30,
+			parseLag:  30*24*time.Hour + 12*time.Hour,
+			wantCount: 2,
+		},
+		{
+			name: "no-archives",
+			f: freshness{since: since,
+				tableDate: civil.Date{Year: 2022, Month: 7, Day: 5},
+				parseTime: now.Add(-time.Hour)},
+			parseLag: time.Hour,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.f.dateLag(); got != tt.dateLag {
+				t.Errorf("dateLag() = %d, want %d", got, tt.dateLag)
+			}
+			if got := tt.f.parseLag(now); got != tt.parseLag {
+				t.Errorf("parseLag() = %v, want %v", got, tt.parseLag)
+			}
+			if got := check(s, tt.f, now, 1, 48*time.Hour); len(got) != tt.wantCount {
+				t.Errorf("check() = %v, want %d problems", got, tt.wantCount)
+			}
+		})
+	}
+}
@@ -0,0 +1,356 @@
+// gen_testdata synthesizes small archives of switch, tcpinfo, ndt7 and
+// sidestream data with known values, plus the edge cases that production
+// archives occasionally contain: empty files, truncated gzip or zstd files,
+// corrupt lines, and files larger than the task's file size limit.  This lets
+// parser and storage tests cover failure modes without capturing production
+// data.
+//
+// Archives are written below -output using the same paths as the archive
+// bucket, e.g. <output>/ndt/ndt7/2022/07/01/20220701T000000.000000Z-ndt7-mlab1-foo01-ndt.tgz
+//
+// Example:
+//
+//	go run ./cmd/gen_testdata -output=/tmp/testdata -date=2022-07-01 -oversize=0
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"github.com/valyala/gozstd"
+
+	"github.com/m-lab/go/flagx"
+	"github.com/m-lab/go/rtx"
+
+	"github.com/m-lab/etl/task"
+)
+
+// Known values written to the generated tests, for tests to check against.
+const (
+	UUID         = "gen-testdata_1656633600_0000000000000001"
+	ServerIP     = "192.168.0.1"
+	ClientIP     = "10.0.0.1"
+	ServerPort   = 443
+	ClientPort   = 54321
+	BytesAcked   = 12500000 // 10 Mbps over ElapsedTime.
+	ElapsedTime  = 10000000 // usec
+	MinRTT       = 20000    // usec
+	SnapCount    = 3
+	SwitchOctets = 1000 // per 10 second sample.
+	SwitchCount  = 6    // samples per metric.
+)
+
+var (
+	output   = flag.String("output", ".", "Directory to write archives to")
+	date     = flag.String("date", "2022-07-01", "Archive date, as YYYY-MM-DD")
+	oversize = flag.Int64("oversize", task.DefaultMaxFileSize+1, "Size of the oversize file added to each archive, or 0 for none")
+)
+
+// file is an entry of a generated archive.
+type file struct {
+	name string
+	data []byte
+	size int64 // If data is nil, size zero bytes are written.
+}
+
+// archive describes a generated archive.
+type archive struct {
+	experiment string
+	datatype   string
+	name       string // File name, without the date, time and suffix.
+	files      []file
+}
+
+// path returns the path of the archive relative to the output directory.
+func (a archive) path(d civil.Date) string {
+	t := d.In(time.UTC)
+	return filepath.Join(a.experiment, a.datatype, t.Format("2006/01/02"),
+		t.Format("20060102T150405.000000Z")+"-"+a.name+".tgz")
+}
+
+// write writes the archive as a gzipped tar file.
+func (a archive) write(w io.Writer, d civil.Date) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	mtime := d.In(time.UTC)
+	for _, f := range a.files {
+		size := f.size
+		if f.data != nil {
+			size = int64(len(f.data))
+		}
+		h := &tar.Header{Name: f.name, Mode: 0644, Size: size, ModTime: mtime, Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(h); err != nil {
+			return err
+		}
+		var err error
+		if f.data != nil {
+			_, err = tw.Write(f.data)
+		} else {
+			_, err = io.CopyN(tw, zeros{}, size)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// zeros is an endless source of zero bytes.
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// gz returns the gzipped data.
+func gz(data []byte) []byte {
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+// truncate returns the first half of the data, e.g. of a compressed file.
+func truncate(data []byte) []byte {
+	return data[:len(data)/2]
+}
+
+// jsonl returns the JSON encoding of each value, one per line.
+func jsonl(values ...interface{}) []byte {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	for _, v := range values {
+		rtx.Must(enc.Encode(v), "Could not encode %v", v)
+	}
+	return buf.Bytes()
+}
+
+// switchArchive returns a DISCOv2 archive with SwitchCount samples of the
+// uplink octet counters, every 10 seconds from the start of the date.
+func switchArchive(d civil.Date) archive {
+	start := d.In(time.UTC).Unix()
+	record := func(metric string) map[string]interface{} {
+		samples := []map[string]interface{}{}
+		for i := 0; i < SwitchCount; i++ {
+			ts := start + int64(10*i)
+			samples = append(samples, map[string]interface{}{
+				"timestamp":    ts,
+				"collectstart": ts * 1e9,
+				"collectend":   ts*1e9 + 1e7,
+				"value":        SwitchOctets,
+				"counter":      SwitchOctets * (i + 1),
+			})
+		}
+		return map[string]interface{}{
+			"experiment": "s1-foo01.measurement-lab.org",
+			"hostname":   "mlab1-foo01.mlab-sandbox.measurement-lab.org",
+			"metric":     metric,
+			"sample":     samples,
+		}
+	}
+	data := jsonl(record("switch.octets.uplink.rx"), record("switch.octets.uplink.tx"))
+	prefix := d.In(time.UTC).Format("20060102T150405Z")
+	return archive{
+		experiment: "utilization",
+		datatype:   "switch",
+		name:       "switch-mlab1-foo01-utilization",
+		files: []file{
+			{name: prefix + "-mlab1-foo01-switch.jsonl", data: data},
+			{name: prefix + "-mlab2-foo01-switch.jsonl", data: []byte{}},
+			{name: prefix + "-mlab3-foo01-switch.jsonl.gz", data: truncate(gz(data))},
+			{name: prefix + "-mlab4-foo01-switch.jsonl", data: []byte("{\"experiment\": \"s1-foo01")},
+		},
+	}
+}
+
+// ndt7Result returns an ndt7 download result, with one server measurement
+// at the end of the test.
+func ndt7Result(d civil.Date) []byte {
+	start := d.In(time.UTC).Add(time.Minute)
+	end := start.Add(ElapsedTime * time.Microsecond)
+	return jsonl(map[string]interface{}{
+		"GitShortCommit": "0000000",
+		"Version":        "gen_testdata",
+		"ServerIP":       ServerIP,
+		"ServerPort":     ServerPort,
+		"ClientIP":       ClientIP,
+		"ClientPort":     ClientPort,
+		"StartTime":      start,
+		"EndTime":        end,
+		"Download": map[string]interface{}{
+			"UUID":      UUID,
+			"StartTime": start,
+			"EndTime":   end,
+			"ServerMeasurements": []map[string]interface{}{{
+				"TCPInfo": map[string]interface{}{
+					"BytesAcked":   BytesAcked,
+					"BytesSent":    BytesAcked,
+					"BytesRetrans": 0,
+					"MinRTT":       MinRTT,
+					"ElapsedTime":  ElapsedTime,
+				},
+			}},
+		},
+	})
+}
+
+// ndt7Archive returns an archive with a download result, and empty and
+// truncated results.
+func ndt7Archive(d civil.Date) archive {
+	data := ndt7Result(d)
+	name := "ndt7-download-" + d.In(time.UTC).Add(time.Minute).Format("20060102T150405.000000000Z") + "." + UUID
+	dir := d.In(time.UTC).Format("2006/01/02/")
+	return archive{
+		experiment: "ndt",
+		datatype:   "ndt7",
+		name:       "ndt7-mlab1-foo01-ndt",
+		files: []file{
+			{name: dir + name + ".json", data: data},
+			{name: dir + "empty-" + name + ".json", data: []byte{}},
+			{name: dir + "truncated-" + name + ".json.gz", data: truncate(gz(data))},
+		},
+	}
+}
+
+// inetDiagMsg returns a raw inet_diag_msg for the test's IPv4 connection.
+func inetDiagMsg() []byte {
+	b := make([]byte, 72)
+	b[0] = 2 // AF_INET
+	b[1] = 1 // TCP_ESTABLISHED
+	binary.BigEndian.PutUint16(b[4:], ServerPort)
+	binary.BigEndian.PutUint16(b[6:], ClientPort)
+	copy(b[8:], []byte{192, 168, 0, 1})
+	copy(b[24:], []byte{10, 0, 0, 1})
+	return b
+}
+
+// tcpInfo returns a raw tcp_info with the given bytes acked.
+func tcpInfo(acked uint64) []byte {
+	b := make([]byte, 224)
+	b[0] = 1                                      // state
+	binary.LittleEndian.PutUint32(b[68:], MinRTT) // rtt
+	binary.LittleEndian.PutUint32(b[80:], 10)     // snd_cwnd
+	binary.LittleEndian.PutUint64(b[120:], acked) // bytes_acked
+	return b
+}
+
+// tcpinfoRecords returns the ArchivalRecords of a connection with SnapCount
+// snapshots, one second apart, the last of which has BytesAcked.
+func tcpinfoRecords(d civil.Date) []byte {
+	start := d.In(time.UTC).Add(time.Minute)
+	records := []interface{}{
+		map[string]interface{}{
+			"Timestamp": time.Time{},
+			"Metadata":  map[string]interface{}{"UUID": UUID, "Sequence": 0, "StartTime": start},
+		},
+	}
+	for i := 1; i <= SnapCount; i++ {
+		attrs := make([][]byte, 9)
+		attrs[2] = tcpInfo(uint64(BytesAcked * i / SnapCount)) // INET_DIAG_INFO
+		records = append(records, map[string]interface{}{
+			"Timestamp":  start.Add(time.Duration(i) * time.Second),
+			"RawIDM":     inetDiagMsg(),
+			"Attributes": attrs,
+		})
+	}
+	return jsonl(records...)
+}
+
+// tcpinfoArchive returns an archive with a connection, a connection with no
+// snapshots, and empty and truncated files.
+func tcpinfoArchive(d civil.Date) archive {
+	data := tcpinfoRecords(d)
+	metaOnly := data[:bytes.IndexByte(data, '\n')+1]
+	dir := d.In(time.UTC).Format("2006/01/02/")
+	return archive{
+		experiment: "ndt",
+		datatype:   "tcpinfo",
+		name:       "tcpinfo-mlab1-foo01-ndt",
+		files: []file{
+			{name: dir + UUID + ".00000.jsonl.zst", data: gozstd.Compress(nil, data)},
+			{name: dir + UUID + ".00001.jsonl.zst", data: gozstd.Compress(nil, metaOnly)},
+			{name: dir + UUID + ".00002.jsonl.zst", data: []byte{}},
+			{name: dir + UUID + ".00003.jsonl.zst", data: truncate(gozstd.Compress(nil, data))},
+		},
+	}
+}
+
+// sidestreamVars are the web100 variables written to sidestream files.
+var sidestreamVars = []string{
+	"cid", "PollTime", "LocalAddress", "LocalPort", "RemAddress", "RemPort",
+	"State", "StartTimeSec", "StartTimeUsec", "Duration", "ThruBytesAcked",
+	"MinRTT", "CurMSS",
+}
+
+// sidestreamArchive returns an archive with a web100 file of SnapCount
+// connections and one corrupt line, and an empty web100 file.
+func sidestreamArchive(d civil.Date) archive {
+	start := d.In(time.UTC).Add(time.Minute)
+	lines := []string{"K: " + strings.Join(sidestreamVars, " ")}
+	for i := 1; i <= SnapCount; i++ {
+		lines = append(lines, fmt.Sprintf("C: %d %s %s %d %s %d 1 %d 0 %d %d %d 1460",
+			i, start.Format("2006-01-02-15:04:05Z"), ServerIP, ServerPort, ClientIP, ClientPort+i,
+			start.Unix(), ElapsedTime, BytesAcked, MinRTT/1000))
+	}
+	lines = append(lines, "C: 0 corrupt")
+	name := start.Format("20060102T15:04:05Z")
+	return archive{
+		experiment: "sidestream",
+		datatype:   "sidestream",
+		name:       "mlab1-foo01-sidestream-0000",
+		files: []file{
+			{name: name + "_ALL0.web100", data: []byte(strings.Join(lines, "\n") + "\n")},
+			{name: name + "_ALL1.web100", data: []byte{}},
+		},
+	}
+}
+
+// archives returns all generated archives for the date.  If oversize is
+// positive, each archive also contains a file of that many bytes.
+func archives(d civil.Date, oversize int64) []archive {
+	all := []archive{switchArchive(d), ndt7Archive(d), tcpinfoArchive(d), sidestreamArchive(d)}
+	if oversize > 0 {
+		for i := range all {
+			name := all[i].files[0].name
+			all[i].files = append(all[i].files, file{name: "oversize-" + filepath.Base(name), size: oversize})
+		}
+	}
+	return all
+}
+
+func main() {
+	flag.Parse()
+	rtx.Must(flagx.ArgsFromEnv(flag.CommandLine), "Could not get args from env")
+
+	d, err := civil.ParseDate(*date)
+	rtx.Must(err, "Invalid -date %q", *date)
+
+	for _, a := range archives(d, *oversize) {
+		p := filepath.Join(*output, a.path(d))
+		rtx.Must(os.MkdirAll(filepath.Dir(p), 0755), "Could not create directory for %s", p)
+		f, err := os.Create(p)
+		rtx.Must(err, "Could not create %s", p)
+		rtx.Must(a.write(f, d), "Could not write %s", p)
+		rtx.Must(f.Close(), "Could not close %s", p)
+		log.Println("Wrote", p)
+	}
+}
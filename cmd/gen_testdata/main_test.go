@@ -0,0 +1,96 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/civil"
+	"github.com/valyala/gozstd"
+
+	"github.com/m-lab/etl/etl"
+)
+
+// readArchive returns the contents of each file in the archive, by name.
+func readArchive(t *testing.T, a archive, d civil.Date) map[string][]byte {
+	buf := &bytes.Buffer{}
+	if err := a.write(buf, d); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(zr)
+	files := map[string][]byte{}
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[h.Name] = data
+	}
+	return files
+}
+
+func Test_archives(t *testing.T) {
+	d := civil.Date{Year: 2022, Month: 7, Day: 1}
+	for _, a := range archives(d, 1<<20) {
+		t.Run(a.datatype, func(t *testing.T) {
+			dp, err := etl.ValidateTestPath("gs://archive-mlab-sandbox/" + a.path(d))
+			if err != nil {
+				t.Fatal("path() is not a valid archive path:", a.path(d), err)
+			}
+			if dp.GetDataType() == etl.INVALID {
+				t.Error("path() has unknown datatype:", a.path(d))
+			}
+
+			files := readArchive(t, a, d)
+			if len(files) != len(a.files) {
+				t.Errorf("archive has %d files, want %d", len(files), len(a.files))
+			}
+			for name, data := range files {
+				switch {
+				case strings.HasPrefix(name, "oversize-"):
+					if len(data) != 1<<20 {
+						t.Errorf("%s has %d bytes, want %d", name, len(data), 1<<20)
+					}
+				case strings.HasPrefix(name, "truncated-") || strings.HasSuffix(name, ".gz"):
+					zr, err := gzip.NewReader(bytes.NewReader(data))
+					if err == nil {
+						_, err = ioutil.ReadAll(zr)
+					}
+					if err == nil {
+						t.Errorf("%s should be truncated", name)
+					}
+				}
+			}
+		})
+	}
+}
+
+func Test_tcpinfoArchive(t *testing.T) {
+	d := civil.Date{Year: 2022, Month: 7, Day: 1}
+	files := readArchive(t, tcpinfoArchive(d), d)
+	data, err := gozstd.Decompress(nil, files["2022/07/01/"+UUID+".00000.jsonl.zst"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(data, []byte("\n")); lines != SnapCount+1 {
+		t.Errorf("connection has %d records, want %d", lines, SnapCount+1)
+	}
+	if _, err := gozstd.Decompress(nil, files["2022/07/01/"+UUID+".00003.jsonl.zst"]); err == nil {
+		t.Error("truncated file should not decompress")
+	}
+}
@@ -158,6 +158,10 @@ func main() {
 		&schema.PTTest{},
 		&schema.PCAPRow{},
 		&schema.Scamper1Row{},
+		&schema.SSRow{},
+		&schema.UUIDMapRow{},
+		&schema.DiffRow{},
+		&schema.FileStatsRow{},
 		// TODO(https://github.com/m-lab/etl/issues/745): Add additional types once
 		// "standard columns" are resolved.
 	}
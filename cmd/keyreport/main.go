@@ -0,0 +1,177 @@
+// keyreport reports how many rows of a table would survive deduplication
+// under each of several candidate keys, to help choose the key for a datatype,
+// e.g. when migrating to a new schema.  A key that leaves more rows than
+// another distinguishes rows that the other would merge.
+//
+// Each -key is a column, or several columns joined with "+".  The flag may be
+// repeated, or given a comma separated list.
+//
+// Example:
+//
+//	go run ./cmd/keyreport -table=mlab-sandbox.raw_ndt.ndt7 \
+//	    -start=2022-07-01 -end=2022-07-04 \
+//	    -key=id -key=id+date -key=raw.Download.UUID
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+	"google.golang.org/api/iterator"
+
+	"github.com/m-lab/go/cloud/bqx"
+	"github.com/m-lab/go/flagx"
+	"github.com/m-lab/go/rtx"
+
+	"github.com/m-lab/etl/etl"
+)
+
+var (
+	table   = flag.String("table", "", "Fully qualified project.dataset.table to report on")
+	start   = flag.String("start", "", "First date to include, as YYYY-MM-DD")
+	end     = flag.String("end", "", "Last date to include, as YYYY-MM-DD. Default is the start date")
+	approx  = flag.Bool("approx", false, "Use approximate distinct counts, which are much cheaper for large ranges")
+	timeout = flag.Duration("timeout", time.Hour, "Timeout for the query")
+	region  = flag.String("region", "", "Run BigQuery jobs in this location, e.g. 'europe-west1', as required for datasets outside the US")
+	keys    flagx.StringArray
+)
+
+func init() {
+	flag.Var(&keys, "key", "Candidate key: a column, or columns joined with '+'. May be repeated")
+}
+
+// ErrNoKeys is returned when no candidate keys are given.
+var ErrNoKeys = errors.New("no candidate keys")
+
+// keyCount is the number of rows that would survive dedup under a key.
+type keyCount struct {
+	key      string
+	distinct int64
+}
+
+// keyExpr returns the SQL expression for a key, combining multiple columns
+// into a single comparable value.
+func keyExpr(key string) string {
+	cols := strings.Split(key, "+")
+	for i := range cols {
+		cols[i] = strings.TrimSpace(cols[i])
+	}
+	if len(cols) == 1 {
+		return "TO_JSON_STRING(" + cols[0] + ")"
+	}
+	return "TO_JSON_STRING(STRUCT(" + strings.Join(cols, ", ") + "))"
+}
+
+// query returns the SQL that counts all rows, and the distinct values of
+// each key, for rows with dates between @start and @end.
+func query(table string, keys []string, approx bool) (string, error) {
+	if len(keys) == 0 {
+		return "", ErrNoKeys
+	}
+	count := "COUNT(DISTINCT %s)"
+	if approx {
+		count = "APPROX_COUNT_DISTINCT(%s)"
+	}
+	cols := []string{"COUNT(*) AS total"}
+	for i, k := range keys {
+		cols = append(cols, fmt.Sprintf(count+" AS key%d", keyExpr(k), i))
+	}
+	return fmt.Sprintf("SELECT\n  %s\nFROM `%s`\nWHERE date BETWEEN @start AND @end",
+		strings.Join(cols, ",\n  "), table), nil
+}
+
+// report runs the query and returns the total row count, and the distinct
+// count for each key.
+func report(ctx context.Context, q *bigquery.Query, keys []string) (int64, []keyCount, error) {
+	it, err := q.Read(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	var row []bigquery.Value
+	err = it.Next(&row)
+	if err == iterator.Done {
+		return 0, nil, errors.New("no result row")
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	return counts(row, keys)
+}
+
+// counts converts the result row into the total and per-key counts.
+func counts(row []bigquery.Value, keys []string) (int64, []keyCount, error) {
+	if len(row) != len(keys)+1 {
+		return 0, nil, fmt.Errorf("got %d columns, want %d", len(row), len(keys)+1)
+	}
+	total, ok := row[0].(int64)
+	if !ok {
+		return 0, nil, fmt.Errorf("unexpected total %v", row[0])
+	}
+	result := make([]keyCount, len(keys))
+	for i, k := range keys {
+		n, ok := row[i+1].(int64)
+		if !ok {
+			return 0, nil, fmt.Errorf("unexpected count %v for key %s", row[i+1], k)
+		}
+		result[i] = keyCount{key: k, distinct: n}
+	}
+	return total, result, nil
+}
+
+// write prints the report as a table.
+func write(w io.Writer, total int64, result []keyCount) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "key\tsurviving rows\tdropped rows\tsurviving\t\n")
+	for _, r := range result {
+		pct := 0.0
+		if total > 0 {
+			pct = 100 * float64(r.distinct) / float64(total)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.2f%%\t\n", r.key, r.distinct, total-r.distinct, pct)
+	}
+	fmt.Fprintf(tw, "(all rows)\t%d\t\t\t\n", total)
+	return tw.Flush()
+}
+
+func main() {
+	flag.Parse()
+	rtx.Must(flagx.ArgsFromEnv(flag.CommandLine), "Could not get args from env")
+	etl.Region = *region
+
+	pdt, err := bqx.ParsePDT(*table)
+	rtx.Must(err, "Invalid -table %q", *table)
+	first, err := civil.ParseDate(*start)
+	rtx.Must(err, "Invalid -start %q", *start)
+	last := first
+	if *end != "" {
+		last, err = civil.ParseDate(*end)
+		rtx.Must(err, "Invalid -end %q", *end)
+	}
+	sql, err := query(*table, keys, *approx)
+	rtx.Must(err, "Invalid -key")
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	client, err := etl.NewBigQueryClient(ctx, pdt.Project)
+	rtx.Must(err, "NewClient")
+
+	q := client.Query(sql)
+	q.Parameters = []bigquery.QueryParameter{
+		{Name: "start", Value: first},
+		{Name: "end", Value: last},
+	}
+	total, result, err := report(ctx, q, keys)
+	rtx.Must(err, "Failed to query %s", *table)
+	log.Printf("%s from %s to %s", *table, first, last)
+	rtx.Must(write(os.Stdout, total, result), "Failed to write report")
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/go-test/deep"
+)
+
+func Test_query(t *testing.T) {
+	got, err := query("p.d.t", []string{"id", "id+date", "raw.Download.UUID"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "SELECT\n" +
+		"  COUNT(*) AS total,\n" +
+		"  COUNT(DISTINCT TO_JSON_STRING(id)) AS key0,\n" +
+		"  COUNT(DISTINCT TO_JSON_STRING(STRUCT(id, date))) AS key1,\n" +
+		"  COUNT(DISTINCT TO_JSON_STRING(raw.Download.UUID)) AS key2\n" +
+		"FROM `p.d.t`\n" +
+		"WHERE date BETWEEN @start AND @end"
+	if got != want {
+		t.Errorf("query() =\n%s\nwant\n%s", got, want)
+	}
+
+	got, _ = query("p.d.t", []string{"test_id"}, true)
+	if !strings.Contains(got, "APPROX_COUNT_DISTINCT(TO_JSON_STRING(test_id)) AS key0") {
+		t.Errorf("query() with approx = %s", got)
+	}
+	if _, err := query("p.d.t", nil, false); err != ErrNoKeys {
+		t.Errorf("query() error = %v, want %v", err, ErrNoKeys)
+	}
+}
+
+func Test_counts(t *testing.T) {
+	keys := []string{"id", "id+date"}
+	total, result, err := counts([]bigquery.Value{int64(100), int64(90), int64(100)}, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	if err := write(buf, total, result); err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		got = append(got, strings.Join(strings.Fields(line), " "))
+	}
+	want := []string{
+		"key surviving rows dropped rows surviving",
+		"id 90 10 90.00%",
+		"id+date 100 0 100.00%",
+		"(all rows) 100",
+	}
+	if diff := deep.Equal(got, want); diff != nil {
+		t.Errorf("write() = \n%s\n%v", buf.String(), diff)
+	}
+
+	if _, _, err := counts([]bigquery.Value{int64(100)}, keys); err == nil {
+		t.Error("counts() expected error for missing columns")
+	}
+	if _, _, err := counts([]bigquery.Value{int64(100), "x", int64(1)}, keys); err == nil {
+		t.Error("counts() expected error for bad count")
+	}
+}
@@ -0,0 +1,166 @@
+// load-avro loads the Avro objects written by etl_worker -output=avro into the
+// date partitions of the datatype's table.  It is intended to run once per
+// date, like update-summaries, after the parsers have completed the date.
+//
+// Each partition is replaced by a single BigQuery load job with WriteTruncate
+// of all the Avro objects of its date, so that rerunning the command for a
+// date, e.g. after reprocessing, is idempotent.  The parsers never load or
+// truncate partitions themselves, since concurrent per-task loads would race,
+// and reload each date's objects once per task.
+//
+// Objects are read from <avro_bucket>/<archive_bucket>/<experiment>/<datatype>/YYYY/MM/DD/*.avro,
+// the layout written by the Avro sinks.
+//
+// Example:
+//
+//	go run ./cmd/load-avro -project=mlab-sandbox -environment=sandbox \
+//	    -avro_bucket=etl-avro-mlab-sandbox -archive_bucket=archive-measurement-lab \
+//	    -datatype=ndt/ndt7 -start=2022-07-01 -end=2022-07-04
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"cloud.google.com/go/datastore"
+
+	"github.com/m-lab/go/flagx"
+	"github.com/m-lab/go/rtx"
+
+	"github.com/m-lab/etl/etl"
+	"github.com/m-lab/etl/storage"
+	"github.com/m-lab/etl/worker"
+)
+
+var (
+	project         = flag.String("project", "", "Project that runs the load jobs")
+	avroBucket      = flag.String("avro_bucket", "", "GCS bucket of the Avro objects, the -output_location of the parsers")
+	archiveBucket   = flag.String("archive_bucket", "", "Archive bucket of the parsed archives, the first directory of the Avro object names")
+	start           = flag.String("start", "", "First date to load, as YYYY-MM-DD. Default is yesterday")
+	end             = flag.String("end", "", "Last date to load, as YYYY-MM-DD. Default is the start date")
+	environment     = flag.String("environment", "", "Select the destination tables of this environment, as for etl_worker")
+	bigqueryProject = flag.String("bigquery_project", "", "Override the project of the destination tables")
+	bigqueryDataset = flag.String("bigquery_dataset", "", "Override the dataset of the destination tables")
+	timeout         = flag.Duration("timeout", 30*time.Minute, "Timeout for all loads")
+	region          = flag.String("region", "", "Run BigQuery jobs in this location, e.g. 'europe-west1', as required for datasets outside the US")
+	budgetProject   = flag.String("budget_datastore_project", "", "Datastore project of the BigQuery job budget shared with the parsers. Required with -budget_limit")
+	budgetLimit     = flag.Int("budget_limit", 0, "If positive, run each load with one of the first -budget_limit tokens of the fleet-wide -budget_datastore_project budget, which all parsers and jobs using the budget share. 0 disables the budget")
+	datatypes       flagx.StringArray
+)
+
+func init() {
+	flag.Var(&datatypes, "datatype", "Datatype to load, as experiment/datatype. May be repeated")
+}
+
+// partition is the Avro objects of a datatype and date, and the partition
+// they replace.
+type partition struct {
+	uri  string
+	dst  etl.Destination
+	date civil.Date
+}
+
+func (p partition) String() string {
+	return p.dst.String() + etl.PartitionSuffix.Suffix(p.date) + " from " + p.uri
+}
+
+// partitions returns the partition of each datatype, given as
+// experiment/datatype, for each date from first to last.
+func partitions(avroBucket, archiveBucket string, dts []string, first, last civil.Date) ([]partition, error) {
+	var ps []partition
+	for _, s := range dts {
+		experiment, dt, err := etl.SplitExperimentDatatype(s)
+		if err != nil {
+			return nil, err
+		}
+		dst := etl.DataType(dt).Destination()
+		for d := first; !d.After(last); d = d.AddDays(1) {
+			uri := fmt.Sprintf("gs://%s/%s/%s/%s/%04d/%02d/%02d/*%s",
+				avroBucket, archiveBucket, experiment, dt, d.Year, d.Month, d.Day, storage.AvroExt)
+			ps = append(ps, partition{uri: uri, dst: dst, date: d})
+		}
+	}
+	return ps, nil
+}
+
+// loadAll loads each partition while holding a token of the budget, and
+// returns the number of failed loads.
+func loadAll(ctx context.Context, loader storage.PartitionLoader, budget *worker.Budget, ps []partition) int {
+	failed := 0
+	for _, p := range ps {
+		release, err := budget.Acquire(ctx)
+		if err != nil {
+			log.Printf("Failed to load %s: %v", p, err)
+			failed++
+			continue
+		}
+		err = loader.Load(ctx, p.uri, p.dst, p.date)
+		release()
+		if err != nil {
+			log.Printf("Failed to load %s: %v", p, err)
+			failed++
+			continue
+		}
+		log.Println("Loaded", p)
+	}
+	return failed
+}
+
+func main() {
+	flag.Parse()
+	rtx.Must(flagx.ArgsFromEnv(flag.CommandLine), "Could not get args from env")
+	etl.Region = *region
+	rtx.Must(etl.ValidateEnvironment(*environment), "Invalid -environment")
+	etl.Environment = *environment
+	etl.BigqueryProject = *bigqueryProject
+	etl.BigqueryDataset = *bigqueryDataset
+	if *project == "" || *avroBucket == "" || *archiveBucket == "" {
+		log.Fatal("-project, -avro_bucket and -archive_bucket are required")
+	}
+	if len(datatypes) == 0 {
+		log.Fatal("At least one -datatype is required")
+	}
+	first := civil.DateOf(time.Now().UTC()).AddDays(-1)
+	var err error
+	if *start != "" {
+		first, err = civil.ParseDate(*start)
+		rtx.Must(err, "Invalid -start %q", *start)
+	}
+	last := first
+	if *end != "" {
+		last, err = civil.ParseDate(*end)
+		rtx.Must(err, "Invalid -end %q", *end)
+	}
+	ps, err := partitions(*avroBucket, *archiveBucket, datatypes, first, last)
+	rtx.Must(err, "Invalid -datatype")
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	client, err := etl.NewBigQueryClient(ctx, *project)
+	rtx.Must(err, "Failed to create BigQuery client")
+
+	var budget *worker.Budget
+	if *budgetLimit < 0 {
+		log.Fatal("-budget_limit must not be negative")
+	}
+	if *budgetLimit > 0 {
+		if *budgetProject == "" {
+			log.Fatal("-budget_datastore_project is required with -budget_limit")
+		}
+		ds, err := datastore.NewClient(ctx, *budgetProject)
+		rtx.Must(err, "Failed to create datastore client")
+		host, err := os.Hostname()
+		rtx.Must(err, "Failed to get hostname")
+		holder := fmt.Sprintf("load-avro-%s-%d", host, os.Getpid())
+		budget = worker.NewBudget(worker.NewDatastoreLeaseStore(ds, "etl"), "bigquery", holder, *budgetLimit, time.Minute)
+	}
+
+	if failed := loadAll(ctx, storage.NewPartitionLoader(client), budget, ps); failed > 0 {
+		log.Fatalf("%d of %d loads failed", failed, len(ps))
+	}
+}
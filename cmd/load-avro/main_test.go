@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"cloud.google.com/go/civil"
+	"github.com/go-test/deep"
+
+	"github.com/m-lab/etl/etl"
+)
+
+func Test_partitions(t *testing.T) {
+	first := civil.Date{Year: 2022, Month: 6, Day: 30}
+	last := civil.Date{Year: 2022, Month: 7, Day: 1}
+	ps, err := partitions("avro", "archive", []string{"ndt/ndt7", "host/scamper1"}, first, last)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, p := range ps {
+		got = append(got, p.uri)
+	}
+	want := []string{
+		"gs://avro/archive/ndt/ndt7/2022/06/30/*.avro",
+		"gs://avro/archive/ndt/ndt7/2022/07/01/*.avro",
+		"gs://avro/archive/host/scamper1/2022/06/30/*.avro",
+		"gs://avro/archive/host/scamper1/2022/07/01/*.avro",
+	}
+	if diff := deep.Equal(got, want); diff != nil {
+		t.Errorf("partitions() = %v, diff %v", got, diff)
+	}
+	if ps[0].dst != etl.NDT7.Destination() || ps[0].date != first {
+		t.Errorf("partitions()[0] = %v, want %v on %v", ps[0], etl.NDT7.Destination(), first)
+	}
+	if _, err := partitions("avro", "archive", []string{"ndt7"}, first, last); !errors.Is(err, etl.ErrBadExperimentDatatype) {
+		t.Errorf("partitions(ndt7) = %v, want %v", err, etl.ErrBadExperimentDatatype)
+	}
+}
+
+type fakeLoader struct {
+	fail   string
+	loaded []string
+}
+
+func (f *fakeLoader) Load(ctx context.Context, uri string, dst etl.Destination, date civil.Date) error {
+	if uri == f.fail {
+		return errors.New("load failed")
+	}
+	f.loaded = append(f.loaded, uri)
+	return nil
+}
+
+func Test_loadAll(t *testing.T) {
+	day := civil.Date{Year: 2022, Month: 7, Day: 1}
+	ps, err := partitions("avro", "archive", []string{"ndt/ndt7", "ndt/ndt5"}, day, day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeLoader{fail: ps[0].uri}
+	if failed := loadAll(context.Background(), f, nil, ps); failed != 1 {
+		t.Errorf("loadAll() = %d failed, want 1", failed)
+	}
+	if diff := deep.Equal(f.loaded, []string{ps[1].uri}); diff != nil {
+		t.Errorf("loadAll() loaded %v, diff %v", f.loaded, diff)
+	}
+}
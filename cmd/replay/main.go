@@ -0,0 +1,161 @@
+// replay queries a table of failed tasks for archives matching a date range,
+// datatype, and error class, and re-enqueues exactly those archives by
+// submitting them to an etl_worker's /v2/worker endpoint.
+//
+// The failures table must have at least the columns:
+//
+//	date        DATE    - date of the archive.
+//	archive_url STRING  - GCS URL of the archive, e.g. gs://bucket/path.tgz
+//	datatype    STRING  - datatype of the archive, e.g. ndt7.
+//	error_class STRING  - short error classification, e.g. TaskError.
+//
+// Example:
+//
+//	go run ./cmd/replay -failures_table=mlab-sandbox.tmp_ndt.parse_errors \
+//	    -start=2022-07-01 -end=2022-07-04 -datatype=ndt7 \
+//	    -worker=http://localhost:8080 -dry_run
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+	"google.golang.org/api/iterator"
+
+	"github.com/m-lab/go/cloud/bqx"
+	"github.com/m-lab/go/flagx"
+	"github.com/m-lab/go/rtx"
+
+	"github.com/m-lab/etl/etl"
+)
+
+var (
+	failuresTable = flag.String("failures_table", "", "Fully qualified project.dataset.table of failed tasks")
+	start         = flag.String("start", "", "First archive date to replay, as YYYY-MM-DD")
+	end           = flag.String("end", "", "Last archive date to replay, as YYYY-MM-DD. Default is the start date")
+	datatype      = flag.String("datatype", "", "Only replay archives of this datatype. Default is all datatypes")
+	errorClass    = flag.String("error_class", "", "Only replay archives that failed with this error class. Default is all errors")
+	workerAddr    = flag.String("worker", "http://localhost:8080", "Base URL of the etl_worker that processes replayed archives")
+	dryRun        = flag.Bool("dry_run", false, "List the matching archives without re-enqueuing them")
+	timeout       = flag.Duration("timeout", time.Hour, "Timeout for the query and all replays")
+	region        = flag.String("region", "", "Run BigQuery jobs in this location, e.g. 'europe-west1', as required for datasets outside the US")
+)
+
+// filter selects the failures to replay.
+type filter struct {
+	start, end civil.Date
+	datatype   string
+	errorClass string
+}
+
+// query returns the query for the distinct archives matching the filter.
+func (f filter) query(client *bigquery.Client, table string) *bigquery.Query {
+	q := client.Query(fmt.Sprintf(`
+SELECT DISTINCT archive_url
+FROM `+"`%s`"+`
+WHERE date BETWEEN @start AND @end
+  AND (@datatype = "" OR datatype = @datatype)
+  AND (@error_class = "" OR error_class = @error_class)
+ORDER BY archive_url`, table))
+	q.Parameters = []bigquery.QueryParameter{
+		{Name: "start", Value: f.start},
+		{Name: "end", Value: f.end},
+		{Name: "datatype", Value: f.datatype},
+		{Name: "error_class", Value: f.errorClass},
+	}
+	return q
+}
+
+// archives runs the query and returns the archive URLs.
+func archives(ctx context.Context, q *bigquery.Query) ([]string, error) {
+	it, err := q.Read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	urls := []string{}
+	for {
+		var row struct {
+			ArchiveURL string `bigquery:"archive_url"`
+		}
+		err := it.Next(&row)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, row.ArchiveURL)
+	}
+	return urls, nil
+}
+
+// enqueue submits the archive to the worker for processing, and waits for
+// the worker to complete it.
+func enqueue(ctx context.Context, client *http.Client, worker, archive string) error {
+	u := strings.TrimSuffix(worker, "/") + "/v2/worker?" +
+		url.Values{"filename": []string{archive}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", archive, resp.Status)
+	}
+	return nil
+}
+
+func main() {
+	flag.Parse()
+	rtx.Must(flagx.ArgsFromEnv(flag.CommandLine), "Could not get args from env")
+	etl.Region = *region
+
+	pdt, err := bqx.ParsePDT(*failuresTable)
+	rtx.Must(err, "Invalid -failures_table %q", *failuresTable)
+	first, err := civil.ParseDate(*start)
+	rtx.Must(err, "Invalid -start %q", *start)
+	last := first
+	if *end != "" {
+		last, err = civil.ParseDate(*end)
+		rtx.Must(err, "Invalid -end %q", *end)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	client, err := etl.NewBigQueryClient(ctx, pdt.Project)
+	rtx.Must(err, "NewClient")
+
+	f := filter{start: first, end: last, datatype: *datatype, errorClass: *errorClass}
+	urls, err := archives(ctx, f.query(client, *failuresTable))
+	rtx.Must(err, "Failed to query failures")
+	log.Printf("Found %d archives to replay", len(urls))
+
+	failed := 0
+	for _, u := range urls {
+		if *dryRun {
+			fmt.Println(u)
+			continue
+		}
+		if err := enqueue(ctx, http.DefaultClient, *workerAddr, u); err != nil {
+			log.Println("Replay failed:", err)
+			failed++
+			continue
+		}
+		log.Println("Replayed", u)
+	}
+	if failed != 0 {
+		log.Fatalf("%d of %d replays failed", failed, len(urls))
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_enqueue(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/v2/worker" {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		got = req.FormValue("filename")
+		if got == "gs://bucket/bad.tgz" {
+			rw.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	archive := "gs://bucket/ndt/ndt7/2022/07/01/20220701T000000.000000Z-ndt7-mlab1-foo01-ndt.tgz"
+	if err := enqueue(ctx, srv.Client(), srv.URL+"/", archive); err != nil {
+		t.Fatal(err)
+	}
+	if got != archive {
+		t.Errorf("enqueue() sent filename %q, want %q", got, archive)
+	}
+	if err := enqueue(ctx, srv.Client(), srv.URL, "gs://bucket/bad.tgz"); err == nil {
+		t.Error("enqueue() expected error for failed replay")
+	}
+}
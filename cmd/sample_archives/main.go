@@ -0,0 +1,175 @@
+// sample_archives copies a random sample of the archives of each datatype and
+// date from a production bucket into a sandbox bucket, so that canary and
+// load tests run on realistic but bounded data.
+//
+// The sample of each day is a -fraction of its archives, and at least one, up
+// to -max_per_day.  Archives are chosen by a hash of their names and the
+// -seed, so that the same flags always select the same archives, and a
+// larger fraction selects a superset of a smaller one.  Copies keep the
+// object names of the originals, so that the etl_worker parses them with the
+// same datatype and date, and their metadata records the source URL.
+//
+// Example:
+//
+//	go run ./cmd/sample_archives -source_bucket=archive-measurement-lab \
+//	    -dest_bucket=archive-mlab-sandbox -datatype=ndt/ndt7 \
+//	    -start=2022-07-01 -fraction=0.001 -dry_run
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/civil"
+	gcs "cloud.google.com/go/storage"
+	"github.com/googleapis/google-cloud-go-testing/storage/stiface"
+	"google.golang.org/api/iterator"
+
+	"github.com/m-lab/go/flagx"
+	"github.com/m-lab/go/rtx"
+
+	"github.com/m-lab/etl/etl"
+	"github.com/m-lab/etl/storage"
+)
+
+var (
+	sourceBucket = flag.String("source_bucket", "", "GCS bucket containing the production archives")
+	destBucket   = flag.String("dest_bucket", "", "Sandbox GCS bucket to copy the sampled archives into")
+	start        = flag.String("start", "", "First archive date to sample, as YYYY-MM-DD")
+	end          = flag.String("end", "", "Last archive date to sample, as YYYY-MM-DD. Default is the start date")
+	fraction     = flag.Float64("fraction", 0.001, "Fraction of the archives of each day to sample, in (0, 1]")
+	maxPerDay    = flag.Int("max_per_day", 0, "Most archives to sample from each day and datatype. Default is no limit")
+	seed         = flag.Uint64("seed", 0, "Seed of the sample. The same seed always selects the same archives")
+	dryRun       = flag.Bool("dry_run", false, "List the sampled archives without copying them")
+	timeout      = flag.Duration("timeout", time.Hour, "Timeout for listing and copying all archives")
+	datatypes    flagx.StringArray
+)
+
+func init() {
+	flag.Var(&datatypes, "datatype", "Datatype to sample, as experiment/datatype. May be repeated")
+}
+
+// prefix returns the GCS object prefix of the archives of an
+// experiment/datatype on a date.
+func prefix(datatype string, d civil.Date) (string, error) {
+	if _, _, err := etl.SplitExperimentDatatype(datatype); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/%04d/%02d/%02d/", datatype, d.Year, d.Month, d.Day), nil
+}
+
+// score returns a pseudo-random number in [0, 1) for the name and seed.
+func score(name string, seed uint64) float64 {
+	h := fnv.New64a()
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], seed)
+	h.Write(b[:])
+	h.Write([]byte(name))
+	return float64(h.Sum64()>>11) / (1 << 53)
+}
+
+// sample returns the names with the lowest scores: the fraction of them,
+// rounded up, but no more than max if max is positive.
+func sample(names []string, fraction float64, max int, seed uint64) []string {
+	n := int(math.Ceil(fraction * float64(len(names))))
+	if n > len(names) {
+		n = len(names)
+	}
+	if max > 0 && n > max {
+		n = max
+	}
+	sorted := append([]string{}, names...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return score(sorted[i], seed) < score(sorted[j], seed)
+	})
+	sorted = sorted[:n]
+	sort.Strings(sorted)
+	return sorted
+}
+
+// archives returns the names of the archives in the bucket with the prefix.
+// Other objects, which the etl_worker would not parse, are skipped.
+func archives(ctx context.Context, client stiface.Client, bucket, prefix string) ([]string, error) {
+	names := []string{}
+	it := client.Bucket(bucket).Objects(ctx, &gcs.Query{Prefix: prefix})
+	for {
+		o, err := it.Next()
+		if err == iterator.Done {
+			return names, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if _, err := etl.ValidateTestPath("gs://" + bucket + "/" + o.Name); err != nil {
+			continue
+		}
+		names = append(names, o.Name)
+	}
+}
+
+// copyArchive copies the named archive from the source to the destination
+// bucket, under the same name.
+func copyArchive(ctx context.Context, client stiface.Client, src, dst, name string) error {
+	c := client.Bucket(dst).Object(name).CopierFrom(client.Bucket(src).Object(name))
+	c.ObjectAttrs().Metadata = map[string]string{"sampled_from": "gs://" + src + "/" + name}
+	_, err := c.Run(ctx)
+	return err
+}
+
+func main() {
+	flag.Parse()
+	rtx.Must(flagx.ArgsFromEnv(flag.CommandLine), "Could not get args from env")
+	if *sourceBucket == "" || (*destBucket == "" && !*dryRun) {
+		log.Fatal("-source_bucket and -dest_bucket are required")
+	}
+	if *fraction <= 0 || *fraction > 1 {
+		log.Fatalf("-fraction %v is not in (0, 1]", *fraction)
+	}
+	first, err := civil.ParseDate(*start)
+	rtx.Must(err, "Invalid -start %q", *start)
+	last := first
+	if *end != "" {
+		last, err = civil.ParseDate(*end)
+		rtx.Must(err, "Invalid -end %q", *end)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	client, err := storage.GetStorageClient(true)
+	rtx.Must(err, "GetStorageClient")
+
+	copied, failed := 0, 0
+	for _, dt := range datatypes {
+		for d := first; !d.After(last); d = d.AddDays(1) {
+			p, err := prefix(dt, d)
+			rtx.Must(err, "Invalid -datatype")
+			names, err := archives(ctx, client, *sourceBucket, p)
+			rtx.Must(err, "Failed to list gs://%s/%s", *sourceBucket, p)
+			sampled := sample(names, *fraction, *maxPerDay, *seed)
+			log.Printf("Sampled %d of %d archives from gs://%s/%s", len(sampled), len(names), *sourceBucket, p)
+			for _, name := range sampled {
+				if *dryRun {
+					fmt.Printf("gs://%s/%s\n", *sourceBucket, name)
+					continue
+				}
+				if err := copyArchive(ctx, client, *sourceBucket, *destBucket, name); err != nil {
+					log.Println("Copy failed:", name, err)
+					failed++
+					continue
+				}
+				copied++
+			}
+		}
+	}
+	if failed != 0 {
+		log.Fatalf("%d of %d copies failed", failed, copied+failed)
+	}
+	log.Printf("Copied %d archives to gs://%s", copied, *destBucket)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"cloud.google.com/go/civil"
+	fgs "github.com/fsouza/fake-gcs-server/fakestorage"
+	"github.com/go-test/deep"
+	"github.com/googleapis/google-cloud-go-testing/storage/stiface"
+
+	"github.com/m-lab/etl/etl"
+)
+
+func Test_prefix(t *testing.T) {
+	got, err := prefix("ndt/ndt7", civil.Date{Year: 2022, Month: 7, Day: 1})
+	if err != nil || got != "ndt/ndt7/2022/07/01/" {
+		t.Errorf("prefix() = %q, %v", got, err)
+	}
+	for _, bad := range []string{"ndt", "ndt/", "/ndt7", "ndt/ndt7/x"} {
+		if _, err := prefix(bad, civil.Date{}); !errors.Is(err, etl.ErrBadExperimentDatatype) {
+			t.Errorf("prefix(%q) error = %v, want %v", bad, err, etl.ErrBadExperimentDatatype)
+		}
+	}
+}
+
+func Test_sample(t *testing.T) {
+	names := make([]string, 1000)
+	for i := range names {
+		names[i] = fmt.Sprintf("ndt/ndt7/2022/07/01/20220701T%06dZ-ndt7-mlab1-foo01-ndt.tgz", i)
+	}
+	small := sample(names, 0.01, 0, 1)
+	if len(small) != 10 {
+		t.Fatalf("sample() returned %d names, want 10", len(small))
+	}
+	if diff := deep.Equal(small, sample(names, 0.01, 0, 1)); diff != nil {
+		t.Errorf("sample() is not repeatable: %v", diff)
+	}
+	large := map[string]bool{}
+	for _, n := range sample(names, 0.1, 0, 1) {
+		large[n] = true
+	}
+	for _, n := range small {
+		if !large[n] {
+			t.Errorf("larger sample does not contain %s", n)
+		}
+	}
+	if diff := deep.Equal(small, sample(names, 0.01, 0, 2)); diff == nil {
+		t.Error("sample() with another seed selected the same names")
+	}
+	if got := sample(names, 0.01, 3, 1); len(got) != 3 {
+		t.Errorf("sample() with max 3 returned %d names", len(got))
+	}
+	if got := sample(names[:5], 0.001, 0, 1); len(got) != 1 {
+		t.Errorf("sample() of a small day returned %d names, want 1", len(got))
+	}
+	if got := sample(nil, 0.5, 0, 1); len(got) != 0 {
+		t.Errorf("sample() of no names = %v", got)
+	}
+}
+
+func Test_archivesAndCopy(t *testing.T) {
+	archive := "ndt/ndt7/2022/07/01/20220701T000000.000000Z-ndt7-mlab1-foo01-ndt.tgz"
+	server := fgs.NewServer([]fgs.Object{
+		{BucketName: "archive", Name: archive, Content: []byte("data")},
+		{BucketName: "archive", Name: "ndt/ndt7/2022/07/01/README.txt", Content: []byte("x")},
+		{BucketName: "archive", Name: "ndt/ndt7/2022/07/02/20220702T000000.000000Z-ndt7-mlab1-foo01-ndt.tgz"},
+	})
+	defer server.Stop()
+	server.CreateBucket("sandbox")
+	client := stiface.AdaptClient(server.Client())
+	ctx := context.Background()
+
+	names, err := archives(ctx, client, "archive", "ndt/ndt7/2022/07/01/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(names, []string{archive}); diff != nil {
+		t.Errorf("archives() differ: %v", diff)
+	}
+	if err := copyArchive(ctx, client, "archive", "sandbox", archive); err != nil {
+		t.Fatal(err)
+	}
+	o, err := server.GetObject("sandbox", archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(o.Content) != "data" {
+		t.Errorf("copy has content %q, want %q", o.Content, "data")
+	}
+	if err := copyArchive(ctx, client, "archive", "sandbox", "ndt/missing.tgz"); err == nil {
+		t.Error("copyArchive() of a missing archive succeeded")
+	}
+}
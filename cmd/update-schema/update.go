@@ -12,6 +12,7 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"strings"
@@ -25,93 +26,129 @@ import (
 	"github.com/m-lab/go/flagx"
 	"github.com/m-lab/go/rtx"
 
+	"github.com/m-lab/etl/etl"
 	"github.com/m-lab/etl/schema"
 )
 
 // CreateOrUpdateTCPInfo will update existing TCPInfo table, or create new table if update fails.
 func CreateOrUpdateTCPInfo(project string, dataset string, table string) error {
 	row := schema.TCPInfoRow{}
+	cfg := schema.TableConfigFor(table)
 	schema, err := row.Schema()
 	rtx.Must(err, "TCPInfoRow.Schema")
-	return CreateOrUpdate(schema, project, dataset, table, "Date")
+	return CreateOrUpdate(schema, project, dataset, table, cfg)
 }
 
 func CreateOrUpdatePT(project string, dataset string, table string) error {
 	row := schema.PTTest{}
+	cfg := schema.TableConfigFor(table)
 	schema, err := row.Schema()
 	rtx.Must(err, "PTTest.Schema")
 	if dataset == "batch" {
-		updateTemplateTables(schema, project, dataset, table, "")
+		updateTemplateTables(schema, project, dataset, table, cfg)
 	}
-	return CreateOrUpdate(schema, project, dataset, table, "")
+	return CreateOrUpdate(schema, project, dataset, table, cfg)
 }
 
-func CreateOrUpdateSS(project string, dataset string, table string) error {
-	row := schema.SS{}
+func CreateOrUpdateSSRow(project string, dataset string, table string) error {
+	row := schema.SSRow{}
+	cfg := schema.TableConfigFor(table)
 	schema, err := row.Schema()
-	rtx.Must(err, "SS.Schema")
+	rtx.Must(err, "SSRow.Schema")
 	if dataset == "batch" {
-		updateTemplateTables(schema, project, dataset, table, "")
+		updateTemplateTables(schema, project, dataset, table, cfg)
 	}
-	return CreateOrUpdate(schema, project, dataset, table, "")
+	return CreateOrUpdate(schema, project, dataset, table, cfg)
 }
 func CreateOrUpdateNDTWeb100(project string, dataset string, table string) error {
 	row := schema.NDTWeb100{}
+	cfg := schema.TableConfigFor(table)
 	schema, err := row.Schema()
 	rtx.Must(err, "NDTWeb100.Schema")
 	if dataset == "batch" {
-		updateTemplateTables(schema, project, dataset, table, "")
+		updateTemplateTables(schema, project, dataset, table, cfg)
 	}
-	return CreateOrUpdate(schema, project, dataset, table, "")
+	return CreateOrUpdate(schema, project, dataset, table, cfg)
 }
 
 func CreateOrUpdateNDT5ResultRowV2(project string, dataset string, table string) error {
 	row := schema.NDT5ResultRowV2{}
+	cfg := schema.TableConfigFor(table)
 	schema, err := row.Schema()
 	rtx.Must(err, "NDT5ResultRowV2.Schema")
-	return CreateOrUpdate(schema, project, dataset, table, "Date")
+	return CreateOrUpdate(schema, project, dataset, table, cfg)
 }
 
 func CreateOrUpdateNDT7ResultRow(project string, dataset string, table string) error {
 	row := schema.NDT7ResultRow{}
+	cfg := schema.TableConfigFor(table)
 	schema, err := row.Schema()
 	rtx.Must(err, "NDT7ResultRow.Schema")
-	return CreateOrUpdate(schema, project, dataset, table, "Date")
+	return CreateOrUpdate(schema, project, dataset, table, cfg)
 }
 
 func CreateOrUpdateAnnotationRow(project string, dataset string, table string) error {
 	row := schema.AnnotationRow{}
+	cfg := schema.TableConfigFor(table)
 	schema, err := row.Schema()
 	rtx.Must(err, "Annotation.Schema")
-	return CreateOrUpdate(schema, project, dataset, table, "Date")
+	return CreateOrUpdate(schema, project, dataset, table, cfg)
 }
 
 func CreateOrUpdateSwitchRow(project string, dataset string, table string) error {
 	row := schema.SwitchRow{}
+	cfg := schema.TableConfigFor(table)
 	schema, err := row.Schema()
 	rtx.Must(err, "SwitchRow.Schema")
-	return CreateOrUpdate(schema, project, dataset, table, "Date")
+	return CreateOrUpdate(schema, project, dataset, table, cfg)
 }
 
 func CreateOrUpdatePCAPRow(project string, dataset string, table string) error {
 	row := schema.PCAPRow{}
+	cfg := schema.TableConfigFor(table)
 	schema, err := row.Schema()
 	rtx.Must(err, "PCAPRow.Schema")
-	return CreateOrUpdate(schema, project, dataset, table, "Date")
+	return CreateOrUpdate(schema, project, dataset, table, cfg)
 }
 
 func CreateOrUpdateHopAnnotation1Row(project string, dataset string, table string) error {
 	row := schema.HopAnnotation1Row{}
+	cfg := schema.TableConfigFor(table)
 	schema, err := row.Schema()
 	rtx.Must(err, "HopAnnotation1Row.Schema")
-	return CreateOrUpdate(schema, project, dataset, table, "Date")
+	return CreateOrUpdate(schema, project, dataset, table, cfg)
 }
 
 func CreateOrUpdateScamper1Row(project string, dataset string, table string) error {
 	row := schema.Scamper1Row{}
+	cfg := schema.TableConfigFor(table)
 	schema, err := row.Schema()
 	rtx.Must(err, "Scamper1Row.Schema")
-	return CreateOrUpdate(schema, project, dataset, table, "Date")
+	return CreateOrUpdate(schema, project, dataset, table, cfg)
+}
+
+func CreateOrUpdateUUIDMapRow(project string, dataset string, table string) error {
+	row := schema.UUIDMapRow{}
+	cfg := schema.TableConfigFor(table)
+	schema, err := row.Schema()
+	rtx.Must(err, "UUIDMapRow.Schema")
+	return CreateOrUpdate(schema, project, dataset, table, cfg)
+}
+
+func CreateOrUpdateFileStatsRow(project string, dataset string, table string) error {
+	row := schema.FileStatsRow{}
+	cfg := schema.TableConfigFor(table)
+	schema, err := row.Schema()
+	rtx.Must(err, "FileStatsRow.Schema")
+	return CreateOrUpdate(schema, project, dataset, table, cfg)
+}
+
+func CreateOrUpdateDiffRow(project string, dataset string, table string) error {
+	row := schema.DiffRow{}
+	cfg := schema.TableConfigFor(table)
+	schema, err := row.Schema()
+	rtx.Must(err, "DiffRow.Schema")
+	return CreateOrUpdate(schema, project, dataset, table, cfg)
 }
 
 // listTemplateTables finds all template tables for the given project, datatype, and base table name.
@@ -121,7 +158,7 @@ func listTemplateTables(project, dataset, table string) ([]string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
 	defer cancel()
 
-	client, err := bigquery.NewClient(ctx, project)
+	client, err := etl.NewBigQueryClient(ctx, project)
 	if err != nil {
 		return nil, err
 	}
@@ -142,7 +179,7 @@ func listTemplateTables(project, dataset, table string) ([]string, error) {
 }
 
 // updateTemplateTables updates the schema on all template tables for the named dataset and table.
-func updateTemplateTables(schema bigquery.Schema, project, dataset, table, partField string) error {
+func updateTemplateTables(schema bigquery.Schema, project, dataset, table string, cfg schema.TableConfig) error {
 	// Find all template tables for this table.
 	tables, err := listTemplateTables(project, dataset, table)
 	if err != nil {
@@ -154,7 +191,7 @@ func updateTemplateTables(schema bigquery.Schema, project, dataset, table, partF
 		// table is recreated here. However, the table will be empty, and used
 		// by the next pass of the parser. So, this is expected to be
 		// unconditionally safe.
-		err = CreateOrUpdate(schema, project, dataset, tables[i], partField)
+		err = CreateOrUpdate(schema, project, dataset, tables[i], cfg)
 		if err != nil {
 			return err
 		}
@@ -162,21 +199,26 @@ func updateTemplateTables(schema bigquery.Schema, project, dataset, table, partF
 	return nil
 }
 
-// CreateOrUpdate will update or create a table from the given schema.
-func CreateOrUpdate(schema bigquery.Schema, project, dataset, table, partField string) error {
+// CreateOrUpdate will update or create a table from the given schema, and
+// apply the partitioning, clustering and retention given by cfg.
+func CreateOrUpdate(sch bigquery.Schema, project, dataset, table string, cfg schema.TableConfig) error {
 	name := project + "." + dataset + "." + table
 	pdt, err := bqx.ParsePDT(name)
 	rtx.Must(err, "ParsePDT")
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
 	defer cancel()
-	client, err := bigquery.NewClient(ctx, pdt.Project)
+	client, err := etl.NewBigQueryClient(ctx, pdt.Project)
 	rtx.Must(err, "NewClient")
 
-	err = pdt.UpdateTable(ctx, client, schema)
+	if err := ensureDataset(ctx, client, pdt.Dataset); err != nil {
+		log.Println("Dataset failed:", err)
+		return err
+	}
+	err = pdt.UpdateTable(ctx, client, sch)
 	if err == nil {
 		log.Println("Successfully updated", pdt)
-		return nil
+		return applyTableConfig(ctx, client, pdt, cfg)
 	}
 	log.Println("UpdateTable failed:", err)
 	// TODO add specific error handling for incompatible schema change
@@ -186,19 +228,50 @@ func CreateOrUpdate(schema bigquery.Schema, project, dataset, table, partField s
 		// TODO - different behavior on specific error types?
 	}
 
-	partitioning := &bigquery.TimePartitioning{
-		Field: partField,
-	}
-
-	err = pdt.CreateTable(ctx, client, schema, "description", partitioning, nil)
+	err = pdt.CreateTable(ctx, client, sch, "description", cfg.TimePartitioning(), cfg.ClusteringSpec())
 	if err == nil {
 		log.Println("Successfully created", pdt)
-		return nil
+		return applyTableConfig(ctx, client, pdt, cfg)
 	}
 	log.Println("Create failed:", err)
 	return err
 }
 
+// ensureDataset creates the dataset in the -region, if it does not exist,
+// and returns an error if it exists in another location, where jobs in the
+// region cannot read it.  Without a region, datasets are left as they are.
+func ensureDataset(ctx context.Context, client *bigquery.Client, dataset string) error {
+	if etl.Region == "" {
+		return nil
+	}
+	ds := client.Dataset(dataset)
+	md, err := ds.Metadata(ctx)
+	if apiErr, ok := err.(*googleapi.Error); ok && apiErr.Code == 404 {
+		log.Println("Creating dataset", dataset, "in", etl.Region)
+		return ds.Create(ctx, &bigquery.DatasetMetadata{Location: etl.Region})
+	}
+	if err != nil {
+		return err
+	}
+	if !etl.InRegion(md.Location) {
+		return fmt.Errorf("dataset %s is in %s, not %s", dataset, md.Location, etl.Region)
+	}
+	return nil
+}
+
+// applyTableConfig sets the partition expiration, clustering and partition
+// filter requirement of an existing table.  CreateTable cannot set the
+// partition filter requirement, so this is also needed for new tables.
+func applyTableConfig(ctx context.Context, client *bigquery.Client, pdt bqx.PDT, cfg schema.TableConfig) error {
+	t := client.Dataset(pdt.Dataset).Table(pdt.Table)
+	_, err := t.Update(ctx, cfg.Update(), "")
+	if err != nil {
+		log.Println("Applying table config failed:", pdt, err)
+		return err
+	}
+	return nil
+}
+
 // Only tables that support Standard Columns should be included here.
 func updateStandardTables(project string) int {
 	errCount := 0
@@ -256,6 +329,15 @@ func updateStandardTables(project string) int {
 	if err := CreateOrUpdateTCPInfo(project, "raw_ndt", "tcpinfo"); err != nil {
 		errCount++
 	}
+	if err := CreateOrUpdateUUIDMapRow(project, "tmp_ndt", "uuid_map"); err != nil {
+		errCount++
+	}
+	if err := CreateOrUpdateUUIDMapRow(project, "raw_ndt", "uuid_map"); err != nil {
+		errCount++
+	}
+	if err := CreateOrUpdateDiffRow(project, "tmp_ndt", "parser_diff"); err != nil {
+		errCount++
+	}
 
 	return errCount
 }
@@ -268,10 +350,10 @@ func updateLegacyTables(project string) int {
 	if err := CreateOrUpdatePT(project, "batch", "traceroute"); err != nil {
 		errCount++
 	}
-	if err := CreateOrUpdateSS(project, "base_tables", "sidestream"); err != nil {
+	if err := CreateOrUpdateSSRow(project, "base_tables", "sidestream"); err != nil {
 		errCount++
 	}
-	if err := CreateOrUpdateSS(project, "batch", "sidestream"); err != nil {
+	if err := CreateOrUpdateSSRow(project, "batch", "sidestream"); err != nil {
 		errCount++
 	}
 	if err := CreateOrUpdateNDTWeb100(project, "base_tables", "ndt"); err != nil {
@@ -286,12 +368,14 @@ func updateLegacyTables(project string) int {
 var (
 	updateType = flag.String("updateType", "", "Short name of datatype to be updated (tcpinfo, scamper, ...).")
 	project    = flag.String("gcloud_project", "", "GCP project to update")
+	region     = flag.String("region", "", "Create missing datasets in, and run BigQuery jobs in, this location, e.g. 'europe-west1'")
 )
 
 // For now, this just updates all known tables for the provided project.
 func main() {
 	flag.Parse()
 	flagx.ArgsFromEnv(flag.CommandLine)
+	etl.Region = *region
 
 	errCount := 0
 
@@ -331,10 +415,10 @@ func main() {
 			errCount++
 		}
 	case "sidestream":
-		if err := CreateOrUpdateSS(*project, "base_tables", "sidestream"); err != nil {
+		if err := CreateOrUpdateSSRow(*project, "base_tables", "sidestream"); err != nil {
 			errCount++
 		}
-		if err := CreateOrUpdateSS(*project, "batch", "sidestream"); err != nil {
+		if err := CreateOrUpdateSSRow(*project, "batch", "sidestream"); err != nil {
 			errCount++
 		}
 	case "ndt":
@@ -401,6 +485,14 @@ func main() {
 			errCount++
 		}
 
+	case "file_stats":
+		if err := CreateOrUpdateFileStatsRow(*project, "tmp_ndt", "file_stats"); err != nil {
+			errCount++
+		}
+		if err := CreateOrUpdateFileStatsRow(*project, "raw_ndt", "file_stats"); err != nil {
+			errCount++
+		}
+
 	default:
 		log.Fatal("invalid updateType: ", *updateType)
 	}
@@ -0,0 +1,152 @@
+package main
+
+// This command recomputes the daily partitions of the derived summary tables
+// defined in the summary package.  It is intended to run periodically, e.g. as
+// a cron job, after the parsers have completed processing a date.
+//
+// By default, it updates all summary tables for yesterday.  It also updates
+// the task summary side table, defined in the provenance package, of each
+// -tasks table, so that validation and dedup need not scan the raw partitions.
+//
+// With a positive -budget_limit, each update holds a token of the fleet-wide
+// BigQuery job budget shared with the parsers, so that the parsers, limited
+// to fewer tokens by their -budget_limit, cannot starve the daily updates.
+//
+// Examples:
+//  GCLOUD_PROJECT=mlab-sandbox go run ./cmd/update-summaries
+//  go run ./cmd/update-summaries -gcloud_project=mlab-sandbox -dataset_prefix=tmp \
+//      -table=switch_daily -start=2022-07-01 -end=2022-07-04
+//  go run ./cmd/update-summaries -gcloud_project=mlab-sandbox -table=none \
+//      -tasks=raw_ndt.ndt7 -tasks=raw_ndt.tcpinfo
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"cloud.google.com/go/datastore"
+
+	"github.com/m-lab/go/flagx"
+	"github.com/m-lab/go/rtx"
+
+	"github.com/m-lab/etl/etl"
+	"github.com/m-lab/etl/provenance"
+	"github.com/m-lab/etl/summary"
+	"github.com/m-lab/etl/worker"
+)
+
+var (
+	project       = flag.String("gcloud_project", "", "GCP project containing the source and summary tables")
+	datasetPrefix = flag.String("dataset_prefix", "raw", "Prefix of the datasets to read and write, e.g. 'raw' or 'tmp'")
+	table         = flag.String("table", "", "Name of a single summary table to update, or 'none'. Default is all tables")
+	start         = flag.String("start", "", "First date to update, as YYYY-MM-DD. Default is yesterday")
+	end           = flag.String("end", "", "Last date to update, as YYYY-MM-DD. Default is the start date")
+	timeout       = flag.Duration("timeout", 30*time.Minute, "Timeout for all updates")
+	region        = flag.String("region", "", "Run BigQuery jobs in this location, e.g. 'europe-west1', as required for datasets outside the US")
+	budgetProject = flag.String("budget_datastore_project", "", "Datastore project of the BigQuery job budget shared with the parsers. Required with -budget_limit")
+	budgetLimit   = flag.Int("budget_limit", 0, "If positive, run each update with one of the first -budget_limit tokens of the fleet-wide -budget_datastore_project budget, which all parsers and jobs using the budget share. Usually all of the tokens. 0 disables the budget")
+	tasks         flagx.StringArray
+)
+
+func init() {
+	flag.Var(&tasks, "tasks", "Standard columns table, as dataset.table, whose task summary side table to update. May be repeated")
+}
+
+func mustParseDate(s string, def civil.Date) civil.Date {
+	if s == "" {
+		return def
+	}
+	d, err := civil.ParseDate(s)
+	rtx.Must(err, "Invalid date: %q", s)
+	return d
+}
+
+// withBudget runs update while holding a token of the budget.
+func withBudget(ctx context.Context, budget *worker.Budget, update func() error) error {
+	release, err := budget.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return update()
+}
+
+func main() {
+	flag.Parse()
+	rtx.Must(flagx.ArgsFromEnv(flag.CommandLine), "Could not get args from env")
+	etl.Region = *region
+
+	if *project == "" {
+		log.Fatal("Missing GCLOUD_PROJECT environment variable.")
+	}
+
+	tables := summary.Tables
+	if *table == "none" {
+		tables = nil
+	} else if *table != "" {
+		t, ok := summary.Get(*table)
+		if !ok {
+			log.Fatalf("Unknown summary table: %q", *table)
+		}
+		tables = []summary.Table{t}
+	}
+
+	first := mustParseDate(*start, civil.DateOf(time.Now().UTC()).AddDays(-1))
+	last := mustParseDate(*end, first)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	client, err := etl.NewBigQueryClient(ctx, *project)
+	rtx.Must(err, "NewClient")
+
+	var budget *worker.Budget
+	if *budgetLimit < 0 {
+		log.Fatal("-budget_limit must not be negative")
+	}
+	if *budgetLimit > 0 {
+		if *budgetProject == "" {
+			log.Fatal("-budget_datastore_project is required with -budget_limit")
+		}
+		ds, err := datastore.NewClient(ctx, *budgetProject)
+		rtx.Must(err, "Failed to create datastore client")
+		host, err := os.Hostname()
+		rtx.Must(err, "Failed to get hostname")
+		holder := fmt.Sprintf("update-summaries-%s-%d", host, os.Getpid())
+		budget = worker.NewBudget(worker.NewDatastoreLeaseStore(ds, "etl"), "bigquery", holder, *budgetLimit, time.Minute)
+	}
+
+	errCount := 0
+	for d := first; last.DaysSince(d) >= 0; d = d.AddDays(1) {
+		for _, t := range tables {
+			dataset := t.Dataset(*datasetPrefix)
+			err := withBudget(ctx, budget, func() error {
+				return t.Update(ctx, client, *project, dataset, d)
+			})
+			if err != nil {
+				log.Println("Failed to update", dataset, t.Name, d, err)
+				errCount++
+				continue
+			}
+			log.Println("Updated", dataset, t.Name, d)
+		}
+		for _, name := range tasks {
+			src := provenance.Standard(*project + "." + name)
+			err := withBudget(ctx, budget, func() error {
+				return src.UpdateTasks(ctx, client, d)
+			})
+			if err != nil {
+				log.Println("Failed to update", src.Tasks().Table, d, err)
+				errCount++
+				continue
+			}
+			log.Println("Updated", src.Tasks().Table, d)
+		}
+	}
+	if errCount != 0 {
+		log.Fatalf("%d updates failed", errCount)
+	}
+}
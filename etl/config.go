@@ -0,0 +1,223 @@
+package etl
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+// Config overrides the built-in per data type settings.  Data types missing
+// from a map keep their built-in setting.  A Config may be replaced while the
+// worker is running, with SetConfig.  Tasks read the settings when they start,
+// so a new Config applies to new tasks only.
+type Config struct {
+	// Tables maps data types to BigQuery table names.
+	Tables map[DataType]string `json:"tables,omitempty"`
+	// BufferSizes maps data types to the initial BQ insert buffer size.
+	BufferSizes map[DataType]int `json:"buffer_sizes,omitempty"`
+	// SkipCounts maps data types to the number of files to skip.
+	SkipCounts map[DataType]int `json:"skip_counts,omitempty"`
+	// MaxArchiveSizes maps data types to the largest archive, in bytes, that
+	// a worker will attempt to process.
+	MaxArchiveSizes map[DataType]int64 `json:"max_archive_sizes,omitempty"`
+	// Features lists the features enabled for each data type.
+	Features map[DataType][]Feature `json:"features,omitempty"`
+	// Profiles maps data types to their resource profiles.  Zero fields keep
+	// the built-in value.
+	Profiles map[DataType]Profile `json:"profiles,omitempty"`
+	// DeniedArchives maps archive URLs, known to be corrupt or intentionally
+	// excluded, to the reason they are denied.  A URL ending in "/" denies
+	// every archive under that prefix, e.g. a whole date directory.
+	DeniedArchives map[string]string `json:"denied_archives,omitempty"`
+	// AllowedDirs lists the archive directories, as "experiment/datatype", or
+	// "datatype" for legacy archives without an experiment directory, that
+	// the worker accepts.  Empty allows all directories.
+	AllowedDirs []string `json:"allowed_dirs,omitempty"`
+	// Suffixes maps data types to the SuffixStrategy of their tables.
+	Suffixes map[DataType]SuffixStrategy `json:"suffixes,omitempty"`
+}
+
+// Feature names a parser or sink behavior that is disabled by default, so that
+// it can be rolled out gradually, per data type and per environment, by
+// enabling it in the Config of each deployment.
+type Feature string
+
+const (
+	// FullSnapshots keeps every tcpinfo snapshot, instead of every 10th.
+	FullSnapshots = Feature("full_snapshots")
+	// SwitchStreaming inserts switch rows as their timestamps complete,
+	// instead of at the end of each file, to bound memory on large archives.
+	SwitchStreaming = Feature("switch_streaming")
+	// AllSnapshots keeps a delta for every ndt web100 snapshot, including
+	// those where only the Duration changed, and those past the usual limit.
+	AllSnapshots = Feature("all_snapshots")
+	// DropIdleSnapshots drops each tcpinfo snapshot whose key fields are
+	// unchanged from its predecessor, before thinning.
+	DropIdleSnapshots = Feature("drop_idle_snapshots")
+)
+
+// knownFeatures lists the features that a Config may enable.
+var knownFeatures = map[Feature]bool{
+	FullSnapshots:     true,
+	SwitchStreaming:   true,
+	AllSnapshots:      true,
+	DropIdleSnapshots: true,
+}
+
+// config holds the current *Config.
+var config atomic.Value
+
+func init() {
+	config.Store(&Config{})
+}
+
+// ParseConfig decodes a JSON Config, and checks that it names only known data
+// types and has no negative values.  Errors are of kind ErrValidation.
+func ParseConfig(data []byte) (*Config, error) {
+	c := &Config{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return nil, ErrValidation.Errorf("%w", err)
+	}
+	check := func(field string, dt DataType, negative bool) error {
+		if _, ok := dataTypeToTable[dt]; !ok || dt == INVALID {
+			return ErrValidation.Errorf("%s: %w: %q", field, ErrBadDataType, dt)
+		}
+		if negative {
+			return ErrValidation.Errorf("%s: negative value for %q", field, dt)
+		}
+		return nil
+	}
+	for dt, table := range c.Tables {
+		if err := check("tables", dt, false); err != nil {
+			return nil, err
+		}
+		if table == "" {
+			return nil, ErrValidation.Errorf("tables: empty table name for %q", dt)
+		}
+	}
+	for dt, n := range c.BufferSizes {
+		if err := check("buffer_sizes", dt, n < 0); err != nil {
+			return nil, err
+		}
+	}
+	for dt, n := range c.SkipCounts {
+		if err := check("skip_counts", dt, n < 0); err != nil {
+			return nil, err
+		}
+	}
+	for dt, n := range c.MaxArchiveSizes {
+		if err := check("max_archive_sizes", dt, n < 0); err != nil {
+			return nil, err
+		}
+	}
+	for dt, features := range c.Features {
+		if err := check("features", dt, false); err != nil {
+			return nil, err
+		}
+		for _, f := range features {
+			if !knownFeatures[f] {
+				return nil, ErrValidation.Errorf("features: unknown feature %q for %q", f, dt)
+			}
+		}
+	}
+	for dt, p := range c.Profiles {
+		negative := p.CPUWeight < 0 || p.ParseFactor < 0 || p.ExpectedSeconds < 0 ||
+			p.Parallelism < 0
+		if err := check("profiles", dt, negative); err != nil {
+			return nil, err
+		}
+	}
+	for dt, strategy := range c.Suffixes {
+		if err := check("suffixes", dt, false); err != nil {
+			return nil, err
+		}
+		if !strategy.valid() {
+			return nil, ErrValidation.Errorf("suffixes: unknown strategy %q for %q", strategy, dt)
+		}
+	}
+	for url := range c.DeniedArchives {
+		if !strings.HasPrefix(url, "gs://") {
+			return nil, ErrValidation.Errorf("denied_archives: not a gs:// URL: %q", url)
+		}
+	}
+	for _, dir := range c.AllowedDirs {
+		if !allowedDirPattern.MatchString(dir) {
+			return nil, ErrValidation.Errorf("allowed_dirs: not experiment/datatype: %q", dir)
+		}
+	}
+	return c, nil
+}
+
+// allowedDirPattern matches the entries of Config.AllowedDirs.
+var allowedDirPattern = regexp.MustCompile(`^(?:[a-z-]+/)?[a-z0-9-]+$`)
+
+// SetConfig replaces the current Config.  A nil Config restores the built-in
+// settings.
+func SetConfig(c *Config) {
+	if c == nil {
+		c = &Config{}
+	}
+	config.Store(c)
+}
+
+// Enabled reports whether the feature is enabled for the DataType in the
+// current Config.  Parsers and sinks should check features when they are
+// created, so that a task sees the same setting throughout.
+func (dt DataType) Enabled(f Feature) bool {
+	for _, e := range currentConfig().Features[dt] {
+		if e == f {
+			return true
+		}
+	}
+	return false
+}
+
+// DeniedArchive returns the reason that the archive URL is denied by the
+// current Config, and whether it is denied.  Denied archives should be
+// skipped, and reported as completed, so that they are not retried.
+func DeniedArchive(url string) (string, bool) {
+	denied := currentConfig().DeniedArchives
+	if reason, ok := denied[url]; ok {
+		return reason, true
+	}
+	for prefix, reason := range denied {
+		if strings.HasSuffix(prefix, "/") && strings.HasPrefix(url, prefix) {
+			return reason, true
+		}
+	}
+	return "", false
+}
+
+// Dir returns the experiment and datatype directories of dp, in the form used
+// by Config.AllowedDirs.
+func (dp DataPath) Dir() string {
+	if dp.ExpDir == "" {
+		return dp.DataType
+	}
+	return dp.ExpDir + "/" + dp.DataType
+}
+
+// Allowed reports whether the current Config allows archives from the
+// experiment and datatype directories of dp.
+func (dp DataPath) Allowed() bool {
+	allowed := currentConfig().AllowedDirs
+	if len(allowed) == 0 {
+		return true
+	}
+	dir := dp.Dir()
+	for _, a := range allowed {
+		if a == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// currentConfig returns the current Config.
+func currentConfig() *Config {
+	return config.Load().(*Config)
+}
@@ -0,0 +1,110 @@
+package etl_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/m-lab/etl/etl"
+)
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{name: "empty", data: `{}`},
+		{name: "all", data: `{
+			"tables": {"ndt7": "ndt7_v2"},
+			"buffer_sizes": {"tcpinfo": 10},
+			"skip_counts": {"pcap": 2},
+			"max_archive_sizes": {"pcap": 1024}
+		}`},
+		{name: "unknown-datatype", data: `{"tables": {"foobar": "foobar"}}`, wantErr: true},
+		{name: "unknown-field", data: `{"thresholds": {}}`, wantErr: true},
+		{name: "negative", data: `{"skip_counts": {"pcap": -1}}`, wantErr: true},
+		{name: "empty-table", data: `{"tables": {"ndt7": ""}}`, wantErr: true},
+		{name: "bad-json", data: `{`, wantErr: true},
+		{name: "feature", data: `{"features": {"tcpinfo": ["full_snapshots"]}}`},
+		{name: "unknown-feature", data: `{"features": {"tcpinfo": ["foobar"]}}`, wantErr: true},
+		{name: "profile", data: `{"profiles": {"pcap": {"cpu_weight": 4}}}`},
+		{name: "negative-profile", data: `{"profiles": {"pcap": {"expected_seconds": -1}}}`, wantErr: true},
+		{name: "negative-parallelism", data: `{"profiles": {"tcpinfo": {"parallelism": -1}}}`, wantErr: true},
+		{name: "denied", data: `{"denied_archives": {"gs://archive/ndt/pcap/2022/07/01/": "corrupt"}}`},
+		{name: "allowed", data: `{"allowed_dirs": ["ndt/ndt7", "sidestream"]}`},
+		{name: "allowed-bad", data: `{"allowed_dirs": ["ndt/ndt7/2022"]}`, wantErr: true},
+		{name: "suffix", data: `{"suffixes": {"ndt7": "partition", "pcap": "none"}}`},
+		{name: "unknown-suffix", data: `{"suffixes": {"ndt7": "daily"}}`, wantErr: true},
+		{name: "denied-not-gcs", data: `{"denied_archives": {"/tmp/foo.tgz": "corrupt"}}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := etl.ParseConfig([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+	_, err := etl.ParseConfig([]byte(`{"tables": {"foobar": "foobar"}}`))
+	if !errors.Is(err, etl.ErrBadDataType) {
+		t.Errorf("ParseConfig() error = %v, want %v", err, etl.ErrBadDataType)
+	}
+}
+
+func TestSetConfig(t *testing.T) {
+	defer etl.SetConfig(nil)
+	c, err := etl.ParseConfig([]byte(`{
+		"tables": {"ndt7": "ndt7_v2"},
+		"buffer_sizes": {"tcpinfo": 10},
+		"skip_counts": {"pcap": 2},
+		"max_archive_sizes": {"pcap": 1024},
+		"features": {"tcpinfo": ["full_snapshots"]},
+		"denied_archives": {
+			"gs://archive/ndt/ndt7/2022/07/01/a.tgz": "truncated",
+			"gs://archive/ndt/pcap/2022/07/02/": "excluded"
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	etl.SetConfig(c)
+	if !etl.TCPINFO.Enabled(etl.FullSnapshots) {
+		t.Error("Enabled(FullSnapshots) = false for tcpinfo")
+	}
+	if etl.NDT7.Enabled(etl.FullSnapshots) {
+		t.Error("Enabled(FullSnapshots) = true for ndt7")
+	}
+	if got := etl.NDT7.Table(); got != "ndt7_v2" {
+		t.Errorf("Table() = %q, want ndt7_v2", got)
+	}
+	if got := etl.DirToTablename("ndt7"); got != "ndt7_v2" {
+		t.Errorf("DirToTablename() = %q, want ndt7_v2", got)
+	}
+	if got := etl.TCPINFO.BQBufferSize(); got != 10 {
+		t.Errorf("BQBufferSize() = %d, want 10", got)
+	}
+	if got := etl.PCAP.SkipCount(); got != 2 {
+		t.Errorf("SkipCount() = %d, want 2", got)
+	}
+	if got := etl.PCAP.MaxArchiveSize(); got != 1024 {
+		t.Errorf("MaxArchiveSize() = %d, want 1024", got)
+	}
+	if reason, ok := etl.DeniedArchive("gs://archive/ndt/ndt7/2022/07/01/a.tgz"); !ok || reason != "truncated" {
+		t.Errorf("DeniedArchive() = %q, %t, want truncated, true", reason, ok)
+	}
+	if reason, ok := etl.DeniedArchive("gs://archive/ndt/pcap/2022/07/02/b.tgz"); !ok || reason != "excluded" {
+		t.Errorf("DeniedArchive() = %q, %t, want excluded, true", reason, ok)
+	}
+	if _, ok := etl.DeniedArchive("gs://archive/ndt/ndt7/2022/07/01/b.tgz"); ok {
+		t.Error("DeniedArchive() = true for an archive not in the config")
+	}
+	// Data types missing from the config keep the built-in settings.
+	if got := etl.SW.Table(); got != "switch" {
+		t.Errorf("Table() = %q, want switch", got)
+	}
+
+	etl.SetConfig(nil)
+	if got := etl.NDT7.Table(); got != "ndt7" {
+		t.Errorf("Table() after reset = %q, want ndt7", got)
+	}
+}
@@ -0,0 +1,103 @@
+package etl
+
+import (
+	"sort"
+)
+
+// Destination identifies the BigQuery table for a DataType's output.
+type Destination struct {
+	Project string
+	Dataset string
+	Table   string
+}
+
+// String returns the fully qualified table name.
+func (d Destination) String() string {
+	return d.Project + "." + d.Dataset + "." + d.Table
+}
+
+var (
+	// Environment selects the deployment environment, e.g. "sandbox".  When
+	// set, output destinations are taken from the environment's config, unless
+	// overridden by BigqueryProject or BigqueryDataset.
+	Environment string
+
+	// environmentProjects maps each environment to its GCP project.
+	// TODO - this should be loaded from a config.
+	environmentProjects = map[string]string{
+		"sandbox": "mlab-sandbox",
+		"staging": "mlab-staging",
+		"oti":     "mlab-oti",
+	}
+
+	// dataTypeToDataset maps from data type to the BigQuery dataset used in
+	// every environment.  Legacy data types not listed here use the
+	// base_tables or batch datasets.
+	// TODO - this should be loaded from a config.
+	dataTypeToDataset = map[DataType]string{
+		ANNOTATION:     "tmp_ndt",
+		HOPANNOTATION1: "tmp_ndt",
+		NDT5:           "tmp_ndt",
+		NDT7:           "tmp_ndt",
+		PCAP:           "tmp_ndt",
+		SCAMPER1:       "tmp_ndt",
+		TCPINFO:        "tmp_ndt",
+		SW:             "tmp_utilization",
+	}
+
+	// environments maps from environment to per data type destinations.
+	environments = map[string]map[DataType]Destination{}
+)
+
+func init() {
+	for env, project := range environmentProjects {
+		dests := make(map[DataType]Destination, len(dataTypeToTable))
+		for dt, table := range dataTypeToTable {
+			dataset, ok := dataTypeToDataset[dt]
+			if !ok {
+				dataset = "base_tables"
+			}
+			dests[dt] = Destination{Project: project, Dataset: dataset, Table: table}
+		}
+		environments[env] = dests
+	}
+}
+
+// Environments returns the sorted names of all known environments.
+func Environments() []string {
+	names := make([]string, 0, len(environments))
+	for env := range environments {
+		names = append(names, env)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ValidateEnvironment returns an error if env is neither empty nor a known
+// environment.
+func ValidateEnvironment(env string) error {
+	if _, ok := environments[env]; env != "" && !ok {
+		return ErrValidation.Errorf("unknown environment %q, want one of %v", env, Environments())
+	}
+	return nil
+}
+
+// environmentDestination returns the destination for dt in the current
+// Environment, if any.
+func (dt DataType) environmentDestination() (Destination, bool) {
+	dests, ok := environments[Environment]
+	if !ok {
+		return Destination{}, false
+	}
+	d, ok := dests[dt]
+	return d, ok
+}
+
+// Destination returns the project, dataset, and table for dt's output.
+func (dt DataType) Destination() Destination {
+	return Destination{
+		Project: dt.BigqueryProject(),
+		Dataset: dt.Dataset(),
+		Table:   dt.Table(),
+	}
+}
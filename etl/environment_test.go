@@ -0,0 +1,82 @@
+package etl_test
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+
+	"github.com/m-lab/etl/etl"
+)
+
+func TestEnvironmentDestination(t *testing.T) {
+	defer func() {
+		etl.Environment = ""
+		etl.BigqueryProject = ""
+		etl.BigqueryDataset = ""
+		etl.GCloudProject = ""
+		etl.IsBatch = false
+	}()
+	etl.IsBatch = false
+	etl.BigqueryProject = ""
+	etl.BigqueryDataset = ""
+	etl.GCloudProject = "some-project"
+
+	tests := []struct {
+		name string
+		env  string
+		dt   etl.DataType
+		want etl.Destination
+	}{
+		{
+			name: "no-environment",
+			dt:   etl.NDT7,
+			want: etl.Destination{Project: "some-project", Dataset: "base_tables", Table: "ndt7"},
+		},
+		{
+			name: "sandbox-ndt7",
+			env:  "sandbox",
+			dt:   etl.NDT7,
+			want: etl.Destination{Project: "mlab-sandbox", Dataset: "tmp_ndt", Table: "ndt7"},
+		},
+		{
+			name: "oti-switch",
+			env:  "oti",
+			dt:   etl.SW,
+			want: etl.Destination{Project: "mlab-oti", Dataset: "tmp_utilization", Table: "switch"},
+		},
+		{
+			name: "staging-legacy",
+			env:  "staging",
+			dt:   etl.SS,
+			want: etl.Destination{Project: "mlab-staging", Dataset: "base_tables", Table: "sidestream"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			etl.Environment = tt.env
+			if diff := deep.Equal(tt.dt.Destination(), tt.want); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+
+	// Explicit overrides take precedence over the environment.
+	etl.Environment = "sandbox"
+	etl.BigqueryProject = "override_project"
+	etl.BigqueryDataset = "override"
+	want := "override_project.override.ndt7"
+	if got := etl.NDT7.Destination().String(); got != want {
+		t.Errorf("Destination() = %s, want %s", got, want)
+	}
+}
+
+func TestValidateEnvironment(t *testing.T) {
+	for _, env := range append(etl.Environments(), "") {
+		if err := etl.ValidateEnvironment(env); err != nil {
+			t.Errorf("ValidateEnvironment(%q) = %v", env, err)
+		}
+	}
+	if err := etl.ValidateEnvironment("prod"); err == nil {
+		t.Error("ValidateEnvironment(prod) expected error")
+	}
+}
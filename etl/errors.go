@@ -0,0 +1,53 @@
+package etl
+
+import "fmt"
+
+// ErrorKind classifies errors by the kind of failure, so that callers can
+// branch on it with errors.Is, while the underlying error is still available
+// to errors.Is and errors.As.
+type ErrorKind string
+
+// The main kinds of failure.
+const (
+	// ErrGCS is a failure to read or write GCS.
+	ErrGCS = ErrorKind("gcs")
+	// ErrBigQuery is a failure of a BigQuery job, or of reading or writing a
+	// BigQuery table.
+	ErrBigQuery = ErrorKind("bigquery")
+	// ErrPubSub is a failure to publish to a Pub/Sub topic.
+	ErrPubSub = ErrorKind("pubsub")
+	// ErrValidation is invalid input, e.g. a malformed archive path or config.
+	ErrValidation = ErrorKind("validation")
+)
+
+// Error implements error, so that an ErrorKind can be the target of errors.Is.
+func (k ErrorKind) Error() string {
+	return string(k) + " error"
+}
+
+// Errorf formats an error of the kind, as by fmt.Errorf, so that the
+// arguments may be wrapped with %w.
+func (k ErrorKind) Errorf(format string, a ...interface{}) error {
+	return &KindError{Kind: k, Err: fmt.Errorf(format, a...)}
+}
+
+// KindError is an error of an ErrorKind.
+type KindError struct {
+	Kind ErrorKind
+	Err  error
+}
+
+func (e *KindError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *KindError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the error's kind.
+func (e *KindError) Is(target error) bool {
+	k, ok := target.(ErrorKind)
+	return ok && k == e.Kind
+}
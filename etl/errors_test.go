@@ -0,0 +1,32 @@
+package etl_test
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/m-lab/etl/etl"
+)
+
+func TestErrorKind(t *testing.T) {
+	err := etl.ErrGCS.Errorf("reading %s: %w", "gs://bucket/a.tgz", io.ErrUnexpectedEOF)
+	if got := err.Error(); got != "reading gs://bucket/a.tgz: unexpected EOF" {
+		t.Errorf("Error() = %q", got)
+	}
+	if !errors.Is(err, etl.ErrGCS) || errors.Is(err, etl.ErrBigQuery) || errors.Is(err, etl.ErrValidation) {
+		t.Errorf("errors.Is(%v) matches the wrong kinds", err)
+	}
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("errors.Is(%v, io.ErrUnexpectedEOF) = false", err)
+	}
+	var ke *etl.KindError
+	if !errors.As(err, &ke) || ke.Kind != etl.ErrGCS {
+		t.Errorf("errors.As(%v) = %v", err, ke)
+	}
+
+	// The kind survives further wrapping.
+	wrapped := etl.ErrValidation.Errorf("task: %w", err)
+	if !errors.Is(wrapped, etl.ErrValidation) || !errors.Is(wrapped, etl.ErrGCS) {
+		t.Errorf("errors.Is(%v) lost a kind", wrapped)
+	}
+}
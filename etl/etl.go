@@ -135,6 +135,52 @@ type Parser interface {
 	RowStats // Parser must implement RowStats
 }
 
+// Abandoner is an optional interface for Parsers that can stop writing rows,
+// e.g. when the task gives up on a test that is still being parsed.
+type Abandoner interface {
+	// Abandon causes subsequent Put and Flush calls to discard rows instead of
+	// committing them.  It is safe to call concurrently with Put.
+	Abandon()
+}
+
+// ContextParser is an optional interface for Parsers that can stop parsing a
+// test when a context is done, e.g. because the test timed out.
+type ContextParser interface {
+	// ParseAndInsertContext is ParseAndInsert, but once ctx is done, it
+	// stops and returns ctx.Err(), without inserting any rows of the test.
+	ParseAndInsertContext(ctx context.Context, meta map[string]bigquery.Value, testName string, test []byte) error
+}
+
+// ConcurrentParser is an optional interface for Parsers whose ParseAndInsert
+// may be called concurrently for different tests of a task, e.g. because
+// each test is parsed without shared state other than the row.Base.
+type ConcurrentParser interface {
+	// ParsesConcurrently reports whether ParseAndInsert is safe to call
+	// concurrently.
+	ParsesConcurrently() bool
+}
+
+// GroupParser is an optional interface for Parsers of data types whose tests
+// span several files, e.g. the ndt c2s and s2c snaplogs and .meta file.  When
+// the task's source groups the files of each test, ParseGroup is called once
+// per test instead of calling ParseAndInsert for each file.
+type GroupParser interface {
+	// meta - metadata, e.g. from the original tar file name.
+	// files - contents of the test's files, keyed by file name.  Companion
+	// files missing from the archive are absent.
+	ParseGroup(meta map[string]bigquery.Value, files map[string][]byte) error
+}
+
+// RowCounter is an optional interface for Parsers that report how many rows
+// each test should produce.  This allows a test that legitimately produces no
+// rows to be distinguished from a test whose rows were dropped.
+type RowCounter interface {
+	// ExpectedRows returns the number of rows that the named test expected
+	// to emit, or -1 if it did not report a count.  Since the count is kept
+	// per test, it may be called for tests parsed concurrently.
+	ExpectedRows(testName string) int
+}
+
 // TestSource provides a source of test data.
 type TestSource interface {
 	// NextTest reads the next test object from the tar file.
@@ -149,6 +195,32 @@ type TestSource interface {
 	Date() civil.Date // Date associated with test source
 }
 
+// Peeker is an optional interface for TestSources that can report the next
+// test before reading its content, e.g. so that memory can be reserved for it.
+type Peeker interface {
+	// Peek returns the name and archived (possibly compressed) size of the
+	// test that the next call to NextTest will most likely return.  It returns
+	// false if that is not known, e.g. because reading the next header failed.
+	// Any error is returned by NextTest.
+	Peek() (name string, size int64, ok bool)
+}
+
+// ModTimer is an optional interface for TestSources that know when each test
+// file was last modified, e.g. from its tar header.
+type ModTimer interface {
+	// ModTime returns the modification time of the test most recently
+	// returned by NextTest, or the zero time if it is not known.
+	ModTime() time.Time
+}
+
+// FileSizer is an optional interface for TestSources that know the archived
+// size of each test file, e.g. from its tar header.
+type FileSizer interface {
+	// FileSize returns the size, in bytes, of the test most recently returned
+	// by NextTest, as recorded in the archive, or -1 if it is not known.
+	FileSize() int64
+}
+
 //========================================================================
 // Interface to allow fakes.
 //========================================================================
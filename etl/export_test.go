@@ -0,0 +1,13 @@
+package etl
+
+// UnregisterDataTypeForTest removes a data type added by RegisterDataType.
+func UnregisterDataTypeForTest(dt DataType) {
+	for dir, t := range dirToDataType {
+		if t == dt {
+			delete(dirToDataType, dir)
+		}
+	}
+	delete(dataTypeToTable, dt)
+	delete(dataTypeToBQBufferSize, dt)
+	delete(dataTypeToTestDate, dt)
+}
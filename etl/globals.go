@@ -3,11 +3,13 @@ package etl
 import (
 	"encoding/base64"
 	"errors"
-	"fmt"
 	"log"
 	"net"
 	"regexp"
 	"strings"
+	"time"
+
+	"cloud.google.com/go/civil"
 )
 
 // TODO: Eliminate these global variables using config or env struct.
@@ -26,6 +28,11 @@ var (
 
 	// BigqueryDataset overrides the default BQ dataset for output.
 	BigqueryDataset string
+
+	// Region, if set, is the location, e.g. "europe-west1", of the BigQuery
+	// jobs and datasets, and of the GCS buckets read and written, so that
+	// data does not cross regions.
+	Region string
 )
 
 var (
@@ -87,6 +94,10 @@ var (
 	sitePattern     = regexp.MustCompile(type2 + mlabNSiteNN)
 
 	justSitePattern = regexp.MustCompile(`.*` + mlabNSiteNN + `.*`)
+
+	// Patterns for the test date within the name of a test file.
+	testTimestampPattern = regexp.MustCompile(`(?:^|[-_.])(` + YYYYMMDD + `)T\d{2}`)
+	testDirPattern       = regexp.MustCompile(`(?:^|/)` + DatePathPattern)
 )
 
 // DataPath breaks out the components of a task filename.
@@ -111,20 +122,34 @@ type DataPath struct {
 	Suffix     string // the archive suffix, e.g. .tgz
 }
 
+// ErrBadExperimentDatatype is returned by SplitExperimentDatatype for a
+// datatype that is not experiment/datatype.
+var ErrBadExperimentDatatype = errors.New("datatype must be experiment/datatype")
+
+// SplitExperimentDatatype splits a datatype given as experiment/datatype,
+// e.g. "ndt/ndt7", as by the -datatype flags of the commands.
+func SplitExperimentDatatype(s string) (string, string, error) {
+	fields := strings.Split(s, "/")
+	if len(fields) != 2 || fields[0] == "" || fields[1] == "" {
+		return "", "", ErrValidation.Errorf("%w: %q", ErrBadExperimentDatatype, s)
+	}
+	return fields[0], fields[1], nil
+}
+
 // ValidateTestPath validates a task filename.
 func ValidateTestPath(uri string) (DataPath, error) {
 	basic := basicTaskPattern.FindStringSubmatch(uri)
 	if basic == nil {
-		return DataPath{}, errors.New("Path missing date-time string")
+		return DataPath{}, ErrValidation.Errorf("Path missing date-time string")
 	}
 	preamble := startPattern.FindStringSubmatch(basic[1])
 	if preamble == nil {
-		return DataPath{}, errors.New("Invalid preamble: " + fmt.Sprint(basic))
+		return DataPath{}, ErrValidation.Errorf("Invalid preamble: %v", basic)
 	}
 
 	post := endPattern.FindStringSubmatch(basic[5])
 	if post == nil {
-		return DataPath{}, errors.New("Invalid postamble: " + basic[5])
+		return DataPath{}, ErrValidation.Errorf("Invalid postamble: %s", basic[5])
 	}
 	dp := DataPath{
 		URI:        uri,
@@ -237,8 +262,11 @@ func NumberBitsDifferent(first string, second string) (int, int) {
 // DataType identifies the type of data handled by a parser.
 type DataType string
 
-// BQBufferSize returns the appropriate BQ insert buffer size.
+// BQBufferSize returns the initial BQ insert buffer size.
 func (dt DataType) BQBufferSize() int {
+	if size, ok := currentConfig().BufferSizes[dt]; ok {
+		return size
+	}
 	// Special case for NDT when omitting deltas.
 	if dt == NDT {
 		if OmitDeltas {
@@ -301,7 +329,8 @@ var (
 		INVALID:        "invalid",
 	}
 
-	// Map from data type to number of buffer size for BQ insertion.
+	// Map from data type to the initial buffer size for BQ insertion.  Parsers
+	// adapt the size to the observed row sizes, using row.NewAdaptiveBase.
 	// This matters more for the legacy parsing that used BQ streaming inserts.
 	// For the JSONL output in Gardener 2.0 operation, the buffer size doesn’t matter much,
 	// as everything is written to gcs files, and the gcs library does it’s own buffering.
@@ -328,8 +357,95 @@ var (
 	// It allows us process fewer archives when there is a very high volume of data.
 	// TODO - this should be loaded from a config.
 	dataTypeToSkipCount = map[DataType]int{}
+
+	// Map from data type to the maximum archive size, in bytes, that a worker
+	// will attempt to process.  Larger archives are rejected before streaming.
+	// Data types not listed use DefaultMaxArchiveSize.
+	// TODO - this should be loaded from a config.
+	dataTypeToMaxArchiveSize = map[DataType]int64{
+		PCAP:    8 << 30,
+		TCPINFO: 4 << 30,
+	}
+
+	// Map from data type to the function that extracts the measurement date
+	// from the name of a test file within an archive.  Data types not listed
+	// use the archive date.
+	dataTypeToTestDate = map[DataType]func(string) (civil.Date, bool){
+		NDT:            timestampDate, // 20170509T13:45:13.590210000Z_host:44160.s2c_snaplog.gz
+		SS:             timestampDate, // 20170315T01:00:00Z_173.205.3.39_0.web100
+		PT:             timestampDate, // 20170320T23:53:10Z-172.17.94.34-33456-74.125.224.100-33457.paris
+		NDT7:           timestampDate, // ndt7-download-20200318T000657.568382877Z.ndt-knwp4_1583603744_000000000000590E.json.gz
+		NDT5:           dirDate,       // 2021/07/22/ndt-4c6fb_1625899199_00000000013A4623.json
+		ANNOTATION:     dirDate,
+		HOPANNOTATION1: dirDate,
+		PCAP:           dirDate,
+		SCAMPER1:       dirDate,
+		TCPINFO:        dirDate,
+	}
 )
 
+// MaxTestDateLag is the largest number of days that the date of a test may
+// precede the date of its archive, e.g. because the archive was uploaded late.
+// Test dates outside this range are assumed to be wrong.
+const MaxTestDateLag = 30
+
+// timestampDate returns the date of a YYYYMMDDTHH timestamp at the start of the
+// file name, or following a - _ or . separator.
+func timestampDate(testname string) (civil.Date, bool) {
+	base := testname[strings.LastIndex(testname, "/")+1:]
+	m := testTimestampPattern.FindStringSubmatch(base)
+	if m == nil {
+		return civil.Date{}, false
+	}
+	t, err := time.Parse("20060102", m[1])
+	if err != nil {
+		return civil.Date{}, false
+	}
+	return civil.DateOf(t), true
+}
+
+// dirDate returns the date of a YYYY/MM/DD/ directory in the file name.
+func dirDate(testname string) (civil.Date, bool) {
+	m := testDirPattern.FindStringSubmatch(testname)
+	if m == nil {
+		return civil.Date{}, false
+	}
+	t, err := time.Parse("2006/01/02", m[1])
+	if err != nil {
+		return civil.Date{}, false
+	}
+	return civil.DateOf(t), true
+}
+
+// TestDate returns the measurement date of the named test from an archive
+// with the given date.  If the test file name includes a date, and it is no
+// later than the archive date, and no more than MaxTestDateLag days earlier,
+// that date is returned.  Otherwise the archive date is returned.
+func (dt DataType) TestDate(testname string, archive civil.Date) civil.Date {
+	f, ok := dataTypeToTestDate[dt]
+	if !ok {
+		return archive
+	}
+	d, ok := f(testname)
+	if !ok || archive.Before(d) || d.DaysSince(archive) < -MaxTestDateLag {
+		return archive
+	}
+	return d
+}
+
+// unixEpoch is the date from which DATE values are counted.
+var unixEpoch = civil.Date{Year: 1970, Month: 1, Day: 1}
+
+// EpochDays returns the number of days from 1970-01-01 to d, the encoding
+// of DATE values in Avro, Parquet and the BigQuery Storage Write API.
+func EpochDays(d civil.Date) int {
+	return d.DaysSince(unixEpoch)
+}
+
+// DefaultMaxArchiveSize is the archive size limit for data types without a
+// specific limit.
+const DefaultMaxArchiveSize = 2 << 30
+
 /*******************************************************************************
 *  TODO: These methods to compute the appropriate project and dataset are ugly.
 *  In not to distant future we need a better solution.
@@ -338,20 +454,42 @@ var (
 
 // DirToTablename translates gs dir to BQ tablename.
 func DirToTablename(dir string) string {
-	return dataTypeToTable[dirToDataType[dir]]
+	dt := dirToDataType[dir]
+	if table, ok := currentConfig().Tables[dt]; ok {
+		return table
+	}
+	return dataTypeToTable[dt]
 }
 
 // SkipCount returns the number of files to skip when processing each DataType.
 func (dt DataType) SkipCount() int {
+	if n, ok := currentConfig().SkipCounts[dt]; ok {
+		return n
+	}
 	return dataTypeToSkipCount[dt]
 }
 
+// MaxArchiveSize returns the largest archive size, in bytes, that should be
+// processed for the DataType.
+func (dt DataType) MaxArchiveSize() int64 {
+	if size, ok := currentConfig().MaxArchiveSizes[dt]; ok {
+		return size
+	}
+	if size, ok := dataTypeToMaxArchiveSize[dt]; ok {
+		return size
+	}
+	return DefaultMaxArchiveSize
+}
+
 // BigqueryProject returns the appropriate project.
 func (dt DataType) BigqueryProject() string {
 	project := BigqueryProject
 	if project != "" {
 		return project
 	}
+	if d, ok := dt.environmentDestination(); ok {
+		return d.Project
+	}
 	return GCloudProject
 }
 
@@ -362,6 +500,9 @@ func (dt DataType) Dataset() string {
 	if dataset != "" {
 		return dataset
 	}
+	if d, ok := dt.environmentDestination(); ok && !IsBatchService() {
+		return d.Dataset
+	}
 	if IsBatchService() {
 		return "batch"
 	}
@@ -369,8 +510,15 @@ func (dt DataType) Dataset() string {
 	return "base_tables"
 }
 
-// Table returns the appropriate table to use.
+// Table returns the appropriate table to use.  A table set in the Config takes
+// precedence over the Environment.
 func (dt DataType) Table() string {
+	if table, ok := currentConfig().Tables[dt]; ok {
+		return table
+	}
+	if d, ok := dt.environmentDestination(); ok {
+		return d.Table
+	}
 	return dataTypeToTable[dt]
 }
 
@@ -383,12 +531,12 @@ func GetFilename(filename string) (string, error) {
 
 	decode, err := base64.StdEncoding.DecodeString(filename)
 	if err != nil {
-		return "", errors.New("invalid file path: " + filename)
+		return "", ErrValidation.Errorf("invalid file path: %s", filename)
 	}
 	fn := string(decode[:])
 	if strings.HasPrefix(fn, "gs://") {
 		return fn, nil
 	}
 
-	return "", errors.New("invalid base64 encoded file path: " + fn)
+	return "", ErrValidation.Errorf("invalid base64 encoded file path: %s", fn)
 }
@@ -1,10 +1,12 @@
 package etl_test
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"testing"
 
+	"cloud.google.com/go/civil"
 	"github.com/go-test/deep"
 
 	"github.com/m-lab/etl/etl"
@@ -421,3 +423,119 @@ func TestSkipCount(t *testing.T) {
 		})
 	}
 }
+
+func TestMaxArchiveSize(t *testing.T) {
+	tests := []struct {
+		name     string
+		dataType etl.DataType
+		want     int64
+	}{
+		{
+			name:     "ndt7",
+			dataType: etl.NDT7,
+			want:     etl.DefaultMaxArchiveSize,
+		},
+		{
+			name:     "pcap",
+			dataType: etl.PCAP,
+			want:     8 << 30,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.dataType.MaxArchiveSize()
+			if got != tt.want {
+				t.Errorf("MaxArchiveSize() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDataType_TestDate(t *testing.T) {
+	archive := civil.Date{Year: 2020, Month: 3, Day: 18}
+	tests := []struct {
+		name     string
+		dataType etl.DataType
+		testname string
+		want     civil.Date
+	}{
+		{
+			name:     "sidestream-late-archive",
+			dataType: etl.SS,
+			testname: "20200315T01:00:00Z_173.205.3.39_0.web100",
+			want:     civil.Date{Year: 2020, Month: 3, Day: 15},
+		},
+		{
+			name:     "ndt7-separator",
+			dataType: etl.NDT7,
+			testname: "2020/03/17/ndt7-download-20200317T235957.568382877Z.ndt-knwp4_1583603744_000000000000590E.json.gz",
+			want:     civil.Date{Year: 2020, Month: 3, Day: 17},
+		},
+		{
+			name:     "ndt5-directory",
+			dataType: etl.NDT5,
+			testname: "2020/03/17/ndt-4c6fb_1625899199_00000000013A4623.json",
+			want:     civil.Date{Year: 2020, Month: 3, Day: 17},
+		},
+		{
+			name:     "no-date-in-name",
+			dataType: etl.NDT5,
+			testname: "ndt-4c6fb_1625899199_00000000013A4623.json",
+			want:     archive,
+		},
+		{
+			name:     "after-archive",
+			dataType: etl.SS,
+			testname: "20200319T01:00:00Z_173.205.3.39_0.web100",
+			want:     archive,
+		},
+		{
+			name:     "too-early",
+			dataType: etl.SS,
+			testname: "19700101T00:00:00Z_173.205.3.39_0.web100",
+			want:     archive,
+		},
+		{
+			name:     "no-extractor",
+			dataType: etl.SW,
+			testname: "2020/03/17/20200317T000000Z-switch.jsonl",
+			want:     archive,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.dataType.TestDate(tt.testname, archive); got != tt.want {
+				t.Errorf("TestDate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitExperimentDatatype(t *testing.T) {
+	exp, dt, err := etl.SplitExperimentDatatype("ndt/ndt7")
+	if exp != "ndt" || dt != "ndt7" || err != nil {
+		t.Errorf("SplitExperimentDatatype() = %q, %q, %v", exp, dt, err)
+	}
+	for _, bad := range []string{"", "ndt", "ndt/", "/ndt7", "ndt/ndt7/x"} {
+		_, _, err := etl.SplitExperimentDatatype(bad)
+		if !errors.Is(err, etl.ErrBadExperimentDatatype) || !errors.Is(err, etl.ErrValidation) {
+			t.Errorf("SplitExperimentDatatype(%q) error = %v, want %v", bad, err, etl.ErrBadExperimentDatatype)
+		}
+	}
+}
+
+func TestEpochDays(t *testing.T) {
+	for _, tc := range []struct {
+		d    civil.Date
+		want int
+	}{
+		{civil.Date{Year: 1970, Month: 1, Day: 1}, 0},
+		{civil.Date{Year: 1969, Month: 12, Day: 31}, -1},
+		{civil.Date{Year: 2022, Month: 6, Day: 1}, 19144},
+	} {
+		if got := etl.EpochDays(tc.d); got != tc.want {
+			t.Errorf("EpochDays(%v) = %d, want %d", tc.d, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package etl
+
+import "time"
+
+// Profile describes the resources that a task for a data type typically
+// needs, so that the worker can admit tasks by their expected cost rather
+// than treating every task alike.
+type Profile struct {
+	// CPUWeight is the number of worker slots that a task occupies.
+	CPUWeight int `json:"cpu_weight,omitempty"`
+	// ParseFactor is the approximate ratio of peak parse memory to the
+	// uncompressed size of a test.
+	ParseFactor int64 `json:"parse_factor,omitempty"`
+	// ExpectedSeconds is the typical duration of a task, in seconds.
+	ExpectedSeconds float64 `json:"expected_seconds,omitempty"`
+	// Parallelism is the number of tests of a task parsed concurrently, if
+	// the parser is an etl.ConcurrentParser.
+	Parallelism int `json:"parallelism,omitempty"`
+}
+
+// ExpectedDuration returns ExpectedSeconds as a time.Duration.
+func (p Profile) ExpectedDuration() time.Duration {
+	return time.Duration(p.ExpectedSeconds * float64(time.Second))
+}
+
+// merge returns p, with each zero field replaced by the field from base.
+func (p Profile) merge(base Profile) Profile {
+	if p.CPUWeight == 0 {
+		p.CPUWeight = base.CPUWeight
+	}
+	if p.ParseFactor == 0 {
+		p.ParseFactor = base.ParseFactor
+	}
+	if p.ExpectedSeconds == 0 {
+		p.ExpectedSeconds = base.ExpectedSeconds
+	}
+	if p.Parallelism == 0 {
+		p.Parallelism = base.Parallelism
+	}
+	return p
+}
+
+// defaultProfile is the profile for data types without a built-in profile, and
+// supplies the fields that a built-in profile leaves zero.
+var defaultProfile = Profile{CPUWeight: 1, ParseFactor: 3, ExpectedSeconds: 60, Parallelism: 1}
+
+// dataTypeToProfile maps from data type to its built-in profile.
+var dataTypeToProfile = map[DataType]Profile{
+	NDT:     {ParseFactor: 2, ExpectedSeconds: 300}, // The snaplog is parsed in place.
+	PCAP:    {CPUWeight: 2, ParseFactor: 2, ExpectedSeconds: 600},
+	SW:      {ParseFactor: 4},
+	TCPINFO: {ParseFactor: 3, ExpectedSeconds: 300},
+}
+
+// Profile returns the resource profile for the DataType.  Fields set in the
+// current Config take precedence over the built-in profile.
+func (dt DataType) Profile() Profile {
+	p := dataTypeToProfile[dt].merge(defaultProfile)
+	if c, ok := currentConfig().Profiles[dt]; ok {
+		p = c.merge(p)
+	}
+	return p
+}
@@ -0,0 +1,41 @@
+package etl_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/m-lab/etl/etl"
+)
+
+func TestProfile(t *testing.T) {
+	defer etl.SetConfig(nil)
+	tests := []struct {
+		name string
+		dt   etl.DataType
+		want etl.Profile
+	}{
+		{name: "default", dt: etl.NDT7, want: etl.Profile{CPUWeight: 1, ParseFactor: 3, ExpectedSeconds: 60, Parallelism: 1}},
+		{name: "built-in", dt: etl.PCAP, want: etl.Profile{CPUWeight: 2, ParseFactor: 2, ExpectedSeconds: 600, Parallelism: 1}},
+		{name: "partial", dt: etl.SW, want: etl.Profile{CPUWeight: 1, ParseFactor: 4, ExpectedSeconds: 60, Parallelism: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.dt.Profile(); got != tt.want {
+				t.Errorf("Profile() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	c, err := etl.ParseConfig([]byte(`{"profiles": {"pcap": {"cpu_weight": 4, "parallelism": 8}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	etl.SetConfig(c)
+	want := etl.Profile{CPUWeight: 4, ParseFactor: 2, ExpectedSeconds: 600, Parallelism: 8}
+	if got := etl.PCAP.Profile(); got != want {
+		t.Errorf("Profile() = %+v, want %+v", got, want)
+	}
+	if got := want.ExpectedDuration(); got != 10*time.Minute {
+		t.Errorf("ExpectedDuration() = %v, want 10m", got)
+	}
+}
@@ -0,0 +1,28 @@
+package etl
+
+import (
+	"context"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+	"google.golang.org/api/option"
+)
+
+// InRegion reports whether location, of a GCS bucket or BigQuery dataset, is
+// the Region, or no Region is set.  Locations are compared ignoring case,
+// since GCS reports them in upper case and BigQuery in lower case.
+func InRegion(location string) bool {
+	return Region == "" || strings.EqualFold(location, Region)
+}
+
+// NewBigQueryClient creates a BigQuery client for project.  If Region is set,
+// the client's query, copy and load jobs run there, instead of in the default
+// US location, which fails for datasets in other regions.
+func NewBigQueryClient(ctx context.Context, project string, opts ...option.ClientOption) (*bigquery.Client, error) {
+	client, err := bigquery.NewClient(ctx, project, opts...)
+	if err != nil {
+		return nil, err
+	}
+	client.Location = Region
+	return client, nil
+}
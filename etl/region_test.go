@@ -0,0 +1,44 @@
+package etl_test
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/api/option"
+
+	"github.com/m-lab/etl/etl"
+)
+
+func TestInRegion(t *testing.T) {
+	defer func() { etl.Region = "" }()
+	tests := []struct {
+		region   string
+		location string
+		want     bool
+	}{
+		{region: "", location: "US", want: true},
+		{region: "europe-west1", location: "EUROPE-WEST1", want: true},
+		{region: "europe-west1", location: "europe-west1", want: true},
+		{region: "europe-west1", location: "US", want: false},
+		{region: "EU", location: "europe-west1", want: false},
+	}
+	for _, tt := range tests {
+		etl.Region = tt.region
+		if got := etl.InRegion(tt.location); got != tt.want {
+			t.Errorf("InRegion(%q) with Region %q = %v, want %v", tt.location, tt.region, got, tt.want)
+		}
+	}
+}
+
+func TestNewBigQueryClient(t *testing.T) {
+	defer func() { etl.Region = "" }()
+	etl.Region = "europe-west1"
+	client, err := etl.NewBigQueryClient(context.Background(), "mlab-testing", option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if client.Location != "europe-west1" {
+		t.Errorf("NewBigQueryClient() Location = %q, want europe-west1", client.Location)
+	}
+}
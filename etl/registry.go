@@ -0,0 +1,55 @@
+package etl
+
+import (
+	"errors"
+	"fmt"
+
+	"cloud.google.com/go/civil"
+)
+
+// ErrDataTypeExists is returned by RegisterDataType for a data type or
+// directory that is already known.
+var ErrDataTypeExists = errors.New("data type already exists")
+
+// DataTypeInfo describes a data type added with RegisterDataType.
+type DataTypeInfo struct {
+	// Dirs lists the GCS subdirectories that hold archives of the data type,
+	// e.g. "ndt7" for gs://archive-measurement-lab/ndt/ndt7/...
+	Dirs []string
+	// Table is the BigQuery table name.  Empty means the data type name.
+	Table string
+	// BufferSize is the initial BQ insert buffer size.
+	BufferSize int
+	// TestDate extracts the measurement date from the name of a test file.
+	// Nil means the archive date is used.
+	TestDate func(testname string) (civil.Date, bool)
+}
+
+// RegisterDataType adds a data type, so that archives in its directories are
+// recognized, without editing the built-in tables in globals.go.  A parser for
+// the data type is registered separately, with parser.RegisterParser.
+//
+// RegisterDataType must only be called during initialization, e.g. from an
+// init function, since the data type tables are read without locking.
+func RegisterDataType(dt DataType, info DataTypeInfo) error {
+	if _, ok := dataTypeToTable[dt]; ok {
+		return fmt.Errorf("%w: %q", ErrDataTypeExists, dt)
+	}
+	for _, dir := range info.Dirs {
+		if _, ok := dirToDataType[dir]; ok {
+			return fmt.Errorf("%w: directory %q", ErrDataTypeExists, dir)
+		}
+	}
+	for _, dir := range info.Dirs {
+		dirToDataType[dir] = dt
+	}
+	dataTypeToTable[dt] = info.Table
+	if info.Table == "" {
+		dataTypeToTable[dt] = string(dt)
+	}
+	dataTypeToBQBufferSize[dt] = info.BufferSize
+	if info.TestDate != nil {
+		dataTypeToTestDate[dt] = info.TestDate
+	}
+	return nil
+}
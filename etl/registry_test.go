@@ -0,0 +1,59 @@
+package etl_test
+
+import (
+	"errors"
+	"testing"
+
+	"cloud.google.com/go/civil"
+
+	"github.com/m-lab/etl/etl"
+)
+
+func TestRegisterDataType(t *testing.T) {
+	dt := etl.DataType("registry_test")
+	archive := civil.Date{Year: 2022, Month: 7, Day: 4}
+	t.Cleanup(func() { etl.UnregisterDataTypeForTest(dt) })
+	err := etl.RegisterDataType(dt, etl.DataTypeInfo{
+		Dirs:       []string{"registry-test", "registry-test-v2"},
+		BufferSize: 7,
+		TestDate: func(string) (civil.Date, bool) {
+			return civil.Date{Year: 2022, Month: 7, Day: 3}, true
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dp, err := etl.ValidateTestPath(`gs://archive-mlab-oti/foo/registry-test-v2/2022/07/04/20220704T000002Z-registry-test-mlab1-bom01-foo-0000.tgz`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := dp.GetDataType(); got != dt {
+		t.Errorf("GetDataType() = %q, want %q", got, dt)
+	}
+	if got := dt.Table(); got != "registry_test" {
+		t.Errorf("Table() = %q, want registry_test", got)
+	}
+	if got := etl.DirToTablename("registry-test"); got != "registry_test" {
+		t.Errorf("DirToTablename() = %q, want registry_test", got)
+	}
+	if got := dt.BQBufferSize(); got != 7 {
+		t.Errorf("BQBufferSize() = %d, want 7", got)
+	}
+	if got := dt.TestDate("test", archive); got != archive.AddDays(-1) {
+		t.Errorf("TestDate() = %v, want %v", got, archive.AddDays(-1))
+	}
+
+	// Registered data types may be configured like the built-in ones.
+	if _, err := etl.ParseConfig([]byte(`{"buffer_sizes": {"registry_test": 10}}`)); err != nil {
+		t.Errorf("ParseConfig() = %v", err)
+	}
+
+	err = etl.RegisterDataType(dt, etl.DataTypeInfo{})
+	if !errors.Is(err, etl.ErrDataTypeExists) {
+		t.Errorf("RegisterDataType() = %v, want %v", err, etl.ErrDataTypeExists)
+	}
+	err = etl.RegisterDataType("registry_test_2", etl.DataTypeInfo{Dirs: []string{"ndt7"}})
+	if !errors.Is(err, etl.ErrDataTypeExists) {
+		t.Errorf("RegisterDataType() = %v, want %v", err, etl.ErrDataTypeExists)
+	}
+}
@@ -0,0 +1,71 @@
+package etl
+
+import (
+	"time"
+
+	"cloud.google.com/go/civil"
+)
+
+// SuffixStrategy selects the table name suffix for the rows of each date, so
+// that the parsers and sinks agree on where rows are written.
+type SuffixStrategy string
+
+const (
+	// NoSuffix writes rows to the base table.  The zero SuffixStrategy is
+	// treated as NoSuffix.
+	NoSuffix = SuffixStrategy("none")
+	// TemplateSuffix writes rows to "_YYYYMMDD" template tables.
+	TemplateSuffix = SuffixStrategy("template")
+	// PartitionSuffix writes rows to "$YYYYMMDD" partitions.
+	PartitionSuffix = SuffixStrategy("partition")
+	// ModeSuffix uses TemplateSuffix in batch mode, where template tables
+	// are later deduplicated and copied into the final partitions, and
+	// PartitionSuffix otherwise.
+	ModeSuffix = SuffixStrategy("mode")
+)
+
+// SuffixStrategies returns the names of all SuffixStrategies.
+func SuffixStrategies() []string {
+	return []string{string(NoSuffix), string(TemplateSuffix), string(PartitionSuffix), string(ModeSuffix)}
+}
+
+// valid reports whether s is a known SuffixStrategy.
+func (s SuffixStrategy) valid() bool {
+	for _, name := range SuffixStrategies() {
+		if string(s) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Suffix returns the table suffix, or partition decorator, for rows of the
+// date.
+func (s SuffixStrategy) Suffix(d civil.Date) string {
+	switch s {
+	case TemplateSuffix:
+		return "_" + packedDate(d)
+	case PartitionSuffix:
+		return "$" + packedDate(d)
+	case ModeSuffix:
+		if IsBatchService() {
+			return TemplateSuffix.Suffix(d)
+		}
+		return PartitionSuffix.Suffix(d)
+	default:
+		return ""
+	}
+}
+
+func packedDate(d civil.Date) string {
+	return d.In(time.UTC).Format("20060102")
+}
+
+// SuffixStrategy returns the SuffixStrategy for the DataType in the current
+// Config, or def if the Config does not set one.
+func (dt DataType) SuffixStrategy(def SuffixStrategy) SuffixStrategy {
+	if s, ok := currentConfig().Suffixes[dt]; ok {
+		return s
+	}
+	return def
+}
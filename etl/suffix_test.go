@@ -0,0 +1,48 @@
+package etl_test
+
+import (
+	"testing"
+
+	"cloud.google.com/go/civil"
+
+	"github.com/m-lab/etl/etl"
+)
+
+func TestSuffixStrategy_Suffix(t *testing.T) {
+	defer func() { etl.IsBatch = false }()
+	d := civil.Date{Year: 2022, Month: 1, Day: 2}
+	tests := []struct {
+		s     etl.SuffixStrategy
+		batch bool
+		want  string
+	}{
+		{s: "", want: ""},
+		{s: etl.NoSuffix, want: ""},
+		{s: etl.TemplateSuffix, want: "_20220102"},
+		{s: etl.PartitionSuffix, want: "$20220102"},
+		{s: etl.ModeSuffix, batch: true, want: "_20220102"},
+		{s: etl.ModeSuffix, want: "$20220102"},
+	}
+	for _, tt := range tests {
+		etl.IsBatch = tt.batch
+		if got := tt.s.Suffix(d); got != tt.want {
+			t.Errorf("%q.Suffix() with batch %t = %q, want %q", tt.s, tt.batch, got, tt.want)
+		}
+	}
+}
+
+func TestDataType_SuffixStrategy(t *testing.T) {
+	defer etl.SetConfig(nil)
+	c, err := etl.ParseConfig([]byte(`{"suffixes": {"ndt7": "partition"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	etl.SetConfig(c)
+	if got := etl.NDT7.SuffixStrategy(etl.TemplateSuffix); got != etl.PartitionSuffix {
+		t.Errorf("SuffixStrategy() = %q, want %q", got, etl.PartitionSuffix)
+	}
+	// Data types missing from the config use the default.
+	if got := etl.PCAP.SuffixStrategy(etl.TemplateSuffix); got != etl.TemplateSuffix {
+		t.Errorf("SuffixStrategy() = %q, want %q", got, etl.TemplateSuffix)
+	}
+}
@@ -27,6 +27,12 @@ func (pe processingError) Code() int {
 	return pe.code
 }
 
+// Unwrap returns the underlying error, so that errors.Is and errors.As can
+// match it, e.g. by its etl.ErrorKind.
+func (pe processingError) Unwrap() error {
+	return pe.error
+}
+
 // NewError creates a new ProcessingError.
 func NewError(dt, detail string, code int, err error) etl.ProcessingError {
 	return processingError{dt, detail, code, err}
@@ -0,0 +1,211 @@
+// Package fixtures fetches large test archives from a public GCS bucket on
+// demand, so that parser tests can use many more fixtures without adding them
+// to git.  Each fixture is pinned by its SHA256 checksum in manifest.json, and
+// is downloaded at most once into a local cache directory.
+//
+// To add a fixture, upload it to the public bucket, and add an entry to
+// manifest.json with its name, URL, size, and checksum from
+//
+//	sha256sum <file>
+//
+// Tests then get the local path to the fixture with
+//
+//	path := fixtures.Path(t, "ndt7/20220701T000000.000000Z-ndt7-mlab1-foo01-ndt.tgz")
+//
+// The cache directory is $ETL_FIXTURES_DIR if set, or etl-fixtures in the
+// user cache directory.
+package fixtures
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	_ "embed" // For the default manifest.
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Errors returned by fixture functions.
+var (
+	ErrUnknownFixture = errors.New("unknown fixture")
+	ErrChecksum       = errors.New("fixture checksum mismatch")
+)
+
+// DirEnv is the environment variable that overrides the cache directory.
+const DirEnv = "ETL_FIXTURES_DIR"
+
+// fetchTimeout limits the time to download a single fixture in Path.
+const fetchTimeout = 10 * time.Minute
+
+//go:embed manifest.json
+var manifestJSON []byte
+
+// Fixture describes a test archive stored in GCS.
+type Fixture struct {
+	Name   string `json:"name"`   // Name used by tests, e.g. pcap/foo.tgz.
+	URL    string `json:"url"`    // gs:// or https:// URL of the archive.
+	Size   int64  `json:"size"`   // Size in bytes, for information only.
+	SHA256 string `json:"sha256"` // Hex encoded checksum of the content.
+}
+
+// httpURL returns the URL for fetching the fixture over HTTP.  Objects in
+// public buckets are available without credentials from storage.googleapis.com.
+func (f Fixture) httpURL() string {
+	if strings.HasPrefix(f.URL, "gs://") {
+		return "https://storage.googleapis.com/" + strings.TrimPrefix(f.URL, "gs://")
+	}
+	return f.URL
+}
+
+// Manifest maps fixture names to Fixtures.
+type Manifest map[string]Fixture
+
+// ReadManifest reads a manifest in the manifest.json format.
+func ReadManifest(r io.Reader) (Manifest, error) {
+	var file struct {
+		Fixtures []Fixture `json:"fixtures"`
+	}
+	if err := json.NewDecoder(r).Decode(&file); err != nil {
+		return nil, err
+	}
+	m := Manifest{}
+	for _, f := range file.Fixtures {
+		if f.Name == "" || f.URL == "" || len(f.SHA256) != 2*sha256.Size {
+			return nil, fmt.Errorf("invalid fixture %+v", f)
+		}
+		if _, dup := m[f.Name]; dup {
+			return nil, fmt.Errorf("duplicate fixture %s", f.Name)
+		}
+		m[f.Name] = f
+	}
+	return m, nil
+}
+
+// DefaultManifest returns the manifest committed with this package.
+func DefaultManifest() (Manifest, error) {
+	return ReadManifest(bytes.NewReader(manifestJSON))
+}
+
+// DefaultDir returns the default cache directory.
+func DefaultDir() string {
+	if dir := os.Getenv(DirEnv); dir != "" {
+		return dir
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "etl-fixtures")
+}
+
+// Cache downloads fixtures into a local directory.  Fixtures are stored by
+// checksum, so a cached fixture is never stale, and fixtures with the same
+// content are only downloaded once.
+type Cache struct {
+	Dir    string
+	Client *http.Client
+}
+
+// NewCache creates a Cache in the directory, or DefaultDir if dir is empty.
+func NewCache(dir string) *Cache {
+	if dir == "" {
+		dir = DefaultDir()
+	}
+	return &Cache{Dir: dir, Client: http.DefaultClient}
+}
+
+// pathFor returns the local path for the fixture, which keeps the base name
+// of the fixture, as parsers may depend on it.
+func (c *Cache) pathFor(f Fixture) string {
+	return filepath.Join(c.Dir, strings.ToLower(f.SHA256), path.Base(f.Name))
+}
+
+// Fetch returns the local path to the fixture, downloading it if it is not
+// already cached.  The download is verified against the pinned checksum
+// before it is added to the cache.
+func (c *Cache) Fetch(ctx context.Context, f Fixture) (string, error) {
+	local := c.pathFor(f)
+	if _, err := os.Stat(local); err == nil {
+		return local, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.httpURL(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetching %s: %s", f.URL, resp.Status)
+	}
+
+	// Download to a temporary file in the same directory, so that the
+	// rename is atomic, and concurrent test binaries never see partial files.
+	tmp, err := ioutil.TempFile(filepath.Dir(local), ".download-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name()) // Fails harmlessly after the rename.
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, h), resp.Body)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(got, f.SHA256) {
+		return "", fmt.Errorf("%w: %s has sha256 %s, want %s", ErrChecksum, f.Name, got, f.SHA256)
+	}
+	if err := os.Rename(tmp.Name(), local); err != nil {
+		return "", err
+	}
+	return local, nil
+}
+
+// Path returns the local path to the named fixture from the default manifest,
+// downloading it if necessary.  The test is skipped in -short mode or if the
+// fixture cannot be downloaded, e.g. when offline, but fails if the fixture
+// is unknown or does not match its checksum.
+func Path(t testing.TB, name string) string {
+	t.Helper()
+	m, err := DefaultManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, ok := m[name]
+	if !ok {
+		t.Fatalf("%v: %s", ErrUnknownFixture, name)
+	}
+	c := NewCache("")
+	if _, err := os.Stat(c.pathFor(f)); err != nil && testing.Short() {
+		t.Skip("skipping fixture download in short mode:", name)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+	local, err := c.Fetch(ctx, f)
+	if errors.Is(err, ErrChecksum) {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Skip("fixture unavailable:", err)
+	}
+	return local
+}
@@ -0,0 +1,109 @@
+package fixtures_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/m-lab/etl/fixtures"
+)
+
+func TestDefaultManifest(t *testing.T) {
+	if _, err := fixtures.DefaultManifest(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadManifest(t *testing.T) {
+	sum := strings.Repeat("ab", 32)
+	tests := []struct {
+		name    string
+		json    string
+		want    int
+		wantErr bool
+	}{
+		{"empty", `{"fixtures": []}`, 0, false},
+		{"valid", `{"fixtures": [{"name": "a.tgz", "url": "gs://b/a.tgz", "sha256": "` + sum + `"}]}`, 1, false},
+		{"bad-sum", `{"fixtures": [{"name": "a.tgz", "url": "gs://b/a.tgz", "sha256": "abc"}]}`, 0, true},
+		{"no-url", `{"fixtures": [{"name": "a.tgz", "sha256": "` + sum + `"}]}`, 0, true},
+		{"duplicate", `{"fixtures": [{"name": "a.tgz", "url": "gs://b/a.tgz", "sha256": "` + sum + `"},
+			{"name": "a.tgz", "url": "gs://b/b.tgz", "sha256": "` + sum + `"}]}`, 0, true},
+		{"bad-json", `{`, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := fixtures.ReadManifest(strings.NewReader(tt.json))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadManifest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(m) != tt.want {
+				t.Errorf("ReadManifest() = %d fixtures, want %d", len(m), tt.want)
+			}
+		})
+	}
+}
+
+func TestCache_Fetch(t *testing.T) {
+	content := "fixture content"
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		requests++
+		if req.URL.Path != "/bucket/pcap/foo.tgz" {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		rw.Write([]byte(content))
+	}))
+	defer srv.Close()
+
+	sum := sha256.Sum256([]byte(content))
+	f := fixtures.Fixture{
+		Name:   "pcap/foo.tgz",
+		URL:    srv.URL + "/bucket/pcap/foo.tgz",
+		SHA256: hex.EncodeToString(sum[:]),
+	}
+	c := fixtures.NewCache(t.TempDir())
+	c.Client = srv.Client()
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		local, err := c.Fetch(ctx, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if filepath.Base(local) != "foo.tgz" {
+			t.Errorf("Fetch() = %s, want base name foo.tgz", local)
+		}
+		got, err := ioutil.ReadFile(local)
+		if err != nil || string(got) != content {
+			t.Errorf("Fetch() content = %q, %v, want %q", got, err, content)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("Fetch() made %d requests, want 1 with caching", requests)
+	}
+
+	bad := f
+	bad.SHA256 = strings.Repeat("00", 32)
+	if _, err := c.Fetch(ctx, bad); !errors.Is(err, fixtures.ErrChecksum) {
+		t.Errorf("Fetch() error = %v, want %v", err, fixtures.ErrChecksum)
+	}
+	// Mismatched downloads are not cached.
+	if _, err := c.Fetch(ctx, bad); !errors.Is(err, fixtures.ErrChecksum) {
+		t.Errorf("Fetch() error = %v, want %v", err, fixtures.ErrChecksum)
+	}
+
+	missing := f
+	missing.URL = srv.URL + "/bucket/missing.tgz"
+	missing.SHA256 = strings.Repeat("11", 32)
+	if _, err := c.Fetch(ctx, missing); err == nil {
+		t.Error("Fetch() expected error for missing fixture")
+	}
+}
@@ -149,6 +149,22 @@ var (
 		// Output bigquery base table name, e.g. "ndt".
 		[]string{"table"})
 
+	// ProfiledLoad is the load of the active workers according to the
+	// resource profiles of their data types, i.e. the sum of their CPU
+	// weights, and of their expected durations in seconds.  Autoscaling can
+	// use it as a measure of work that accounts for costly data types.
+	//
+	// Provides metrics:
+	//   etl_profiled_load{datatype, resource}
+	// Example usage:
+	//   metrics.ProfiledLoad.WithLabelValues("pcap", "cpu_weight").Add(2)
+	ProfiledLoad = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "etl_profiled_load",
+			Help: "Profiled CPU weight and expected seconds of active workers.",
+		},
+		[]string{"datatype", "resource"})
+
 	// WorkerState counts the number of workers in each worker state..
 	//
 	// Provides metrics:
@@ -583,6 +599,132 @@ var (
 		},
 		[]string{"port"},
 	)
+
+	// MemoryPressureCount counts the checks that found memory use above the
+	// flush threshold, and requested early flushes of buffered rows.
+	// Provides metrics:
+	//    etl_memory_pressure_total
+	// Example usage:
+	//    metrics.MemoryPressureCount.Inc()
+	MemoryPressureCount = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "etl_memory_pressure_total",
+			Help: "Number of times memory use exceeded the flush threshold.",
+		})
+
+	// TaskRowCount counts the rows expected and emitted by the tests of
+	// completed tasks, as reported in task.Summary, for completeness checks.
+	// Only tests whose parser reports an expected row count are included.
+	// Provides metrics:
+	//    etl_task_row_total{datatype, kind}
+	// Example usage:
+	//    metrics.TaskRowCount.WithLabelValues("ndt5", "expected").Add(10)
+	TaskRowCount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "etl_task_row_total",
+			Help: "Rows expected, emitted, and dropped by the tests of each task.",
+		}, []string{"datatype", "kind"})
+
+	// EmptyArchiveCount counts completed tasks whose archive held no tests,
+	// by kind: "empty" for archives with no files at all, and
+	// "directories_only" for archives whose files held no data.
+	// Provides metrics:
+	//    etl_empty_archive_total{datatype, kind}
+	// Example usage:
+	//    metrics.EmptyArchiveCount.WithLabelValues("ndt5", "empty").Inc()
+	EmptyArchiveCount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "etl_empty_archive_total",
+			Help: "Number of tasks whose archive was empty, or held only directories.",
+		}, []string{"datatype", "kind"})
+
+	// RowReconcileCount counts tasks whose parser, sink, and expected row
+	// counts disagree at the end of the task, by kind of mismatch.  See
+	// task.Reconciliation.
+	// Provides metrics:
+	//    etl_row_reconcile_mismatch_total{datatype, kind}
+	// Example usage:
+	//    metrics.RowReconcileCount.WithLabelValues("ndt5", "sink").Inc()
+	RowReconcileCount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "etl_row_reconcile_mismatch_total",
+			Help: "Tasks whose row counts disagree at the end of the task, by kind.",
+		}, []string{"datatype", "kind"})
+
+	// PressureFlushCount counts the buffers committed early because of memory
+	// pressure.
+	// Provides metrics:
+	//    etl_pressure_flush_total{table}
+	// Example usage:
+	//    metrics.PressureFlushCount.WithLabelValues("ndt").Inc()
+	PressureFlushCount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "etl_pressure_flush_total",
+			Help: "Number of row buffers committed early because of memory pressure.",
+		}, []string{"table"})
+
+	// DeadLetterCount counts the tests that failed to parse and were written
+	// to the dead letter location, by whether the write succeeded.
+	// Provides metrics:
+	//    etl_dead_letter_total{datatype, status}
+	// Example usage:
+	//    metrics.DeadLetterCount.WithLabelValues("tcpinfo", "ok").Inc()
+	DeadLetterCount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "etl_dead_letter_total",
+			Help: "Number of tests that failed to parse, written to the dead letter location.",
+		}, []string{"datatype", "status"})
+
+	// ConfigReloadCount counts attempts to reload the per data type config,
+	// by outcome.
+	// Provides metrics:
+	//    etl_config_reload_total{status}
+	// Example usage:
+	//    metrics.ConfigReloadCount.WithLabelValues("ok").Inc()
+	ConfigReloadCount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "etl_config_reload_total",
+			Help: "Number of config reloads, by outcome.",
+		}, []string{"status"})
+
+	// WarmupCount counts the clients constructed and validated by the
+	// startup warm-up, by outcome.
+	// Provides metrics:
+	//    etl_warmup_total{client, status}
+	// Example usage:
+	//    metrics.WarmupCount.WithLabelValues("gcs", "ok").Inc()
+	WarmupCount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "etl_warmup_total",
+			Help: "Number of clients warmed up at startup, by outcome.",
+		}, []string{"client", "status"})
+
+	// CommitStageHistogram provides a histogram of the time each batch of
+	// rows spends in each stage of the commit path, to show whether commit
+	// latency comes from the buffering policy or from the sink.  The stages
+	// are "buffered", the age of the oldest row when the batch leaves the
+	// buffer, "pending", from leaving the buffer to the start of the sink
+	// call, and "sink", the duration of the sink call.
+	//
+	// Provides metrics:
+	//   etl_commit_stage_seconds_bucket{table="...", stage="...", le="..."}
+	//   ...
+	//   etl_commit_stage_seconds_sum{table="...", stage="..."}
+	//   etl_commit_stage_seconds_count{table="...", stage="..."}
+	// Usage example:
+	//   metrics.CommitStageHistogram.WithLabelValues(
+	//           "ndt", "sink").Observe(time.Since(t).Seconds())
+	CommitStageHistogram = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "etl_commit_stage_seconds",
+			Help: "Time spent by row batches in each commit stage.",
+			Buckets: []float64{
+				.001, .002, .005, .01, .02, .05, .1, .2, .5,
+				1, 2, 5, 10, 20, 50, 100, 200, 500, 1000,
+			},
+		},
+		[]string{"table", "stage"},
+	)
 )
 
 // catchStatus wraps the native http.ResponseWriter and captures any written HTTP
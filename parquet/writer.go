@@ -0,0 +1,388 @@
+// Package parquet writes rows to Apache Parquet files, with the Parquet
+// schema derived from the BigQuery schema of the rows, so that parser output
+// can be analyzed with tools such as Spark or DuckDB, without BigQuery.
+//
+// Rows are matched to the schema and split into columns here, and the file
+// itself is encoded by github.com/xitongsys/parquet-go, with gzip compressed
+// pages.
+package parquet
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+	"github.com/xitongsys/parquet-go/common"
+	"github.com/xitongsys/parquet-go/layout"
+	pq "github.com/xitongsys/parquet-go/parquet"
+	pqschema "github.com/xitongsys/parquet-go/schema"
+	"github.com/xitongsys/parquet-go/writer"
+
+	"github.com/m-lab/etl/etl"
+)
+
+// DefaultRowGroupBytes is the default size of the values buffered for each
+// row group.
+const DefaultRowGroupBytes = 16 << 20
+
+// Errors of NewWriter and Write.
+var (
+	ErrNoSchema   = errors.New("schema has no fields")
+	ErrInvalidRow = errors.New("row does not match schema")
+)
+
+// node is a field of the schema.
+type node struct {
+	field    *bigquery.FieldSchema
+	key      string // Lower case name, matched to the JSON keys of rows.
+	rep      pq.FieldRepetitionType
+	def      int32 // Definition level of the field when it is present.
+	level    int32 // Repetition level of the field's repeated values.
+	children []*node
+	elem     *pq.SchemaElement
+	index    int32  // Of elem, in the schema of the file.
+	path     string // Of the column of a leaf field, in the SchemaHandler.
+}
+
+// columns are the values and levels of the leaf fields of a row, by path.
+type columns map[string]*layout.Table
+
+// Writer writes rows to a Parquet file.
+type Writer struct {
+	pw *writer.ParquetWriter
+
+	// RowGroupBytes is the size of the values buffered before they are
+	// written as a row group.
+	RowGroupBytes int
+
+	fields   []*node
+	leaves   []*node
+	rows     int
+	buffered int // Bytes of the values of the current row group.
+}
+
+// NewWriter creates a Writer for rows of the schema, and writes the header of
+// the file to w.
+func NewWriter(w io.Writer, schema bigquery.Schema) (*Writer, error) {
+	if len(schema) == 0 {
+		return nil, ErrNoSchema
+	}
+	pw := &Writer{RowGroupBytes: DefaultRowGroupBytes}
+	root := &pq.SchemaElement{Name: "schema", RepetitionType: pq.FieldRepetitionTypePtr(pq.FieldRepetitionType_REQUIRED)}
+	elems := []*pq.SchemaElement{root}
+	var err error
+	pw.fields, err = pw.nodes(schema, &elems, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	root.NumChildren = int32Ptr(int32(len(pw.fields)))
+
+	// Each row is split into columns by Write, so the ParquetWriter only
+	// has to merge them.
+	pw.pw, err = writer.NewParquetWriterFromWriter(w, elems, 1)
+	if err != nil {
+		return nil, err
+	}
+	pw.pw.MarshalFunc = merge
+	pw.pw.CompressionType = pq.CompressionCodec_GZIP
+	createdBy := "github.com/m-lab/etl"
+	pw.pw.Footer.CreatedBy = &createdBy
+	for _, n := range pw.leaves {
+		n.path = pw.pw.SchemaHandler.IndexMap[n.index]
+	}
+	return pw, nil
+}
+
+func int32Ptr(v int32) *int32 {
+	return &v
+}
+
+// nodes returns the nodes of the fields, whose parent has the levels, and
+// adds their SchemaElements, depth first, to elems.
+func (pw *Writer) nodes(schema bigquery.Schema, elems *[]*pq.SchemaElement, def, level int32) ([]*node, error) {
+	if len(schema) == 0 {
+		return nil, ErrNoSchema
+	}
+	nodes := make([]*node, 0, len(schema))
+	for _, f := range schema {
+		n := &node{field: f, key: strings.ToLower(f.Name), def: def, level: level}
+		switch {
+		case f.Repeated:
+			n.rep = pq.FieldRepetitionType_REPEATED
+			n.def++
+			n.level++
+		case f.Required:
+			n.rep = pq.FieldRepetitionType_REQUIRED
+		default:
+			n.rep = pq.FieldRepetitionType_OPTIONAL
+			n.def++
+		}
+		n.elem = &pq.SchemaElement{Name: f.Name, RepetitionType: pq.FieldRepetitionTypePtr(n.rep)}
+		n.index = int32(len(*elems))
+		*elems = append(*elems, n.elem)
+		nodes = append(nodes, n)
+		var typ pq.Type
+		var converted *pq.ConvertedType
+		switch f.Type {
+		case bigquery.RecordFieldType:
+			children, err := pw.nodes(f.Schema, elems, n.def, n.level)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", f.Name, err)
+			}
+			n.children = children
+			n.elem.NumChildren = int32Ptr(int32(len(children)))
+			continue
+		case bigquery.BytesFieldType:
+			typ = pq.Type_BYTE_ARRAY
+		case bigquery.IntegerFieldType:
+			typ = pq.Type_INT64
+		case bigquery.FloatFieldType:
+			typ = pq.Type_DOUBLE
+		case bigquery.BooleanFieldType:
+			typ = pq.Type_BOOLEAN
+		case bigquery.TimestampFieldType:
+			typ, converted = pq.Type_INT64, pq.ConvertedTypePtr(pq.ConvertedType_TIMESTAMP_MICROS)
+		case bigquery.DateFieldType:
+			typ, converted = pq.Type_INT32, pq.ConvertedTypePtr(pq.ConvertedType_DATE)
+		default:
+			// Strings, and the types without a Parquet equivalent, such as
+			// NUMERIC or GEOGRAPHY, are written as their text.
+			typ, converted = pq.Type_BYTE_ARRAY, pq.ConvertedTypePtr(pq.ConvertedType_UTF8)
+		}
+		n.elem.Type = pq.TypePtr(typ)
+		n.elem.ConvertedType = converted
+		pw.leaves = append(pw.leaves, n)
+	}
+	return nodes, nil
+}
+
+// merge implements the MarshalFunc of the ParquetWriter, for rows that are
+// already split into columns.
+func merge(rows []interface{}, sh *pqschema.SchemaHandler) (*map[string]*layout.Table, error) {
+	res := make(map[string]*layout.Table)
+	for _, r := range rows {
+		for path, t := range r.(columns) {
+			if m, ok := res[path]; ok {
+				m.Merge(t)
+			} else {
+				res[path] = t
+			}
+		}
+	}
+	return &res, nil
+}
+
+// Write adds a row to the file.  The row is encoded as JSON, and the keys of
+// the JSON object are matched to the schema ignoring case, like BigQuery
+// column names.  Keys with non-null values that are not in the schema are an
+// error, so that no data is lost.  A row that cannot be encoded is not added,
+// and the error wraps ErrInvalidRow.  Other errors are from the underlying
+// io.Writer, after which the file is incomplete.
+func (pw *Writer) Write(r interface{}) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRow, err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v map[string]interface{}
+	if err := dec.Decode(&v); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRow, err)
+	}
+	cols := make(columns, len(pw.leaves))
+	for _, n := range pw.leaves {
+		cols[n.path] = &layout.Table{
+			RepetitionType:     n.rep,
+			Schema:             n.elem,
+			Path:               common.StrToPath(n.path),
+			MaxDefinitionLevel: n.def,
+			MaxRepetitionLevel: n.level,
+			Info:               pw.pw.SchemaHandler.Infos[n.index],
+		}
+	}
+	size, err := writeFields(pw.fields, cols, v, 0)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRow, err)
+	}
+	if err := pw.pw.Write(cols); err != nil {
+		return err
+	}
+	pw.rows++
+	pw.buffered += size
+	if pw.buffered >= pw.RowGroupBytes {
+		pw.buffered = 0
+		return pw.pw.Flush(true)
+	}
+	return nil
+}
+
+// writeFields splits the JSON object v into the columns of the fields, at
+// the repetition level r, and returns the size of the values.
+func writeFields(fields []*node, cols columns, v map[string]interface{}, r int32) (int, error) {
+	values := make(map[string]interface{}, len(v))
+	for k, x := range v {
+		if x != nil {
+			values[strings.ToLower(k)] = x
+		}
+	}
+	size := 0
+	for _, n := range fields {
+		x := values[n.key]
+		delete(values, n.key)
+		s, err := n.write(cols, x, r)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", n.field.Name, err)
+		}
+		size += s
+	}
+	if len(values) > 0 {
+		unknown := make([]string, 0, len(values))
+		for k := range values {
+			unknown = append(unknown, k)
+		}
+		sort.Strings(unknown)
+		return 0, fmt.Errorf("no such fields: %s", strings.Join(unknown, ", "))
+	}
+	return size, nil
+}
+
+// write splits the JSON value x of the field into columns, at the
+// repetition level r.
+func (n *node) write(cols columns, x interface{}, r int32) (int, error) {
+	switch {
+	case n.rep == pq.FieldRepetitionType_REPEATED:
+		if x == nil {
+			n.null(cols, r, n.def-1)
+			return 0, nil
+		}
+		xs, ok := x.([]interface{})
+		if !ok {
+			return 0, fmt.Errorf("%T is not an array", x)
+		}
+		if len(xs) == 0 {
+			n.null(cols, r, n.def-1)
+			return 0, nil
+		}
+		size := 0
+		for i, e := range xs {
+			if i > 0 {
+				r = n.level
+			}
+			if e == nil {
+				return 0, errors.New("arrays cannot contain nulls")
+			}
+			s, err := n.value(cols, e, r)
+			if err != nil {
+				return 0, err
+			}
+			size += s
+		}
+		return size, nil
+	case x == nil:
+		if n.rep == pq.FieldRepetitionType_REQUIRED {
+			return 0, errors.New("required field is missing")
+		}
+		n.null(cols, r, n.def-1)
+		return 0, nil
+	default:
+		return n.value(cols, x, r)
+	}
+}
+
+// value splits the non-null JSON value x of the field into columns.
+func (n *node) value(cols columns, x interface{}, r int32) (int, error) {
+	if n.children != nil {
+		v, ok := x.(map[string]interface{})
+		if !ok {
+			return 0, fmt.Errorf("%T is not a valid %s", x, n.field.Type)
+		}
+		return writeFields(n.children, cols, v, r)
+	}
+	v, size, err := n.decode(x)
+	if err != nil {
+		return 0, err
+	}
+	t := cols[n.path]
+	t.Values = append(t.Values, v)
+	t.DefinitionLevels = append(t.DefinitionLevels, n.def)
+	t.RepetitionLevels = append(t.RepetitionLevels, r)
+	return size, nil
+}
+
+// null records a missing value, defined to level d, for all of the columns
+// of the field.
+func (n *node) null(cols columns, r, d int32) {
+	if n.children == nil {
+		t := cols[n.path]
+		t.Values = append(t.Values, nil)
+		t.DefinitionLevels = append(t.DefinitionLevels, d)
+		t.RepetitionLevels = append(t.RepetitionLevels, r)
+		return
+	}
+	for _, child := range n.children {
+		child.null(cols, r, d)
+	}
+}
+
+// decode returns the Parquet value of the JSON value x of the leaf field,
+// and its size.
+func (n *node) decode(x interface{}) (interface{}, int, error) {
+	switch n.field.Type {
+	case bigquery.BytesFieldType:
+		if s, ok := x.(string); ok {
+			v, err := base64.StdEncoding.DecodeString(s)
+			return string(v), len(v), err
+		}
+	case bigquery.IntegerFieldType:
+		if s, ok := x.(json.Number); ok {
+			v, err := s.Int64()
+			return v, 8, err
+		}
+	case bigquery.FloatFieldType:
+		if s, ok := x.(json.Number); ok {
+			v, err := s.Float64()
+			return v, 8, err
+		}
+	case bigquery.BooleanFieldType:
+		if v, ok := x.(bool); ok {
+			return v, 1, nil
+		}
+	case bigquery.TimestampFieldType:
+		if s, ok := x.(string); ok {
+			t, err := time.Parse(time.RFC3339Nano, s)
+			return t.UnixMicro(), 8, err
+		}
+	case bigquery.DateFieldType:
+		if s, ok := x.(string); ok {
+			d, err := civil.ParseDate(s)
+			return int32(etl.EpochDays(d)), 4, err
+		}
+	default:
+		switch v := x.(type) {
+		case string:
+			return v, len(v), nil
+		case json.Number:
+			return v.String(), len(v), nil
+		}
+	}
+	return nil, 0, fmt.Errorf("%T is not a valid %s", x, n.field.Type)
+}
+
+// Rows returns the number of rows written.
+func (pw *Writer) Rows() int {
+	return pw.rows
+}
+
+// Close writes any buffered rows, and the footer of the file.  It does not
+// close the underlying io.Writer.
+func (pw *Writer) Close() error {
+	return pw.pw.WriteStop()
+}
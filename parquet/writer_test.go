@@ -0,0 +1,194 @@
+package parquet_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+	"github.com/go-test/deep"
+	"github.com/xitongsys/parquet-go/common"
+	pq "github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/source"
+
+	"github.com/m-lab/etl/parquet"
+)
+
+// value is a value of a column, with its levels.
+type value struct {
+	R, D int
+	V    interface{}
+}
+
+var testSchema = bigquery.Schema{
+	{Name: "id", Type: bigquery.StringFieldType, Required: true},
+	{Name: "n", Type: bigquery.IntegerFieldType},
+	{Name: "ok", Type: bigquery.BooleanFieldType},
+	{Name: "date", Type: bigquery.DateFieldType},
+	{Name: "time", Type: bigquery.TimestampFieldType},
+	{Name: "raw", Type: bigquery.BytesFieldType},
+	{Name: "tags", Type: bigquery.StringFieldType, Repeated: true},
+	{Name: "Hops", Type: bigquery.RecordFieldType, Repeated: true, Schema: bigquery.Schema{
+		{Name: "addr", Type: bigquery.StringFieldType},
+		{Name: "rtt", Type: bigquery.FloatFieldType},
+	}},
+}
+
+// The maximum definition and repetition levels of the test columns.
+var testLevels = map[string][2]int{
+	"id": {0, 0}, "n": {1, 0}, "ok": {1, 0}, "date": {1, 0}, "time": {1, 0}, "raw": {1, 0},
+	"tags": {1, 1}, "Hops.addr": {2, 1}, "Hops.rtt": {2, 1},
+}
+
+type hop struct {
+	Addr string   `json:"addr"`
+	RTT  *float64 `json:"rtt"`
+}
+
+type testRow struct {
+	ID   string     `json:"id"`
+	N    int64      `json:"n"`
+	OK   bool       `json:"ok"`
+	Date civil.Date `json:"date"`
+	Time time.Time  `json:"time"`
+	Raw  []byte     `json:"raw"`
+	Tags []string   `json:"tags"`
+	Hops []hop      `json:"hops"`
+}
+
+// bufferFile implements the parquet-go source.ParquetFile for reading bytes.
+type bufferFile struct {
+	*bytes.Reader
+}
+
+func (f bufferFile) Write(p []byte) (int, error)                  { return 0, errors.New("read only") }
+func (f bufferFile) Close() error                                 { return nil }
+func (f bufferFile) Open(name string) (source.ParquetFile, error) { return f, nil }
+func (f bufferFile) Create(name string) (source.ParquetFile, error) {
+	return nil, errors.New("read only")
+}
+
+func TestWriter(t *testing.T) {
+	rtt := 1.5
+	ts := time.Date(2022, 7, 1, 12, 0, 0, 123456000, time.UTC)
+	rows := []interface{}{
+		testRow{ID: "a", N: 7, OK: true, Date: civil.Date{Year: 2022, Month: 7, Day: 1}, Time: ts,
+			Raw: []byte{0xff, 0}, Tags: []string{"x", "y"}, Hops: []hop{{Addr: "h1", RTT: &rtt}, {Addr: "h2"}}},
+		map[string]interface{}{"id": "bad", "unknown": 1},
+		map[string]interface{}{"ID": "b", "ok": false, "tags": []string{}},
+	}
+	for _, groupBytes := range []int{parquet.DefaultRowGroupBytes, 1} {
+		var buf bytes.Buffer
+		w, err := parquet.NewWriter(&buf, testSchema)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.RowGroupBytes = groupBytes
+		for _, r := range rows {
+			err := w.Write(r)
+			if m, ok := r.(map[string]interface{}); ok && m["id"] == "bad" {
+				if !errors.Is(err, parquet.ErrInvalidRow) {
+					t.Errorf("Write() of unknown field error = %v, want %v", err, parquet.ErrInvalidRow)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if w.Rows() != 2 {
+			t.Errorf("Rows() = %d, want 2", w.Rows())
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		pr, err := reader.NewParquetColumnReader(bufferFile{bytes.NewReader(buf.Bytes())}, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pr.GetNumRows() != 2 {
+			t.Errorf("RowGroupBytes %d: GetNumRows() = %d, want 2", groupBytes, pr.GetNumRows())
+		}
+		wantGroups := 1
+		if groupBytes == 1 {
+			wantGroups = 2
+		}
+		if len(pr.Footer.RowGroups) != wantGroups {
+			t.Errorf("RowGroupBytes %d: %d row groups, want %d", groupBytes, len(pr.Footer.RowGroups), wantGroups)
+		}
+		for _, c := range pr.Footer.RowGroups[0].Columns {
+			if c.MetaData.Codec != pq.CompressionCodec_GZIP {
+				t.Errorf("%v codec is %v, want GZIP", c.MetaData.PathInSchema, c.MetaData.Codec)
+			}
+		}
+		// The reader renames the schema, so the names in the file are the
+		// external names.
+		names := []string{}
+		for _, info := range pr.SchemaHandler.Infos {
+			names = append(names, info.ExName)
+		}
+		if diff := deep.Equal(names, []string{"schema", "id", "n", "ok", "date", "time", "raw", "tags", "Hops", "addr", "rtt"}); diff != nil {
+			t.Errorf("schema names: %v", diff)
+		}
+		columns := map[string][]value{}
+		for path := range testLevels {
+			p := strings.Join(append([]string{"schema"}, strings.Split(path, ".")...), common.PAR_GO_PATH_DELIMITER)
+			values, rls, dls, err := pr.ReadColumnByPath(p, 2)
+			if err != nil {
+				t.Fatalf("ReadColumnByPath(%s) = %v", path, err)
+			}
+			for i := range values {
+				columns[path] = append(columns[path], value{int(rls[i]), int(dls[i]), values[i]})
+			}
+		}
+		pr.ReadStop()
+
+		want := map[string][]value{
+			"id":        {{0, 0, "a"}, {0, 0, "b"}},
+			"n":         {{0, 1, int64(7)}, {0, 0, nil}},
+			"ok":        {{0, 1, true}, {0, 1, false}},
+			"date":      {{0, 1, int32(19174)}, {0, 0, nil}},
+			"time":      {{0, 1, ts.UnixMicro()}, {0, 0, nil}},
+			"raw":       {{0, 1, "\xff\x00"}, {0, 0, nil}},
+			"tags":      {{0, 1, "x"}, {1, 1, "y"}, {0, 0, nil}},
+			"Hops.addr": {{0, 2, "h1"}, {1, 2, "h2"}, {0, 0, nil}},
+			"Hops.rtt":  {{0, 2, 1.5}, {1, 1, nil}, {0, 0, nil}},
+		}
+		if diff := deep.Equal(columns, want); diff != nil {
+			t.Errorf("RowGroupBytes %d: columns differ: %v", groupBytes, diff)
+		}
+	}
+}
+
+func TestNewWriter_Errors(t *testing.T) {
+	if _, err := parquet.NewWriter(&bytes.Buffer{}, nil); !errors.Is(err, parquet.ErrNoSchema) {
+		t.Errorf("NewWriter() error = %v, want %v", err, parquet.ErrNoSchema)
+	}
+	empty := bigquery.Schema{{Name: "r", Type: bigquery.RecordFieldType}}
+	if _, err := parquet.NewWriter(&bytes.Buffer{}, empty); !errors.Is(err, parquet.ErrNoSchema) {
+		t.Errorf("NewWriter() error = %v, want %v", err, parquet.ErrNoSchema)
+	}
+
+	w, err := parquet.NewWriter(&bytes.Buffer{}, testSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []map[string]interface{}{
+		{"n": 1},                                      // missing required id
+		{"id": "a", "n": "one"},                       // wrong type
+		{"id": "a", "tags": []interface{}{nil}},       // null in array
+		{"id": "a", "hops": map[string]interface{}{}}, // not an array
+	} {
+		if err := w.Write(r); !errors.Is(err, parquet.ErrInvalidRow) {
+			t.Errorf("Write(%v) error = %v, want %v", r, err, parquet.ErrInvalidRow)
+		}
+	}
+	if w.Rows() != 0 {
+		t.Errorf("Rows() = %d after failed writes", w.Rows())
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"log"
 	"strings"
-	"time"
 
 	"cloud.google.com/go/bigquery"
 
@@ -25,13 +24,15 @@ type AnnotationParser struct {
 	*row.Base
 	table  string
 	suffix string
+
+	uuidMap *UUIDMapper // Optional.
 }
 
 // NewAnnotationParser creates a new parser for annotation data.
 func NewAnnotationParser(sink row.Sink, label, suffix string) etl.Parser {
 	bufSize := etl.ANNOTATION.BQBufferSize()
 	return &AnnotationParser{
-		Base:   row.NewBase(label, sink, bufSize),
+		Base:   row.NewAdaptiveBase(label, sink, bufSize),
 		table:  label,
 		suffix: suffix,
 	}
@@ -47,7 +48,12 @@ func (ap *AnnotationParser) TaskError() error {
 			stats.Failed, stats.Total())
 		return etl.ErrHighInsertionFailureRate
 	}
-	return nil
+	return ap.Base.TaskError()
+}
+
+// SetUUIDMapper sets the UUIDMapper used to record the UUID of each test.
+func (ap *AnnotationParser) SetUUIDMapper(m *UUIDMapper) {
+	ap.uuidMap = m
 }
 
 // IsParsable returns the canonical test type and whether to parse data.
@@ -66,11 +72,13 @@ func (ap *AnnotationParser) ParseAndInsert(meta map[string]bigquery.Value, testN
 
 	row := schema.AnnotationRow{
 		Parser: schema.ParseInfo{
-			Version:    Version(),
-			Time:       time.Now(),
-			ArchiveURL: meta["filename"].(string),
-			Filename:   testName,
-			GitCommit:  GitCommit(),
+			Version:     Version(),
+			Time:        ap.Now(),
+			ArchiveURL:  meta["filename"].(string),
+			Filename:    testName,
+			GitCommit:   GitCommit(),
+			FileModTime: fileModTime(meta),
+			FileSize:    fileSize(meta),
 		},
 	}
 
@@ -107,10 +115,13 @@ func (ap *AnnotationParser) ParseAndInsert(meta map[string]bigquery.Value, testN
 	// will these dates.
 	row.Date = meta["date"].(civil.Date)
 
+	ap.uuidMap.mapTest(ap.TableName(), "annotation", meta, testName, row.UUID)
+
 	// Estimate the row size based on the input JSON size.
 	metrics.RowSizeHistogram.WithLabelValues(ap.TableName()).Observe(float64(len(test)))
 
 	// Insert the row.
+	ap.ExpectRows(testName, 1)
 	if err = ap.Base.Put(&row); err != nil {
 		return err
 	}
@@ -1,5 +1,7 @@
 package parser
 
+import "github.com/m-lab/etl/etl"
+
 // This file contains any whitebox tests (with access to package internals), and wrappers
 // to enable blackbox tests to set up environment.
 // See https://golang.org/src/net/http/export_test.go.
@@ -11,3 +13,19 @@ var InitParserVersionForTest = initParserVersion
 // InitParserGitCommitForTest allows test to rerun initParseGitCommit after initializing
 // environement variables.
 var InitParserGitCommitForTest = initParserGitCommit
+
+// ClockSkew exports clockSkew for testing.
+var ClockSkew = clockSkew
+
+// SnapshotDelta exports snapshotDelta for testing.
+var SnapshotDelta = snapshotDelta
+
+// DropIdleSnaps exports dropIdleSnaps for testing.
+var DropIdleSnaps = dropIdleSnaps
+
+// UnregisterParserForTest removes the Factory registered for the datatype.
+func UnregisterParserForTest(dt etl.DataType) {
+	parserRegistry.lock.Lock()
+	defer parserRegistry.lock.Unlock()
+	delete(parserRegistry.byDT, dt)
+}
@@ -3,7 +3,6 @@ package parser
 import (
 	"encoding/json"
 	"strings"
-	"time"
 
 	"cloud.google.com/go/bigquery"
 	"cloud.google.com/go/civil"
@@ -29,7 +28,7 @@ type HopAnnotation1Parser struct {
 func NewHopAnnotation1Parser(sink row.Sink, table, suffix string) etl.Parser {
 	bufSize := etl.HOPANNOTATION1.BQBufferSize()
 	return &HopAnnotation1Parser{
-		Base:   row.NewBase(table, sink, bufSize),
+		Base:   row.NewAdaptiveBase(table, sink, bufSize),
 		table:  table,
 		suffix: suffix,
 	}
@@ -50,11 +49,13 @@ func (p *HopAnnotation1Parser) ParseAndInsert(fileMetadata map[string]bigquery.V
 
 	row := schema.HopAnnotation1Row{
 		Parser: schema.ParseInfo{
-			Version:    Version(),
-			Time:       time.Now(),
-			ArchiveURL: fileMetadata["filename"].(string),
-			Filename:   testName,
-			GitCommit:  GitCommit(),
+			Version:     Version(),
+			Time:        p.Now(),
+			ArchiveURL:  fileMetadata["filename"].(string),
+			Filename:    testName,
+			GitCommit:   GitCommit(),
+			FileModTime: fileModTime(fileMetadata),
+			FileSize:    fileSize(fileMetadata),
 		},
 	}
 
@@ -78,6 +79,7 @@ func (p *HopAnnotation1Parser) ParseAndInsert(fileMetadata map[string]bigquery.V
 	metrics.RowSizeHistogram.WithLabelValues(p.TableName()).Observe(float64(len(rawContent)))
 
 	// Insert the row.
+	p.ExpectRows(testName, 1)
 	err = p.Base.Put(&row)
 	if err != nil {
 		return err
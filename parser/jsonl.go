@@ -0,0 +1,78 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// MaxCorruptLineRatio is the largest fraction of a JSON Lines file that may be
+// skipped as corrupt.  Files with more corrupt lines are rejected, since they
+// are more likely truncated or in an unexpected format than occasionally
+// damaged.
+var MaxCorruptLineRatio = 0.1
+
+// ErrTooManyCorruptLines is returned when the corrupt lines of a JSON Lines
+// file exceed MaxCorruptLineRatio.
+var ErrTooManyCorruptLines = errors.New("too many corrupt lines")
+
+// jsonLines decodes a JSON Lines file, one value per line, skipping lines that
+// cannot be decoded, so that one corrupt line does not lose the whole file.
+type jsonLines struct {
+	data    []byte
+	lines   int // Non-empty lines read so far.
+	corrupt int // Lines skipped because they could not be decoded.
+	lastErr error
+}
+
+// newJSONLines returns a jsonLines decoder for data.
+func newJSONLines(data []byte) *jsonLines {
+	return &jsonLines{data: data}
+}
+
+// Decode decodes the next valid line into v, which must be a non-nil pointer,
+// and reports whether there was one.  Empty lines are ignored.  If a line
+// cannot be decoded, v is reset to its zero value, and Decode continues with
+// the next line.
+func (d *jsonLines) Decode(v interface{}) bool {
+	for len(d.data) > 0 {
+		line := d.data
+		if i := bytes.IndexByte(d.data, '\n'); i >= 0 {
+			line, d.data = d.data[:i], d.data[i+1:]
+		} else {
+			d.data = nil
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		d.lines++
+		err := json.Unmarshal(line, v)
+		if err == nil {
+			return true
+		}
+		d.corrupt++
+		d.lastErr = err
+		// Discard any fields decoded before the error.
+		e := reflect.ValueOf(v).Elem()
+		e.Set(reflect.Zero(e.Type()))
+	}
+	return false
+}
+
+// Corrupt returns the number of lines skipped so far.
+func (d *jsonLines) Corrupt() int {
+	return d.corrupt
+}
+
+// Err returns ErrTooManyCorruptLines, wrapping the last decoding error, if the
+// fraction of corrupt lines exceeds MaxCorruptLineRatio.
+func (d *jsonLines) Err() error {
+	if d.corrupt == 0 || float64(d.corrupt) <= MaxCorruptLineRatio*float64(d.lines) {
+		return nil
+	}
+	return fmt.Errorf("%w: %d of %d lines, last error: %v",
+		ErrTooManyCorruptLines, d.corrupt, d.lines, d.lastErr)
+}
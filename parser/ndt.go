@@ -19,6 +19,7 @@ import (
 	"log"
 	"os"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -129,6 +130,9 @@ type NDTParser struct {
 	*row.Base
 	table string
 
+	omitDeltas   bool // Omit the snapshot deltas.
+	allSnapshots bool // Keep a delta for every snapshot.
+
 	// These will be non-empty iff a test group is pending.
 	taskFileName string // The tar file containing these tests.
 	timestamp    string // The unique timestamp common across all files in current batch.
@@ -144,8 +148,10 @@ type NDTParser struct {
 func NewNDTParser(sink row.Sink, table, suffix string) *NDTParser {
 	bufSize := etl.NDT.BQBufferSize()
 	return &NDTParser{
-		Base:  row.NewBase(table, sink, bufSize),
-		table: table,
+		Base:         row.NewAdaptiveBase(table, sink, bufSize),
+		table:        table,
+		omitDeltas:   etl.OmitDeltas,
+		allSnapshots: etl.NDT.Enabled(etl.AllSnapshots),
 	}
 }
 
@@ -159,7 +165,7 @@ func (n *NDTParser) TaskError() error {
 			s.Failed, s.Total())
 		return errors.New("too many insertion failures")
 	}
-	return nil
+	return n.Base.TaskError()
 }
 
 // Flush completes processing of final task group, if any, and flushes
@@ -308,6 +314,42 @@ func (n *NDTParser) ParseAndInsert(taskInfo map[string]bigquery.Value, testName
 	return nil
 }
 
+// ParseGroup implements etl.GroupParser.  The files of a single test are
+// processed together, instead of being held until the next test or Flush.
+func (n *NDTParser) ParseGroup(taskInfo map[string]bigquery.Value, files map[string][]byte) error {
+	// Process any test left over from ParseAndInsert.
+	if n.timestamp != "" {
+		n.processGroup()
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, ok := n.IsParsable(name, files[name]); !ok {
+			continue
+		}
+		if n.timestamp == "" {
+			// Start the group here, so that ParseAndInsert does not report
+			// an empty previous group.
+			info, err := ParseNDTFileName(name)
+			if err != nil {
+				continue
+			}
+			n.taskFileName = taskInfo["filename"].(string)
+			n.timestamp = info.Time
+		}
+		if err := n.ParseAndInsert(taskInfo, name, files[name]); err != nil {
+			return err
+		}
+	}
+	if n.timestamp != "" {
+		n.processGroup()
+	}
+	return nil
+}
+
 func (n *NDTParser) reportAnomalies() {
 	// Report all groups that are missing files.
 	tag := ""
@@ -389,12 +431,16 @@ func (n *NDTParser) processTest(test *fileInfoAndData, testType string) {
 func (n *NDTParser) getDeltas(snaplog *web100.SnapLog, testType string) ([]schema.Web100ValueMap, int) {
 	deltas := []schema.Web100ValueMap{}
 	deltaFieldCount := 0
-	if etl.OmitDeltas {
+	if n.omitDeltas {
 		return deltas, deltaFieldCount
 	}
+	limit := maxNumSnapshots
+	if n.allSnapshots {
+		limit = snaplog.SnapCount()
+	}
 	snapshotCount := 0
 	last := &web100.Snapshot{}
-	for count := 0; count < snaplog.SnapCount() && count < maxNumSnapshots; count++ {
+	for count := 0; count < snaplog.SnapCount() && count < limit; count++ {
 		snap, err := snaplog.Snapshot(count)
 		if err != nil {
 			// TODO - refine label and maybe write a log?
@@ -422,7 +468,7 @@ func (n *NDTParser) getDeltas(snaplog *web100.SnapLog, testType string) ([]schem
 		delete(delta, "RemPort")
 		delete(delta, "SACK")
 		// Now ignore delta if the only field that changed is duration.
-		if len(delta) == 1 {
+		if len(delta) == 1 && !n.allSnapshots {
 			_, ok := delta["Duration"]
 			if ok {
 				continue
@@ -460,11 +506,13 @@ func (n *NDTParser) getAndInsertValues(test *fileInfoAndData, testType string) {
 	}
 
 	// Large allocation here.
-	snaplog, err := web100.NewSnapLog(test.data)
+	snaplog, err := web100.Validate(test.data)
 	if err != nil {
 		metrics.ErrorCount.WithLabelValues(
-			n.TableName(), testType, "snaplog failure").Inc()
-		log.Printf("Unable to parse snaplog for %s, when processing: %s\n%s\n",
+			n.TableName(), testType, "corrupt snaplog").Inc()
+		metrics.TestTotal.WithLabelValues(
+			n.TableName(), testType, "corrupt snaplog").Inc()
+		log.Printf("Corrupt snaplog %s, when processing: %s\n%s\n",
 			test.fn, n.taskFileName, err)
 		return
 	}
@@ -556,7 +604,7 @@ func (n *NDTParser) getAndInsertValues(test *fileInfoAndData, testType string) {
 	} else {
 		results["log_time"] = string(lt)
 	}
-	now, err := time.Now().MarshalText()
+	now, err := n.Now().MarshalText()
 	if err != nil {
 		log.Println(err)
 		metrics.ErrorCount.WithLabelValues(
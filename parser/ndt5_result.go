@@ -27,13 +27,15 @@ type NDT5ResultParser struct {
 	*row.Base
 	table  string
 	suffix string
+
+	uuidMap *UUIDMapper // Optional.
 }
 
 // NewNDT5ResultParser returns a parser for NDT5Result archives.
 func NewNDT5ResultParser(sink row.Sink, label, suffix string) etl.Parser {
 	bufSize := etl.NDT5.BQBufferSize()
 	return &NDT5ResultParser{
-		Base:   row.NewBase(label, sink, bufSize),
+		Base:   row.NewAdaptiveBase(label, sink, bufSize),
 		table:  label,
 		suffix: suffix,
 	}
@@ -49,7 +51,12 @@ func (dp *NDT5ResultParser) TaskError() error {
 			stats.Failed, stats.Total())
 		return etl.ErrHighInsertionFailureRate
 	}
-	return nil
+	return dp.Base.TaskError()
+}
+
+// SetUUIDMapper sets the UUIDMapper used to record the UUID of each test.
+func (dp *NDT5ResultParser) SetUUIDMapper(m *UUIDMapper) {
+	dp.uuidMap = m
 }
 
 // IsParsable returns the canonical test type and whether to parse data.
@@ -80,16 +87,19 @@ func (dp *NDT5ResultParser) ParseAndInsert(meta map[string]bigquery.Value, testN
 	if len(test) == 0 {
 		// This is an empty test.
 		// NOTE: We may wish to record these for full e2e accounting.
+		dp.ExpectRows(testName, 0)
 		metrics.RowSizeHistogram.WithLabelValues(dp.TableName()).Observe(float64(len(test)))
 		return nil
 	}
 
 	parser := schema.ParseInfo{
-		Version:    Version(),
-		Time:       time.Now(),
-		ArchiveURL: meta["filename"].(string),
-		Filename:   testName,
-		GitCommit:  GitCommit(),
+		Version:     Version(),
+		Time:        dp.Now(),
+		ArchiveURL:  meta["filename"].(string),
+		Filename:    testName,
+		GitCommit:   GitCommit(),
+		FileModTime: fileModTime(meta),
+		FileSize:    fileSize(meta),
 	}
 	date := meta["date"].(civil.Date)
 
@@ -105,11 +115,15 @@ func (dp *NDT5ResultParser) ParseAndInsert(meta map[string]bigquery.Value, testN
 		metrics.TestTotal.WithLabelValues(dp.TableName(), "ndt5_result", "Decode").Inc()
 		return err
 	}
+	dp.ExpectRows(testName, expectedRows(result))
+	// The IDs of the rows, for the UUID map.
+	ids := []string{}
 	if result.Raw.S2C != nil && result.Raw.S2C.UUID != "" {
 		dp.prepareS2CRow(result)
 		if err = dp.Base.Put(result); err != nil {
 			return err
 		}
+		ids = append(ids, result.ID)
 	}
 
 	// C2S
@@ -123,6 +137,7 @@ func (dp *NDT5ResultParser) ParseAndInsert(meta map[string]bigquery.Value, testN
 		if err = dp.Base.Put(result); err != nil {
 			return err
 		}
+		ids = append(ids, result.ID)
 	}
 
 	// Neither C2S nor S2C
@@ -137,8 +152,13 @@ func (dp *NDT5ResultParser) ParseAndInsert(meta map[string]bigquery.Value, testN
 		if err = dp.Base.Put(result); err != nil {
 			return err
 		}
+		ids = append(ids, result.ID)
 	}
 
+	// The row IDs are the measurement UUIDs, which differ from the test UUID
+	// in the filename, so only the row IDs are mapped.
+	dp.uuidMap.mapTest(dp.TableName(), "ndt5_result", meta, testName, "", ids...)
+
 	// Estimate the row size based on the input JSON size.
 	metrics.RowSizeHistogram.WithLabelValues(dp.TableName()).Observe(float64(len(test)))
 
@@ -147,6 +167,22 @@ func (dp *NDT5ResultParser) ParseAndInsert(meta map[string]bigquery.Value, testN
 	return nil
 }
 
+// expectedRows returns the number of rows produced for the result: one for
+// each of the S2C and C2S measurements, or one for the control alone.
+func expectedRows(result *schema.NDT5ResultRowV2) int {
+	n := 0
+	if result.Raw.S2C != nil && result.Raw.S2C.UUID != "" {
+		n++
+	}
+	if result.Raw.C2S != nil && result.Raw.C2S.UUID != "" {
+		n++
+	}
+	if result.Raw.C2S == nil && result.Raw.S2C == nil {
+		n++
+	}
+	return n
+}
+
 func (dp *NDT5ResultParser) newResult(test []byte, parser schema.ParseInfo, date civil.Date) (*schema.NDT5ResultRowV2, error) {
 	result := &schema.NDT5ResultRowV2{
 		Parser: parser,
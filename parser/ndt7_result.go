@@ -6,7 +6,6 @@ import (
 	"encoding/json"
 	"log"
 	"strings"
-	"time"
 
 	"cloud.google.com/go/bigquery"
 
@@ -28,13 +27,15 @@ type NDT7ResultParser struct {
 	*row.Base
 	table  string
 	suffix string
+
+	uuidMap *UUIDMapper // Optional.
 }
 
 // NewNDT7ResultParser returns a parser for NDT7Result archives.
 func NewNDT7ResultParser(sink row.Sink, table, suffix string) etl.Parser {
 	bufSize := etl.NDT7.BQBufferSize()
 	return &NDT7ResultParser{
-		Base:   row.NewBase(table, sink, bufSize),
+		Base:   row.NewAdaptiveBase(table, sink, bufSize),
 		table:  table,
 		suffix: suffix,
 	}
@@ -50,7 +51,12 @@ func (dp *NDT7ResultParser) TaskError() error {
 			stats.Failed, stats.Total())
 		return etl.ErrHighInsertionFailureRate
 	}
-	return nil
+	return dp.Base.TaskError()
+}
+
+// SetUUIDMapper sets the UUIDMapper used to record the UUID of each test.
+func (dp *NDT7ResultParser) SetUUIDMapper(m *UUIDMapper) {
+	dp.uuidMap = m
 }
 
 // IsParsable returns the canonical test type and whether to parse data.
@@ -72,11 +78,13 @@ func (dp *NDT7ResultParser) ParseAndInsert(meta map[string]bigquery.Value, testN
 
 	row := schema.NDT7ResultRow{
 		Parser: schema.ParseInfo{
-			Version:    Version(),
-			Time:       time.Now(),
-			ArchiveURL: meta["filename"].(string),
-			Filename:   testName,
-			GitCommit:  GitCommit(),
+			Version:     Version(),
+			Time:        dp.Now(),
+			ArchiveURL:  meta["filename"].(string),
+			Filename:    testName,
+			GitCommit:   GitCommit(),
+			FileModTime: fileModTime(meta),
+			FileSize:    fileSize(meta),
 		},
 	}
 
@@ -116,12 +124,16 @@ func (dp *NDT7ResultParser) ParseAndInsert(meta map[string]bigquery.Value, testN
 			dp.TableName(), "ndt7", "download and upload are both nil").Inc()
 	}
 	row.ID = row.A.UUID
+	checkClockSkew(dp.TableName(), "ndt7_result", &row.Parser, meta, row.A.TestTime)
+
+	dp.uuidMap.mapTest(dp.TableName(), "ndt7_result", meta, testName, row.ID)
 
 	// Estimate the row size based on the input JSON size.
 	metrics.RowSizeHistogram.WithLabelValues(
 		dp.TableName()).Observe(float64(len(test)))
 
 	// Insert the row.
+	dp.ExpectRows(testName, 1)
 	err = dp.Base.Put(&row)
 	if err != nil {
 		return err
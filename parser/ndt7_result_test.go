@@ -4,6 +4,7 @@ import (
 	"io/ioutil"
 	"strings"
 	"testing"
+	"time"
 
 	"cloud.google.com/go/bigquery"
 	"cloud.google.com/go/civil"
@@ -29,6 +30,8 @@ func TestNDT7ResultParser_ParseAndInsert(t *testing.T) {
 			testName: `ndt7-upload-20200318T001352.496224022Z.ndt-knwp4_1583603744_0000000000005CF2.json`,
 		},
 	}
+	// The tar header time of the test files, shortly after the tests.
+	modTime := time.Date(2020, 3, 18, 0, 15, 0, 0, time.UTC)
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			ins := newInMemorySink()
@@ -39,8 +42,10 @@ func TestNDT7ResultParser_ParseAndInsert(t *testing.T) {
 				t.Fatalf(err.Error())
 			}
 			meta := map[string]bigquery.Value{
-				"filename": "gs://mlab-test-bucket/ndt/ndt7/2020/03/18/ndt_ndt7_2020_03_18_20200318T003853.425987Z-ndt7-mlab3-syd03-ndt.tgz",
-				"date":     civil.Date{Year: 2020, Month: 3, Day: 18},
+				"filename":  "gs://mlab-test-bucket/ndt/ndt7/2020/03/18/ndt_ndt7_2020_03_18_20200318T003853.425987Z-ndt7-mlab3-syd03-ndt.tgz",
+				"date":      civil.Date{Year: 2020, Month: 3, Day: 18},
+				"mod_time":  modTime,
+				"file_size": int64(len(resultData)),
 			}
 
 			if err := n.ParseAndInsert(meta, tt.testName, resultData); (err != nil) != tt.wantErr {
@@ -65,12 +70,14 @@ func TestNDT7ResultParser_ParseAndInsert(t *testing.T) {
 				}
 
 				expPI := schema.ParseInfo{
-					Version:    "https://github.com/m-lab/etl/tree/foobar", // "local development",
-					Time:       row.Parser.Time,                            // cheat a little, since this value should be about now.
-					ArchiveURL: "gs://mlab-test-bucket/ndt/ndt7/2020/03/18/ndt_ndt7_2020_03_18_20200318T003853.425987Z-ndt7-mlab3-syd03-ndt.tgz",
-					Filename:   "ndt7-download-20200318T000657.568382877Z.ndt-knwp4_1583603744_000000000000590E.json",
-					Priority:   0,
-					GitCommit:  "12345678",
+					Version:     "https://github.com/m-lab/etl/tree/foobar", // "local development",
+					Time:        row.Parser.Time,                            // cheat a little, since this value should be about now.
+					ArchiveURL:  "gs://mlab-test-bucket/ndt/ndt7/2020/03/18/ndt_ndt7_2020_03_18_20200318T003853.425987Z-ndt7-mlab3-syd03-ndt.tgz",
+					Filename:    "ndt7-download-20200318T000657.568382877Z.ndt-knwp4_1583603744_000000000000590E.json",
+					Priority:    0,
+					GitCommit:   "12345678",
+					FileModTime: modTime,
+					FileSize:    int64(len(resultData)),
 				}
 				if diff := deep.Equal(row.Parser, expPI); diff != nil {
 					pretty.Print(row.Parser)
@@ -104,12 +111,14 @@ func TestNDT7ResultParser_ParseAndInsert(t *testing.T) {
 					t.Errorf("NDT7ResultParser.ParseAndInsert() different summary: %s", strings.Join(diff, "\n"))
 				}
 				expPI := schema.ParseInfo{
-					Version:    "https://github.com/m-lab/etl/tree/foobar",
-					Time:       row.Parser.Time,
-					ArchiveURL: "gs://mlab-test-bucket/ndt/ndt7/2020/03/18/ndt_ndt7_2020_03_18_20200318T003853.425987Z-ndt7-mlab3-syd03-ndt.tgz",
-					Filename:   "ndt7-upload-20200318T001352.496224022Z.ndt-knwp4_1583603744_0000000000005CF2.json",
-					Priority:   0,
-					GitCommit:  "12345678",
+					Version:     "https://github.com/m-lab/etl/tree/foobar",
+					Time:        row.Parser.Time,
+					ArchiveURL:  "gs://mlab-test-bucket/ndt/ndt7/2020/03/18/ndt_ndt7_2020_03_18_20200318T003853.425987Z-ndt7-mlab3-syd03-ndt.tgz",
+					Filename:    "ndt7-upload-20200318T001352.496224022Z.ndt-knwp4_1583603744_0000000000005CF2.json",
+					Priority:    0,
+					GitCommit:   "12345678",
+					FileModTime: modTime,
+					FileSize:    int64(len(resultData)),
 				}
 				if diff := deep.Equal(row.Parser, expPI); diff != nil {
 					t.Errorf("NDT7ResultParser.ParseAndInsert() different summary: %s", strings.Join(diff, "\n"))
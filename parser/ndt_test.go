@@ -8,8 +8,10 @@ import (
 	"cloud.google.com/go/bigquery"
 
 	"github.com/kr/pretty"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 
 	"github.com/m-lab/etl/etl"
+	"github.com/m-lab/etl/metrics"
 	"github.com/m-lab/etl/parser"
 	"github.com/m-lab/etl/schema"
 )
@@ -150,6 +152,51 @@ func TestNDTParser(t *testing.T) {
 	}
 }
 
+func TestNDTParser_ParseGroup(t *testing.T) {
+	ins := newInMemoryInserter()
+	n := parser.NewNDTParser(ins, "web100", "")
+
+	// As in the archive, the snaplogs are gzipped.
+	files := map[string][]byte{}
+	for name, gz := range map[string]string{
+		`20170509T13:45:13.590210000Z_eb.measurementlab.net:44160.s2c_snaplog`: ".gz",
+		`20170509T13:45:13.590210000Z_eb.measurementlab.net:48716.c2s_snaplog`: ".gz",
+		`20170509T13:45:13.590210000Z_eb.measurementlab.net:53000.meta`:        "",
+	} {
+		data, err := ioutil.ReadFile(`testdata/web100/` + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[name+gz] = data
+	}
+	// Unparsable files of the test are ignored.
+	files[`20170509T13:45:13.590210000Z_45.56.98.222.c2s_ndttrace`] = []byte("trace")
+
+	meta := map[string]bigquery.Value{"filename": "gs://mlab-test-bucket/ndt/2017/06/13/20170613T000000Z-mlab3-vie01-ndt-0186.tgz"}
+	found := metrics.WarningCount.WithLabelValues("web100", "group", "Found no files")
+	before := testutil.ToFloat64(found)
+	if err := n.ParseGroup(meta, files); err != nil {
+		t.Fatal(err)
+	}
+	// The test is processed without waiting for the next test or Flush.
+	if got := n.GetStats().Total(); got != 2 {
+		t.Errorf("GetStats().Total() = %d after ParseGroup, want 2", got)
+	}
+	if got := testutil.ToFloat64(found); got != before {
+		t.Errorf("Found no files warnings = %v, want %v", got, before)
+	}
+	if err := n.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if ins.Accepted() != 2 {
+		t.Fatalf("Accepted() = %d after Flush, want 2", ins.Accepted())
+	}
+	id := ins.data[0].(parser.NDTTest).Web100ValueMap["id"]
+	if id != "nYjSCZhB0EfQPChl2tT8Fg" {
+		t.Errorf("id = %v, want the s2c test first", id)
+	}
+}
+
 // compare recursively checks whether actual values equal values in the expected values.
 // The expected values may be a subset of the actual values, but not a superset.
 func compare(t *testing.T, actual schema.Web100ValueMap, expected schema.Web100ValueMap) bool {
@@ -309,3 +356,50 @@ func (in *inMemoryInserter) Committed() int {
 func (in *inMemoryInserter) Failed() int {
 	return in.failed
 }
+
+// ndtDeltas parses a single s2c snaplog, and returns the deltas of its row.
+func ndtDeltas(t *testing.T) []schema.Web100ValueMap {
+	ins := newInMemoryInserter()
+	n := parser.NewNDTParser(ins, "web100", "")
+	s2cName := `20170509T13:45:13.590210000Z_eb.measurementlab.net:44160.s2c_snaplog`
+	s2cData, err := ioutil.ReadFile(`testdata/web100/` + s2cName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := map[string]bigquery.Value{"filename": "gs://mlab-test-bucket/ndt/2017/06/13/20170613T000000Z-mlab3-vie01-ndt-0186.tgz"}
+	if err := n.ParseAndInsert(meta, s2cName+".gz", s2cData); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(ins.data) != 1 {
+		t.Fatalf("inserted %d rows, want 1", len(ins.data))
+	}
+	entry := ins.data[0].(parser.NDTTest).Web100ValueMap["web100_log_entry"].(schema.Web100ValueMap)
+	return entry["deltas"].([]schema.Web100ValueMap)
+}
+
+func TestNDTParser_AllSnapshots(t *testing.T) {
+	defer etl.SetConfig(nil)
+	some := ndtDeltas(t)
+
+	c, err := etl.ParseConfig([]byte(`{"features": {"ndt": ["all_snapshots"]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	etl.SetConfig(c)
+	all := ndtDeltas(t)
+	if len(all) <= len(some) {
+		t.Errorf("all_snapshots kept %d deltas, want more than %d", len(all), len(some))
+	}
+	// Every snapshot has a delta, in order.
+	for i, d := range all {
+		if d["snapshot_num"] != i || d["delta_index"] != i {
+			t.Fatalf("delta %d has snapshot_num %v, delta_index %v", i, d["snapshot_num"], d["delta_index"])
+		}
+	}
+	if all[len(all)-1]["is_last"] != true {
+		t.Error("last delta is not tagged is_last")
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"encoding/base64"
 	"fmt"
 	"net"
+	"sync"
 	"time"
 
 	"cloud.google.com/go/bigquery"
@@ -86,29 +87,82 @@ func GetHopID(cycleStartTime float64, hostname string, address string) string {
 	return fmt.Sprintf("%s_%s_%s", date, hostname, address)
 }
 
-// NewSinkParser creates a parser for the given data type.
+// fileModTime returns the "mod_time" of the test file from the metadata, or
+// the zero time if it is not known.
+func fileModTime(meta map[string]bigquery.Value) time.Time {
+	mt, _ := meta["mod_time"].(time.Time)
+	return mt
+}
+
+// fileSize returns the "file_size" of the test file from the metadata, or
+// zero if it is not known.
+func fileSize(meta map[string]bigquery.Value) int64 {
+	size, _ := meta["file_size"].(int64)
+	if size < 0 {
+		return 0
+	}
+	return size
+}
+
+// transformable is implemented by parsers that embed row.Base.
+type transformable interface {
+	SetTransformers(t ...row.Transformer)
+}
+
+// Factory creates a parser for a data type, that writes to sink.  The table is
+// the data type's configured table name, e.g. from etl.DataType.Table, and the
+// suffix is the table suffix for the archive's date, from its
+// etl.SuffixStrategy.
+type Factory func(sink row.Sink, table, suffix string) etl.Parser
+
+// parserRegistry holds the Factory for each datatype.
+var parserRegistry = struct {
+	lock sync.RWMutex
+	byDT map[etl.DataType]Factory
+}{byDT: map[etl.DataType]Factory{
+	etl.ANNOTATION:     func(s row.Sink, t, x string) etl.Parser { return NewAnnotationParser(s, t, x) },
+	etl.HOPANNOTATION1: func(s row.Sink, t, x string) etl.Parser { return NewHopAnnotation1Parser(s, t, x) },
+	etl.NDT5:           func(s row.Sink, t, x string) etl.Parser { return NewNDT5ResultParser(s, t, x) },
+	etl.NDT7:           func(s row.Sink, t, x string) etl.Parser { return NewNDT7ResultParser(s, t, x) },
+	etl.TCPINFO:        func(s row.Sink, t, x string) etl.Parser { return NewTCPInfoParser(s, t, x) },
+	etl.PCAP:           func(s row.Sink, t, x string) etl.Parser { return NewPCAPParser(s, t, x) },
+	etl.SCAMPER1:       func(s row.Sink, t, x string) etl.Parser { return NewScamper1Parser(s, t, x) },
+	etl.SS:             func(s row.Sink, t, x string) etl.Parser { return NewSSParser(s, t, x) },
+	etl.SW:             func(s row.Sink, t, x string) etl.Parser { return NewSwitchParser(s, t, x) },
+}}
+
+// RegisterParser sets the Factory that NewSinkParser uses for the datatype,
+// replacing any earlier Factory, including a built-in one.  Together with
+// etl.RegisterDataType, this adds a datatype without editing the built-in
+// tables.  This should typically be called during program initialization.
+func RegisterParser(dt etl.DataType, f Factory) {
+	parserRegistry.lock.Lock()
+	defer parserRegistry.lock.Unlock()
+	parserRegistry.byDT[dt] = f
+}
+
+// NewSinkParser creates a parser for the given data type, using the Factory
+// registered for it, or returns nil if there is none.
 // NewSinkParser should only support datatypes that use "standard column" schemas.
-func NewSinkParser(dt etl.DataType, sink row.Sink, table string) etl.Parser {
-	switch dt {
-	case etl.ANNOTATION:
-		return NewAnnotationParser(sink, table, "")
-	case etl.HOPANNOTATION1:
-		return NewHopAnnotation1Parser(sink, table, "")
-	case etl.NDT5:
-		return NewNDT5ResultParser(sink, table, "")
-	case etl.NDT7:
-		return NewNDT7ResultParser(sink, table, "")
-	case etl.TCPINFO:
-		return NewTCPInfoParser(sink, table, "")
-	case etl.PCAP:
-		return NewPCAPParser(sink, table, "")
-	case etl.SCAMPER1:
-		return NewScamper1Parser(sink, table, "")
-	case etl.SW:
-		return NewSwitchParser(sink, table, "")
-	default:
+// Any row.Transformers registered for the datatype are applied to the parser.
+func NewSinkParser(dt etl.DataType, sink row.Sink, table, suffix string) etl.Parser {
+	p := newSinkParser(dt, sink, table, suffix)
+	if tp, ok := p.(transformable); ok {
+		if t := row.Transformers(string(dt)); len(t) > 0 {
+			tp.SetTransformers(t...)
+		}
+	}
+	return p
+}
+
+func newSinkParser(dt etl.DataType, sink row.Sink, table, suffix string) etl.Parser {
+	parserRegistry.lock.RLock()
+	f, ok := parserRegistry.byDT[dt]
+	parserRegistry.lock.RUnlock()
+	if !ok {
 		return nil
 	}
+	return f(sink, table, suffix)
 }
 
 //=====================================================================================
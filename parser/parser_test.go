@@ -12,6 +12,7 @@ import (
 	"github.com/m-lab/etl/etl"
 	"github.com/m-lab/etl/metrics"
 	"github.com/m-lab/etl/parser"
+	"github.com/m-lab/etl/row"
 	pipe "gopkg.in/m-lab/pipe.v3"
 )
 
@@ -200,3 +201,27 @@ func TestMain(m *testing.M) {
 	}
 	os.Exit(exitCode)
 }
+
+func TestRegisterParser(t *testing.T) {
+	dt := etl.DataType("register_parser_test")
+	if p := parser.NewSinkParser(dt, newInMemorySink(), "table", ""); p != nil {
+		t.Fatalf("NewSinkParser() = %v before registration, want nil", p)
+	}
+	var gotTable string
+	t.Cleanup(func() { parser.UnregisterParserForTest(dt) })
+	parser.RegisterParser(dt, func(sink row.Sink, table, suffix string) etl.Parser {
+		gotTable = table
+		return parser.NewSwitchParser(sink, table, suffix)
+	})
+	p := parser.NewSinkParser(dt, newInMemorySink(), "register_parser_table", "")
+	if p == nil {
+		t.Fatal("NewSinkParser() = nil after registration")
+	}
+	if gotTable != "register_parser_table" || p.TableName() != "register_parser_table" {
+		t.Errorf("NewSinkParser() table = %q, TableName() = %q", gotTable, p.TableName())
+	}
+	// Built-in datatypes are registered too.
+	if p := parser.NewSinkParser(etl.NDT7, newInMemorySink(), "ndt7", ""); p == nil {
+		t.Error("NewSinkParser(ndt7) = nil")
+	}
+}
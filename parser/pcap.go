@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"encoding/binary"
 	"fmt"
 	"log"
 	"net"
@@ -110,35 +111,163 @@ func GetPackets(data []byte) ([]Packet, error) {
 	return packets, nil
 }
 
+// flowDirection tracks the data sent in one direction of a TCP flow.
+type flowDirection struct {
+	seen bool
+	next uint32 // Sequence number following the highest data sent.
+}
+
+// seqBefore reports whether TCP sequence number a is before b, allowing for
+// wraparound.
+func seqBefore(a, b uint32) bool {
+	return int32(a-b) < 0
+}
+
+// tcpPacket decodes the IP and TCP layers of the packet, and returns the IP
+// addresses, the IP length, the TCP payload length, and the TCP layer.  The
+// lengths are taken from the headers, since the capture may be truncated.
+func (p *Packet) tcpPacket() (net.IP, net.IP, int64, uint32, *layers.TCP, bool) {
+	pkt := gopacket.NewPacket(p.Data, layers.LayerTypeEthernet, gopacket.DecodeOptions{
+		Lazy:   true,
+		NoCopy: true,
+	})
+	tcp, ok := pkt.Layer(layers.LayerTypeTCP).(*layers.TCP)
+	if !ok {
+		return nil, nil, 0, 0, nil, false
+	}
+	tcpHeader := 4 * int64(tcp.DataOffset)
+	var src, dst net.IP
+	var length, payload int64
+	if ip, ok := pkt.Layer(layers.LayerTypeIPv4).(*layers.IPv4); ok {
+		src, dst = ip.SrcIP, ip.DstIP
+		length = int64(ip.Length)
+		payload = length - 4*int64(ip.IHL) - tcpHeader
+	} else if ip, ok := pkt.Layer(layers.LayerTypeIPv6).(*layers.IPv6); ok {
+		// The IPv6 Length is the payload length, excluding the 40 byte header.
+		src, dst = ip.SrcIP, ip.DstIP
+		length = int64(ip.Length) + 40
+		payload = int64(ip.Length) - tcpHeader
+	} else {
+		return nil, nil, 0, 0, nil, false
+	}
+	if payload < 0 {
+		payload = 0
+	}
+	return src, dst, length, uint32(payload), tcp, true
+}
+
+// setSYNOptions sets the TCP option fields of the summary from a SYN.
+func setSYNOptions(s *schema.PCAPSummary, tcp *layers.TCP) {
+	for _, opt := range tcp.Options {
+		switch opt.OptionType {
+		case layers.TCPOptionKindMSS:
+			if len(opt.OptionData) == 2 {
+				s.MSS = int64(binary.BigEndian.Uint16(opt.OptionData))
+			}
+		case layers.TCPOptionKindWindowScale:
+			if len(opt.OptionData) == 1 {
+				s.WindowScale = int64(opt.OptionData[0])
+			}
+		case layers.TCPOptionKindSACKPermitted:
+			s.SACKPermitted = true
+		case layers.TCPOptionKindTimestamps:
+			s.Timestamps = true
+		}
+	}
+}
+
+// SummarizeFlow returns the summary of the TCP flow in the packets, or nil if
+// there are no TCP packets.  Packets that are not TCP are ignored.
+func SummarizeFlow(packets []Packet) *schema.PCAPSummary {
+	var s *schema.PCAPSummary
+	var srcIP net.IP
+	var srcPort layers.TCPPort
+	var sawSYN bool
+	var dirs [2]flowDirection // From the source, and to the source.
+	for i := range packets {
+		src, dst, length, payload, tcp, ok := packets[i].tcpPacket()
+		if !ok {
+			continue
+		}
+		if s == nil {
+			srcIP, srcPort = src, tcp.SrcPort
+			s = &schema.PCAPSummary{
+				SrcIP:     src.String(),
+				SrcPort:   int64(tcp.SrcPort),
+				DstIP:     dst.String(),
+				DstPort:   int64(tcp.DstPort),
+				StartTime: packets[i].Ci.Timestamp,
+			}
+		}
+		s.EndTime = packets[i].Ci.Timestamp
+		s.Packets++
+		s.Bytes += length
+		d := &dirs[1]
+		if src.Equal(srcIP) && tcp.SrcPort == srcPort {
+			d = &dirs[0]
+			s.SrcPackets++
+			s.SrcBytes += length
+		} else {
+			s.DstPackets++
+			s.DstBytes += length
+		}
+		if tcp.SYN && !sawSYN {
+			sawSYN = true
+			setSYNOptions(s, tcp)
+		}
+		if payload == 0 {
+			continue
+		}
+		end := tcp.Seq + payload
+		if d.seen && !seqBefore(d.next, end) {
+			s.Retransmissions++
+			continue
+		}
+		d.seen = true
+		d.next = end
+	}
+	return s
+}
+
 //=====================================================================================
 //                       PCAP Parser
 //=====================================================================================
 
-const pcapSuffix = ".pcap.gz"
+// pcapSuffixes are the file name suffixes of pcap files, compressed or not.
+var pcapSuffixes = []string{".pcap.gz", ".pcap"}
 
 // PCAPParser parses the PCAP datatype from the packet-headers process.
 type PCAPParser struct {
 	*row.Base
 	table  string
 	suffix string
+
+	uuidMap *UUIDMapper // Optional.
 }
 
 // NewPCAPParser returns a new parser for PCAP archives.
 func NewPCAPParser(sink row.Sink, table, suffix string) etl.Parser {
 	bufSize := etl.PCAP.BQBufferSize()
 	return &PCAPParser{
-		Base:   row.NewBase(table, sink, bufSize),
+		Base:   row.NewAdaptiveBase(table, sink, bufSize),
 		table:  table,
 		suffix: suffix,
 	}
 
 }
 
+// SetUUIDMapper sets the UUIDMapper used to record the UUID of each test.
+func (p *PCAPParser) SetUUIDMapper(m *UUIDMapper) {
+	p.uuidMap = m
+}
+
 // IsParsable returns the canonical test type and whether to parse data.
 func (p *PCAPParser) IsParsable(testName string, data []byte) (string, bool) {
-	// Files look like (.*).pcap.gz .
-	if strings.HasSuffix(testName, pcapSuffix) {
-		return "pcap", true
+	// Files look like (.*).pcap.gz or (.*).pcap .
+	for _, suffix := range pcapSuffixes {
+		if strings.HasSuffix(testName, suffix) {
+			return "pcap", true
+		}
 	}
 	return "", false
 }
@@ -150,11 +279,13 @@ func (p *PCAPParser) ParseAndInsert(fileMetadata map[string]bigquery.Value, test
 
 	row := schema.PCAPRow{
 		Parser: schema.ParseInfo{
-			Version:    Version(),
-			Time:       time.Now(),
-			ArchiveURL: fileMetadata["filename"].(string),
-			Filename:   testName,
-			GitCommit:  GitCommit(),
+			Version:     Version(),
+			Time:        p.Now(),
+			ArchiveURL:  fileMetadata["filename"].(string),
+			Filename:    testName,
+			GitCommit:   GitCommit(),
+			FileModTime: fileModTime(fileMetadata),
+			FileSize:    fileSize(fileMetadata),
 		},
 	}
 
@@ -164,12 +295,15 @@ func (p *PCAPParser) ParseAndInsert(fileMetadata map[string]bigquery.Value, test
 	// will these dates.
 	row.Date = fileMetadata["date"].(civil.Date)
 	row.ID = p.GetUUID(testName)
+	p.uuidMap.mapTest(p.TableName(), "pcap", fileMetadata, testName, row.ID)
 
-	// Parse top level PCAP data and update metrics.
-	// TODO - add schema fields here.
-	_, _ = GetPackets(rawContent)
+	// Parse top level PCAP data, update metrics, and summarize the flow.  A
+	// file that cannot be read still produces a row, without a summary.
+	packets, _ := GetPackets(rawContent)
+	row.A = SummarizeFlow(packets)
 
 	// Insert the row.
+	p.ExpectRows(testName, 1)
 	if err := p.Put(&row); err != nil {
 		return err
 	}
@@ -189,7 +323,12 @@ func (p *PCAPParser) ParseAndInsert(fileMetadata map[string]bigquery.Value, test
 // it returns ndt-4c6fb_1625899199_00000000013A4623.
 func (p *PCAPParser) GetUUID(filename string) string {
 	id := filepath.Base(filename)
-	return strings.TrimSuffix(id, pcapSuffix)
+	for _, suffix := range pcapSuffixes {
+		if strings.HasSuffix(id, suffix) {
+			return strings.TrimSuffix(id, suffix)
+		}
+	}
+	return id
 }
 
 // NB: These functions are also required to complete the etl.Parser interface
@@ -56,8 +56,12 @@ func TestPCAPParser_ParseAndInsert(t *testing.T) {
 		GitCommit:  "12345678",
 	}
 
+	if row.A == nil || row.A.Packets == 0 {
+		t.Errorf("PCAPParser.ParseAndInsert() missing flow summary: %+v", row.A)
+	}
 	expectedPCAPRow := schema.PCAPRow{
 		ID:     "ndt-4c6fb_1625899199_000000000121C1A0",
+		A:      row.A, // Checked by TestSummarizeFlow.
 		Parser: expectedParseInfo,
 		Date:   date,
 	}
@@ -117,6 +121,11 @@ func TestPCAPParser_GetUUID(t *testing.T) {
 			filename: "ndt-4c6fb_1625899199_00000000013A4623.pcap.gz",
 			want:     "ndt-4c6fb_1625899199_00000000013A4623",
 		},
+		{
+			name:     "uncompressed",
+			filename: "2021/07/22/ndt-4c6fb_1625899199_00000000013A4623.pcap",
+			want:     "ndt-4c6fb_1625899199_00000000013A4623",
+		},
 		{
 			name:     "empty-string",
 			filename: "",
@@ -184,6 +193,55 @@ func TestIPLayer(t *testing.T) {
 	}
 }
 
+func TestSummarizeFlow(t *testing.T) {
+	tests := []struct {
+		name        string
+		fn          string
+		srcIP       string
+		retransmits bool
+	}{
+		{name: "retransmits", fn: "testdata/PCAP/ndt-nnwk2_1611335823_00000000000C2DFE.pcap.gz",
+			srcIP: "173.49.19.128", retransmits: true},
+		{name: "ipv6", fn: "testdata/PCAP/ndt-nnwk2_1611335823_00000000000C2DA8.pcap.gz",
+			srcIP: "2a0d:5600:24:a71::1d"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := ioutil.ReadFile(tt.fn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			packets, err := parser.GetPackets(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := parser.SummarizeFlow(packets)
+			if s == nil {
+				t.Fatal("SummarizeFlow() = nil")
+			}
+			if s.SrcIP != tt.srcIP {
+				t.Errorf("SrcIP = %s, want %s", s.SrcIP, tt.srcIP)
+			}
+			if s.Packets != int64(len(packets)) || s.SrcPackets+s.DstPackets != s.Packets {
+				t.Errorf("Packets = %d, SrcPackets = %d, DstPackets = %d, want %d in total",
+					s.Packets, s.SrcPackets, s.DstPackets, len(packets))
+			}
+			if s.SrcBytes+s.DstBytes != s.Bytes || s.SrcPackets > 0 && s.SrcBytes == 0 {
+				t.Errorf("Bytes = %d, SrcBytes = %d, DstBytes = %d", s.Bytes, s.SrcBytes, s.DstBytes)
+			}
+			if !s.StartTime.Equal(packets[0].Ci.Timestamp) || !s.EndTime.Equal(packets[len(packets)-1].Ci.Timestamp) {
+				t.Errorf("StartTime, EndTime = %v, %v", s.StartTime, s.EndTime)
+			}
+			if (s.Retransmissions > 0) != tt.retransmits {
+				t.Errorf("Retransmissions = %d, want retransmits %t", s.Retransmissions, tt.retransmits)
+			}
+		})
+	}
+	if s := parser.SummarizeFlow(nil); s != nil {
+		t.Errorf("SummarizeFlow(nil) = %+v, want nil", s)
+	}
+}
+
 func TestPCAPGarbage(t *testing.T) {
 	data := []byte{0xd4, 0xc3, 0xb2, 0xa1, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
 	_, err := parser.GetPackets(data)
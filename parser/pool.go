@@ -0,0 +1,87 @@
+package parser
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/m-lab/tcp-info/snapshot"
+)
+
+// Pools of per-test scratch space, reused across calls to ParseAndInsert to
+// reduce allocation and GC pressure at high throughput.  Values taken from a
+// pool must not be referenced by any row passed to Put, since rows may remain
+// buffered after ParseAndInsert returns.
+
+// maxPooledBuffer limits the size of buffers returned to bufferPool, so that
+// an occasional very large test does not pin memory indefinitely.
+const maxPooledBuffer = 64 << 20
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, 1<<20)
+		return &b
+	},
+}
+
+// getBuffer returns an empty byte slice from the pool.
+func getBuffer() *[]byte {
+	b := bufferPool.Get().(*[]byte)
+	*b = (*b)[:0]
+	return b
+}
+
+// putBuffer returns a byte slice to the pool.
+func putBuffer(b *[]byte) {
+	if cap(*b) > maxPooledBuffer {
+		return
+	}
+	bufferPool.Put(b)
+}
+
+// maxPooledSnaps limits the capacity of snapshot slices returned to snapsPool.
+const maxPooledSnaps = 20000
+
+var snapsPool = sync.Pool{
+	New: func() interface{} {
+		s := make([]snapshot.Snapshot, 0, 2000)
+		return &s
+	},
+}
+
+// getSnaps returns an empty snapshot slice from the pool.
+func getSnaps() *[]snapshot.Snapshot {
+	s := snapsPool.Get().(*[]snapshot.Snapshot)
+	*s = (*s)[:0]
+	return s
+}
+
+// putSnaps clears the snapshot slice, so it doesn't retain references to
+// decoded records, and returns it to the pool.
+func putSnaps(s *[]snapshot.Snapshot) {
+	if cap(*s) > maxPooledSnaps {
+		return
+	}
+	for i := range *s {
+		(*s)[i] = snapshot.Snapshot{}
+	}
+	snapsPool.Put(s)
+}
+
+var readerPool = sync.Pool{
+	New: func() interface{} {
+		return bytes.NewReader(nil)
+	},
+}
+
+// getReader returns a bytes.Reader from the pool, reading from data.
+func getReader(data []byte) *bytes.Reader {
+	r := readerPool.Get().(*bytes.Reader)
+	r.Reset(data)
+	return r
+}
+
+// putReader returns a bytes.Reader to the pool.
+func putReader(r *bytes.Reader) {
+	r.Reset(nil)
+	readerPool.Put(r)
+}
@@ -366,7 +366,7 @@ const PTBufferSize int = 2
 func NewPTParser(sink row.Sink, table, suffix string) *PTParser {
 	bufSize := etl.PT.BQBufferSize()
 	return &PTParser{
-		Base:  row.NewBase(table, sink, bufSize),
+		Base:  row.NewAdaptiveBase(table, sink, bufSize),
 		table: table,
 	}
 }
@@ -500,7 +500,7 @@ func (pt *PTParser) TableName() string {
 func (pt *PTParser) InsertOneTest(oneTest cachedPTData) {
 	parseInfo := schema.ParseInfoV0{
 		TaskFileName:  pt.taskFileName,
-		ParseTime:     time.Now(),
+		ParseTime:     pt.Now(),
 		ParserVersion: Version(),
 		Filename:      oneTest.TestID,
 	}
@@ -581,6 +581,7 @@ func (pt *PTParser) ParseAndInsert(meta map[string]bigquery.Value, testName stri
 	if strings.HasSuffix(testName, ".json") {
 		ptTest, err := ParsePT(testName, rawContent, pt.TableName(), pt.taskFileName)
 		if err == nil {
+			ptTest.Parseinfo.ParseTime = pt.Now()
 			err = pt.Put(&ptTest)
 		} else {
 			// Modify metrics
@@ -595,6 +596,7 @@ func (pt *PTParser) ParseAndInsert(meta map[string]bigquery.Value, testName stri
 	if strings.HasSuffix(testName, ".jsonl") {
 		ptTest, err := ParseJSONL(testName, rawContent, pt.TableName(), pt.taskFileName)
 		if err == nil {
+			ptTest.Parseinfo.ParseTime = pt.Now()
 			ptTest.ServerX.Site = dp.Site
 			ptTest.ServerX.Machine = dp.Host
 
@@ -3,7 +3,6 @@ package parser
 import (
 	"fmt"
 	"strings"
-	"time"
 
 	"cloud.google.com/go/bigquery"
 	"cloud.google.com/go/civil"
@@ -33,7 +32,7 @@ type Scamper1Parser struct {
 func NewScamper1Parser(sink row.Sink, table, suffix string) etl.Parser {
 	bufSize := etl.SCAMPER1.BQBufferSize()
 	return &Scamper1Parser{
-		Base:   row.NewBase(table, sink, bufSize),
+		Base:   row.NewAdaptiveBase(table, sink, bufSize),
 		table:  table,
 		suffix: suffix,
 	}
@@ -128,11 +127,13 @@ func (p *Scamper1Parser) ParseAndInsert(fileMetadata map[string]bigquery.Value,
 	parseTracelb(&bqScamperOutput, scamperOutput.Tracelb)
 
 	parseInfo := schema.ParseInfo{
-		Version:    Version(),
-		Time:       time.Now(),
-		ArchiveURL: archiveURL,
-		Filename:   testName,
-		GitCommit:  GitCommit(),
+		Version:     Version(),
+		Time:        p.Now(),
+		ArchiveURL:  archiveURL,
+		Filename:    testName,
+		GitCommit:   GitCommit(),
+		FileModTime: fileModTime(fileMetadata),
+		FileSize:    fileSize(fileMetadata),
 	}
 
 	row := schema.Scamper1Row{
@@ -143,6 +144,7 @@ func (p *Scamper1Parser) ParseAndInsert(fileMetadata map[string]bigquery.Value,
 	}
 
 	// Insert the row.
+	p.ExpectRows(testName, 1)
 	if err := p.Put(&row); err != nil {
 		return err
 	}
@@ -0,0 +1,55 @@
+package parser
+
+import (
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+
+	"github.com/m-lab/etl/metrics"
+	"github.com/m-lab/etl/schema"
+)
+
+// MaxClockSkew is the largest difference between a test's own timestamp and
+// the time its file was archived that is attributed to the test duration and
+// archiving delays.  Larger differences are recorded as server clock skew.
+var MaxClockSkew = time.Hour
+
+// clockSkew estimates the skew of the server clock that recorded testTime,
+// using the test file's modification time from the archive, if known, or the
+// archive date otherwise.  Archives are created shortly after their tests, so
+// tests may start on the day before the archive date, but not after it.  Returns
+// zero if the skew is within MaxClockSkew, or cannot be estimated.
+func clockSkew(meta map[string]bigquery.Value, testTime time.Time) time.Duration {
+	if testTime.IsZero() {
+		return 0
+	}
+	var earliest, latest time.Time
+	if mt, ok := meta["mod_time"].(time.Time); ok && !mt.IsZero() {
+		// The file is written as, or after, the test ends.
+		earliest, latest = mt.Add(-MaxClockSkew), mt.Add(MaxClockSkew)
+	} else if d, ok := meta["date"].(civil.Date); ok && d.IsValid() {
+		start := d.In(time.UTC)
+		earliest, latest = start.AddDate(0, 0, -1).Add(-MaxClockSkew), start.AddDate(0, 0, 1).Add(MaxClockSkew)
+	} else {
+		return 0
+	}
+	switch {
+	case testTime.Before(earliest):
+		return testTime.Sub(earliest)
+	case testTime.After(latest):
+		return testTime.Sub(latest)
+	}
+	return 0
+}
+
+// checkClockSkew records the clock skew estimate for testTime in the parse
+// info, and counts tests whose timestamps are skewed.
+func checkClockSkew(table, kind string, info *schema.ParseInfo, meta map[string]bigquery.Value, testTime time.Time) {
+	skew := clockSkew(meta, testTime)
+	if skew == 0 {
+		return
+	}
+	info.ClockSkew = int64(skew / time.Second)
+	metrics.WarningCount.WithLabelValues(table, kind, "clock skew").Inc()
+}
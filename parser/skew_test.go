@@ -0,0 +1,69 @@
+package parser_test
+
+import (
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+
+	"github.com/m-lab/etl/parser"
+)
+
+func TestClockSkew(t *testing.T) {
+	date := civil.Date{Year: 2020, Month: 3, Day: 18}
+	modTime := time.Date(2020, 3, 18, 6, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		meta     map[string]bigquery.Value
+		testTime time.Time
+		want     time.Duration
+	}{
+		{
+			name:     "mod-time-close",
+			meta:     map[string]bigquery.Value{"date": date, "mod_time": modTime},
+			testTime: modTime.Add(-10 * time.Second),
+		},
+		{
+			name:     "mod-time-behind",
+			meta:     map[string]bigquery.Value{"date": date, "mod_time": modTime},
+			testTime: modTime.Add(-3 * time.Hour),
+			want:     -2 * time.Hour,
+		},
+		{
+			name:     "mod-time-ahead",
+			meta:     map[string]bigquery.Value{"date": date, "mod_time": modTime},
+			testTime: modTime.Add(90 * time.Minute),
+			want:     30 * time.Minute,
+		},
+		{
+			name:     "date-previous-day",
+			meta:     map[string]bigquery.Value{"date": date},
+			testTime: time.Date(2020, 3, 17, 23, 59, 0, 0, time.UTC),
+		},
+		{
+			name:     "date-years-behind",
+			meta:     map[string]bigquery.Value{"date": date},
+			testTime: time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC),
+			want:     time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC).Sub(time.Date(2020, 3, 16, 23, 0, 0, 0, time.UTC)),
+		},
+		{
+			name:     "zero-mod-time-uses-date",
+			meta:     map[string]bigquery.Value{"date": date, "mod_time": time.Time{}},
+			testTime: time.Date(2020, 3, 19, 2, 0, 0, 0, time.UTC),
+			want:     time.Hour,
+		},
+		{
+			name:     "unknown-test-time",
+			meta:     map[string]bigquery.Value{"date": date, "mod_time": modTime},
+			testTime: time.Time{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parser.ClockSkew(tt.meta, tt.testTime); got != tt.want {
+				t.Errorf("ClockSkew() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
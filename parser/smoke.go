@@ -0,0 +1,194 @@
+package parser
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+
+	"github.com/m-lab/etl/etl"
+)
+
+// ErrSmokeTest is returned by SmokeError if any smoke test failed.
+var ErrSmokeTest = errors.New("parser smoke test failed")
+
+// smokeFiles holds the tiny test files of the built-in smoke tests.
+//
+//go:embed smoke
+var smokeFiles embed.FS
+
+// SmokeTest is a tiny test file for a datatype, and the number of rows that
+// its parser should produce from it.
+type SmokeTest struct {
+	DataType etl.DataType
+	File     string // Test file name, as in an archive.
+	Data     []byte // Content of the test file.
+	Archive  string // URL of the archive the file is from.
+	Date     civil.Date
+	Rows     int
+}
+
+// smokeTests holds the registered smoke tests, by datatype.
+var smokeTests = struct {
+	lock sync.RWMutex
+	byDT map[etl.DataType]SmokeTest
+}{byDT: map[etl.DataType]SmokeTest{}}
+
+// builtinSmokeTests are registered by init, from the files of smokeFiles.
+var builtinSmokeTests = []SmokeTest{
+	{DataType: etl.ANNOTATION, File: "annotation/ndt-njp6l_1585004303_00000000000170FA.json",
+		Archive: "gs://archive-measurement-lab/ndt/annotation/2020/03/24/20200324T000000.000000Z-annotation-mlab1-lga03-ndt.tgz",
+		Date:    civil.Date{Year: 2020, Month: 3, Day: 24}, Rows: 1},
+	{DataType: etl.HOPANNOTATION1, File: "hopannotation1/20210818T174432Z_1e0b318cf3c2_91.189.88.152.json",
+		Archive: "gs://archive-measurement-lab/ndt/hopannotation1/2021/08/18/20210818T180000.000000Z-hopannotation1-mlab1-lga03-ndt.tgz",
+		Date:    civil.Date{Year: 2021, Month: 8, Day: 18}, Rows: 1},
+	{DataType: etl.NDT5, File: "ndt5/ndt-5hkck_1566219987_000000000000017D.json",
+		Archive: "gs://archive-measurement-lab/ndt/ndt5/2019/08/22/20190822T000000.000000Z-ndt5-mlab1-lga03-ndt.tgz",
+		Date:    civil.Date{Year: 2019, Month: 8, Day: 22}, Rows: 2},
+	{DataType: etl.NDT7, File: "ndt7/ndt7-download-20200318T000657.568382877Z.ndt-knwp4_1583603744_000000000000590E.json",
+		Archive: "gs://archive-measurement-lab/ndt/ndt7/2020/03/18/20200318T000000.000000Z-ndt7-mlab1-lga03-ndt.tgz",
+		Date:    civil.Date{Year: 2020, Month: 3, Day: 18}, Rows: 1},
+	{DataType: etl.PCAP, File: "pcap/ndt-nnwk2_1611335823_00000000000C2DA8.pcap.gz",
+		Archive: "gs://archive-measurement-lab/ndt/pcap/2021/07/22/20210722T000000.000000Z-pcap-mlab1-lga03-ndt.tgz",
+		Date:    civil.Date{Year: 2021, Month: 7, Day: 22}, Rows: 1},
+	{DataType: etl.SCAMPER1, File: "scamper1/valid.jsonl",
+		Archive: "gs://archive-measurement-lab/ndt/scamper1/2021/09/14/20210914T000000.000000Z-scamper1-mlab1-lga03-ndt.tgz",
+		Date:    civil.Date{Year: 2021, Month: 9, Day: 14}, Rows: 1},
+	{DataType: etl.SW, File: "switch/discov2-switch.jsonl",
+		Archive: "gs://archive-measurement-lab/utilization/switch/2021/12/14/20211214T000000.000000Z-switch-mlab2-dfw07-utilization.tgz",
+		Date:    civil.Date{Year: 2021, Month: 12, Day: 14}, Rows: 30},
+	{DataType: etl.TCPINFO, File: "tcpinfo/ndt-q5zbq_1555433454_0000000000002BA4.00000.jsonl.zst",
+		Archive: "gs://archive-measurement-lab/ndt/tcpinfo/2019/05/16/20190516T013026.744845Z-tcpinfo-mlab4-arn02-ndt.tgz",
+		Date:    civil.Date{Year: 2019, Month: 5, Day: 16}, Rows: 1},
+}
+
+func init() {
+	for _, t := range builtinSmokeTests {
+		data, err := smokeFiles.ReadFile(path.Join("smoke", t.File))
+		if err != nil {
+			panic(err)
+		}
+		t.File = path.Base(t.File)
+		t.Data = data
+		RegisterSmokeTest(t)
+	}
+}
+
+// RegisterSmokeTest sets the smoke test of the test's datatype, replacing
+// any earlier one, so that datatypes registered with RegisterParser can be
+// smoke tested too.  This should typically be called during program
+// initialization.
+func RegisterSmokeTest(t SmokeTest) {
+	smokeTests.lock.Lock()
+	defer smokeTests.lock.Unlock()
+	smokeTests.byDT[t.DataType] = t
+}
+
+// SmokeResult is the outcome of the smoke test of a datatype's parser.
+type SmokeResult struct {
+	DataType etl.DataType `json:"datatype"`
+	File     string       `json:"file"`
+	Rows     int          `json:"rows"`
+	Want     int          `json:"want"`
+	Error    string       `json:"error,omitempty"`
+}
+
+// countingSink counts the rows committed to it, after checking that each
+// can be encoded as JSON, as the output sinks do.
+type countingSink struct {
+	rows int
+}
+
+func (s *countingSink) Commit(rows []interface{}, label string) (int, error) {
+	for i := range rows {
+		if _, err := json.Marshal(rows[i]); err != nil {
+			return 0, err
+		}
+	}
+	s.rows += len(rows)
+	return len(rows), nil
+}
+
+func (s *countingSink) Close() error {
+	return nil
+}
+
+// run parses the test file with the parser registered for its datatype, and
+// returns the result.
+func (t SmokeTest) run() (r SmokeResult) {
+	r = SmokeResult{DataType: t.DataType, File: t.File, Want: t.Rows}
+	// A broken parser should fail its test, not the caller.
+	defer func() {
+		if p := recover(); p != nil {
+			r.Error = fmt.Sprint("panic: ", p)
+		}
+	}()
+	sink := &countingSink{}
+	p := NewSinkParser(t.DataType, sink, "smoke", "")
+	if p == nil {
+		r.Error = "no parser"
+		return r
+	}
+	if _, ok := p.IsParsable(t.File, t.Data); !ok {
+		r.Error = "file is not parsable"
+		return r
+	}
+	meta := map[string]bigquery.Value{"filename": t.Archive, "date": t.Date}
+	err := p.ParseAndInsert(meta, t.File, t.Data)
+	if err == nil {
+		err = p.Flush()
+	}
+	if err == nil {
+		err = p.TaskError()
+	}
+	r.Rows = sink.rows
+	switch {
+	case err != nil:
+		r.Error = err.Error()
+	case r.Rows != r.Want:
+		r.Error = fmt.Sprintf("parsed %d rows, want %d", r.Rows, r.Want)
+	}
+	return r
+}
+
+// RunSmokeTests parses the registered smoke test of each datatype with its
+// parser, and returns the results, ordered by datatype.  Rows are counted
+// rather than written, so this is cheap enough to run at startup, and
+// detects a broken parser before it consumes real tasks.
+func RunSmokeTests() []SmokeResult {
+	smokeTests.lock.RLock()
+	tests := make([]SmokeTest, 0, len(smokeTests.byDT))
+	for _, t := range smokeTests.byDT {
+		tests = append(tests, t)
+	}
+	smokeTests.lock.RUnlock()
+	sort.Slice(tests, func(i, j int) bool { return tests[i].DataType < tests[j].DataType })
+
+	results := make([]SmokeResult, len(tests))
+	for i := range tests {
+		results[i] = tests[i].run()
+	}
+	return results
+}
+
+// SmokeError returns ErrSmokeTest, describing each failure, if any of the
+// results failed, and nil otherwise.
+func SmokeError(results []SmokeResult) error {
+	var failed []string
+	for _, r := range results {
+		if r.Error != "" {
+			failed = append(failed, fmt.Sprintf("%s: %s", r.DataType, r.Error))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%w: %s", ErrSmokeTest, strings.Join(failed, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package parser_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/m-lab/etl/etl"
+	"github.com/m-lab/etl/parser"
+)
+
+func TestRunSmokeTests(t *testing.T) {
+	noParser := etl.DataType("smoke_no_parser")
+	var results []parser.SmokeResult
+	for _, r := range parser.RunSmokeTests() {
+		if r.DataType == noParser {
+			continue // Registered below, by an earlier run of the test.
+		}
+		if r.Error != "" {
+			t.Errorf("%s smoke test of %s: %s", r.DataType, r.File, r.Error)
+		}
+		results = append(results, r)
+	}
+	if len(results) != 8 {
+		t.Errorf("RunSmokeTests() ran %d built-in tests, want 8", len(results))
+	}
+	if err := parser.SmokeError(results); err != nil {
+		t.Error(err)
+	}
+
+	// A datatype without a parser fails.
+	parser.RegisterSmokeTest(parser.SmokeTest{DataType: noParser, File: "x.json"})
+	if err := parser.SmokeError(parser.RunSmokeTests()); !errors.Is(err, parser.ErrSmokeTest) {
+		t.Errorf("SmokeError() = %v, want %v", err, parser.ErrSmokeTest)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
 
 	"github.com/m-lab/etl/etl"
 	"github.com/m-lab/etl/metrics"
@@ -19,20 +20,24 @@ import (
 	"github.com/m-lab/etl/web100"
 )
 
-// Parser for parsing sidestream tests.
+//=====================================================================================
+//                       Sidestream Datatype Parser
+//=====================================================================================
 
 // SSParser provides a parser implementation for SideStream data.
 type SSParser struct {
 	*row.Base
-	table string
+	table  string
+	suffix string
 }
 
 // NewSSParser creates a new sidestream parser.
-func NewSSParser(sink row.Sink, table, suffix string) *SSParser {
+func NewSSParser(sink row.Sink, table, suffix string) etl.Parser {
 	bufSize := etl.SS.BQBufferSize()
 	return &SSParser{
-		Base:  row.NewBase(table, sink, bufSize),
-		table: table,
+		Base:   row.NewAdaptiveBase(table, sink, bufSize),
+		table:  table,
+		suffix: suffix,
 	}
 }
 
@@ -57,30 +62,22 @@ func ExtractLogtimeFromFilename(fileName string) (time.Time, error) {
 
 // ParseKHeader parses the first line of SS file, in format "K: cid PollTime LocalAddress LocalPort ... other_web100_variables_separated_by_space"
 func ParseKHeader(header string) ([]string, error) {
-	var varNames []string
 	web100Vars := strings.Split(header, " ")
 	if web100Vars[0] != "K:" {
-		return varNames, errors.New("Corrupted header")
+		return nil, errors.New("Corrupted header")
 	}
 
 	data, err := web100.Asset("tcp-kis.txt")
 	if err != nil {
-		// TODO - convert this panic to something else.
-		panic("tcp-kis.txt not found")
+		return nil, err
 	}
-	b := bytes.NewBuffer(data)
-
-	mapping, err := web100.ParseWeb100Definitions(b)
+	mapping, err := web100.ParseWeb100Definitions(bytes.NewBuffer(data))
 	if err != nil {
-		// TODO - convert this panic to something else.
-		panic("tcp-kis.txt not found")
+		return nil, err
 	}
 
-	for index, name := range web100Vars {
-		if index == 0 {
-			continue
-		}
-
+	varNames := make([]string, 0, len(web100Vars)-1)
+	for _, name := range web100Vars[1:] {
 		if mapping[name] != "" {
 			varNames = append(varNames, mapping[name])
 		} else {
@@ -90,76 +87,65 @@ func ParseKHeader(header string) ([]string, error) {
 	return varNames, nil
 }
 
-// TableName of the table that this Parser inserts into.
-func (ss *SSParser) TableName() string {
-	return ss.table
-}
-
-// PackDataIntoSchema packs data into sidestream BigQeury schema and buffers it.
-func PackDataIntoSchema(ssValue map[string]string, logTime time.Time, testName string) (schema.SS, error) {
+// PackDataIntoSchema packs the values of one snapshot into a sidestream row.
+// The Parser and Date fields are left for the caller.
+func PackDataIntoSchema(ssValue map[string]string, logTime time.Time, testName string) (*schema.SSRow, error) {
 	localPort, err := strconv.Atoi(ssValue["LocalPort"])
 	if err != nil {
-		return schema.SS{}, err
+		return nil, err
 	}
 	remotePort, err := strconv.Atoi(ssValue["RemPort"])
 	if err != nil {
-		return schema.SS{}, err
+		return nil, err
 	}
 
-	ssValue["LocalAddress"] = NormalizeIP(ssValue["LocalAddress"])
-	ssValue["RemAddress"] = NormalizeIP(ssValue["RemAddress"])
-	connSpec := &schema.Web100ConnectionSpecification{
-		Local_ip:    ssValue["LocalAddress"],
-		Local_af:    web100.ParseIPFamily(ssValue["LocalAddress"]),
-		Local_port:  int64(localPort),
-		Remote_ip:   ssValue["RemAddress"],
-		Remote_port: int64(remotePort),
-	}
-
-	// NOTE: Annotation was previously done here, using AddGeoDataSS...(), but it now done
-	// in ss.Annotate, prior to inserter.PutAsync
+	localIP := NormalizeIP(ssValue["LocalAddress"])
+	remoteIP := NormalizeIP(ssValue["RemAddress"])
+	ssValue["LocalAddress"] = localIP
+	ssValue["RemAddress"] = remoteIP
 	snap, err := PopulateSnap(ssValue)
 	if err != nil {
-		return schema.SS{}, err
+		return nil, err
 	}
-	web100Log := &schema.Web100LogEntry{
-		LogTime:         logTime.Unix(), // TODO: Should use timestamp, not integer
-		Version:         "unknown",
-		Group_name:      "read", // TODO: Use Camelcase, with json annotations?
-		Connection_spec: *connSpec,
-		Snap:            snap,
+	raw := &schema.Web100LogEntry{
+		LogTime:    logTime.Unix(),
+		Version:    "unknown",
+		Group_name: "read",
+		Connection_spec: schema.Web100ConnectionSpecification{
+			Local_ip:    localIP,
+			Local_af:    web100.ParseIPFamily(localIP),
+			Local_port:  int64(localPort),
+			Remote_ip:   remoteIP,
+			Remote_port: int64(remotePort),
+		},
+		Snap: snap,
 	}
 
-	// Create a synthetic UUID for joining with annotations.
-	id := ssSyntheticUUID(
-		testName,
-		web100Log.Snap.StartTimeStamp,
-		web100Log.Connection_spec.Local_ip,
-		web100Log.Connection_spec.Local_port,
-		web100Log.Connection_spec.Remote_ip,
-		web100Log.Connection_spec.Remote_port)
-
-	ssTest := &schema.SS{
-		ID:               id,
-		TestID:           testName,
-		LogTime:          logTime.Unix(),
-		Type:             int64(1),
-		Project:          int64(2),
-		Web100_log_entry: *web100Log,
-	}
-	return *ssTest, nil
+	return &schema.SSRow{
+		// Create a synthetic UUID for joining with annotations.
+		ID: ssSyntheticUUID(testName, snap.StartTimeStamp,
+			localIP, int64(localPort), remoteIP, int64(remotePort)),
+		A: &schema.SSSummary{
+			TestID:     testName,
+			LogTime:    logTime,
+			StartTime:  time.UnixMicro(snap.StartTimeStamp).UTC(),
+			ServerIP:   localIP,
+			ServerPort: int64(localPort),
+			ClientIP:   remoteIP,
+			ClientPort: int64(remotePort),
+		},
+		Raw: raw,
+	}, nil
 }
 
 // ParseOneLine parses a single line of sidestream data.
 func ParseOneLine(snapshot string, varNames []string) (map[string]string, error) {
 	value := strings.Split(snapshot, " ")
-	ssValue := make(map[string]string)
 	if value[0] != "C:" || len(value) != len(varNames)+1 {
-		log.Printf("corrupted content:")
-		log.Printf(snapshot)
-		return ssValue, errors.New("corrupted content")
+		return nil, errors.New("corrupted content")
 	}
 
+	ssValue := make(map[string]string, len(varNames))
 	for index, val := range value[1:] {
 		// Match value with var_name
 		ssValue[varNames[index]] = val
@@ -167,7 +153,8 @@ func ParseOneLine(snapshot string, varNames []string) (map[string]string, error)
 	return ssValue, nil
 }
 
-// PopulateSnap fills in the snapshot data.
+// PopulateSnap fills in the snapshot data.  Variables that are not web100
+// variables are ignored.
 func PopulateSnap(ssValue map[string]string) (schema.Web100Snap, error) {
 	var snap = &schema.Web100Snap{}
 	var startTimeUsec int64
@@ -183,32 +170,30 @@ func PopulateSnap(ssValue map[string]string) (schema.Web100Snap, error) {
 
 	// Process every other snap key.
 	for key := range ssValue {
-		// Skip cid and PollTime. They are SideStream-specific fields, not web100 variables.
-		if key == "cid" || key == "PollTime" {
-			continue
-		}
-		// Skip StartTimeUsec because this is not part of the Web100Snap struct.
-		if key == "StartTimeUsec" {
+		x := reflect.ValueOf(snap).Elem().FieldByName(key)
+		// Skip variables that are not part of the Web100Snap struct, e.g.
+		// the SideStream-specific cid and PollTime, and StartTimeUsec.
+		if !x.IsValid() {
 			continue
 		}
-		x := reflect.ValueOf(snap).Elem().FieldByName(key)
 
-		switch x.Type().String() {
-		case "int64":
+		switch x.Kind() {
+		case reflect.Int64:
 			value, err := strconv.ParseInt(ssValue[key], 10, 64)
 			if err != nil {
 				return *snap, err
 			}
 			x.SetInt(value)
-		case "string":
-			x.Set(reflect.ValueOf(ssValue[key]))
-		case "bool":
-			if ssValue[key] == "0" {
-				x.Set(reflect.ValueOf(false))
-			} else if ssValue[key] == "1" {
-				x.Set(reflect.ValueOf(true))
-			} else {
-				return *snap, errors.New("Cannot parse field " + key + " into a valie bool value.")
+		case reflect.String:
+			x.SetString(ssValue[key])
+		case reflect.Bool:
+			switch ssValue[key] {
+			case "0":
+				x.SetBool(false)
+			case "1":
+				x.SetBool(true)
+			default:
+				return *snap, errors.New("Cannot parse field " + key + " into a valid bool value.")
 			}
 		}
 	}
@@ -241,7 +226,7 @@ func (ss *SSParser) ParseAndInsert(meta map[string]bigquery.Value, testName stri
 	if err != nil {
 		return err
 	}
-	testContent := strings.Split(string(rawContent[:]), "\n")
+	testContent := strings.Split(string(rawContent), "\n")
 	if len(testContent) < 2 {
 		return errors.New("empty test file")
 	}
@@ -251,9 +236,13 @@ func (ss *SSParser) ParseAndInsert(meta map[string]bigquery.Value, testName stri
 			ss.TableName(), "ss", "corrupted header").Inc()
 		return err
 	}
-	for _, oneLine := range testContent[1:] {
-		oneLine = strings.TrimSuffix(oneLine, "\n")
 
+	archiveURL, _ := meta["filename"].(string)
+	date, _ := meta["date"].(civil.Date)
+	// The archive URL must already be valid, so the error is safe to ignore.
+	dp, _ := etl.ValidateTestPath(archiveURL)
+
+	for _, oneLine := range testContent[1:] {
 		if len(oneLine) == 0 {
 			continue
 		}
@@ -277,7 +266,7 @@ func (ss *SSParser) ParseAndInsert(meta map[string]bigquery.Value, testName stri
 			log.Printf("Invalid client IP address: %s with error: %s", ssValue["RemAddress"], err)
 			continue
 		}
-		ssTest, err := PackDataIntoSchema(ssValue, logTime, testName)
+		ssRow, err := PackDataIntoSchema(ssValue, logTime, testName)
 		if err != nil {
 			metrics.TestTotal.WithLabelValues(
 				ss.TableName(), "ss", "corrupted data").Inc()
@@ -285,19 +274,21 @@ func (ss *SSParser) ParseAndInsert(meta map[string]bigquery.Value, testName stri
 			continue
 		}
 
-		ssTest.ParseTime = time.Now() // for map, use string(time.Now().MarshalText())
-		ssTest.ParserVersion = Version()
-		if meta["filename"] != nil {
-			ssTest.TaskFileName = meta["filename"].(string)
+		ssRow.Parser = schema.ParseInfo{
+			Version:     Version(),
+			Time:        ss.Now(),
+			ArchiveURL:  archiveURL,
+			Filename:    testName,
+			GitCommit:   GitCommit(),
+			FileModTime: fileModTime(meta),
+			FileSize:    fileSize(meta),
 		}
-
-		// ArchiveURL must already be valid, so error is safe to ignore.
-		dp, _ := etl.ValidateTestPath(ssTest.TaskFileName)
-		ssTest.Web100_log_entry.Connection_spec.ServerX.Site = dp.Site
-		ssTest.Web100_log_entry.Connection_spec.ServerX.Machine = dp.Host
+		ssRow.Date = date
+		ssRow.Raw.Connection_spec.ServerX.Site = dp.Site
+		ssRow.Raw.Connection_spec.ServerX.Machine = dp.Host
 
 		// Add row to buffer, possibly flushing buffer if it is full.
-		err = ss.Put(&ssTest)
+		err = ss.Put(ssRow)
 		if err != nil {
 			metrics.ErrorCount.WithLabelValues(
 				ss.TableName(), "ss", "insert-err").Inc()
@@ -310,3 +301,41 @@ func (ss *SSParser) ParseAndInsert(meta map[string]bigquery.Value, testName stri
 	}
 	return nil
 }
+
+// NB: These functions are also required to complete the etl.Parser interface.
+// For SSParser, we just forward the calls to the Base.
+
+// Flush flushes any pending rows.
+func (ss *SSParser) Flush() error {
+	return ss.Base.Flush()
+}
+
+// TableName of the table that this Parser inserts into.
+func (ss *SSParser) TableName() string {
+	return ss.table
+}
+
+// FullTableName of the table, including the suffix.
+func (ss *SSParser) FullTableName() string {
+	return ss.table + ss.suffix
+}
+
+// RowsInBuffer returns the count of rows currently in the buffer.
+func (ss *SSParser) RowsInBuffer() int {
+	return ss.GetStats().Pending
+}
+
+// Committed returns the count of rows successfully committed to BQ.
+func (ss *SSParser) Committed() int {
+	return ss.GetStats().Committed
+}
+
+// Accepted returns the count of all rows received through InsertRow(s).
+func (ss *SSParser) Accepted() int {
+	return ss.GetStats().Total()
+}
+
+// Failed returns the count of all rows that could not be committed.
+func (ss *SSParser) Failed() int {
+	return ss.GetStats().Failed
+}
@@ -3,10 +3,12 @@ package parser_test
 import (
 	"fmt"
 	"io/ioutil"
+	"strings"
 	"testing"
 	"time"
 
 	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
 	"github.com/go-test/deep"
 
 	"github.com/m-lab/etl/parser"
@@ -65,9 +67,9 @@ func TestParseOneLine(t *testing.T) {
 	}
 }
 
-func TestSSInserter(t *testing.T) {
-	ins := &inMemoryInserter{}
-	p := parser.NewSSParser(ins, "sidestream", "")
+func TestSSParser(t *testing.T) {
+	sink := newInMemorySink()
+	p := parser.NewSSParser(sink, "sidestream", "_20170203")
 	filename := "testdata/sidestream/20170203T00:00:00Z_ALL0.web100"
 	rawData, err := ioutil.ReadFile(filename)
 	if err != nil {
@@ -75,8 +77,8 @@ func TestSSInserter(t *testing.T) {
 	}
 
 	taskFilename := "gs://archive-measurement-lab/sidestream/2019/11/20/20191120T010010Z-mlab1-ord03-sidestream-0000.tgz"
-
-	meta := map[string]bigquery.Value{"filename": taskFilename}
+	date := civil.Date{Year: 2019, Month: 11, Day: 20}
+	meta := map[string]bigquery.Value{"filename": taskFilename, "date": date}
 	err = p.ParseAndInsert(meta, filename, rawData)
 	if err != nil {
 		t.Fatalf(err.Error())
@@ -86,31 +88,51 @@ func TestSSInserter(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	if ins.Committed() != 6 {
-		t.Fatalf("Expected %d, Got %d.", 6, ins.Committed())
+	if p.Committed() != 6 {
+		t.Fatalf("Expected %d, Got %d.", 6, p.Committed())
 	}
-
-	if len(ins.data) < 1 {
-		t.Fatal("Should have at least one inserted row")
+	if p.FullTableName() != "sidestream_20170203" {
+		t.Errorf("FullTableName() = %q, want sidestream_20170203", p.FullTableName())
 	}
 
-	inserted := ins.data[0].(*schema.SS)
-	if inserted.ParseTime.After(time.Now()) {
-		t.Error("Should have inserted parse_time")
+	inserted := sink.data[0].(*schema.SSRow)
+	if inserted.Parser.Time.After(time.Now()) {
+		t.Error("Should have inserted parse time")
 	}
-	if inserted.TaskFileName != taskFilename {
-		t.Error("Should have correct filename", taskFilename, "!=", inserted.TaskFileName)
+	if inserted.Parser.ArchiveURL != taskFilename {
+		t.Error("Should have correct archive URL", taskFilename, "!=", inserted.Parser.ArchiveURL)
 	}
-
-	if inserted.ParserVersion != "https://github.com/m-lab/etl/tree/foobar" {
+	if inserted.Parser.Filename != filename {
+		t.Error("Should have correct filename", filename, "!=", inserted.Parser.Filename)
+	}
+	if inserted.Parser.Version != "https://github.com/m-lab/etl/tree/foobar" {
 		t.Error("ParserVersion not properly set")
 	}
+	if inserted.Date != date {
+		t.Errorf("Date = %v, want %v", inserted.Date, date)
+	}
 	// echo -n testdata/sidestream/20170203T00:00:00Z_ALL0.web100-1486123188191060-213.248.112.75-41131-5.228.253.100-52290 | \
 	//     openssl dgst -binary -md5 | base64  | tr '/+' '_-' | tr -d '='
 	if inserted.ID != "cjFOd7-tIa3RXxWMhCNSrQ" {
 		t.Errorf("ss.ParseAndInsert() wrong ID; got %q, want %q", inserted.ID, "cjFOd7-tIa3RXxWMhCNSrQ")
 	}
 
+	expectedSummary := &schema.SSSummary{
+		TestID:     filename,
+		LogTime:    time.Date(2017, 2, 3, 0, 0, 0, 0, time.UTC),
+		StartTime:  time.Date(2017, 2, 3, 11, 59, 48, 191060000, time.UTC),
+		ServerIP:   "213.248.112.75",
+		ServerPort: 41131,
+		ClientIP:   "5.228.253.100",
+		ClientPort: 52290,
+	}
+	if diff := deep.Equal(inserted.A, expectedSummary); diff != nil {
+		t.Error("Summary does not match:", diff)
+	}
+	if diff := deep.Equal(inserted.GetClientIPs(), []string{"5.228.253.100"}); diff != nil {
+		t.Error("GetClientIPs() does not match:", diff)
+	}
+
 	// The expected connection spec includes all legacy fields as well as fully
 	// populated ServerX and ClientX fields.
 	expectedSpec := schema.Web100ConnectionSpecification{
@@ -125,7 +147,58 @@ func TestSSInserter(t *testing.T) {
 		},
 	}
 
-	if diff := deep.Equal(inserted.Web100_log_entry.Connection_spec, expectedSpec); diff != nil {
+	if diff := deep.Equal(inserted.Raw.Connection_spec, expectedSpec); diff != nil {
 		t.Error("Connection spec does not match:", diff)
 	}
 }
+
+func TestSSParser_CorruptLines(t *testing.T) {
+	filename := "testdata/sidestream/20170203T00:00:00Z_ALL0.web100"
+	rawData, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("cannot read testdata.")
+	}
+	lines := strings.Split(string(rawData), "\n")
+	fields := strings.Split(lines[1], " ")
+	badIP := append([]string{}, fields...)
+	badIP[5] = "not-an-ip" // RemAddress
+	badPort := append([]string{}, fields...)
+	badPort[4] = "port" // LocalPort
+	content := strings.Join([]string{
+		lines[0],
+		lines[1],
+		"C: too few fields",
+		strings.Join(badIP, " "),
+		strings.Join(badPort, " "),
+		"",
+	}, "\n")
+
+	sink := newInMemorySink()
+	p := parser.NewSSParser(sink, "sidestream", "")
+	meta := map[string]bigquery.Value{"filename": "gs://archive-measurement-lab/sidestream/2019/11/20/20191120T010010Z-mlab1-ord03-sidestream-0000.tgz"}
+	if err := p.ParseAndInsert(meta, filename, []byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if p.Committed() != 1 {
+		t.Errorf("Committed() = %d, want 1", p.Committed())
+	}
+
+	// A corrupt header fails the whole file.
+	err = p.ParseAndInsert(meta, filename, []byte("X: cid\n"+lines[1]))
+	if err == nil {
+		t.Error("ParseAndInsert() with corrupt header = nil, want error")
+	}
+}
+
+func TestPopulateSnap_UnknownVariable(t *testing.T) {
+	snap, err := parser.PopulateSnap(map[string]string{"NotAWeb100Var": "1", "CERcvd": "3"})
+	if err != nil {
+		t.Fatalf("PopulateSnap() error = %v", err)
+	}
+	if snap.CERcvd != 3 {
+		t.Errorf("CERcvd; got %d; want 3", snap.CERcvd)
+	}
+}
@@ -1,9 +1,9 @@
 package parser
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
+	"math"
 	"reflect"
 	"regexp"
 	"sort"
@@ -35,17 +35,21 @@ var (
 // SwitchParser handles parsing for the switch datatype.
 type SwitchParser struct {
 	*row.Base
-	table  string
-	suffix string
+	table     string
+	suffix    string
+	streaming bool // Insert rows as their timestamps complete.
+	errs      *testErrors
 }
 
 // NewSwitchParser returns a new parser for the switch archives.
 func NewSwitchParser(sink row.Sink, table, suffix string) etl.Parser {
 	bufSize := etl.SW.BQBufferSize()
 	return &SwitchParser{
-		Base:   row.NewBase(table, sink, bufSize),
-		table:  table,
-		suffix: suffix,
+		Base:      row.NewAdaptiveBase(table, sink, bufSize),
+		table:     table,
+		suffix:    suffix,
+		streaming: etl.SW.Enabled(etl.SwitchStreaming),
+		errs:      newTestErrors(table, string(etl.SW)),
 	}
 }
 
@@ -67,9 +71,31 @@ func (p *SwitchParser) IsParsable(testName string, data []byte) (string, bool) {
 func (p *SwitchParser) ParseAndInsert(fileMetadata map[string]bigquery.Value, testName string, rawContent []byte) error {
 	metrics.WorkerState.WithLabelValues(p.TableName(), string(etl.SW)).Inc()
 	defer metrics.WorkerState.WithLabelValues(p.TableName(), string(etl.SW)).Dec()
-
-	reader := bytes.NewReader(rawContent)
-	dec := json.NewDecoder(reader)
+	p.errs.start()
+
+	// DISCOv2 files are JSON Lines, so a corrupt line can be skipped without
+	// losing the rest of the file.  Other files are decoded as a stream of
+	// JSON values, which cannot resynchronize after an error.
+	isJSONL := strings.HasSuffix(testName, "switch.jsonl") ||
+		strings.HasSuffix(testName, "switch.jsonl.gz")
+	var lines *jsonLines
+	var next func(v *schema.RawSwitchStats) (bool, error)
+	if isJSONL {
+		lines = newJSONLines(rawContent)
+		next = func(v *schema.RawSwitchStats) (bool, error) {
+			return lines.Decode(v), nil
+		}
+	} else {
+		reader := getReader(rawContent)
+		defer putReader(reader)
+		dec := json.NewDecoder(reader)
+		next = func(v *schema.RawSwitchStats) (bool, error) {
+			if !dec.More() {
+				return false, nil
+			}
+			return true, dec.Decode(v)
+		}
+	}
 	rowCount := 0
 
 	// Each file contains multiple samples referring to the same hostname, but
@@ -80,16 +106,25 @@ func (p *SwitchParser) ParseAndInsert(fileMetadata map[string]bigquery.Value, te
 	// DISCOv2 octets.local.tx/rx values.
 	archiveDate := fileMetadata["date"].(civil.Date)
 
-	for dec.More() {
+	// In streaming mode, the watermark is the latest first timestamp of any
+	// record so far.  Assuming that records are written in time order, no
+	// later record has samples before the watermark, so the rows before it
+	// are complete and can be inserted.  Samples that arrive behind the
+	// watermark anyway are dropped, since their rows may already be inserted.
+	watermark := int64(math.MinInt64)
+
+	for {
 		// Unmarshal the raw JSON into a SwitchStats.
 		// This can hold both DISCOv1 and DISCOv2 data.
 		tmp := &schema.RawSwitchStats{}
-		err := dec.Decode(tmp)
+		ok, err := next(tmp)
 		if err != nil {
 			metrics.TestTotal.WithLabelValues(
 				p.TableName(), string(etl.SW), "Decode").Inc()
-			// TODO(dev) Should accumulate errors, instead of aborting?
-			return err
+			return p.errs.add("Decode", err)
+		}
+		if !ok {
+			break
 		}
 
 		// For collectd in the "utilization" experiment, by design, the raw data
@@ -102,8 +137,7 @@ func (p *SwitchParser) ParseAndInsert(fileMetadata map[string]bigquery.Value, te
 		// DISCOv2. DISCOv2 can be differentiated from collectd by the "jsonl"
 		// suffix.
 		if len(tmp.Sample) > 0 {
-			if !strings.HasSuffix(testName, "switch.jsonl") &&
-				!strings.HasSuffix(testName, "switch.jsonl.gz") {
+			if !isJSONL {
 				tmp.Sample = tmp.Sample[:len(tmp.Sample)-1]
 				// DISCOv1's Timestamp field in each sample represents the
 				// *beginning* of a 10s sample window, while v2's Timestamp
@@ -117,9 +151,31 @@ func (p *SwitchParser) ParseAndInsert(fileMetadata map[string]bigquery.Value, te
 			}
 		}
 
+		if p.streaming && len(tmp.Sample) > 0 {
+			first := tmp.Sample[0].Timestamp
+			for _, sample := range tmp.Sample {
+				if sample.Timestamp < first {
+					first = sample.Timestamp
+				}
+			}
+			if first > watermark {
+				watermark = first
+				n, err := p.putRows(timestampToRow, watermark)
+				rowCount += n
+				if err != nil {
+					return p.errs.add("put-error", err)
+				}
+			}
+		}
+
 		// Iterate over the samples in the JSON. Keep together metrics
 		// with the same timestamp in a single SwitchRow.
 		for _, sample := range tmp.Sample {
+			if sample.Timestamp < watermark {
+				metrics.WarningCount.WithLabelValues(
+					p.TableName(), string(etl.SW), "late sample").Inc()
+				continue
+			}
 			// If a row for this timestamp does not exist already, create one.
 			var row *schema.SwitchRow
 			var ok bool
@@ -138,11 +194,13 @@ func (p *SwitchParser) ParseAndInsert(fileMetadata map[string]bigquery.Value, te
 					ID:   fmt.Sprintf("%s-%s-%d", machine, site, sample.Timestamp),
 					Date: archiveDate,
 					Parser: schema.ParseInfo{
-						Version:    Version(),
-						Time:       time.Now(),
-						ArchiveURL: fileMetadata["filename"].(string),
-						Filename:   testName,
-						GitCommit:  GitCommit(),
+						Version:     Version(),
+						Time:        p.Now(),
+						ArchiveURL:  fileMetadata["filename"].(string),
+						Filename:    testName,
+						GitCommit:   GitCommit(),
+						FileModTime: fileModTime(fileMetadata),
+						FileSize:    fileSize(fileMetadata),
 					},
 					A: &schema.SwitchSummary{
 						Machine:        machine,
@@ -170,21 +228,51 @@ func (p *SwitchParser) ParseAndInsert(fileMetadata map[string]bigquery.Value, te
 		}
 	}
 
+	if lines != nil && lines.Corrupt() > 0 {
+		metrics.WarningCount.WithLabelValues(
+			p.TableName(), string(etl.SW), "corrupt line").Add(float64(lines.Corrupt()))
+		if err := lines.Err(); err != nil {
+			metrics.TestTotal.WithLabelValues(
+				p.TableName(), string(etl.SW), "Decode").Inc()
+			return p.errs.add("Decode", err)
+		}
+	}
+
+	// Write all the remaining rows, i.e. all the rows containing the samples
+	// in the current archive that were not written while streaming.
+	n, err := p.putRows(timestampToRow, math.MaxInt64)
+	rowCount += n
+	p.ExpectRows(testName, rowCount)
+	if err != nil {
+		return p.errs.add("put-error", err)
+	}
+
+	// Measure the distribution of records per file.
+	metrics.EntryFieldCountHistogram.WithLabelValues(
+		p.TableName()).Observe(float64(rowCount))
+
+	return nil
+}
+
+// putRows inserts the rows with timestamps before limit, in timestamp order,
+// and removes them from timestampToRow.  It returns the number of rows
+// inserted.
+func (p *SwitchParser) putRows(timestampToRow map[int64]*schema.SwitchRow, limit int64) (int, error) {
 	// Sort the rows by timestamp. This is necessary because the rows are
 	// added to a map, whose order would be randomized otherwise.
 	timestamps := make([]int64, 0, len(timestampToRow))
 	for k := range timestampToRow {
-		timestamps = append(timestamps, k)
+		if k < limit {
+			timestamps = append(timestamps, k)
+		}
 	}
 	sort.Slice(timestamps, func(i, j int) bool {
 		return timestamps[i] < timestamps[j]
 	})
 
-	// Write all the rows created so far, i.e. all the rows containing the
-	// samples in the current archive.
-	for _, ts := range timestamps {
+	for i, ts := range timestamps {
 		row := timestampToRow[ts]
-		rowCount++
+		delete(timestampToRow, ts)
 
 		// Count the number of samples per record.
 		metrics.DeltaNumFieldsHistogram.WithLabelValues(
@@ -198,17 +286,12 @@ func (p *SwitchParser) ParseAndInsert(fileMetadata map[string]bigquery.Value, te
 		if err != nil {
 			metrics.TestTotal.WithLabelValues(
 				p.TableName(), string(etl.SW), "put-error").Inc()
-			return err
+			return i, err
 		}
 		// Count successful inserts.
 		metrics.TestTotal.WithLabelValues(p.TableName(), string(etl.SW), "ok").Inc()
 	}
-
-	// Measure the distribution of records per file.
-	metrics.EntryFieldCountHistogram.WithLabelValues(
-		p.TableName()).Observe(float64(rowCount))
-
-	return nil
+	return len(timestamps), nil
 }
 
 // getSummaryFromSample reads the raw Sample and fills the corresponding
@@ -256,6 +339,14 @@ func (p *SwitchParser) Flush() error {
 	return p.Base.Flush()
 }
 
+// TaskError returns non-nil if too many tests failed to parse.
+func (p *SwitchParser) TaskError() error {
+	if err := p.errs.Err(); err != nil {
+		return err
+	}
+	return p.Base.TaskError()
+}
+
 func (p *SwitchParser) TableName() string {
 	return p.table
 }
@@ -3,13 +3,19 @@ package parser_test
 import (
 	"bytes"
 	"compress/gzip"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"path"
+	"strings"
 	"testing"
+	"time"
 
 	"cloud.google.com/go/bigquery"
 	"cloud.google.com/go/civil"
+	"github.com/m-lab/etl/etl"
 	"github.com/m-lab/etl/parser"
+	"github.com/m-lab/etl/row"
 	"github.com/m-lab/etl/schema"
 	"github.com/m-lab/go/rtx"
 )
@@ -145,3 +151,131 @@ func TestSwitchParser_ParseAndInsert(t *testing.T) {
 		t.Errorf("Wrong switch discards in DISCOv1 row, got %v", firstRow.A)
 	}
 }
+
+func TestSwitchParser_CorruptLines(t *testing.T) {
+	data, err := ioutil.ReadFile(path.Join("testdata/Switch/", switchDISCOv2Filename))
+	rtx.Must(err, "failed to load DISCOv2 test file")
+	meta := map[string]bigquery.Value{
+		"filename": path.Join(switchGCSPath, switchDISCOv2Filename),
+		"date":     civil.Date{Year: 2021, Month: 12, Day: 14},
+	}
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+	corrupt := func(n int) []byte {
+		c := make([][]byte, len(lines))
+		copy(c, lines)
+		for i := 0; i < n; i++ {
+			c[i] = c[i][:len(c[i])/2]
+		}
+		return bytes.Join(c, []byte("\n"))
+	}
+
+	// One corrupt line in 16 is skipped, and the other lines are parsed.
+	sink := newInMemorySink()
+	n := parser.NewSwitchParser(sink, "switch", "_suffix")
+	if err := n.ParseAndInsert(meta, switchDISCOv2Filename, corrupt(1)); err != nil {
+		t.Fatal("ParseAndInsert() with one corrupt line:", err)
+	}
+	n.Flush()
+	if n.Accepted() != 30 {
+		t.Errorf("Expected 30 accepted rows, got %d", n.Accepted())
+	}
+	if got := len(sink.data[0].(*schema.SwitchRow).Raw.Metrics); got != 15 {
+		t.Errorf("Expected 15 metrics, got %d", got)
+	}
+
+	// More than MaxCorruptLineRatio corrupt lines rejects the file.
+	n = parser.NewSwitchParser(newInMemorySink(), "switch", "_suffix")
+	err = n.ParseAndInsert(meta, switchDISCOv2Filename, corrupt(2))
+	if !errors.Is(err, parser.ErrTooManyCorruptLines) {
+		t.Errorf("ParseAndInsert() error = %v, want %v", err, parser.ErrTooManyCorruptLines)
+	}
+}
+
+func TestSwitchParser_FixedClock(t *testing.T) {
+	data, err := ioutil.ReadFile(path.Join("testdata/Switch/", switchDISCOv2Filename))
+	rtx.Must(err, "failed to load DISCOv2 test file")
+	meta := map[string]bigquery.Value{
+		"filename": path.Join(switchGCSPath, switchDISCOv2Filename),
+		"date":     civil.Date{Year: 2021, Month: 12, Day: 14},
+	}
+	sink := newInMemorySink()
+	n := parser.NewSwitchParser(sink, "switch", "_suffix")
+	fixed := time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC)
+	n.(*parser.SwitchParser).SetClock(row.FixedClock(fixed))
+	if err := n.ParseAndInsert(meta, switchDISCOv2Filename, data); err != nil {
+		t.Fatal(err)
+	}
+	n.Flush()
+	for _, r := range sink.data {
+		if got := r.(*schema.SwitchRow).Parser.Time; !got.Equal(fixed) {
+			t.Fatalf("Parser.Time = %v, want %v", got, fixed)
+		}
+	}
+}
+
+func TestSwitchParser_Streaming(t *testing.T) {
+	c, err := etl.ParseConfig([]byte(`{"features": {"switch": ["switch_streaming"]}}`))
+	rtx.Must(err, "failed to parse config")
+	etl.SetConfig(c)
+	defer etl.SetConfig(nil)
+
+	// The last record has a sample behind the watermark set by the second.
+	record := `{"experiment":"s1-dfw07.measurement-lab.org",` +
+		`"hostname":"mlab2-dfw07.mlab-oti.measurement-lab.org",` +
+		`"metric":"switch.discards.local.rx","sample":[%s]}`
+	sample := `{"timestamp":%d,"value":1,"counter":2}`
+	var lines []string
+	for _, ts := range [][]int64{{100, 110}, {120, 130}, {110, 140}} {
+		samples := []string{}
+		for _, t := range ts {
+			samples = append(samples, fmt.Sprintf(sample, t))
+		}
+		lines = append(lines, fmt.Sprintf(record, strings.Join(samples, ",")))
+	}
+	data := []byte(strings.Join(lines, "\n"))
+	meta := map[string]bigquery.Value{
+		"filename": path.Join(switchGCSPath, switchDISCOv2Filename),
+		"date":     civil.Date{Year: 2021, Month: 12, Day: 14},
+	}
+
+	sink := newInMemorySink()
+	n := parser.NewSwitchParser(sink, "switch", "_suffix")
+	if err := n.ParseAndInsert(meta, switchDISCOv2Filename, data); err != nil {
+		t.Fatal(err)
+	}
+	n.Flush()
+	if len(sink.data) != 5 {
+		t.Fatalf("Expected 5 rows, got %d", len(sink.data))
+	}
+	for i, r := range sink.data {
+		row := r.(*schema.SwitchRow)
+		want := fmt.Sprintf("mlab2-dfw07-%d", 100+10*i)
+		if row.ID != want {
+			t.Errorf("Row %d ID = %s, want %s", i, row.ID, want)
+		}
+		if len(row.Raw.Metrics) != 1 {
+			t.Errorf("Row %s has %d metrics, want 1", row.ID, len(row.Raw.Metrics))
+		}
+	}
+}
+
+func BenchmarkSwitchParser(b *testing.B) {
+	data, err := ioutil.ReadFile(path.Join("testdata/Switch/", switchDISCOv2Filename))
+	rtx.Must(err, "failed to load DISCOv2 test file")
+	meta := map[string]bigquery.Value{
+		"filename": path.Join(switchGCSPath, switchDISCOv2Filename),
+		"date":     civil.Date{Year: 2021, Month: 12, Day: 14},
+	}
+	sink := newInMemorySink()
+	p := parser.NewSwitchParser(sink, "switch", "_suffix")
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := p.ParseAndInsert(meta, switchDISCOv2Filename, data); err != nil {
+			b.Fatal(err)
+		}
+		// Discard the committed rows, so the sink doesn't grow without bound.
+		p.Flush()
+		sink.data = sink.data[:0]
+	}
+}
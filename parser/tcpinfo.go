@@ -14,11 +14,10 @@ Notes:
 */
 
 import (
-	"bytes"
+	"context"
 	"io"
 	"log"
 	"strings"
-	"time"
 
 	"cloud.google.com/go/bigquery"
 	"cloud.google.com/go/civil"
@@ -39,6 +38,12 @@ type TCPInfoParser struct {
 	*row.Base
 	table  string
 	suffix string
+
+	fullSnapshots bool // Keep all snapshots, instead of thinning them.
+	dropIdle      bool // Drop snapshots unchanged from their predecessor.
+
+	uuidMap *UUIDMapper // Optional.
+	errs    *testErrors
 }
 
 // RowsInBuffer returns the count of rows currently in the buffer.
@@ -72,7 +77,8 @@ func (p *TCPInfoParser) TableName() string {
 }
 
 // TaskError return the task level error, based on failed rows, or any other criteria.
-// TaskError returns non-nil if more than 10% of row commits failed.
+// TaskError returns non-nil if more than 10% of row commits failed, or too many
+// tests failed to parse.
 func (p *TCPInfoParser) TaskError() error {
 	stats := p.GetStats()
 	if stats.Total() < 10*stats.Failed {
@@ -80,7 +86,10 @@ func (p *TCPInfoParser) TaskError() error {
 			stats.Failed, stats.Total())
 		return etl.ErrHighInsertionFailureRate
 	}
-	return nil
+	if err := p.errs.Err(); err != nil {
+		return err
+	}
+	return p.Base.TaskError()
 }
 
 // Flush synchronously flushes any pending rows.
@@ -88,6 +97,17 @@ func (p *TCPInfoParser) Flush() error {
 	return p.Base.Flush()
 }
 
+// SetUUIDMapper sets the UUIDMapper used to record the UUID of each test.
+func (p *TCPInfoParser) SetUUIDMapper(m *UUIDMapper) {
+	p.uuidMap = m
+}
+
+// ParsesConcurrently implements etl.ConcurrentParser.  Each test is decoded
+// into its own row, using pooled buffers, and only Put to the row.Base.
+func (p *TCPInfoParser) ParsesConcurrently() bool {
+	return true
+}
+
 // IsParsable returns the canonical test type and whether to parse data.
 func (p *TCPInfoParser) IsParsable(testName string, data []byte) (string, bool) {
 	if strings.HasSuffix(testName, "jsonl.zst") {
@@ -111,24 +131,112 @@ func thinSnaps(orig []snapshot.Snapshot) []snapshot.Snapshot {
 	return out
 }
 
+// isIdle reports whether snap has the same state and counters as prev, so
+// that it records no activity.  Snapshots without TCPInfo are never idle.
+func isIdle(prev, snap *snapshot.Snapshot) bool {
+	if prev.TCPInfo == nil || snap.TCPInfo == nil {
+		return false
+	}
+	if (prev.InetDiagMsg == nil) != (snap.InetDiagMsg == nil) {
+		return false
+	}
+	if prev.InetDiagMsg != nil && prev.InetDiagMsg.IDiagState != snap.InetDiagMsg.IDiagState {
+		return false
+	}
+	a, b := prev.TCPInfo, snap.TCPInfo
+	return a.State == b.State &&
+		a.BytesAcked == b.BytesAcked &&
+		a.BytesReceived == b.BytesReceived &&
+		a.BytesSent == b.BytesSent &&
+		a.BytesRetrans == b.BytesRetrans &&
+		a.SegsIn == b.SegsIn &&
+		a.SegsOut == b.SegsOut &&
+		a.TotalRetrans == b.TotalRetrans
+}
+
+// dropIdleSnaps removes, in place, each snapshot that is idle compared to its
+// predecessor, and returns the remaining snapshots and the number dropped.
+// The first and final snapshots are always kept.
+func dropIdleSnaps(snaps []snapshot.Snapshot) ([]snapshot.Snapshot, int) {
+	n := len(snaps)
+	if n < 3 {
+		return snaps, 0
+	}
+	out := snaps[:1]
+	for i := 1; i < n-1; i++ {
+		// Compare with the original predecessor, which is either kept, or
+		// idle and so equal in key fields to the last kept snapshot.
+		if !isIdle(&snaps[i-1], &snaps[i]) {
+			out = append(out, snaps[i])
+		}
+	}
+	out = append(out, snaps[n-1])
+	return out, n - len(out)
+}
+
+// snapshotDelta returns the change between the first and final snapshots with
+// TCPInfo, or nil if there are none.
+func snapshotDelta(snaps []snapshot.Snapshot) *schema.TCPInfoDelta {
+	first, final := -1, -1
+	for i := range snaps {
+		if snaps[i].TCPInfo == nil {
+			continue
+		}
+		if first < 0 {
+			first = i
+		}
+		final = i
+	}
+	if first < 0 {
+		return nil
+	}
+	a, b := snaps[first].TCPInfo, snaps[final].TCPInfo
+	return &schema.TCPInfoDelta{
+		StartTime:     snaps[first].Timestamp,
+		ElapsedTime:   snaps[final].Timestamp.Sub(snaps[first].Timestamp).Microseconds(),
+		BytesAcked:    b.BytesAcked - a.BytesAcked,
+		BytesReceived: b.BytesReceived - a.BytesReceived,
+		BytesRetrans:  b.BytesRetrans - a.BytesRetrans,
+		TotalRetrans:  int64(b.TotalRetrans) - int64(a.TotalRetrans),
+		MinRTT:        int64(b.MinRTT),
+	}
+}
+
 // ParseAndInsert extracts all ArchivalRecords from the rawContent and inserts into a single row.
 // Approximately 15 usec/snapshot.
 func (p *TCPInfoParser) ParseAndInsert(meta map[string]bigquery.Value, testName string, rawContent []byte) error {
+	return p.ParseAndInsertContext(context.Background(), meta, testName, rawContent)
+}
+
+// ctxCheckInterval is the number of snapshots decoded between checks of the
+// context, so that a timed out test stops within milliseconds.
+const ctxCheckInterval = 1000
+
+// ParseAndInsertContext implements etl.ContextParser.  It checks ctx between
+// snapshots, and returns ctx.Err(), without inserting the row, once it is done.
+func (p *TCPInfoParser) ParseAndInsertContext(ctx context.Context, meta map[string]bigquery.Value, testName string, rawContent []byte) error {
 	tableName := p.FullTableName()
 	metrics.WorkerState.WithLabelValues(tableName, "tcpinfo").Inc()
 	defer metrics.WorkerState.WithLabelValues(tableName, "tcpinfo").Dec()
+	p.errs.start()
 
 	var err error
 	if strings.HasSuffix(testName, "zst") {
-		rawContent, err = gozstd.Decompress(nil, rawContent)
+		// Decoded records don't reference the decompressed content, so the
+		// buffer can be reused once decoding is complete.
+		buf := getBuffer()
+		defer putBuffer(buf)
+		*buf, err = gozstd.Decompress(*buf, rawContent)
+		rawContent = *buf
 		if err != nil {
 			metrics.TestTotal.WithLabelValues(p.TableName(), "tcpinfo", "zstd error").Inc()
-			return err
+			return p.errs.add("zstd error", err)
 		}
 	}
 
 	// This contains metadata and all snapshots from a single connection.
-	rdr := bytes.NewReader(rawContent)
+	rdr := getReader(rawContent)
+	defer putReader(rdr)
 	ar := netlink.NewArchiveReader(rdr)
 
 	metrics.WorkerState.WithLabelValues(tableName, "tcpinfo-parse").Inc()
@@ -136,9 +244,17 @@ func (p *TCPInfoParser) ParseAndInsert(meta map[string]bigquery.Value, testName
 	defer metrics.WorkerState.WithLabelValues(tableName, "tcpinfo-parse").Dec()
 
 	var rec *netlink.ArchivalRecord
-	snaps := make([]snapshot.Snapshot, 0, 2000)
+	// The row holds only copies of the snapshots, so the slice can be reused.
+	pooled := getSnaps()
+	defer putSnaps(pooled)
+	snaps := *pooled
 	tcpMeta := netlink.Metadata{}
-	for {
+	for n := 0; ; n++ {
+		if n%ctxCheckInterval == 0 {
+			if err = ctx.Err(); err != nil {
+				break
+			}
+		}
 		rec, err = ar.Next()
 		if err != nil {
 			break
@@ -155,52 +271,77 @@ func (p *TCPInfoParser) ParseAndInsert(meta map[string]bigquery.Value, testName
 			snaps = append(snaps, *snap)
 		}
 	}
+	*pooled = snaps // Retain any growth for reuse.
+
+	if err != nil && err == ctx.Err() {
+		return err
+	}
 
 	if err != io.EOF {
 		log.Println(err)
 		metrics.TestTotal.WithLabelValues(p.TableName(), "tcpinfo", "decode error").Inc()
-		metrics.ErrorCount.WithLabelValues(p.TableName(), "tcpinfo", "decode error").Inc()
-		return err
+		return p.errs.add("decode error", err)
 	}
 
 	if len(snaps) < 1 {
 		// For now, we don't save rows with no snapshots.
+		p.ExpectRows(testName, 0)
 		metrics.TestTotal.WithLabelValues(p.TableName(), "tcpinfo", "no-snaps").Inc()
 		metrics.WarningCount.WithLabelValues(p.TableName(), "tcpinfo", "no-snaps").Inc()
 		return nil
 	}
 	if snaps[len(snaps)-1].InetDiagMsg == nil {
 		// For now, we don't save rows with nil inetdiagmsg.
+		p.ExpectRows(testName, 0)
 		metrics.TestTotal.WithLabelValues(p.TableName(), "tcpinfo", "nil-inetdiagmsg").Inc()
 		metrics.WarningCount.WithLabelValues(p.TableName(), "tcpinfo", "nil-inetdiagmsg").Inc()
 		return nil
 	}
 
+	idle := 0
+	if p.dropIdle {
+		snaps, idle = dropIdleSnaps(snaps)
+	}
+
+	var kept []snapshot.Snapshot
+	if p.fullSnapshots {
+		// The pooled snaps are reused, so the row needs its own copy.
+		kept = append(kept, snaps...)
+	} else {
+		// TODO(https://github.com/m-lab/etl/issues/1068) - consider minimizing snapshot thinning.
+		kept = thinSnaps(snaps)
+	}
+
 	row := schema.TCPInfoRow{
 		ID: tcpMeta.UUID,
 		A: &schema.TCPInfoSummary{
 			SockID:        snaps[len(snaps)-1].InetDiagMsg.ID.GetSockID(),
 			FinalSnapshot: snaps[len(snaps)-1],
+			Delta:         snapshotDelta(snaps),
+			IdleSnapshots: int64(idle),
 		},
 		Parser: schema.ParseInfo{
-			Version:    Version(),
-			Time:       time.Now(),
-			ArchiveURL: meta["filename"].(string),
-			Filename:   testName,
-			GitCommit:  GitCommit(),
+			Version:     Version(),
+			Time:        p.Now(),
+			ArchiveURL:  meta["filename"].(string),
+			Filename:    testName,
+			GitCommit:   GitCommit(),
+			FileModTime: fileModTime(meta),
+			FileSize:    fileSize(meta),
 		},
 		Date: meta["date"].(civil.Date),
 		Raw: &snapshot.ConnectionLog{
 			Metadata: tcpMeta,
-			// TODO(https://github.com/m-lab/etl/issues/1068) - consider minimizing snapshot thinning.
-			Snapshots: thinSnaps(snaps),
+			Snapshots: kept,
 		},
 	}
 
+	p.uuidMap.mapTest(p.TableName(), "tcpinfo", meta, testName, row.ID)
+
+	p.ExpectRows(testName, 1)
 	if err := p.Put(&row); err != nil {
 		metrics.TestTotal.WithLabelValues(p.TableName(), "tcpinfo", "put error").Inc()
-		metrics.ErrorCount.WithLabelValues(p.TableName(), "tcpinfo", "put error").Inc()
-		return err
+		return p.errs.add("put error", err)
 	}
 	metrics.TestTotal.WithLabelValues(p.TableName(), "tcpinfo", "ok").Inc()
 	return nil
@@ -210,8 +351,11 @@ func (p *TCPInfoParser) ParseAndInsert(meta map[string]bigquery.Value, testName
 func NewTCPInfoParser(sink row.Sink, table, suffix string) *TCPInfoParser {
 	bufSize := etl.TCPINFO.BQBufferSize()
 	return &TCPInfoParser{
-		Base:   row.NewBase("tcpinfo", sink, bufSize),
-		table:  table,
-		suffix: suffix,
+		Base:          row.NewAdaptiveBase("tcpinfo", sink, bufSize),
+		table:         table,
+		suffix:        suffix,
+		fullSnapshots: etl.TCPINFO.Enabled(etl.FullSnapshots),
+		dropIdle:      etl.TCPINFO.Enabled(etl.DropIdleSnapshots),
+		errs:          newTestErrors(table, "tcpinfo"),
 	}
 }
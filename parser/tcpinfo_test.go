@@ -9,11 +9,16 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"testing"
 	"time"
 
 	"cloud.google.com/go/civil"
+	"github.com/go-test/deep"
+	"github.com/m-lab/tcp-info/snapshot"
+	"github.com/m-lab/tcp-info/tcp"
+
 	"github.com/m-lab/etl/etl"
 	"github.com/m-lab/etl/parser"
 	"github.com/m-lab/etl/schema"
@@ -207,7 +212,204 @@ func TestTCPParser(t *testing.T) {
 	}
 }
 
+func TestTCPParserFullSnapshots(t *testing.T) {
+	c, err := etl.ParseConfig([]byte(`{"features": {"tcpinfo": ["full_snapshots"]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	etl.SetConfig(c)
+	defer etl.SetConfig(nil)
+
+	taskfilename := "testdata/20190516T013026.744845Z-tcpinfo-mlab4-arn02-ndt.tgz"
+	url := "gs://fake-archive/ndt/tcpinfo/2019/05/16/" + filepath.Base(taskfilename)
+	src, err := fileSource(taskfilename)
+	if err != nil {
+		t.Fatal("Failed reading testdata from", taskfilename)
+	}
+	ins := newInMemorySink()
+	p := parser.NewTCPInfoParser(ins, "test", "_suffix")
+	// Features are read when the parser is created.
+	etl.SetConfig(nil)
+	task := task.NewTask(url, src, p, nullCloser{})
+	if _, err := task.ProcessAllTests(false); err != nil {
+		t.Fatal(err)
+	}
+
+	totalSnaps := 0
+	for _, r := range ins.data {
+		totalSnaps += len(r.(*schema.TCPInfoRow).Raw.Snapshots)
+	}
+	if totalSnaps <= 1588 {
+		t.Error("expected more than the 1588 thinned snapshots, got", totalSnaps)
+	}
+	for _, r := range ins.data {
+		row := r.(*schema.TCPInfoRow)
+		if row.A.Delta == nil {
+			t.Fatalf("Row %s has no Delta", row.ID)
+		}
+		if row.A.Delta.BytesAcked < 0 || row.A.Delta.ElapsedTime < 0 {
+			t.Errorf("Row %s has negative Delta %+v", row.ID, row.A.Delta)
+		}
+	}
+}
+
+func TestSnapshotDelta(t *testing.T) {
+	start := time.Date(2019, 5, 16, 0, 0, 0, 0, time.UTC)
+	snaps := []snapshot.Snapshot{
+		{Timestamp: start.Add(-time.Second)},
+		{Timestamp: start, TCPInfo: &tcp.LinuxTCPInfo{
+			BytesAcked: 100, BytesReceived: 10, BytesRetrans: 0, TotalRetrans: 1, MinRTT: 9000}},
+		{Timestamp: start.Add(time.Second), TCPInfo: &tcp.LinuxTCPInfo{
+			BytesAcked: 5100, BytesReceived: 30, BytesRetrans: 1400, TotalRetrans: 2, MinRTT: 8000}},
+		{Timestamp: start.Add(2 * time.Second)},
+	}
+	want := &schema.TCPInfoDelta{
+		StartTime:     start,
+		ElapsedTime:   1000000,
+		BytesAcked:    5000,
+		BytesReceived: 20,
+		BytesRetrans:  1400,
+		TotalRetrans:  1,
+		MinRTT:        8000,
+	}
+	if diff := deep.Equal(parser.SnapshotDelta(snaps), want); diff != nil {
+		t.Errorf("SnapshotDelta() = %v", diff)
+	}
+	if got := parser.SnapshotDelta(snaps[:1]); got != nil {
+		t.Errorf("SnapshotDelta() = %+v, want nil without TCPInfo", got)
+	}
+}
+
+func TestDropIdleSnaps(t *testing.T) {
+	start := time.Date(2019, 5, 16, 0, 0, 0, 0, time.UTC)
+	snap := func(sec int, acked int64) snapshot.Snapshot {
+		return snapshot.Snapshot{
+			Timestamp: start.Add(time.Duration(sec) * time.Second),
+			TCPInfo:   &tcp.LinuxTCPInfo{State: 1, BytesAcked: acked},
+		}
+	}
+	snaps := []snapshot.Snapshot{
+		snap(0, 0), snap(1, 0), snap(2, 100), snap(3, 100), snap(4, 100),
+		{Timestamp: start.Add(5 * time.Second)}, snap(6, 100), snap(7, 100),
+	}
+	got, dropped := parser.DropIdleSnaps(snaps)
+	if dropped != 3 {
+		t.Errorf("DropIdleSnaps() dropped %d, want 3", dropped)
+	}
+	var secs []int
+	for _, s := range got {
+		secs = append(secs, int(s.Timestamp.Sub(start)/time.Second))
+	}
+	// The final snapshot is kept, even though it is idle.
+	if diff := deep.Equal(secs, []int{0, 2, 5, 6, 7}); diff != nil {
+		t.Errorf("DropIdleSnaps() kept %v, diff %v", secs, diff)
+	}
+	if got, dropped := parser.DropIdleSnaps(snaps[:2]); len(got) != 2 || dropped != 0 {
+		t.Errorf("DropIdleSnaps() = %d, %d, want the first and final snapshots", len(got), dropped)
+	}
+}
+
+func TestTCPParserDropIdleSnapshots(t *testing.T) {
+	c, err := etl.ParseConfig([]byte(`{"features": {"tcpinfo": ["drop_idle_snapshots", "full_snapshots"]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	etl.SetConfig(c)
+	defer etl.SetConfig(nil)
+
+	taskfilename := "testdata/20190516T013026.744845Z-tcpinfo-mlab4-arn02-ndt.tgz"
+	url := "gs://fake-archive/ndt/tcpinfo/2019/05/16/" + filepath.Base(taskfilename)
+	src, err := fileSource(taskfilename)
+	if err != nil {
+		t.Fatal("Failed reading testdata from", taskfilename)
+	}
+	ins := newInMemorySink()
+	p := parser.NewTCPInfoParser(ins, "test", "_suffix")
+	etl.SetConfig(nil)
+	task := task.NewTask(url, src, p, nullCloser{})
+	if _, err := task.ProcessAllTests(false); err != nil {
+		t.Fatal(err)
+	}
+
+	totalIdle := int64(0)
+	for _, r := range ins.data {
+		row := r.(*schema.TCPInfoRow)
+		totalIdle += row.A.IdleSnapshots
+		snaps := row.Raw.Snapshots
+		if len(snaps) == 0 || row.A.FinalSnapshot.Timestamp != snaps[len(snaps)-1].Timestamp {
+			t.Errorf("Row %s did not keep the final snapshot", row.ID)
+		}
+	}
+	if totalIdle == 0 {
+		t.Error("expected some idle snapshots to be dropped")
+	}
+}
+
+func TestTCPParserParallel(t *testing.T) {
+	taskfilename := "testdata/20190516T013026.744845Z-tcpinfo-mlab4-arn02-ndt.tgz"
+	url := "gs://fake-archive/ndt/tcpinfo/2019/05/16/" + filepath.Base(taskfilename)
+	// The row IDs, in order, from parsing the tests serially, or in parallel.
+	rowIDs := func(parallelism int) []string {
+		src, err := fileSource(taskfilename)
+		if err != nil {
+			t.Fatal("Failed reading testdata from", taskfilename)
+		}
+		ins := newInMemorySink()
+		p := parser.NewTCPInfoParser(ins, "test", "_suffix")
+		task := task.NewTask(url, src, p, nullCloser{})
+		n, err := task.ProcessAllTestsParallel(false, parallelism)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 364 {
+			t.Errorf("Expected ProcessAllTestsParallel to handle %d files, but it handled %d.\n", 364, n)
+		}
+		if p.Committed() != ins.Committed() {
+			t.Errorf("Parser committed %d rows, sink %d", p.Committed(), ins.Committed())
+		}
+		var ids []string
+		for _, r := range ins.data {
+			ids = append(ids, r.(*schema.TCPInfoRow).ID)
+		}
+		sort.Strings(ids)
+		return ids
+	}
+	serial := rowIDs(1)
+	if len(serial) != 362 {
+		t.Fatalf("Expected 362 rows, got %d", len(serial))
+	}
+	if diff := deep.Equal(rowIDs(4), serial); diff != nil {
+		t.Errorf("Parallel rows differ from serial rows: %v", diff)
+	}
+}
+
 // This is a subset of TestTCPParser, but simpler, so might be useful.
+func TestTCPParser_Context(t *testing.T) {
+	ins := newInMemorySink()
+	p := parser.NewTCPInfoParser(ins, "test", "_suffix")
+	src, err := fileSource("testdata/20190516T013026.744845Z-tcpinfo-mlab4-arn02-ndt.tgz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var name string
+	var data []byte
+	for data == nil {
+		name, data, err = src.NextTest(task.DefaultMaxFileSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A done context stops the parse before any row is inserted.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := p.ParseAndInsertContext(ctx, nil, name, data); !errors.Is(err, context.Canceled) {
+		t.Errorf("ParseAndInsertContext() = %v, want %v", err, context.Canceled)
+	}
+	if p.Accepted() != 0 {
+		t.Errorf("Accepted() = %d rows of a stopped parse", p.Accepted())
+	}
+}
+
 func TestTCPTask(t *testing.T) {
 	// Inject fake inserter and annotator
 	ins := newInMemorySink()
@@ -281,6 +483,7 @@ func BenchmarkTCPParser(b *testing.B) {
 
 	filename := "testdata/20190516T013026.744845Z-tcpinfo-mlab4-arn02-ndt.tgz"
 	n := 0
+	b.ReportAllocs()
 	for i := 0; i < b.N; i += n {
 		src, err := fileSource(filename)
 		if err != nil {
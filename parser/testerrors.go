@@ -0,0 +1,84 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/m-lab/etl/metrics"
+)
+
+// MaxTestErrors is the number of tests in a task that may fail to parse before
+// the task fails, or -1 for no limit.
+var MaxTestErrors = -1
+
+// MaxTestErrorRatio is the largest fraction of the tests in a task that may
+// fail to parse before the task fails.  The default, 1, never fails a task.
+var MaxTestErrorRatio = 1.0
+
+// ErrTooManyTestErrors is returned by TaskError when the tests that failed to
+// parse exceed MaxTestErrors or MaxTestErrorRatio.
+var ErrTooManyTestErrors = errors.New("too many test errors")
+
+// testErrors accumulates the errors of the tests parsed in a task.  A test
+// that fails is counted, and the task continues with the next test, so that a
+// few corrupt tests do not forfeit the task.  Only when too many tests fail is
+// the task failed, by TaskError.
+// testErrors is safe for concurrent use.
+type testErrors struct {
+	table    string
+	datatype string
+
+	lock   sync.Mutex
+	tests  int            // Tests started.
+	failed int            // Tests that failed.
+	kinds  map[string]int // Failed tests, by kind of error.
+	last   error
+}
+
+// newTestErrors returns a testErrors for a parser's table and datatype, which
+// label the error metrics.
+func newTestErrors(table, datatype string) *testErrors {
+	return &testErrors{table: table, datatype: datatype, kinds: map[string]int{}}
+}
+
+// start counts a test.  It should be called once for each test parsed.
+func (e *testErrors) start() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.tests++
+}
+
+// add counts a failed test, with the kind of error, and returns err.
+func (e *testErrors) add(kind string, err error) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.failed++
+	e.kinds[kind]++
+	e.last = err
+	metrics.ErrorCount.WithLabelValues(e.table, e.datatype, kind).Inc()
+	return err
+}
+
+// Err returns ErrTooManyTestErrors, with a summary of the errors, if the failed
+// tests exceed MaxTestErrors or MaxTestErrorRatio.
+func (e *testErrors) Err() error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.failed == 0 {
+		return nil
+	}
+	if (MaxTestErrors < 0 || e.failed <= MaxTestErrors) &&
+		float64(e.failed) <= MaxTestErrorRatio*float64(e.tests) {
+		return nil
+	}
+	kinds := make([]string, 0, len(e.kinds))
+	for k, n := range e.kinds {
+		kinds = append(kinds, fmt.Sprintf("%s: %d", k, n))
+	}
+	sort.Strings(kinds)
+	return fmt.Errorf("%w: %d of %d tests failed (%s), last error: %v",
+		ErrTooManyTestErrors, e.failed, e.tests, strings.Join(kinds, ", "), e.last)
+}
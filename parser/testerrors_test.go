@@ -0,0 +1,79 @@
+package parser_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"path"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+
+	"github.com/m-lab/etl/parser"
+)
+
+func TestSwitchParser_TaskError(t *testing.T) {
+	data, err := ioutil.ReadFile(path.Join("testdata/Switch/", switchDISCOv2Filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := map[string]bigquery.Value{
+		"filename": path.Join(switchGCSPath, switchDISCOv2Filename),
+		"date":     civil.Date{Year: 2021, Month: 12, Day: 14},
+	}
+	bad := []byte(`{"experiment": "s1-dfw07`)
+	tests := []struct {
+		name      string
+		maxErrors int
+		maxRatio  float64
+		good      int
+		bad       int
+		wantErr   error
+	}{
+		{name: "no-errors", maxErrors: -1, maxRatio: 0.1, good: 3},
+		{name: "within-ratio", maxErrors: -1, maxRatio: 0.1, good: 9, bad: 1},
+		{name: "over-ratio", maxErrors: -1, maxRatio: 0.1, good: 9, bad: 2, wantErr: parser.ErrTooManyTestErrors},
+		{name: "over-count", maxErrors: 0, maxRatio: 1, good: 9, bad: 1, wantErr: parser.ErrTooManyTestErrors},
+		{name: "no-limits", maxErrors: -1, maxRatio: 1, good: 1, bad: 9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(n int, r float64) {
+				parser.MaxTestErrors, parser.MaxTestErrorRatio = n, r
+			}(parser.MaxTestErrors, parser.MaxTestErrorRatio)
+			parser.MaxTestErrors, parser.MaxTestErrorRatio = tt.maxErrors, tt.maxRatio
+
+			p := parser.NewSwitchParser(newInMemorySink(), "switch", "_suffix")
+			for i := 0; i < tt.good; i++ {
+				if err := p.ParseAndInsert(meta, switchDISCOv2Filename, data); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Failed tests are reported, and parsing continues.
+			for i := 0; i < tt.bad; i++ {
+				if err := p.ParseAndInsert(meta, "bad-switch.json", bad); err == nil {
+					t.Fatal("ParseAndInsert() expected error for corrupt test")
+				}
+			}
+			if err := p.TaskError(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("TaskError() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTCPInfoParser_TaskError(t *testing.T) {
+	defer func(r float64) { parser.MaxTestErrorRatio = r }(parser.MaxTestErrorRatio)
+	parser.MaxTestErrorRatio = 0.1
+	p := parser.NewTCPInfoParser(newInMemorySink(), "tcpinfo", "_suffix")
+	meta := map[string]bigquery.Value{
+		"filename": "gs://fake-archive/ndt/tcpinfo/2019/05/16/fake.tgz",
+		"date":     civil.Date{Year: 2019, Month: 5, Day: 16},
+	}
+	if err := p.ParseAndInsert(meta, "bad.jsonl.zst", []byte("not zstd")); err == nil {
+		t.Fatal("ParseAndInsert() expected error for corrupt test")
+	}
+	if err := p.TaskError(); !errors.Is(err, parser.ErrTooManyTestErrors) {
+		t.Errorf("TaskError() = %v, want %v", err, parser.ErrTooManyTestErrors)
+	}
+}
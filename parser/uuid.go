@@ -0,0 +1,125 @@
+package parser
+
+import (
+	"errors"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+
+	"github.com/m-lab/etl/metrics"
+	"github.com/m-lab/etl/row"
+	"github.com/m-lab/etl/schema"
+)
+
+// ErrNoUUID is returned when a filename does not contain a valid UUID.
+var ErrNoUUID = errors.New("filename does not contain a valid UUID")
+
+// uuidSuffix matches the boot time and socket cookie that end an M-Lab UUID,
+// e.g. the "_1625899199_00000000013A4623" of "ndt-4c6fb_1625899199_00000000013A4623".
+var uuidSuffix = regexp.MustCompile(`_[0-9]+_[0-9A-F]{16}`)
+
+// UUIDFromFilename extracts the UUID from modern test filenames, such as:
+//
+//	ndt-4c6fb_1625899199_00000000013A4623.00000.jsonl.zst              (tcpinfo)
+//	ndt7-download-20210722T000000.000000Z.ndt-4c6fb_1625899199_00000000013A4623.json.gz
+//	ndt-4c6fb_1625899199_00000000013A4623.json                         (annotation)
+//
+// The hostname prefix may not be empty, or contain underscores.
+func UUIDFromFilename(fn string) (string, error) {
+	base := filepath.Base(fn)
+	// Drop any timestamp prefix, which always ends with "Z.".
+	if i := strings.LastIndex(base, "Z."); i >= 0 {
+		base = base[i+2:]
+	}
+	loc := uuidSuffix.FindStringIndex(base)
+	if loc == nil || loc[0] == 0 || strings.Contains(base[:loc[0]], "_") {
+		return "", ErrNoUUID
+	}
+	// The UUID must be followed by an extension, or nothing.
+	if loc[1] < len(base) && base[loc[1]] != '.' {
+		return "", ErrNoUUID
+	}
+	return base[:loc[1]], nil
+}
+
+// UUIDMapper emits UUIDMapRows for the tests in an archive, to a lookup table
+// used for cross-datatype joins.  Each row is a join hint, recording which
+// datatype, archive, and row ID hold data for a test UUID, so that the tables
+// with data for a test can be found without scanning every table.
+// Put may be called concurrently, but not concurrently with Close.
+type UUIDMapper struct {
+	base *row.Base
+	sink row.Sink
+}
+
+// NewUUIDMapper creates a UUIDMapper that writes rows to the sink.
+func NewUUIDMapper(sink row.Sink) *UUIDMapper {
+	return &UUIDMapper{base: row.NewBase("uuid_map", sink, 1000), sink: sink}
+}
+
+// Put extracts the UUID from filename, and adds a mapping row for it, with the
+// ID of a row produced from the file.  If rowID is empty, the UUID is used.
+// The UUID is returned, or ErrNoUUID if the filename has no UUID.
+func (m *UUIDMapper) Put(datatype, archiveURL, filename, rowID string, date civil.Date) (string, error) {
+	uuid, err := UUIDFromFilename(filename)
+	if err != nil {
+		return "", err
+	}
+	if rowID == "" {
+		rowID = uuid
+	}
+	return uuid, m.base.Put(&schema.UUIDMapRow{
+		UUID:       uuid,
+		Datatype:   datatype,
+		Filename:   filename,
+		ArchiveURL: archiveURL,
+		Date:       date,
+		RowID:      rowID,
+	})
+}
+
+// Close flushes any buffered rows, and closes the sink.
+func (m *UUIDMapper) Close() error {
+	err := m.base.Flush()
+	if cerr := m.sink.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// mapTest validates the UUID in the test filename against the UUID found in
+// the test data, if not empty, and adds a mapping row for each of the rowIDs
+// produced from the test, or for dataUUID if there are none.  Failures are
+// counted, but do not prevent the test from being parsed.  A nil UUIDMapper
+// only validates.
+func (m *UUIDMapper) mapTest(table, datatype string, meta map[string]bigquery.Value, testName, dataUUID string, rowIDs ...string) {
+	uuid, err := UUIDFromFilename(testName)
+	if err != nil {
+		metrics.WarningCount.WithLabelValues(table, datatype, "no filename uuid").Inc()
+		return
+	}
+	if dataUUID != "" && dataUUID != uuid {
+		metrics.WarningCount.WithLabelValues(table, datatype, "filename uuid mismatch").Inc()
+	}
+	if m == nil {
+		return
+	}
+	archive, _ := meta["filename"].(string)
+	date, _ := meta["date"].(civil.Date)
+	if len(rowIDs) == 0 {
+		rowIDs = []string{dataUUID}
+	}
+	for _, id := range rowIDs {
+		if _, err := m.Put(datatype, archive, testName, id, date); err != nil {
+			metrics.ErrorCount.WithLabelValues(table, datatype, "uuid map error").Inc()
+		}
+	}
+}
+
+// UUIDMappable is implemented by parsers that can emit UUID mapping rows.
+type UUIDMappable interface {
+	SetUUIDMapper(m *UUIDMapper)
+}
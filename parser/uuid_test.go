@@ -0,0 +1,131 @@
+package parser_test
+
+import (
+	"io/ioutil"
+	"sort"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+	"github.com/go-test/deep"
+
+	"github.com/m-lab/etl/parser"
+	"github.com/m-lab/etl/schema"
+)
+
+func TestUUIDFromFilename(t *testing.T) {
+	tests := []struct {
+		fn      string
+		want    string
+		wantErr bool
+	}{
+		{fn: "ndt-4c6fb_1625899199_00000000013A4623.00000.jsonl.zst", want: "ndt-4c6fb_1625899199_00000000013A4623"},
+		{fn: "2021/07/22/ndt-4c6fb_1625899199_00000000013A4623.00000.jsonl.zst", want: "ndt-4c6fb_1625899199_00000000013A4623"},
+		{fn: "ndt7-download-20200318T000657.568382877Z.ndt-knwp4_1583603744_000000000000590E.json.gz", want: "ndt-knwp4_1583603744_000000000000590E"},
+		{fn: "ndt-knwp4_1583603744_000000000000590E.json", want: "ndt-knwp4_1583603744_000000000000590E"},
+		{fn: "mlab1.lga03.measurement-lab.org_1583603744_000000000000590E", want: "mlab1.lga03.measurement-lab.org_1583603744_000000000000590E"},
+		{fn: "_1583603744_000000000000590E.json", wantErr: true},
+		{fn: "ndt_knwp4_1583603744_000000000000590E.json", wantErr: true},
+		{fn: "ndt-knwp4_1583603744_000000000000590E00.json", wantErr: true},
+		{fn: "ndt-knwp4_1583603744_590E.json", wantErr: true},
+		{fn: "20090601T22:19:19.325928000Z-75.133.69.98:60631.s2c_snaplog", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fn, func(t *testing.T) {
+			got, err := parser.UUIDFromFilename(tt.fn)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UUIDFromFilename() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("UUIDFromFilename() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUUIDMapper(t *testing.T) {
+	testName := `ndt7-download-20200318T000657.568382877Z.ndt-knwp4_1583603744_000000000000590E.json`
+	archive := "gs://mlab-test-bucket/ndt/ndt7/2020/03/18/20200318T003853.425987Z-ndt7-mlab3-syd03-ndt.tgz"
+	date := civil.Date{Year: 2020, Month: 3, Day: 18}
+
+	ins := newInMemorySink()
+	mapIns := newInMemorySink()
+	n := parser.NewNDT7ResultParser(ins, "test", "_suffix")
+	m := parser.NewUUIDMapper(mapIns)
+	mp, ok := n.(parser.UUIDMappable)
+	if !ok {
+		t.Fatal("NDT7ResultParser does not implement UUIDMappable")
+	}
+	mp.SetUUIDMapper(m)
+
+	data, err := ioutil.ReadFile(`testdata/NDT7Result/` + testName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := map[string]bigquery.Value{"filename": archive, "date": date}
+	if err := n.ParseAndInsert(meta, testName, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(mapIns.data) != 1 {
+		t.Fatalf("UUIDMapper wrote %d rows, want 1", len(mapIns.data))
+	}
+	want := &schema.UUIDMapRow{
+		UUID:       "ndt-knwp4_1583603744_000000000000590E",
+		Datatype:   "ndt7_result",
+		Filename:   testName,
+		ArchiveURL: archive,
+		Date:       date,
+		RowID:      "ndt-knwp4_1583603744_000000000000590E",
+	}
+	if diff := deep.Equal(mapIns.data[0], want); diff != nil {
+		t.Error(diff)
+	}
+
+	if _, err := m.Put("ndt7", archive, "badfile.badextension", "", date); err != parser.ErrNoUUID {
+		t.Errorf("UUIDMapper.Put() error = %v, want %v", err, parser.ErrNoUUID)
+	}
+}
+
+func TestUUIDMapper_NDT5RowIDs(t *testing.T) {
+	testName := `ndt-5hkck_1566219987_000000000000017D.json`
+	archive := "gs://mlab-test-bucket/ndt/ndt5/2019/08/19/20190819T000000.000000Z-ndt5-mlab1-foo01-ndt.tgz"
+	date := civil.Date{Year: 2019, Month: 8, Day: 19}
+
+	mapIns := newInMemorySink()
+	n := parser.NewNDT5ResultParser(newInMemorySink(), "test", "_suffix")
+	m := parser.NewUUIDMapper(mapIns)
+	mp, ok := n.(parser.UUIDMappable)
+	if !ok {
+		t.Fatal("NDT5ResultParser does not implement UUIDMappable")
+	}
+	mp.SetUUIDMapper(m)
+
+	data, err := ioutil.ReadFile(`testdata/NDT5Result/` + testName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := map[string]bigquery.Value{"filename": archive, "date": date}
+	if err := n.ParseAndInsert(meta, testName, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Each measurement row is mapped to the test UUID.
+	ids := []string{}
+	for _, r := range mapIns.data {
+		row := r.(*schema.UUIDMapRow)
+		if row.UUID != "ndt-5hkck_1566219987_000000000000017D" {
+			t.Errorf("UUIDMapRow.UUID = %q, want test UUID", row.UUID)
+		}
+		ids = append(ids, row.RowID)
+	}
+	sort.Strings(ids)
+	want := []string{"ndt-5hkck_1566219987_0000000000000181", "ndt-5hkck_1566219987_0000000000000183"}
+	if diff := deep.Equal(ids, want); diff != nil {
+		t.Error(diff)
+	}
+}
@@ -0,0 +1,288 @@
+// Package provenance builds the standard queries that report where the rows of
+// a parser output table came from: how many rows each archive produced, which
+// parser versions wrote each partition, and which dates have no rows at all.
+// The same queries are used by validation, deduplication and dashboards, so
+// that they agree on what "complete" means.
+//
+// Since every such query scans whole partitions, the rows per archive and
+// parser version can also be materialized, one partition at a time, in a small
+// side table next to the source table.  Source.Tasks reads the same reports
+// from the side table instead.
+package provenance
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+	"google.golang.org/api/iterator"
+
+	"github.com/m-lab/go/cloud/bqx"
+
+	"github.com/m-lab/etl/etl"
+)
+
+// TasksSuffix is appended to the name of a source table to name its side
+// table of task summaries.
+const TasksSuffix = "_tasks"
+
+// Source describes the columns of a table that record provenance.
+type Source struct {
+	// Table is the fully qualified table name, e.g. mlab-oti.raw_ndt.ndt7
+	Table string
+	// Date is the expression for the partition date of a row.
+	Date string
+	// Archive is the column holding the archive a row was parsed from.
+	Archive string
+	// Version is the column holding the parser version that wrote a row.
+	Version string
+	// Rows is the aggregate expression for the number of rows in a group.
+	// Empty means COUNT(*).
+	Rows string
+}
+
+// Standard returns the Source for a table with standard columns.
+func Standard(table string) Source {
+	return Source{
+		Table:   table,
+		Date:    "date",
+		Archive: "parser.ArchiveURL",
+		Version: "parser.Version",
+	}
+}
+
+// Legacy returns the Source for an ingestion time partitioned legacy table,
+// e.g. ndt web100, sidestream or traceroute.
+func Legacy(table string) Source {
+	return Source{
+		Table:   table,
+		Date:    "_PARTITIONDATE",
+		Archive: "task_filename",
+		Version: "parser_version",
+	}
+}
+
+// Tasks returns the Source for the side table of s, written by UpdateTasks.
+// Each row of the side table counts the rows of one archive and parser
+// version on one date, so reports read from it scan far less than reports
+// read from s.
+func (s Source) Tasks() Source {
+	return Source{
+		Table:   s.Table + TasksSuffix,
+		Date:    "date",
+		Archive: "archive_url",
+		Version: "version",
+		Rows:    "SUM(rows)",
+	}
+}
+
+// ArchiveRows is the number of rows parsed from one archive on one date.
+type ArchiveRows struct {
+	Date       civil.Date `bigquery:"date"`
+	ArchiveURL string     `bigquery:"archive_url"`
+	Rows       int64      `bigquery:"rows"`
+}
+
+// VersionRows is the number of rows written by one parser version to one
+// partition.
+type VersionRows struct {
+	Date    civil.Date `bigquery:"date"`
+	Version string     `bigquery:"version"`
+	Rows    int64      `bigquery:"rows"`
+}
+
+// Gap is a run of consecutive dates with no rows.
+type Gap struct {
+	Start civil.Date `bigquery:"start"`
+	End   civil.Date `bigquery:"end"`
+	Days  int64      `bigquery:"days"`
+}
+
+// PartitionStats summarizes a single partition.  It is a single row, so it can
+// be read with bqx.Dataset.QueryAndParse.
+type PartitionStats struct {
+	Rows     int64 `bigquery:"rows"`
+	Archives int64 `bigquery:"archives"`
+	Versions int64 `bigquery:"versions"`
+}
+
+// rows returns the expression for the number of rows in a group.
+func (s Source) rows() string {
+	if s.Rows == "" {
+		return "COUNT(*)"
+	}
+	return s.Rows
+}
+
+// dateRange returns the filter selecting rows from start to end inclusive.
+func (s Source) dateRange(start, end civil.Date) string {
+	return fmt.Sprintf(`%s BETWEEN "%s" AND "%s"`, s.Date, start, end)
+}
+
+// ArchiveRowsSQL returns the query for the rows per archive per date.
+func (s Source) ArchiveRowsSQL(start, end civil.Date) string {
+	return fmt.Sprintf(`
+SELECT
+  %s AS date,
+  %s AS archive_url,
+  %s AS rows
+FROM `+"`%s`"+`
+WHERE %s
+GROUP BY date, archive_url
+ORDER BY date, archive_url`, s.Date, s.Archive, s.rows(), s.Table, s.dateRange(start, end))
+}
+
+// VersionRowsSQL returns the query for the rows per parser version per date.
+func (s Source) VersionRowsSQL(start, end civil.Date) string {
+	return fmt.Sprintf(`
+SELECT
+  %s AS date,
+  %s AS version,
+  %s AS rows
+FROM `+"`%s`"+`
+WHERE %s
+GROUP BY date, version
+ORDER BY date, version`, s.Date, s.Version, s.rows(), s.Table, s.dateRange(start, end))
+}
+
+// GapsSQL returns the query for the runs of dates from start to end inclusive
+// that have no rows.
+func (s Source) GapsSQL(start, end civil.Date) string {
+	return fmt.Sprintf(`
+WITH present AS (
+  SELECT DISTINCT %s AS date
+  FROM `+"`%s`"+`
+  WHERE %s
+), missing AS (
+  SELECT day
+  FROM UNNEST(GENERATE_DATE_ARRAY("%s", "%s")) AS day
+  LEFT JOIN present ON present.date = day
+  WHERE present.date IS NULL
+), runs AS (
+  SELECT day, DATE_SUB(day, INTERVAL ROW_NUMBER() OVER (ORDER BY day) DAY) AS run
+  FROM missing
+)
+SELECT
+  MIN(day) AS start,
+  MAX(day) AS `+"`end`"+`,
+  COUNT(*) AS days
+FROM runs
+GROUP BY run
+ORDER BY start`, s.Date, s.Table, s.dateRange(start, end), start, end)
+}
+
+// PartitionStatsSQL returns the query for the stats of the date's partition.
+func (s Source) PartitionStatsSQL(date civil.Date) string {
+	return fmt.Sprintf(`
+SELECT
+  %s AS rows,
+  COUNT(DISTINCT %s) AS archives,
+  COUNT(DISTINCT %s) AS versions
+FROM `+"`%s`"+`
+WHERE %s = "%s"`, s.rows(), s.Archive, s.Version, s.Table, s.Date, date)
+}
+
+// TasksSQL returns the query for the rows of the side table for the date's
+// partition.
+func (s Source) TasksSQL(date civil.Date) string {
+	return fmt.Sprintf(`
+SELECT
+  %s AS date,
+  %s AS archive_url,
+  %s AS version,
+  %s AS rows
+FROM `+"`%s`"+`
+WHERE %s = "%s"
+GROUP BY date, archive_url, version`, s.Date, s.Archive, s.Version, s.rows(), s.Table, s.Date, date)
+}
+
+// UpdateTasks recomputes the date's partition of the side table of s,
+// replacing any previous content for that date.  It should be run after each
+// parse or dedup of the date.  The side table is created, partitioned by date,
+// if it does not exist.
+func (s Source) UpdateTasks(ctx context.Context, client *bigquery.Client, date civil.Date) error {
+	pdt, err := bqx.ParsePDT(s.Tasks().Table)
+	if err != nil {
+		return err
+	}
+	q := client.Query(s.TasksSQL(date))
+	q.Dst = client.DatasetInProject(pdt.Project, pdt.Dataset).Table(
+		pdt.Table + "$" + date.In(time.UTC).Format("20060102"))
+	q.WriteDisposition = bigquery.WriteTruncate
+	q.CreateDisposition = bigquery.CreateIfNeeded
+	q.TimePartitioning = &bigquery.TimePartitioning{Field: "date"}
+
+	job, err := q.Run(ctx)
+	if err != nil {
+		return etl.ErrBigQuery.Errorf("updating %s: %w", pdt.Table, err)
+	}
+	status, err := job.Wait(ctx)
+	if err != nil {
+		return etl.ErrBigQuery.Errorf("updating %s: %w", pdt.Table, err)
+	}
+	if err := status.Err(); err != nil {
+		return etl.ErrBigQuery.Errorf("updating %s: %w", pdt.Table, err)
+	}
+	return nil
+}
+
+// ArchiveRows runs ArchiveRowsSQL and returns the results.
+func (s Source) ArchiveRows(ctx context.Context, client *bigquery.Client, start, end civil.Date) ([]ArchiveRows, error) {
+	rows := []ArchiveRows{}
+	err := read(ctx, client.Query(s.ArchiveRowsSQL(start, end)), func(it *bigquery.RowIterator) error {
+		var r ArchiveRows
+		err := it.Next(&r)
+		if err == nil {
+			rows = append(rows, r)
+		}
+		return err
+	})
+	return rows, err
+}
+
+// VersionRows runs VersionRowsSQL and returns the results.
+func (s Source) VersionRows(ctx context.Context, client *bigquery.Client, start, end civil.Date) ([]VersionRows, error) {
+	rows := []VersionRows{}
+	err := read(ctx, client.Query(s.VersionRowsSQL(start, end)), func(it *bigquery.RowIterator) error {
+		var r VersionRows
+		err := it.Next(&r)
+		if err == nil {
+			rows = append(rows, r)
+		}
+		return err
+	})
+	return rows, err
+}
+
+// Gaps runs GapsSQL and returns the results.
+func (s Source) Gaps(ctx context.Context, client *bigquery.Client, start, end civil.Date) ([]Gap, error) {
+	gaps := []Gap{}
+	err := read(ctx, client.Query(s.GapsSQL(start, end)), func(it *bigquery.RowIterator) error {
+		var g Gap
+		err := it.Next(&g)
+		if err == nil {
+			gaps = append(gaps, g)
+		}
+		return err
+	})
+	return gaps, err
+}
+
+// read runs the query and calls next until it returns iterator.Done.
+func read(ctx context.Context, q *bigquery.Query, next func(it *bigquery.RowIterator) error) error {
+	it, err := q.Read(ctx)
+	if err != nil {
+		return etl.ErrBigQuery.Errorf("reading query: %w", err)
+	}
+	for {
+		err := next(it)
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return etl.ErrBigQuery.Errorf("reading query: %w", err)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package provenance_test
+
+import (
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/civil"
+
+	"github.com/m-lab/etl/provenance"
+)
+
+func TestSource_SQL(t *testing.T) {
+	start := civil.Date{Year: 2022, Month: 7, Day: 1}
+	end := civil.Date{Year: 2022, Month: 7, Day: 4}
+	tests := []struct {
+		name    string
+		src     provenance.Source
+		archive string
+	}{
+		{name: "standard", src: provenance.Standard("mlab-sandbox.tmp_ndt.ndt7"), archive: "parser.ArchiveURL"},
+		{name: "legacy", src: provenance.Legacy("mlab-sandbox.batch.sidestream"), archive: "task_filename"},
+		{name: "tasks", src: provenance.Standard("mlab-sandbox.tmp_ndt.ndt7").Tasks(), archive: "archive_url"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queries := map[string]string{
+				"ArchiveRowsSQL":    tt.src.ArchiveRowsSQL(start, end),
+				"VersionRowsSQL":    tt.src.VersionRowsSQL(start, end),
+				"GapsSQL":           tt.src.GapsSQL(start, end),
+				"PartitionStatsSQL": tt.src.PartitionStatsSQL(end),
+				"TasksSQL":          tt.src.TasksSQL(end),
+			}
+			for name, sql := range queries {
+				if !strings.Contains(sql, "`"+tt.src.Table+"`") {
+					t.Errorf("%s() missing source table:\n%s", name, sql)
+				}
+				if !strings.Contains(sql, tt.src.Date) {
+					t.Errorf("%s() missing date column %s:\n%s", name, tt.src.Date, sql)
+				}
+				if !strings.Contains(sql, `"2022-07-04"`) {
+					t.Errorf("%s() missing end date:\n%s", name, sql)
+				}
+			}
+			if !strings.Contains(queries["ArchiveRowsSQL"], tt.archive+" AS archive_url") {
+				t.Errorf("ArchiveRowsSQL() missing archive column %s:\n%s", tt.archive, queries["ArchiveRowsSQL"])
+			}
+			if !strings.Contains(queries["GapsSQL"], `GENERATE_DATE_ARRAY("2022-07-01", "2022-07-04")`) {
+				t.Errorf("GapsSQL() missing date array:\n%s", queries["GapsSQL"])
+			}
+		})
+	}
+}
+
+func TestSource_Tasks(t *testing.T) {
+	date := civil.Date{Year: 2022, Month: 7, Day: 4}
+	src := provenance.Legacy("mlab-sandbox.batch.sidestream")
+	tasks := src.Tasks()
+	if tasks.Table != "mlab-sandbox.batch.sidestream_tasks" {
+		t.Errorf("Tasks() table = %q", tasks.Table)
+	}
+	// The side table is computed by counting rows of the source table.
+	if sql := src.TasksSQL(date); !strings.Contains(sql, "COUNT(*) AS rows") ||
+		!strings.Contains(sql, "task_filename AS archive_url") ||
+		!strings.Contains(sql, "parser_version AS version") {
+		t.Errorf("TasksSQL() =\n%s", sql)
+	}
+	// Reports from the side table add up its row counts.
+	for name, sql := range map[string]string{
+		"ArchiveRowsSQL":    tasks.ArchiveRowsSQL(date, date),
+		"VersionRowsSQL":    tasks.VersionRowsSQL(date, date),
+		"PartitionStatsSQL": tasks.PartitionStatsSQL(date),
+	} {
+		if !strings.Contains(sql, "SUM(rows) AS rows") {
+			t.Errorf("%s() does not sum rows:\n%s", name, sql)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+package retry
+
+import (
+	"context"
+	"time"
+)
+
+// SetSleepForTest replaces the sleep function, and returns a function that
+// restores the original.
+func SetSleepForTest(f func(context.Context, time.Duration) error) func() {
+	orig := sleep
+	sleep = f
+	return func() { sleep = orig }
+}
@@ -0,0 +1,115 @@
+// Package retry provides exponential backoff with jitter, an attempt limit,
+// a total delay budget, and a predicate for retryable errors.  It is the
+// shared implementation for retry loops throughout the pipeline.
+package retry
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff describes an exponential backoff policy.  The zero value retries
+// immediately, forever, for any error, so most callers should set at least
+// Base and MaxAttempts.
+type Backoff struct {
+	Base        time.Duration // Delay before the second attempt.
+	Max         time.Duration // Maximum delay between attempts, if non-zero.
+	Multiplier  float64       // Growth factor for each delay.  Defaults to 2.
+	Jitter      float64       // Randomizes each delay by +/- this fraction, e.g. 0.1.
+	MaxAttempts int           // Maximum number of attempts, if non-zero.
+	Budget      time.Duration // Maximum total delay across all attempts, if non-zero.
+
+	// Retryable reports whether an error should be retried.  If nil, all
+	// errors are retried, except those wrapped with Stop.
+	Retryable func(error) bool
+}
+
+// sleep waits for d, or until the context is done.  It is a variable so that
+// tests can observe delays without sleeping.
+var sleep = func(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// stopError wraps an error that must not be retried.
+type stopError struct {
+	err error
+}
+
+func (s stopError) Error() string { return s.err.Error() }
+func (s stopError) Unwrap() error { return s.err }
+
+// Stop wraps err so that Do returns it immediately without retrying.  Do
+// returns the original, unwrapped error.
+func Stop(err error) error {
+	if err == nil {
+		return nil
+	}
+	return stopError{err}
+}
+
+// Delay returns the delay after the given attempt, numbered from 1, without
+// jitter.
+func (b Backoff) Delay(attempt int) time.Duration {
+	mult := b.Multiplier
+	if mult == 0 {
+		mult = 2
+	}
+	d := float64(b.Base) * math.Pow(mult, float64(attempt-1))
+	if b.Max > 0 && d > float64(b.Max) {
+		return b.Max
+	}
+	if d > math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(d)
+}
+
+// jittered applies the jitter fraction to d.
+func (b Backoff) jittered(d time.Duration) time.Duration {
+	if b.Jitter <= 0 {
+		return d
+	}
+	return time.Duration(float64(d) * (1 + b.Jitter*(2*rand.Float64()-1)))
+}
+
+// Do calls f until it succeeds, returns a non-retryable error, or the attempt
+// limit or delay budget is exhausted.  The attempt number, starting at 1, is
+// passed to f.  Do returns nil on success, otherwise the last error from f,
+// or the context error if the context is done while waiting.
+func (b Backoff) Do(ctx context.Context, f func(attempt int) error) error {
+	var total time.Duration
+	for attempt := 1; ; attempt++ {
+		err := f(attempt)
+		if err == nil {
+			return nil
+		}
+		var stop stopError
+		if errors.As(err, &stop) {
+			return stop.err
+		}
+		if b.Retryable != nil && !b.Retryable(err) {
+			return err
+		}
+		if b.MaxAttempts > 0 && attempt >= b.MaxAttempts {
+			return err
+		}
+		d := b.jittered(b.Delay(attempt))
+		if b.Budget > 0 && total+d > b.Budget {
+			return err
+		}
+		total += d
+		if sErr := sleep(ctx, d); sErr != nil {
+			return sErr
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package retry_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+
+	"github.com/m-lab/etl/retry"
+)
+
+var errTransient = errors.New("transient")
+
+// recordSleeps replaces the retry sleep with one that records delays.
+func recordSleeps(t *testing.T) *[]time.Duration {
+	delays := []time.Duration{}
+	restore := retry.SetSleepForTest(func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return ctx.Err()
+	})
+	t.Cleanup(restore)
+	return &delays
+}
+
+func failN(n int, err error) (func(int) error, *int) {
+	calls := 0
+	return func(attempt int) error {
+		calls++
+		if attempt <= n {
+			return err
+		}
+		return nil
+	}, &calls
+}
+
+func TestBackoff_Delay(t *testing.T) {
+	b := retry.Backoff{Base: 10 * time.Millisecond, Max: 50 * time.Millisecond}
+	got := []time.Duration{}
+	for i := 1; i <= 5; i++ {
+		got = append(got, b.Delay(i))
+	}
+	want := []time.Duration{10, 20, 40, 50, 50}
+	for i := range want {
+		want[i] *= time.Millisecond
+	}
+	if diff := deep.Equal(got, want); diff != nil {
+		t.Error(diff)
+	}
+
+	b = retry.Backoff{Base: time.Second, Multiplier: 3}
+	if b.Delay(3) != 9*time.Second {
+		t.Errorf("Delay(3) = %v, want 9s", b.Delay(3))
+	}
+	b = retry.Backoff{Base: time.Hour}
+	if b.Delay(200) <= 0 {
+		t.Errorf("Delay(200) overflowed: %v", b.Delay(200))
+	}
+}
+
+func TestBackoff_Do(t *testing.T) {
+	tests := []struct {
+		name      string
+		backoff   retry.Backoff
+		failures  int
+		err       error
+		wantErr   error
+		wantCalls int
+		wantSleep []time.Duration
+	}{
+		{
+			name:      "success",
+			backoff:   retry.Backoff{Base: time.Millisecond, MaxAttempts: 3},
+			wantCalls: 1,
+			wantSleep: []time.Duration{},
+		},
+		{
+			name:      "retry-then-success",
+			backoff:   retry.Backoff{Base: time.Millisecond, MaxAttempts: 3},
+			failures:  2,
+			err:       errTransient,
+			wantCalls: 3,
+			wantSleep: []time.Duration{time.Millisecond, 2 * time.Millisecond},
+		},
+		{
+			name:      "attempts-exhausted",
+			backoff:   retry.Backoff{Base: time.Millisecond, MaxAttempts: 3},
+			failures:  5,
+			err:       errTransient,
+			wantErr:   errTransient,
+			wantCalls: 3,
+			wantSleep: []time.Duration{time.Millisecond, 2 * time.Millisecond},
+		},
+		{
+			name:      "budget-exhausted",
+			backoff:   retry.Backoff{Base: time.Millisecond, Budget: 5 * time.Millisecond},
+			failures:  10,
+			err:       errTransient,
+			wantErr:   errTransient,
+			wantCalls: 3,
+			wantSleep: []time.Duration{time.Millisecond, 2 * time.Millisecond},
+		},
+		{
+			name:      "stop",
+			backoff:   retry.Backoff{Base: time.Millisecond, MaxAttempts: 3},
+			failures:  5,
+			err:       retry.Stop(errTransient),
+			wantErr:   errTransient,
+			wantCalls: 1,
+			wantSleep: []time.Duration{},
+		},
+		{
+			name: "not-retryable",
+			backoff: retry.Backoff{
+				Base: time.Millisecond, MaxAttempts: 3,
+				Retryable: func(err error) bool { return err != errTransient },
+			},
+			failures:  5,
+			err:       errTransient,
+			wantErr:   errTransient,
+			wantCalls: 1,
+			wantSleep: []time.Duration{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delays := recordSleeps(t)
+			f, calls := failN(tt.failures, tt.err)
+			err := tt.backoff.Do(context.Background(), f)
+			if err != tt.wantErr {
+				t.Errorf("Do() error = %v, want %v", err, tt.wantErr)
+			}
+			if *calls != tt.wantCalls {
+				t.Errorf("Do() calls = %d, want %d", *calls, tt.wantCalls)
+			}
+			if diff := deep.Equal(*delays, tt.wantSleep); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestBackoff_DoJitter(t *testing.T) {
+	delays := recordSleeps(t)
+	b := retry.Backoff{Base: 100 * time.Millisecond, Jitter: 0.1, MaxAttempts: 20}
+	f, _ := failN(19, errTransient)
+	if err := b.Do(context.Background(), f); err != nil {
+		t.Fatal(err)
+	}
+	for i, d := range *delays {
+		nominal := b.Delay(i + 1)
+		if d < nominal*9/10 || d > nominal*11/10 {
+			t.Errorf("delay %d = %v, outside 10%% of %v", i, d, nominal)
+		}
+	}
+}
+
+func TestBackoff_DoContext(t *testing.T) {
+	// Use the real sleep, with a delay much longer than the timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	b := retry.Backoff{Base: time.Minute}
+	start := time.Now()
+	err := b.Do(ctx, func(int) error { return errTransient })
+	if err != context.DeadlineExceeded {
+		t.Errorf("Do() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Error("Do() did not return promptly on context timeout")
+	}
+}
@@ -0,0 +1,99 @@
+package row
+
+import "sync"
+
+// BQRequestLimit is the maximum size of a BigQuery insert request.
+const BQRequestLimit = 10 * 1024 * 1024
+
+// Defaults for adaptive batch sizing.
+const (
+	// DefaultTargetBatchBytes leaves headroom below BQRequestLimit for the
+	// request encoding overhead, and for rows larger than the observed average.
+	DefaultTargetBatchBytes = 8 * 1024 * 1024
+	// DefaultMaxBatchRows bounds the batch size for very small rows, where
+	// per-row overhead dominates.
+	DefaultMaxBatchRows = 5000
+)
+
+// sampleEvery is the interval between row size samples, after the first
+// sampleFirst rows, which are always sampled.
+const (
+	sampleFirst = 4
+	sampleEvery = 16
+)
+
+// ewmaWeight is the weight given to each new row size sample.
+const ewmaWeight = 0.25
+
+// BatchSizer adapts the number of rows per batch, to keep the serialized size
+// of each batch near a target size.  It tracks a moving average of sampled row
+// sizes, but sizes each batch for the larger of the average and the most
+// recent sample, so that a run of large rows shrinks batches immediately.
+// BatchSizer is THREAD-SAFE.
+type BatchSizer struct {
+	lock    sync.Mutex
+	target  int
+	maxRows int
+	avg     float64 // moving average of sampled row bytes.
+	samples int
+	size    int
+}
+
+// NewBatchSizer creates a BatchSizer with an initial batch size, which is used
+// until the first row size is observed.
+func NewBatchSizer(initial, targetBytes, maxRows int) *BatchSizer {
+	if initial < 1 {
+		initial = 1
+	}
+	if initial > maxRows {
+		initial = maxRows
+	}
+	return &BatchSizer{target: targetBytes, maxRows: maxRows, size: initial}
+}
+
+// Observe records the serialized size of a row, and returns the updated
+// batch size.
+func (s *BatchSizer) Observe(rowBytes int) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.samples == 0 {
+		s.avg = float64(rowBytes)
+	} else {
+		s.avg += ewmaWeight * (float64(rowBytes) - s.avg)
+	}
+	s.samples++
+
+	est := s.avg
+	if float64(rowBytes) > est {
+		est = float64(rowBytes)
+	}
+	size := s.maxRows
+	if est > 0 && float64(s.target)/est < float64(s.maxRows) {
+		size = int(float64(s.target) / est)
+	}
+	if size < 1 {
+		size = 1
+	}
+	s.size = size
+	return size
+}
+
+// Size returns the current batch size.
+func (s *BatchSizer) Size() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.size
+}
+
+// AverageRowBytes returns the moving average of the observed row sizes.
+func (s *BatchSizer) AverageRowBytes() float64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.avg
+}
+
+// shouldSample reports whether the nth row (counting from zero) should be
+// sampled.
+func shouldSample(n int) bool {
+	return n < sampleFirst || n%sampleEvery == 0
+}
@@ -0,0 +1,29 @@
+package row
+
+import "time"
+
+// Clock provides the time recorded in rows as the parse time.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is a Clock that returns the current time.
+type SystemClock struct{}
+
+// Now returns time.Now().
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// FixedClock is a Clock that always returns the same time, so that the parse
+// times of rows, and hence the rows themselves, are reproducible.
+type FixedClock time.Time
+
+// Now returns the fixed time.
+func (c FixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+// DefaultClock is the Clock for Bases created by NewBase.  It should only be
+// modified during initialization.
+var DefaultClock Clock = SystemClock{}
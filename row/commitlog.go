@@ -0,0 +1,148 @@
+package row
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Sampling intervals for commit logging.  Each kind of record is logged at
+// most once per interval, and reports how many records were suppressed since
+// the previous one.  These should only be modified during initialization.
+var (
+	CommitFailureLogInterval = time.Second
+	CommitSuccessLogInterval = time.Minute
+)
+
+// Retried may be implemented by errors returned from Sink.Commit, to report
+// how many times the sink retried the commit before giving up.
+type Retried interface {
+	Retries() int
+}
+
+// ID returns the value of the ID field of a row struct, if present.  Rows that
+// are not structs, or have no non-empty string field named ID, report false.
+func ID(r interface{}) (string, bool) {
+	v := reflect.ValueOf(r)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return "", false
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return "", false
+	}
+	f := v.FieldByName("ID")
+	if !f.IsValid() || f.Kind() != reflect.String || f.String() == "" {
+		return "", false
+	}
+	return f.String(), true
+}
+
+// sampler admits at most one event per interval.
+type sampler struct {
+	lock       sync.Mutex
+	interval   *time.Duration
+	last       time.Time
+	suppressed int
+}
+
+// sample reports whether the event should be logged, and if so, how many
+// events were suppressed since the last one logged.
+func (s *sampler) sample(now time.Time) (bool, int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.last.IsZero() && now.Sub(s.last) < *s.interval {
+		s.suppressed++
+		return false, 0
+	}
+	n := s.suppressed
+	s.last = now
+	s.suppressed = 0
+	return true, n
+}
+
+// commitSamplers holds the failure and success samplers for each table label,
+// so that frequent records for one table do not suppress those of another.
+var commitSamplers = struct {
+	lock    sync.Mutex
+	byLabel map[string]*[2]sampler // failure, success
+}{byLabel: map[string]*[2]sampler{}}
+
+// samplerFor returns the label's sampler for failed or successful commits.
+func samplerFor(label string, failed bool) *sampler {
+	commitSamplers.lock.Lock()
+	defer commitSamplers.lock.Unlock()
+	s, ok := commitSamplers.byLabel[label]
+	if !ok {
+		s = &[2]sampler{
+			{interval: &CommitFailureLogInterval},
+			{interval: &CommitSuccessLogInterval},
+		}
+		commitSamplers.byLabel[label] = s
+	}
+	if failed {
+		return &s[0]
+	}
+	return &s[1]
+}
+
+// commitRecord is the structured log record for a committed batch.
+type commitRecord struct {
+	Msg        string `json:"msg"`
+	Table      string `json:"table"`
+	Rows       int    `json:"rows"`
+	Committed  int    `json:"committed"`
+	Bytes      int    `json:"bytes"`
+	FirstID    string `json:"first_id,omitempty"`
+	LastID     string `json:"last_id,omitempty"`
+	Retries    int    `json:"retries"`
+	Suppressed int    `json:"suppressed"`
+	Error      string `json:"error,omitempty"`
+}
+
+// logCommit logs a sampled, structured record of a batch commit, so that
+// sink side issues, such as BigQuery errors, can be correlated with specific
+// batches.  The batch is only serialized if the record is logged.
+func logCommit(label string, rows []interface{}, committed int, err error) {
+	if len(rows) == 0 && err == nil {
+		return
+	}
+	msg := "commit"
+	if err != nil {
+		msg = "commit failed"
+	}
+	ok, suppressed := samplerFor(label, err != nil).sample(time.Now())
+	if !ok {
+		return
+	}
+	rec := commitRecord{
+		Msg:        msg,
+		Table:      label,
+		Rows:       len(rows),
+		Committed:  committed,
+		Suppressed: suppressed,
+	}
+	for i := range rows {
+		if j, err := json.Marshal(rows[i]); err == nil {
+			rec.Bytes += len(j)
+		}
+	}
+	if len(rows) > 0 {
+		rec.FirstID, _ = ID(rows[0])
+		rec.LastID, _ = ID(rows[len(rows)-1])
+	}
+	if err != nil {
+		rec.Error = err.Error()
+		var r Retried
+		if errors.As(err, &r) {
+			rec.Retries = r.Retries()
+		}
+	}
+	j, _ := json.Marshal(rec) // Cannot fail for this type.
+	log.Println(string(j))
+}
@@ -0,0 +1,9 @@
+package row
+
+// ResetCommitSamplersForTest forgets the commit log samplers of all tables,
+// so that each test starts with no suppressed records.
+func ResetCommitSamplersForTest() {
+	commitSamplers.lock.Lock()
+	defer commitSamplers.lock.Unlock()
+	commitSamplers.byLabel = map[string]*[2]sampler{}
+}
@@ -0,0 +1,70 @@
+package row
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/m-lab/etl/metrics"
+)
+
+// ErrDuplicateRowID is returned by TaskError when a task emitted rows with
+// duplicate IDs, and the IDPolicy is IDCheckFlag.
+var ErrDuplicateRowID = errors.New("duplicate row IDs")
+
+// IDPolicy determines how Base handles rows whose ID was already Put by the
+// same task.  Duplicate IDs within a task are a strong signal of a parser bug.
+type IDPolicy string
+
+// IDPolicy values.
+const (
+	IDCheckOff  = IDPolicy("off")  // IDs are not checked.
+	IDCheckFlag = IDPolicy("flag") // Duplicates are counted, and fail the task.
+	IDCheckDrop = IDPolicy("drop") // Duplicates are counted, and dropped.
+)
+
+// IDPolicies lists the valid IDPolicy values, e.g. for flags.
+func IDPolicies() []string {
+	return []string{string(IDCheckOff), string(IDCheckFlag), string(IDCheckDrop)}
+}
+
+// DefaultIDPolicy is the IDPolicy for Bases created by NewBase.  It should
+// only be modified during initialization.
+var DefaultIDPolicy = IDCheckOff
+
+// idChecker tracks the row IDs Put by a task.
+type idChecker struct {
+	policy IDPolicy
+	seen   map[string]struct{}
+	dupes  int
+}
+
+func newIDChecker(policy IDPolicy) *idChecker {
+	if policy == IDCheckOff || policy == "" {
+		return nil
+	}
+	return &idChecker{policy: policy, seen: map[string]struct{}{}}
+}
+
+// keep reports whether the row should be kept.  Rows without IDs are always
+// kept.
+func (c *idChecker) keep(label string, row interface{}) bool {
+	id, ok := ID(row)
+	if !ok {
+		return true
+	}
+	if _, dup := c.seen[id]; !dup {
+		c.seen[id] = struct{}{}
+		return true
+	}
+	c.dupes++
+	metrics.WarningCount.WithLabelValues(label, "", "duplicate row id").Inc()
+	return c.policy != IDCheckDrop
+}
+
+// err returns ErrDuplicateRowID if duplicates should fail the task.
+func (c *idChecker) err() error {
+	if c == nil || c.dupes == 0 || c.policy != IDCheckFlag {
+		return nil
+	}
+	return fmt.Errorf("%w: %d rows", ErrDuplicateRowID, c.dupes)
+}
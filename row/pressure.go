@@ -0,0 +1,26 @@
+package row
+
+import "sync/atomic"
+
+// flushGeneration is incremented by each RequestFlush.  Each Base records the
+// generation it has seen, and commits its buffered rows when it changes.
+var flushGeneration int64
+
+// RequestFlush asks every Base to commit its buffered rows early, on its next
+// Put, rather than waiting for the buffer to fill.  This trades insert
+// efficiency for lower memory use, e.g. when the process nears its memory
+// limit.  It is safe to call from any goroutine.
+func RequestFlush() {
+	atomic.AddInt64(&flushGeneration, 1)
+}
+
+// flushRequested reports whether RequestFlush has been called since the last
+// call, or since the Base was created.
+func (pb *Base) flushRequested() bool {
+	g := atomic.LoadInt64(&flushGeneration)
+	if g == pb.flushGen {
+		return false
+	}
+	pb.flushGen = g
+	return true
+}
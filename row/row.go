@@ -4,11 +4,14 @@ package row
 // Probably should have Base implement Parser.
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/m-lab/go/logx"
 
@@ -21,6 +24,7 @@ var (
 	ErrNotAnnotatable  = errors.New("object does not implement Annotatable")
 	ErrBufferFull      = errors.New("Buffer full")
 	ErrInvalidSink     = errors.New("Not a valid row.Sink")
+	ErrAbandoned       = errors.New("Base abandoned")
 )
 
 // ErrCommitRow is returned when there was an error committing a
@@ -116,6 +120,76 @@ type Sink interface {
 	io.Closer
 }
 
+// Counter is implemented by Sinks that count the rows they have committed, so
+// that the count can be reconciled with the parser's row stats.
+type Counter interface {
+	// Committed returns the number of rows committed so far.
+	Committed() int
+}
+
+// Discarder is implemented by Sinks that publish rows only when closed, so
+// that the rows of a failed task can be dropped instead, and the task retried
+// without duplicating them.
+type Discarder interface {
+	// Discard causes Close to drop all rows committed so far.
+	Discard()
+}
+
+// Syncer is implemented by Sinks that publish rows only when closed, so that
+// a task can make the rows committed so far durable before it records a
+// checkpoint.
+type Syncer interface {
+	// Sync publishes the rows committed since the last Sync, and continues
+	// with objects named for the entry'th entry of the archive.  Objects are
+	// thus named for the entry at which they start, so that a retry that
+	// resumes after the entry replaces only objects it rewrites.
+	Sync(entry int) error
+}
+
+// Transformer modifies rows after they are Put, and before they are buffered,
+// e.g. to redact fields or backfill values.  Transform may modify the row in
+// place, or return a replacement.  A nil row (and nil error) drops the row.
+type Transformer interface {
+	Transform(row interface{}) (interface{}, error)
+}
+
+// TransformerFunc adapts an ordinary function to the Transformer interface.
+type TransformerFunc func(row interface{}) (interface{}, error)
+
+// Transform implements Transformer.
+func (f TransformerFunc) Transform(row interface{}) (interface{}, error) {
+	return f(row)
+}
+
+// transformerRegistry holds the Transformers to be applied for each datatype.
+var transformerRegistry = struct {
+	lock sync.RWMutex
+	byDT map[string][]Transformer
+}{byDT: map[string][]Transformer{}}
+
+// RegisterTransformer adds a Transformer to be applied to rows of the given
+// datatype.  Transformers are applied in the order they are registered.
+// This should typically be called during program initialization.
+func RegisterTransformer(datatype string, t Transformer) {
+	transformerRegistry.lock.Lock()
+	defer transformerRegistry.lock.Unlock()
+	transformerRegistry.byDT[datatype] = append(transformerRegistry.byDT[datatype], t)
+}
+
+// Transformers returns the Transformers registered for the datatype.
+func Transformers(datatype string) []Transformer {
+	transformerRegistry.lock.RLock()
+	defer transformerRegistry.lock.RUnlock()
+	return append([]Transformer{}, transformerRegistry.byDT[datatype]...)
+}
+
+// ResetTransformers removes all registered Transformers.  Intended for tests.
+func ResetTransformers() {
+	transformerRegistry.lock.Lock()
+	defer transformerRegistry.lock.Unlock()
+	transformerRegistry.byDT = map[string][]Transformer{}
+}
+
 // Buffer provides all basic functionality generally needed for buffering, annotating, and inserting
 // rows that implement Annotatable.
 // Buffer functions are THREAD-SAFE
@@ -147,6 +221,14 @@ func (buf *Buffer) Append(row interface{}) []interface{} {
 	return rows
 }
 
+// SetSize changes the number of rows before starting a new buffer.  If the
+// buffer already holds more rows, they are all returned by the next Append.
+func (buf *Buffer) SetSize(size int) {
+	buf.lock.Lock()
+	defer buf.lock.Unlock()
+	buf.size = size
+}
+
 // Reset clears the buffer, returning all pending rows.
 func (buf *Buffer) Reset() []interface{} {
 	buf.lock.Lock()
@@ -157,19 +239,127 @@ func (buf *Buffer) Reset() []interface{} {
 }
 
 // Base provides common parser functionality.
-// Base is NOT THREAD-SAFE
+// Put, Flush, and the row and error counts may be called concurrently, so
+// that the tests of a task can be parsed concurrently.  The Set methods
+// configure the Base, and must be called before it is used.
 type Base struct {
 	sink  Sink
 	buf   *Buffer
 	label string // Used in metrics and errors.
 
+	transformers []Transformer // Applied in order to each row in Put.
+
+	expected map[string]int // Rows expected from each test, until consumed.
+
+	ids   *idChecker  // Optional. Checks for duplicate row IDs.
+	sizer *BatchSizer // Optional. Adapts the buffer size to the row sizes.
+	puts  int         // Rows Put, used to sample row sizes.
+
+	flushGen  int64 // Last flush generation seen.  See RequestFlush.
+	abandoned int32 // Set atomically by Abandon.
+
+	bufferedSince time.Time // When the oldest buffered row was buffered.
+
+	clock Clock // Provides the parse time for rows.
+
+	// putLock serializes the buffering of rows by Put and Flush, and
+	// protects the fields they use, so that the tests of a task may be parsed
+	// concurrently.  It is not held while rows are committed to the sink.
+	putLock sync.Mutex
+
 	stats ActiveStats
 }
 
 // NewBase creates a new Base.  This will generally be embedded in a type specific parser.
 func NewBase(label string, sink Sink, bufSize int) *Base {
 	buf := NewBuffer(bufSize)
-	return &Base{sink: sink, buf: buf, label: label}
+	return &Base{sink: sink, buf: buf, label: label, expected: map[string]int{},
+		ids: newIDChecker(DefaultIDPolicy), flushGen: atomic.LoadInt64(&flushGeneration),
+		clock: DefaultClock}
+}
+
+// SetClock sets the Clock that provides the parse time for rows.
+func (pb *Base) SetClock(c Clock) {
+	pb.clock = c
+}
+
+// Now returns the parse time for rows, from the Base's Clock.  Parsers should
+// use it instead of time.Now, so that the parse time can be fixed.
+func (pb *Base) Now() time.Time {
+	return pb.clock.Now()
+}
+
+// SetIDPolicy sets the handling of duplicate row IDs.  It should be called
+// before any rows are Put.
+func (pb *Base) SetIDPolicy(p IDPolicy) {
+	pb.ids = newIDChecker(p)
+}
+
+// DuplicateIDs returns the number of rows Put with an ID already Put, if IDs
+// are checked.
+func (pb *Base) DuplicateIDs() int {
+	pb.putLock.Lock()
+	defer pb.putLock.Unlock()
+	if pb.ids == nil {
+		return 0
+	}
+	return pb.ids.dupes
+}
+
+// NewAdaptiveBase creates a new Base whose buffer size adapts to the observed
+// row sizes, starting from bufSize, so that each batch committed to the sink
+// is near DefaultTargetBatchBytes when serialized.
+func NewAdaptiveBase(label string, sink Sink, bufSize int) *Base {
+	b := NewBase(label, sink, bufSize)
+	b.SetBatchSizer(NewBatchSizer(bufSize, DefaultTargetBatchBytes, DefaultMaxBatchRows))
+	return b
+}
+
+// SetBatchSizer sets the BatchSizer used to adapt the buffer size, or disables
+// adaptation if nil.
+func (pb *Base) SetBatchSizer(s *BatchSizer) {
+	pb.sizer = s
+	if s != nil {
+		pb.buf.SetSize(s.Size())
+	}
+}
+
+// observe samples the serialized size of some rows, and resizes the buffer
+// accordingly.  Sinks serialize rows as JSON, so that is used as the estimate.
+func (pb *Base) observe(row interface{}) {
+	n := pb.puts
+	pb.puts++
+	if !shouldSample(n) {
+		return
+	}
+	j, err := json.Marshal(row)
+	if err != nil {
+		return // The sink will report the error.
+	}
+	pb.buf.SetSize(pb.sizer.Observe(len(j)))
+}
+
+// ExpectRows records the number of rows the named test should produce.
+// Parsers should call this once per test, including when a test legitimately
+// produces no rows.  Since the count is kept per test, tests may be parsed
+// concurrently.
+func (pb *Base) ExpectRows(testName string, n int) {
+	pb.putLock.Lock()
+	defer pb.putLock.Unlock()
+	pb.expected[testName] = n
+}
+
+// ExpectedRows implements etl.RowCounter.  It returns the count recorded by
+// ExpectRows for the named test, or -1 if none was recorded, and forgets it.
+func (pb *Base) ExpectedRows(testName string) int {
+	pb.putLock.Lock()
+	defer pb.putLock.Unlock()
+	n, ok := pb.expected[testName]
+	if !ok {
+		return -1
+	}
+	delete(pb.expected, testName)
+	return n
 }
 
 // GetStats returns the buffer/sink stats.
@@ -177,19 +367,75 @@ func (pb *Base) GetStats() Stats {
 	return pb.stats.GetStats()
 }
 
+// SetTransformers replaces the Transformers applied to each row by Put.
+func (pb *Base) SetTransformers(t ...Transformer) {
+	pb.transformers = t
+}
+
+// transform applies all Transformers to the row, stopping if a row is dropped.
+func (pb *Base) transform(row interface{}) (interface{}, error) {
+	var err error
+	for _, t := range pb.transformers {
+		row, err = t.Transform(row)
+		if err != nil || row == nil {
+			return nil, err
+		}
+	}
+	return row, nil
+}
+
+// Abandon implements etl.Abandoner.  After Abandon, Put and Flush discard rows
+// and return ErrAbandoned, so that a parser the task has given up on no longer
+// writes to the sink.
+func (pb *Base) Abandon() {
+	atomic.StoreInt32(&pb.abandoned, 1)
+}
+
+func (pb *Base) isAbandoned() bool {
+	return atomic.LoadInt32(&pb.abandoned) != 0
+}
+
 // TaskError return the task level error, based on failed rows, or any other criteria.
+// Currently, this reports duplicate row IDs, if the IDPolicy is IDCheckFlag.
 func (pb *Base) TaskError() error {
-	return nil
+	pb.putLock.Lock()
+	defer pb.putLock.Unlock()
+	return pb.ids.err()
+}
+
+// take records the time the oldest of the rows taken from the buffer spent
+// buffered, and returns the time they were taken.  next is the time the
+// oldest row still buffered was buffered, or zero if the buffer is empty.
+func (pb *Base) take(rows []interface{}, next time.Time) time.Time {
+	now := time.Now()
+	if len(rows) > 0 && !pb.bufferedSince.IsZero() {
+		metrics.CommitStageHistogram.WithLabelValues(pb.label, "buffered").Observe(
+			now.Sub(pb.bufferedSince).Seconds())
+	}
+	pb.bufferedSince = next
+	return now
 }
 
-func (pb *Base) commit(rows []interface{}) error {
+// commit commits rows taken from the buffer at the given time.
+func (pb *Base) commit(rows []interface{}, taken time.Time) error {
+	if pb.isAbandoned() {
+		pb.stats.Done(len(rows), ErrAbandoned)
+		return ErrAbandoned
+	}
+	start := time.Now()
 	// This is synchronous, blocking, and thread safe.
 	done, err := pb.sink.Commit(rows, pb.label)
+	if len(rows) > 0 {
+		metrics.CommitStageHistogram.WithLabelValues(pb.label, "pending").Observe(
+			start.Sub(taken).Seconds())
+		metrics.CommitStageHistogram.WithLabelValues(pb.label, "sink").Observe(
+			time.Since(start).Seconds())
+	}
+	logCommit(pb.label, rows, done, err)
 	if done > 0 {
 		pb.stats.Done(done, nil)
 	}
 	if err != nil {
-		log.Println(pb.label, err)
 		pb.stats.Done(len(rows)-done, err)
 		return ErrCommitRow{err}
 	}
@@ -198,33 +444,101 @@ func (pb *Base) commit(rows []interface{}) error {
 
 // Flush synchronously flushes any pending rows.
 func (pb *Base) Flush() error {
+	pb.putLock.Lock()
 	rows := pb.buf.Reset()
+	taken := pb.take(rows, time.Time{})
 	pb.stats.MoveToPending(len(rows))
-	return pb.commit(rows)
+	pb.putLock.Unlock()
+	return pb.commit(rows, taken)
+}
+
+// batch is a block of rows taken from the buffer, to be committed.
+type batch struct {
+	rows  []interface{}
+	taken time.Time
 }
 
 // Put adds a row to the buffer. If the buffer is already full, then prior
 // buffered rows are committed to the Sink. NOTE: There is no guarantee about
 // when writes will result from sequential calls to Put. However, once a block
 // of rows is "committed", they will be written to the Sink in the same order
-// they were Put.
+// they were Put.  Put is safe to call concurrently.
 func (pb *Base) Put(row interface{}) error {
-	rows := pb.buf.Append(row)
-	pb.stats.Inc()
+	// The rows are committed after the lock is released, so that a slow sink
+	// does not block the buffering of rows by concurrent tests.
+	batches, err := pb.put(row)
+	if err != nil {
+		return err
+	}
+	for _, b := range batches {
+		if err := pb.commitBuffered(b.rows, b.taken); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	if rows != nil {
-		pb.stats.MoveToPending(len(rows))
-		err := pb.commit(rows)
+// put adds a row to the buffer, and returns the blocks of rows taken from the
+// buffer, which the caller must commit.
+func (pb *Base) put(row interface{}) ([]batch, error) {
+	pb.putLock.Lock()
+	defer pb.putLock.Unlock()
+	if pb.isAbandoned() {
+		return nil, ErrAbandoned
+	}
+	if len(pb.transformers) > 0 {
+		var err error
+		row, err = pb.transform(row)
 		if err != nil {
-			// Note that error is likely associated with buffered rows, not the current
-			// row.
-			// When using GCS output, this may result in a corrupted json file.
-			// In that event, the test count may become meaningless.
-			metrics.TestTotal.WithLabelValues(pb.label, pb.label, "error").Inc()
 			metrics.ErrorCount.WithLabelValues(
-				pb.label, "", "put error").Inc()
-			return err
+				pb.label, "", "transform error").Inc()
+			return nil, err
+		}
+		if row == nil {
+			metrics.WarningCount.WithLabelValues(
+				pb.label, "", "transform dropped row").Inc()
+			return nil, nil
+		}
+	}
+	if pb.ids != nil && !pb.ids.keep(pb.label, row) {
+		return nil, nil
+	}
+	if pb.sizer != nil {
+		pb.observe(row)
+	}
+	var batches []batch
+	if pb.flushRequested() {
+		if rows := pb.buf.Reset(); len(rows) > 0 {
+			metrics.PressureFlushCount.WithLabelValues(pb.label).Inc()
+			batches = append(batches, batch{rows, pb.take(rows, time.Time{})})
 		}
 	}
+	rows := pb.buf.Append(row)
+	pb.stats.Inc()
+
+	if rows != nil {
+		// The row starts a new buffer.
+		return append(batches, batch{rows, pb.take(rows, time.Now())}), nil
+	}
+	if pb.bufferedSince.IsZero() {
+		pb.bufferedSince = time.Now()
+	}
+	return batches, nil
+}
+
+// commitBuffered commits rows taken from the buffer during Put.
+func (pb *Base) commitBuffered(rows []interface{}, taken time.Time) error {
+	pb.stats.MoveToPending(len(rows))
+	err := pb.commit(rows, taken)
+	if err != nil {
+		// Note that error is likely associated with buffered rows, not the current
+		// row.
+		// When using GCS output, this may result in a corrupted json file.
+		// In that event, the test count may become meaningless.
+		metrics.TestTotal.WithLabelValues(pb.label, pb.label, "error").Inc()
+		metrics.ErrorCount.WithLabelValues(
+			pb.label, "", "put error").Inc()
+		return err
+	}
 	return nil
 }
@@ -2,9 +2,17 @@ package row_test
 
 import (
 	"errors"
+	"fmt"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/m-lab/go/logx"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/m-lab/etl/etl"
+	"github.com/m-lab/etl/metrics"
 	"github.com/m-lab/etl/row"
 )
 
@@ -72,6 +80,38 @@ func TestBase(t *testing.T) {
 	}
 }
 
+func TestBaseClock(t *testing.T) {
+	b := row.NewBase("test", &inMemorySink{}, 10)
+	if d := time.Since(b.Now()); d < 0 || d > time.Minute {
+		t.Errorf("Now() with default clock is %v from the current time", d)
+	}
+	fixed := time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC)
+	b.SetClock(row.FixedClock(fixed))
+	if got := b.Now(); !got.Equal(fixed) {
+		t.Errorf("Now() = %v, want %v", got, fixed)
+	}
+}
+
+func TestAbandon(t *testing.T) {
+	ins := &inMemorySink{}
+	b := row.NewBase("test", ins, 10)
+
+	b.Put(&Row{"1.2.3.4", "4.3.2.1"})
+	b.Abandon()
+	if err := b.Put(&Row{"1.2.3.4", "4.3.2.1"}); err != row.ErrAbandoned {
+		t.Errorf("Put() after Abandon = %v, want %v", err, row.ErrAbandoned)
+	}
+	if err := b.Flush(); err != row.ErrAbandoned {
+		t.Errorf("Flush() after Abandon = %v, want %v", err, row.ErrAbandoned)
+	}
+	if len(ins.data) != 0 {
+		t.Errorf("Abandoned Base committed %d rows", len(ins.data))
+	}
+	if stats := b.GetStats(); stats.Failed != 1 {
+		t.Errorf("Failed = %d, want 1", stats.Failed)
+	}
+}
+
 func TestAsyncPut(t *testing.T) {
 	ins := &inMemorySink{}
 
@@ -99,6 +139,58 @@ func TestAsyncPut(t *testing.T) {
 	}
 }
 
+// blockingSink blocks each Commit until it receives from release.
+type blockingSink struct {
+	inMemorySink
+	committing chan bool
+	release    chan bool
+}
+
+func (bs *blockingSink) Commit(data []interface{}, label string) (int, error) {
+	bs.committing <- true
+	<-bs.release
+	return bs.inMemorySink.Commit(data, label)
+}
+
+func TestPutDuringCommit(t *testing.T) {
+	bs := &blockingSink{committing: make(chan bool, 10), release: make(chan bool)}
+	b := row.NewBase("test", bs, 2)
+	b.Put(&Row{"1.2.3.4", "4.3.2.1"})
+	b.Put(&Row{"1.2.3.4", "4.3.2.1"})
+
+	done := make(chan error)
+	go func() {
+		// The third row starts a new buffer, so the first two are committed.
+		done <- b.Put(&Row{"1.2.3.4", "4.3.2.1"})
+	}()
+	<-bs.committing
+
+	// Rows of other tests are buffered while the commit is blocked.
+	put := make(chan error)
+	go func() {
+		put <- b.Put(&Row{"1.2.3.4", "4.3.2.1"})
+	}()
+	select {
+	case err := <-put:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Put() blocked by Commit")
+	}
+
+	close(bs.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(bs.data) != 4 {
+		t.Errorf("committed %d rows, want 4", len(bs.data))
+	}
+}
+
 func TestErrCommitRow(t *testing.T) {
 	baseErr := errors.New("googleapi.Error")
 	commitErr := row.ErrCommitRow{baseErr}
@@ -117,3 +209,343 @@ func TestErrCommitRow(t *testing.T) {
 		t.Errorf("ErrCommitRow.As() failed to recognize error as ErrCommitRow, expected: true, got: false")
 	}
 }
+
+func TestTransformers(t *testing.T) {
+	ins := &inMemorySink{}
+	b := row.NewBase("test", ins, 10)
+
+	redact := row.TransformerFunc(func(r interface{}) (interface{}, error) {
+		rr := r.(*Row)
+		rr.client = "redacted"
+		return rr, nil
+	})
+	dropEmpty := row.TransformerFunc(func(r interface{}) (interface{}, error) {
+		if r.(*Row).server == "" {
+			return nil, nil
+		}
+		return r, nil
+	})
+	b.SetTransformers(dropEmpty, redact)
+
+	b.Put(&Row{"1.2.3.4", "4.3.2.1"})
+	b.Put(&Row{"1.2.3.4", ""}) // dropped
+	b.Flush()
+
+	if len(ins.data) != 1 {
+		t.Fatalf("Expected 1 row, got %d", len(ins.data))
+	}
+	if ins.data[0].(*Row).client != "redacted" {
+		t.Error("Expected redacted client, got", ins.data[0].(*Row).client)
+	}
+	if b.GetStats().Total() != 1 {
+		t.Error("Dropped rows should not be counted:", b.GetStats())
+	}
+
+	fail := errors.New("transform failed")
+	b.SetTransformers(row.TransformerFunc(func(r interface{}) (interface{}, error) {
+		return nil, fail
+	}))
+	if err := b.Put(&Row{"1.2.3.4", "4.3.2.1"}); !errors.Is(err, fail) {
+		t.Errorf("Put() error = %v, want %v", err, fail)
+	}
+}
+
+func TestTransformerRegistry(t *testing.T) {
+	defer row.ResetTransformers()
+	noop := row.TransformerFunc(func(r interface{}) (interface{}, error) { return r, nil })
+	row.RegisterTransformer("foo", noop)
+	row.RegisterTransformer("foo", noop)
+	if len(row.Transformers("foo")) != 2 {
+		t.Error("Expected 2 transformers for foo")
+	}
+	if len(row.Transformers("bar")) != 0 {
+		t.Error("Expected no transformers for bar")
+	}
+}
+
+func assertBaseIsRowCounter(b *row.Base) {
+	func(etl.RowCounter) {}(b)
+}
+
+func TestExpectRows(t *testing.T) {
+	b := row.NewBase("test", &inMemorySink{}, 10)
+	if n := b.ExpectedRows("a"); n != -1 {
+		t.Errorf("ExpectedRows(a) = %d, want -1 before ExpectRows", n)
+	}
+	// The counts of concurrent tests are kept separately.
+	b.ExpectRows("a", 0)
+	b.ExpectRows("b", 2)
+	if n := b.ExpectedRows("b"); n != 2 {
+		t.Errorf("ExpectedRows(b) = %d, want 2", n)
+	}
+	if n := b.ExpectedRows("a"); n != 0 {
+		t.Errorf("ExpectedRows(a) = %d, want 0", n)
+	}
+	// The count is consumed by ExpectedRows.
+	if n := b.ExpectedRows("a"); n != -1 {
+		t.Errorf("ExpectedRows(a) = %d, want -1 after consuming", n)
+	}
+}
+
+func TestBatchSizer(t *testing.T) {
+	s := row.NewBatchSizer(10, 1000, 50)
+	if s.Size() != 10 {
+		t.Errorf("Size() = %d, want initial 10", s.Size())
+	}
+	// Small rows are bounded by maxRows.
+	if n := s.Observe(10); n != 50 {
+		t.Errorf("Observe(10) = %d, want 50", n)
+	}
+	// A large row shrinks the batch immediately.
+	if n := s.Observe(500); n != 2 {
+		t.Errorf("Observe(500) = %d, want 2", n)
+	}
+	// Then the batch grows back as the average recovers.
+	n := 0
+	for i := 0; i < 20; i++ {
+		n = s.Observe(100)
+	}
+	if n < 9 || n > 10 {
+		t.Errorf("Observe(100) = %d after 20 samples, want about 10", n)
+	}
+	// Rows larger than the target are committed alone.
+	if n := s.Observe(5000); n != 1 {
+		t.Errorf("Observe(5000) = %d, want 1", n)
+	}
+}
+
+type sizedRow struct {
+	Data string
+}
+
+type batchSink struct {
+	inMemorySink
+	batches []int
+}
+
+func (b *batchSink) Commit(data []interface{}, label string) (int, error) {
+	b.batches = append(b.batches, len(data))
+	return b.inMemorySink.Commit(data, label)
+}
+
+func TestAdaptiveBase(t *testing.T) {
+	ins := &batchSink{}
+	b := row.NewBase("test", ins, 2)
+	b.SetBatchSizer(row.NewBatchSizer(2, 1000, 100))
+
+	// Rows serialize to 111 bytes, so batches should hold 9.
+	for i := 0; i < 40; i++ {
+		b.Put(&sizedRow{Data: strings.Repeat("x", 100)})
+	}
+	b.Flush()
+	if len(ins.data) != 40 {
+		t.Fatalf("committed %d rows, want 40", len(ins.data))
+	}
+	for _, n := range ins.batches[:len(ins.batches)-1] {
+		if n != 9 {
+			t.Errorf("batch sizes = %v, want 9", ins.batches)
+			break
+		}
+	}
+}
+
+type idRow struct {
+	ID string
+}
+
+type retriedErr struct{}
+
+func (retriedErr) Error() string { return "backend error" }
+func (retriedErr) Retries() int  { return 3 }
+
+type failingSink struct{}
+
+func (failingSink) Commit(data []interface{}, label string) (int, error) {
+	return 1, retriedErr{}
+}
+func (failingSink) Close() error { return nil }
+
+func TestCommitLogging(t *testing.T) {
+	defer func(d time.Duration) { row.CommitFailureLogInterval = d }(row.CommitFailureLogInterval)
+	row.CommitFailureLogInterval = time.Hour
+	row.ResetCommitSamplersForTest()
+
+	b := row.NewBase("test", failingSink{}, 10)
+	out, err := logx.CaptureLog(nil, func() {
+		for i := 0; i < 3; i++ {
+			b.Put(&idRow{ID: fmt.Sprint("a", i)})
+			b.Put(&idRow{ID: fmt.Sprint("b", i)})
+			b.Flush()
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the first failure is logged, within the interval.
+	want := `{"msg":"commit failed","table":"test","rows":2,"committed":1,"bytes":22,` +
+		`"first_id":"a0","last_id":"b0","retries":3,"suppressed":0,"error":"backend error"}`
+	if strings.Count(out, `"msg"`) != 1 || !strings.Contains(out, want) {
+		t.Errorf("commit log = %q, want one %q", out, want)
+	}
+
+	row.CommitFailureLogInterval = 0
+	out, _ = logx.CaptureLog(nil, func() {
+		b.Put(&idRow{ID: "c"})
+		b.Flush()
+	})
+	if !strings.Contains(out, `"suppressed":2`) {
+		t.Errorf("commit log = %q, want 2 suppressed", out)
+	}
+
+	// Failures for one table do not suppress those of another.
+	row.CommitFailureLogInterval = time.Hour
+	other := row.NewBase("other", failingSink{}, 10)
+	out, _ = logx.CaptureLog(nil, func() {
+		b.Put(&idRow{ID: "d"})
+		b.Flush()
+		other.Put(&idRow{ID: "e"})
+		other.Flush()
+	})
+	if strings.Count(out, `"msg"`) != 1 || !strings.Contains(out, `"table":"other"`) {
+		t.Errorf("commit log = %q, want one record for table other", out)
+	}
+}
+
+func TestID(t *testing.T) {
+	tests := []struct {
+		row  interface{}
+		want string
+		ok   bool
+	}{
+		{&idRow{ID: "x"}, "x", true},
+		{idRow{ID: "y"}, "y", true},
+		{&idRow{}, "", false},
+		{(*idRow)(nil), "", false},
+		{&Row{}, "", false},
+		{"string", "", false},
+	}
+	for _, tt := range tests {
+		if got, ok := row.ID(tt.row); got != tt.want || ok != tt.ok {
+			t.Errorf("ID(%#v) = %q, %v, want %q, %v", tt.row, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestIDPolicy(t *testing.T) {
+	rows := []interface{}{&idRow{ID: "a"}, &idRow{ID: "b"}, &idRow{ID: "a"}, &Row{}, &Row{}}
+	tests := []struct {
+		policy  row.IDPolicy
+		want    int
+		dupes   int
+		wantErr error
+	}{
+		{row.IDCheckOff, 5, 0, nil},
+		{row.IDCheckFlag, 5, 1, row.ErrDuplicateRowID},
+		{row.IDCheckDrop, 4, 1, nil},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			ins := &inMemorySink{}
+			b := row.NewBase("test", ins, 10)
+			b.SetIDPolicy(tt.policy)
+			for _, r := range rows {
+				if err := b.Put(r); err != nil {
+					t.Fatal(err)
+				}
+			}
+			b.Flush()
+			if len(ins.data) != tt.want {
+				t.Errorf("committed %d rows, want %d", len(ins.data), tt.want)
+			}
+			if b.DuplicateIDs() != tt.dupes {
+				t.Errorf("DuplicateIDs() = %d, want %d", b.DuplicateIDs(), tt.dupes)
+			}
+			if err := b.TaskError(); !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Errorf("TaskError() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRequestFlush(t *testing.T) {
+	ins := &inMemorySink{}
+	b := row.NewBase("test", ins, 10)
+	b.Put(&Row{"1.2.3.4", "4.3.2.1"})
+	b.Put(&Row{"1.2.3.4", "4.3.2.1"})
+	if len(ins.data) != 0 {
+		t.Fatalf("committed %d rows before flush request, want 0", len(ins.data))
+	}
+
+	row.RequestFlush()
+	// Bases created after the request are unaffected by it.
+	later := row.NewBase("test", &inMemorySink{}, 10)
+	b.Put(&Row{"1.2.3.4", "4.3.2.1"})
+	if len(ins.data) != 2 {
+		t.Errorf("committed %d rows after flush request, want 2", len(ins.data))
+	}
+	// The request is honored once.
+	b.Put(&Row{"1.2.3.4", "4.3.2.1"})
+	if len(ins.data) != 2 {
+		t.Errorf("committed %d rows after second Put, want 2", len(ins.data))
+	}
+	later.Put(&Row{"1.2.3.4", "4.3.2.1"})
+	if later.GetStats().Committed != 0 {
+		t.Errorf("later Base committed %d rows, want 0", later.GetStats().Committed)
+	}
+}
+
+// slowSink delays each commit.
+type slowSink struct {
+	inMemorySink
+	delay time.Duration
+}
+
+func (s *slowSink) Commit(data []interface{}, label string) (int, error) {
+	time.Sleep(s.delay)
+	return s.inMemorySink.Commit(data, label)
+}
+
+// stage returns the sample count and sum of the commit stage histogram.
+func stage(t *testing.T, label, stage string) (uint64, float64) {
+	m := &dto.Metric{}
+	h := metrics.CommitStageHistogram.WithLabelValues(label, stage).(prometheus.Histogram)
+	if err := h.Write(m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount(), m.GetHistogram().GetSampleSum()
+}
+
+func TestCommitStages(t *testing.T) {
+	sink := &slowSink{delay: 10 * time.Millisecond}
+	b := row.NewBase("stages", sink, 2)
+	// The histograms are global, so only count the samples of this test.
+	before := map[string]uint64{}
+	beforeSum := map[string]float64{}
+	for _, s := range []string{"buffered", "pending", "sink"} {
+		before[s], beforeSum[s] = stage(t, "stages", s)
+	}
+	for i := 0; i < 3; i++ {
+		b.Put(&Row{"1.2.3.4", "4.3.2.1"})
+		time.Sleep(5 * time.Millisecond)
+	}
+	// The third Put committed the first two rows.
+	for _, s := range []string{"buffered", "pending", "sink"} {
+		if n, _ := stage(t, "stages", s); n-before[s] != 1 {
+			t.Errorf("%s count = %d after Put, want 1", s, n-before[s])
+		}
+	}
+	b.Flush()
+	// Flushing an empty buffer records nothing.
+	b.Flush()
+	for _, s := range []string{"buffered", "pending", "sink"} {
+		if n, _ := stage(t, "stages", s); n-before[s] != 2 {
+			t.Errorf("%s count = %d after Flush, want 2", s, n-before[s])
+		}
+	}
+	if _, sum := stage(t, "stages", "buffered"); sum-beforeSum["buffered"] < 0.010 {
+		t.Errorf("buffered sum = %v, want at least 10ms", sum-beforeSum["buffered"])
+	}
+	if _, sum := stage(t, "stages", "sink"); sum-beforeSum["sink"] < 0.020 {
+		t.Errorf("sink sum = %v, want at least 20ms", sum-beforeSum["sink"])
+	}
+}
@@ -0,0 +1,42 @@
+package schema
+
+import (
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+	"github.com/m-lab/go/cloud/bqx"
+)
+
+// Kinds of difference reported in DiffRow.
+const (
+	DiffChanged          = "changed"
+	DiffMissingBaseline  = "missing_in_baseline"
+	DiffMissingCandidate = "missing_in_candidate"
+	DiffDuplicate        = "duplicate"
+)
+
+// DiffRow defines the BQ schema for a field-level difference between the rows
+// produced by a baseline parser and a candidate parser for the same row ID.
+// Values are JSON encoded, so that fields of any type share the same columns.
+type DiffRow struct {
+	ID        string     `bigquery:"id" json:"id"`
+	Date      civil.Date `bigquery:"date" json:"date"`
+	Table     string     `bigquery:"table" json:"table"`
+	Kind      string     `bigquery:"kind" json:"kind"`
+	Field     string     `bigquery:"field" json:"field"`
+	Baseline  string     `bigquery:"baseline" json:"baseline"`
+	Candidate string     `bigquery:"candidate" json:"candidate"`
+}
+
+// Schema returns the BigQuery schema for DiffRow.
+func (row *DiffRow) Schema() (bigquery.Schema, error) {
+	sch, err := bigquery.InferSchema(row)
+	if err != nil {
+		return bigquery.Schema{}, err
+	}
+	docs := FindSchemaDocsFor(row)
+	for _, doc := range docs {
+		bqx.UpdateSchemaDescription(sch, doc)
+	}
+	rr := bqx.RemoveRequired(sch)
+	return rr, err
+}
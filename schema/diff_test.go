@@ -0,0 +1,30 @@
+package schema
+
+import (
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+
+	"github.com/m-lab/go/cloud/bqx"
+)
+
+func TestDiffRow_Schema(t *testing.T) {
+	row := &DiffRow{}
+	got, err := row.Schema()
+	if err != nil {
+		t.Fatalf("DiffRow.Schema() unexpected error = %v", err)
+	}
+
+	count := 0
+	bqx.WalkSchema(got, func(prefix []string, field *bigquery.FieldSchema) error {
+		if field.Description == "" {
+			t.Errorf("DiffRow.Schema() missing field.Description for %q", field.Name)
+		} else {
+			count++
+		}
+		return nil
+	})
+	if count != 7 {
+		t.Errorf("DiffRow.Schema() missing expected fields; got %d, want 7", count)
+	}
+}
@@ -0,0 +1,42 @@
+package schema
+
+import (
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+	"github.com/m-lab/go/cloud/bqx"
+)
+
+// FileStatsRow defines the BQ schema for the profile of the test files in an
+// archive.  The histograms of each archive use the same bins, so they can be
+// summed per datatype, to choose buffer sizes and memory limits from the real
+// distribution of file sizes.
+type FileStatsRow struct {
+	ArchiveURL    string         `bigquery:"archive_url" json:"archive_url"`
+	Datatype      string         `bigquery:"datatype" json:"datatype"`
+	Date          civil.Date     `bigquery:"date" json:"date"`
+	Files         int64          `bigquery:"files" json:"files"`
+	ArchivedBytes int64          `bigquery:"archived_bytes" json:"archived_bytes"`
+	Bytes         int64          `bigquery:"bytes" json:"bytes"`
+	Sizes         []HistogramBin `bigquery:"sizes" json:"sizes"`
+	Ratios        []HistogramBin `bigquery:"ratios" json:"ratios"`
+}
+
+// HistogramBin counts the values from Min up to the Min of the next bin.
+type HistogramBin struct {
+	Min   float64 `bigquery:"min" json:"min"`
+	Count int64   `bigquery:"count" json:"count"`
+}
+
+// Schema returns the BigQuery schema for FileStatsRow.
+func (row *FileStatsRow) Schema() (bigquery.Schema, error) {
+	sch, err := bigquery.InferSchema(row)
+	if err != nil {
+		return bigquery.Schema{}, err
+	}
+	docs := FindSchemaDocsFor(row)
+	for _, doc := range docs {
+		bqx.UpdateSchemaDescription(sch, doc)
+	}
+	rr := bqx.RemoveRequired(sch)
+	return rr, err
+}
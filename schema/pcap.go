@@ -1,16 +1,48 @@
 package schema
 
 import (
+	"time"
+
 	"cloud.google.com/go/bigquery"
 	"cloud.google.com/go/civil"
 	"github.com/m-lab/go/cloud/bqx"
 )
 
+// PCAPSummary summarizes the TCP flow captured in a pcap file.  The source of
+// the flow is the sender of the first TCP packet, usually the client.
+type PCAPSummary struct {
+	SrcIP     string
+	SrcPort   int64
+	DstIP     string
+	DstPort   int64
+	StartTime time.Time
+	EndTime   time.Time
+
+	// Packets and Bytes count all TCP packets, and their IP lengths.
+	Packets    int64
+	Bytes      int64
+	SrcPackets int64
+	SrcBytes   int64
+	DstPackets int64
+	DstBytes   int64
+
+	// TCP options of the first SYN.
+	MSS           int64
+	WindowScale   int64
+	SACKPermitted bool
+	Timestamps    bool
+
+	// Retransmissions estimates the retransmitted data segments, as the
+	// segments that end at or before data already sent in their direction.
+	Retransmissions int64
+}
+
 // PCAPRow describes a single BQ row of pcap (packet capture) data.
 type PCAPRow struct {
-	ID     string     `bigquery:"id"`
-	Parser ParseInfo  `bigquery:"parser"`
-	Date   civil.Date `bigquery:"date"`
+	ID     string       `bigquery:"id"`
+	A      *PCAPSummary `bigquery:"a"`
+	Parser ParseInfo    `bigquery:"parser"`
+	Date   civil.Date   `bigquery:"date"`
 }
 
 // Schema returns the Bigquery schema for Pcap.
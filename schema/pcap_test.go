@@ -16,7 +16,7 @@ func TestPCAPRow_Schema(t *testing.T) {
 	}
 	count := 0
 	bqx.WalkSchema(got, func(prefix []string, field *bigquery.FieldSchema) error {
-		for _, name := range []string{"id", "parser", "date"} {
+		for _, name := range []string{"id", "a", "parser", "date"} {
 			if field.Name == name {
 				if field.Description == "" {
 					t.Errorf("PCAPRow.Schema() missing field.Description for %q", field.Name)
@@ -27,7 +27,7 @@ func TestPCAPRow_Schema(t *testing.T) {
 		}
 		return nil
 	})
-	if count != 3 {
-		t.Errorf("PCAPRow.Schema() missing expected fields: got %d, want 3", count)
+	if count != 4 {
+		t.Errorf("PCAPRow.Schema() missing expected fields: got %d, want 4", count)
 	}
 }
@@ -0,0 +1,82 @@
+package schema_test
+
+import (
+	"fmt"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+
+	"github.com/m-lab/etl/schema"
+	"github.com/m-lab/etl/schema/schematest"
+)
+
+// schemaRow is a row type with a generated schema.
+type schemaRow interface {
+	Schema() (bigquery.Schema, error)
+}
+
+// knownMismatches are the mismatches that cannot be fixed, by row type.
+// PTTest is the legacy traceroute row.  Its json names follow the scamper
+// output, e.g. probe_size, while its bigquery names, e.g. ProbeSize, are
+// the columns of the existing traceroute tables, so renaming either breaks
+// existing tables or readers of the JSON.  Listing the mismatches exactly
+// still fails the test for any new one.
+var knownMismatches = map[string][]string{
+	"*schema.PTTest": {
+		"ProbeSize: in schema, not in JSON",
+		"hop.Links: in schema, not in JSON",
+		"hop.link: in JSON, not in schema",
+		"hop.source.CountryCode: in schema, not in JSON",
+		"hop.source.country_code: in JSON, not in schema",
+		"probe_size: in JSON, not in schema",
+	},
+}
+
+// TestRoundTrip checks that every row type that the parsers write as JSON
+// encodes to fields that match its schema.  The legacy NDTWeb100, NDT5ResultRow
+// and SS rows are not included, since they are not written as JSON.  SSRow,
+// which replaces SS, is.
+func TestRoundTrip(t *testing.T) {
+	rows := []schemaRow{
+		&schema.AnnotationRow{},
+		&schema.DiffRow{},
+		&schema.FileStatsRow{},
+		&schema.HopAnnotation1Row{},
+		&schema.NDT5ResultRowV2{},
+		&schema.NDT7ResultRow{},
+		&schema.PCAPRow{},
+		&schema.PTTest{},
+		&schema.Scamper1Row{},
+		&schema.SSRow{},
+		&schema.SwitchRow{},
+		&schema.TCPInfoRow{},
+		&schema.UUIDMapRow{},
+	}
+	for _, row := range rows {
+		name := fmt.Sprintf("%T", row)
+		t.Run(name, func(t *testing.T) {
+			sch, err := row.Schema()
+			if err != nil {
+				t.Fatal(err)
+			}
+			schematest.Populate(row)
+			problems, err := schematest.Mismatches(row, sch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			known := map[string]bool{}
+			for _, p := range knownMismatches[name] {
+				known[p] = true
+			}
+			for _, p := range problems {
+				if !known[p] {
+					t.Error(p)
+				}
+				delete(known, p)
+			}
+			for p := range known {
+				t.Errorf("%s: known mismatch not found", p)
+			}
+		})
+	}
+}
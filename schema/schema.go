@@ -19,6 +19,21 @@ type ParseInfo struct {
 	Filename   string
 	Priority   int64
 	GitCommit  string
+
+	// Anonymization names the method used to anonymize client IPs, if any.
+	Anonymization string
+
+	// ClockSkew is the estimated offset, in seconds, of the test's own
+	// timestamps from the time its file was archived, when the offset exceeds
+	// what the test duration and archiving delays explain.  Zero otherwise.
+	ClockSkew int64
+
+	// FileModTime and FileSize are the modification time and archived size
+	// of the test file, from its tar header, or zero if they are not known.
+	// They allow rows to be audited against the archive contents, and test
+	// files that were written late to be identified.
+	FileModTime time.Time
+	FileSize    int64
 }
 
 // ServerInfo details various kinds of information about the server.
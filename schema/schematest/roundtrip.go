@@ -0,0 +1,181 @@
+// Package schematest provides helpers for testing that rows serialize to JSON
+// that matches their BigQuery schema.  The parsers write rows as JSON, so a
+// field whose json and bigquery tags disagree is otherwise only discovered
+// when the rows fail to load.
+package schematest
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+)
+
+// maxDepth limits the recursion of Populate, for types that contain
+// themselves.
+const maxDepth = 20
+
+// populateTime is the value given to every time field by Populate.
+var populateTime = time.Date(2022, 7, 1, 12, 30, 0, 0, time.UTC)
+
+// timeValues are the values given to struct types that encode as JSON
+// strings, rather than objects.
+var timeValues = map[reflect.Type]reflect.Value{
+	reflect.TypeOf(time.Time{}):      reflect.ValueOf(populateTime),
+	reflect.TypeOf(civil.Date{}):     reflect.ValueOf(civil.DateOf(populateTime)),
+	reflect.TypeOf(civil.Time{}):     reflect.ValueOf(civil.TimeOf(populateTime)),
+	reflect.TypeOf(civil.DateTime{}): reflect.ValueOf(civil.DateTimeOf(populateTime)),
+}
+
+// Populate sets every exported field reachable from v, which must be a
+// non-nil pointer, to a non-zero value.  Pointers are allocated, and slices
+// and maps are given one element, so that every field appears in the JSON
+// encoding of v.
+func Populate(v interface{}) {
+	populate(reflect.ValueOf(v).Elem(), 0)
+}
+
+func populate(v reflect.Value, depth int) {
+	if depth > maxDepth {
+		return
+	}
+	// Exported fields of embedded unexported structs are settable, though
+	// the embedded struct itself is not.
+	if !v.CanSet() && v.Kind() != reflect.Struct {
+		return
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		populate(v.Elem(), depth+1)
+	case reflect.Struct:
+		if t, ok := timeValues[v.Type()]; ok {
+			if v.CanSet() {
+				v.Set(t)
+			}
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			populate(v.Field(i), depth+1)
+		}
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			// Four bytes is also a valid net.IP.
+			v.SetBytes([]byte{1, 2, 3, 4})
+			return
+		}
+		s := reflect.MakeSlice(v.Type(), 1, 1)
+		populate(s.Index(0), depth+1)
+		v.Set(s)
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			populate(v.Index(i), depth+1)
+		}
+	case reflect.Map:
+		m := reflect.MakeMap(v.Type())
+		k := reflect.New(v.Type().Key()).Elem()
+		populate(k, depth+1)
+		e := reflect.New(v.Type().Elem()).Elem()
+		populate(e, depth+1)
+		m.SetMapIndex(k, e)
+		v.Set(m)
+	case reflect.String:
+		v.SetString("x")
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(1)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(1)
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(1.5)
+	}
+}
+
+// Mismatches encodes row as JSON, and compares the encoding with sch.  It
+// returns a description of each field that is in the JSON but not in sch, or
+// in sch but not in the JSON, or whose shape differs, e.g. a record in sch
+// that is not an object in the JSON.  Field names are compared without case,
+// as BigQuery does.  Populate row first, so that empty and omitted fields do
+// not hide mismatches.
+func Mismatches(row interface{}, sch bigquery.Schema) ([]string, error) {
+	data, err := json.Marshal(row)
+	if err != nil {
+		return nil, err
+	}
+	var obj map[string]interface{}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, err
+	}
+	var problems []string
+	compare("", sch, obj, &problems)
+	sort.Strings(problems)
+	return problems, nil
+}
+
+// compare checks the fields of a JSON object against the fields of a record.
+func compare(prefix string, sch bigquery.Schema, obj map[string]interface{}, problems *[]string) {
+	fields := make(map[string]*bigquery.FieldSchema, len(sch))
+	for _, f := range sch {
+		fields[strings.ToLower(f.Name)] = f
+	}
+	seen := map[string]bool{}
+	for k, v := range obj {
+		f, ok := fields[strings.ToLower(k)]
+		if !ok {
+			*problems = append(*problems, prefix+k+": in JSON, not in schema")
+			continue
+		}
+		seen[strings.ToLower(k)] = true
+		check(prefix+k, f, v, problems)
+	}
+	for k, f := range fields {
+		if !seen[k] {
+			*problems = append(*problems, prefix+f.Name+": in schema, not in JSON")
+		}
+	}
+}
+
+// check checks the shape of a JSON value against its schema field.
+func check(path string, f *bigquery.FieldSchema, v interface{}, problems *[]string) {
+	if v == nil {
+		return
+	}
+	if f.Repeated {
+		arr, ok := v.([]interface{})
+		if !ok {
+			*problems = append(*problems, path+": repeated in schema, not an array in JSON")
+			return
+		}
+		for _, e := range arr {
+			checkValue(path, f, e, problems)
+		}
+		return
+	}
+	checkValue(path, f, v, problems)
+}
+
+// checkValue checks the shape of a single, non-repeated JSON value.
+func checkValue(path string, f *bigquery.FieldSchema, v interface{}, problems *[]string) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if f.Type != bigquery.RecordFieldType {
+			*problems = append(*problems, fmt.Sprintf("%s: object in JSON, %s in schema", path, f.Type))
+			return
+		}
+		compare(path+".", f.Schema, v, problems)
+	case []interface{}:
+		*problems = append(*problems, path+": array in JSON, not repeated in schema")
+	default:
+		if f.Type == bigquery.RecordFieldType {
+			*problems = append(*problems, path+": record in schema, not an object in JSON")
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package schematest_test
+
+import (
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/go-test/deep"
+
+	"github.com/m-lab/etl/schema/schematest"
+)
+
+type inner struct {
+	Name string `json:"name" bigquery:"name"`
+}
+
+type mismatched struct {
+	ID      string    `json:"id" bigquery:"id"`
+	Time    time.Time `json:"time" bigquery:"time"`
+	Renamed int64     `json:"renamed" bigquery:"other_name"`
+	Hidden  string    `json:"-" bigquery:"hidden"`
+	Inner   *inner    `json:"inner" bigquery:"inner"`
+	Values  []int64   `json:"values" bigquery:"values"`
+}
+
+func TestMismatches(t *testing.T) {
+	row := &mismatched{}
+	sch, err := bigquery.InferSchema(row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schematest.Populate(row)
+	if row.Inner == nil || row.Inner.Name == "" || len(row.Values) != 1 || row.Time.IsZero() {
+		t.Fatalf("Populate() = %+v", row)
+	}
+	got, err := schematest.Mismatches(row, sch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"hidden: in schema, not in JSON",
+		"other_name: in schema, not in JSON",
+		"renamed: in JSON, not in schema",
+	}
+	if diff := deep.Equal(got, want); diff != nil {
+		t.Errorf("Mismatches() = %v, diff %v", got, diff)
+	}
+}
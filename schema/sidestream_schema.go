@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
 	"github.com/m-lab/go/cloud/bqx"
 	"github.com/m-lab/uuid-annotator/annotator"
 )
@@ -217,3 +218,53 @@ func (ss *SS) Schema() (bigquery.Schema, error) {
 	rr := bqx.RemoveRequired(sch)
 	return rr, nil
 }
+
+// SSRow is a single sidestream snapshot of a TCP connection, using the
+// 'Standard Column' conventions.  It replaces the legacy SS row.
+type SSRow struct {
+	ID     string          `bigquery:"id"`
+	Parser ParseInfo       `bigquery:"parser"`
+	Date   civil.Date      `bigquery:"date"`
+	A      *SSSummary      `bigquery:"a"`
+	Raw    *Web100LogEntry `bigquery:"raw"`
+}
+
+// SSSummary contains fields summarizing or derived from the raw snapshot.
+type SSSummary struct {
+	TestID     string
+	LogTime    time.Time
+	StartTime  time.Time
+	ServerIP   string
+	ServerPort int64
+	ClientIP   string
+	ClientPort int64
+}
+
+// GetClientIPs returns the client IP of the connection, for annotation.
+func (row *SSRow) GetClientIPs() []string {
+	return []string{row.A.ClientIP}
+}
+
+// GetServerIP returns the server IP of the connection, for annotation.
+func (row *SSRow) GetServerIP() string {
+	return row.A.ServerIP
+}
+
+// GetLogTime returns the time the snapshot was logged.
+func (row *SSRow) GetLogTime() time.Time {
+	return row.A.LogTime
+}
+
+// Schema returns the BigQuery schema for SSRow.
+func (row *SSRow) Schema() (bigquery.Schema, error) {
+	sch, err := bigquery.InferSchema(row)
+	if err != nil {
+		return bigquery.Schema{}, err
+	}
+	docs := FindSchemaDocsFor(row)
+	for _, doc := range docs {
+		bqx.UpdateSchemaDescription(sch, doc)
+	}
+	rr := bqx.RemoveRequired(sch)
+	return rr, err
+}
@@ -2,6 +2,10 @@ package schema
 
 import (
 	"testing"
+
+	"cloud.google.com/go/bigquery"
+
+	"github.com/m-lab/go/cloud/bqx"
 )
 
 func TestSS_Schema(t *testing.T) {
@@ -17,3 +21,29 @@ func TestSS_Schema(t *testing.T) {
 		}
 	})
 }
+
+func TestSSRow_Schema(t *testing.T) {
+	row := &SSRow{}
+	got, err := row.Schema()
+	if err != nil {
+		t.Fatalf("SSRow.Schema() unexpected error = %v", err)
+	}
+	count := 0
+	// The complete schema is large, so verify that field descriptions
+	// are present for select fields by walking the schema and looking for them.
+	bqx.WalkSchema(got, func(prefix []string, field *bigquery.FieldSchema) error {
+		for _, name := range []string{"a", "parser", "raw", "ClientIP", "snap"} {
+			if field.Name == name {
+				if field.Description == "" {
+					t.Errorf("SSRow.Schema() missing field.Description for %q", field.Name)
+				} else {
+					count++
+				}
+			}
+		}
+		return nil
+	})
+	if count != 5 {
+		t.Errorf("SSRow.Schema() missing expected fields; got %d, want 5", count)
+	}
+}
@@ -0,0 +1,103 @@
+package schema
+
+import (
+	"time"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// TableConfig describes how a datatype's tables are partitioned, clustered
+// and retained.  Applying it when tables are created or updated keeps these
+// policies in code rather than in manual console edits.
+type TableConfig struct {
+	// PartitionField is the column that tables are partitioned on.  Empty
+	// means the tables are partitioned by ingestion time.
+	PartitionField string
+	// Expiration is how long each partition is kept.  Zero means partitions
+	// never expire.
+	Expiration time.Duration
+	// Clustering lists the columns that tables are clustered on, if any.
+	Clustering []string
+	// RequirePartitionFilter causes queries that do not filter on the
+	// partition column to be rejected.
+	RequirePartitionFilter bool
+}
+
+// defaultTableConfig is used for standard column datatypes without an entry in
+// tableConfigs.
+var defaultTableConfig = TableConfig{
+	PartitionField: "Date",
+	Clustering:     []string{"id"},
+}
+
+var tableConfigs = map[string]TableConfig{
+	// Legacy datatypes use ingestion time partitions and have no "date" column.
+	"ndt":        {},
+	"sidestream": {},
+	"traceroute": {},
+
+	// The largest tables are too expensive to scan by accident.
+	"pcap": {
+		PartitionField:         "Date",
+		Clustering:             []string{"id"},
+		RequirePartitionFilter: true,
+	},
+	"tcpinfo": {
+		PartitionField:         "Date",
+		Clustering:             []string{"id"},
+		RequirePartitionFilter: true,
+	},
+
+	"uuid_map": {
+		PartitionField: "Date",
+		Clustering:     []string{"id", "datatype"},
+	},
+	// File stats are only needed to tune the workers, so recent data suffices.
+	"file_stats": {
+		PartitionField: "Date",
+		Expiration:     90 * 24 * time.Hour,
+		Clustering:     []string{"datatype"},
+	},
+	// Parser diffs are only needed while a parser change is being validated.
+	"parser_diff": {
+		PartitionField: "Date",
+		Expiration:     90 * 24 * time.Hour,
+		Clustering:     []string{"id"},
+	},
+}
+
+// TableConfigFor returns the table configuration for the named datatype.
+func TableConfigFor(datatype string) TableConfig {
+	if c, ok := tableConfigs[datatype]; ok {
+		return c
+	}
+	return defaultTableConfig
+}
+
+// TimePartitioning returns the partitioning to create tables with.
+func (c TableConfig) TimePartitioning() *bigquery.TimePartitioning {
+	return &bigquery.TimePartitioning{
+		Field:      c.PartitionField,
+		Expiration: c.Expiration,
+	}
+}
+
+// ClusteringSpec returns the clustering to create tables with, or nil if the
+// tables are not clustered.
+func (c TableConfig) ClusteringSpec() *bigquery.Clustering {
+	if len(c.Clustering) == 0 {
+		return nil
+	}
+	return &bigquery.Clustering{Fields: c.Clustering}
+}
+
+// Update returns the changes that bring an existing table in line with the
+// config.  An existing partition expiration is removed when Expiration is zero.
+// The partition field itself cannot be changed once a table exists.
+func (c TableConfig) Update() bigquery.TableMetadataToUpdate {
+	return bigquery.TableMetadataToUpdate{
+		TimePartitioning:       c.TimePartitioning(),
+		Clustering:             c.ClusteringSpec(),
+		RequirePartitionFilter: c.RequirePartitionFilter,
+	}
+}
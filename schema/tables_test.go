@@ -0,0 +1,59 @@
+package schema
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+)
+
+func TestTableConfigFor(t *testing.T) {
+	tests := []struct {
+		datatype string
+		want     TableConfig
+	}{
+		{
+			datatype: "ndt7",
+			want:     TableConfig{PartitionField: "Date", Clustering: []string{"id"}},
+		},
+		{
+			datatype: "traceroute",
+			want:     TableConfig{},
+		},
+		{
+			datatype: "tcpinfo",
+			want:     TableConfig{PartitionField: "Date", Clustering: []string{"id"}, RequirePartitionFilter: true},
+		},
+		{
+			datatype: "parser_diff",
+			want:     TableConfig{PartitionField: "Date", Expiration: 90 * 24 * time.Hour, Clustering: []string{"id"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.datatype, func(t *testing.T) {
+			if got := TableConfigFor(tt.datatype); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("TableConfigFor() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTableConfig_Update(t *testing.T) {
+	c := TableConfigFor("parser_diff")
+	got := c.Update()
+	if got.TimePartitioning.Field != "Date" || got.TimePartitioning.Expiration != 90*24*time.Hour {
+		t.Errorf("Update() TimePartitioning = %+v", got.TimePartitioning)
+	}
+	if !reflect.DeepEqual(got.Clustering, &bigquery.Clustering{Fields: []string{"id"}}) {
+		t.Errorf("Update() Clustering = %+v", got.Clustering)
+	}
+	if got.RequirePartitionFilter != false {
+		t.Errorf("Update() RequirePartitionFilter = %v, want false", got.RequirePartitionFilter)
+	}
+
+	legacy := TableConfigFor("ndt").Update()
+	if legacy.Clustering != nil {
+		t.Errorf("Update() Clustering = %+v, want nil", legacy.Clustering)
+	}
+}
@@ -1,6 +1,8 @@
 package schema
 
 import (
+	"time"
+
 	"cloud.google.com/go/bigquery"
 	"cloud.google.com/go/civil"
 
@@ -13,6 +15,21 @@ import (
 type TCPInfoSummary struct {
 	SockID        inetdiag.SockID
 	FinalSnapshot snapshot.Snapshot
+	Delta         *TCPInfoDelta
+	IdleSnapshots int64 // Snapshots dropped because they were idle.
+}
+
+// TCPInfoDelta summarizes the change between the first and final snapshots
+// with TCPInfo, so that basic performance metrics can be queried without
+// unnesting the raw snapshots.
+type TCPInfoDelta struct {
+	StartTime     time.Time
+	ElapsedTime   int64 // Microseconds.
+	BytesAcked    int64
+	BytesReceived int64
+	BytesRetrans  int64
+	TotalRetrans  int64
+	MinRTT        int64 // Microseconds.
 }
 
 // TCPInfoRow defines the BQ schema using 'Standard Columns' conventions for
@@ -0,0 +1,35 @@
+package schema
+
+import (
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+	"github.com/m-lab/go/cloud/bqx"
+)
+
+// UUIDMapRow defines the BQ schema for the lookup table mapping test
+// filenames to the UUIDs they contain.  Joining on this table allows
+// datatypes that name files differently (e.g. tcpinfo, ndt7, annotation) to be
+// joined by UUID without string manipulation in SQL, and shows which
+// datatypes have data for a test without scanning every table.
+type UUIDMapRow struct {
+	UUID       string     `bigquery:"id" json:"id"`
+	Datatype   string     `bigquery:"datatype" json:"datatype"`
+	Filename   string     `bigquery:"filename" json:"filename"`
+	ArchiveURL string     `bigquery:"archive_url" json:"archive_url"`
+	Date       civil.Date `bigquery:"date" json:"date"`
+	RowID      string     `bigquery:"row_id" json:"row_id"`
+}
+
+// Schema returns the BigQuery schema for UUIDMapRow.
+func (row *UUIDMapRow) Schema() (bigquery.Schema, error) {
+	sch, err := bigquery.InferSchema(row)
+	if err != nil {
+		return bigquery.Schema{}, err
+	}
+	docs := FindSchemaDocsFor(row)
+	for _, doc := range docs {
+		bqx.UpdateSchemaDescription(sch, doc)
+	}
+	rr := bqx.RemoveRequired(sch)
+	return rr, err
+}
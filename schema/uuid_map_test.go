@@ -0,0 +1,30 @@
+package schema
+
+import (
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+
+	"github.com/m-lab/go/cloud/bqx"
+)
+
+func TestUUIDMapRow_Schema(t *testing.T) {
+	row := &UUIDMapRow{}
+	got, err := row.Schema()
+	if err != nil {
+		t.Fatalf("UUIDMapRow.Schema() unexpected error = %v", err)
+	}
+
+	count := 0
+	bqx.WalkSchema(got, func(prefix []string, field *bigquery.FieldSchema) error {
+		if field.Description == "" {
+			t.Errorf("UUIDMapRow.Schema() missing field.Description for %q", field.Name)
+		} else {
+			count++
+		}
+		return nil
+	})
+	if count != 6 {
+		t.Errorf("UUIDMapRow.Schema() missing expected fields; got %d, want 6", count)
+	}
+}
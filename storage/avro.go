@@ -0,0 +1,461 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+	"github.com/googleapis/google-cloud-go-testing/storage/stiface"
+	"github.com/linkedin/goavro/v2"
+
+	"github.com/m-lab/etl/etl"
+	"github.com/m-lab/etl/factory"
+	"github.com/m-lab/etl/metrics"
+	"github.com/m-lab/etl/row"
+)
+
+// AvroExt is the extension of the Avro objects written by AvroSinks.
+const AvroExt = ".avro"
+
+// ErrInvalidAvroRow is returned for rows that do not match the Avro schema.
+var ErrInvalidAvroRow = errors.New("row does not match schema")
+
+// PartitionLoader loads Avro objects into a date partition of a table.  It is
+// used once per date, after all tasks of the date have completed, as by
+// cmd/load-avro, and never by the sinks of individual tasks.
+type PartitionLoader interface {
+	// Load replaces the date's partition of the table with the rows of the
+	// objects matching uri, which may contain a wildcard.
+	Load(ctx context.Context, uri string, dst etl.Destination, date civil.Date) error
+}
+
+// bqLoader implements PartitionLoader with BigQuery load jobs.
+type bqLoader struct {
+	client *bigquery.Client
+}
+
+// NewPartitionLoader returns a PartitionLoader that runs BigQuery load jobs
+// with the client.  The jobs run in the client's Location.
+func NewPartitionLoader(client *bigquery.Client) PartitionLoader {
+	return &bqLoader{client: client}
+}
+
+// Load implements PartitionLoader.  The table must exist, and the load job
+// fails if the Avro schema does not match it.
+func (l *bqLoader) Load(ctx context.Context, uri string, dst etl.Destination, date civil.Date) error {
+	ref := bigquery.NewGCSReference(uri)
+	ref.SourceFormat = bigquery.Avro
+	table := dst.Table + etl.PartitionSuffix.Suffix(date)
+	loader := l.client.DatasetInProject(dst.Project, dst.Dataset).Table(table).LoaderFrom(ref)
+	loader.UseAvroLogicalTypes = true
+	loader.WriteDisposition = bigquery.WriteTruncate
+	loader.CreateDisposition = bigquery.CreateNever
+
+	job, err := loader.Run(ctx)
+	if err != nil {
+		return etl.ErrBigQuery.Errorf("loading %s: %w", table, err)
+	}
+	status, err := job.Wait(ctx)
+	if err != nil {
+		return etl.ErrBigQuery.Errorf("loading %s: %w", table, err)
+	}
+	if err := status.Err(); err != nil {
+		return etl.ErrBigQuery.Errorf("loading %s: %w", table, err)
+	}
+	return nil
+}
+
+// avroTypes maps the BigQuery types supported by AvroSink, other than
+// RECORD, to their Avro types.
+var avroTypes = map[bigquery.FieldType]interface{}{
+	bigquery.StringFieldType:    "string",
+	bigquery.GeographyFieldType: "string",
+	bigquery.BytesFieldType:     "bytes",
+	bigquery.IntegerFieldType:   "long",
+	bigquery.FloatFieldType:     "double",
+	bigquery.BooleanFieldType:   "boolean",
+	bigquery.TimestampFieldType: map[string]interface{}{"type": "long", "logicalType": "timestamp-micros"},
+	bigquery.DateFieldType:      map[string]interface{}{"type": "int", "logicalType": "date"},
+}
+
+// avroTypeName returns the name of the Avro type of a BigQuery field, as
+// used to select the member of a union.  Record types are named for their
+// path in the schema.
+func avroTypeName(f *bigquery.FieldSchema, scope string) string {
+	switch t := avroTypes[f.Type].(type) {
+	case string:
+		return t
+	case map[string]interface{}:
+		return t["type"].(string) + "." + t["logicalType"].(string)
+	}
+	return scope + "__" + f.Name
+}
+
+// avroType returns the Avro type of values of a BigQuery field, ignoring its
+// mode.
+func avroType(f *bigquery.FieldSchema, scope string) (interface{}, error) {
+	if t, ok := avroTypes[f.Type]; ok {
+		return t, nil
+	}
+	if f.Type != bigquery.RecordFieldType {
+		return nil, fmt.Errorf("%s: unsupported type %s", f.Name, f.Type)
+	}
+	name := avroTypeName(f, scope)
+	fields, err := avroFields(f.Schema, name)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"type": "record", "name": name, "fields": fields}, nil
+}
+
+// avroFields returns the Avro fields of a BigQuery schema.  Repeated fields
+// are arrays, and fields that are neither required nor repeated are unions
+// with null.
+func avroFields(schema bigquery.Schema, scope string) ([]interface{}, error) {
+	if len(schema) == 0 {
+		return nil, fmt.Errorf("%s: no fields", scope)
+	}
+	fields := make([]interface{}, 0, len(schema))
+	for _, f := range schema {
+		t, err := avroType(f, scope)
+		if err != nil {
+			return nil, err
+		}
+		field := map[string]interface{}{"name": f.Name}
+		switch {
+		case f.Repeated:
+			field["type"] = map[string]interface{}{"type": "array", "items": t}
+			field["default"] = []interface{}{}
+		case f.Required:
+			field["type"] = t
+		default:
+			field["type"] = []interface{}{"null", t}
+			field["default"] = nil
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// avroSchema returns the Avro schema of rows of the BigQuery schema.
+func avroSchema(schema bigquery.Schema) (string, error) {
+	fields, err := avroFields(schema, "root")
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(map[string]interface{}{"type": "record", "name": "root", "fields": fields})
+	return string(b), err
+}
+
+// avroRecord converts the row to the native Avro record of the schema, from
+// the row's JSON encoding, as written by the GCS sinks.
+func avroRecord(schema bigquery.Schema, r interface{}) (map[string]interface{}, error) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v map[string]interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return avroFieldValues(schema, v, "root")
+}
+
+// avroFieldValues converts the JSON object v to a native Avro record.  Keys
+// are matched to the schema ignoring case, like BigQuery column names.  Keys
+// with non-null values that are not in the schema are an error, so that no
+// data is lost.
+func avroFieldValues(schema bigquery.Schema, v map[string]interface{}, scope string) (map[string]interface{}, error) {
+	values := make(map[string]interface{}, len(v))
+	for k, x := range v {
+		if x != nil {
+			values[strings.ToLower(k)] = x
+		}
+	}
+	record := make(map[string]interface{}, len(schema))
+	for _, f := range schema {
+		key := strings.ToLower(f.Name)
+		x, ok := values[key]
+		delete(values, key)
+		switch {
+		case f.Repeated:
+			if !ok {
+				record[f.Name] = []interface{}{}
+				continue
+			}
+			xs, ok := x.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s: %T is not an array", f.Name, x)
+			}
+			items := make([]interface{}, len(xs))
+			for i := range xs {
+				item, err := avroValue(f, xs[i], scope)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", f.Name, err)
+				}
+				items[i] = item
+			}
+			record[f.Name] = items
+		case !ok && f.Required:
+			return nil, fmt.Errorf("%s: required field is missing", f.Name)
+		case !ok:
+			record[f.Name] = nil
+		default:
+			y, err := avroValue(f, x, scope)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", f.Name, err)
+			}
+			if !f.Required {
+				y = goavro.Union(avroTypeName(f, scope), y)
+			}
+			record[f.Name] = y
+		}
+	}
+	if len(values) > 0 {
+		unknown := make([]string, 0, len(values))
+		for k := range values {
+			unknown = append(unknown, k)
+		}
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("no such fields: %s", strings.Join(unknown, ", "))
+	}
+	return record, nil
+}
+
+// avroValue converts the JSON value x of the field f to its native Avro
+// value.
+func avroValue(f *bigquery.FieldSchema, x interface{}, scope string) (interface{}, error) {
+	switch f.Type {
+	case bigquery.StringFieldType, bigquery.GeographyFieldType:
+		if s, ok := x.(string); ok {
+			return s, nil
+		}
+	case bigquery.BytesFieldType:
+		if s, ok := x.(string); ok {
+			return base64.StdEncoding.DecodeString(s)
+		}
+	case bigquery.IntegerFieldType:
+		if n, ok := x.(json.Number); ok {
+			return n.Int64()
+		}
+	case bigquery.FloatFieldType:
+		if n, ok := x.(json.Number); ok {
+			return n.Float64()
+		}
+	case bigquery.BooleanFieldType:
+		if b, ok := x.(bool); ok {
+			return b, nil
+		}
+	case bigquery.TimestampFieldType:
+		if s, ok := x.(string); ok {
+			t, err := time.Parse(time.RFC3339Nano, s)
+			return t.UnixMicro(), err
+		}
+	case bigquery.DateFieldType:
+		if s, ok := x.(string); ok {
+			d, err := civil.ParseDate(s)
+			return int32(etl.EpochDays(d)), err
+		}
+	case bigquery.RecordFieldType:
+		if v, ok := x.(map[string]interface{}); ok {
+			return avroFieldValues(f.Schema, v, scope+"__"+f.Name)
+		}
+	}
+	return nil, fmt.Errorf("%T is not a valid %s", x, f.Type)
+}
+
+// errWriter records the first error of the underlying writer, so that write
+// errors can be told from encoding errors.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (e *errWriter) Write(b []byte) (int, error) {
+	n, err := e.w.Write(b)
+	if err != nil && e.err == nil {
+		e.err = err
+	}
+	return n, err
+}
+
+// AvroSink implements row.Sink, writing the rows of a task to a single Avro
+// object container file in GCS, with deflate compressed blocks.  As for a
+// ParquetSink, the schema is derived from the BigQuery schema of the first
+// row committed, and no object is written for a task without rows.
+type AvroSink struct {
+	ctx      context.Context
+	cancel   context.CancelFunc // Cancels w's context, abandoning the upload.
+	o        stiface.ObjectHandle
+	bucket   string
+	path     string
+	manifest *Manifest // Optional, records the object.
+
+	lock    sync.Mutex
+	w       stiface.Writer // nil until the first row is committed.
+	ew      *errWriter
+	schema  bigquery.Schema
+	ocf     *goavro.OCFWriter
+	rows    int
+	err     error // Write error, after which the upload is abandoned.
+	retries int64 // Failed GCS requests, updated atomically by the transport.
+}
+
+// NewAvroSink creates an AvroSink that writes the object at path in the
+// bucket.
+func NewAvroSink(ctx context.Context, client stiface.Client, bucket, path string) *AvroSink {
+	s := &AvroSink{o: client.Bucket(bucket).Object(path), bucket: bucket, path: path}
+	s.ctx, s.cancel = context.WithCancel(withRetryCounter(ctx, &s.retries))
+	return s
+}
+
+// open starts the upload, for rows of the schema of r.
+// Caller must hold the lock.
+func (s *AvroSink) open(r interface{}) error {
+	schema, err := rowSchema(r)
+	if err != nil {
+		return etl.ErrValidation.Errorf("%w", err)
+	}
+	avro, err := avroSchema(schema)
+	if err != nil {
+		return etl.ErrValidation.Errorf("%w", err)
+	}
+	w := s.o.NewWriter(s.ctx)
+	if DefaultWriterOptions.ChunkSize > 0 {
+		w.SetChunkSize(DefaultWriterOptions.ChunkSize)
+	}
+	ew := &errWriter{w: w}
+	ocf, err := goavro.NewOCFWriter(goavro.OCFConfig{
+		W: ew, Schema: avro, CompressionName: goavro.CompressionDeflateLabel})
+	if err != nil {
+		// Nothing is uploaded until the writer is flushed or closed, so w
+		// is simply dropped.
+		return etl.ErrValidation.Errorf("%w", err)
+	}
+	s.w, s.ew, s.schema, s.ocf = w, ew, schema, ocf
+	return nil
+}
+
+// Commit implements row.Sink.  Rows that cannot be encoded are dropped, and
+// the first such error is returned with the number of rows written.
+func (s *AvroSink) Commit(rows []interface{}, label string) (int, error) {
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.err != nil {
+		return 0, &CommitError{Err: etl.ErrGCS.Errorf("%v", s.err), retries: int(atomic.LoadInt64(&s.retries))}
+	}
+	if s.w == nil {
+		if err := s.open(rows[0]); err != nil {
+			metrics.BackendFailureCount.WithLabelValues(label, "schema error").Inc()
+			return 0, err
+		}
+	}
+	records := make([]interface{}, 0, len(rows))
+	var encodeErr error
+	for _, r := range rows {
+		record, err := avroRecord(s.schema, r)
+		if err != nil {
+			metrics.BackendFailureCount.WithLabelValues(label, "encoding error").Inc()
+			if encodeErr == nil {
+				encodeErr = etl.ErrValidation.Errorf("encoding row: %w: %v", ErrInvalidAvroRow, err)
+			}
+			continue
+		}
+		records = append(records, record)
+	}
+	if len(records) == 0 {
+		return 0, encodeErr
+	}
+	// The OCFWriter encodes a whole block before writing it, so an encoding
+	// error writes nothing.
+	if err := s.ocf.Append(records); err != nil {
+		if s.ew.err == nil {
+			metrics.BackendFailureCount.WithLabelValues(label, "encoding error").Inc()
+			return 0, etl.ErrValidation.Errorf("encoding rows: %w: %v", ErrInvalidAvroRow, err)
+		}
+		metrics.BackendFailureCount.WithLabelValues(label, "other error").Inc()
+		log.Println(err, s.bucket, s.path)
+		s.err = err
+		return 0, &CommitError{Err: etl.ErrGCS.Errorf("writing %s: %w", s.path, err), retries: int(atomic.LoadInt64(&s.retries))}
+	}
+	s.rows += len(records)
+	return len(records), encodeErr
+}
+
+// Committed implements row.Counter.
+func (s *AvroSink) Committed() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.rows
+}
+
+// Close closes the object.  If any write failed, the upload is abandoned, so that no
+// truncated object is published.
+func (s *AvroSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	defer s.cancel()
+	if s.w == nil {
+		return nil
+	}
+	if s.err != nil {
+		log.Println(s.err, "abandoning", s.bucket, s.path)
+		// Canceling the context before Close abandons the upload.
+		s.cancel()
+		s.w.Close()
+		return etl.ErrGCS.Errorf("writing %s: %w", s.path, s.err)
+	}
+	if err := s.w.Close(); err != nil {
+		return etl.ErrGCS.Errorf("writing %s: %w", s.path, err)
+	}
+	if s.manifest != nil {
+		var size int64
+		if a := s.w.Attrs(); a != nil {
+			size = a.Size
+		}
+		s.manifest.add(ManifestObject{
+			Name: "gs://" + s.bucket + "/" + s.path, Rows: s.rows, Bytes: size})
+	}
+	return nil
+}
+
+// AvroSinkFactory implements factory.SinkFactory, producing AvroSinks that
+// write one Avro object per task.
+type AvroSinkFactory struct {
+	client       stiface.Client
+	outputBucket string
+}
+
+// NewAvroSinkFactory returns a SinkFactory that writes the rows of each task
+// to an Avro object in the output bucket, named for the archive.  If
+// DefaultWriterOptions.Manifest is set, a manifest is also written for each
+// task.
+func NewAvroSinkFactory(client stiface.Client, outputBucket string) factory.SinkFactory {
+	return &AvroSinkFactory{client: client, outputBucket: outputBucket}
+}
+
+// Get implements factory.SinkFactory.
+func (sf *AvroSinkFactory) Get(ctx context.Context, dp etl.DataPath) (row.Sink, etl.ProcessingError) {
+	m := newManifest(dp)
+	s := NewAvroSink(ctx, sf.client, sf.outputBucket, path.Join(dp.Bucket, dp.Path+AvroExt))
+	s.manifest = m
+	return withManifest(s, m, sf.client, sf.outputBucket, dp), nil
+}
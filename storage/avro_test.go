@@ -0,0 +1,130 @@
+package storage_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+	fgs "github.com/fsouza/fake-gcs-server/fakestorage"
+	"github.com/go-test/deep"
+	"github.com/googleapis/google-cloud-go-testing/storage/stiface"
+	"github.com/linkedin/goavro/v2"
+
+	"github.com/m-lab/etl/etl"
+	"github.com/m-lab/etl/row"
+	"github.com/m-lab/etl/storage"
+)
+
+type avroHop struct {
+	Addr string   `json:"addr"`
+	RTT  *float64 `json:"rtt"`
+}
+
+type avroRow struct {
+	ID   string     `json:"id"`
+	N    *int64     `json:"n"`
+	Date civil.Date `json:"date"`
+	Time time.Time  `json:"time"`
+	Tags []string   `json:"tags"`
+	Hops []avroHop  `json:"hops"`
+}
+
+func (r *avroRow) Schema() (bigquery.Schema, error) {
+	return bigquery.Schema{
+		{Name: "id", Type: bigquery.StringFieldType, Required: true},
+		{Name: "n", Type: bigquery.IntegerFieldType},
+		{Name: "date", Type: bigquery.DateFieldType},
+		{Name: "time", Type: bigquery.TimestampFieldType},
+		{Name: "tags", Type: bigquery.StringFieldType, Repeated: true},
+		{Name: "hops", Type: bigquery.RecordFieldType, Repeated: true, Schema: bigquery.Schema{
+			{Name: "addr", Type: bigquery.StringFieldType},
+			{Name: "rtt", Type: bigquery.FloatFieldType},
+		}},
+	}, nil
+}
+
+func TestAvroSinkFactory(t *testing.T) {
+	server := fgs.NewServer([]fgs.Object{})
+	defer server.Stop()
+	server.CreateBucket("output")
+	sf := storage.NewAvroSinkFactory(stiface.AdaptClient(server.Client()), "output")
+
+	dp, err := etl.ValidateTestPath(
+		"gs://archive/ndt/ndt7/2022/07/01/20220701T000000.000000Z-ndt7-mlab1-foo01-ndt.tgz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink, pErr := sf.Get(context.Background(), dp)
+	if pErr != nil {
+		t.Fatal(pErr)
+	}
+	n, rtt := int64(7), 1.5
+	date := civil.Date{Year: 2022, Month: 7, Day: 1}
+	ts := time.Date(2022, 7, 1, 12, 0, 0, 123456000, time.UTC)
+	rows := []interface{}{
+		avroRow{ID: "a", N: &n, Date: date, Time: ts, Tags: []string{"x", "y"},
+			Hops: []avroHop{{Addr: "h1", RTT: &rtt}, {Addr: "h2"}}},
+		map[string]interface{}{"id": "b"},
+		map[string]interface{}{"id": "c", "unknown": 1},
+	}
+	if n, err := sink.Commit(rows, "fake-label"); n != 2 || !errors.Is(err, etl.ErrValidation) {
+		t.Errorf("Commit() = %d, %v, want 2, %v", n, err, etl.ErrValidation)
+	}
+	if n := sink.(row.Counter).Committed(); n != 2 {
+		t.Errorf("Committed() = %d, want 2", n)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	name := "archive/" + dp.Path + storage.AvroExt
+	o, err := server.GetObject("output", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := goavro.NewOCFReader(bytes.NewReader(o.Content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []interface{}
+	for r.Scan() {
+		record, err := r.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, record)
+	}
+	want := []interface{}{
+		map[string]interface{}{
+			"id":   "a",
+			"n":    map[string]interface{}{"long": int64(7)},
+			"date": map[string]interface{}{"int.date": time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC)},
+			"time": map[string]interface{}{"long.timestamp-micros": ts},
+			"tags": []interface{}{"x", "y"},
+			"hops": []interface{}{
+				map[string]interface{}{"addr": map[string]interface{}{"string": "h1"}, "rtt": map[string]interface{}{"double": 1.5}},
+				map[string]interface{}{"addr": map[string]interface{}{"string": "h2"}, "rtt": nil},
+			},
+		},
+		map[string]interface{}{
+			"id": "b", "n": nil, "date": nil, "time": nil, "tags": []interface{}{}, "hops": []interface{}{},
+		},
+	}
+	if diff := deep.Equal(got, want); diff != nil {
+		t.Errorf("records differ: %v", diff)
+	}
+
+	// A task with no rows writes no object.
+	dp.Path = "ndt/ndt7/2022/07/01/20220701T000001.000000Z-ndt7-mlab1-foo01-ndt.tgz"
+	sink, _ = sf.Get(context.Background(), dp)
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.GetObject("output", "archive/"+dp.Path+storage.AvroExt); err == nil {
+		t.Error("empty sink wrote an object")
+	}
+}
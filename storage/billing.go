@@ -0,0 +1,30 @@
+package storage
+
+import (
+	"github.com/googleapis/google-cloud-go-testing/storage/stiface"
+)
+
+// BillingProject, if set, is billed for all requests made through clients
+// from GetStorageClient.  This is required to read requester-pays buckets.
+var BillingProject string
+
+// billedClient is a Client whose bucket handles bill requests to a project.
+type billedClient struct {
+	stiface.Client
+	project string
+}
+
+// Bucket returns a handle for the named bucket, billed to the client project.
+func (c *billedClient) Bucket(name string) stiface.BucketHandle {
+	return c.Client.Bucket(name).UserProject(c.project)
+}
+
+// WithBillingProject returns a Client whose bucket handles bill all requests
+// to project, as required for requester-pays buckets.  If project is empty,
+// the client is returned unchanged.
+func WithBillingProject(client stiface.Client, project string) stiface.Client {
+	if project == "" || client == nil {
+		return client
+	}
+	return &billedClient{Client: client, project: project}
+}
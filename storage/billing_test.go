@@ -0,0 +1,42 @@
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/googleapis/google-cloud-go-testing/storage/stiface"
+
+	"github.com/m-lab/etl/storage"
+)
+
+// fakeClient records the project billed for each bucket handle.
+type fakeClient struct {
+	stiface.Client
+	billed map[string]string
+}
+
+func (c *fakeClient) Bucket(name string) stiface.BucketHandle {
+	return &fakeBucket{client: c, name: name}
+}
+
+type fakeBucket struct {
+	stiface.BucketHandle
+	client *fakeClient
+	name   string
+}
+
+func (b *fakeBucket) UserProject(project string) stiface.BucketHandle {
+	b.client.billed[b.name] = project
+	return b
+}
+
+func TestWithBillingProject(t *testing.T) {
+	c := &fakeClient{billed: map[string]string{}}
+	if got := storage.WithBillingProject(c, ""); got != stiface.Client(c) {
+		t.Error("WithBillingProject() should not wrap client without a project")
+	}
+
+	storage.WithBillingProject(c, "mlab-sandbox").Bucket("requester-pays")
+	if got := c.billed["requester-pays"]; got != "mlab-sandbox" {
+		t.Errorf("Bucket() billed %q, want mlab-sandbox", got)
+	}
+}
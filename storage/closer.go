@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"strings"
+)
+
+// joinError aggregates several errors.
+type joinError struct {
+	errs []error
+}
+
+// Error returns the messages of all errors, one per line.
+func (e *joinError) Error() string {
+	msgs := make([]string, len(e.errs))
+	for i, err := range e.errs {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Unwrap returns all errors, for errors.Is and errors.As from Go 1.20.
+func (e *joinError) Unwrap() []error {
+	return e.errs
+}
+
+// Is reports whether any of the errors matches target, since errors.Is does
+// not use a multi-error Unwrap before Go 1.20.
+func (e *joinError) Is(target error) bool {
+	for _, err := range e.errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first of the errors that matches target, as errors.As does,
+// since errors.As does not use a multi-error Unwrap before Go 1.20.
+func (e *joinError) As(target interface{}) bool {
+	for _, err := range e.errs {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// JoinErrors returns an error that aggregates the non-nil errs, or nil if
+// there are none.  A single error is returned unchanged.  This is equivalent
+// to errors.Join, which is not available in Go 1.18.
+func JoinErrors(errs ...error) error {
+	var nonNil []error
+	for _, err := range errs {
+		if err != nil {
+			nonNil = append(nonNil, err)
+		}
+	}
+	switch len(nonNil) {
+	case 0:
+		return nil
+	case 1:
+		return nonNil[0]
+	}
+	return &joinError{errs: nonNil}
+}
+
+// MultiCloser closes each non-nil element in order, even if earlier elements
+// fail, and returns all of their errors.
+type MultiCloser []io.Closer
+
+// Close implements io.Closer.
+func (mc MultiCloser) Close() error {
+	var errs []error
+	for _, c := range mc {
+		if c != nil {
+			errs = append(errs, c.Close())
+		}
+	}
+	return JoinErrors(errs...)
+}
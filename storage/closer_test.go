@@ -0,0 +1,60 @@
+package storage_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/m-lab/etl/storage"
+)
+
+type fakeCloser struct {
+	err    error
+	closed bool
+}
+
+func (c *fakeCloser) Close() error {
+	c.closed = true
+	return c.err
+}
+
+func TestJoinErrors(t *testing.T) {
+	errA := errors.New("a")
+	errB := errors.New("b")
+	if err := storage.JoinErrors(nil, nil); err != nil {
+		t.Errorf("JoinErrors(nil, nil) = %v, want nil", err)
+	}
+	if err := storage.JoinErrors(nil, errA); err != errA {
+		t.Errorf("JoinErrors(nil, a) = %v, want a", err)
+	}
+	err := storage.JoinErrors(errA, nil, errB)
+	if err == nil || err.Error() != "a\nb" {
+		t.Fatalf("JoinErrors(a, nil, b) = %v, want a\\nb", err)
+	}
+	if !errors.Is(err, errA) || !errors.Is(err, errB) {
+		t.Errorf("JoinErrors(a, nil, b) should match both errors")
+	}
+	var pathErr *os.PathError
+	err = storage.JoinErrors(errA, fmt.Errorf("wrapped: %w", &os.PathError{Op: "open"}))
+	if !errors.As(err, &pathErr) || pathErr.Op != "open" {
+		t.Errorf("JoinErrors(a, path error) should match *os.PathError")
+	}
+}
+
+func TestMultiCloser(t *testing.T) {
+	first := &fakeCloser{err: io.ErrClosedPipe}
+	second := &fakeCloser{}
+	third := &fakeCloser{err: io.ErrUnexpectedEOF}
+	err := storage.MultiCloser{first, nil, second, third}.Close()
+	if !first.closed || !second.closed || !third.closed {
+		t.Error("Close() should close every element despite errors")
+	}
+	if !errors.Is(err, io.ErrClosedPipe) || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Close() = %v, want both errors", err)
+	}
+	if err := (storage.MultiCloser{second}).Close(); err != nil {
+		t.Errorf("Close() = %v, want nil", err)
+	}
+}
@@ -0,0 +1,293 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/m-lab/etl/row"
+	"github.com/m-lab/etl/schema"
+)
+
+// ErrNoRowID is returned when rows committed to a Comparator have no ID.
+var ErrNoRowID = errors.New("row has no ID field")
+
+// DefaultIgnoredFields are the fields, as dotted JSON paths, that Comparators
+// ignore by default.  These are parser metadata that differ between any two
+// runs or builds, rather than differences in the parsed data.
+var DefaultIgnoredFields = []string{
+	"parser.Time", "parser.Version", "parser.GitCommit",
+	"Parser.Time", "Parser.Version", "Parser.GitCommit",
+	"ParseInfo.ParseTime", "ParseInfo.ParserVersion",
+}
+
+// Sides of a comparison.
+const (
+	baseline = iota
+	candidate
+)
+
+// flatten adds the leaf values of a decoded JSON value to fields, keyed by
+// dotted path.  Arrays are treated as leaves, so that a change in length is
+// reported once rather than for every element.
+func flatten(prefix string, v interface{}, fields map[string]interface{}) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		fields[prefix] = v
+		return
+	}
+	for k, child := range m {
+		if prefix != "" {
+			k = prefix + "." + k
+		}
+		flatten(k, child, fields)
+	}
+}
+
+// fieldsOf returns the leaf values of the row's JSON encoding, as written by
+// the GCS sinks, keyed by dotted path.
+func fieldsOf(r interface{}) (map[string]interface{}, error) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	fields := map[string]interface{}{}
+	flatten("", v, fields)
+	return fields, nil
+}
+
+// encode returns the JSON encoding of a decoded JSON value, or "" if absent.
+func encode(v interface{}, ok bool) string {
+	if !ok {
+		return ""
+	}
+	b, _ := json.Marshal(v) // Values came from json.Unmarshal, so cannot fail.
+	return string(b)
+}
+
+// diff returns a DiffRow for each field that differs between the rows, except
+// for ignored fields.
+func diff(id, label string, base, cand interface{}, ignored map[string]bool) ([]interface{}, error) {
+	bf, err := fieldsOf(base)
+	if err != nil {
+		return nil, err
+	}
+	cf, err := fieldsOf(cand)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(bf))
+	for k := range bf {
+		names = append(names, k)
+	}
+	for k := range cf {
+		if _, ok := bf[k]; !ok {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+
+	date, _ := rowDate(base)
+	diffs := []interface{}{}
+	for _, k := range names {
+		if ignored[k] {
+			continue
+		}
+		b, bok := bf[k]
+		c, cok := cf[k]
+		if bok == cok && reflect.DeepEqual(b, c) {
+			continue
+		}
+		diffs = append(diffs, &schema.DiffRow{
+			ID: id, Date: date, Table: label, Kind: schema.DiffChanged, Field: k,
+			Baseline: encode(b, bok), Candidate: encode(c, cok),
+		})
+	}
+	return diffs, nil
+}
+
+// pendingRow is a row waiting for the row with the same ID from the other side.
+type pendingRow struct {
+	row   interface{}
+	label string
+}
+
+// Comparator compares the rows committed by a baseline parser and a candidate
+// parser, e.g. the production parser and a canary, and commits the field-level
+// differences between rows with the same ID to a diff Sink, as DiffRows.  Rows
+// are matched regardless of the order in which either side commits them, and
+// rows that are never matched are reported when both sides are closed.
+//
+// Only unmatched rows are retained, so duplicate IDs are detected only while
+// the first row is waiting for its match.  A duplicate of a row already
+// compared is reported as missing from the other side.
+type Comparator struct {
+	lock    sync.Mutex
+	diffs   row.Sink
+	pending [2]map[string]pendingRow
+	ignored map[string]bool // Fields not compared.
+	closed  [2]bool
+	count   int // number of DiffRows committed.
+}
+
+// NewComparator creates a Comparator that commits differences to diffs,
+// ignoring DefaultIgnoredFields.  The diffs Sink is closed when both sides of
+// the comparison are closed.
+func NewComparator(diffs row.Sink) *Comparator {
+	c := &Comparator{
+		diffs:   diffs,
+		pending: [2]map[string]pendingRow{{}, {}},
+	}
+	c.SetIgnoredFields(DefaultIgnoredFields...)
+	return c
+}
+
+// SetIgnoredFields replaces the fields, as dotted JSON paths, that are not
+// compared.  It should be called before any rows are committed.
+func (c *Comparator) SetIgnoredFields(fields ...string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.ignored = make(map[string]bool, len(fields))
+	for _, f := range fields {
+		c.ignored[f] = true
+	}
+}
+
+// Baseline returns the Sink for the baseline rows.
+func (c *Comparator) Baseline() row.Sink {
+	return &compareSink{c: c, side: baseline}
+}
+
+// Candidate returns the Sink for the candidate rows.
+func (c *Comparator) Candidate() row.Sink {
+	return &compareSink{c: c, side: candidate}
+}
+
+// Diffs returns the number of DiffRows committed so far.
+func (c *Comparator) Diffs() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.count
+}
+
+// commitDiffs commits the DiffRows to the diff Sink.
+// Caller must hold the lock.
+func (c *Comparator) commitDiffs(diffs []interface{}) error {
+	if len(diffs) == 0 {
+		return nil
+	}
+	n, err := c.diffs.Commit(diffs, "diff")
+	c.count += n
+	return err
+}
+
+// commit matches the rows against the pending rows from the other side, and
+// commits the differences.  It returns the number of rows accepted, which
+// excludes rows without an ID.
+func (c *Comparator) commit(side int, rows []interface{}, label string) (int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	mine, other := c.pending[side], c.pending[1-side]
+	diffs := []interface{}{}
+	accepted := 0
+	var firstErr error
+	for _, r := range rows {
+		id, ok := row.ID(r)
+		if !ok {
+			if firstErr == nil {
+				firstErr = ErrNoRowID
+			}
+			continue
+		}
+		accepted++
+		if _, dup := mine[id]; dup {
+			date, _ := rowDate(r)
+			diffs = append(diffs, &schema.DiffRow{
+				ID: id, Date: date, Table: label, Kind: schema.DiffDuplicate})
+			continue
+		}
+		match, ok := other[id]
+		if !ok {
+			mine[id] = pendingRow{row: r, label: label}
+			continue
+		}
+		delete(other, id)
+		base, cand := match.row, r
+		if side == baseline {
+			base, cand = r, match.row
+		}
+		d, err := diff(id, label, base, cand, c.ignored)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		diffs = append(diffs, d...)
+	}
+	if err := c.commitDiffs(diffs); err != nil {
+		firstErr = err
+	}
+	return accepted, firstErr
+}
+
+// close closes one side.  When both sides are closed, it reports the
+// unmatched rows in ID order, and closes the diff Sink.
+func (c *Comparator) close(side int) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed[side] {
+		return nil
+	}
+	c.closed[side] = true
+	if !c.closed[1-side] {
+		return nil
+	}
+
+	diffs := []interface{}{}
+	kinds := [2]string{schema.DiffMissingCandidate, schema.DiffMissingBaseline}
+	for s := range c.pending {
+		ids := make([]string, 0, len(c.pending[s]))
+		for id := range c.pending[s] {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			p := c.pending[s][id]
+			date, _ := rowDate(p.row)
+			diffs = append(diffs, &schema.DiffRow{
+				ID: id, Date: date, Table: p.label, Kind: kinds[s]})
+		}
+		c.pending[s] = map[string]pendingRow{}
+	}
+	err := c.commitDiffs(diffs)
+	return JoinErrors(err, c.diffs.Close())
+}
+
+// compareSink implements row.Sink for one side of a Comparator.
+type compareSink struct {
+	c    *Comparator
+	side int
+}
+
+// Commit implements row.Sink.
+func (s *compareSink) Commit(rows []interface{}, label string) (int, error) {
+	n, err := s.c.commit(s.side, rows, label)
+	if err != nil {
+		return n, fmt.Errorf("comparing rows: %w", err)
+	}
+	return n, nil
+}
+
+// Close implements row.Sink.
+func (s *compareSink) Close() error {
+	return s.c.close(s.side)
+}
@@ -0,0 +1,115 @@
+package storage_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"github.com/go-test/deep"
+
+	"github.com/m-lab/etl/schema"
+	"github.com/m-lab/etl/storage"
+)
+
+type nested struct {
+	MinRTT int64
+	Flows  []int
+}
+
+type compareRow struct {
+	ID     string
+	Date   civil.Date
+	Raw    nested
+	Parser schema.ParseInfo `json:"parser"`
+}
+
+func TestComparator(t *testing.T) {
+	d := civil.Date{Year: 2022, Month: 7, Day: 1}
+	diffs := &memSink{}
+	c := storage.NewComparator(diffs)
+	base, cand := c.Baseline(), c.Candidate()
+
+	// Rows may arrive in any order, on either side.
+	n, err := base.Commit([]interface{}{
+		&compareRow{ID: "same", Date: d, Raw: nested{10, []int{1}}},
+		&compareRow{ID: "changed", Date: d, Raw: nested{10, []int{1}}},
+		&compareRow{ID: "base-only", Date: d},
+	}, "ndt7")
+	if err != nil || n != 3 {
+		t.Fatalf("Commit() = %d, %v, want 3, nil", n, err)
+	}
+	_, err = cand.Commit([]interface{}{
+		&compareRow{ID: "cand-only", Date: d},
+		&compareRow{ID: "changed", Date: d, Raw: nested{11, []int{1, 2}}},
+		&compareRow{ID: "same", Date: d, Raw: nested{10, []int{1}}},
+		&compareRow{ID: "cand-only", Date: d},
+	}, "ndt7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diffs.closed {
+		t.Error("diff sink closed before both sides closed")
+	}
+	if err := base.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cand.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !diffs.closed {
+		t.Error("diff sink not closed")
+	}
+
+	want := []interface{}{
+		&schema.DiffRow{ID: "changed", Date: d, Table: "ndt7", Kind: schema.DiffChanged,
+			Field: "Raw.Flows", Baseline: "[1]", Candidate: "[1,2]"},
+		&schema.DiffRow{ID: "changed", Date: d, Table: "ndt7", Kind: schema.DiffChanged,
+			Field: "Raw.MinRTT", Baseline: "10", Candidate: "11"},
+		&schema.DiffRow{ID: "cand-only", Date: d, Table: "ndt7", Kind: schema.DiffDuplicate},
+		&schema.DiffRow{ID: "base-only", Date: d, Table: "ndt7", Kind: schema.DiffMissingCandidate},
+		&schema.DiffRow{ID: "cand-only", Date: d, Table: "ndt7", Kind: schema.DiffMissingBaseline},
+	}
+	if diff := deep.Equal(diffs.rows, want); diff != nil {
+		t.Error(diff)
+	}
+	if c.Diffs() != len(want) {
+		t.Errorf("Diffs() = %d, want %d", c.Diffs(), len(want))
+	}
+}
+
+func TestComparator_IgnoredFields(t *testing.T) {
+	base := &compareRow{ID: "a", Parser: schema.ParseInfo{Version: "v1", Time: time.Unix(1, 0)}}
+	cand := &compareRow{ID: "a", Parser: schema.ParseInfo{Version: "v2", Time: time.Unix(2, 0)}}
+	tests := []struct {
+		name    string
+		ignored []string
+		want    int
+	}{
+		{"default", storage.DefaultIgnoredFields, 0},
+		{"none", nil, 2},
+		{"time", []string{"parser.Time"}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diffs := &memSink{}
+			c := storage.NewComparator(diffs)
+			c.SetIgnoredFields(tt.ignored...)
+			c.Baseline().Commit([]interface{}{base}, "ndt7")
+			c.Candidate().Commit([]interface{}{cand}, "ndt7")
+			if len(diffs.rows) != tt.want {
+				t.Errorf("got %d diffs, want %d: %v", len(diffs.rows), tt.want, diffs.rows)
+			}
+		})
+	}
+}
+
+func TestComparator_NoID(t *testing.T) {
+	c := storage.NewComparator(&memSink{})
+	n, err := c.Baseline().Commit([]interface{}{
+		&compareRow{ID: "a"}, &compareRow{}, "not a struct",
+	}, "ndt7")
+	if n != 1 || !errors.Is(err, storage.ErrNoRowID) {
+		t.Errorf("Commit() = %d, %v, want 1, %v", n, err, storage.ErrNoRowID)
+	}
+}
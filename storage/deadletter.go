@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"github.com/googleapis/google-cloud-go-testing/storage/stiface"
+
+	"github.com/m-lab/etl/etl"
+)
+
+// DeadLetterWriter writes the content of tests that failed to parse to GCS
+// objects under a prefix, with the error in the object metadata, so that
+// operators can inspect and reprocess them.
+type DeadLetterWriter struct {
+	client stiface.Client
+	bucket string
+	prefix string
+}
+
+// NewDeadLetterWriter creates a DeadLetterWriter for objects in the bucket
+// under the prefix.
+func NewDeadLetterWriter(client stiface.Client, bucket, prefix string) *DeadLetterWriter {
+	return &DeadLetterWriter{client: client, bucket: bucket, prefix: prefix}
+}
+
+// Path returns the name of the object for a test of an archive, which is the
+// archive's bucket and path, and the test name, under the prefix.
+func (d *DeadLetterWriter) Path(archive, testname string) string {
+	return path.Join(d.prefix, strings.TrimPrefix(archive, "gs://"), testname)
+}
+
+// Write writes the content of a test of an archive, with the parse error.
+func (d *DeadLetterWriter) Write(ctx context.Context, archive, testname string, data []byte, parseErr error) error {
+	// Canceling the context aborts the upload if the write fails.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w := d.client.Bucket(d.bucket).Object(d.Path(archive, testname)).NewWriter(ctx)
+	w.ObjectAttrs().Metadata = map[string]string{
+		"archive": archive,
+		"test":    testname,
+		"error":   parseErr.Error(),
+	}
+	if _, err := w.Write(data); err != nil {
+		return etl.ErrGCS.Errorf("writing dead letter %s: %w", d.Path(archive, testname), err)
+	}
+	if err := w.Close(); err != nil {
+		return etl.ErrGCS.Errorf("writing dead letter %s: %w", d.Path(archive, testname), err)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	fgs "github.com/fsouza/fake-gcs-server/fakestorage"
+	"github.com/go-test/deep"
+	"github.com/googleapis/google-cloud-go-testing/storage/stiface"
+
+	"github.com/m-lab/etl/storage"
+)
+
+func TestDeadLetterWriter(t *testing.T) {
+	server := fgs.NewServer([]fgs.Object{})
+	defer server.Stop()
+	bucket := "fake-bucket"
+	server.CreateBucket(bucket)
+
+	d := storage.NewDeadLetterWriter(stiface.AdaptClient(server.Client()), bucket, "deadletter")
+	archive := "gs://archive/ndt/tcpinfo/2019/05/16/a.tgz"
+	test := "2019/05/16/foo.jsonl.zst"
+	want := "deadletter/archive/ndt/tcpinfo/2019/05/16/a.tgz/2019/05/16/foo.jsonl.zst"
+	if got := d.Path(archive, test); got != want {
+		t.Errorf("Path() = %q, want %q", got, want)
+	}
+	if err := d.Write(context.Background(), archive, test, []byte("corrupt"), errors.New("zstd error")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readObject(t, server, bucket, want); string(got) != "corrupt" {
+		t.Errorf("Write() wrote %q, want %q", got, "corrupt")
+	}
+	attrs, err := server.Client().Bucket(bucket).Object(want).Attrs(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := map[string]string{"archive": archive, "test": test, "error": "zstd error"}
+	if diff := deep.Equal(attrs.Metadata, meta); diff != nil {
+		t.Errorf("Write() metadata = %v, diff %v", attrs.Metadata, diff)
+	}
+}
@@ -1 +1,30 @@
 package storage
+
+import (
+	"context"
+	"net/http"
+
+	"cloud.google.com/go/bigquery"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// RetryCountingTransport exports retryCountingTransport for testing.
+func RetryCountingTransport(base http.RoundTripper) http.RoundTripper {
+	return &retryCountingTransport{base: base}
+}
+
+// WithRetryCounter exports withRetryCounter for testing.
+func WithRetryCounter(ctx context.Context, n *int64) context.Context {
+	return withRetryCounter(ctx, n)
+}
+
+// NewCommitError creates a CommitError for testing.
+func NewCommitError(err error, retries int) *CommitError {
+	return &CommitError{Err: err, retries: retries}
+}
+
+// RowDescriptor exports the message descriptor of rowDescriptor for testing.
+func RowDescriptor(schema bigquery.Schema) (protoreflect.MessageDescriptor, error) {
+	md, _, err := rowDescriptor(schema)
+	return md, err
+}
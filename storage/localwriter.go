@@ -68,6 +68,11 @@ func (lw *LocalWriter) Commit(rows []interface{}, label string) (int, error) {
 	return len(rows), nil
 }
 
+// Committed implements row.Counter.
+func (lw *LocalWriter) Committed() int {
+	return lw.rows
+}
+
 // Close closes the underlying LocalWriter file object.
 func (lw *LocalWriter) Close() error {
 	err := lw.f.Close()
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/googleapis/google-cloud-go-testing/storage/stiface"
+
+	"github.com/m-lab/etl/etl"
+	"github.com/m-lab/etl/row"
+)
+
+// ManifestExt is the extension of manifest objects.
+const ManifestExt = ".manifest.json"
+
+// ManifestObject describes an object listed in a Manifest.
+type ManifestObject struct {
+	Name  string `json:"name"`  // gs://bucket/path URL of the object.
+	Rows  int    `json:"rows"`  // Number of rows in the object.
+	Bytes int64  `json:"bytes"` // Size of the object, after any compression.
+}
+
+// Manifest lists the objects written for a task.  It is written once all of
+// the objects are closed, and only if the task succeeds, so that load jobs
+// can consume exactly the objects of completed tasks.
+type Manifest struct {
+	Archive string           `json:"archive"` // gs://bucket/path URL of the archive.
+	Objects []ManifestObject `json:"objects"`
+
+	lock sync.Mutex
+}
+
+// newManifest returns a Manifest for the archive, or nil if
+// DefaultWriterOptions.Manifest is not set.
+func newManifest(dp etl.DataPath) *Manifest {
+	if !DefaultWriterOptions.Manifest {
+		return nil
+	}
+	return &Manifest{Archive: dp.URI, Objects: []ManifestObject{}}
+}
+
+// ManifestPath returns the path of the manifest object for an archive, in
+// the output bucket.
+func ManifestPath(dp etl.DataPath) string {
+	return path.Join(dp.Bucket, dp.Path+ManifestExt)
+}
+
+func (m *Manifest) add(o ManifestObject) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.Objects = append(m.Objects, o)
+}
+
+// manifestSink writes the Manifest of its row.Sink when it is closed, unless
+// it was discarded.
+type manifestSink struct {
+	row.Sink
+	m         *Manifest
+	o         stiface.ObjectHandle
+	path      string
+	discarded bool
+}
+
+// withManifest returns sink, wrapped to write m to the manifest object for dp
+// when it is closed, or sink itself if m is nil.
+func withManifest(sink row.Sink, m *Manifest, client stiface.Client, bucket string, dp etl.DataPath) row.Sink {
+	if m == nil {
+		return sink
+	}
+	p := ManifestPath(dp)
+	return &manifestSink{Sink: sink, m: m, o: client.Bucket(bucket).Object(p), path: p}
+}
+
+// Committed implements row.Counter.
+func (s *manifestSink) Committed() int {
+	if c, ok := s.Sink.(row.Counter); ok {
+		return c.Committed()
+	}
+	return 0
+}
+
+// Discard implements row.Discarder.  The objects are still closed, but no
+// manifest lists them.
+func (s *manifestSink) Discard() {
+	s.discarded = true
+}
+
+// Close closes the sink, and then writes the manifest if all of the objects
+// were closed successfully.
+func (s *manifestSink) Close() error {
+	if err := s.Sink.Close(); err != nil || s.discarded {
+		return err
+	}
+	s.m.lock.Lock()
+	defer s.m.lock.Unlock()
+	data, err := json.Marshal(s.m)
+	if err != nil {
+		return err
+	}
+	// Canceling the context aborts the upload if the write fails.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	w := s.o.NewWriter(ctx)
+	w.ObjectAttrs().ContentType = "application/json"
+	if _, err := w.Write(data); err != nil {
+		return etl.ErrGCS.Errorf("writing manifest %s: %w", s.path, err)
+	}
+	if err := w.Close(); err != nil {
+		return etl.ErrGCS.Errorf("writing manifest %s: %w", s.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package storage_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	fgs "github.com/fsouza/fake-gcs-server/fakestorage"
+	"github.com/googleapis/google-cloud-go-testing/storage/stiface"
+
+	"github.com/m-lab/etl/etl"
+	"github.com/m-lab/etl/row"
+	"github.com/m-lab/etl/storage"
+)
+
+func TestSinkFactory_Manifest(t *testing.T) {
+	defer func(opts storage.WriterOptions) { storage.DefaultWriterOptions = opts }(storage.DefaultWriterOptions)
+	storage.DefaultWriterOptions.Manifest = true
+	storage.DefaultWriterOptions.RotateBytes = 1
+
+	server := fgs.NewServer([]fgs.Object{})
+	defer server.Stop()
+	server.CreateBucket("output")
+	sf := storage.NewSinkFactory(stiface.AdaptClient(server.Client()), "output")
+
+	dp, err := etl.ValidateTestPath(
+		"gs://archive/ndt/tcpinfo/2022/07/01/20220701T000000.000000Z-tcpinfo-mlab1-foo01-ndt.tgz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink, pErr := sf.Get(context.Background(), dp)
+	if pErr != nil {
+		t.Fatal(pErr)
+	}
+	rows := tcpinfoRows(2)
+	for i := range rows {
+		if _, err := sink.Commit(rows[i:i+1], "fake-label"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := sink.(row.Counter).Committed(); n != 2 {
+		t.Errorf("Committed() = %d, want 2", n)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var m storage.Manifest
+	if err := json.Unmarshal(readObject(t, server, "output", storage.ManifestPath(dp)), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Archive != dp.URI || len(m.Objects) != 2 {
+		t.Fatalf("manifest of %s = %+v, want 2 objects of %s", m.Archive, m.Objects, dp.URI)
+	}
+	first := "archive/" + dp.Path + storage.DefaultWriterOptions.Ext()
+	for i, o := range m.Objects {
+		if want := "gs://output/" + storage.DefaultWriterOptions.PartPath(first, i); o.Name != want || o.Rows != 1 {
+			t.Errorf("manifest object %d = %+v, want 1 row in %s", i, o, want)
+		}
+	}
+
+	// A discarded sink writes no manifest.
+	dp.Path = "ndt/tcpinfo/2022/07/01/20220701T000001.000000Z-tcpinfo-mlab1-foo01-ndt.tgz"
+	sink, pErr = sf.Get(context.Background(), dp)
+	if pErr != nil {
+		t.Fatal(pErr)
+	}
+	if _, err := sink.Commit(rows, "fake-label"); err != nil {
+		t.Fatal(err)
+	}
+	sink.(row.Discarder).Discard()
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.GetObject("output", storage.ManifestPath(dp)); err == nil {
+		t.Error("discarded sink wrote a manifest")
+	}
+}
@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/googleapis/google-cloud-go-testing/storage/stiface"
+
+	"github.com/m-lab/etl/etl"
+	"github.com/m-lab/etl/factory"
+	"github.com/m-lab/etl/metrics"
+	"github.com/m-lab/etl/parquet"
+	"github.com/m-lab/etl/row"
+)
+
+// ParquetExt is the extension of the Parquet objects written by ParquetSinks.
+const ParquetExt = ".parquet"
+
+// schemer is implemented by the row types of the schema package.
+type schemer interface {
+	Schema() (bigquery.Schema, error)
+}
+
+// rowSchema returns the BigQuery schema of a row whose type, or pointer type,
+// has a Schema method.
+func rowSchema(r interface{}) (bigquery.Schema, error) {
+	if s, ok := r.(schemer); ok {
+		return s.Schema()
+	}
+	v := reflect.ValueOf(r)
+	if v.IsValid() && v.Kind() != reflect.Ptr {
+		p := reflect.New(v.Type())
+		p.Elem().Set(v)
+		if s, ok := p.Interface().(schemer); ok {
+			return s.Schema()
+		}
+	}
+	return nil, fmt.Errorf("%T has no Schema method", r)
+}
+
+// ParquetSink implements row.Sink, writing the rows of a task to a single
+// Parquet object in GCS.  The Parquet schema is derived from the BigQuery
+// schema of the first row committed, so the object is only created once
+// there are rows, and all rows must have the same schema.  As for a
+// RowWriter, the object is not available until Close is called.
+type ParquetSink struct {
+	ctx      context.Context
+	cancel   context.CancelFunc // Cancels w's context, abandoning the upload.
+	o        stiface.ObjectHandle
+	bucket   string
+	path     string
+	manifest *Manifest // Optional, records the object.
+
+	lock    sync.Mutex
+	w       stiface.Writer // nil until the first row is committed.
+	pw      *parquet.Writer
+	err     error // Write error, after which the upload is abandoned.
+	retries int64 // Failed GCS requests, updated atomically by the transport.
+}
+
+// NewParquetSink creates a ParquetSink that writes the object at path in the
+// bucket.
+func NewParquetSink(ctx context.Context, client stiface.Client, bucket, path string) *ParquetSink {
+	return newParquetSink(ctx, client, bucket, path, nil)
+}
+
+// newParquetSink creates a ParquetSink that adds its object to m, if m is not
+// nil.
+func newParquetSink(ctx context.Context, client stiface.Client, bucket, path string, m *Manifest) *ParquetSink {
+	s := &ParquetSink{o: client.Bucket(bucket).Object(path), bucket: bucket, path: path, manifest: m}
+	s.ctx, s.cancel = context.WithCancel(withRetryCounter(ctx, &s.retries))
+	return s
+}
+
+// open starts the upload, for rows of the schema of r.
+// Caller must hold the lock.
+func (s *ParquetSink) open(r interface{}) error {
+	schema, err := rowSchema(r)
+	if err != nil {
+		return etl.ErrValidation.Errorf("%w", err)
+	}
+	w := s.o.NewWriter(s.ctx)
+	if DefaultWriterOptions.ChunkSize > 0 {
+		w.SetChunkSize(DefaultWriterOptions.ChunkSize)
+	}
+	pw, err := parquet.NewWriter(w, schema)
+	if err != nil {
+		// Nothing is uploaded until the writer is flushed or closed, so w
+		// is simply dropped.
+		return etl.ErrValidation.Errorf("%w", err)
+	}
+	s.w, s.pw = w, pw
+	return nil
+}
+
+// Commit implements row.Sink.  Rows that cannot be encoded are dropped, and
+// the first such error is returned with the number of rows written.
+func (s *ParquetSink) Commit(rows []interface{}, label string) (int, error) {
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.err != nil {
+		return 0, &CommitError{Err: etl.ErrGCS.Errorf("%v", s.err), retries: int(atomic.LoadInt64(&s.retries))}
+	}
+	if s.w == nil {
+		if err := s.open(rows[0]); err != nil {
+			metrics.BackendFailureCount.WithLabelValues(label, "schema error").Inc()
+			return 0, err
+		}
+	}
+	n := 0
+	var encodeErr error
+	for _, r := range rows {
+		err := s.pw.Write(r)
+		if err == nil {
+			n++
+			continue
+		}
+		if errors.Is(err, parquet.ErrInvalidRow) {
+			metrics.BackendFailureCount.WithLabelValues(label, "encoding error").Inc()
+			if encodeErr == nil {
+				encodeErr = etl.ErrValidation.Errorf("encoding row: %w", err)
+			}
+			continue
+		}
+		// Flushing a row group failed, so the object will be abandoned.
+		metrics.BackendFailureCount.WithLabelValues(label, "other error").Inc()
+		log.Println(err, s.bucket, s.path)
+		s.err = err
+		return 0, &CommitError{Err: etl.ErrGCS.Errorf("writing %s: %w", s.path, err), retries: int(atomic.LoadInt64(&s.retries))}
+	}
+	return n, encodeErr
+}
+
+// Committed implements row.Counter.
+func (s *ParquetSink) Committed() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.pw == nil {
+		return 0
+	}
+	return s.pw.Rows()
+}
+
+// Close writes the footer of the Parquet file, and closes the object.  If
+// any write failed, the upload is abandoned, so that no truncated object is
+// published.  If no rows were committed, no object is written.
+func (s *ParquetSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	defer s.cancel()
+	if s.w == nil {
+		return nil
+	}
+	err := s.err
+	if err == nil {
+		err = s.pw.Close()
+	}
+	if err != nil {
+		log.Println(err, "abandoning", s.bucket, s.path)
+		// Canceling the context before Close abandons the upload.
+		s.cancel()
+		s.w.Close()
+		return etl.ErrGCS.Errorf("writing %s: %w", s.path, err)
+	}
+	if err := s.w.Close(); err != nil {
+		return etl.ErrGCS.Errorf("writing %s: %w", s.path, err)
+	}
+	if s.manifest != nil {
+		var size int64
+		if a := s.w.Attrs(); a != nil {
+			size = a.Size
+		}
+		s.manifest.add(ManifestObject{
+			Name: "gs://" + s.bucket + "/" + s.path, Rows: s.pw.Rows(), Bytes: size})
+	}
+	return nil
+}
+
+// ParquetSinkFactory implements factory.SinkFactory, producing ParquetSinks
+// that write one Parquet object per task.
+type ParquetSinkFactory struct {
+	client       stiface.Client
+	outputBucket string
+}
+
+// NewParquetSinkFactory returns a SinkFactory that writes the rows of each
+// task to a Parquet object in the output bucket, named for the archive.  If
+// DefaultWriterOptions.Manifest is set, a manifest is also written for each
+// task.
+func NewParquetSinkFactory(client stiface.Client, outputBucket string) factory.SinkFactory {
+	return &ParquetSinkFactory{client: client, outputBucket: outputBucket}
+}
+
+// Get implements factory.SinkFactory.
+func (sf *ParquetSinkFactory) Get(ctx context.Context, dp etl.DataPath) (row.Sink, etl.ProcessingError) {
+	m := newManifest(dp)
+	s := newParquetSink(ctx, sf.client, sf.outputBucket, path.Join(dp.Bucket, dp.Path+ParquetExt), m)
+	return withManifest(s, m, sf.client, sf.outputBucket, dp), nil
+}
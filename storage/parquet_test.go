@@ -0,0 +1,90 @@
+package storage_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"cloud.google.com/go/civil"
+	fgs "github.com/fsouza/fake-gcs-server/fakestorage"
+	"github.com/googleapis/google-cloud-go-testing/storage/stiface"
+
+	"github.com/m-lab/etl/etl"
+	"github.com/m-lab/etl/row"
+	"github.com/m-lab/etl/schema"
+	"github.com/m-lab/etl/storage"
+)
+
+func TestParquetSinkFactory(t *testing.T) {
+	defer func(opts storage.WriterOptions) { storage.DefaultWriterOptions = opts }(storage.DefaultWriterOptions)
+	storage.DefaultWriterOptions.Manifest = true
+
+	server := fgs.NewServer([]fgs.Object{})
+	defer server.Stop()
+	server.CreateBucket("output")
+	sf := storage.NewParquetSinkFactory(stiface.AdaptClient(server.Client()), "output")
+
+	dp, err := etl.ValidateTestPath(
+		"gs://archive/ndt/tcpinfo/2022/07/01/20220701T000000.000000Z-tcpinfo-mlab1-foo01-ndt.tgz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink, pErr := sf.Get(context.Background(), dp)
+	if pErr != nil {
+		t.Fatal(pErr)
+	}
+	date := civil.Date{Year: 2022, Month: 7, Day: 1}
+	// Rows may be committed by value or by pointer.
+	rows := []interface{}{
+		schema.UUIDMapRow{UUID: "a", Datatype: "tcpinfo", Date: date},
+		&schema.UUIDMapRow{UUID: "b", Datatype: "tcpinfo", Date: date},
+	}
+	if n, err := sink.Commit(rows, "fake-label"); n != 2 || err != nil {
+		t.Fatalf("Commit() = %d, %v, want 2, nil", n, err)
+	}
+	bad := []interface{}{map[string]interface{}{"id": "c", "unknown": 1}, &schema.UUIDMapRow{UUID: "d", Date: date}}
+	if n, err := sink.Commit(bad, "fake-label"); n != 1 || !errors.Is(err, etl.ErrValidation) {
+		t.Errorf("Commit() = %d, %v, want 1, %v", n, err, etl.ErrValidation)
+	}
+	if n := sink.(row.Counter).Committed(); n != 3 {
+		t.Errorf("Committed() = %d, want 3", n)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	name := "archive/" + dp.Path + storage.ParquetExt
+	data := readObject(t, server, "output", name)
+	if !bytes.HasPrefix(data, []byte("PAR1")) || !bytes.HasSuffix(data, []byte("PAR1")) {
+		t.Errorf("%s is not a Parquet file", name)
+	}
+	var m storage.Manifest
+	if err := json.Unmarshal(readObject(t, server, "output", storage.ManifestPath(dp)), &m); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Objects) != 1 || m.Objects[0].Name != "gs://output/"+name || m.Objects[0].Rows != 3 {
+		t.Errorf("manifest objects = %+v, want 3 rows in %s", m.Objects, name)
+	}
+
+	// A task with no rows writes no object.
+	dp.Path = "ndt/tcpinfo/2022/07/01/20220701T000001.000000Z-tcpinfo-mlab1-foo01-ndt.tgz"
+	sink, pErr = sf.Get(context.Background(), dp)
+	if pErr != nil {
+		t.Fatal(pErr)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.GetObject("output", "archive/"+dp.Path+storage.ParquetExt); err == nil {
+		t.Error("empty sink wrote an object")
+	}
+
+	// Rows without a Schema method are rejected.
+	sink, _ = sf.Get(context.Background(), dp)
+	if _, err := sink.Commit([]interface{}{struct{ X int }{1}}, "fake-label"); !errors.Is(err, etl.ErrValidation) {
+		t.Errorf("Commit() error = %v, want %v", err, etl.ErrValidation)
+	}
+	sink.Close()
+}
@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"google.golang.org/api/option"
+	pubsub "google.golang.org/api/pubsub/v1"
+
+	"github.com/m-lab/etl/etl"
+	"github.com/m-lab/etl/factory"
+	"github.com/m-lab/etl/metrics"
+	"github.com/m-lab/etl/row"
+)
+
+// publishTimeout limits the time for each publish request.
+const publishTimeout = 2 * time.Minute
+
+// The limits of each publish request.  Pub/Sub accepts at most 1000 messages
+// and 10MB per request, and message data is base64 encoded in the request.
+const (
+	maxBatchMessages = 1000
+	maxBatchBytes    = 7 << 20
+)
+
+// ErrPublishFailed is returned by PubSubSink.Commit after a publish has
+// failed, since the sink's later rows would be published out of order.
+var ErrPublishFailed = errors.New("publish failed")
+
+// Publisher publishes messages to a Pub/Sub topic.
+type Publisher interface {
+	// Publish publishes the messages, in order, and waits until they have
+	// been accepted.
+	Publish(ctx context.Context, msgs []*pubsub.PubsubMessage) error
+}
+
+// topicPublisher implements Publisher with the Pub/Sub REST API.
+type topicPublisher struct {
+	topics *pubsub.ProjectsTopicsService
+	topic  string
+}
+
+// NewPublisher returns a Publisher to the topic, given as
+// "projects/p/topics/t".  The topic's subscriptions must enable message
+// ordering for consumers to receive each task's rows in order.
+func NewPublisher(ctx context.Context, topic string, opts ...option.ClientOption) (Publisher, error) {
+	svc, err := pubsub.NewService(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &topicPublisher{topics: pubsub.NewProjectsTopicsService(svc), topic: topic}, nil
+}
+
+// Publish implements Publisher.
+func (p *topicPublisher) Publish(ctx context.Context, msgs []*pubsub.PubsubMessage) error {
+	_, err := p.topics.Publish(p.topic, &pubsub.PublishRequest{Messages: msgs}).Context(ctx).Do()
+	return err
+}
+
+// PubSubSink implements row.Sink, publishing each row as a JSON encoded
+// message, so that downstream consumers can subscribe to the parsed rows as
+// they are produced.  Rows are published in batches, all with the task's
+// filename as their ordering key, so each task's rows are received in order.
+//
+// Published rows cannot be withdrawn, so the rows of a task that fails after
+// a publish, and is retried, are published again.  Consumers should expect
+// duplicates, which share the ordering key.
+type PubSubSink struct {
+	ctx context.Context
+	pub Publisher
+	key string // Ordering key, and "filename" attribute, of the messages.
+
+	lock      sync.Mutex
+	batch     []*pubsub.PubsubMessage
+	bytes     int   // Data bytes in the batch.
+	rows      int   // Rows published, or in the batch.
+	err       error // First publish error.
+	discarded bool
+}
+
+// NewPubSubSink creates a PubSubSink that publishes rows with the publisher,
+// with the filename as their ordering key.
+func NewPubSubSink(ctx context.Context, pub Publisher, filename string) *PubSubSink {
+	return &PubSubSink{ctx: ctx, pub: pub, key: filename}
+}
+
+// publish publishes the batch.  The lock must be held.
+func (s *PubSubSink) publish() error {
+	if len(s.batch) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, publishTimeout)
+	defer cancel()
+	if err := s.pub.Publish(ctx, s.batch); err != nil {
+		s.err = err
+		return etl.ErrPubSub.Errorf("publishing %d rows of %s: %w", len(s.batch), s.key, err)
+	}
+	s.batch, s.bytes = nil, 0
+	return nil
+}
+
+// Commit implements row.Sink.  Rows that cannot be encoded are dropped, and
+// the others are added to the batch, which is published whenever it is full.
+// The returned count is the number of rows added, and the error is the first
+// encoding or publish error.
+func (s *PubSubSink) Commit(rows []interface{}, label string) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.err != nil {
+		return 0, etl.ErrPubSub.Errorf("%w: %v", ErrPublishFailed, s.err)
+	}
+	n := 0
+	var encodeErr error
+	for i := range rows {
+		b, err := json.Marshal(rows[i])
+		if err == nil && len(b) > maxBatchBytes {
+			err = errors.New("row is too large to publish")
+		}
+		if err != nil {
+			metrics.BackendFailureCount.WithLabelValues(label, "encoding error").Inc()
+			if encodeErr == nil {
+				encodeErr = etl.ErrValidation.Errorf("encoding row: %w", err)
+			}
+			continue
+		}
+		if len(s.batch) == maxBatchMessages || s.bytes+len(b) > maxBatchBytes {
+			if err := s.publish(); err != nil {
+				metrics.BackendFailureCount.WithLabelValues(label, "other error").Inc()
+				return n, err
+			}
+		}
+		s.batch = append(s.batch, &pubsub.PubsubMessage{
+			Data:        base64.StdEncoding.EncodeToString(b),
+			OrderingKey: s.key,
+			Attributes:  map[string]string{"filename": s.key, "table": label},
+		})
+		s.bytes += len(b)
+		s.rows++
+		n++
+	}
+	return n, encodeErr
+}
+
+// Committed implements row.Counter.  Rows are counted when they are added to
+// the batch, although they are not published until the batch is full, or
+// the sink is closed.
+func (s *PubSubSink) Committed() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.rows
+}
+
+// Discard implements row.Discarder.  Rows not yet published are dropped.
+func (s *PubSubSink) Discard() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.discarded = true
+}
+
+// Close publishes the rest of the batch, unless the sink was discarded or a
+// publish failed.
+func (s *PubSubSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	switch {
+	case s.discarded:
+		log.Printf("Discarding %d unpublished rows of %s", len(s.batch), s.key)
+		return nil
+	case s.err != nil:
+		log.Printf("Discarding %d unpublished rows of %s after %v", len(s.batch), s.key, s.err)
+		return etl.ErrPubSub.Errorf("%w: %v", ErrPublishFailed, s.err)
+	}
+	return s.publish()
+}
+
+// PubSubSinkFactory implements factory.SinkFactory, producing PubSubSinks
+// that publish to a single topic.
+type PubSubSinkFactory struct {
+	pub Publisher
+}
+
+// NewPubSubSinkFactory returns a SinkFactory that publishes the rows of each
+// task with the publisher.
+func NewPubSubSinkFactory(pub Publisher) factory.SinkFactory {
+	return &PubSubSinkFactory{pub: pub}
+}
+
+// Get implements factory.SinkFactory.
+func (sf *PubSubSinkFactory) Get(ctx context.Context, dp etl.DataPath) (row.Sink, etl.ProcessingError) {
+	return NewPubSubSink(ctx, sf.pub, dp.URI), nil
+}
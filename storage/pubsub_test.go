@@ -0,0 +1,104 @@
+package storage_test
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	pubsub "google.golang.org/api/pubsub/v1"
+
+	"github.com/m-lab/etl/etl"
+	"github.com/m-lab/etl/row"
+	"github.com/m-lab/etl/storage"
+)
+
+// fakePublisher records the batches published, and fails if err is set.
+type fakePublisher struct {
+	batches [][]*pubsub.PubsubMessage
+	err     error
+}
+
+func (fp *fakePublisher) Publish(ctx context.Context, msgs []*pubsub.PubsubMessage) error {
+	if fp.err != nil {
+		return fp.err
+	}
+	fp.batches = append(fp.batches, msgs)
+	return nil
+}
+
+type pubRow struct {
+	ID string
+	N  int
+}
+
+func TestPubSubSink(t *testing.T) {
+	fp := &fakePublisher{}
+	uri := "gs://archive/ndt/ndt7/2022/07/01/20220701T000000.000000Z-ndt7-mlab1-foo01-ndt.tgz"
+	dp, err := etl.ValidateTestPath(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink, pErr := storage.NewPubSubSinkFactory(fp).Get(context.Background(), dp)
+	if pErr != nil {
+		t.Fatal(pErr)
+	}
+
+	rows := make([]interface{}, 1001)
+	for i := range rows {
+		rows[i] = &pubRow{ID: "a", N: i}
+	}
+	rows = append(rows, map[string]interface{}{"bad": make(chan int)})
+	if n, err := sink.Commit(rows, "ndt7"); n != 1001 || !errors.Is(err, etl.ErrValidation) {
+		t.Errorf("Commit() = %d, %v, want 1001, %v", n, err, etl.ErrValidation)
+	}
+	// A full batch is published, and the rest wait for Close.
+	if len(fp.batches) != 1 || len(fp.batches[0]) != 1000 {
+		t.Fatalf("published %d batches, want 1 of 1000 rows", len(fp.batches))
+	}
+	if n := sink.(row.Counter).Committed(); n != 1001 {
+		t.Errorf("Committed() = %d, want 1001", n)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(fp.batches) != 2 || len(fp.batches[1]) != 1 {
+		t.Fatalf("published %d batches, want 2", len(fp.batches))
+	}
+	m := fp.batches[1][0]
+	data, err := base64.StdEncoding.DecodeString(m.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"ID":"a","N":1000}` || m.OrderingKey != uri ||
+		m.Attributes["filename"] != uri || m.Attributes["table"] != "ndt7" {
+		t.Errorf("published %s, %+v", data, m)
+	}
+}
+
+func TestPubSubSink_Errors(t *testing.T) {
+	// A failed publish fails the later commits, and Close.
+	fp := &fakePublisher{err: errors.New("unavailable")}
+	s := storage.NewPubSubSink(context.Background(), fp, "gs://archive/x.tgz")
+	if n, err := s.Commit([]interface{}{&pubRow{ID: "a"}}, "t"); n != 1 || err != nil {
+		t.Fatalf("Commit() = %d, %v", n, err)
+	}
+	if err := s.Close(); !errors.Is(err, etl.ErrPubSub) {
+		t.Errorf("Close() = %v, want %v", err, etl.ErrPubSub)
+	}
+	if _, err := s.Commit([]interface{}{&pubRow{ID: "b"}}, "t"); !errors.Is(err, storage.ErrPublishFailed) {
+		t.Errorf("Commit() = %v, want %v", err, storage.ErrPublishFailed)
+	}
+	if err := s.Close(); !errors.Is(err, storage.ErrPublishFailed) {
+		t.Errorf("Close() = %v, want %v", err, storage.ErrPublishFailed)
+	}
+
+	// A discarded sink publishes nothing more.
+	fp = &fakePublisher{}
+	s = storage.NewPubSubSink(context.Background(), fp, "gs://archive/x.tgz")
+	s.Commit([]interface{}{&pubRow{ID: "a"}}, "t")
+	s.Discard()
+	if err := s.Close(); err != nil || len(fp.batches) != 0 {
+		t.Errorf("Close() = %v, published %d batches after Discard", err, len(fp.batches))
+	}
+}
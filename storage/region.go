@@ -0,0 +1,27 @@
+package storage
+
+import (
+	"context"
+	"errors"
+
+	"github.com/googleapis/google-cloud-go-testing/storage/stiface"
+
+	"github.com/m-lab/etl/etl"
+)
+
+// ErrWrongRegion is returned for buckets outside etl.Region, which are not
+// read or written, to avoid cross-region egress.
+var ErrWrongRegion = errors.New("bucket is not in region")
+
+// CheckBucketRegion returns an etl.ErrGCS error if the bucket cannot be
+// accessed, or ErrWrongRegion if it is not in etl.Region.
+func CheckBucketRegion(ctx context.Context, client stiface.Client, bucket string) error {
+	attrs, err := client.Bucket(bucket).Attrs(ctx)
+	if err != nil {
+		return etl.ErrGCS.Errorf("bucket %s: %w", bucket, err)
+	}
+	if !etl.InRegion(attrs.Location) {
+		return etl.ErrValidation.Errorf("%w %s: %s is in %s", ErrWrongRegion, etl.Region, bucket, attrs.Location)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	gcs "cloud.google.com/go/storage"
+	"github.com/googleapis/google-cloud-go-testing/storage/stiface"
+
+	"github.com/m-lab/etl/etl"
+	"github.com/m-lab/etl/storage"
+)
+
+// locationClient reports the location of each bucket, and counts lookups.
+type locationClient struct {
+	stiface.Client
+	locations map[string]string
+	lookups   int
+}
+
+func (c *locationClient) Bucket(name string) stiface.BucketHandle {
+	return &locationBucket{client: c, name: name}
+}
+
+type locationBucket struct {
+	stiface.BucketHandle
+	client *locationClient
+	name   string
+}
+
+func (b *locationBucket) Attrs(ctx context.Context) (*gcs.BucketAttrs, error) {
+	b.client.lookups++
+	loc, ok := b.client.locations[b.name]
+	if !ok {
+		return nil, gcs.ErrBucketNotExist
+	}
+	return &gcs.BucketAttrs{Name: b.name, Location: loc}, nil
+}
+
+func TestCheckBucketRegion(t *testing.T) {
+	defer func() { etl.Region = "" }()
+	c := &locationClient{locations: map[string]string{"eu": "EUROPE-WEST1", "us": "US"}}
+	tests := []struct {
+		region  string
+		bucket  string
+		wantErr error
+	}{
+		{region: "", bucket: "us"},
+		{region: "europe-west1", bucket: "eu"},
+		{region: "europe-west1", bucket: "us", wantErr: storage.ErrWrongRegion},
+		{region: "europe-west1", bucket: "missing", wantErr: gcs.ErrBucketNotExist},
+	}
+	for _, tt := range tests {
+		etl.Region = tt.region
+		err := storage.CheckBucketRegion(context.Background(), c, tt.bucket)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("CheckBucketRegion(%q) in %q = %v, want %v", tt.bucket, tt.region, err, tt.wantErr)
+		}
+		if tt.wantErr == gcs.ErrBucketNotExist && !errors.Is(err, etl.ErrGCS) {
+			t.Errorf("CheckBucketRegion(%q) = %v, want kind ErrGCS", tt.bucket, err)
+		}
+	}
+}
+
+func TestSourceClients_CheckRegion(t *testing.T) {
+	defer func() { etl.Region = "" }()
+	c := &locationClient{locations: map[string]string{"eu": "EUROPE-WEST1", "us": "US"}}
+	sc := storage.NewSourceClients(c)
+	ctx := context.Background()
+
+	// Without a region, buckets are not looked up.
+	if err := sc.CheckRegion(ctx, c, "us"); err != nil || c.lookups != 0 {
+		t.Errorf("CheckRegion() = %v after %d lookups, want nil after none", err, c.lookups)
+	}
+
+	etl.Region = "europe-west1"
+	for i := 0; i < 2; i++ {
+		if err := sc.CheckRegion(ctx, c, "eu"); err != nil {
+			t.Errorf("CheckRegion(eu) = %v", err)
+		}
+		if err := sc.CheckRegion(ctx, c, "us"); !errors.Is(err, storage.ErrWrongRegion) {
+			t.Errorf("CheckRegion(us) = %v, want ErrWrongRegion", err)
+		}
+	}
+	if c.lookups != 2 {
+		t.Errorf("CheckRegion() looked up %d buckets, want 2", c.lookups)
+	}
+
+	// Failed lookups are retried.
+	for i := 0; i < 2; i++ {
+		sc.CheckRegion(ctx, c, "missing")
+	}
+	if c.lookups != 4 {
+		t.Errorf("CheckRegion() looked up %d buckets, want 4", c.lookups)
+	}
+
+	dp, err := etl.ValidateTestPath(
+		"gs://us/ndt/ndt5/2020/06/11/20200611T123456.12345Z-ndt5-mlab1-foo01-ndt.tgz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, pErr := storage.MultiBucketSourceFactory(sc).Get(ctx, dp)
+	if pErr == nil || pErr.Code() != http.StatusForbidden {
+		t.Errorf("Get() = %v, want status %d", pErr, http.StatusForbidden)
+	}
+}
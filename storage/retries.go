@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/m-lab/etl/etl"
+)
+
+// retryCounterKey is the context key for the counter of failed GCS requests.
+type retryCounterKey struct{}
+
+// withRetryCounter returns a context whose GCS requests, made through a client
+// from GetStorageClient, count their failed attempts in n.
+func withRetryCounter(ctx context.Context, n *int64) context.Context {
+	return context.WithValue(ctx, retryCounterKey{}, n)
+}
+
+// retryCountingTransport counts the failed attempts of requests whose context
+// has a retry counter.  The GCS client retries such failures until its retry
+// deadline, so the count approximates the number of retries.
+type retryCountingTransport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *retryCountingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if n, ok := req.Context().Value(retryCounterKey{}).(*int64); ok {
+		if err != nil || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			atomic.AddInt64(n, 1)
+		}
+	}
+	return resp, err
+}
+
+// CommitError is returned by RowWriter.Commit when rows could not be written.
+// It implements row.Retried.
+type CommitError struct {
+	Err     error
+	retries int
+}
+
+// Error implements error.
+func (e *CommitError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying write error.
+func (e *CommitError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is etl.ErrGCS, the kind of every CommitError.
+func (e *CommitError) Is(target error) bool {
+	return target == etl.ErrGCS
+}
+
+// Retries implements row.Retried.  It returns the number of failed GCS
+// requests for the object, which the client retried, before the error.
+func (e *CommitError) Retries() int {
+	return e.retries
+}
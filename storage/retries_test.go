@@ -0,0 +1,60 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/m-lab/etl/etl"
+	"github.com/m-lab/etl/row"
+	"github.com/m-lab/etl/storage"
+)
+
+func TestRetryCountingTransport(t *testing.T) {
+	status := []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusNotFound, http.StatusOK}
+	i := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status[i%len(status)])
+		i++
+	}))
+	defer srv.Close()
+
+	c := &http.Client{Transport: storage.RetryCountingTransport(http.DefaultTransport)}
+	var n int64
+	ctx := storage.WithRetryCounter(context.Background(), &n)
+	for range status {
+		req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	// Requests without a counter are not counted.
+	resp, err := c.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if n != 2 {
+		t.Errorf("counted %d failed requests, want 2", n)
+	}
+}
+
+func TestCommitError(t *testing.T) {
+	base := errors.New("write failed")
+	err := fmt.Errorf("commit: %w", storage.NewCommitError(base, 3))
+	var r row.Retried
+	if !errors.As(err, &r) || r.Retries() != 3 {
+		t.Errorf("errors.As(%v, Retried) failed, or wrong Retries()", err)
+	}
+	if !errors.Is(err, base) {
+		t.Errorf("errors.Is(%v, %v) = false", err, base)
+	}
+	if !errors.Is(err, etl.ErrGCS) || errors.Is(err, etl.ErrBigQuery) {
+		t.Errorf("errors.Is(%v) matches the wrong kinds", err)
+	}
+}
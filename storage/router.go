@@ -0,0 +1,278 @@
+package storage
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"reflect"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"github.com/googleapis/google-cloud-go-testing/storage/stiface"
+
+	"github.com/m-lab/etl/etl"
+	"github.com/m-lab/etl/factory"
+	"github.com/m-lab/etl/row"
+)
+
+// SuffixFunc computes a table suffix or partition decorator from a row date.
+type SuffixFunc func(civil.Date) string
+
+// TemplateSuffix returns the "_YYYYMMDD" suffix used for template tables.
+func TemplateSuffix(d civil.Date) string {
+	return etl.TemplateSuffix.Suffix(d)
+}
+
+// PartitionSuffix returns the "$YYYYMMDD" partition decorator.
+func PartitionSuffix(d civil.Date) string {
+	return etl.PartitionSuffix.Suffix(d)
+}
+
+// ModeSuffix returns the suffix for the deployment mode.  Batch deployments
+// write to "_YYYYMMDD" template tables, which are later deduplicated and
+// copied into the final partitions.  Daily deployments write directly into
+// "$YYYYMMDD" partitions.
+func ModeSuffix(d civil.Date) string {
+	return etl.ModeSuffix.Suffix(d)
+}
+
+// rowDate returns the value of the Date field of a row struct, if present.
+// Rows that are not structs, or have no civil.Date field named Date, report false.
+func rowDate(r interface{}) (civil.Date, bool) {
+	v := reflect.ValueOf(r)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return civil.Date{}, false
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return civil.Date{}, false
+	}
+	f := v.FieldByName("Date")
+	if !f.IsValid() {
+		return civil.Date{}, false
+	}
+	d, ok := f.Interface().(civil.Date)
+	if !ok || !d.IsValid() {
+		return civil.Date{}, false
+	}
+	return d, true
+}
+
+// Router implements row.Sink, and routes each row to a per-suffix Sink derived
+// from the row's Date field.  This allows a single task that spans midnight to
+// write rows into the correct daily partitions.  Rows without a valid Date are
+// routed to the default suffix.  A Router with a nil SuffixFunc routes every
+// row to the default suffix.
+type Router struct {
+	lock     sync.Mutex
+	suffix   SuffixFunc
+	fallback string
+	newSink  func(suffix string) (row.Sink, error)
+	sinks    map[string]row.Sink
+	order    []string // suffixes in the order their sinks were created.
+	rows     int      // rows committed across all sinks.
+	entry    int      // entry of the last Sync, at which new sinks start.
+}
+
+// NewRouter creates a Router.  The newSink function is called once for each
+// distinct suffix, and rows without a Date are routed to the fallback suffix.
+// If suffix is nil, all rows are routed to the fallback suffix.
+func NewRouter(suffix SuffixFunc, fallback string, newSink func(suffix string) (row.Sink, error)) *Router {
+	return &Router{
+		suffix:   suffix,
+		fallback: fallback,
+		newSink:  newSink,
+		sinks:    make(map[string]row.Sink, 2),
+	}
+}
+
+// sinkFor returns the Sink for the suffix, creating it if necessary.
+// Caller must hold the lock.
+func (r *Router) sinkFor(suffix string) (row.Sink, error) {
+	s, ok := r.sinks[suffix]
+	if ok {
+		return s, nil
+	}
+	s, err := r.newSink(suffix)
+	if err != nil {
+		return nil, err
+	}
+	if sy, ok := s.(row.Syncer); ok && r.entry > 0 {
+		// Start in the current segment, as the existing sinks do.
+		if err := sy.Sync(r.entry); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	r.sinks[suffix] = s
+	r.order = append(r.order, suffix)
+	return s, nil
+}
+
+// Commit implements row.Sink.  Rows are grouped by suffix, preserving their
+// relative order, and committed to the corresponding Sinks.  The returned count
+// is the total committed across all Sinks, and the error is the first error
+// encountered.
+func (r *Router) Commit(rows []interface{}, label string) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	groups := make(map[string][]interface{}, 2)
+	suffixes := []string{}
+	for i := range rows {
+		s := r.fallback
+		if r.suffix != nil {
+			if d, ok := rowDate(rows[i]); ok {
+				s = r.suffix(d)
+			}
+		}
+		if _, ok := groups[s]; !ok {
+			suffixes = append(suffixes, s)
+		}
+		groups[s] = append(groups[s], rows[i])
+	}
+
+	total := 0
+	var firstErr error
+	for _, s := range suffixes {
+		sink, err := r.sinkFor(s)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		n, err := sink.Commit(groups[s], label)
+		total += n
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	r.rows += total
+	return total, firstErr
+}
+
+// Committed implements row.Counter.
+func (r *Router) Committed() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.rows
+}
+
+// Sync implements row.Syncer, syncing each per-suffix Sink that implements
+// it, and returns all of their errors.  Sinks created later start at the
+// entry too.
+func (r *Router) Sync(entry int) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.entry = entry
+	var errs []error
+	for _, s := range r.order {
+		if sy, ok := r.sinks[s].(row.Syncer); ok {
+			errs = append(errs, sy.Sync(entry))
+		}
+	}
+	return JoinErrors(errs...)
+}
+
+// Suffixes returns the suffixes that have been routed so far.
+func (r *Router) Suffixes() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]string{}, r.order...)
+}
+
+// Close closes all the per-suffix Sinks, and returns all of their errors.
+func (r *Router) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	mc := make(MultiCloser, 0, len(r.order))
+	for _, s := range r.order {
+		mc = append(mc, r.sinks[s])
+	}
+	return mc.Close()
+}
+
+// RoutingSinkFactory implements factory.SinkFactory, producing Routers that
+// write one GCS object per suffix, and a single manifest for all of them if
+// DefaultWriterOptions.Manifest is set.
+type RoutingSinkFactory struct {
+	client       stiface.Client
+	outputBucket string
+	suffix       SuffixFunc
+	byArchive    bool // Route all rows by the archive date, ignoring row Dates.
+}
+
+// Get implements factory.SinkFactory.
+func (sf *RoutingSinkFactory) Get(ctx context.Context, dp etl.DataPath) (row.Sink, etl.ProcessingError) {
+	// Rows without a Date are routed using the archive date.
+	date, err := time.Parse("20060102", dp.PackedDate)
+	if err != nil {
+		return nil, factory.NewError(dp.DataType, "RoutingSinkFactory",
+			http.StatusBadRequest, err)
+	}
+	fallback := sf.suffix(civil.DateOf(date))
+	m := newManifest(dp)
+	newSink := func(suffix string) (row.Sink, error) {
+		rw, err := newRowWriter(ctx, sf.client, sf.outputBucket,
+			path.Join(dp.Bucket, dp.Path+suffix+DefaultWriterOptions.Ext()), DefaultWriterOptions, m)
+		if err != nil {
+			return nil, err
+		}
+		return rw, nil
+	}
+	if sf.byArchive {
+		return withManifest(NewRouter(nil, fallback, newSink), m, sf.client, sf.outputBucket, dp), nil
+	}
+	return withManifest(NewRouter(sf.suffix, fallback, newSink), m, sf.client, sf.outputBucket, dp), nil
+}
+
+// NewRoutingSinkFactory returns a SinkFactory that routes rows to per-date GCS
+// objects, using the suffix function to name each object.
+func NewRoutingSinkFactory(client stiface.Client, outputBucket string, suffix SuffixFunc) factory.SinkFactory {
+	return &RoutingSinkFactory{client: client, outputBucket: outputBucket, suffix: suffix}
+}
+
+// SuffixSinkFactory implements factory.SinkFactory, choosing the suffix of the
+// GCS objects for each datatype by its etl.SuffixStrategy.  Datatypes with
+// NoSuffix are written to a single object, as by SinkFactory, and others are
+// routed as by RoutingSinkFactory.
+type SuffixSinkFactory struct {
+	client       stiface.Client
+	outputBucket string
+	strategy     etl.SuffixStrategy // For datatypes the etl.Config does not set.
+	byArchive    bool               // Route all rows by the archive date, ignoring row Dates.
+}
+
+// Get implements factory.SinkFactory.
+func (sf *SuffixSinkFactory) Get(ctx context.Context, dp etl.DataPath) (row.Sink, etl.ProcessingError) {
+	s := dp.GetDataType().SuffixStrategy(sf.strategy)
+	if s == "" || s == etl.NoSuffix {
+		f := SinkFactory{client: sf.client, outputBucket: sf.outputBucket}
+		return f.Get(ctx, dp)
+	}
+	f := RoutingSinkFactory{client: sf.client, outputBucket: sf.outputBucket,
+		suffix: s.Suffix, byArchive: sf.byArchive}
+	return f.Get(ctx, dp)
+}
+
+// NewSuffixSinkFactory returns a SinkFactory that names the GCS objects of each
+// datatype by the SuffixStrategy in the current etl.Config, or by strategy if
+// the Config does not set one.  If byArchive is set, all rows of an archive
+// are routed by the archive date, as by NewArchiveRoutingSinkFactory.
+func NewSuffixSinkFactory(client stiface.Client, outputBucket string, strategy etl.SuffixStrategy, byArchive bool) factory.SinkFactory {
+	return &SuffixSinkFactory{client: client, outputBucket: outputBucket,
+		strategy: strategy, byArchive: byArchive}
+}
+
+// NewArchiveRoutingSinkFactory returns a SinkFactory that writes all rows of
+// an archive to a single GCS object, named with the suffix for the archive's
+// date, regardless of the row Dates or the time they are written.  Archives
+// that arrive days late, e.g. retransmissions from nodes, are thus still loaded
+// into the partition for the day they were collected.
+func NewArchiveRoutingSinkFactory(client stiface.Client, outputBucket string, suffix SuffixFunc) factory.SinkFactory {
+	return &RoutingSinkFactory{client: client, outputBucket: outputBucket, suffix: suffix, byArchive: true}
+}
@@ -0,0 +1,176 @@
+package storage_test
+
+import (
+	"errors"
+	"testing"
+
+	"cloud.google.com/go/civil"
+	"github.com/go-test/deep"
+
+	"github.com/m-lab/etl/etl"
+	"github.com/m-lab/etl/row"
+	"github.com/m-lab/etl/storage"
+)
+
+type datedRow struct {
+	ID   string
+	Date civil.Date
+}
+
+type memSink struct {
+	rows   []interface{}
+	closed bool
+}
+
+func (m *memSink) Commit(rows []interface{}, label string) (int, error) {
+	m.rows = append(m.rows, rows...)
+	return len(rows), nil
+}
+
+func (m *memSink) Close() error {
+	m.closed = true
+	return nil
+}
+
+func TestSuffixFuncs(t *testing.T) {
+	d := civil.Date{Year: 2022, Month: 1, Day: 2}
+	if got := storage.TemplateSuffix(d); got != "_20220102" {
+		t.Errorf("TemplateSuffix() = %q, want _20220102", got)
+	}
+	if got := storage.PartitionSuffix(d); got != "$20220102" {
+		t.Errorf("PartitionSuffix() = %q, want $20220102", got)
+	}
+
+	defer func() { etl.IsBatch = false }()
+	etl.IsBatch = true
+	if got := storage.ModeSuffix(d); got != "_20220102" {
+		t.Errorf("ModeSuffix() in batch mode = %q, want _20220102", got)
+	}
+	etl.IsBatch = false
+	if got := storage.ModeSuffix(d); got != "$20220102" {
+		t.Errorf("ModeSuffix() in daily mode = %q, want $20220102", got)
+	}
+}
+
+func TestRouter(t *testing.T) {
+	sinks := map[string]*memSink{}
+	r := storage.NewRouter(storage.PartitionSuffix, "$20220101",
+		func(suffix string) (row.Sink, error) {
+			s := &memSink{}
+			sinks[suffix] = s
+			return s, nil
+		})
+
+	day1 := civil.Date{Year: 2022, Month: 1, Day: 1}
+	day2 := civil.Date{Year: 2022, Month: 1, Day: 2}
+	rows := []interface{}{
+		&datedRow{"a", day1},
+		datedRow{"b", day2},
+		&datedRow{"c", day1},
+		"no date",
+		&datedRow{"d", civil.Date{}}, // invalid date uses fallback.
+	}
+	n, err := r.Commit(rows, "label")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(rows) {
+		t.Errorf("Commit() = %d, want %d", n, len(rows))
+	}
+	if diff := deep.Equal(r.Suffixes(), []string{"$20220101", "$20220102"}); diff != nil {
+		t.Error(diff)
+	}
+	if len(sinks["$20220101"].rows) != 4 {
+		t.Errorf("day1 rows = %d, want 4", len(sinks["$20220101"].rows))
+	}
+	if sinks["$20220101"].rows[1].(*datedRow).ID != "c" {
+		t.Error("rows not committed in order:", sinks["$20220101"].rows)
+	}
+	if len(sinks["$20220102"].rows) != 1 {
+		t.Errorf("day2 rows = %d, want 1", len(sinks["$20220102"].rows))
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for s, sink := range sinks {
+		if !sink.closed {
+			t.Error("sink not closed:", s)
+		}
+	}
+}
+
+// syncSink records the entries at which it is synced.
+type syncSink struct {
+	memSink
+	synced []int
+}
+
+func (s *syncSink) Sync(entry int) error {
+	s.synced = append(s.synced, entry)
+	return nil
+}
+
+func TestRouterSync(t *testing.T) {
+	sinks := map[string]*syncSink{}
+	r := storage.NewRouter(storage.PartitionSuffix, "$20220101",
+		func(suffix string) (row.Sink, error) {
+			s := &syncSink{}
+			sinks[suffix] = s
+			return s, nil
+		})
+	day1 := civil.Date{Year: 2022, Month: 1, Day: 1}
+	day2 := civil.Date{Year: 2022, Month: 1, Day: 2}
+	if _, err := r.Commit([]interface{}{&datedRow{"a", day1}}, "label"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Sync(10); err != nil {
+		t.Fatal(err)
+	}
+	// A sink created after the Sync starts at the same entry.
+	if _, err := r.Commit([]interface{}{&datedRow{"b", day2}}, "label"); err != nil {
+		t.Fatal(err)
+	}
+	for s, want := range map[string][]int{"$20220101": {10}, "$20220102": {10}} {
+		if diff := deep.Equal(sinks[s].synced, want); diff != nil {
+			t.Errorf("%s synced at %v, want %v", s, sinks[s].synced, want)
+		}
+	}
+}
+
+func TestRouterSinkError(t *testing.T) {
+	wantErr := errors.New("no sink")
+	r := storage.NewRouter(storage.TemplateSuffix, "_20220101",
+		func(suffix string) (row.Sink, error) {
+			return nil, wantErr
+		})
+	n, err := r.Commit([]interface{}{"foo"}, "label")
+	if n != 0 || !errors.Is(err, wantErr) {
+		t.Errorf("Commit() = %d, %v; want 0, %v", n, err, wantErr)
+	}
+}
+
+func TestRouterArchiveDate(t *testing.T) {
+	sinks := map[string]*memSink{}
+	r := storage.NewRouter(nil, "$20220101",
+		func(suffix string) (row.Sink, error) {
+			s := &memSink{}
+			sinks[suffix] = s
+			return s, nil
+		})
+	rows := []interface{}{
+		&datedRow{"a", civil.Date{Year: 2022, Month: 1, Day: 2}},
+		"no date",
+	}
+	if _, err := r.Commit(rows, "label"); err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(r.Suffixes(), []string{"$20220101"}); diff != nil {
+		t.Error(diff)
+	}
+	if len(sinks["$20220101"].rows) != 2 {
+		t.Errorf("rows = %d, want 2", len(sinks["$20220101"].rows))
+	}
+	if got := r.Committed(); got != 2 {
+		t.Errorf("Committed() = %d, want 2", got)
+	}
+}
@@ -1,17 +1,23 @@
 package storage
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"path"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	gcs "cloud.google.com/go/storage"
 	"github.com/googleapis/google-cloud-go-testing/storage/stiface"
+	"github.com/klauspost/pgzip"
 	"google.golang.org/api/googleapi"
 
 	"github.com/m-lab/etl/etl"
@@ -20,17 +26,131 @@ import (
 	"github.com/m-lab/etl/row"
 )
 
-// RowWriter implements row.Sink to a GCS file backend.
+// WriterOptions configures the output of RowWriters.
+type WriterOptions struct {
+	// GzipLevel is the gzip compression level, from gzip.HuffmanOnly to
+	// gzip.BestCompression.  Zero, which is gzip.NoCompression, disables gzip.
+	GzipLevel int
+	// BufferSize is the size of the buffer in front of the gzip writer, so
+	// that the compressor sees large writes.  Zero disables the buffer.
+	BufferSize int
+	// FlushBytes is the number of uncompressed bytes after which the gzip
+	// stream is flushed to GCS.  Zero flushes only on Close.  Frequent flushes
+	// bound the data held by the compressor, at some cost in compression.
+	FlushBytes int
+	// ChunkSize is the GCS upload chunk size.
+	ChunkSize int
+	// GzipConcurrency is the number of blocks compressed in parallel.  Zero
+	// compresses on the calling goroutine, with compress/gzip.  Parallel
+	// compression holds up to twice GzipConcurrency blocks in memory.
+	GzipConcurrency int
+	// GzipBlockSize is the size of each block compressed in parallel, which
+	// must be more than 16KB.  Zero uses the pgzip default of 1MB.
+	GzipBlockSize int
+	// RotateBytes is the number of uncompressed bytes after which a RowWriter
+	// closes its object and continues in a new one.  Zero never rotates.
+	RotateBytes int
+	// Manifest enables writing a manifest, listing the objects written for
+	// each task, when the task's sink is closed.
+	Manifest bool
+}
+
+// Ext returns the object name extension for the options, ".jsonl" or
+// ".jsonl.gz".
+func (o WriterOptions) Ext() string {
+	if o.GzipLevel != gzip.NoCompression {
+		return ".jsonl.gz"
+	}
+	return ".jsonl"
+}
+
+// defaultGzipBlockSize is the pgzip default block size.
+const defaultGzipBlockSize = 1 << 20
+
+// minGzipBlockSize is the largest block size rejected by pgzip.
+const minGzipBlockSize = 16 << 10
+
+// Validate returns an error if the options would fail every RowWriter, so
+// that bad flags are reported at startup, rather than by each task.
+func (o WriterOptions) Validate() error {
+	if o.GzipLevel < gzip.HuffmanOnly || o.GzipLevel > gzip.BestCompression {
+		return etl.ErrValidation.Errorf("invalid gzip level %d", o.GzipLevel)
+	}
+	if o.GzipBlockSize != 0 && o.GzipBlockSize <= minGzipBlockSize {
+		return etl.ErrValidation.Errorf("gzip block size %d must be more than %d", o.GzipBlockSize, minGzipBlockSize)
+	}
+	return nil
+}
+
+// gzipWriter is implemented by both gzip.Writer and pgzip.Writer.
+type gzipWriter interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// newGzipWriter returns a gzip writer for w, which compresses in parallel if
+// opts.GzipConcurrency is positive.
+func newGzipWriter(w io.Writer, opts WriterOptions) (gzipWriter, error) {
+	if opts.GzipConcurrency <= 0 {
+		zw, err := gzip.NewWriterLevel(w, opts.GzipLevel)
+		if err != nil {
+			return nil, err
+		}
+		return zw, nil
+	}
+	zw, err := pgzip.NewWriterLevel(w, opts.GzipLevel)
+	if err != nil {
+		return nil, err
+	}
+	blockSize := opts.GzipBlockSize
+	if blockSize == 0 {
+		blockSize = defaultGzipBlockSize
+	}
+	if err := zw.SetConcurrency(blockSize, opts.GzipConcurrency); err != nil {
+		return nil, err
+	}
+	return zw, nil
+}
+
+// DefaultWriterOptions are the options used by NewRowWriter and the GCS sink
+// factories.  They should only be modified during initialization.
+var DefaultWriterOptions = WriterOptions{
+	// Set smaller chunk size to conserve memory.
+	ChunkSize: 4 * 1024 * 1024,
+}
+
+// RowWriter implements row.Sink to a GCS file backend.  If
+// WriterOptions.RotateBytes is set, the rows are written to a sequence of
+// objects, named by PartPath.  Each Sync starts a new sequence, named by
+// SegmentPath.
 type RowWriter struct {
-	w stiface.Writer
-	o stiface.ObjectHandle
-	a gcs.ObjectAttrsToUpdate
+	ctx    context.Context // Parent of the upload contexts.
+	client stiface.Client
+	w      stiface.Writer     // nil once the last object is closed.
+	cancel context.CancelFunc // Cancels w's context, abandoning the upload.
+	o      stiface.ObjectHandle
+	a      gcs.ObjectAttrsToUpdate
+
+	// out is the head of the writer chain, which may include a buffer and
+	// gzip writer in front of w.
+	out       io.Writer
+	buf       *bufio.Writer // Optional.
+	zw        gzipWriter    // Optional.
+	opts      WriterOptions
+	unflushed int // uncompressed bytes written since the last flush.
 
 	rows     int
+	objRows  int // rows written to the current object.
+	objBytes int // uncompressed bytes written to the current object.
 	writeErr error
+	retries  int64 // Failed GCS requests, updated atomically by the transport.
 
-	bucket string
-	path   string
+	bucket   string
+	first    string    // path of the first object of the task.
+	base     string    // path of the first object of the current segment.
+	path     string    // path of the current object.
+	part     int       // index of the current object.
+	manifest *Manifest // Optional, records each object closed.
 
 	// These act as tokens to serialize access to the writer.
 	// This allows concurrent encoding and writing, while ensuring
@@ -39,20 +159,120 @@ type RowWriter struct {
 	writing  chan struct{} // Token required for writing.
 }
 
-// NewRowWriter creates a RowWriter.
+// NewRowWriter creates a RowWriter using DefaultWriterOptions.
 func NewRowWriter(ctx context.Context, client stiface.Client, bucket string, path string) (row.Sink, error) {
-	b := client.Bucket(bucket)
-	o := b.Object(path)
-	w := o.NewWriter(ctx)
-	// Set smaller chunk size to conserve memory.
-	w.SetChunkSize(4 * 1024 * 1024)
+	return NewRowWriterWithOptions(ctx, client, bucket, path, DefaultWriterOptions)
+}
+
+// NewRowWriterWithOptions creates a RowWriter with the given options.
+func NewRowWriterWithOptions(ctx context.Context, client stiface.Client, bucket string, path string, opts WriterOptions) (row.Sink, error) {
+	rw, err := newRowWriter(ctx, client, bucket, path, opts, nil)
+	if err != nil {
+		return nil, err
+	}
+	return rw, nil
+}
+
+// newRowWriter creates a RowWriter that adds the objects it writes to m, if
+// m is not nil.
+func newRowWriter(ctx context.Context, client stiface.Client, bucket string, path string, opts WriterOptions, m *Manifest) (*RowWriter, error) {
+	rw := &RowWriter{client: client, bucket: bucket, first: path, base: path, opts: opts, manifest: m}
+	rw.ctx = withRetryCounter(ctx, &rw.retries)
+	if err := rw.open(); err != nil {
+		return nil, err
+	}
+
+	rw.encoding = make(chan struct{}, 1)
+	rw.encoding <- struct{}{}
+	rw.writing = make(chan struct{}, 1)
+	rw.writing <- struct{}{}
 
-	encoding := make(chan struct{}, 1)
-	encoding <- struct{}{}
-	writing := make(chan struct{}, 1)
-	writing <- struct{}{}
+	return rw, nil
+}
+
+// PartPath returns the path of the part'th object written by a RowWriter
+// created with path.  The first object is written to path itself, and later
+// ones have a "-00001" style part number before the extension, so that
+// "dir/name.jsonl.gz" is followed by "dir/name-00001.jsonl.gz".
+func (o WriterOptions) PartPath(path string, part int) string {
+	if part == 0 {
+		return path
+	}
+	ext := o.Ext()
+	if !strings.HasSuffix(path, ext) {
+		ext = ""
+	}
+	return fmt.Sprintf("%s-%05d%s", strings.TrimSuffix(path, ext), part, ext)
+}
 
-	return &RowWriter{bucket: bucket, path: path, o: o, w: w, encoding: encoding, writing: writing}, nil
+// SegmentPath returns the path of the first object written by a RowWriter
+// created with path after it is synced at the entry'th archive entry.  The
+// entry number is added before the extension, as "-e000100", so that
+// "dir/name.jsonl.gz" is followed by "dir/name-e000100.jsonl.gz", whose
+// later parts are named by PartPath.
+func (o WriterOptions) SegmentPath(path string, entry int) string {
+	if entry == 0 {
+		return path
+	}
+	ext := o.Ext()
+	if !strings.HasSuffix(path, ext) {
+		ext = ""
+	}
+	return fmt.Sprintf("%s-e%06d%s", strings.TrimSuffix(path, ext), entry, ext)
+}
+
+// open starts the upload of the current part.
+func (rw *RowWriter) open() error {
+	rw.path = rw.opts.PartPath(rw.base, rw.part)
+	rw.o = rw.client.Bucket(rw.bucket).Object(rw.path)
+	rw.objRows, rw.objBytes, rw.unflushed = 0, 0, 0
+	rw.buf, rw.zw = nil, nil
+	ctx, cancel := context.WithCancel(rw.ctx)
+	w := rw.o.NewWriter(ctx)
+	if rw.opts.ChunkSize > 0 {
+		w.SetChunkSize(rw.opts.ChunkSize)
+	}
+	rw.out = w
+	if rw.opts.GzipLevel != gzip.NoCompression {
+		zw, err := newGzipWriter(w, rw.opts)
+		if err != nil {
+			cancel()
+			return err
+		}
+		rw.zw = zw
+		rw.out = zw
+	}
+	if rw.opts.BufferSize > 0 {
+		rw.buf = bufio.NewWriterSize(rw.out, rw.opts.BufferSize)
+		rw.out = rw.buf
+	}
+	rw.w, rw.cancel = w, cancel
+	return nil
+}
+
+// rotate closes the current object, and opens the next part.
+// Caller must hold the writing token.
+func (rw *RowWriter) rotate() error {
+	if err := rw.closeObject(); err != nil {
+		return err
+	}
+	rw.part++
+	return rw.open()
+}
+
+// flush writes any buffered and compressed data through to the GCS writer.
+// Caller must hold the writing token.
+func (rw *RowWriter) flush() error {
+	rw.unflushed = 0
+	if rw.buf != nil {
+		if err := rw.buf.Flush(); err != nil {
+			return err
+		}
+	}
+	if rw.zw != nil {
+		return rw.zw.Flush()
+	}
+	return nil
 }
 
 // Acquire the encoding token.
@@ -108,7 +328,29 @@ func (rw *RowWriter) Commit(rows []interface{}, label string) (int, error) {
 	numBytes := buf.Len()
 	rw.swapForWritingToken()
 	defer rw.releaseWritingToken()
-	n, err := buf.WriteTo(rw.w) // This is buffered (by 4MB chunks).  Are the writes to GCS synchronous?
+	if rw.w != nil && rw.opts.RotateBytes > 0 && rw.objBytes >= rw.opts.RotateBytes {
+		lost := rw.objRows
+		if err := rw.rotate(); err != nil {
+			// The rows of the previous object are lost with its upload.
+			log.Println(err, "rotating", rw.bucket, rw.path)
+			metrics.BackendFailureCount.WithLabelValues(
+				label, "rotate error").Inc()
+			rw.writeErr = err
+			rw.rows -= lost
+		}
+	}
+	if rw.w == nil {
+		// A failed rotation left no object to write to.
+		return 0, &CommitError{Err: rw.writeErr, retries: int(atomic.LoadInt64(&rw.retries))}
+	}
+	n, err := buf.WriteTo(rw.out) // This is buffered (by 4MB chunks).  Are the writes to GCS synchronous?
+	rw.objBytes += int(n)
+	if err == nil && rw.opts.FlushBytes > 0 {
+		rw.unflushed += numBytes
+		if rw.unflushed >= rw.opts.FlushBytes {
+			err = rw.flush()
+		}
+	}
 	if err != nil {
 		rw.writeErr = err
 		switch typedErr := err.(type) {
@@ -131,15 +373,54 @@ func (rw *RowWriter) Commit(rows []interface{}, label string) (int, error) {
 		// See https://github.com/m-lab/etl/issues/899
 		rowEstimate := int(n) * len(rows) / numBytes
 		rw.rows += rowEstimate
-		return rowEstimate, err
+		rw.objRows += rowEstimate
+		return rowEstimate, &CommitError{Err: err, retries: int(atomic.LoadInt64(&rw.retries))}
 	}
 
 	// TODO - these may not be committed, so the returned value may be wrong.
 	rw.rows += len(rows)
+	rw.objRows += len(rows)
 	return len(rows), nil
 }
 
-// Close synchronizes on the tokens, and closes the backing file.
+// Committed implements row.Counter.  It waits for any write in progress, so it
+// must not be called after Close.
+func (rw *RowWriter) Committed() int {
+	<-rw.writing
+	defer rw.releaseWritingToken()
+	return rw.rows
+}
+
+// Sync implements row.Syncer.  It closes the current object, or drops it if
+// it has no rows, so that an empty object does not replace one written by an
+// earlier attempt, and continues in the objects of the entry's segment.
+func (rw *RowWriter) Sync(entry int) error {
+	<-rw.writing
+	defer rw.releaseWritingToken()
+	if rw.writeErr != nil {
+		return rw.writeErr
+	}
+	if rw.objRows == 0 {
+		// Nothing has been written to w, so its upload has not started, and
+		// it is simply dropped.  Closing it would publish an empty object.
+		rw.cancel()
+		rw.w = nil
+	} else if err := rw.closeObject(); err != nil {
+		rw.writeErr = err
+		return err
+	}
+	rw.base = rw.opts.SegmentPath(rw.first, entry)
+	rw.part = 0
+	if err := rw.open(); err != nil {
+		rw.writeErr = err
+		return err
+	}
+	return nil
+}
+
+// Close synchronizes on the tokens, and closes the backing file.  If the
+// buffered data cannot be written, the upload is abandoned, so that no
+// truncated object is published.
 func (rw *RowWriter) Close() error {
 	// Take BOTH tokens, to ensure no other goroutines are still running.
 	<-rw.encoding
@@ -148,8 +429,32 @@ func (rw *RowWriter) Close() error {
 	close(rw.encoding)
 	close(rw.writing)
 
+	if rw.w == nil {
+		return rw.writeErr
+	}
+	return rw.closeObject()
+}
+
+// closeObject closes the current object, and records it in the manifest.
+// Caller must hold the writing token.
+func (rw *RowWriter) closeObject() error {
+	w := rw.w
+	rw.w = nil
+	defer rw.cancel()
+
 	log.Println("Closing", rw.bucket, rw.path)
-	err := rw.w.Close()
+	err := rw.flush()
+	if err == nil && rw.zw != nil {
+		err = rw.zw.Close()
+	}
+	if err != nil {
+		log.Println(err, "abandoning", rw.bucket, rw.path)
+		// Canceling the context before Close abandons the upload.
+		rw.cancel()
+		w.Close()
+		return err
+	}
+	err = w.Close()
 	if err != nil {
 		log.Println(err)
 		return err
@@ -157,7 +462,7 @@ func (rw *RowWriter) Close() error {
 
 	oa := gcs.ObjectAttrsToUpdate{}
 	oa.Metadata = make(map[string]string, 1)
-	oa.Metadata["rows"] = fmt.Sprint(rw.rows)
+	oa.Metadata["rows"] = fmt.Sprint(rw.objRows)
 	if rw.writeErr != nil {
 		oa.Metadata["writeError"] = rw.writeErr.Error()
 	}
@@ -166,8 +471,18 @@ func (rw *RowWriter) Close() error {
 	defer cancel()
 	attr, err := rw.o.Update(ctx, oa)
 	log.Println(attr, err)
-	return err
-
+	if err != nil {
+		return err
+	}
+	if rw.manifest != nil {
+		var size int64
+		if wa := w.Attrs(); wa != nil {
+			size = wa.Size
+		}
+		rw.manifest.add(ManifestObject{
+			Name: "gs://" + rw.bucket + "/" + rw.path, Rows: rw.objRows, Bytes: size})
+	}
+	return nil
 }
 
 // SinkFactory implements factory.SinkFactory.
@@ -176,14 +491,17 @@ type SinkFactory struct {
 	outputBucket string
 }
 
-// Get implements factory.SinkFactory
+// Get implements factory.SinkFactory.  If DefaultWriterOptions.Manifest is
+// set, the sink writes a manifest of its objects when it is closed.
 func (sf *SinkFactory) Get(ctx context.Context, dp etl.DataPath) (row.Sink, etl.ProcessingError) {
-	s, err := NewRowWriter(ctx, sf.client, sf.outputBucket, path.Join(dp.Bucket, dp.Path+".jsonl"))
+	m := newManifest(dp)
+	s, err := newRowWriter(ctx, sf.client, sf.outputBucket,
+		path.Join(dp.Bucket, dp.Path+DefaultWriterOptions.Ext()), DefaultWriterOptions, m)
 	if err != nil {
 		return nil, factory.NewError(dp.DataType, "SinkFactory",
 			http.StatusInternalServerError, err)
 	}
-	return s, nil
+	return withManifest(s, m, sf.client, sf.outputBucket, dp), nil
 }
 
 // NewSinkFactory returns the default SinkFactory
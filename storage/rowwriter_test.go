@@ -1,8 +1,14 @@
 package storage_test
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"runtime"
 	"testing"
 	"time"
 
@@ -11,6 +17,7 @@ import (
 
 	fgs "github.com/fsouza/fake-gcs-server/fakestorage"
 
+	"github.com/m-lab/etl/row"
 	"github.com/m-lab/etl/storage"
 )
 
@@ -59,3 +66,348 @@ func TestRowWriter(t *testing.T) {
 		t.Error(diff)
 	}
 }
+
+// readObject returns the content of the object, decompressed if gzipped.
+func readObject(t testing.TB, c *fgs.Server, bucket, file string) []byte {
+	o := c.Client().Bucket(bucket).Object(file)
+	reader, err := o.NewReader(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) < 2 || data[0] != 0x1f || data[1] != 0x8b {
+		return data
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+type snapshot struct {
+	Timestamp                         time.Time
+	State, CAState, Retransmits       int64
+	RTO, ATO, SndMSS, RcvMSS          int64
+	Unacked, Sacked, Lost, Retrans    int64
+	LastDataSent, LastAckRecv         int64
+	PMTU, RcvSsThresh, RTT, RTTVar    int64
+	SndSsThresh, SndCwnd, AdvMSS      int64
+	BytesAcked, BytesReceived, MinRTT int64
+}
+
+type tcpinfoRow struct {
+	UUID      string
+	Snapshots []snapshot
+}
+
+// tcpinfoRows returns n large rows, similar to tcpinfo rows.
+func tcpinfoRows(n int) []interface{} {
+	rows := make([]interface{}, n)
+	t0 := time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC)
+	for i := range rows {
+		r := tcpinfoRow{UUID: fmt.Sprintf("ndt-abcde_1656633600_%016X", i)}
+		for j := int64(0); j < 100; j++ {
+			r.Snapshots = append(r.Snapshots, snapshot{
+				Timestamp: t0.Add(time.Duration(j) * 10 * time.Millisecond),
+				State:     1, RTO: 204000, ATO: 40000, SndMSS: 1448, RcvMSS: 536,
+				RTT: 3000 + j*7, RTTVar: 1500 + j%5, SndCwnd: 10 + j,
+				BytesAcked: j * 14480, BytesReceived: 512, MinRTT: 2900,
+			})
+		}
+		rows[i] = r
+	}
+	return rows
+}
+
+func TestRowWriterWithOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opts storage.WriterOptions
+		ext  string
+	}{
+		{"default", storage.DefaultWriterOptions, ".jsonl"},
+		{"gzip", storage.WriterOptions{GzipLevel: gzip.BestSpeed}, ".jsonl.gz"},
+		{"gzip-buffered", storage.WriterOptions{GzipLevel: gzip.DefaultCompression, BufferSize: 64 * 1024}, ".jsonl.gz"},
+		{"gzip-flushed", storage.WriterOptions{GzipLevel: gzip.HuffmanOnly, BufferSize: 1024, FlushBytes: 10000}, ".jsonl.gz"},
+		{"pgzip", storage.WriterOptions{GzipLevel: gzip.BestSpeed, GzipConcurrency: 4, GzipBlockSize: 32 * 1024}, ".jsonl.gz"},
+		{"pgzip-flushed", storage.WriterOptions{GzipLevel: gzip.DefaultCompression, GzipConcurrency: 2, FlushBytes: 10000}, ".jsonl.gz"},
+	}
+	rows := tcpinfoRows(10)
+	want := &bytes.Buffer{}
+	for _, r := range rows {
+		j, _ := json.Marshal(r)
+		want.Write(j)
+		want.WriteByte('\n')
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.opts.Ext() != tt.ext {
+				t.Errorf("Ext() = %q, want %q", tt.opts.Ext(), tt.ext)
+			}
+			server := fgs.NewServer([]fgs.Object{})
+			defer server.Stop()
+			server.CreateBucket("fake-bucket")
+
+			rw, err := storage.NewRowWriterWithOptions(context.Background(),
+				stiface.AdaptClient(server.Client()), "fake-bucket", "file", tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range rows {
+				if _, err := rw.Commit(rows[i:i+1], "fake-label"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := rw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if got := readObject(t, server, "fake-bucket", "file"); !bytes.Equal(got, want.Bytes()) {
+				t.Errorf("RowWriter wrote %d bytes, want %d", len(got), want.Len())
+			}
+		})
+	}
+	server := fgs.NewServer([]fgs.Object{})
+	defer server.Stop()
+	if _, err := storage.NewRowWriterWithOptions(context.Background(),
+		stiface.AdaptClient(server.Client()), "fake-bucket", "file",
+		storage.WriterOptions{GzipLevel: 42}); err == nil {
+		t.Error("NewRowWriterWithOptions() expected error for invalid level")
+	}
+	if _, err := storage.NewRowWriterWithOptions(context.Background(),
+		stiface.AdaptClient(server.Client()), "fake-bucket", "file",
+		storage.WriterOptions{GzipLevel: gzip.BestSpeed, GzipConcurrency: 2, GzipBlockSize: 10}); err == nil {
+		t.Error("NewRowWriterWithOptions() expected error for invalid block size")
+	}
+}
+
+func BenchmarkRowWriter(b *testing.B) {
+	benchmarks := []struct {
+		name string
+		opts storage.WriterOptions
+	}{
+		{"uncompressed", storage.DefaultWriterOptions},
+		{"gzip-default", storage.WriterOptions{GzipLevel: gzip.DefaultCompression}},
+		{"gzip-speed", storage.WriterOptions{GzipLevel: gzip.BestSpeed}},
+		{"gzip-huffman", storage.WriterOptions{GzipLevel: gzip.HuffmanOnly}},
+		{"gzip-speed-buffered", storage.WriterOptions{GzipLevel: gzip.BestSpeed, BufferSize: 256 * 1024}},
+		{"gzip-speed-flushed", storage.WriterOptions{GzipLevel: gzip.BestSpeed, FlushBytes: 1024 * 1024}},
+		{"pgzip-speed", storage.WriterOptions{GzipLevel: gzip.BestSpeed, GzipConcurrency: runtime.GOMAXPROCS(0)}},
+		{"pgzip-default", storage.WriterOptions{GzipLevel: gzip.DefaultCompression, GzipConcurrency: runtime.GOMAXPROCS(0)}},
+	}
+	rows := tcpinfoRows(100)
+	server := fgs.NewServer([]fgs.Object{})
+	defer server.Stop()
+	server.CreateBucket("fake-bucket")
+	client := stiface.AdaptClient(server.Client())
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				rw, err := storage.NewRowWriterWithOptions(context.Background(),
+					client, "fake-bucket", fmt.Sprint(bm.name, i), bm.opts)
+				if err != nil {
+					b.Fatal(err)
+				}
+				for j := 0; j < len(rows); j += 10 {
+					if _, err := rw.Commit(rows[j:j+10], "fake-label"); err != nil {
+						b.Fatal(err)
+					}
+				}
+				if err := rw.Close(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// failingWriter fails every Write, and records whether its context was
+// canceled, abandoning the upload, before Close.
+type failingWriter struct {
+	stiface.Writer
+	ctx       context.Context
+	abandoned bool
+}
+
+func (fw *failingWriter) SetChunkSize(int) {}
+
+func (fw *failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func (fw *failingWriter) Close() error {
+	fw.abandoned = fw.ctx.Err() != nil
+	return fw.ctx.Err()
+}
+
+type failingObject struct {
+	stiface.ObjectHandle
+	w *failingWriter
+}
+
+func (fo *failingObject) NewWriter(ctx context.Context) stiface.Writer {
+	fo.w = &failingWriter{ctx: ctx}
+	return fo.w
+}
+
+type failingBucket struct {
+	stiface.BucketHandle
+	o *failingObject
+}
+
+func (fb *failingBucket) Object(string) stiface.ObjectHandle {
+	return fb.o
+}
+
+type failingClient struct {
+	stiface.Client
+	b *failingBucket
+}
+
+func (fc *failingClient) Bucket(string) stiface.BucketHandle {
+	return fc.b
+}
+
+func TestRowWriterCloseFailure(t *testing.T) {
+	o := &failingObject{}
+	c := &failingClient{b: &failingBucket{o: o}}
+	rw, err := storage.NewRowWriterWithOptions(context.Background(), c, "fake-bucket", "file",
+		storage.WriterOptions{GzipLevel: gzip.BestSpeed, BufferSize: 64 * 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The row is buffered, so the write fails only when Close flushes it.
+	if _, err := rw.Commit(tcpinfoRows(1), "fake-label"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rw.Close(); err == nil {
+		t.Error("Close() expected error")
+	}
+	if !o.w.abandoned {
+		t.Error("Close() published the object instead of abandoning the upload")
+	}
+}
+
+func TestRowWriter_Rotate(t *testing.T) {
+	opts := storage.WriterOptions{GzipLevel: gzip.BestSpeed, RotateBytes: 1}
+	if got := opts.PartPath("dir/file.jsonl.gz", 0); got != "dir/file.jsonl.gz" {
+		t.Errorf("PartPath(0) = %q", got)
+	}
+	if got := opts.PartPath("dir/file.jsonl.gz", 2); got != "dir/file-00002.jsonl.gz" {
+		t.Errorf("PartPath(2) = %q", got)
+	}
+	if got := opts.PartPath("dir/file", 1); got != "dir/file-00001" {
+		t.Errorf("PartPath(1) = %q", got)
+	}
+
+	server := fgs.NewServer([]fgs.Object{})
+	defer server.Stop()
+	server.CreateBucket("fake-bucket")
+	rw, err := storage.NewRowWriterWithOptions(context.Background(),
+		stiface.AdaptClient(server.Client()), "fake-bucket", "file.jsonl.gz", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each commit exceeds RotateBytes, so the next one starts a new object.
+	rows := tcpinfoRows(3)
+	for i := range rows {
+		if _, err := rw.Commit(rows[i:i+1], "fake-label"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := rw.(row.Counter).Committed(); n != 3 {
+		t.Errorf("Committed() = %d, want 3", n)
+	}
+	if err := rw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for i := range rows {
+		name := opts.PartPath("file.jsonl.gz", i)
+		want, _ := json.Marshal(rows[i])
+		if got := readObject(t, server, "fake-bucket", name); !bytes.Equal(got, append(want, '\n')) {
+			t.Errorf("%s has %d bytes, want %d", name, len(got), len(want)+1)
+		}
+		attrs, err := server.Client().Bucket("fake-bucket").Object(name).Attrs(context.Background())
+		if err != nil || attrs.Metadata["rows"] != "1" {
+			t.Errorf("%s has metadata %v, %v; want 1 row", name, attrs, err)
+		}
+	}
+	// No empty object follows the last commit.
+	if _, err := server.GetObject("fake-bucket", opts.PartPath("file.jsonl.gz", 3)); err == nil {
+		t.Error("RowWriter wrote an empty last object")
+	}
+}
+
+func TestRowWriter_Sync(t *testing.T) {
+	opts := storage.WriterOptions{}
+	if got := opts.SegmentPath("dir/file.jsonl", 0); got != "dir/file.jsonl" {
+		t.Errorf("SegmentPath(0) = %q", got)
+	}
+	if got := opts.SegmentPath("dir/file.jsonl", 100); got != "dir/file-e000100.jsonl" {
+		t.Errorf("SegmentPath(100) = %q", got)
+	}
+
+	server := fgs.NewServer([]fgs.Object{})
+	defer server.Stop()
+	server.CreateBucket("fake-bucket")
+	rw, err := storage.NewRowWriterWithOptions(context.Background(),
+		stiface.AdaptClient(server.Client()), "fake-bucket", "file.jsonl", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sy := rw.(row.Syncer)
+	// The empty first object is dropped, rather than published.
+	if err := sy.Sync(2); err != nil {
+		t.Fatal(err)
+	}
+	rows := tcpinfoRows(2)
+	if _, err := rw.Commit(rows[:1], "fake-label"); err != nil {
+		t.Fatal(err)
+	}
+	// The synced object is published before Close.
+	if err := sy.Sync(4); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := json.Marshal(rows[0])
+	if got := readObject(t, server, "fake-bucket", "file-e000002.jsonl"); !bytes.Equal(got, append(want, '\n')) {
+		t.Errorf("file-e000002.jsonl has %d bytes, want %d", len(got), len(want)+1)
+	}
+	if _, err := rw.Commit(rows[1:], "fake-label"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want, _ = json.Marshal(rows[1])
+	if got := readObject(t, server, "fake-bucket", "file-e000004.jsonl"); !bytes.Equal(got, append(want, '\n')) {
+		t.Errorf("file-e000004.jsonl has %d bytes, want %d", len(got), len(want)+1)
+	}
+	if _, err := server.GetObject("fake-bucket", "file.jsonl"); err == nil {
+		t.Error("Sync published an empty object")
+	}
+}
+
+func TestWriterOptions_Validate(t *testing.T) {
+	for _, tc := range []struct {
+		opts storage.WriterOptions
+		ok   bool
+	}{
+		{storage.WriterOptions{}, true},
+		{storage.WriterOptions{GzipLevel: gzip.HuffmanOnly}, true},
+		{storage.WriterOptions{GzipLevel: 10}, false},
+		{storage.WriterOptions{GzipConcurrency: 2, GzipBlockSize: 16 << 10}, false},
+		{storage.WriterOptions{GzipConcurrency: 2, GzipBlockSize: 16<<10 + 1}, true},
+	} {
+		if err := tc.opts.Validate(); (err == nil) != tc.ok {
+			t.Errorf("Validate(%+v) = %v, want ok %v", tc.opts, err, tc.ok)
+		}
+	}
+}
@@ -23,11 +23,13 @@ import (
 	"cloud.google.com/go/civil"
 	gcs "cloud.google.com/go/storage"
 	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
 
 	"github.com/googleapis/google-cloud-go-testing/storage/stiface"
 	"github.com/m-lab/etl/etl"
 	"github.com/m-lab/etl/factory"
 	"github.com/m-lab/etl/metrics"
+	"github.com/m-lab/etl/retry"
 )
 
 // ErrOversizeFile is returned when exceptionally large files are skipped.
@@ -48,6 +50,11 @@ type GCSSource struct {
 	RetryBaseTime time.Duration // The base time for backoff and retry.
 	TableBase     string        // TableBase is BQ table associated with this source, or "invalid".
 	PathDate      civil.Date    // Date associated with YYYY/MM/DD in FilePath.
+
+	peeked  *tar.Header // Header read by Peek, for the next NextTest.
+	peekErr error       // Error reading the peeked header.
+	modTime time.Time   // ModTime of the test most recently returned.
+	size    int64       // Archived size of the test most recently returned.
 }
 
 // Retrieve next file header.
@@ -141,6 +148,54 @@ func (src *GCSSource) Date() civil.Date {
 	return src.PathDate
 }
 
+// ModTime implements etl.ModTimer, using the tar header of the test.
+func (src *GCSSource) ModTime() time.Time {
+	return src.modTime
+}
+
+// FileSize implements etl.FileSizer, using the tar header of the test.
+func (src *GCSSource) FileSize() int64 {
+	return src.size
+}
+
+// readHeader reads the next tar header, with retries.
+func (src *GCSSource) readHeader(backoff retry.Backoff) (*tar.Header, error) {
+	var h *tar.Header
+	err := backoff.Do(context.Background(), func(trial int) error {
+		var retryable bool
+		var err error
+		h, retryable, err = src.nextHeader(trial)
+		if err != nil && !retryable {
+			return retry.Stop(err)
+		}
+		return err
+	})
+	return h, err
+}
+
+// backoff returns the retry policy for reading from the source.
+// With default RetryBaseTime, the last trial will be after total delay of
+// about 32ms + 64ms + ... + 8192ms, or about 16 seconds.
+func (src *GCSSource) backoff() retry.Backoff {
+	return retry.Backoff{
+		Base:        2 * src.RetryBaseTime,
+		Jitter:      0.1,
+		MaxAttempts: 10,
+	}
+}
+
+// Peek implements etl.Peeker.  It reads the next tar header, but not the
+// content, which the following NextTest reads.
+func (src *GCSSource) Peek() (string, int64, bool) {
+	if src.peeked == nil && src.peekErr == nil {
+		src.peeked, src.peekErr = src.readHeader(src.backoff())
+	}
+	if src.peekErr != nil {
+		return "", 0, false
+	}
+	return src.peeked.Name, src.peeked.Size, true
+}
+
 // NextTest reads the next test object from the tar file.
 // Skips reading contents of any file larger than maxSize, returning empty data
 // and storage.ErrOversizeFile.
@@ -151,29 +206,18 @@ func (src *GCSSource) NextTest(maxSize int64) (string, []byte, error) {
 
 	// Try to get the next file.  We retry multiple times, because sometimes
 	// GCS stalls and produces stream errors.
-	var err error
 	var data []byte
-	var h *tar.Header
-
-	// With default RetryBaseTime, the last trial will be after total delay of
-	// 16ms + 32ms + ... + 8192ms, or about 15 seconds.
-	// TODO - should add a random element to the backoff?
-	trial := 0
-	delay := src.RetryBaseTime
-	for {
-		trial++
-		var retry bool
-		h, retry, err = src.nextHeader(trial)
-		if err == nil {
-			break
-		}
-		if !retry || trial >= 10 {
-			return "", nil, err
-		}
-		// For each trial, increase backoff delay by 2x.
-		delay *= 2
-		time.Sleep(delay)
+	backoff := src.backoff()
+	h, err := src.peeked, src.peekErr
+	if h == nil && err == nil {
+		h, err = src.readHeader(backoff)
 	}
+	src.peeked, src.peekErr = nil, nil
+	src.modTime, src.size = time.Time{}, -1
+	if err != nil {
+		return "", nil, err
+	}
+	src.modTime, src.size = h.ModTime, h.Size
 
 	if h.Size > maxSize {
 		return h.Name, data, ErrOversizeFile
@@ -184,25 +228,18 @@ func (src *GCSSource) NextTest(maxSize int64) (string, []byte, error) {
 		return h.Name, data, nil
 	}
 
-	trial = 0
-	delay = src.RetryBaseTime
-	for {
-		trial++
-		var retry bool
-		data, retry, err = src.nextData(h, trial)
-		if err == nil {
-			break
-		}
-		if !retry || trial >= 10 {
-			// FYI, it appears that stream errors start in the
-			// nextData phase of reading, but then persist on
-			// the next call to nextHeader.
-			break
+	// FYI, it appears that stream errors start in the nextData phase of
+	// reading, but then persist on the next call to nextHeader, so an
+	// error here is reported by the following call to NextTest.
+	_ = backoff.Do(context.Background(), func(trial int) error {
+		var retryable bool
+		var err error
+		data, retryable, err = src.nextData(h, trial)
+		if err != nil && !retryable {
+			return retry.Stop(err)
 		}
-		// For each trial, increase backoff delay by 2x.
-		delay *= 2
-		time.Sleep(delay)
-	}
+		return err
+	})
 
 	return h.Name, data, nil
 }
@@ -214,17 +251,10 @@ type Closer struct {
 	cancel func()    // Context cancel.
 }
 
-// Close invokes the gzip and body Close() functions.
+// Close invokes the gzip and body Close() functions, and returns both errors.
 func (t *Closer) Close() error {
 	defer t.cancel()
-	var err error
-	if t.zipper != nil {
-		err = t.zipper.Close()
-		t.rdr.Close() // ignoring this error?
-	} else {
-		err = t.rdr.Close()
-	}
-	return err
+	return MultiCloser{t.zipper, t.rdr}.Close()
 }
 
 var errNoClient = errors.New("client should be non-null")
@@ -240,20 +270,20 @@ func NewTestSource(client stiface.Client, dp etl.DataPath, label string) (etl.Te
 	}
 	// For now only handle gcs paths.
 	if !strings.HasPrefix(dp.URI, "gs://") {
-		return nil, errors.New("invalid file path: " + dp.URI)
+		return nil, etl.ErrValidation.Errorf("invalid file path: %s", dp.URI)
 	}
 	bucket := dp.Bucket
 	fn := dp.Path
 
 	archiveDate, err := time.Parse("2006/01/02", dp.DatePath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse archive date path: %w", err)
+		return nil, etl.ErrValidation.Errorf("failed to parse archive date path: %w", err)
 	}
 
 	// TODO - consider just always testing for valid gzip file.
 	if !(strings.HasSuffix(fn, ".tgz") || strings.HasSuffix(fn, ".tar") ||
 		strings.HasSuffix(fn, ".tar.gz")) {
-		return nil, errors.New("not tar or tgz: " + dp.URI)
+		return nil, etl.ErrValidation.Errorf("not tar or tgz: %s", dp.URI)
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -265,7 +295,7 @@ func NewTestSource(client stiface.Client, dp etl.DataPath, label string) (etl.Te
 	if err != nil {
 		cancel()
 		log.Println(err)
-		return nil, err
+		return nil, etl.ErrGCS.Errorf("opening %s: %w", dp.URI, err)
 	}
 
 	closer := &Closer{nil, rdr, cancel}
@@ -275,13 +305,21 @@ func NewTestSource(client stiface.Client, dp etl.DataPath, label string) (etl.Te
 		// NB: This must not be :=, or it creates local rdr.
 		// TODO - add retries with backoff.
 		gzRdr, err := gzip.NewReader(rdr)
-		if err != nil {
+		switch {
+		case err == io.EOF:
+			// A zero length archive, e.g. a placeholder uploaded by a node
+			// with nothing to archive.  It holds no tests, so read it as an
+			// empty tar file, rather than failing, and retrying, the task.
+			log.Printf("Empty archive: %s", dp.URI)
+			rdr = io.NopCloser(strings.NewReader(""))
+		case err != nil:
 			closer.Close()
 			log.Println(err)
 			return nil, err
+		default:
+			closer.zipper = gzRdr
+			rdr = gzRdr
 		}
-		closer.zipper = gzRdr
-		rdr = gzRdr
 	}
 	tarReader := tar.NewReader(rdr)
 
@@ -298,9 +336,11 @@ func NewTestSource(client stiface.Client, dp etl.DataPath, label string) (etl.Te
 	return gcs, nil
 }
 
-// GetStorageClient provides a storage reader client.
+// GetStorageClient provides a storage reader client.  If BillingProject is
+// set, all bucket requests are billed to it.  Options, e.g. credentials, are
+// added to the client options.
 // This contacts the backend server, so should be used infrequently.
-func GetStorageClient(writeAccess bool) (stiface.Client, error) {
+func GetStorageClient(writeAccess bool, opts ...option.ClientOption) (stiface.Client, error) {
 	var scope string
 	if writeAccess {
 		scope = gcs.ScopeReadWrite
@@ -310,15 +350,23 @@ func GetStorageClient(writeAccess bool) (stiface.Client, error) {
 
 	// This cannot include a defer cancel, as the client then doesn't work after
 	// the cancel.
-	client, err := gcs.NewClient(context.Background(), option.WithScopes(scope))
+	ctx := context.Background()
+	opts = append([]option.ClientOption{option.WithScopes(scope)}, opts...)
+	trans, err := htransport.NewTransport(ctx, http.DefaultTransport, opts...)
+	if err != nil {
+		return nil, err
+	}
+	// Count failed requests, so that sinks can report retries.
+	hc := &http.Client{Transport: &retryCountingTransport{base: trans}}
+	client, err := gcs.NewClient(ctx, option.WithHTTPClient(hc))
 	if err != nil {
 		return nil, err
 	}
-	return stiface.AdaptClient(client), nil
+	return WithBillingProject(stiface.AdaptClient(client), BillingProject), nil
 }
 
 type gcsSourceFactory struct {
-	client stiface.Client
+	clients *SourceClients
 }
 
 // Get implements SourceFactory.Get
@@ -331,7 +379,24 @@ func (sf *gcsSourceFactory) Get(ctx context.Context, dp etl.DataPath) (etl.TestS
 			http.StatusInternalServerError, etl.ErrBadDataType)
 	}
 
-	tr, err := NewTestSource(sf.client, dp, label)
+	client, err := sf.clients.Client(dp.Bucket)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return nil, factory.NewError(dp.DataType, "ForbiddenBucket",
+			http.StatusForbidden, err)
+	}
+	if err := sf.clients.CheckRegion(ctx, client, dp.Bucket); err != nil {
+		log.Printf("ERROR: %v", err)
+		if errors.Is(err, ErrWrongRegion) {
+			return nil, factory.NewError(dp.DataType, "WrongRegion",
+				http.StatusForbidden, err)
+		}
+		return nil, factory.NewError(dp.DataType, "ETLSourceError",
+			http.StatusInternalServerError,
+			fmt.Errorf("ETLSourceError %w", err))
+	}
+
+	tr, err := NewTestSource(client, dp, label)
 	if err != nil {
 		log.Printf("ERROR: opening gcs file: %v", err)
 		// TODO - anything better we could do here?
@@ -345,7 +410,14 @@ func (sf *gcsSourceFactory) Get(ctx context.Context, dp etl.DataPath) (etl.TestS
 
 // GCSSourceFactory returns the default SourceFactory
 func GCSSourceFactory(c stiface.Client) factory.SourceFactory {
-	return &gcsSourceFactory{c}
+	return &gcsSourceFactory{NewSourceClients(c)}
+}
+
+// MultiBucketSourceFactory returns a SourceFactory that reads each archive
+// with the client for its bucket, and rejects archives from buckets that are
+// not allowed.
+func MultiBucketSourceFactory(sc *SourceClients) factory.SourceFactory {
+	return &gcsSourceFactory{sc}
 }
 
 //---------------------------------------------------------------------------------
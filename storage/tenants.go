@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/googleapis/google-cloud-go-testing/storage/stiface"
+
+	"github.com/m-lab/etl/etl"
+)
+
+// ErrBucketNotAllowed is returned for source buckets that are not in the
+// allow-list of a SourceClients.
+var ErrBucketNotAllowed = errors.New("source bucket not allowed")
+
+// SourceClients selects the client used to read each source bucket, so that a
+// single worker can parse archives from several buckets and projects, e.g.
+// archives contributed by third parties, using credentials scoped to each.
+// Only buckets that have been added are allowed, unless none have been, in
+// which case every bucket is read with the default client.
+type SourceClients struct {
+	def     stiface.Client
+	buckets map[string]stiface.Client
+
+	lock    sync.Mutex
+	regions map[string]error // Result of CheckRegion for each bucket.
+}
+
+// NewSourceClients creates a SourceClients that reads allowed buckets with
+// def, unless they are added with their own client.
+func NewSourceClients(def stiface.Client) *SourceClients {
+	return &SourceClients{
+		def:     def,
+		buckets: map[string]stiface.Client{},
+		regions: map[string]error{},
+	}
+}
+
+// Add allows the bucket, to be read with client, or with the default client
+// if client is nil.
+func (sc *SourceClients) Add(bucket string, client stiface.Client) {
+	if client == nil {
+		client = sc.def
+	}
+	sc.buckets[bucket] = client
+}
+
+// Client returns the client for reading the bucket, or ErrBucketNotAllowed.
+func (sc *SourceClients) Client(bucket string) (stiface.Client, error) {
+	if len(sc.buckets) == 0 {
+		return sc.def, nil
+	}
+	c, ok := sc.buckets[bucket]
+	if !ok {
+		return nil, etl.ErrValidation.Errorf("%w: %s", ErrBucketNotAllowed, bucket)
+	}
+	return c, nil
+}
+
+// CheckRegion returns ErrWrongRegion if the bucket, read with client, is not
+// in etl.Region.  The location of each bucket is looked up only once, unless
+// the lookup fails.
+func (sc *SourceClients) CheckRegion(ctx context.Context, client stiface.Client, bucket string) error {
+	if etl.Region == "" {
+		return nil
+	}
+	sc.lock.Lock()
+	err, ok := sc.regions[bucket]
+	sc.lock.Unlock()
+	if ok {
+		return err
+	}
+	err = CheckBucketRegion(ctx, client, bucket)
+	if err != nil && !errors.Is(err, ErrWrongRegion) {
+		return err
+	}
+	sc.lock.Lock()
+	sc.regions[bucket] = err
+	sc.lock.Unlock()
+	return err
+}
+
+// ParseSourceBucket parses a source bucket specification, of the form
+// "bucket" or "bucket=credentials.json", where the credentials file holds
+// the service account key used to read that bucket.
+func ParseSourceBucket(spec string) (bucket string, credentials string, err error) {
+	bucket, credentials, _ = strings.Cut(spec, "=")
+	if bucket == "" || strings.ContainsAny(bucket, "/ ") {
+		return "", "", fmt.Errorf("invalid source bucket: %q", spec)
+	}
+	return bucket, credentials, nil
+}
@@ -0,0 +1,89 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/googleapis/google-cloud-go-testing/storage/stiface"
+
+	"github.com/m-lab/etl/etl"
+	"github.com/m-lab/etl/storage"
+)
+
+func TestSourceClients(t *testing.T) {
+	def := &fakeClient{}
+	thirdParty := &fakeClient{}
+	sc := storage.NewSourceClients(def)
+
+	// Without an allow-list, all buckets use the default client.
+	if c, err := sc.Client("any-bucket"); err != nil || c != stiface.Client(def) {
+		t.Errorf("Client(any-bucket) = %v, %v; want default client", c, err)
+	}
+
+	sc.Add("archive-measurement-lab", nil)
+	sc.Add("contributed-archives", thirdParty)
+	tests := []struct {
+		bucket  string
+		want    stiface.Client
+		wantErr error
+	}{
+		{bucket: "archive-measurement-lab", want: def},
+		{bucket: "contributed-archives", want: thirdParty},
+		{bucket: "any-bucket", wantErr: storage.ErrBucketNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.bucket, func(t *testing.T) {
+			c, err := sc.Client(tt.bucket)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Client() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, etl.ErrValidation) {
+				t.Errorf("Client() error = %v, want kind ErrValidation", err)
+			}
+			if c != tt.want {
+				t.Errorf("Client() = %v, want %v", c, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSourceBucket(t *testing.T) {
+	tests := []struct {
+		spec      string
+		bucket    string
+		creds     string
+		wantError bool
+	}{
+		{spec: "archive-measurement-lab", bucket: "archive-measurement-lab"},
+		{spec: "contributed=/etc/creds/key.json", bucket: "contributed", creds: "/etc/creds/key.json"},
+		{spec: "=/etc/creds/key.json", wantError: true},
+		{spec: "gs://bucket/path", wantError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			bucket, creds, err := storage.ParseSourceBucket(tt.spec)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("ParseSourceBucket() error = %v, wantError %v", err, tt.wantError)
+			}
+			if bucket != tt.bucket || creds != tt.creds {
+				t.Errorf("ParseSourceBucket() = %q, %q; want %q, %q", bucket, creds, tt.bucket, tt.creds)
+			}
+		})
+	}
+}
+
+func TestMultiBucketSourceFactory(t *testing.T) {
+	sc := storage.NewSourceClients(&fakeClient{})
+	sc.Add("archive-measurement-lab", nil)
+	dp, err := etl.ValidateTestPath(
+		"gs://other-bucket/ndt/ndt5/2020/06/11/20200611T123456.12345Z-ndt5-mlab1-foo01-ndt.tgz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, pErr := storage.MultiBucketSourceFactory(sc).Get(context.Background(), dp)
+	if pErr == nil || pErr.Code() != http.StatusForbidden {
+		t.Errorf("Get() = %v, want status %d", pErr, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,478 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/bigquery/storage/managedwriter"
+	"cloud.google.com/go/civil"
+	storagepb "google.golang.org/genproto/googleapis/cloud/bigquery/storage/v1"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/m-lab/etl/etl"
+	"github.com/m-lab/etl/factory"
+	"github.com/m-lab/etl/row"
+)
+
+// writeAPITimeout limits the time for each append to, and the commit of, a
+// pending stream.
+const writeAPITimeout = 2 * time.Minute
+
+// maxAppendBytes limits the size of the rows of each append, leaving room
+// for the rest of the request under the 10MB AppendRows request limit.
+const maxAppendBytes = 9 << 20
+
+// ErrStreamFailed is returned by WriteAPISink.Commit after an append has
+// failed, since the rows of the stream will not be committed.
+var ErrStreamFailed = errors.New("write stream failed")
+
+// PendingStream is a pending stream of the BigQuery Storage Write API.  Rows
+// appended to it become visible in the table only when it is committed.
+type PendingStream interface {
+	// Append appends serialized rows at the offset, and waits until they
+	// have been accepted.  Appends at an offset are applied only once.
+	Append(ctx context.Context, rows [][]byte, offset int64) error
+	// Commit finalizes the stream, and commits its rows atomically.
+	Commit(ctx context.Context) error
+	// Close releases the stream.  An uncommitted stream is discarded.
+	Close() error
+}
+
+// pendingStream implements PendingStream with a managedwriter.ManagedStream.
+type pendingStream struct {
+	client *managedwriter.Client
+	table  string
+	ms     *managedwriter.ManagedStream
+}
+
+// NewPendingStream creates a PendingStream of rows of the schema for the
+// table, given as "projects/p/datasets/d/tables/t".
+func NewPendingStream(ctx context.Context, client *managedwriter.Client, table string, schema bigquery.Schema) (PendingStream, error) {
+	_, dp, err := rowDescriptor(schema)
+	if err != nil {
+		return nil, err
+	}
+	ms, err := client.NewManagedStream(ctx,
+		managedwriter.WithDestinationTable(table),
+		managedwriter.WithType(managedwriter.PendingStream),
+		managedwriter.WithSchemaDescriptor(dp))
+	if err != nil {
+		return nil, err
+	}
+	return &pendingStream{client: client, table: table, ms: ms}, nil
+}
+
+// Append implements PendingStream.
+func (s *pendingStream) Append(ctx context.Context, rows [][]byte, offset int64) error {
+	r, err := s.ms.AppendRows(ctx, rows, managedwriter.WithOffset(offset))
+	if err != nil {
+		return err
+	}
+	_, err = r.GetResult(ctx)
+	return err
+}
+
+// Commit implements PendingStream.
+func (s *pendingStream) Commit(ctx context.Context) error {
+	if _, err := s.ms.Finalize(ctx); err != nil {
+		return err
+	}
+	resp, err := s.client.BatchCommitWriteStreams(ctx, &storagepb.BatchCommitWriteStreamsRequest{
+		Parent:       s.table,
+		WriteStreams: []string{s.ms.StreamName()},
+	})
+	if err != nil {
+		return err
+	}
+	if errs := resp.GetStreamErrors(); len(errs) > 0 {
+		return fmt.Errorf("committing %s: %s", s.ms.StreamName(), errs[0].GetErrorMessage())
+	}
+	return nil
+}
+
+// Close implements PendingStream.
+func (s *pendingStream) Close() error {
+	return s.ms.Close()
+}
+
+// WriteAPISink implements row.Sink, appending rows to a PendingStream, rather
+// than streaming inserts, which are more expensive and limited by quota.  The
+// stream is committed when the sink is closed, so all the rows of a task
+// become visible at once.  If any append fails, or the sink is discarded
+// because the task failed, the stream is not committed, and a retry of the
+// task writes its rows to a new stream.  Append offsets only prevent
+// duplicate appends within a stream, so a retry of a task whose stream was
+// committed, e.g. after the worker died before acknowledging the task, writes
+// its rows again.
+type WriteAPISink struct {
+	ctx    context.Context
+	stream PendingStream
+	schema bigquery.Schema
+	desc   protoreflect.MessageDescriptor
+
+	lock      sync.Mutex
+	offset    int64 // Rows appended to the stream.
+	err       error // First append error.
+	discarded bool
+}
+
+// NewWriteAPISink creates a WriteAPISink that appends rows, encoded using the
+// table schema, to the stream.  Rows are encoded as in their JSON encoding,
+// as written by the GCS sinks, so the schema must match that encoding.
+func NewWriteAPISink(ctx context.Context, stream PendingStream, schema bigquery.Schema) (*WriteAPISink, error) {
+	desc, _, err := rowDescriptor(schema)
+	if err != nil {
+		return nil, err
+	}
+	return &WriteAPISink{ctx: ctx, stream: stream, schema: schema, desc: desc}, nil
+}
+
+// Commit implements row.Sink.  Rows that cannot be encoded, or are too
+// large to append, are dropped, and the others are appended to the stream in
+// as many appends as the request size limit requires.  The returned count is
+// the number of rows appended, and the error is the first encoding or append
+// error.
+func (s *WriteAPISink) Commit(rows []interface{}, label string) (int, error) {
+	data := make([][]byte, 0, len(rows))
+	var encodeErr error
+	for i := range rows {
+		b, err := encodeRow(s.desc, s.schema, rows[i])
+		if err == nil && appendSize(b) > maxAppendBytes {
+			err = fmt.Errorf("%d bytes exceeds the append limit of %d bytes", len(b), maxAppendBytes)
+		}
+		if err != nil {
+			if encodeErr == nil {
+				encodeErr = etl.ErrValidation.Errorf("encoding row: %w", err)
+			}
+			continue
+		}
+		data = append(data, b)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.err != nil {
+		return 0, etl.ErrBigQuery.Errorf("%w: %v", ErrStreamFailed, s.err)
+	}
+	if len(data) == 0 {
+		return 0, encodeErr
+	}
+	for _, batch := range appendBatches(data) {
+		if err := s.append(batch); err != nil {
+			// The rows of the stream are lost, so later commits fail too.
+			s.err = etl.ErrBigQuery.Errorf("appending rows: %w", err)
+			return 0, s.err
+		}
+		s.offset += int64(len(batch))
+	}
+	return len(data), encodeErr
+}
+
+// append appends the rows at the current offset.
+func (s *WriteAPISink) append(rows [][]byte) error {
+	ctx, cancel := context.WithTimeout(s.ctx, writeAPITimeout)
+	defer cancel()
+	return s.stream.Append(ctx, rows, s.offset)
+}
+
+// appendSize returns the size of the encoded row in an append request,
+// including its field tag and length.
+func appendSize(row []byte) int {
+	return protowire.SizeTag(1) + protowire.SizeBytes(len(row))
+}
+
+// appendBatches splits the rows into consecutive batches, each no larger
+// than maxAppendBytes.  Each row must itself be within the limit.
+func appendBatches(rows [][]byte) [][][]byte {
+	var batches [][][]byte
+	start, size := 0, 0
+	for i, r := range rows {
+		n := appendSize(r)
+		if size+n > maxAppendBytes {
+			batches = append(batches, rows[start:i])
+			start, size = i, 0
+		}
+		size += n
+	}
+	if start < len(rows) {
+		batches = append(batches, rows[start:])
+	}
+	return batches
+}
+
+// Committed implements row.Counter.  Rows are counted when they are appended,
+// although they are not visible until the stream is committed by Close.
+func (s *WriteAPISink) Committed() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return int(s.offset)
+}
+
+// Discard implements row.Discarder.
+func (s *WriteAPISink) Discard() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.discarded = true
+}
+
+// Close commits the stream, unless the sink was discarded or an append
+// failed, and releases it.
+func (s *WriteAPISink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	switch {
+	case s.discarded:
+		log.Printf("Discarding %d rows", s.offset)
+		return s.stream.Close()
+	case s.err != nil:
+		log.Printf("Discarding %d rows after %v", s.offset, s.err)
+		return JoinErrors(etl.ErrBigQuery.Errorf("%w: %v", ErrStreamFailed, s.err), s.stream.Close())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), writeAPITimeout)
+	defer cancel()
+	err := s.stream.Commit(ctx)
+	if err != nil {
+		log.Printf("%v committing %d rows", err, s.offset)
+		err = etl.ErrBigQuery.Errorf("committing rows: %w", err)
+	}
+	return JoinErrors(err, s.stream.Close())
+}
+
+// fieldTypes maps the BigQuery types supported by WriteAPISink to the types
+// of their proto encoding.
+var fieldTypes = map[bigquery.FieldType]descriptorpb.FieldDescriptorProto_Type{
+	bigquery.StringFieldType:    descriptorpb.FieldDescriptorProto_TYPE_STRING,
+	bigquery.GeographyFieldType: descriptorpb.FieldDescriptorProto_TYPE_STRING,
+	bigquery.BytesFieldType:     descriptorpb.FieldDescriptorProto_TYPE_BYTES,
+	bigquery.IntegerFieldType:   descriptorpb.FieldDescriptorProto_TYPE_INT64,
+	bigquery.FloatFieldType:     descriptorpb.FieldDescriptorProto_TYPE_DOUBLE,
+	bigquery.BooleanFieldType:   descriptorpb.FieldDescriptorProto_TYPE_BOOL,
+	bigquery.TimestampFieldType: descriptorpb.FieldDescriptorProto_TYPE_INT64,
+	bigquery.DateFieldType:      descriptorpb.FieldDescriptorProto_TYPE_INT32,
+}
+
+// rowDescriptor returns the proto2 descriptor of rows of the schema, and the
+// self-contained DescriptorProto sent to the Storage Write API.
+func rowDescriptor(schema bigquery.Schema) (protoreflect.MessageDescriptor, *descriptorpb.DescriptorProto, error) {
+	root := &descriptorpb.DescriptorProto{Name: proto.String("root")}
+	if err := addFields(root, root, "root", schema); err != nil {
+		return nil, nil, err
+	}
+	fd, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:        proto.String("root.proto"),
+		Syntax:      proto.String("proto2"),
+		MessageType: []*descriptorpb.DescriptorProto{root},
+	}, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return fd.Messages().Get(0), root, nil
+}
+
+// addFields adds the fields of the schema to the message dp, numbered in
+// schema order.  The message type of each RECORD field is nested in root,
+// named by its path, as the Storage Write API requires a single message.
+func addFields(root, dp *descriptorpb.DescriptorProto, scope string, schema bigquery.Schema) error {
+	for i, f := range schema {
+		fdp := &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(strings.ToLower(f.Name)),
+			Number: proto.Int32(int32(i + 1)),
+			Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		}
+		switch {
+		case f.Repeated:
+			fdp.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+		case f.Required:
+			fdp.Label = descriptorpb.FieldDescriptorProto_LABEL_REQUIRED.Enum()
+		}
+		if f.Type == bigquery.RecordFieldType {
+			name := scope + "__" + f.Name
+			nested := &descriptorpb.DescriptorProto{Name: proto.String(name)}
+			if err := addFields(root, nested, name, f.Schema); err != nil {
+				return err
+			}
+			root.NestedType = append(root.NestedType, nested)
+			fdp.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+			fdp.TypeName = proto.String(name)
+		} else {
+			t, ok := fieldTypes[f.Type]
+			if !ok {
+				return fmt.Errorf("%s: unsupported type %s", f.Name, f.Type)
+			}
+			fdp.Type = t.Enum()
+		}
+		dp.Field = append(dp.Field, fdp)
+	}
+	return nil
+}
+
+// encodeRow returns the proto encoding of the row, converted from its JSON
+// encoding using the schema.
+func encodeRow(desc protoreflect.MessageDescriptor, schema bigquery.Schema, r interface{}) ([]byte, error) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v map[string]interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	m := dynamicpb.NewMessage(desc)
+	if err := setFields(m, schema, v); err != nil {
+		return nil, err
+	}
+	return proto.Marshal(m)
+}
+
+// setFields sets the fields of m from the JSON object v.  Keys are matched to
+// the schema ignoring case, like BigQuery column names.  Keys with non-null
+// values that are not in the schema are an error, so that no data is lost.
+func setFields(m *dynamicpb.Message, schema bigquery.Schema, v map[string]interface{}) error {
+	values := make(map[string]interface{}, len(v))
+	for k, x := range v {
+		if x != nil {
+			values[strings.ToLower(k)] = x
+		}
+	}
+	fields := m.Descriptor().Fields()
+	for i, f := range schema {
+		name := strings.ToLower(f.Name)
+		x, ok := values[name]
+		if !ok {
+			continue
+		}
+		delete(values, name)
+		// Fields are numbered in schema order.
+		fd := fields.ByNumber(protoreflect.FieldNumber(i + 1))
+		if !f.Repeated {
+			pv, err := fieldValue(fd, f, x)
+			if err != nil {
+				return fmt.Errorf("%s: %w", f.Name, err)
+			}
+			m.Set(fd, pv)
+			continue
+		}
+		xs, ok := x.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: %T is not an array", f.Name, x)
+		}
+		list := m.Mutable(fd).List()
+		for _, e := range xs {
+			pv, err := fieldValue(fd, f, e)
+			if err != nil {
+				return fmt.Errorf("%s: %w", f.Name, err)
+			}
+			list.Append(pv)
+		}
+	}
+	if len(values) > 0 {
+		unknown := make([]string, 0, len(values))
+		for k := range values {
+			unknown = append(unknown, k)
+		}
+		sort.Strings(unknown)
+		return fmt.Errorf("no such fields: %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// fieldValue converts the JSON value x of the field f to its proto value, as
+// used by the Storage Write API.
+func fieldValue(fd protoreflect.FieldDescriptor, f *bigquery.FieldSchema, x interface{}) (protoreflect.Value, error) {
+	switch f.Type {
+	case bigquery.StringFieldType, bigquery.GeographyFieldType:
+		if s, ok := x.(string); ok {
+			return protoreflect.ValueOfString(s), nil
+		}
+	case bigquery.BytesFieldType:
+		if s, ok := x.(string); ok {
+			b, err := base64.StdEncoding.DecodeString(s)
+			return protoreflect.ValueOfBytes(b), err
+		}
+	case bigquery.IntegerFieldType:
+		if n, ok := x.(json.Number); ok {
+			i, err := n.Int64()
+			return protoreflect.ValueOfInt64(i), err
+		}
+	case bigquery.FloatFieldType:
+		if n, ok := x.(json.Number); ok {
+			f, err := n.Float64()
+			return protoreflect.ValueOfFloat64(f), err
+		}
+	case bigquery.BooleanFieldType:
+		if b, ok := x.(bool); ok {
+			return protoreflect.ValueOfBool(b), nil
+		}
+	case bigquery.TimestampFieldType:
+		if s, ok := x.(string); ok {
+			t, err := time.Parse(time.RFC3339Nano, s)
+			return protoreflect.ValueOfInt64(t.UnixMicro()), err
+		}
+	case bigquery.DateFieldType:
+		if s, ok := x.(string); ok {
+			d, err := civil.ParseDate(s)
+			return protoreflect.ValueOfInt32(int32(etl.EpochDays(d))), err
+		}
+	case bigquery.RecordFieldType:
+		if v, ok := x.(map[string]interface{}); ok {
+			m := dynamicpb.NewMessage(fd.Message())
+			err := setFields(m, f.Schema, v)
+			return protoreflect.ValueOfMessage(m), err
+		}
+	}
+	return protoreflect.Value{}, fmt.Errorf("%T is not a valid %s", x, f.Type)
+}
+
+// WriteAPISinkFactory implements factory.SinkFactory, producing WriteAPISinks
+// for the destination table of each datatype.
+type WriteAPISinkFactory struct {
+	bq     *bigquery.Client
+	writer *managedwriter.Client
+}
+
+// NewWriteAPISinkFactory returns a SinkFactory that writes to BigQuery with
+// the Storage Write API.  The bq client reads the schema of each table.
+func NewWriteAPISinkFactory(bq *bigquery.Client, writer *managedwriter.Client) factory.SinkFactory {
+	return &WriteAPISinkFactory{bq: bq, writer: writer}
+}
+
+// Get implements factory.SinkFactory.
+func (sf *WriteAPISinkFactory) Get(ctx context.Context, dp etl.DataPath) (row.Sink, etl.ProcessingError) {
+	d := dp.GetDataType().Destination()
+	md, err := sf.bq.DatasetInProject(d.Project, d.Dataset).Table(d.Table).Metadata(ctx)
+	if err != nil {
+		return nil, factory.NewError(dp.DataType, "WriteAPISinkFactory",
+			http.StatusInternalServerError, etl.ErrBigQuery.Errorf("reading schema of %s: %w", d, err))
+	}
+	table := managedwriter.TableParentFromParts(d.Project, d.Dataset, d.Table)
+	stream, err := NewPendingStream(ctx, sf.writer, table, md.Schema)
+	if err != nil {
+		return nil, factory.NewError(dp.DataType, "WriteAPISinkFactory",
+			http.StatusInternalServerError, etl.ErrBigQuery.Errorf("opening stream for %s: %w", d, err))
+	}
+	s, err := NewWriteAPISink(ctx, stream, md.Schema)
+	if err != nil {
+		stream.Close()
+		return nil, factory.NewError(dp.DataType, "WriteAPISinkFactory",
+			http.StatusInternalServerError, err)
+	}
+	return s, nil
+}
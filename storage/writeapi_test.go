@@ -0,0 +1,180 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/m-lab/etl/etl"
+	"github.com/m-lab/etl/storage"
+)
+
+// fakeStream records the rows appended, and fails appends if err is set.
+type fakeStream struct {
+	rows      [][]byte
+	offsets   []int64
+	err       error
+	committed bool
+	closed    bool
+}
+
+func (fs *fakeStream) Append(ctx context.Context, rows [][]byte, offset int64) error {
+	if fs.err != nil {
+		return fs.err
+	}
+	fs.offsets = append(fs.offsets, offset)
+	fs.rows = append(fs.rows, rows...)
+	return nil
+}
+
+func (fs *fakeStream) Commit(ctx context.Context) error {
+	fs.committed = true
+	return nil
+}
+
+func (fs *fakeStream) Close() error {
+	fs.closed = true
+	return nil
+}
+
+type apiRaw struct {
+	RTT []float64
+	OK  bool
+}
+
+type apiRow struct {
+	ID    string
+	Date  civil.Date
+	Time  time.Time
+	Count int64
+	Data  []byte
+	Raw   apiRaw
+}
+
+type otherRow struct {
+	ID    string
+	Extra string
+}
+
+func newWriteAPISink(t *testing.T) (*storage.WriteAPISink, *fakeStream, bigquery.Schema) {
+	schema, err := bigquery.InferSchema(apiRow{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := &fakeStream{}
+	s, err := storage.NewWriteAPISink(context.Background(), fs, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, fs, schema
+}
+
+func TestWriteAPISink_Commit(t *testing.T) {
+	s, fs, schema := newWriteAPISink(t)
+	ts := time.Date(2022, 6, 1, 12, 30, 0, 123456000, time.UTC)
+	rows := []interface{}{
+		&apiRow{ID: "a", Date: civil.Date{Year: 2022, Month: 6, Day: 1}, Time: ts, Count: 7,
+			Data: []byte{1, 2}, Raw: apiRaw{RTT: []float64{1.5, 2.5}, OK: true}},
+		&apiRow{ID: "b", Date: civil.Date{Year: 2022, Month: 6, Day: 2}, Data: []byte{}},
+	}
+	if n, err := s.Commit(rows, "test"); n != 2 || err != nil {
+		t.Fatalf("Commit() = %d, %v", n, err)
+	}
+	if n, err := s.Commit(rows[:1], "test"); n != 1 || err != nil {
+		t.Fatalf("Commit() = %d, %v", n, err)
+	}
+	if s.Committed() != 3 || len(fs.offsets) != 2 || fs.offsets[1] != 2 {
+		t.Errorf("Committed() = %d, offsets %v", s.Committed(), fs.offsets)
+	}
+
+	desc, err := storage.RowDescriptor(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := dynamicpb.NewMessage(desc)
+	if err := proto.Unmarshal(fs.rows[0], m); err != nil {
+		t.Fatal(err)
+	}
+	get := func(m protoreflect.Message, name string) protoreflect.Value {
+		return m.Get(m.Descriptor().Fields().ByName(protoreflect.Name(name)))
+	}
+	raw := get(m, "raw").Message()
+	if get(m, "id").String() != "a" ||
+		get(m, "date").Int() != 19144 ||
+		get(m, "time").Int() != ts.UnixMicro() ||
+		get(m, "count").Int() != 7 ||
+		string(get(m, "data").Bytes()) != "\x01\x02" ||
+		get(raw, "rtt").List().Len() != 2 || get(raw, "rtt").List().Get(1).Float() != 2.5 ||
+		!get(raw, "ok").Bool() {
+		t.Errorf("Commit() appended %v", m)
+	}
+
+	if err := s.Close(); err != nil || !fs.committed || !fs.closed {
+		t.Errorf("Close() = %v, committed %v, closed %v", err, fs.committed, fs.closed)
+	}
+}
+
+func TestWriteAPISink_UnknownField(t *testing.T) {
+	s, fs, _ := newWriteAPISink(t)
+	rows := []interface{}{&otherRow{ID: "a", Extra: "x"}, &apiRow{ID: "b", Date: civil.Date{Year: 2022, Month: 6, Day: 2}, Data: []byte{}}}
+	if n, err := s.Commit(rows, "test"); n != 1 || err == nil {
+		t.Errorf("Commit() = %d, %v; want 1 and an error", n, err)
+	}
+	// The other rows are committed.
+	if err := s.Close(); err != nil || len(fs.rows) != 1 || !fs.committed {
+		t.Errorf("Close() = %v after %d rows, committed %v", err, len(fs.rows), fs.committed)
+	}
+}
+
+func TestWriteAPISink_Split(t *testing.T) {
+	s, fs, _ := newWriteAPISink(t)
+	day := civil.Date{Year: 2022, Month: 6, Day: 2}
+	var rows []interface{}
+	for i := 0; i < 5; i++ {
+		rows = append(rows, &apiRow{ID: "a", Date: day, Data: make([]byte, 3000000)})
+	}
+	// A row larger than any request is dropped.
+	rows = append(rows, &apiRow{ID: "big", Date: day, Data: make([]byte, 10<<20)})
+	n, err := s.Commit(rows, "test")
+	if n != 5 || !errors.Is(err, etl.ErrValidation) {
+		t.Errorf("Commit() = %d, %v; want 5 and a validation error", n, err)
+	}
+	// Three 3MB rows fit within each 9MiB append, leaving two for the second.
+	if len(fs.rows) != 5 || len(fs.offsets) != 2 || fs.offsets[1] != 3 || s.Committed() != 5 {
+		t.Errorf("Commit() appended %d rows at offsets %v, Committed() = %d", len(fs.rows), fs.offsets, s.Committed())
+	}
+}
+
+func TestWriteAPISink_AppendError(t *testing.T) {
+	s, fs, _ := newWriteAPISink(t)
+	fs.err = errors.New("append failed")
+	rows := []interface{}{&apiRow{ID: "a", Date: civil.Date{Year: 2022, Month: 6, Day: 2}, Data: []byte{}}}
+	if n, err := s.Commit(rows, "test"); n != 0 || err == nil {
+		t.Errorf("Commit() = %d, %v; want an error", n, err)
+	}
+	fs.err = nil
+	if _, err := s.Commit(rows, "test"); !errors.Is(err, storage.ErrStreamFailed) || !errors.Is(err, etl.ErrBigQuery) {
+		t.Errorf("Commit() = %v, want ErrStreamFailed of kind ErrBigQuery", err)
+	}
+	if err := s.Close(); !errors.Is(err, storage.ErrStreamFailed) || fs.committed || !fs.closed {
+		t.Errorf("Close() = %v, committed %v, closed %v", err, fs.committed, fs.closed)
+	}
+}
+
+func TestWriteAPISink_Discard(t *testing.T) {
+	s, fs, _ := newWriteAPISink(t)
+	if _, err := s.Commit([]interface{}{&apiRow{ID: "a", Date: civil.Date{Year: 2022, Month: 6, Day: 2}, Data: []byte{}}}, "test"); err != nil {
+		t.Fatal(err)
+	}
+	s.Discard()
+	if err := s.Close(); err != nil || fs.committed || !fs.closed {
+		t.Errorf("Close() = %v, committed %v, closed %v", err, fs.committed, fs.closed)
+	}
+}
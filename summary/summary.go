@@ -0,0 +1,148 @@
+// Package summary defines small, query-cheap tables derived from the raw
+// parser output tables.  Each summary table is maintained by a query defined
+// in code, which recomputes one daily partition at a time, so that dashboards
+// need not scan the full raw tables.
+//
+// Summary queries are intended to run on a schedule, after the parsers have
+// completed a date, e.g. using cmd/update-summaries from a cron job.
+package summary
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"text/template"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+
+	"github.com/m-lab/etl/etl"
+)
+
+// Table describes a summary table derived from the raw table of a datatype.
+type Table struct {
+	// Name of the summary table, in the same dataset as the source table.
+	Name string
+	// Source is the datatype whose table is summarized.
+	Source etl.DataType
+	// Experiment is the experiment name of the source datatype, used to name
+	// datasets, e.g. "raw_ndt" or "tmp_ndt".
+	Experiment string
+	// Query is a text/template for a SQL query that produces the summary rows
+	// for a single date.  The template has access to the Source, which is the
+	// fully qualified source table name, and Date, formatted as YYYY-MM-DD.
+	// Results must include a DATE column named "date".
+	Query string
+}
+
+// queryParams are the values available to Table.Query templates.
+type queryParams struct {
+	Source string
+	Date   string
+}
+
+// Tables lists all summary tables.
+var Tables = []Table{
+	{
+		Name:       "switch_daily",
+		Source:     etl.SW,
+		Experiment: "utilization",
+		Query: `
+SELECT
+  date,
+  a.Site AS site,
+  a.Machine AS machine,
+  COUNT(*) AS samples,
+  SUM(a.SwitchOctetsUplinkRx) AS uplink_rx_octets,
+  SUM(a.SwitchOctetsUplinkTx) AS uplink_tx_octets,
+  MAX(a.SwitchOctetsUplinkRx) * 8 / 10 AS max_uplink_rx_bps,
+  MAX(a.SwitchOctetsUplinkTx) * 8 / 10 AS max_uplink_tx_bps,
+  SUM(a.SwitchOctetsLocalRx) AS local_rx_octets,
+  SUM(a.SwitchOctetsLocalTx) AS local_tx_octets
+FROM ` + "`{{.Source}}`" + `
+WHERE date = "{{.Date}}"
+GROUP BY date, site, machine`,
+	},
+	{
+		Name:       "ndt7_tests",
+		Source:     etl.NDT7,
+		Experiment: "ndt",
+		Query: `
+SELECT
+  date,
+  id,
+  a.TestTime AS test_time,
+  IF(raw.Download IS NOT NULL, "download", "upload") AS direction,
+  REGEXP_EXTRACT(parser.ArchiveURL, r"-(mlab[1-4]-[a-z]{3}[0-9t]{2})-") AS server,
+  a.CongestionControl AS congestion_control,
+  a.MeanThroughputMbps AS mean_throughput_mbps,
+  a.MinRTT AS min_rtt,
+  a.LossRate AS loss_rate
+FROM ` + "`{{.Source}}`" + `
+WHERE date = "{{.Date}}"`,
+	},
+}
+
+// Get returns the named summary Table.
+func Get(name string) (Table, bool) {
+	for i := range Tables {
+		if Tables[i].Name == name {
+			return Tables[i], true
+		}
+	}
+	return Table{}, false
+}
+
+// Dataset returns the name of the dataset for the source and summary tables,
+// given a dataset prefix such as "raw" or "tmp".
+func (t Table) Dataset(prefix string) string {
+	return prefix + "_" + t.Experiment
+}
+
+// SQL returns the query that computes the summary rows for the date, reading
+// from the source table in the given project and dataset.
+func (t Table) SQL(project, dataset string, date civil.Date) (string, error) {
+	tmpl, err := template.New(t.Name).Parse(t.Query)
+	if err != nil {
+		return "", err
+	}
+	p := queryParams{
+		Source: fmt.Sprintf("%s.%s.%s", project, dataset, t.Source.Table()),
+		Date:   date.String(),
+	}
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, p); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Update recomputes the date's partition of the summary table, replacing any
+// previous content for that date.  The table is created, partitioned by date,
+// if it does not exist.
+func (t Table) Update(ctx context.Context, client *bigquery.Client, project, dataset string, date civil.Date) error {
+	sql, err := t.SQL(project, dataset, date)
+	if err != nil {
+		return err
+	}
+	q := client.Query(sql)
+	q.Dst = client.DatasetInProject(project, dataset).Table(
+		t.Name + "$" + date.In(time.UTC).Format("20060102"))
+	q.WriteDisposition = bigquery.WriteTruncate
+	q.CreateDisposition = bigquery.CreateIfNeeded
+	q.TimePartitioning = &bigquery.TimePartitioning{Field: "date"}
+
+	job, err := q.Run(ctx)
+	if err != nil {
+		return etl.ErrBigQuery.Errorf("updating %s: %w", t.Name, err)
+	}
+	status, err := job.Wait(ctx)
+	if err != nil {
+		return etl.ErrBigQuery.Errorf("updating %s: %w", t.Name, err)
+	}
+	if err := status.Err(); err != nil {
+		return etl.ErrBigQuery.Errorf("updating %s: %w", t.Name, err)
+	}
+	return nil
+}
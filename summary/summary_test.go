@@ -0,0 +1,52 @@
+package summary_test
+
+import (
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/civil"
+
+	"github.com/m-lab/etl/summary"
+)
+
+func TestTable_SQL(t *testing.T) {
+	date := civil.Date{Year: 2022, Month: 7, Day: 4}
+	for _, tbl := range summary.Tables {
+		t.Run(tbl.Name, func(t *testing.T) {
+			sql, err := tbl.SQL("mlab-sandbox", "tmp_ndt", date)
+			if err != nil {
+				t.Fatal(err)
+			}
+			source := "`mlab-sandbox.tmp_ndt." + tbl.Source.Table() + "`"
+			if !strings.Contains(sql, source) {
+				t.Errorf("SQL() missing source table %s:\n%s", source, sql)
+			}
+			if !strings.Contains(sql, `date = "2022-07-04"`) {
+				t.Errorf("SQL() missing date filter:\n%s", sql)
+			}
+			if strings.Contains(sql, "{{") {
+				t.Errorf("SQL() has unexpanded template:\n%s", sql)
+			}
+		})
+	}
+}
+
+func TestTable_SQLBadTemplate(t *testing.T) {
+	tbl := summary.Table{Name: "bad", Query: "SELECT {{.Missing"}
+	if _, err := tbl.SQL("p", "d", civil.Date{Year: 2022, Month: 1, Day: 1}); err == nil {
+		t.Error("SQL() expected error for bad template")
+	}
+}
+
+func TestGet(t *testing.T) {
+	tbl, ok := summary.Get("switch_daily")
+	if !ok {
+		t.Fatal("Get(switch_daily) not found")
+	}
+	if tbl.Dataset("raw") != "raw_utilization" {
+		t.Errorf("Dataset(raw) = %q, want raw_utilization", tbl.Dataset("raw"))
+	}
+	if _, ok := summary.Get("no_such_table"); ok {
+		t.Error("Get(no_such_table) found")
+	}
+}
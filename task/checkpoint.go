@@ -0,0 +1,282 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+
+	"github.com/m-lab/etl/row"
+)
+
+// ErrCheckpointMismatch is returned when the archive entry at a checkpoint
+// is not the entry recorded, e.g. because the archive was replaced.  The
+// checkpoint is deleted, so that a retry processes the whole archive.
+var ErrCheckpointMismatch = errors.New("archive does not match checkpoint")
+
+// Checkpoint records the last archive entry whose rows are all committed and
+// published.
+type Checkpoint struct {
+	Name    string    // Name of the entry.
+	Files   int       // Number of entries read, including this one.
+	Updated time.Time // When the checkpoint was recorded.
+}
+
+// CheckpointStore holds the checkpoints of partially processed archives.
+type CheckpointStore interface {
+	// Get returns the checkpoint of the archive, or nil if it has none.
+	Get(ctx context.Context, archive string) (*Checkpoint, error)
+	// Put records the checkpoint of the archive.
+	Put(ctx context.Context, archive string, cp Checkpoint) error
+	// Delete removes the checkpoint of the archive, if any.
+	Delete(ctx context.Context, archive string) error
+}
+
+// MemoryCheckpointStore implements CheckpointStore in memory, for testing or
+// a single worker.
+type MemoryCheckpointStore struct {
+	lock        sync.Mutex
+	checkpoints map[string]Checkpoint
+}
+
+// NewMemoryCheckpointStore creates an empty MemoryCheckpointStore.
+func NewMemoryCheckpointStore() *MemoryCheckpointStore {
+	return &MemoryCheckpointStore{checkpoints: map[string]Checkpoint{}}
+}
+
+// Get implements CheckpointStore.
+func (m *MemoryCheckpointStore) Get(ctx context.Context, archive string) (*Checkpoint, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	cp, ok := m.checkpoints[archive]
+	if !ok {
+		return nil, nil
+	}
+	return &cp, nil
+}
+
+// Put implements CheckpointStore.
+func (m *MemoryCheckpointStore) Put(ctx context.Context, archive string, cp Checkpoint) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.checkpoints[archive] = cp
+	return nil
+}
+
+// Delete implements CheckpointStore.
+func (m *MemoryCheckpointStore) Delete(ctx context.Context, archive string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.checkpoints, archive)
+	return nil
+}
+
+// checkpointKind is the Datastore kind used by DatastoreCheckpointStore.
+const checkpointKind = "TaskCheckpoint"
+
+// DatastoreCheckpointStore implements CheckpointStore in Datastore, so that
+// a checkpoint survives the worker that recorded it.
+type DatastoreCheckpointStore struct {
+	client    *datastore.Client
+	namespace string
+}
+
+// NewDatastoreCheckpointStore creates a DatastoreCheckpointStore using
+// entities in the namespace.
+func NewDatastoreCheckpointStore(client *datastore.Client, namespace string) *DatastoreCheckpointStore {
+	return &DatastoreCheckpointStore{client: client, namespace: namespace}
+}
+
+func (d *DatastoreCheckpointStore) key(archive string) *datastore.Key {
+	k := datastore.NameKey(checkpointKind, archive, nil)
+	k.Namespace = d.namespace
+	return k
+}
+
+// Get implements CheckpointStore.
+func (d *DatastoreCheckpointStore) Get(ctx context.Context, archive string) (*Checkpoint, error) {
+	var cp Checkpoint
+	err := d.client.Get(ctx, d.key(archive), &cp)
+	if errors.Is(err, datastore.ErrNoSuchEntity) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+// Put implements CheckpointStore.
+func (d *DatastoreCheckpointStore) Put(ctx context.Context, archive string, cp Checkpoint) error {
+	_, err := d.client.Put(ctx, d.key(archive), &cp)
+	return err
+}
+
+// Delete implements CheckpointStore.
+func (d *DatastoreCheckpointStore) Delete(ctx context.Context, archive string) error {
+	return d.client.Delete(ctx, d.key(archive))
+}
+
+// checkpointTimeout limits the time for each checkpoint store operation.
+const checkpointTimeout = 10 * time.Second
+
+// checkpointer tracks the checkpoint of a task's archive.
+type checkpointer struct {
+	store CheckpointStore
+	sink  row.Syncer // The parser's sink.
+	every int        // Entries between checkpoints.
+
+	resume Checkpoint // Checkpoint at the start of ProcessAllTests.
+	last   Checkpoint // Last checkpoint recorded.
+	prev   Checkpoint // Most recently read entry.
+}
+
+// SetCheckpointStore sets a CheckpointStore, so that ProcessAllTests skips
+// the entries of a partially processed archive whose rows were already
+// committed, and records its own progress.  Every every entries, the parser
+// is flushed, and its sink synced, so that each checkpoint covers only rows
+// in published objects.  Since the objects are named for the entry at which
+// they start, a retry that resumes at a checkpoint rewrites exactly the
+// objects that follow it, even if they were partially written or published.
+//
+// Tests of an etl.GroupParser are not checkpointed.  Checkpoints need tests
+// to be parsed in order, so ProcessAllTestsParallel parses them serially if a
+// store is set.
+func (tt *Task) SetCheckpointStore(s CheckpointStore, sink row.Syncer, every int) {
+	if s == nil || sink == nil || every < 1 {
+		tt.checkpoints = nil
+		return
+	}
+	tt.checkpoints = &checkpointer{store: s, sink: sink, every: every}
+}
+
+// archive returns the checkpoint key of the task's archive.
+func (tt *Task) archive() string {
+	name, _ := tt.meta["filename"].(string)
+	return name
+}
+
+// loadCheckpoint reads the checkpoint of the archive, if any, from which
+// ProcessAllTests resumes, and syncs the sink, so that the rows of the
+// remaining entries replace the objects that followed the checkpoint.  Store
+// and sync errors are logged, and the whole archive is processed.
+func (tt *Task) loadCheckpoint() {
+	c := tt.checkpoints
+	if c == nil {
+		return
+	}
+	*c = checkpointer{store: c.store, sink: c.sink, every: c.every}
+	ctx, cancel := context.WithTimeout(context.Background(), checkpointTimeout)
+	defer cancel()
+	cp, err := c.store.Get(ctx, tt.archive())
+	if err != nil {
+		log.Println("checkpoint:", err)
+		return
+	}
+	if cp == nil {
+		return
+	}
+	if err := c.sink.Sync(cp.Files); err != nil {
+		log.Println("checkpoint:", err)
+		return
+	}
+	log.Printf("Resuming %s after entry %d, %s", tt.archive(), cp.Files, cp.Name)
+	c.resume = *cp
+	c.last = *cp
+	c.prev = *cp
+}
+
+// skipCommitted reports whether the entry read as the files'th entry of the
+// archive precedes the checkpoint, so that its rows are already committed.
+// It returns ErrCheckpointMismatch, after deleting the checkpoint, if the
+// entry at the checkpoint has a different name.
+func (tt *Task) skipCommitted(testname string, files int) (bool, error) {
+	c := tt.checkpoints
+	if c == nil || files > c.resume.Files {
+		return false, nil
+	}
+	if files == c.resume.Files && testname != c.resume.Name {
+		tt.clearCheckpoint()
+		return false, fmt.Errorf("%w: entry %d is %q, not %q",
+			ErrCheckpointMismatch, files, testname, c.resume.Name)
+	}
+	return true, nil
+}
+
+// resumedPast returns ErrCheckpointMismatch, after deleting the checkpoint,
+// if the archive ended after files entries, before the checkpoint entry.
+func (tt *Task) resumedPast(files int) error {
+	c := tt.checkpoints
+	if c == nil || files >= c.resume.Files {
+		return nil
+	}
+	tt.clearCheckpoint()
+	return fmt.Errorf("%w: archive has %d entries, not %d",
+		ErrCheckpointMismatch, files, c.resume.Files)
+}
+
+// advanceCheckpoint is called as the files'th entry, testname, is read.  If
+// the previous entry completed a block of every entries, it flushes the
+// parser, syncs the sink at that entry, and then records a checkpoint of the
+// entry, if all rows so far were committed.  The sink is synced even when no
+// checkpoint can be recorded, so that the objects of every attempt are named
+// for the same entries.  Sync errors are returned, since the rows of the
+// object being closed are lost.
+func (tt *Task) advanceCheckpoint(testname string, files int) error {
+	c := tt.checkpoints
+	if c == nil {
+		return nil
+	}
+	prev := c.prev
+	c.prev = Checkpoint{Name: testname, Files: files}
+	if prev.Files <= c.last.Files || prev.Files%c.every != 0 {
+		return nil
+	}
+	if err := tt.Parser.Flush(); err != nil {
+		// The failed rows are counted, and prevent the checkpoint.
+		log.Println("checkpoint:", err)
+	}
+	if err := c.sink.Sync(prev.Files); err != nil {
+		return err
+	}
+	if tt.Parser.Accepted() == tt.Parser.Committed() {
+		tt.recordCheckpoint(prev)
+	}
+	return nil
+}
+
+// recordCheckpoint records cp.  Nothing is recorded once any rows have
+// failed, since resuming would skip tests whose rows were not committed.
+// Store errors are logged.
+func (tt *Task) recordCheckpoint(cp Checkpoint) {
+	c := tt.checkpoints
+	if tt.Parser.Failed() > 0 {
+		return
+	}
+	cp.Updated = time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), checkpointTimeout)
+	defer cancel()
+	if err := c.store.Put(ctx, tt.archive(), cp); err != nil {
+		log.Println("checkpoint:", err)
+		return
+	}
+	c.last = cp
+}
+
+// clearCheckpoint deletes the checkpoint of the archive, once it has been
+// processed completely.
+func (tt *Task) clearCheckpoint() {
+	c := tt.checkpoints
+	if c == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), checkpointTimeout)
+	defer cancel()
+	if err := c.store.Delete(ctx, tt.archive()); err != nil {
+		log.Println("checkpoint:", err)
+	}
+}
@@ -0,0 +1,299 @@
+package task_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	fgs "github.com/fsouza/fake-gcs-server/fakestorage"
+	"github.com/googleapis/google-cloud-go-testing/storage/stiface"
+
+	"github.com/m-lab/etl/etl"
+	"github.com/m-lab/etl/row"
+	"github.com/m-lab/etl/storage"
+	"github.com/m-lab/etl/task"
+)
+
+// makeArchive returns a source of the named tests.
+func makeArchive(t *testing.T, names ...string) etl.TestSource {
+	b := new(bytes.Buffer)
+	tw := tar.NewWriter(b)
+	for _, name := range names {
+		hdr := tar.Header{Name: name, Mode: 0666, Typeflag: tar.TypeReg, Size: int64(len(name))}
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &storage.GCSSource{TarReader: tar.NewReader(b), Closer: NullCloser{}, RetryBaseTime: time.Millisecond}
+}
+
+// failingSource fails with an unrecoverable error after n tests.
+type failingSource struct {
+	etl.TestSource
+	n int
+}
+
+func (fs *failingSource) NextTest(maxSize int64) (string, []byte, error) {
+	if fs.n == 0 {
+		return "", nil, errors.New("stream error")
+	}
+	fs.n--
+	return fs.TestSource.NextTest(maxSize)
+}
+
+// testRow is the row emitted for each test.
+type testRow struct {
+	ID string
+}
+
+// objectSink writes rows to objects named for the entry at which they start,
+// and publishes them when synced or closed, as the gcs sink does.
+type objectSink struct {
+	objects map[int][]string // Published objects, by starting entry.
+	entry   int              // Starting entry of the current object.
+	current []string         // IDs of the rows in the current object.
+}
+
+func newObjectSink(objects map[int][]string) *objectSink {
+	return &objectSink{objects: objects}
+}
+
+func (s *objectSink) Commit(rows []interface{}, label string) (int, error) {
+	for _, r := range rows {
+		s.current = append(s.current, r.(*testRow).ID)
+	}
+	return len(rows), nil
+}
+
+func (s *objectSink) Sync(entry int) error {
+	if len(s.current) > 0 {
+		s.objects[s.entry] = s.current
+	}
+	s.entry, s.current = entry, nil
+	return nil
+}
+
+func (s *objectSink) Close() error {
+	return s.Sync(0)
+}
+
+// published returns the IDs of all published rows, in entry order.
+func published(objects map[int][]string) []string {
+	var entries []int
+	for e := range objects {
+		entries = append(entries, e)
+	}
+	sort.Ints(entries)
+	ids := []string{}
+	for _, e := range entries {
+		ids = append(ids, objects[e]...)
+	}
+	return ids
+}
+
+// rowParser emits one row for each test, using a row.Base.
+type rowParser struct {
+	*row.Base
+}
+
+func newRowParser(sink row.Sink) *rowParser {
+	return &rowParser{Base: row.NewBase("test", sink, 2)}
+}
+
+func (rp *rowParser) IsParsable(testName string, test []byte) (string, bool) {
+	return "ext", true
+}
+
+func (rp *rowParser) ParseAndInsert(meta map[string]bigquery.Value, testName string, test []byte) error {
+	return rp.Put(&testRow{ID: testName})
+}
+
+func (rp *rowParser) TableName() string     { return "test-table" }
+func (rp *rowParser) FullTableName() string { return "test-table" }
+func (rp *rowParser) RowsInBuffer() int     { return rp.GetStats().Pending }
+func (rp *rowParser) Committed() int        { return rp.GetStats().Committed }
+func (rp *rowParser) Accepted() int         { return rp.GetStats().Total() }
+func (rp *rowParser) Failed() int           { return rp.GetStats().Failed }
+
+// checkingStore checks that every checkpoint is of published rows.
+type checkingStore struct {
+	*task.MemoryCheckpointStore
+	t       *testing.T
+	objects map[int][]string
+	puts    int
+}
+
+func (cs *checkingStore) Put(ctx context.Context, archive string, cp task.Checkpoint) error {
+	cs.puts++
+	ids := published(cs.objects)
+	if len(ids) != cp.Files || ids[cp.Files-1] != cp.Name {
+		cs.t.Errorf("Checkpoint %+v of unpublished rows %v", cp, ids)
+	}
+	return cs.MemoryCheckpointStore.Put(ctx, archive, cp)
+}
+
+func TestCheckpointResume(t *testing.T) {
+	var names []string
+	for i := 1; i <= 7; i++ {
+		names = append(names, fmt.Sprintf("test%d", i))
+	}
+	objects := map[int][]string{}
+	store := &checkingStore{MemoryCheckpointStore: task.NewMemoryCheckpointStore(), t: t, objects: objects}
+
+	// The first attempt fails after 5 tests, and its worker dies before
+	// closing the sink, so the rows after the last checkpoint are lost.
+	src := &failingSource{TestSource: makeArchive(t, names...), n: 5}
+	sink := newObjectSink(objects)
+	tt := task.NewTask("gs://archive/a.tgz", src, newRowParser(sink), &NullCloser{})
+	tt.SetCheckpointStore(store, sink, 2)
+	if _, err := tt.ProcessAllTests(false); err == nil {
+		t.Fatal("Expected an error from the failing source")
+	}
+	if store.puts != 2 {
+		t.Errorf("Expected checkpoints after test2 and test4, got %d", store.puts)
+	}
+	cp, _ := store.Get(context.Background(), "gs://archive/a.tgz")
+	if cp == nil || cp.Files != 4 || cp.Name != "test4" {
+		t.Fatalf("Checkpoint = %+v, want test4", cp)
+	}
+
+	// The retry parses only the remaining tests, and deletes the checkpoint.
+	sink = newObjectSink(objects)
+	tt = task.NewTask("gs://archive/a.tgz", makeArchive(t, names...), newRowParser(sink), sink)
+	tt.SetCheckpointStore(store, sink, 2)
+	files, err := tt.ProcessAllTests(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tt.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if files != 7 || tt.Summary().Resumed != 4 || tt.Summary().Parsed != 3 {
+		t.Errorf("ProcessAllTests() = %d files, summary %+v", files, tt.Summary())
+	}
+	if ids := published(objects); !reflect.DeepEqual(ids, names) {
+		t.Errorf("Published rows %v, want %v", ids, names)
+	}
+	if cp, _ := store.Get(context.Background(), "gs://archive/a.tgz"); cp != nil {
+		t.Errorf("Checkpoint %+v not deleted", cp)
+	}
+}
+
+func TestCheckpointMismatch(t *testing.T) {
+	tests := []struct {
+		name string
+		cp   task.Checkpoint
+	}{
+		{name: "name", cp: task.Checkpoint{Name: "other", Files: 2}},
+		{name: "short", cp: task.Checkpoint{Name: "test9", Files: 9}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			store := task.NewMemoryCheckpointStore()
+			ctx := context.Background()
+			store.Put(ctx, "gs://archive/a.tgz", tc.cp)
+			objects := map[int][]string{}
+			sink := newObjectSink(objects)
+			src := makeArchive(t, "test1", "test2", "test3")
+			tt := task.NewTask("gs://archive/a.tgz", src, newRowParser(sink), &NullCloser{})
+			tt.SetCheckpointStore(store, sink, 2)
+			if _, err := tt.ProcessAllTests(false); !errors.Is(err, task.ErrCheckpointMismatch) {
+				t.Errorf("ProcessAllTests() = %v, want ErrCheckpointMismatch", err)
+			}
+			if cp, _ := store.Get(ctx, "gs://archive/a.tgz"); cp != nil {
+				t.Errorf("Checkpoint %+v not deleted", cp)
+			}
+			if ids := published(objects); len(ids) != 0 {
+				t.Errorf("Published rows %v, want none", ids)
+			}
+		})
+	}
+}
+
+func TestCheckpointResume_GCS(t *testing.T) {
+	var names []string
+	for i := 1; i <= 7; i++ {
+		names = append(names, fmt.Sprintf("test%d", i))
+	}
+	server := fgs.NewServer([]fgs.Object{})
+	defer server.Stop()
+	server.CreateBucket("output")
+	sf := storage.NewSinkFactory(stiface.AdaptClient(server.Client()), "output")
+	dp, err := etl.ValidateTestPath(
+		"gs://archive/ndt/ndt7/2022/07/01/20220701T000000.000000Z-ndt7-mlab1-foo01-ndt.tgz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := task.NewMemoryCheckpointStore()
+
+	// The first attempt is killed after 5 tests: its uploads are abandoned,
+	// and its sink is never closed.
+	ctx, kill := context.WithCancel(context.Background())
+	sink, pErr := sf.Get(ctx, dp)
+	if pErr != nil {
+		t.Fatal(pErr)
+	}
+	src := &failingSource{TestSource: makeArchive(t, names...), n: 5}
+	tt := task.NewTask(dp.URI, src, newRowParser(sink), &NullCloser{})
+	tt.SetCheckpointStore(store, sink.(row.Syncer), 2)
+	if _, err := tt.ProcessAllTests(false); err == nil {
+		t.Fatal("Expected an error from the failing source")
+	}
+	kill()
+
+	// The retry resumes after the last checkpoint, and completes.
+	sink, pErr = sf.Get(context.Background(), dp)
+	if pErr != nil {
+		t.Fatal(pErr)
+	}
+	tt = task.NewTask(dp.URI, makeArchive(t, names...), newRowParser(sink), sink)
+	tt.SetCheckpointStore(store, sink.(row.Syncer), 2)
+	if _, err := tt.ProcessAllTests(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := tt.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if tt.Summary().Resumed != 4 {
+		t.Errorf("Resumed %d tests, want 4", tt.Summary().Resumed)
+	}
+
+	// Every row is in exactly one object.
+	objects, _, err := server.ListObjects("output", "", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{}
+	for _, o := range objects {
+		o, err := server.GetObject("output", o.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range strings.Split(strings.TrimSpace(string(o.Content)), "\n") {
+			var r testRow
+			if err := json.Unmarshal([]byte(line), &r); err != nil {
+				t.Fatalf("%s: %v", o.Name, err)
+			}
+			ids = append(ids, r.ID)
+		}
+	}
+	sort.Strings(ids)
+	if !reflect.DeepEqual(ids, names) {
+		t.Errorf("Rows in %d objects = %v, want %v", len(objects), ids, names)
+	}
+}
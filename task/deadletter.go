@@ -0,0 +1,47 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+
+	"github.com/m-lab/etl/metrics"
+	"github.com/m-lab/etl/row"
+)
+
+// DeadLetter records the content of tests that failed to parse, e.g. in
+// storage.DeadLetterWriter, so that they can be inspected and reprocessed.
+type DeadLetter interface {
+	Write(ctx context.Context, archive, testname string, data []byte, err error) error
+}
+
+// deadLetterTimeout limits the time to write each dead letter.
+const deadLetterTimeout = time.Minute
+
+// SetDeadLetter sets a DeadLetter to record each test that the parser fails
+// to parse.
+func (tt *Task) SetDeadLetter(d DeadLetter) {
+	tt.deadLetter = d
+}
+
+// writeDeadLetter records a test, with the task metadata meta, that failed
+// to parse with err, and reports whether it was recorded.  Row commit errors
+// are not the fault of the test, so those tests are not recorded.
+func (tt *Task) writeDeadLetter(meta map[string]bigquery.Value, testname string, data []byte, err error) bool {
+	if tt.deadLetter == nil || errors.As(err, &row.ErrCommitRow{}) {
+		return false
+	}
+	archive, _ := meta["filename"].(string)
+	ctx, cancel := context.WithTimeout(context.Background(), deadLetterTimeout)
+	defer cancel()
+	if werr := tt.deadLetter.Write(ctx, archive, testname, data, err); werr != nil {
+		log.Printf("Failed to write dead letter for %s %s: %v", archive, testname, werr)
+		metrics.DeadLetterCount.WithLabelValues(tt.Type(), "error").Inc()
+		return false
+	}
+	metrics.DeadLetterCount.WithLabelValues(tt.Type(), "ok").Inc()
+	return true
+}
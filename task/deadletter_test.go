@@ -0,0 +1,57 @@
+package task_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/go-test/deep"
+
+	"github.com/m-lab/etl/row"
+	"github.com/m-lab/etl/task"
+)
+
+// failingParser fails to parse "bar" as corrupt, and "foo" with a commit
+// error.
+type failingParser struct {
+	TestParser
+}
+
+func (fp *failingParser) ParseAndInsert(meta map[string]bigquery.Value, testName string, test []byte) error {
+	switch testName {
+	case "bar":
+		return errors.New("corrupt test")
+	case "foo":
+		return row.ErrCommitRow{Err: errors.New("sink error")}
+	}
+	return nil
+}
+
+// fakeDeadLetter records the dead letters, keyed by archive and test.
+type fakeDeadLetter struct {
+	letters map[string]string
+}
+
+func (f *fakeDeadLetter) Write(ctx context.Context, archive, testname string, data []byte, err error) error {
+	f.letters[archive+"/"+testname] = string(data) + ": " + err.Error()
+	return nil
+}
+
+func TestDeadLetter(t *testing.T) {
+	d := &fakeDeadLetter{letters: map[string]string{}}
+	tt := task.NewTask("gs://archive/a.tgz", MakeTestSource(t), &failingParser{}, &NullCloser{})
+	tt.SetMaxFileSize(100)
+	tt.SetDeadLetter(d)
+	if _, err := tt.ProcessAllTests(false); err != nil {
+		t.Fatal(err)
+	}
+	// The commit error is not the fault of the test, so only bar is recorded.
+	want := map[string]string{"gs://archive/a.tgz/bar": "butter milk: corrupt test"}
+	if diff := deep.Equal(d.letters, want); diff != nil {
+		t.Errorf("Dead letters = %v, diff %v", d.letters, diff)
+	}
+	if got := tt.Summary().DeadLettered; got != 1 {
+		t.Errorf("Summary().DeadLettered = %d, want 1", got)
+	}
+}
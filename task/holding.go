@@ -0,0 +1,295 @@
+package task
+
+import (
+	"path"
+	"strings"
+	"time"
+
+	"github.com/m-lab/etl/etl"
+	"github.com/m-lab/etl/metrics"
+)
+
+// Limits on the entries held back while waiting for companion files.  When
+// either is exceeded, the oldest group is released, complete or not.
+const (
+	maxHeldGroups = 16
+	maxHeldBytes  = 64 * 1024 * 1024
+)
+
+// maxLaterGroups is the number of later groups that may start while a group
+// waits for its companion files.  Companions may follow the next test, e.g. a
+// late .meta file, but incomplete groups are normal, e.g. an ndt test without
+// a c2s snaplog, so once more groups start, the group is released as is.
+const maxLaterGroups = 1
+
+// grouping describes the companion files that make up a single test, for data
+// types whose parsers need all of them together.
+type grouping struct {
+	// key returns the group key for a file.  Files with the same key belong
+	// to the same test.
+	key func(testname string) string
+	// members are the suffixes, after removing any .gz, of the files in a
+	// complete group.  Files with other suffixes are not held.
+	members []string
+}
+
+// member returns the index of the testname's suffix in members, or -1.
+func (g *grouping) member(testname string) int {
+	name := strings.TrimSuffix(testname, ".gz")
+	for i, m := range g.members {
+		if strings.HasSuffix(name, m) {
+			return i
+		}
+	}
+	return -1
+}
+
+// ndtKey returns the test timestamp, which precedes the first underscore, e.g.
+// 20170509T13:45:13.590210000Z_eb.measurementlab.net:44160.s2c_snaplog.gz
+// The c2s, s2c and meta files of a test use different ports, so the timestamp
+// is the only shared part of the name.
+func ndtKey(testname string) string {
+	base := path.Base(testname)
+	if i := strings.Index(base, "_"); i >= 0 {
+		return base[:i]
+	}
+	return base
+}
+
+// baseKey returns the file name without its extensions.
+func baseKey(testname string) string {
+	base := path.Base(testname)
+	if i := strings.Index(base, "."); i >= 0 {
+		return base[:i]
+	}
+	return base
+}
+
+// dataTypeToGrouping maps from data type to the companion files its parser
+// needs together.  Data types not listed are read in archive order.
+var dataTypeToGrouping = map[etl.DataType]*grouping{
+	etl.NDT:  {key: ndtKey, members: []string{"c2s_snaplog", "s2c_snaplog", ".meta"}},
+	etl.PCAP: {key: baseKey, members: []string{".pcap", ".json"}},
+}
+
+// heldTest is a test read from the source and not yet returned.
+type heldTest struct {
+	name    string
+	data    []byte
+	err     error     // Error from the source, e.g. storage.ErrOversizeFile.
+	modTime time.Time // From the source, if it implements etl.ModTimer.
+	size    int64     // From the source, if it implements etl.FileSizer, or -1.
+}
+
+// testGroup is the held files of a single test.
+type testGroup struct {
+	key   string
+	tests []heldTest
+	seen  []bool // Members seen, indexed as grouping.members.
+	later int    // Groups with companions started after this one.
+}
+
+// done returns whether the group is ready to be released: it is a file
+// without companions, it is complete, or too many later groups have started.
+func (tg *testGroup) done() bool {
+	return len(tg.seen) == 0 || tg.complete() || tg.later > maxLaterGroups
+}
+
+func (tg *testGroup) complete() bool {
+	for _, s := range tg.seen {
+		if !s {
+			return false
+		}
+	}
+	return true
+}
+
+// HoldingArea wraps a TestSource, and reorders its tests so that the companion
+// files of each test, e.g. the ndt snaplogs and .meta file, are returned
+// consecutively, even if the archive places some of them after other tests.
+// Groups are returned in the order their first file was read, so that the
+// archive order is otherwise preserved.  Incomplete groups are returned once
+// the test after the next one starts, or the source is exhausted.  To bound
+// memory, at most a few groups are held, and the oldest is returned, complete
+// or not, once the limits are reached.
+type HoldingArea struct {
+	etl.TestSource
+	grouping *grouping
+	table    string // For metrics.
+
+	queue  []*testGroup          // Held groups, in the order first read.
+	groups map[string]*testGroup // Held groups with companions, by key.
+	held   int                   // Bytes held.
+	ready  []*testGroup          // Released groups, not yet returned.
+	err    error                 // Error that ended the source, after ready tests.
+
+	modTime time.Time // ModTime of the test most recently returned.
+	size    int64     // FileSize of the test most recently returned.
+}
+
+// NewHoldingArea returns a TestSource that groups the companion files of the
+// data type, or src itself if the data type has no companion files.
+func NewHoldingArea(src etl.TestSource, dt etl.DataType) etl.TestSource {
+	g, ok := dataTypeToGrouping[dt]
+	if !ok {
+		return src
+	}
+	return &HoldingArea{TestSource: src, grouping: g, table: dt.Table(), groups: map[string]*testGroup{}, size: -1}
+}
+
+// release moves the oldest held group to the ready list.
+func (h *HoldingArea) release() {
+	tg := h.queue[0]
+	h.queue = h.queue[1:]
+	if h.groups[tg.key] == tg {
+		delete(h.groups, tg.key)
+	}
+	for _, t := range tg.tests {
+		h.held -= len(t.data)
+	}
+	h.ready = append(h.ready, tg)
+}
+
+// hold adds a test to its group, starting a new group if needed.
+func (h *HoldingArea) hold(name string, data []byte, modTime time.Time, size int64) {
+	h.held += len(data)
+	m := -1
+	if data != nil {
+		m = h.grouping.member(name)
+	}
+	if m < 0 {
+		// Not a companion file, so it forms a group of its own.
+		h.queue = append(h.queue, &testGroup{key: name, tests: []heldTest{{name: name, data: data, modTime: modTime, size: size}}})
+		return
+	}
+	key := h.grouping.key(name)
+	tg, ok := h.groups[key]
+	if !ok {
+		for _, g := range h.queue {
+			g.later++
+		}
+		tg = &testGroup{key: key, seen: make([]bool, len(h.grouping.members))}
+		h.groups[key] = tg
+		h.queue = append(h.queue, tg)
+	}
+	tg.tests = append(tg.tests, heldTest{name: name, data: data, modTime: modTime, size: size})
+	tg.seen[m] = true
+}
+
+// drain releases all held groups, oldest first, complete or not.
+func (h *HoldingArea) drain() {
+	for len(h.queue) > 0 {
+		h.release()
+	}
+}
+
+// Peek implements etl.Peeker, if the source does.  Tests already read are not
+// peeked, since their content is already in memory.
+func (h *HoldingArea) Peek() (string, int64, bool) {
+	p, ok := h.TestSource.(etl.Peeker)
+	if !ok || len(h.ready) > 0 || h.err != nil {
+		return "", 0, false
+	}
+	return p.Peek()
+}
+
+// fill reads from the source until a group is ready, or the source ends.
+func (h *HoldingArea) fill(maxSize int64) {
+	for len(h.ready) == 0 && h.err == nil {
+		name, data, err := h.TestSource.NextTest(maxSize)
+		var modTime time.Time
+		if mt, ok := h.TestSource.(etl.ModTimer); ok {
+			modTime = mt.ModTime()
+		}
+		size := int64(-1)
+		if fs, ok := h.TestSource.(etl.FileSizer); ok {
+			size = fs.FileSize()
+		}
+		if err != nil && name == "" {
+			// io.EOF, or an error that ends the source.  Return the held
+			// tests before the error.
+			h.err = err
+			h.drain()
+			break
+		}
+		if err != nil {
+			// Errors such as ErrOversizeFile do not end the source, so the
+			// test is queued, with its error, like a file without companions.
+			h.queue = append(h.queue, &testGroup{key: name, tests: []heldTest{{name, data, err, modTime, size}}})
+		} else {
+			h.hold(name, data, modTime, size)
+		}
+		for len(h.queue) > 0 && h.queue[0].done() {
+			h.release()
+		}
+		for len(h.queue) > maxHeldGroups || h.held > maxHeldBytes {
+			metrics.WarningCount.WithLabelValues(
+				h.table, "group", "holding area full").Inc()
+			h.release()
+		}
+	}
+}
+
+// NextTest implements etl.TestSource.
+func (h *HoldingArea) NextTest(maxSize int64) (string, []byte, error) {
+	h.fill(maxSize)
+	if len(h.ready) == 0 {
+		return "", nil, h.err
+	}
+	tg := h.ready[0]
+	t := tg.tests[0]
+	tg.tests = tg.tests[1:]
+	if len(tg.tests) == 0 {
+		h.ready = h.ready[1:]
+	}
+	h.modTime = t.modTime
+	h.size = t.size
+	return t.name, t.data, t.err
+}
+
+// ModTime implements etl.ModTimer.  It returns the zero time if the source
+// does not implement etl.ModTimer.
+func (h *HoldingArea) ModTime() time.Time {
+	return h.modTime
+}
+
+// FileSize implements etl.FileSizer.  It returns -1 if the source does not
+// implement etl.FileSizer.
+func (h *HoldingArea) FileSize() int64 {
+	return h.size
+}
+
+// NextGroup returns the remaining files of the next test, keyed by file name,
+// along with the group key, e.g. the ndt test timestamp.  ModTime then returns
+// the latest ModTime of the files, and FileSize their total size, or -1 if the
+// size of any file is unknown.  Groups may be
+// incomplete if companion files are missing or arrive too late.  A file that
+// could not be read, e.g. because it exceeds maxSize, is returned alone, as
+// the key, with nil files and the error.  Returns io.EOF when there are no
+// more tests.
+func (h *HoldingArea) NextGroup(maxSize int64) (string, map[string][]byte, error) {
+	h.fill(maxSize)
+	if len(h.ready) == 0 {
+		return "", nil, h.err
+	}
+	tg := h.ready[0]
+	h.ready = h.ready[1:]
+	h.modTime, h.size = time.Time{}, 0
+	if len(tg.tests) == 1 && tg.tests[0].err != nil {
+		h.size = tg.tests[0].size
+		return tg.tests[0].name, nil, tg.tests[0].err
+	}
+	files := make(map[string][]byte, len(tg.tests))
+	for _, t := range tg.tests {
+		files[t.name] = t.data
+		if t.modTime.After(h.modTime) {
+			h.modTime = t.modTime
+		}
+		if t.size < 0 || h.size < 0 {
+			h.size = -1
+		} else {
+			h.size += t.size
+		}
+	}
+	return tg.key, files, nil
+}
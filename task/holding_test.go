@@ -0,0 +1,208 @@
+package task_test
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/civil"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/m-lab/etl/etl"
+	"github.com/m-lab/etl/metrics"
+	"github.com/m-lab/etl/storage"
+	"github.com/m-lab/etl/task"
+)
+
+// fakeSource returns the named tests in order, each with non-empty data.
+// Names starting with "big" are returned with storage.ErrOversizeFile.
+type fakeSource struct {
+	names []string
+}
+
+func (fs *fakeSource) NextTest(maxSize int64) (string, []byte, error) {
+	if len(fs.names) == 0 {
+		return "", nil, io.EOF
+	}
+	name := fs.names[0]
+	fs.names = fs.names[1:]
+	if strings.HasPrefix(name, "big") {
+		return name, nil, storage.ErrOversizeFile
+	}
+	return name, []byte(name), nil
+}
+
+func (fs *fakeSource) Close() error     { return nil }
+func (fs *fakeSource) Detail() string   { return "fake" }
+func (fs *fakeSource) Type() string     { return "fake" }
+func (fs *fakeSource) Date() civil.Date { return civil.Date{} }
+
+func readAll(t *testing.T, src etl.TestSource) []string {
+	names := []string{}
+	for {
+		name, _, err := src.NextTest(100)
+		if err == io.EOF {
+			return names
+		}
+		if err != nil && err != storage.ErrOversizeFile {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+}
+
+func TestHoldingArea(t *testing.T) {
+	tests := []struct {
+		name string
+		dt   etl.DataType
+		in   []string
+		want []string
+	}{
+		{
+			name: "ndt-meta-last",
+			dt:   etl.NDT,
+			in: []string{
+				"20170509T13:45:13.5Z_host:1.c2s_snaplog.gz",
+				"20170509T13:45:13.5Z_host:2.s2c_snaplog.gz",
+				"20170509T14:00:00.0Z_host:3.c2s_snaplog.gz",
+				"20170509T14:00:00.0Z_host:4.s2c_snaplog.gz",
+				"20170509T14:00:00.0Z_host.meta",
+				"20170509T13:45:13.5Z_host.meta",
+			},
+			want: []string{
+				"20170509T13:45:13.5Z_host:1.c2s_snaplog.gz",
+				"20170509T13:45:13.5Z_host:2.s2c_snaplog.gz",
+				"20170509T13:45:13.5Z_host.meta",
+				"20170509T14:00:00.0Z_host:3.c2s_snaplog.gz",
+				"20170509T14:00:00.0Z_host:4.s2c_snaplog.gz",
+				"20170509T14:00:00.0Z_host.meta",
+			},
+		},
+		{
+			name: "pcap-json-first",
+			dt:   etl.PCAP,
+			in:   []string{"a.json", "other.txt", "b.pcap.gz", "a.pcap.gz", "b.json"},
+			want: []string{"a.json", "a.pcap.gz", "other.txt", "b.pcap.gz", "b.json"},
+		},
+		{
+			name: "oversize-queued",
+			dt:   etl.PCAP,
+			in:   []string{"a.pcap", "big.pcap", "a.json"},
+			want: []string{"a.pcap", "a.json", "big.pcap"},
+		},
+		{
+			name: "incomplete-at-eof",
+			dt:   etl.PCAP,
+			in:   []string{"a.pcap", "b.json"},
+			want: []string{"a.pcap", "b.json"},
+		},
+		{
+			name: "no-grouping",
+			dt:   etl.SS,
+			in:   []string{"b", "a"},
+			want: []string{"b", "a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := task.NewHoldingArea(&fakeSource{names: tt.in}, tt.dt)
+			got := readAll(t, src)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NextTest() order = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// sizedSource is a fakeSource that reports the length of each name as the
+// FileSize of its test.
+type sizedSource struct {
+	fakeSource
+	size int64
+}
+
+func (ss *sizedSource) NextTest(maxSize int64) (string, []byte, error) {
+	name, data, err := ss.fakeSource.NextTest(maxSize)
+	ss.size = int64(len(name))
+	return name, data, err
+}
+
+func (ss *sizedSource) FileSize() int64 { return ss.size }
+
+func TestHoldingAreaFileSize(t *testing.T) {
+	src := task.NewHoldingArea(&sizedSource{fakeSource: fakeSource{names: []string{"a.pcap", "other.txt", "a.json"}}}, etl.PCAP)
+	h := src.(*task.HoldingArea)
+	if got := h.FileSize(); got != -1 {
+		t.Errorf("FileSize() before NextTest = %d, want -1", got)
+	}
+	key, files, err := h.NextGroup(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("NextGroup(%s) returned %d files, want 2", key, len(files))
+	}
+	if got := h.FileSize(); got != int64(len("a.pcap")+len("a.json")) {
+		t.Errorf("FileSize() of group = %d, want %d", got, len("a.pcap")+len("a.json"))
+	}
+	name, _, err := h.NextTest(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := h.FileSize(); got != int64(len(name)) {
+		t.Errorf("FileSize() of %s = %d, want %d", name, got, len(name))
+	}
+
+	// Sources without FileSize report unknown sizes.
+	plain := task.NewHoldingArea(&fakeSource{names: []string{"a.pcap"}}, etl.PCAP).(*task.HoldingArea)
+	if _, _, err := plain.NextTest(100); err != nil {
+		t.Fatal(err)
+	}
+	if got := plain.FileSize(); got != -1 {
+		t.Errorf("FileSize() = %d, want -1", got)
+	}
+}
+
+func TestHoldingAreaIncomplete(t *testing.T) {
+	// Each ndt test is missing its c2s snaplog, which is normal.
+	names := []string{}
+	for i := 0; i < 20; i++ {
+		ts := fmt.Sprintf("20170509T13:%02d:00.0Z", i)
+		names = append(names, ts+"_host:1.s2c_snaplog.gz", ts+"_host.meta")
+	}
+	fs := &fakeSource{names: names}
+	h := task.NewHoldingArea(fs, etl.NDT).(*task.HoldingArea)
+	full := metrics.WarningCount.WithLabelValues(etl.NDT.Table(), "group", "holding area full")
+	warnings := testutil.ToFloat64(full)
+
+	key, files, err := h.NextGroup(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "20170509T13:00:00.0Z" || len(files) != 2 {
+		t.Errorf("NextGroup() = %s, %v, want the first test's 2 files", key, files)
+	}
+	// The group is released once the test after the next one starts.
+	if read := len(names) - len(fs.names); read != 5 {
+		t.Errorf("NextGroup() read %d files, want 5", read)
+	}
+	groups := 1
+	for {
+		_, _, err := h.NextGroup(100)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		groups++
+	}
+	if groups != 20 {
+		t.Errorf("NextGroup() returned %d groups, want 20", groups)
+	}
+	if got := testutil.ToFloat64(full); got != warnings {
+		t.Errorf("holding area full warnings = %v, want %v", got, warnings)
+	}
+}
@@ -0,0 +1,72 @@
+package task
+
+import (
+	"context"
+	"strings"
+
+	"golang.org/x/sync/semaphore"
+
+	"github.com/m-lab/etl/etl"
+)
+
+// minTestMemory is the smallest estimate for any test, covering fixed
+// per-test allocations such as decoders and row structs.
+const minTestMemory = 64 * 1024
+
+// zstdExpansion is the typical decompression ratio for zstd compressed tests.
+const zstdExpansion = 10
+
+// dataTypeToGzipExpansion maps from data type to the typical decompression
+// ratio of its gzipped tests.  Data types not listed use defaultGzipExpansion.
+var dataTypeToGzipExpansion = map[etl.DataType]int64{
+	etl.NDT:  8, // Snaplogs compress very well.
+	etl.PCAP: 2, // Packet payloads are mostly incompressible.
+}
+
+const defaultGzipExpansion = 5
+
+// EstimateMemory returns a heuristic estimate of the peak memory, in bytes,
+// needed to parse a test with the given name and archived size, as recorded in
+// its tar header.  Compressed tests are scaled up by the typical decompression
+// ratio for the data type, so the estimate can be made before the test is
+// read.  The result is scaled by the ParseFactor of the data type's Profile.
+func EstimateMemory(dt etl.DataType, testname string, size int64) int64 {
+	switch {
+	case strings.HasSuffix(testname, ".gz"):
+		expansion, ok := dataTypeToGzipExpansion[dt]
+		if !ok {
+			expansion = defaultGzipExpansion
+		}
+		size *= expansion
+	case strings.HasSuffix(testname, ".zst"):
+		size *= zstdExpansion
+	}
+	return size*dt.Profile().ParseFactor + minTestMemory
+}
+
+// MemoryGate limits the total estimated memory of the tests being parsed
+// concurrently, across all tasks that share it.  This admits many small tests
+// at once, while preventing several highly compressible large tests from
+// exhausting memory together.
+type MemoryGate struct {
+	limit int64
+	sem   *semaphore.Weighted
+}
+
+// NewMemoryGate creates a MemoryGate admitting up to limit bytes.
+func NewMemoryGate(limit int64) *MemoryGate {
+	return &MemoryGate{limit: limit, sem: semaphore.NewWeighted(limit)}
+}
+
+// Acquire blocks until n bytes are available, or the context is done.  An
+// estimate larger than the limit is reduced to the limit, so the test runs
+// alone rather than never.  On success, the caller must call release.
+func (g *MemoryGate) Acquire(ctx context.Context, n int64) (release func(), err error) {
+	if n > g.limit {
+		n = g.limit
+	}
+	if err := g.sem.Acquire(ctx, n); err != nil {
+		return nil, err
+	}
+	return func() { g.sem.Release(n) }, nil
+}
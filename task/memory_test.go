@@ -0,0 +1,64 @@
+package task_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/m-lab/etl/etl"
+	"github.com/m-lab/etl/task"
+)
+
+func TestEstimateMemory(t *testing.T) {
+	tests := []struct {
+		name     string
+		dt       etl.DataType
+		testname string
+		size     int64
+		want     int64
+	}{
+		{"default", etl.NDT7, "foo.json", 1000, 3*1000 + 64*1024},
+		{"listed", etl.SW, "foo-switch.jsonl", 1000, 4*1000 + 64*1024},
+		{"zstd", etl.TCPINFO, "foo.jsonl.zst", 1000, 3*10*1000 + 64*1024},
+		{"gzip-listed", etl.NDT, "foo.c2s_snaplog.gz", 1000, 2*8*1000 + 64*1024},
+		{"gzip-default", etl.NDT7, "foo.json.gz", 1000, 3*5*1000 + 64*1024},
+		{"empty", etl.PCAP, "foo.pcap", 0, 64 * 1024},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := task.EstimateMemory(tt.dt, tt.testname, tt.size); got != tt.want {
+				t.Errorf("EstimateMemory() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryGate(t *testing.T) {
+	g := task.NewMemoryGate(100)
+	ctx := context.Background()
+
+	r1, err := g.Acquire(ctx, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2, err := g.Acquire(ctx, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The gate is full, so further requests block.
+	tctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := g.Acquire(tctx, 1); err == nil {
+		t.Error("Acquire() succeeded on full gate")
+	}
+	r1()
+	r2()
+
+	// Requests larger than the limit are admitted alone.
+	r3, err := g.Acquire(ctx, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r3()
+}
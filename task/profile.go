@@ -0,0 +1,91 @@
+package task
+
+import (
+	"cloud.google.com/go/civil"
+
+	"github.com/m-lab/etl/row"
+	"github.com/m-lab/etl/schema"
+)
+
+// sizeBins are the minimum sizes, in bytes, of the file size histogram bins,
+// growing by a factor of 4 from 1KB to 1GB.
+var sizeBins = []float64{0, 1 << 10, 1 << 12, 1 << 14, 1 << 16, 1 << 18,
+	1 << 20, 1 << 22, 1 << 24, 1 << 26, 1 << 28, 1 << 30}
+
+// ratioBins are the minimum compression ratios of the ratio histogram bins.
+var ratioBins = []float64{0, 1, 1.5, 2, 3, 4, 6, 8, 12, 16, 24, 32}
+
+// FileProfiler records the distribution of the sizes and compression ratios
+// of the test files in an archive, and writes it as a single FileStatsRow
+// when it is closed.
+// FileProfiler is NOT THREAD-SAFE.
+type FileProfiler struct {
+	base   *row.Base
+	sink   row.Sink
+	row    schema.FileStatsRow
+	sizes  []int64 // Counts for each of sizeBins.
+	ratios []int64 // Counts for each of ratioBins.
+}
+
+// NewFileProfiler creates a FileProfiler for an archive, that writes its row
+// to the sink.
+func NewFileProfiler(sink row.Sink, datatype, archiveURL string, date civil.Date) *FileProfiler {
+	return &FileProfiler{
+		base: row.NewBase("file_stats", sink, 1),
+		sink: sink,
+		row: schema.FileStatsRow{
+			ArchiveURL: archiveURL,
+			Datatype:   datatype,
+			Date:       date,
+		},
+		sizes:  make([]int64, len(sizeBins)),
+		ratios: make([]int64, len(ratioBins)),
+	}
+}
+
+// Observe records a test file, of archived size, or -1 if that is not known,
+// and of size bytes once decompressed.
+func (fp *FileProfiler) Observe(archived, size int64) {
+	fp.row.Files++
+	fp.row.Bytes += size
+	fp.sizes[bin(sizeBins, float64(size))]++
+	if archived <= 0 {
+		return
+	}
+	fp.row.ArchivedBytes += archived
+	fp.ratios[bin(ratioBins, float64(size)/float64(archived))]++
+}
+
+// Close writes the FileStatsRow, and closes the sink.
+func (fp *FileProfiler) Close() error {
+	fp.row.Sizes = histogram(sizeBins, fp.sizes)
+	fp.row.Ratios = histogram(ratioBins, fp.ratios)
+	err := fp.base.Put(&fp.row)
+	if err == nil {
+		err = fp.base.Flush()
+	}
+	if cerr := fp.sink.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// bin returns the index of the last of bins that is no more than v.
+func bin(bins []float64, v float64) int {
+	i := 0
+	for i+1 < len(bins) && bins[i+1] <= v {
+		i++
+	}
+	return i
+}
+
+// histogram returns the bins with non-zero counts.
+func histogram(bins []float64, counts []int64) []schema.HistogramBin {
+	h := []schema.HistogramBin{}
+	for i, c := range counts {
+		if c > 0 {
+			h = append(h, schema.HistogramBin{Min: bins[i], Count: c})
+		}
+	}
+	return h
+}
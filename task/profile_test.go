@@ -0,0 +1,63 @@
+package task_test
+
+import (
+	"testing"
+
+	"cloud.google.com/go/civil"
+	"github.com/go-test/deep"
+
+	"github.com/m-lab/etl/schema"
+	"github.com/m-lab/etl/task"
+)
+
+// rowSink is a row.Sink that keeps the committed rows.
+type rowSink struct {
+	rows   []interface{}
+	closed bool
+}
+
+func (s *rowSink) Commit(rows []interface{}, label string) (int, error) {
+	s.rows = append(s.rows, rows...)
+	return len(rows), nil
+}
+
+func (s *rowSink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestFileProfiler(t *testing.T) {
+	sink := &rowSink{}
+	date := civil.Date{Year: 2022, Month: 7, Day: 1}
+	p := task.NewFileProfiler(sink, "test", "gs://fake/test.tgz", date)
+
+	tt := task.NewTask("gs://fake/test.tgz", MakeTestSource(t), &TestParser{}, &NullCloser{})
+	tt.SetFileProfiler(p)
+	if _, err := tt.ProcessAllTests(false); err != nil {
+		t.Fatal(err)
+	}
+	// Files compressed within the archive have larger ratios.
+	p.Observe(1000, 5000)
+	p.Observe(-1, 2<<20)
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !sink.closed || len(sink.rows) != 1 {
+		t.Fatalf("Close() committed %d rows, closed %v", len(sink.rows), sink.closed)
+	}
+	want := &schema.FileStatsRow{
+		ArchiveURL:    "gs://fake/test.tgz",
+		Datatype:      "test",
+		Date:          date,
+		Files:         5,
+		ArchivedBytes: 1120,
+		Bytes:         120 + 5000 + 2<<20,
+		Sizes: []schema.HistogramBin{
+			{Min: 0, Count: 3}, {Min: 4096, Count: 1}, {Min: 1 << 20, Count: 1}},
+		Ratios: []schema.HistogramBin{
+			{Min: 1, Count: 3}, {Min: 4, Count: 1}},
+	}
+	if diff := deep.Equal(sink.rows[0], want); diff != nil {
+		t.Errorf("FileStatsRow differs: %v", diff)
+	}
+}
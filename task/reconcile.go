@@ -0,0 +1,82 @@
+package task
+
+import (
+	"log"
+
+	"github.com/m-lab/etl/metrics"
+	"github.com/m-lab/etl/row"
+)
+
+// Reconciliation compares the row counts of a task from its three sources:
+// the parser's row stats, the sink's own commit count, and the row counts
+// that the tests themselves report as expected.
+type Reconciliation struct {
+	Accepted  int // Rows accepted by the parser.
+	Committed int // Rows the parser counted as committed.
+	Failed    int // Rows the parser counted as failed.
+	Buffered  int // Rows still in the parser's buffer after the final flush.
+
+	// SinkCommitted is the sink's count of committed rows, or -1 if the sink
+	// does not implement row.Counter.
+	SinkCommitted int
+
+	Expected int // Rows expected by counted tests, from Summary.
+	Emitted  int // Rows emitted by counted tests, from Summary.
+}
+
+// Mismatches returns the kinds of disagreement between the counts, or nil if
+// they all agree.  The kinds are "unaccounted", if Accepted is not the sum of
+// Committed, Failed, and Buffered; "sink", if the sink committed a different
+// number of rows than the parser; and "expected", if the counted tests emitted
+// a different number of rows than they expected.
+func (r Reconciliation) Mismatches() []string {
+	var m []string
+	if r.Accepted != r.Committed+r.Failed+r.Buffered {
+		m = append(m, "unaccounted")
+	}
+	if r.SinkCommitted >= 0 && r.SinkCommitted != r.Committed {
+		m = append(m, "sink")
+	}
+	if r.Emitted != r.Expected {
+		m = append(m, "expected")
+	}
+	return m
+}
+
+// SetSinkCounter sets the sink whose commit count is reconciled with the
+// parser's row stats at the end of ProcessAllTests.
+func (tt *Task) SetSinkCounter(c row.Counter) {
+	tt.sinkCounter = c
+}
+
+// Reconciliation returns the row count reconciliation from ProcessAllTests.
+func (tt *Task) Reconciliation() Reconciliation {
+	return tt.reconciliation
+}
+
+// reconcile compares the parser, sink, and expected row counts after the
+// final flush.  If they disagree, it logs the reconciliation record, and
+// counts each kind of mismatch.
+func (tt *Task) reconcile() {
+	r := Reconciliation{
+		Accepted:      tt.Parser.Accepted(),
+		Committed:     tt.Parser.Committed(),
+		Failed:        tt.Parser.Failed(),
+		Buffered:      tt.Parser.RowsInBuffer(),
+		SinkCommitted: -1,
+		Expected:      tt.summary.Expected,
+		Emitted:       tt.summary.Emitted,
+	}
+	if tt.sinkCounter != nil {
+		r.SinkCommitted = tt.sinkCounter.Committed()
+	}
+	tt.reconciliation = r
+	m := r.Mismatches()
+	if len(m) == 0 {
+		return
+	}
+	log.Printf("Row reconciliation mismatch %v for %s: %+v", m, tt.meta["filename"], r)
+	for _, kind := range m {
+		metrics.RowReconcileCount.WithLabelValues(tt.Type(), kind).Inc()
+	}
+}
@@ -8,8 +8,12 @@ package task
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"cloud.google.com/go/bigquery"
@@ -33,6 +37,37 @@ type Factory interface {
 // This can be overridden with SetMaxFileSize()
 const DefaultMaxFileSize = 200 * 1024 * 1024
 
+// ErrTestTimeout is returned when a single test takes longer than the task's
+// test timeout to parse.
+var ErrTestTimeout = errors.New("test parsing timed out")
+
+// ErrParserAbandoned is returned when a test times out, and the parser does
+// not stop, so that the task must be abandoned.
+var ErrParserAbandoned = errors.New("timed out parser did not stop")
+
+// Summary counts the tests and rows processed by a Task.  Row counts include
+// only tests whose parser implements etl.RowCounter and reported a count.
+type Summary struct {
+	Files   int // Files read from the archive.
+	NilData int // Files with no data, e.g. directories.
+	Resumed int // Files skipped, since a checkpoint shows they were parsed.
+	Parsed  int // Tests passed to the parser.
+	Counted int // Parsed tests that reported an expected row count.
+
+	DeadLettered int // Tests that failed to parse, recorded by the DeadLetter.
+
+	Expected int // Rows expected by counted tests.
+	Emitted  int // Rows emitted by counted tests.
+	NoRows   int // Counted tests that expected, and emitted, no rows.
+	Short    int // Counted tests that emitted fewer rows than expected.
+	Dropped  int // Total shortfall of rows over all Short tests.
+
+	// Empty is set if the whole archive was read, and none of its files held
+	// data, e.g. a placeholder uploaded with no files, or only directories.
+	// Such a task succeeds, with no tests.
+	Empty bool
+}
+
 // Task contains the state required to process a single task tar file.
 // TODO(dev) Add unit tests for meta data.
 type Task struct {
@@ -43,6 +78,18 @@ type Task struct {
 
 	meta        map[string]bigquery.Value // Metadata about this task.
 	maxFileSize int64                     // Max file size to avoid OOM.
+	testTimeout time.Duration             // Max time to parse each test, or 0 for no limit.
+	memoryGate  *MemoryGate               // Limits concurrent parse memory, if non-nil.
+	profiler    *FileProfiler             // Records file sizes, if non-nil.
+	reserved    func()                    // Releases memory reserved for the next test.
+	checkpoints *checkpointer             // Records progress, if non-nil.
+	deadLetter  DeadLetter                // Records tests that fail to parse, if non-nil.
+	summary     Summary                   // Counts for the most recent ProcessAllTests.
+
+	filesRead      int64          // Files read so far, updated atomically.
+	sinkCounter    row.Counter    // Sink commit count to reconcile, if non-nil.
+	discarder      row.Discarder  // Sink to discard if the task fails, if non-nil.
+	reconciliation Reconciliation // Row counts for the most recent ProcessAllTests.
 
 	closer io.Closer // So we can call Close()
 }
@@ -64,10 +111,23 @@ func NewTask(filename string, src etl.TestSource, prsr etl.Parser, closer io.Clo
 	return &t
 }
 
-// Close closes the source and sink.
-func (tt *Task) Close() {
-	tt.TestSource.Close()
-	tt.closer.Close()
+// Close closes the source and sink, and returns all of their errors.
+func (tt *Task) Close() error {
+	return storage.MultiCloser{tt.TestSource, tt.closer}.Close()
+}
+
+// SetSinkDiscarder sets the sink whose rows Discard drops.
+func (tt *Task) SetSinkDiscarder(d row.Discarder) {
+	tt.discarder = d
+}
+
+// Discard drops the rows of a failed task from a sink that publishes them
+// only when closed, so that a retry does not duplicate them.  It must be
+// called before Close.
+func (tt *Task) Discard() {
+	if tt.discarder != nil {
+		tt.discarder.Discard()
+	}
 }
 
 // SetMaxFileSize overrides the default maxFileSize.
@@ -75,6 +135,248 @@ func (tt *Task) SetMaxFileSize(max int64) {
 	tt.maxFileSize = max
 }
 
+// SetTestTimeout sets the maximum time allowed to parse each test.  Zero, the
+// default, allows unlimited time.  At about 15µs per snapshot, even a 200MB
+// tcpinfo test parses in seconds, so a timeout of a minute stops only runaway
+// parses.
+func (tt *Task) SetTestTimeout(timeout time.Duration) {
+	tt.testTimeout = timeout
+}
+
+// SetMemoryGate sets a MemoryGate to acquire before parsing each test.
+func (tt *Task) SetMemoryGate(g *MemoryGate) {
+	tt.memoryGate = g
+}
+
+// SetFileProfiler sets a FileProfiler to record the size of each test.  The
+// caller is responsible for closing it, after ProcessAllTests.
+func (tt *Task) SetFileProfiler(p *FileProfiler) {
+	tt.profiler = p
+}
+
+// profile records the size of the most recently read test, or group of tests,
+// with the profiler, if any.
+func (tt *Task) profile(size int64) {
+	if tt.profiler == nil {
+		return
+	}
+	archived := int64(-1)
+	if fs, ok := tt.TestSource.(etl.FileSizer); ok {
+		archived = fs.FileSize()
+	}
+	tt.profiler.Observe(archived, size)
+}
+
+// nextTest reads the next test.  If there is a memory gate and the source can
+// Peek, memory for the test is reserved, using its archived size, before its
+// content is read.  The reservation is kept in tt.reserved until it is taken
+// for parsing, or released by the following nextTest.
+func (tt *Task) nextTest() (string, []byte, error) {
+	tt.releaseReserved()
+	if p, ok := tt.TestSource.(etl.Peeker); ok && tt.memoryGate != nil {
+		if name, size, ok := p.Peek(); ok && size <= tt.maxFileSize {
+			// Acquire cannot fail with a background context.
+			tt.reserved, _ = tt.memoryGate.Acquire(context.Background(),
+				EstimateMemory(etl.DataType(tt.Type()), name, size))
+		}
+	}
+	return tt.NextTest(tt.maxFileSize)
+}
+
+// setTestMeta updates the metadata for the named test, which is about to be
+// parsed.  The "date" is the measurement date from the test file name, if the
+// data type has one, so that tests in late archives are assigned to the
+// correct partition.  The "mod_time" is the modification time of the test
+// file, if the source reports it, so that parsers can compare it with the
+// test's own timestamps.  The "file_size" is the archived size of the test
+// file, or -1, if the source reports it.
+func (tt *Task) setTestMeta(testname string) {
+	date := etl.DataType(tt.Type()).TestDate(testname, tt.Date())
+	if date != tt.Date() {
+		metrics.WarningCount.WithLabelValues(
+			tt.TableName(), tt.Type(), "test date differs from archive").Inc()
+	}
+	tt.meta["date"] = date
+	if mt, ok := tt.TestSource.(etl.ModTimer); ok {
+		tt.meta["mod_time"] = mt.ModTime()
+	}
+	if fs, ok := tt.TestSource.(etl.FileSizer); ok {
+		tt.meta["file_size"] = fs.FileSize()
+	}
+}
+
+// releaseReserved releases any memory reserved by nextTest.
+func (tt *Task) releaseReserved() {
+	if tt.reserved != nil {
+		tt.reserved()
+		tt.reserved = nil
+	}
+}
+
+// parse calls fn, which parses the named test, with a context that is done
+// after the test timeout, and calls done once fn returns.  If fn stops with
+// the context's error, parse returns ErrTestTimeout, and the task may
+// continue with the next test.
+//
+// An etl.Abandoner that does not implement etl.ContextParser cannot be
+// stopped, so it is given another timeout period to finish.  If it is still
+// running, it is abandoned, parse returns ErrParserAbandoned, and done is
+// called only when fn eventually returns.  The caller must then not use the
+// parser again.
+//
+// Parsers that implement neither interface could keep writing rows after
+// the task is closed, so the timeout does not apply to them.
+func (tt *Task) parse(testname string, fn func(ctx context.Context) error, done func()) error {
+	if tt.testTimeout <= 0 || !tt.stoppable() {
+		defer done()
+		return fn(context.Background())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), tt.testTimeout)
+	defer cancel()
+	result := make(chan error, 1)
+	go func() {
+		defer done()
+		result <- fn(ctx)
+	}()
+	var err error
+	select {
+	case err = <-result:
+	case <-ctx.Done():
+		select {
+		case err = <-result:
+		case <-time.After(tt.testTimeout):
+			if a, ok := tt.Parser.(etl.Abandoner); ok {
+				a.Abandon()
+			}
+			return fmt.Errorf("%w: %s after %v", ErrParserAbandoned, testname, 2*tt.testTimeout)
+		}
+	}
+	if err != nil && ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+		return fmt.Errorf("%w: %s after %v", ErrTestTimeout, testname, tt.testTimeout)
+	}
+	return err
+}
+
+// stoppable returns whether the test timeout applies to the parser, because
+// it stops when its context is done, or can be abandoned.
+func (tt *Task) stoppable() bool {
+	if _, ok := tt.Parser.(etl.ContextParser); ok {
+		return true
+	}
+	_, ok := tt.Parser.(etl.Abandoner)
+	return ok
+}
+
+// parseTest parses a test, stopping when ctx is done if the parser is an
+// etl.ContextParser.
+func (tt *Task) parseTest(ctx context.Context, meta map[string]bigquery.Value, testname string, data []byte) error {
+	if cp, ok := tt.Parser.(etl.ContextParser); ok {
+		return cp.ParseAndInsertContext(ctx, meta, testname, data)
+	}
+	return tt.Parser.ParseAndInsert(meta, testname, data)
+}
+
+// FilesRead returns the number of files read so far by ProcessAllTests.  It
+// may be called while ProcessAllTests is running, to report progress.
+func (tt *Task) FilesRead() int {
+	return int(atomic.LoadInt64(&tt.filesRead))
+}
+
+// Summary returns the test and row counts from ProcessAllTests.
+func (tt *Task) Summary() Summary {
+	return tt.summary
+}
+
+// countRows updates the summary with the rows expected and emitted by the
+// named test, which was the most recently parsed.
+func (tt *Task) countRows(testname string, accepted int) {
+	rc, ok := tt.Parser.(etl.RowCounter)
+	if !ok {
+		return
+	}
+	expected := rc.ExpectedRows(testname)
+	if expected < 0 {
+		return
+	}
+	emitted := tt.Parser.Accepted() - accepted
+	s := &tt.summary
+	s.Counted++
+	s.Expected += expected
+	s.Emitted += emitted
+	switch {
+	case expected == 0 && emitted == 0:
+		s.NoRows++
+	case emitted < expected:
+		s.Short++
+		s.Dropped += expected - emitted
+		metrics.WarningCount.WithLabelValues(
+			tt.TableName(), tt.Type(), "fewer rows than expected").Inc()
+	}
+}
+
+// skipReadError logs and counts an error reading the named test, and reports
+// whether the test can be skipped.  Otherwise, the task should stop.
+func (tt *Task) skipReadError(testname string, files int, err error) bool {
+	log.Printf("ERROR filename:%s testname:%s files:%d, duration:%v err:%v",
+		tt.meta["filename"], testname, files,
+		time.Since(tt.meta["parse_time"].(time.Time)), err)
+	if err == storage.ErrOversizeFile {
+		metrics.TestTotal.WithLabelValues(
+			tt.Type(), "unknown", "oversize file").Inc()
+		return true
+	}
+	// We are seeing several of these per hour, a little more than
+	// one in one thousand files.  duration varies from 10 seconds
+	// up to several minutes.
+	// Example:
+	// filename:
+	// gs://m-lab-sandbox/ndt/2016/04/10/20160410T000000Z-mlab1-ord02-ndt-0002.tgz
+	// files:666 duration:1m47.571825351s
+	// err:stream error: stream ID 801; INTERNAL_ERROR
+	metrics.TestTotal.WithLabelValues(
+		tt.Type(), "unknown", "unrecovered").Inc()
+	// Since we don't understand these errors, safest thing to do is
+	// stop processing the tar file (and task).
+	return false
+}
+
+// prepare records the size of a test that was read, and reports whether the
+// parser accepts it.  If so, it returns the kind of test, and the function
+// that releases the memory reserved for parsing it.
+func (tt *Task) prepare(testname string, data []byte) (string, func(), bool) {
+	tt.profile(int64(len(data)))
+	if len(data) == 0 {
+		// Parser should also, likely, insert an empty row with just parse info and id
+		// There are spike of 100K, so we use a 1 second logEvery to avoid log spam.
+		emptyTest.Printf("WARNING empty test %s:%s %s\n", tt.TableName(), tt.Type(), tt.Detail())
+		metrics.WarningCount.WithLabelValues(
+			tt.TableName(), tt.Type(), "empty test file").Inc()
+	}
+	kind, parsable := tt.Parser.IsParsable(testname, data)
+	if !parsable {
+		metrics.FileSizeHistogram.WithLabelValues(
+			tt.Type(), kind, "ignored").Observe(float64(len(data)))
+		// Don't bother calling ParseAndInsert since this is unparsable.
+		return kind, nil, false
+	}
+	metrics.FileSizeHistogram.WithLabelValues(
+		tt.Type(), kind, "parsed").Observe(float64(len(data)))
+	release := tt.reserved
+	tt.reserved = nil
+	switch {
+	case release != nil:
+	case tt.memoryGate != nil:
+		// The source cannot Peek, so the test has already been read, and
+		// decompressed.  Acquire cannot fail with a background context.
+		release, _ = tt.memoryGate.Acquire(context.Background(),
+			EstimateMemory(etl.DataType(tt.Type()),
+				strings.TrimSuffix(testname, ".gz"), int64(len(data))))
+	default:
+		release = func() {}
+	}
+	return kind, release, true
+}
+
 // This is used for logging empty test warnings.
 // TODO - consider just removing the log.
 var emptyTest = logx.NewLogEvery(nil, time.Second)
@@ -89,6 +391,14 @@ func (tt *Task) ProcessAllTests(failfast bool) (int, error) {
 	}
 	metrics.WorkerState.WithLabelValues(tt.Type(), "task").Inc()
 	defer metrics.WorkerState.WithLabelValues(tt.Type(), "task").Dec()
+	tt.summary = Summary{}
+	atomic.StoreInt64(&tt.filesRead, 0)
+	if gp, ok := tt.Parser.(etl.GroupParser); ok {
+		if h, ok := tt.TestSource.(*HoldingArea); ok {
+			return tt.processAllGroups(gp, h, failfast)
+		}
+	}
+	tt.loadCheckpoint()
 	files := 0
 	nilData := 0
 	var testname string
@@ -97,40 +407,29 @@ func (tt *Task) ProcessAllTests(failfast bool) (int, error) {
 	// Read each file from the tar
 
 OUTER:
-	for testname, data, loopErr = tt.NextTest(tt.maxFileSize); loopErr != io.EOF; testname, data, loopErr = tt.NextTest(tt.maxFileSize) {
+	for testname, data, loopErr = tt.nextTest(); loopErr != io.EOF; testname, data, loopErr = tt.nextTest() {
 		files++
+		atomic.StoreInt64(&tt.filesRead, int64(files))
+		if err := tt.advanceCheckpoint(testname, files); err != nil {
+			loopErr = err
+			break OUTER
+		}
 		if loopErr != nil {
-			switch {
-			case loopErr == io.EOF:
-				break OUTER
-			case loopErr == storage.ErrOversizeFile:
-				log.Printf("ERROR filename:%s testname:%s files:%d, duration:%v err:%v",
-					tt.meta["filename"], testname, files,
-					time.Since(tt.meta["parse_time"].(time.Time)), loopErr)
-				metrics.TestTotal.WithLabelValues(
-					tt.Type(), "unknown", "oversize file").Inc()
+			if tt.skipReadError(testname, files, loopErr) {
 				continue OUTER
-			default:
-				// We are seeing several of these per hour, a little more than
-				// one in one thousand files.  duration varies from 10 seconds
-				// up to several minutes.
-				// Example:
-				// filename:
-				// gs://m-lab-sandbox/ndt/2016/04/10/20160410T000000Z-mlab1-ord02-ndt-0002.tgz
-				// files:666 duration:1m47.571825351s
-				// err:stream error: stream ID 801; INTERNAL_ERROR
-				// Because of the break, this error is passed up, and counted at
-				// the Task level.
-				log.Printf("ERROR filename:%s testname:%s files:%d, duration:%v err:%v",
-					tt.meta["filename"], testname, files,
-					time.Since(tt.meta["parse_time"].(time.Time)), loopErr)
-
-				metrics.TestTotal.WithLabelValues(
-					tt.Type(), "unknown", "unrecovered").Inc()
-				// Since we don't understand these errors, safest thing to do is
-				// stop processing the tar file (and task).
-				break OUTER
 			}
+			// Because of the break, this error is passed up, and counted at
+			// the Task level.
+			break OUTER
+		}
+		skip, err := tt.skipCommitted(testname, files)
+		if err != nil {
+			loopErr = err
+			break OUTER
+		}
+		if skip {
+			tt.summary.Resumed++
+			continue
 		}
 		if data == nil {
 			// TODO(dev) Handle directories (expected) and other
@@ -139,27 +438,34 @@ OUTER:
 			// If verbose, log the filename that is skipped.
 			continue
 		}
-		if len(data) == 0 {
-			// Parser should also, likely, insert an empty row with just parse info and id
-			// There are spike of 100K, so we use a 1 second logEvery to avoid log spam.
-			emptyTest.Printf("WARNING empty test %s:%s %s\n", tt.TableName(), tt.Type(), tt.Detail())
-			metrics.WarningCount.WithLabelValues(
-				tt.TableName(), tt.Type(), "empty test file").Inc()
-		}
-		kind, parsable := tt.Parser.IsParsable(testname, data)
+		kind, release, parsable := tt.prepare(testname, data)
 		if !parsable {
-			metrics.FileSizeHistogram.WithLabelValues(
-				tt.Type(), kind, "ignored").Observe(float64(len(data)))
-			// Don't bother calling ParseAndInsert since this is unparsable.
 			continue
-		} else {
-			metrics.FileSizeHistogram.WithLabelValues(
-				tt.Type(), kind, "parsed").Observe(float64(len(data)))
 		}
-		loopErr = tt.Parser.ParseAndInsert(tt.meta, testname, data)
+		tt.setTestMeta(testname)
+		accepted := tt.Parser.Accepted()
+		tt.summary.Parsed++
+		// The memory is released only when the parse actually ends, even if
+		// it is abandoned after a timeout.
+		loopErr = tt.parse(testname, func(ctx context.Context) error {
+			return tt.parseTest(ctx, tt.meta, testname, data)
+		}, release)
+		if errors.Is(loopErr, ErrTestTimeout) || errors.Is(loopErr, ErrParserAbandoned) {
+			tt.countTimeout(tt.meta, testname, kind, files, loopErr)
+		}
+		if errors.Is(loopErr, ErrParserAbandoned) {
+			// The abandoned parser may still be running, so it is not safe
+			// to continue or to flush.  Fail the whole task instead.
+			tt.summary.Files = files
+			return files, loopErr
+		}
+		tt.countRows(testname, accepted)
 		// Shouldn't have any of these, as they should be handled in ParseAndInsert.
 		if loopErr != nil {
 			log.Printf("ERROR %v", loopErr)
+			if tt.writeDeadLetter(tt.meta, testname, data, loopErr) {
+				tt.summary.DeadLettered++
+			}
 			// TODO(dev) Handle this error properly!
 			commitRowErr := row.ErrCommitRow{}
 			if failfast && errors.As(loopErr, &commitRowErr) {
@@ -169,6 +475,264 @@ OUTER:
 		}
 	}
 
+	tt.releaseReserved()
+	if loopErr == io.EOF {
+		if err := tt.resumedPast(files); err != nil {
+			loopErr = err
+		}
+	}
+	files, err := tt.finish(files, nilData, loopErr)
+	if err == nil {
+		tt.clearCheckpoint()
+	}
+	return files, err
+}
+
+// countTimeout logs and counts a test, with the given metadata, that timed out.
+func (tt *Task) countTimeout(meta map[string]bigquery.Value, testname, kind string, files int, err error) {
+	log.Printf("ERROR filename:%s testname:%s files:%d, err:%v",
+		meta["filename"], testname, files, err)
+	metrics.TestTotal.WithLabelValues(tt.Type(), kind, "timeout").Inc()
+	metrics.ErrorCount.WithLabelValues(tt.TableName(), tt.Type(), "test timeout").Inc()
+}
+
+// testMeta returns a copy of the task metadata, for a test parsed
+// concurrently with the reading of later tests.
+func (tt *Task) testMeta() map[string]bigquery.Value {
+	meta := make(map[string]bigquery.Value, len(tt.meta))
+	for k, v := range tt.meta {
+		meta[k] = v
+	}
+	return meta
+}
+
+// parseJob is a test to be parsed by a ProcessAllTestsParallel worker.
+type parseJob struct {
+	testname string
+	kind     string
+	files    int // Files read, including this test.
+	data     []byte
+	meta     map[string]bigquery.Value
+	release  func()
+}
+
+// ProcessAllTestsParallel is ProcessAllTests, except that up to n tests are
+// parsed concurrently, if the parser implements etl.ConcurrentParser.  Tests
+// are still read in order, by the calling goroutine.  The Summary counts the
+// rows Expected by each test, but since the rows emitted by concurrent tests
+// cannot be attributed to them, Emitted and Dropped are totals for the task,
+// and no tests are counted as Short or NoRows.  If n is less than 2, the
+// parser cannot parse concurrently, or the task has a CheckpointStore, it
+// simply calls ProcessAllTests.
+func (tt *Task) ProcessAllTestsParallel(failfast bool, n int) (int, error) {
+	if tt.Parser == nil {
+		panic("Parser is nil")
+	}
+	cp, ok := tt.Parser.(etl.ConcurrentParser)
+	if n < 2 || !ok || !cp.ParsesConcurrently() || tt.checkpoints != nil {
+		return tt.ProcessAllTests(failfast)
+	}
+	if _, ok := tt.Parser.(etl.GroupParser); ok {
+		if _, ok := tt.TestSource.(*HoldingArea); ok {
+			return tt.ProcessAllTests(failfast)
+		}
+	}
+	metrics.WorkerState.WithLabelValues(tt.Type(), "task").Inc()
+	defer metrics.WorkerState.WithLabelValues(tt.Type(), "task").Dec()
+	tt.summary = Summary{}
+	atomic.StoreInt64(&tt.filesRead, 0)
+	rc, counting := tt.Parser.(etl.RowCounter)
+	accepted := tt.Parser.Accepted()
+
+	var lock sync.Mutex
+	var abandonErr, commitErr error // Either stops the reading of tests.
+	stopped := func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return abandonErr != nil || commitErr != nil
+	}
+
+	jobs := make(chan parseJob)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				j := j
+				err := tt.parse(j.testname, func(ctx context.Context) error {
+					return tt.parseTest(ctx, j.meta, j.testname, j.data)
+				}, j.release)
+				if errors.Is(err, ErrTestTimeout) {
+					tt.countTimeout(j.meta, j.testname, j.kind, j.files, err)
+				}
+				if counting && !errors.Is(err, ErrParserAbandoned) {
+					if expected := rc.ExpectedRows(j.testname); expected >= 0 {
+						lock.Lock()
+						tt.summary.Counted++
+						tt.summary.Expected += expected
+						lock.Unlock()
+					}
+				}
+				commitRowErr := row.ErrCommitRow{}
+				switch {
+				case err == nil:
+				case errors.Is(err, ErrParserAbandoned):
+					// The parser is abandoned, so the task fails, once the
+					// tests already being parsed are done.
+					tt.countTimeout(j.meta, j.testname, j.kind, j.files, err)
+					lock.Lock()
+					if abandonErr == nil {
+						abandonErr = err
+					}
+					lock.Unlock()
+				default:
+					log.Printf("ERROR %v", err)
+					if tt.writeDeadLetter(j.meta, j.testname, j.data, err) {
+						lock.Lock()
+						tt.summary.DeadLettered++
+						lock.Unlock()
+					}
+					if failfast && errors.As(err, &commitRowErr) {
+						lock.Lock()
+						if commitErr == nil {
+							commitErr = err
+						}
+						lock.Unlock()
+					}
+				}
+			}
+		}()
+	}
+
+	files := 0
+	nilData := 0
+	var loopErr error
+	for !stopped() {
+		var testname string
+		var data []byte
+		testname, data, loopErr = tt.nextTest()
+		if loopErr == io.EOF {
+			break
+		}
+		files++
+		atomic.StoreInt64(&tt.filesRead, int64(files))
+		if loopErr != nil {
+			if tt.skipReadError(testname, files, loopErr) {
+				continue
+			}
+			break
+		}
+		if data == nil {
+			nilData++
+			continue
+		}
+		kind, release, parsable := tt.prepare(testname, data)
+		if !parsable {
+			continue
+		}
+		tt.setTestMeta(testname)
+		tt.summary.Parsed++
+		jobs <- parseJob{testname: testname, kind: kind, files: files,
+			data: data, meta: tt.testMeta(), release: release}
+	}
+	close(jobs)
+	wg.Wait()
+	tt.releaseReserved()
+
+	if abandonErr != nil {
+		tt.summary.Files = files
+		return files, abandonErr
+	}
+	if commitErr != nil {
+		loopErr = commitErr
+	}
+	if counting {
+		s := &tt.summary
+		s.Emitted = tt.Parser.Accepted() - accepted
+		if s.Emitted < s.Expected {
+			s.Dropped = s.Expected - s.Emitted
+			metrics.WarningCount.WithLabelValues(
+				tt.TableName(), tt.Type(), "fewer rows than expected").Inc()
+		}
+	}
+	return tt.finish(files, nilData, loopErr)
+}
+
+// processAllGroups is ProcessAllTests for parsers that implement
+// etl.GroupParser, with sources that group the companion files of each test.
+// Each test's files are passed to ParseGroup together.
+func (tt *Task) processAllGroups(gp etl.GroupParser, h *HoldingArea, failfast bool) (int, error) {
+	files := 0
+	nilData := 0
+	var loopErr error
+	for {
+		var key string
+		var group map[string][]byte
+		key, group, loopErr = h.NextGroup(tt.maxFileSize)
+		if loopErr == io.EOF {
+			break
+		}
+		if loopErr != nil {
+			files++
+			if tt.skipReadError(key, files, loopErr) {
+				continue
+			}
+			break
+		}
+		size := int64(0)
+		for name, data := range group {
+			files++
+			if data == nil {
+				nilData++
+				delete(group, name)
+				continue
+			}
+			size += int64(len(data))
+		}
+		atomic.StoreInt64(&tt.filesRead, int64(files))
+		if len(group) == 0 {
+			continue
+		}
+		tt.profile(size)
+		release := func() {}
+		if tt.memoryGate != nil {
+			// The files are already decompressed.  Acquire cannot fail with
+			// a background context.
+			release, _ = tt.memoryGate.Acquire(context.Background(),
+				EstimateMemory(etl.DataType(tt.Type()), key, size))
+		}
+		tt.setTestMeta(key)
+		accepted := tt.Parser.Accepted()
+		tt.summary.Parsed++
+		loopErr = tt.parse(key, func(context.Context) error {
+			return gp.ParseGroup(tt.meta, group)
+		}, release)
+		if errors.Is(loopErr, ErrParserAbandoned) {
+			tt.countTimeout(tt.meta, key, "group", files, loopErr)
+			tt.summary.Files = files
+			return files, loopErr
+		}
+		tt.countRows(key, accepted)
+		if loopErr != nil {
+			log.Printf("ERROR %v", loopErr)
+			for name, data := range group {
+				if tt.writeDeadLetter(tt.meta, name, data, loopErr) {
+					tt.summary.DeadLettered++
+				}
+			}
+			commitRowErr := row.ErrCommitRow{}
+			if failfast && errors.As(loopErr, &commitRowErr) {
+				break
+			}
+		}
+	}
+	return tt.finish(files, nilData, loopErr)
+}
+
+// finish flushes the parser, logs the task, and returns the number of files
+// processed and the error, if any, for the task.
+func (tt *Task) finish(files, nilData int, loopErr error) (int, error) {
 	// There may be an error from the processing loop, but we wait to handle that
 	// error until after we flush and cached rows.
 	flushErr := tt.Flush()
@@ -176,9 +740,15 @@ OUTER:
 		log.Printf("%v", flushErr)
 	}
 
+	tt.summary.Files = files
+	tt.summary.NilData = nilData
+	tt.summary.Empty = files == nilData && errors.Is(loopErr, io.EOF)
+	tt.reconcile()
+
 	// TODO - make this debug or remove
-	log.Printf("Processed %d files, %d nil data, %d rows committed, %d failed, from %s into %s",
+	log.Printf("Processed %d files, %d nil data, %d rows committed, %d failed, %d of %d expected rows emitted, from %s into %s",
 		files, nilData, tt.Parser.Committed(), tt.Parser.Failed(),
+		tt.summary.Emitted, tt.summary.Expected,
 		tt.meta["filename"], tt.Parser.FullTableName())
 
 	// We expect the loopErr to be io.EOF.  If it is something else, then
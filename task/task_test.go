@@ -5,9 +5,13 @@ package task_test
 import (
 	"archive/tar"
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"cloud.google.com/go/bigquery"
@@ -188,3 +192,407 @@ func TestTarFileInput(t *testing.T) {
 	}
 
 }
+
+// slowParser blocks while parsing the named test.
+type slowParser struct {
+	TestParser
+	slow      string
+	release   chan struct{}
+	abandoned int32
+}
+
+func (sp *slowParser) Abandon() {
+	atomic.StoreInt32(&sp.abandoned, 1)
+}
+
+func (sp *slowParser) ParseAndInsert(meta map[string]bigquery.Value, testName string, test []byte) error {
+	if testName == sp.slow {
+		<-sp.release
+	}
+	return sp.TestParser.ParseAndInsert(meta, testName, test)
+}
+
+func TestTestTimeout(t *testing.T) {
+	sp := &slowParser{slow: "foo", release: make(chan struct{})}
+	gate := task.NewMemoryGate(1)
+
+	tt := task.NewTask("filename", MakeTestSource(t), sp, &NullCloser{})
+	tt.SetMaxFileSize(100)
+	tt.SetTestTimeout(10 * time.Millisecond)
+	tt.SetMemoryGate(gate)
+	fc, err := tt.ProcessAllTests(false)
+	if !errors.Is(err, task.ErrParserAbandoned) {
+		t.Fatal("Expected ErrParserAbandoned, but got", err)
+	}
+	// The parser cannot be stopped, so the task stops at the first file.
+	if fc != 1 {
+		t.Error("Expected 1 file: ", fc)
+	}
+	if atomic.LoadInt32(&sp.abandoned) == 0 {
+		t.Error("Timed out parser was not abandoned")
+	}
+
+	// The gate is held until the abandoned parse returns.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := gate.Acquire(ctx, 1); err == nil {
+		t.Error("Gate released while the abandoned parse is running")
+	}
+	close(sp.release)
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	release, err := gate.Acquire(ctx, 1)
+	if err != nil {
+		t.Fatal("Gate not released after the abandoned parse returned:", err)
+	}
+	release()
+
+	// With a generous timeout, all files are processed.
+	fp := &slowParser{slow: "none"}
+	tt = task.NewTask("filename", MakeTestSource(t), fp, &NullCloser{})
+	tt.SetMaxFileSize(100)
+	tt.SetTestTimeout(time.Minute)
+	if _, err := tt.ProcessAllTests(false); err != nil {
+		t.Error("Expected nil error, but got ", err)
+	}
+	if !reflect.DeepEqual(fp.files, []string{"foo", "bar"}) {
+		t.Error("Not expected files: ", fp.files)
+	}
+
+	// A parser that can be neither stopped nor abandoned is not timed out.
+	np := &sleepyParser{slow: "foo", delay: 50 * time.Millisecond}
+	tt = task.NewTask("filename", MakeTestSource(t), np, &NullCloser{})
+	tt.SetMaxFileSize(100)
+	tt.SetTestTimeout(10 * time.Millisecond)
+	if _, err := tt.ProcessAllTests(false); err != nil {
+		t.Error("Expected nil error, but got ", err)
+	}
+	if !reflect.DeepEqual(np.files, []string{"foo", "bar"}) {
+		t.Error("Not expected files: ", np.files)
+	}
+}
+
+// sleepyParser sleeps while parsing the named test, and cannot be stopped.
+type sleepyParser struct {
+	TestParser
+	slow  string
+	delay time.Duration
+}
+
+func (sp *sleepyParser) ParseAndInsert(meta map[string]bigquery.Value, testName string, test []byte) error {
+	if testName == sp.slow {
+		time.Sleep(sp.delay)
+	}
+	return sp.TestParser.ParseAndInsert(meta, testName, test)
+}
+
+// ctxParser blocks while parsing the named test, until its context is done.
+type ctxParser struct {
+	TestParser
+	slow string
+}
+
+func (cp *ctxParser) ParseAndInsertContext(ctx context.Context, meta map[string]bigquery.Value, testName string, test []byte) error {
+	if testName == cp.slow {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return cp.TestParser.ParseAndInsert(meta, testName, test)
+}
+
+func TestTestTimeout_Stopped(t *testing.T) {
+	cp := &ctxParser{slow: "foo"}
+	gate := task.NewMemoryGate(1)
+	d := &fakeDeadLetter{letters: map[string]string{}}
+
+	tt := task.NewTask("gs://archive/a.tgz", MakeTestSource(t), cp, &NullCloser{})
+	tt.SetMaxFileSize(100)
+	tt.SetTestTimeout(10 * time.Millisecond)
+	tt.SetMemoryGate(gate)
+	tt.SetDeadLetter(d)
+	// The timed out test is stopped and dead lettered, and the task
+	// continues with the next test.
+	fc, err := tt.ProcessAllTests(false)
+	if err != nil {
+		t.Fatal("Expected nil error, but got", err)
+	}
+	if fc != 3 || !reflect.DeepEqual(cp.files, []string{"bar"}) {
+		t.Errorf("ProcessAllTests() = %d files, parsed %v", fc, cp.files)
+	}
+	if _, ok := d.letters["gs://archive/a.tgz/foo"]; !ok || tt.Summary().DeadLettered != 1 {
+		t.Errorf("Dead letters = %v, want foo", d.letters)
+	}
+	// The stopped parse released its memory.
+	release, err := gate.Acquire(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
+
+// concurrentParser waits, in each ParseAndInsert, until the tests are all
+// being parsed at once.
+type concurrentParser struct {
+	TestParser
+	lock    sync.Mutex
+	all     sync.WaitGroup
+	metas   map[string]interface{}
+	timeout time.Duration
+}
+
+func (cp *concurrentParser) ParsesConcurrently() bool {
+	return true
+}
+
+func (cp *concurrentParser) Abandon() {}
+
+// ExpectedRows reports that "foo" should produce a row, which it never emits.
+func (cp *concurrentParser) ExpectedRows(testName string) int {
+	if testName == "foo" {
+		return 1
+	}
+	return -1
+}
+
+func (cp *concurrentParser) ParseAndInsert(meta map[string]bigquery.Value, testName string, test []byte) error {
+	cp.lock.Lock()
+	cp.files = append(cp.files, testName)
+	cp.metas[testName] = meta["date"]
+	cp.lock.Unlock()
+	cp.all.Done()
+	done := make(chan struct{})
+	go func() {
+		cp.all.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(cp.timeout):
+		return errors.New("tests were not parsed concurrently")
+	}
+}
+
+func TestProcessAllTestsParallel(t *testing.T) {
+	cp := &concurrentParser{metas: map[string]interface{}{}, timeout: 10 * time.Second}
+	cp.all.Add(2)
+	tt := task.NewTask("filename", MakeTestSource(t), cp, &NullCloser{})
+	tt.SetMaxFileSize(100)
+	fc, err := tt.ProcessAllTestsParallel(false, 4)
+	if err != nil {
+		t.Error("Expected nil error, but got ", err)
+	}
+	if fc != 3 {
+		t.Error("Expected 3 files: ", fc)
+	}
+	sort.Strings(cp.files)
+	if !reflect.DeepEqual(cp.files, []string{"bar", "foo"}) {
+		t.Error("Not expected files: ", cp.files)
+	}
+	if len(cp.metas) != 2 {
+		t.Error("Expected metadata for each test: ", cp.metas)
+	}
+	want := task.Summary{Files: 3, Parsed: 2, Counted: 1, Expected: 1, Dropped: 1}
+	if got := tt.Summary(); got != want {
+		t.Errorf("Summary() = %+v, want %+v", got, want)
+	}
+
+	// A parser that does not parse concurrently is called serially, so it
+	// never sees both tests at once.
+	sp := &slowParser{slow: "none"}
+	tt = task.NewTask("filename", MakeTestSource(t), sp, &NullCloser{})
+	tt.SetMaxFileSize(100)
+	if _, err := tt.ProcessAllTestsParallel(false, 4); err != nil {
+		t.Error("Expected nil error, but got ", err)
+	}
+	if !reflect.DeepEqual(sp.files, []string{"foo", "bar"}) {
+		t.Error("Not expected files: ", sp.files)
+	}
+}
+
+func TestProcessAllTestsParallel_Timeout(t *testing.T) {
+	// Only one of the two tests is parsed at a time, so both block.
+	cp := &concurrentParser{metas: map[string]interface{}{}, timeout: time.Second}
+	cp.all.Add(3)
+	tt := task.NewTask("filename", MakeTestSource(t), cp, &NullCloser{})
+	tt.SetMaxFileSize(100)
+	tt.SetTestTimeout(10 * time.Millisecond)
+	_, err := tt.ProcessAllTestsParallel(false, 2)
+	if !errors.Is(err, task.ErrParserAbandoned) {
+		t.Fatal("Expected ErrParserAbandoned, but got", err)
+	}
+}
+
+// countingParser reports expected row counts, but emits rows only for "foo".
+type countingParser struct {
+	TestParser
+	accepted int
+	expected int
+}
+
+func (cp *countingParser) ParseAndInsert(meta map[string]bigquery.Value, testName string, test []byte) error {
+	switch testName {
+	case "foo":
+		cp.expected = 2
+		cp.accepted++
+	case "bar":
+		cp.expected = 0
+	}
+	return cp.TestParser.ParseAndInsert(meta, testName, test)
+}
+
+func (cp *countingParser) Accepted() int {
+	return cp.accepted
+}
+
+func (cp *countingParser) ExpectedRows(testName string) int {
+	return cp.expected
+}
+
+func TestSummary(t *testing.T) {
+	cp := &countingParser{}
+	tt := task.NewTask("filename", MakeTestSource(t), cp, &NullCloser{})
+	tt.SetMaxFileSize(100)
+	if _, err := tt.ProcessAllTests(false); err != nil {
+		t.Fatal("Expected nil error, but got ", err)
+	}
+	want := task.Summary{
+		Files: 3, Parsed: 2, Counted: 2,
+		Expected: 2, Emitted: 1, NoRows: 1, Short: 1, Dropped: 1,
+	}
+	if got := tt.Summary(); got != want {
+		t.Errorf("Summary() = %+v, want %+v", got, want)
+	}
+}
+
+// fixedCounter is a row.Counter reporting a fixed count.
+type fixedCounter int
+
+func (fc fixedCounter) Committed() int {
+	return int(fc)
+}
+
+func TestReconciliation(t *testing.T) {
+	cp := &countingParser{}
+	tt := task.NewTask("filename", MakeTestSource(t), cp, &NullCloser{})
+	tt.SetMaxFileSize(100)
+	tt.SetSinkCounter(fixedCounter(1))
+	if _, err := tt.ProcessAllTests(false); err != nil {
+		t.Fatal("Expected nil error, but got ", err)
+	}
+	// The parser accepts a row that it never commits or fails, the sink
+	// reports a row that the parser did not count as committed, and one
+	// expected row is missing.
+	want := task.Reconciliation{Accepted: 1, SinkCommitted: 1, Expected: 2, Emitted: 1}
+	r := tt.Reconciliation()
+	if r != want {
+		t.Errorf("Reconciliation() = %+v, want %+v", r, want)
+	}
+	if got := r.Mismatches(); !reflect.DeepEqual(got, []string{"unaccounted", "sink", "expected"}) {
+		t.Errorf("Mismatches() = %v", got)
+	}
+
+	ok := task.Reconciliation{Accepted: 3, Committed: 2, Failed: 1, SinkCommitted: -1, Expected: 4, Emitted: 4}
+	if got := ok.Mismatches(); got != nil {
+		t.Errorf("Mismatches() = %v, want nil", got)
+	}
+}
+
+// gateCheckingSource records, for each call to NextTest, whether the memory
+// gate was already held when the test was read.
+type gateCheckingSource struct {
+	etl.TestSource
+	gate  *task.MemoryGate
+	limit int64
+	held  []bool
+}
+
+func (gs *gateCheckingSource) Peek() (string, int64, bool) {
+	return gs.TestSource.(etl.Peeker).Peek()
+}
+
+func (gs *gateCheckingSource) NextTest(maxSize int64) (string, []byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	release, err := gs.gate.Acquire(ctx, gs.limit)
+	gs.held = append(gs.held, err != nil)
+	if err == nil {
+		release()
+	}
+	return gs.TestSource.NextTest(maxSize)
+}
+
+func TestMemoryGateBeforeRead(t *testing.T) {
+	gate := task.NewMemoryGate(1000000)
+	src := &gateCheckingSource{TestSource: MakeTestSource(t), gate: gate, limit: 1000000}
+	tt := task.NewTask("filename", src, &TestParser{}, &NullCloser{})
+	tt.SetMaxFileSize(100)
+	tt.SetMemoryGate(gate)
+	if _, err := tt.ProcessAllTests(false); err != nil {
+		t.Fatal("Expected nil error, but got ", err)
+	}
+	// Memory is reserved before foo and bar are read, but not for the
+	// oversize file, or at the end of the archive.
+	want := []bool{true, false, true, false}
+	if !reflect.DeepEqual(src.held, want) {
+		t.Errorf("gate held = %v, want %v", src.held, want)
+	}
+}
+
+// groupParser records the files of each group passed to ParseGroup.
+type groupParser struct {
+	TestParser
+	groups [][]string
+}
+
+func (gp *groupParser) ParseGroup(meta map[string]bigquery.Value, files map[string][]byte) error {
+	names := []string{}
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	gp.groups = append(gp.groups, names)
+	return nil
+}
+
+func TestProcessAllGroups(t *testing.T) {
+	src := task.NewHoldingArea(&fakeSource{names: []string{
+		"20170509T13:45:13.5Z_host:1.c2s_snaplog.gz",
+		"20170509T14:00:00.0Z_host:3.c2s_snaplog.gz",
+		"big.s2c_snaplog",
+		"20170509T13:45:13.5Z_host:2.s2c_snaplog.gz",
+		"20170509T13:45:13.5Z_host.meta",
+		"20170509T14:00:00.0Z_host.meta",
+	}}, etl.NDT)
+	gp := &groupParser{}
+	tt := task.NewTask("filename", src, gp, &NullCloser{})
+	tt.SetMaxFileSize(100)
+	files, err := tt.ProcessAllTests(false)
+	if err != nil {
+		t.Fatal("Expected nil error, but got ", err)
+	}
+	if files != 6 {
+		t.Errorf("ProcessAllTests() = %d files, want 6", files)
+	}
+	want := [][]string{
+		{
+			"20170509T13:45:13.5Z_host.meta",
+			"20170509T13:45:13.5Z_host:1.c2s_snaplog.gz",
+			"20170509T13:45:13.5Z_host:2.s2c_snaplog.gz",
+		},
+		// The s2c snaplog is oversize, so this group is incomplete.
+		{
+			"20170509T14:00:00.0Z_host.meta",
+			"20170509T14:00:00.0Z_host:3.c2s_snaplog.gz",
+		},
+	}
+	if !reflect.DeepEqual(gp.groups, want) {
+		t.Errorf("ParseGroup() groups = %v, want %v", gp.groups, want)
+	}
+	if len(gp.files) != 0 {
+		t.Errorf("ParseAndInsert() called for %v", gp.files)
+	}
+	if got := tt.Summary().Parsed; got != 2 {
+		t.Errorf("Summary().Parsed = %d, want 2", got)
+	}
+}
@@ -522,6 +522,77 @@ func (sl *SnapLog) ValidateSnapshots() error {
 	return nil
 }
 
+// ErrCorruptSnaplog is returned by Validate when the structure of a snaplog
+// is damaged, as opposed to merely truncated.
+var ErrCorruptSnaplog = errors.New("corrupt snaplog")
+
+// snaplogMagic is the end of the version string, followed by the blank line
+// and section name that start the header of every snaplog.
+const snaplogMagic = "\n\n/spec\n"
+
+// Validate checks the structure of a raw snaplog before it is fully parsed:
+// the leading version string and /spec section, the sanity of the header, and
+// that snapshot times never decrease.  A truncated final snapshot is not
+// considered corrupt, as it is reported by ValidateSnapshots.  On success,
+// the parsed SnapLog is returned.  All failures wrap ErrCorruptSnaplog.
+func Validate(raw []byte) (*SnapLog, error) {
+	eol := bytes.IndexByte(raw, '\n')
+	if eol <= 0 || !bytes.HasPrefix(raw[eol:], []byte(snaplogMagic)) {
+		return nil, fmt.Errorf("%w: missing version or /spec header", ErrCorruptSnaplog)
+	}
+	sl, err := NewSnapLog(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptSnaplog, err)
+	}
+	if err := sl.validateHeader(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptSnaplog, err)
+	}
+	if err := sl.validateTimes(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptSnaplog, err)
+	}
+	return sl, nil
+}
+
+// validateHeader checks that the parsed header describes usable snapshots.
+func (sl *SnapLog) validateHeader() error {
+	if sl.LogTime == 0 {
+		return errors.New("zero logTime")
+	}
+	if len(sl.read.Fields) == 0 || sl.read.Length <= len(BEGIN_SNAP_DATA) {
+		return errors.New("empty read group")
+	}
+	if sl.read.find("Duration") == nil {
+		return errors.New("read group has no Duration")
+	}
+	if sl.SnapCount() == 0 {
+		return errors.New("no snapshots")
+	}
+	if _, err := sl.Snapshot(0); err != nil {
+		return err
+	}
+	return nil
+}
+
+// validateTimes checks that the Duration of successive snapshots never
+// decreases.  It stops at the first snapshot without a BeginSnapData marker.
+func (sl *SnapLog) validateTimes() error {
+	indices := make([]int, 0, sl.SnapCount())
+	for i := 0; i < sl.SnapCount(); i++ {
+		offset := sl.bodyOffset + i*sl.read.Length
+		if string(sl.raw[offset:offset+len(BEGIN_SNAP_DATA)]) != BEGIN_SNAP_DATA {
+			break
+		}
+		indices = append(indices, i)
+	}
+	durations := sl.SliceIntField("Duration", indices)
+	for i := 1; i < len(durations); i++ {
+		if durations[i] < durations[i-1] {
+			return fmt.Errorf("snapshot %d time %d precedes %d", indices[i], durations[i], durations[i-1])
+		}
+	}
+	return nil
+}
+
 //=================================================================================
 
 // Snapshot represents a complete snapshot from a snapshot log.
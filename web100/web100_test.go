@@ -1,7 +1,9 @@
 package web100_test
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -46,6 +48,50 @@ func TestHeaderParsing(t *testing.T) {
 	}
 }
 
+func TestValidate(t *testing.T) {
+	c2sName := `20170509T13:45:13.590210000Z_eb.measurementlab.net:48716.c2s_snaplog`
+	c2sData, err := ioutil.ReadFile(`testdata/web100/` + c2sName)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	slog, err := web100.NewSnapLog(c2sData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := bytes.Index(c2sData, []byte(web100.BEGIN_SNAP_DATA))
+	size := slog.SnapshotNumBytes()
+	last := first + (slog.SnapCount()-1)*size
+
+	// Replace the first snapshot with the last, so that time goes backwards.
+	reordered := append([]byte{}, c2sData...)
+	copy(reordered[first:first+size], c2sData[last:last+size])
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr bool
+	}{
+		{"valid", c2sData, false},
+		{"truncated-snapshot", c2sData[:len(c2sData)-10], false},
+		{"no-magic", append([]byte("garbage\n"), c2sData...), true},
+		{"empty", []byte{}, true},
+		{"truncated-header", c2sData[:first/2], true},
+		{"no-snapshots", c2sData[:first], true},
+		{"non-monotonic", reordered, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := web100.Validate(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, web100.ErrCorruptSnaplog) {
+				t.Errorf("Validate() error = %v, want ErrCorruptSnaplog", err)
+			}
+		})
+	}
+}
+
 type SimpleSaver struct {
 	Integers map[string]int64
 	Strings  map[string]string
@@ -0,0 +1,62 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Budget shares a fixed number of concurrent BigQuery jobs between the
+// processes that compete for the slots and quotas of a project, such as
+// parsers and the daily summary and dedup jobs.  Each job holds one of the
+// tokens, a lease on one of the keys "budget/<name>/<i>", while it runs.
+//
+// A process may only take the first limit tokens.  Giving reprocessing a
+// lower limit than the daily jobs reserves the remaining tokens for them, so
+// that reprocessing cannot starve the daily jobs.
+type Budget struct {
+	store  LeaseStore
+	name   string
+	holder string
+	limit  int
+	ttl    time.Duration
+	next   int64 // Sequence number of the next acquisition, for its holder.
+}
+
+// NewBudget creates a Budget of tokens with the name, which all processes
+// sharing the budget must use, from which this process may take the first
+// limit.  The holder must be unique to this process.  Tokens expire after ttl
+// unless renewed, so a token outlives its ttl only if its process dies.
+func NewBudget(store LeaseStore, name, holder string, limit int, ttl time.Duration) *Budget {
+	return &Budget{store: store, name: name, holder: holder, limit: limit, ttl: ttl}
+}
+
+// Acquire waits until one of the tokens available to this process is free,
+// polling every third of the ttl, and takes it until the returned release
+// function is called.  It returns an error only if ctx is done first.  As for
+// Leases, a token is assumed free if the store fails, so that an outage of
+// the store does not stop processing.  A nil Budget always succeeds at once.
+func (b *Budget) Acquire(ctx context.Context) (release func(), err error) {
+	if b == nil {
+		return func() {}, nil
+	}
+	// Each acquisition has its own holder, so that concurrent jobs of the
+	// process take different tokens.
+	holder := fmt.Sprintf("%s/%d", b.holder, atomic.AddInt64(&b.next, 1))
+	l := NewLeases(b.store, holder, b.ttl)
+	for {
+		for i := 0; i < b.limit; i++ {
+			release, err := l.Acquire(ctx, fmt.Sprintf("budget/%s/%d", b.name, i))
+			if !errors.Is(err, ErrLeased) {
+				return release, err
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(b.ttl / 3):
+		}
+	}
+}
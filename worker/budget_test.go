@@ -0,0 +1,60 @@
+package worker_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/m-lab/etl/worker"
+)
+
+func TestBudget(t *testing.T) {
+	store := worker.NewMemoryLeaseStore()
+	// Reprocessing may take one of the two tokens, and the daily jobs both.
+	reprocess := worker.NewBudget(store, "bigquery", "reprocess", 1, 30*time.Millisecond)
+	daily := worker.NewBudget(store, "bigquery", "daily", 2, 30*time.Millisecond)
+	ctx := context.Background()
+
+	release, err := reprocess.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	short, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := reprocess.Acquire(short); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Acquire() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	releaseDaily, err := daily.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("Acquire() of the reserved token error = %v", err)
+	}
+
+	// A waiting job takes the token once it is released.
+	done := make(chan error)
+	go func() {
+		r, err := reprocess.Acquire(ctx)
+		if err == nil {
+			r()
+		}
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	release()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Acquire() after release error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Acquire() did not take the released token")
+	}
+	releaseDaily()
+
+	var nilBudget *worker.Budget
+	release, err = nilBudget.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
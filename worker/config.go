@@ -0,0 +1,92 @@
+package worker
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/googleapis/google-cloud-go-testing/storage/stiface"
+
+	"github.com/m-lab/etl/etl"
+	"github.com/m-lab/etl/metrics"
+)
+
+// ConfigLoader reads the content of a config file.
+type ConfigLoader func(ctx context.Context) ([]byte, error)
+
+// NewConfigLoader returns a ConfigLoader for the location, which is either a
+// gs://bucket/object URL, read with the client, or a local file path.
+func NewConfigLoader(client stiface.Client, location string) ConfigLoader {
+	if !strings.HasPrefix(location, "gs://") {
+		return func(ctx context.Context) ([]byte, error) {
+			return ioutil.ReadFile(location)
+		}
+	}
+	bucket, object, _ := strings.Cut(strings.TrimPrefix(location, "gs://"), "/")
+	return func(ctx context.Context) ([]byte, error) {
+		r, err := client.Bucket(bucket).Object(object).NewReader(ctx)
+		if err != nil {
+			return nil, etl.ErrGCS.Errorf("reading %s: %w", location, err)
+		}
+		defer r.Close()
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, etl.ErrGCS.Errorf("reading %s: %w", location, err)
+		}
+		return data, nil
+	}
+}
+
+// ConfigWatcher reloads the etl.Config when signaled, and periodically.
+type ConfigWatcher struct {
+	load ConfigLoader
+}
+
+// NewConfigWatcher creates a ConfigWatcher that reads the config with load.
+func NewConfigWatcher(load ConfigLoader) *ConfigWatcher {
+	return &ConfigWatcher{load: load}
+}
+
+// Reload reads and parses the config, and makes it the current etl.Config.
+// If the config cannot be read or is invalid, the current config is kept.
+func (w *ConfigWatcher) Reload(ctx context.Context) error {
+	data, err := w.load(ctx)
+	if err != nil {
+		metrics.ConfigReloadCount.WithLabelValues("read error").Inc()
+		return err
+	}
+	c, err := etl.ParseConfig(data)
+	if err != nil {
+		metrics.ConfigReloadCount.WithLabelValues("invalid").Inc()
+		return err
+	}
+	etl.SetConfig(c)
+	metrics.ConfigReloadCount.WithLabelValues("ok").Inc()
+	return nil
+}
+
+// Watch reloads the config whenever a signal is received on sig, and every
+// period, if period is positive, until the context is canceled.  Failed
+// reloads are logged and retried at the next signal or period.
+func (w *ConfigWatcher) Watch(ctx context.Context, sig <-chan os.Signal, period time.Duration) {
+	var tick <-chan time.Time
+	if period > 0 {
+		t := time.NewTicker(period)
+		defer t.Stop()
+		tick = t.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sig:
+		case <-tick:
+		}
+		if err := w.Reload(ctx); err != nil {
+			log.Println("Config reload failed:", err)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package worker_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/m-lab/etl/etl"
+	"github.com/m-lab/etl/worker"
+)
+
+func TestConfigWatcher(t *testing.T) {
+	defer etl.SetConfig(nil)
+	path := filepath.Join(t.TempDir(), "config.json")
+	write := func(s string) {
+		if err := ioutil.WriteFile(path, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w := worker.NewConfigWatcher(worker.NewConfigLoader(nil, path))
+	if err := w.Reload(ctx); err == nil {
+		t.Error("Reload() of missing file should fail")
+	}
+
+	write(`{"tables": {"ndt7": "ndt7_v2"}}`)
+	if err := w.Reload(ctx); err != nil {
+		t.Fatal("Reload()", err)
+	}
+	if got := etl.NDT7.Table(); got != "ndt7_v2" {
+		t.Errorf("Table() = %q, want ndt7_v2", got)
+	}
+
+	// An invalid config leaves the current config in place.
+	write(`{"tables": {"foobar": "x"}}`)
+	if err := w.Reload(ctx); err == nil {
+		t.Error("Reload() of invalid config should fail")
+	}
+	if got := etl.NDT7.Table(); got != "ndt7_v2" {
+		t.Errorf("Table() = %q, want ndt7_v2", got)
+	}
+
+	// A signal causes a reload.
+	write(`{"tables": {"ndt7": "ndt7_v3"}}`)
+	sig := make(chan os.Signal)
+	go w.Watch(ctx, sig, 0)
+	sig <- os.Interrupt
+	for start := time.Now(); etl.NDT7.Table() != "ndt7_v3"; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("Watch() did not reload the config")
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package worker
+
+import "time"
+
+// SetNow replaces the clock used by the MemoryStore.
+func (m *MemoryStore) SetNow(now func() time.Time) {
+	m.now = now
+}
+
+// SetInUse replaces the memory reading used by the PressureMonitor.
+func (m *PressureMonitor) SetInUse(inUse func() uint64) {
+	m.inUse = inUse
+}
+
+// SetGCCycles replaces the GC cycle count used by the PressureMonitor.
+func (m *PressureMonitor) SetGCCycles(gcCycles func() uint64) {
+	m.gcCycles = gcCycles
+}
+
+// SetNow replaces the clock used by the MemoryLeaseStore.
+func (m *MemoryLeaseStore) SetNow(now func() time.Time) {
+	m.now = now
+}
@@ -0,0 +1,230 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	gcs "cloud.google.com/go/storage"
+
+	"github.com/m-lab/etl/etl"
+	"github.com/m-lab/etl/task"
+)
+
+// ArchiveHash identifies an archive object, and its content and GCS generation.
+type ArchiveHash struct {
+	Bucket     string
+	Name       string
+	CRC32C     uint32
+	MD5        []byte
+	Generation int64
+}
+
+// HashOf returns the ArchiveHash of a GCS object.
+func HashOf(attrs *gcs.ObjectAttrs) ArchiveHash {
+	return ArchiveHash{
+		Bucket:     attrs.Bucket,
+		Name:       attrs.Name,
+		CRC32C:     attrs.CRC32C,
+		MD5:        attrs.MD5,
+		Generation: attrs.Generation,
+	}
+}
+
+// String returns a summary suitable for logs.
+func (h ArchiveHash) String() string {
+	return fmt.Sprintf("crc32c:%08x md5:%s generation:%d",
+		h.CRC32C, hex.EncodeToString(h.MD5), h.Generation)
+}
+
+// URL returns the gs:// URL of the archive.  The output location depends on
+// the object name, so identical content at another URL must still be
+// processed.
+func (h ArchiveHash) URL() string {
+	return fmt.Sprintf("gs://%s/%s", h.Bucket, h.Name)
+}
+
+// hasMD5 returns whether the hash identifies the content well enough to
+// skip reprocessing.  Composite objects have only a CRC32C, which is too weak
+// to rely on.
+func (h ArchiveHash) hasMD5() bool {
+	return len(h.MD5) > 0
+}
+
+// ArchiveSummary is the task summary of the most recent successful
+// processing of an archive, with the hash of the content processed.
+type ArchiveSummary struct {
+	ArchiveURL string
+	CRC32C     int64 // Datastore has no unsigned integers.
+	MD5        []byte
+	Generation int64
+	Version    string // Parser version.
+	Completed  time.Time
+	Summary    task.Summary `datastore:",flatten"`
+}
+
+// SummaryStore holds the ArchiveSummary of each archive, keyed by its URL.
+type SummaryStore interface {
+	// Get returns the summary of the archive, or nil if it has none.
+	Get(ctx context.Context, url string) (*ArchiveSummary, error)
+	// Put records the summary of the archive, replacing any previous one.
+	Put(ctx context.Context, url string, s ArchiveSummary) error
+}
+
+// MemorySummaryStore implements SummaryStore in memory, for testing or a
+// single worker.
+type MemorySummaryStore struct {
+	lock      sync.Mutex
+	summaries map[string]ArchiveSummary
+}
+
+// NewMemorySummaryStore creates an empty MemorySummaryStore.
+func NewMemorySummaryStore() *MemorySummaryStore {
+	return &MemorySummaryStore{summaries: map[string]ArchiveSummary{}}
+}
+
+// Get implements SummaryStore.
+func (m *MemorySummaryStore) Get(ctx context.Context, url string) (*ArchiveSummary, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	s, ok := m.summaries[url]
+	if !ok {
+		return nil, nil
+	}
+	return &s, nil
+}
+
+// Put implements SummaryStore.
+func (m *MemorySummaryStore) Put(ctx context.Context, url string, s ArchiveSummary) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.summaries[url] = s
+	return nil
+}
+
+// summaryKind is the Datastore kind used by DatastoreSummaryStore.
+const summaryKind = "ArchiveSummary"
+
+// DatastoreSummaryStore implements SummaryStore in Datastore, so that the
+// summaries are shared by all workers, and survive restarts.
+type DatastoreSummaryStore struct {
+	client    *datastore.Client
+	namespace string
+}
+
+// NewDatastoreSummaryStore creates a DatastoreSummaryStore using entities in
+// the namespace.
+func NewDatastoreSummaryStore(client *datastore.Client, namespace string) *DatastoreSummaryStore {
+	return &DatastoreSummaryStore{client: client, namespace: namespace}
+}
+
+func (d *DatastoreSummaryStore) key(url string) *datastore.Key {
+	k := datastore.NameKey(summaryKind, url, nil)
+	k.Namespace = d.namespace
+	return k
+}
+
+// Get implements SummaryStore.
+func (d *DatastoreSummaryStore) Get(ctx context.Context, url string) (*ArchiveSummary, error) {
+	var s ArchiveSummary
+	err := d.client.Get(ctx, d.key(url), &s)
+	if errors.Is(err, datastore.ErrNoSuchEntity) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Put implements SummaryStore.
+func (d *DatastoreSummaryStore) Put(ctx context.Context, url string, s ArchiveSummary) error {
+	_, err := d.client.Put(ctx, d.key(url), &s)
+	return err
+}
+
+// ProcessedArchives records the task summary and content hash of each
+// archive processed successfully, so that re-enqueued archives whose content
+// was already processed by the same parser version can be skipped cheaply.
+type ProcessedArchives struct {
+	store SummaryStore
+}
+
+// NewProcessedArchives creates a ProcessedArchives that keeps the summaries
+// in the store.
+func NewProcessedArchives(store SummaryStore) *ProcessedArchives {
+	return &ProcessedArchives{store: store}
+}
+
+// Contains returns true if the archive's summary shows the same content was
+// already processed successfully by the given parser version.  Store errors
+// are logged, and treated as not processed, since reprocessing is safe.  A
+// nil ProcessedArchives reports false.
+func (p *ProcessedArchives) Contains(ctx context.Context, h ArchiveHash, version string) bool {
+	if p == nil || !h.hasMD5() {
+		// Without an MD5 hash, archives can't be compared reliably.
+		return false
+	}
+	s, err := p.store.Get(ctx, h.URL())
+	if err != nil {
+		log.Println("processed archives:", err)
+		return false
+	}
+	return s != nil && s.Version == version &&
+		s.CRC32C == int64(h.CRC32C) && bytes.Equal(s.MD5, h.MD5)
+}
+
+// Add records the summary of a successful task for the archive, processed
+// by the given parser version.  Store errors are logged.  A nil
+// ProcessedArchives records nothing.
+func (p *ProcessedArchives) Add(ctx context.Context, h ArchiveHash, version string, s task.Summary) {
+	if p == nil {
+		return
+	}
+	err := p.store.Put(ctx, h.URL(), ArchiveSummary{
+		ArchiveURL: h.URL(),
+		CRC32C:     int64(h.CRC32C),
+		MD5:        h.MD5,
+		Generation: h.Generation,
+		Version:    version,
+		Completed:  time.Now(),
+		Summary:    s,
+	})
+	if err != nil {
+		log.Println("processed archives:", err)
+	}
+}
+
+// SummaryFactory wraps a task.Factory, and keeps the task it creates, so that
+// the task's Summary can be recorded once it completes.
+type SummaryFactory struct {
+	task.Factory
+
+	lock sync.Mutex
+	task *task.Task
+}
+
+// Get implements task.Factory.
+func (sf *SummaryFactory) Get(ctx context.Context, dp etl.DataPath) (*task.Task, etl.ProcessingError) {
+	t, err := sf.Factory.Get(ctx, dp)
+	sf.lock.Lock()
+	sf.task = t
+	sf.lock.Unlock()
+	return t, err
+}
+
+// Summary returns the Summary of the task, and false if no task was created.
+func (sf *SummaryFactory) Summary() (task.Summary, bool) {
+	sf.lock.Lock()
+	defer sf.lock.Unlock()
+	if sf.task == nil {
+		return task.Summary{}, false
+	}
+	return sf.task.Summary(), true
+}
@@ -0,0 +1,115 @@
+package worker_test
+
+import (
+	"context"
+	"testing"
+
+	gcs "cloud.google.com/go/storage"
+
+	"github.com/m-lab/etl/etl"
+	"github.com/m-lab/etl/metrics"
+	"github.com/m-lab/etl/task"
+	"github.com/m-lab/etl/worker"
+)
+
+func TestHashOf(t *testing.T) {
+	h := worker.HashOf(&gcs.ObjectAttrs{CRC32C: 0xabc, MD5: []byte{1, 2, 255}, Generation: 7})
+	want := "crc32c:00000abc md5:0102ff generation:7"
+	if h.String() != want {
+		t.Errorf("String() = %q, want %q", h.String(), want)
+	}
+}
+
+func TestProcessedArchives(t *testing.T) {
+	ctx := context.Background()
+	store := worker.NewMemorySummaryStore()
+	p := worker.NewProcessedArchives(store)
+	a := worker.ArchiveHash{Bucket: "b", Name: "a.tgz", CRC32C: 1, MD5: []byte{1}, Generation: 1}
+	aNewGen := worker.ArchiveHash{Bucket: "b", Name: "a.tgz", CRC32C: 1, MD5: []byte{1}, Generation: 2}
+	aChanged := worker.ArchiveHash{Bucket: "b", Name: "a.tgz", CRC32C: 2, MD5: []byte{2}, Generation: 3}
+
+	if p.Contains(ctx, a, "v1") {
+		t.Error("Contains() true before Add")
+	}
+	p.Add(ctx, a, "v1", task.Summary{Files: 3, Parsed: 2})
+	if !p.Contains(ctx, aNewGen, "v1") {
+		t.Error("Contains() false for same content with new generation")
+	}
+	if p.Contains(ctx, a, "v2") {
+		t.Error("Contains() true for different parser version")
+	}
+	if p.Contains(ctx, aChanged, "v1") {
+		t.Error("Contains() true for changed content")
+	}
+
+	// The summary and hash are recorded in the store, so that other workers
+	// can look them up.
+	s, err := store.Get(ctx, "gs://b/a.tgz")
+	if err != nil || s == nil {
+		t.Fatalf("Get() = %v, %v, want summary", s, err)
+	}
+	if s.Summary.Files != 3 || s.Summary.Parsed != 2 || s.CRC32C != 1 || s.Generation != 1 || s.Version != "v1" {
+		t.Errorf("Get() = %+v, want the summary and hash of a", s)
+	}
+	if !worker.NewProcessedArchives(store).Contains(ctx, aNewGen, "v1") {
+		t.Error("Contains() false for a new ProcessedArchives with the same store")
+	}
+
+	// Reprocessing changed content replaces the summary.
+	p.Add(ctx, aChanged, "v1", task.Summary{})
+	if p.Contains(ctx, a, "v1") || !p.Contains(ctx, aChanged, "v1") {
+		t.Error("Add() did not replace the summary")
+	}
+
+	// Archives without hashes are never matched.
+	p.Add(ctx, worker.ArchiveHash{}, "v1", task.Summary{})
+	if p.Contains(ctx, worker.ArchiveHash{}, "v1") {
+		t.Error("Contains() true for archive without hash")
+	}
+
+	// Composite objects, with only a CRC32C, are never matched.
+	composite := worker.ArchiveHash{Bucket: "b", Name: "c.tgz", CRC32C: 4}
+	p.Add(ctx, composite, "v1", task.Summary{})
+	if p.Contains(ctx, composite, "v1") {
+		t.Error("Contains() true for archive without MD5")
+	}
+
+	// Identical content at another path is not matched.
+	p.Add(ctx, worker.ArchiveHash{Bucket: "b", Name: "x.tgz", CRC32C: 5, MD5: []byte{5}}, "v1", task.Summary{})
+	if p.Contains(ctx, worker.ArchiveHash{Bucket: "b", Name: "y.tgz", CRC32C: 5, MD5: []byte{5}}, "v1") {
+		t.Error("Contains() true for same content at another path")
+	}
+
+	// A nil ProcessedArchives contains nothing.
+	var none *worker.ProcessedArchives
+	none.Add(ctx, a, "v1", task.Summary{})
+	if none.Contains(ctx, a, "v1") {
+		t.Error("Contains() true for nil ProcessedArchives")
+	}
+}
+
+func TestSummaryFactory(t *testing.T) {
+	_, sinks := NewSinkFactory("test-bucket")
+	tf := &worker.SummaryFactory{Factory: &worker.StandardTaskFactory{
+		Sink:   sinks,
+		Source: NewSourceFactory("test-bucket"),
+	}}
+	if _, ok := tf.Summary(); ok {
+		t.Error("Summary() ok before a task was created")
+	}
+	path, err := etl.ValidateTestPath("gs://test-bucket/ndt/ndt5/2019/12/01/20191201T020011.395772Z-ndt5-mlab1-bcn01-ndt.tgz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := worker.ProcessGKETask(context.Background(), path, tf); err != nil {
+		t.Fatal(err)
+	}
+	s, ok := tf.Summary()
+	if !ok || s.Files != 488 {
+		t.Errorf("Summary() = %+v, %t, want 488 files", s, ok)
+	}
+	metrics.FileCount.Reset()
+	metrics.TaskTotal.Reset()
+	metrics.TestTotal.Reset()
+	metrics.TaskRowCount.Reset()
+}
@@ -0,0 +1,236 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/m-lab/etl/etl"
+	"github.com/m-lab/etl/factory"
+	"github.com/m-lab/etl/metrics"
+	"github.com/m-lab/etl/task"
+)
+
+// ErrDuplicateTask is returned when an archive is already being processed.
+var ErrDuplicateTask = errors.New("archive is already being processed")
+
+// ErrAlreadyProcessed is returned, when serializing, if the earlier delivery
+// of the archive completed successfully while waiting.
+var ErrAlreadyProcessed = errors.New("archive was processed by an earlier delivery")
+
+// flight is an archive being processed.
+type flight struct {
+	done chan struct{} // closed when the task completes.
+	err  error         // task outcome, valid once done is closed.
+
+	start    time.Time
+	dataType string     // Set by ProcessGKETask.
+	task     *task.Task // Set by ProcessGKETask, once the task is created.
+}
+
+// TaskInfo describes a task in flight.
+type TaskInfo struct {
+	ArchiveURL string    `json:"archive_url"`
+	DataType   string    `json:"datatype"`
+	StartTime  time.Time `json:"start_time"`
+	Files      int       `json:"files"` // Files read from the archive so far.
+	Rows       int       `json:"rows"`  // Rows accepted by the parser so far.
+}
+
+// Tracker registers the archives in flight on all workers, so that duplicate
+// deliveries to different workers can be detected.  Leases implements
+// Tracker.
+type Tracker interface {
+	// Acquire registers the archive as in flight, and returns an error if
+	// another worker is processing it.  On success, the caller must call the
+	// returned release function when processing is complete.
+	Acquire(ctx context.Context, uri string) (release func(), err error)
+}
+
+// InFlight tracks the archives currently being processed by this worker, so
+// that a duplicate delivery of the same archive can be detected.  A duplicate
+// is either rejected, or serialized behind the earlier delivery.  If a
+// Tracker is set, it is also checked before processing, so that deliveries
+// of an archive in flight on another worker are rejected.
+type InFlight struct {
+	serialize bool
+	tracker   Tracker
+
+	lock   sync.Mutex
+	active map[string]*flight
+}
+
+// NewInFlight creates an InFlight registry.  If serialize is true, duplicate
+// deliveries wait for the earlier delivery to complete, and are processed
+// again only if it failed.  Otherwise they are rejected with ErrDuplicateTask.
+func NewInFlight(serialize bool) *InFlight {
+	return &InFlight{
+		serialize: serialize,
+		active:    make(map[string]*flight, 10),
+	}
+}
+
+// SetTracker sets the Tracker checked by ProcessGKETask.  Since the outcome
+// of a delivery on another worker is unknown, duplicates held by another
+// worker are always rejected, even when serializing, and a retry after the
+// other worker completes is processed again.  It must be called before
+// ProcessGKETask.
+func (f *InFlight) SetTracker(t Tracker) {
+	f.tracker = t
+}
+
+// Acquire registers the uri as in flight.  On success, the caller must call
+// the returned release function with the outcome when processing is complete.
+// When serializing, Acquire waits for an earlier delivery, and returns
+// ErrAlreadyProcessed if it succeeded.
+func (f *InFlight) Acquire(ctx context.Context, uri string) (release func(error), err error) {
+	fl, err := f.acquire(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	return func(err error) { f.release(uri, fl, err) }, nil
+}
+
+func (f *InFlight) acquire(ctx context.Context, uri string) (*flight, error) {
+	for {
+		f.lock.Lock()
+		fl, ok := f.active[uri]
+		if !ok {
+			fl = &flight{done: make(chan struct{}), start: time.Now()}
+			f.active[uri] = fl
+			f.lock.Unlock()
+			return fl, nil
+		}
+		f.lock.Unlock()
+
+		if !f.serialize {
+			return nil, ErrDuplicateTask
+		}
+		select {
+		case <-fl.done:
+			if fl.err == nil {
+				return nil, ErrAlreadyProcessed
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (f *InFlight) release(uri string, fl *flight, err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.active[uri] == fl {
+		delete(f.active, uri)
+		fl.err = err
+		close(fl.done)
+	}
+}
+
+// Active returns the sorted URIs of the archives currently in flight.
+func (f *InFlight) Active() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	uris := make([]string, 0, len(f.active))
+	for uri := range f.active {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+	return uris
+}
+
+// Tasks returns the tasks currently in flight, oldest first.
+func (f *InFlight) Tasks() []TaskInfo {
+	f.lock.Lock()
+	infos := make([]TaskInfo, 0, len(f.active))
+	tasks := make([]*task.Task, 0, len(f.active))
+	for uri, fl := range f.active {
+		infos = append(infos, TaskInfo{ArchiveURL: uri, DataType: fl.dataType, StartTime: fl.start})
+		tasks = append(tasks, fl.task)
+	}
+	f.lock.Unlock()
+
+	// The progress is read without the lock, since the parser stats have
+	// their own lock.
+	for i, t := range tasks {
+		if t != nil {
+			infos[i].Files = t.FilesRead()
+			infos[i].Rows = t.Parser.Accepted()
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if !infos[i].StartTime.Equal(infos[j].StartTime) {
+			return infos[i].StartTime.Before(infos[j].StartTime)
+		}
+		return infos[i].ArchiveURL < infos[j].ArchiveURL
+	})
+	return infos
+}
+
+// ServeTasks writes the tasks in flight as a JSON list, so that the gardener
+// can detect stuck tasks, and avoid dispatching archives already in flight.
+func (f *InFlight) ServeTasks(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(f.Tasks()); err != nil {
+		log.Println(err, "writing tasks in flight")
+	}
+}
+
+// trackingFactory records the Task it creates in the flight, so that the
+// progress of the task can be reported.
+type trackingFactory struct {
+	task.Factory
+	f  *InFlight
+	fl *flight
+}
+
+// Get implements task.Factory.
+func (tf *trackingFactory) Get(ctx context.Context, dp etl.DataPath) (*task.Task, etl.ProcessingError) {
+	t, err := tf.Factory.Get(ctx, dp)
+	if t != nil {
+		tf.f.lock.Lock()
+		tf.fl.task = t
+		tf.f.lock.Unlock()
+	}
+	return t, err
+}
+
+// ProcessGKETask is like the package level ProcessGKETask, but first checks
+// whether the same archive is already in flight.  A serialized duplicate
+// returns success without reprocessing if the earlier delivery succeeded.
+func (f *InFlight) ProcessGKETask(ctx context.Context, path etl.DataPath, tf task.Factory) etl.ProcessingError {
+	fl, err := f.acquire(ctx, path.URI)
+	if err == ErrAlreadyProcessed {
+		metrics.TaskTotal.WithLabelValues(path.DataType, "AlreadyProcessed").Inc()
+		return nil
+	}
+	if err != nil {
+		metrics.TaskTotal.WithLabelValues(path.DataType, "DuplicateTask").Inc()
+		return factory.NewError(path.DataType, "DuplicateTask", http.StatusConflict, err)
+	}
+	if f.tracker != nil {
+		releaseTracker, err := f.tracker.Acquire(ctx, path.URI)
+		if err != nil {
+			// A serialized duplicate waiting on this delivery tries again.
+			f.release(path.URI, fl, err)
+			metrics.TaskTotal.WithLabelValues(path.DataType, "Leased").Inc()
+			return factory.NewError(path.DataType, "Leased", http.StatusConflict, err)
+		}
+		defer releaseTracker()
+	}
+	f.lock.Lock()
+	fl.dataType = path.DataType
+	f.lock.Unlock()
+	pErr := ProcessGKETask(ctx, path, &trackingFactory{Factory: tf, f: f, fl: fl})
+	if pErr != nil {
+		f.release(path.URI, fl, pErr)
+		return pErr
+	}
+	f.release(path.URI, fl, nil)
+	return nil
+}
@@ -0,0 +1,182 @@
+package worker_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+
+	"github.com/m-lab/etl/etl"
+	"github.com/m-lab/etl/factory"
+	"github.com/m-lab/etl/task"
+	"github.com/m-lab/etl/worker"
+)
+
+func TestInFlight_Reject(t *testing.T) {
+	f := worker.NewInFlight(false)
+	ctx := context.Background()
+
+	release, err := f.Acquire(ctx, "gs://foo/a.tgz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Acquire(ctx, "gs://foo/a.tgz"); err != worker.ErrDuplicateTask {
+		t.Errorf("Acquire() = %v, want %v", err, worker.ErrDuplicateTask)
+	}
+	other, err := f.Acquire(ctx, "gs://foo/b.tgz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(f.Active(), []string{"gs://foo/a.tgz", "gs://foo/b.tgz"}); diff != nil {
+		t.Error(diff)
+	}
+
+	release(nil)
+	other(nil)
+	if len(f.Active()) != 0 {
+		t.Error("Active() not empty after release:", f.Active())
+	}
+	release, err = f.Acquire(ctx, "gs://foo/a.tgz")
+	if err != nil {
+		t.Fatal("Acquire() after release:", err)
+	}
+	release(nil)
+}
+
+func TestInFlight_Serialize(t *testing.T) {
+	f := worker.NewInFlight(true)
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		outcome error
+		wantErr error
+	}{
+		{"first-succeeded", nil, worker.ErrAlreadyProcessed},
+		{"first-failed", errors.New("failed"), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release, err := f.Acquire(ctx, "gs://foo/a.tgz")
+			if err != nil {
+				t.Fatal(err)
+			}
+			acquired := make(chan error, 1)
+			go func() {
+				r, err := f.Acquire(ctx, "gs://foo/a.tgz")
+				if err == nil {
+					r(nil)
+				}
+				acquired <- err
+			}()
+
+			select {
+			case <-acquired:
+				t.Fatal("duplicate acquired before release")
+			case <-time.After(50 * time.Millisecond):
+			}
+			release(tt.outcome)
+			select {
+			case err := <-acquired:
+				if err != tt.wantErr {
+					t.Errorf("Acquire() = %v, want %v", err, tt.wantErr)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("duplicate not acquired after release")
+			}
+		})
+	}
+
+	// A cancelled context abandons the wait.
+	release, err := f.Acquire(ctx, "gs://foo/a.tgz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release(nil)
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := f.Acquire(cctx, "gs://foo/a.tgz"); err != context.Canceled {
+		t.Errorf("Acquire() = %v, want %v", err, context.Canceled)
+	}
+}
+
+// failingFactory counts its calls, and fails to create tasks.
+type failingFactory struct {
+	calls int
+}
+
+func (ff *failingFactory) Get(ctx context.Context, dp etl.DataPath) (*task.Task, etl.ProcessingError) {
+	ff.calls++
+	return nil, factory.NewError(dp.DataType, "Test", http.StatusInternalServerError, errors.New("no task"))
+}
+
+func TestInFlight_Tracker(t *testing.T) {
+	ctx := context.Background()
+	path, err := etl.ValidateTestPath("gs://test-bucket/ndt/ndt5/2019/12/01/20191201T020011.395772Z-ndt5-mlab1-bcn01-ndt.tgz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := worker.NewMemoryLeaseStore()
+	f := worker.NewInFlight(true)
+	f.SetTracker(worker.NewLeases(store, "this", time.Minute))
+
+	// An archive in flight on another worker is rejected, even when
+	// serializing, without creating a task.
+	release, err := worker.NewLeases(store, "other", time.Minute).Acquire(ctx, path.URI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tf := &failingFactory{}
+	pErr := f.ProcessGKETask(ctx, path, tf)
+	if pErr == nil || pErr.Code() != http.StatusConflict || !errors.Is(pErr, worker.ErrLeased) {
+		t.Errorf("ProcessGKETask() = %v, want %d with %v", pErr, http.StatusConflict, worker.ErrLeased)
+	}
+	if tf.calls != 0 || len(f.Active()) != 0 {
+		t.Errorf("ProcessGKETask() created %d tasks, left %v active", tf.calls, f.Active())
+	}
+
+	// Once the other worker is done, the archive is processed, and the
+	// tracker is released afterwards.
+	release()
+	if pErr := f.ProcessGKETask(ctx, path, tf); pErr == nil || pErr.Code() == http.StatusConflict || tf.calls != 1 {
+		t.Errorf("ProcessGKETask() = %v after %d calls, want the factory error", pErr, tf.calls)
+	}
+	if ok, err := store.Acquire(ctx, path.URI, "other", time.Minute); !ok || err != nil {
+		t.Errorf("Acquire() = %t, %v after ProcessGKETask, want the lease released", ok, err)
+	}
+}
+
+func TestInFlight_Tasks(t *testing.T) {
+	f := worker.NewInFlight(false)
+	ctx := context.Background()
+	release, err := f.Acquire(ctx, "gs://foo/a.tgz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	f.ServeTasks(rec, httptest.NewRequest("GET", "/v2/tasks", nil))
+	got := []worker.TaskInfo{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].ArchiveURL != "gs://foo/a.tgz" {
+		t.Fatalf("ServeTasks() = %+v, want gs://foo/a.tgz", got)
+	}
+	if d := time.Since(got[0].StartTime); d < 0 || d > time.Minute {
+		t.Errorf("StartTime = %v, want about now", got[0].StartTime)
+	}
+
+	release(nil)
+	rec = httptest.NewRecorder()
+	f.ServeTasks(rec, httptest.NewRequest("GET", "/v2/tasks", nil))
+	if body := strings.TrimSpace(rec.Body.String()); body != "[]" {
+		t.Errorf("ServeTasks() after release = %s, want []", body)
+	}
+}
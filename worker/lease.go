@@ -0,0 +1,205 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// ErrLeased is returned when another worker holds the lease on an archive.
+var ErrLeased = errors.New("archive is leased by another worker")
+
+// LeaseStore holds leases on keys.  Implementations backed by a shared store
+// allow a worker to recognize an archive still being processed by another.
+type LeaseStore interface {
+	// Acquire takes, or renews, the lease on the key for the holder until the
+	// ttl expires, unless another holder has an unexpired lease.  It reports
+	// whether the holder has the lease.
+	Acquire(ctx context.Context, key, holder string, ttl time.Duration) (bool, error)
+	// Release gives up the lease on the key, if the holder has it.
+	Release(ctx context.Context, key, holder string) error
+}
+
+// lease is the holder and expiry of a lease.  It is also the Datastore entity
+// used by DatastoreLeaseStore.  Expired entities are ignored, and should be
+// deleted by a TTL policy on the Expires property.
+type lease struct {
+	Holder  string
+	Expires time.Time
+}
+
+// MemoryLeaseStore implements LeaseStore in memory, for a single worker.
+type MemoryLeaseStore struct {
+	now func() time.Time // for testing.
+
+	lock   sync.Mutex
+	leases map[string]lease
+}
+
+// NewMemoryLeaseStore creates an empty MemoryLeaseStore.
+func NewMemoryLeaseStore() *MemoryLeaseStore {
+	return &MemoryLeaseStore{now: time.Now, leases: map[string]lease{}}
+}
+
+// Acquire implements LeaseStore.
+func (m *MemoryLeaseStore) Acquire(ctx context.Context, key, holder string, ttl time.Duration) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	now := m.now()
+	if l, ok := m.leases[key]; ok && l.Holder != holder && l.Expires.After(now) {
+		return false, nil
+	}
+	m.leases[key] = lease{Holder: holder, Expires: now.Add(ttl)}
+	return true, nil
+}
+
+// Release implements LeaseStore.
+func (m *MemoryLeaseStore) Release(ctx context.Context, key, holder string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.leases[key].Holder == holder {
+		delete(m.leases, key)
+	}
+	return nil
+}
+
+// leaseKind is the Datastore kind used by DatastoreLeaseStore.
+const leaseKind = "ArchiveLease"
+
+// DatastoreLeaseStore implements LeaseStore in Datastore, shared by all
+// workers.
+type DatastoreLeaseStore struct {
+	client    *datastore.Client
+	namespace string
+}
+
+// NewDatastoreLeaseStore creates a DatastoreLeaseStore using entities in the
+// namespace.
+func NewDatastoreLeaseStore(client *datastore.Client, namespace string) *DatastoreLeaseStore {
+	return &DatastoreLeaseStore{client: client, namespace: namespace}
+}
+
+func (d *DatastoreLeaseStore) key(key string) *datastore.Key {
+	k := datastore.NameKey(leaseKind, key, nil)
+	k.Namespace = d.namespace
+	return k
+}
+
+// Acquire implements LeaseStore.  The lease is read and written in a
+// transaction, so that only one of several workers racing for it succeeds.
+func (d *DatastoreLeaseStore) Acquire(ctx context.Context, key, holder string, ttl time.Duration) (bool, error) {
+	k := d.key(key)
+	var acquired bool
+	_, err := d.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		acquired = false
+		var l lease
+		err := tx.Get(k, &l)
+		if err != nil && !errors.Is(err, datastore.ErrNoSuchEntity) {
+			return err
+		}
+		if err == nil && l.Holder != holder && l.Expires.After(time.Now()) {
+			return nil
+		}
+		if _, err := tx.Put(k, &lease{Holder: holder, Expires: time.Now().Add(ttl)}); err != nil {
+			return err
+		}
+		acquired = true
+		return nil
+	})
+	return acquired, err
+}
+
+// Release implements LeaseStore.
+func (d *DatastoreLeaseStore) Release(ctx context.Context, key, holder string) error {
+	k := d.key(key)
+	_, err := d.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		var l lease
+		err := tx.Get(k, &l)
+		if errors.Is(err, datastore.ErrNoSuchEntity) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if l.Holder != holder {
+			return nil
+		}
+		return tx.Delete(k)
+	})
+	return err
+}
+
+// Leases prevents several workers from processing the same archive
+// concurrently, e.g. when a task queue retries a delivery that is still being
+// processed by another worker.  Each archive is leased while it is processed,
+// and the lease is renewed until processing completes, so a lease outlives
+// its ttl only if the worker holding it dies.
+type Leases struct {
+	store  LeaseStore
+	holder string
+	ttl    time.Duration
+}
+
+// NewLeases creates Leases for the holder, which must be unique to this
+// worker, with leases that expire after ttl unless renewed.
+func NewLeases(store LeaseStore, holder string, ttl time.Duration) *Leases {
+	return &Leases{store: store, holder: holder, ttl: ttl}
+}
+
+// releaseTimeout limits the time to release a lease, which is done even if
+// the processing context is canceled.
+const releaseTimeout = 10 * time.Second
+
+// Acquire leases the archive, and renews the lease every third of the ttl
+// until the returned release function is called.  It returns ErrLeased if
+// another worker holds the lease.  Store errors are logged, and the archive is
+// processed without a lease, since processing an archive twice is safe.  A nil
+// Leases always succeeds.
+func (l *Leases) Acquire(ctx context.Context, uri string) (release func(), err error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	ok, err := l.store.Acquire(ctx, uri, l.holder, l.ttl)
+	if err != nil {
+		log.Println("lease:", err)
+		return func() {}, nil
+	}
+	if !ok {
+		return nil, ErrLeased
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(l.ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				ok, err := l.store.Acquire(ctx, uri, l.holder, l.ttl)
+				if err != nil {
+					log.Println("lease renewal:", err)
+				} else if !ok {
+					log.Println("lease lost:", uri)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+		rctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+		defer cancel()
+		if err := l.store.Release(rctx, uri, l.holder); err != nil {
+			log.Println("lease release:", err)
+		}
+	}, nil
+}
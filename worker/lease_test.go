@@ -0,0 +1,113 @@
+package worker_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/m-lab/etl/worker"
+)
+
+func TestMemoryLeaseStore(t *testing.T) {
+	now := time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC)
+	store := worker.NewMemoryLeaseStore()
+	store.SetNow(func() time.Time { return now })
+	ctx := context.Background()
+	uri := "gs://bucket/ndt/ndt7/2022/07/01/20220701T000000.000000Z-ndt7-mlab1-foo01-ndt.tgz"
+
+	if ok, _ := store.Acquire(ctx, uri, "a", time.Minute); !ok {
+		t.Fatal("Acquire() = false for an unleased key")
+	}
+	if ok, _ := store.Acquire(ctx, uri, "b", time.Minute); ok {
+		t.Error("Acquire() = true for a key leased by another holder")
+	}
+	if ok, _ := store.Acquire(ctx, uri, "a", time.Minute); !ok {
+		t.Error("Acquire() = false to renew a lease")
+	}
+	// Releasing another holder's lease does nothing.
+	store.Release(ctx, uri, "b")
+	if ok, _ := store.Acquire(ctx, uri, "b", time.Minute); ok {
+		t.Error("Acquire() = true after another holder's Release")
+	}
+	now = now.Add(time.Minute)
+	if ok, _ := store.Acquire(ctx, uri, "b", time.Minute); !ok {
+		t.Error("Acquire() = false for an expired lease")
+	}
+	store.Release(ctx, uri, "b")
+	if ok, _ := store.Acquire(ctx, uri, "a", time.Minute); !ok {
+		t.Error("Acquire() = false after Release")
+	}
+}
+
+// countingLeaseStore counts the Acquire calls of each holder.
+type countingLeaseStore struct {
+	*worker.MemoryLeaseStore
+	lock     sync.Mutex
+	acquires map[string]int
+}
+
+func (c *countingLeaseStore) Acquire(ctx context.Context, key, holder string, ttl time.Duration) (bool, error) {
+	c.lock.Lock()
+	c.acquires[holder]++
+	c.lock.Unlock()
+	return c.MemoryLeaseStore.Acquire(ctx, key, holder, ttl)
+}
+
+func TestLeases(t *testing.T) {
+	store := &countingLeaseStore{MemoryLeaseStore: worker.NewMemoryLeaseStore(), acquires: map[string]int{}}
+	a := worker.NewLeases(store, "a", 30*time.Millisecond)
+	b := worker.NewLeases(store, "b", 30*time.Millisecond)
+	ctx := context.Background()
+	uri := "gs://bucket/archive.tgz"
+
+	release, err := a.Acquire(ctx, uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The lease is renewed while held, so it does not expire.
+	time.Sleep(100 * time.Millisecond)
+	if _, err := b.Acquire(ctx, uri); !errors.Is(err, worker.ErrLeased) {
+		t.Errorf("Acquire() error = %v, want %v", err, worker.ErrLeased)
+	}
+	release()
+	store.lock.Lock()
+	renewals := store.acquires["a"] - 1
+	store.lock.Unlock()
+	if renewals < 2 {
+		t.Errorf("lease renewed %d times, want at least 2", renewals)
+	}
+
+	release, err = b.Acquire(ctx, uri)
+	if err != nil {
+		t.Fatalf("Acquire() after release error = %v", err)
+	}
+	release()
+
+	var nilLeases *worker.Leases
+	release, err = nilLeases.Acquire(ctx, uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
+
+type failingLeaseStore struct{}
+
+func (failingLeaseStore) Acquire(ctx context.Context, key, holder string, ttl time.Duration) (bool, error) {
+	return false, errors.New("unavailable")
+}
+
+func (failingLeaseStore) Release(ctx context.Context, key, holder string) error {
+	return errors.New("unavailable")
+}
+
+func TestLeases_StoreError(t *testing.T) {
+	l := worker.NewLeases(failingLeaseStore{}, "a", time.Minute)
+	release, err := l.Acquire(context.Background(), "gs://bucket/archive.tgz")
+	if err != nil {
+		t.Errorf("Acquire() error = %v, want nil when the store fails", err)
+	}
+	release()
+}
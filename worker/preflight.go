@@ -0,0 +1,58 @@
+package worker
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	gcs "cloud.google.com/go/storage"
+
+	"github.com/m-lab/etl/etl"
+	"github.com/m-lab/etl/factory"
+	"github.com/m-lab/etl/metrics"
+)
+
+// archiveContentTypes are the object content types expected for archives.
+// An empty content type is also accepted, since older archives were uploaded
+// without one.
+var archiveContentTypes = map[string]bool{
+	"":                         true,
+	"application/gzip":         true,
+	"application/x-gzip":       true,
+	"application/x-gtar":       true,
+	"application/x-tar":        true,
+	"application/x-compressed": true,
+	"application/octet-stream": true,
+}
+
+// Preflight checks the archive's path and object metadata before any content
+// is streamed.  Archives from directories not allowed by the Config, larger
+// than the DataType's MaxArchiveSize, or with an unexpected content type, are
+// rejected with a permanent (4xx) error.
+func Preflight(dp etl.DataPath, attrs *gcs.ObjectAttrs) etl.ProcessingError {
+	if !dp.Allowed() {
+		metrics.TaskTotal.WithLabelValues(dp.DataType, "NotAllowed").Inc()
+		err := fmt.Errorf("archive directory %q is not allowed", dp.Dir())
+		log.Println(dp.URI, err)
+		return factory.NewError(dp.DataType, "NotAllowed", http.StatusForbidden, err)
+	}
+	dt := dp.GetDataType()
+	if limit := dt.MaxArchiveSize(); attrs.Size > limit {
+		metrics.TaskTotal.WithLabelValues(dp.DataType, "OversizeArchive").Inc()
+		err := fmt.Errorf("archive size %d exceeds %d byte limit", attrs.Size, limit)
+		log.Println(dp.URI, err)
+		return factory.NewError(dp.DataType, "OversizeArchive",
+			http.StatusRequestEntityTooLarge, err)
+	}
+	// Ignore parameters, e.g. "application/gzip; charset=binary".
+	ct := strings.TrimSpace(strings.Split(attrs.ContentType, ";")[0])
+	if !archiveContentTypes[strings.ToLower(ct)] {
+		metrics.TaskTotal.WithLabelValues(dp.DataType, "BadContentType").Inc()
+		err := fmt.Errorf("unexpected archive content type %q", attrs.ContentType)
+		log.Println(dp.URI, err)
+		return factory.NewError(dp.DataType, "BadContentType",
+			http.StatusUnsupportedMediaType, err)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package worker_test
+
+import (
+	"net/http"
+	"testing"
+
+	gcs "cloud.google.com/go/storage"
+
+	"github.com/m-lab/etl/etl"
+	"github.com/m-lab/etl/metrics"
+	"github.com/m-lab/etl/worker"
+)
+
+func TestPreflight(t *testing.T) {
+	defer metrics.TaskTotal.Reset()
+	dp, err := etl.ValidateTestPath(
+		"gs://archive-mlab-testing/ndt/ndt5/2019/12/01/20191201T020011.395772Z-ndt5-mlab1-bcn01-ndt.tgz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		attrs    gcs.ObjectAttrs
+		wantCode int
+	}{
+		{
+			name:  "ok",
+			attrs: gcs.ObjectAttrs{Size: 1000, ContentType: "application/x-gzip"},
+		},
+		{
+			name:  "ok-no-content-type",
+			attrs: gcs.ObjectAttrs{Size: 1000},
+		},
+		{
+			name:  "ok-content-type-params",
+			attrs: gcs.ObjectAttrs{Size: 1000, ContentType: "application/gzip; charset=binary"},
+		},
+		{
+			name:     "oversize",
+			attrs:    gcs.ObjectAttrs{Size: etl.DefaultMaxArchiveSize + 1},
+			wantCode: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:     "bad-content-type",
+			attrs:    gcs.ObjectAttrs{Size: 1000, ContentType: "text/html"},
+			wantCode: http.StatusUnsupportedMediaType,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := worker.Preflight(dp, &tt.attrs)
+			if tt.wantCode == 0 {
+				if err != nil {
+					t.Errorf("Preflight() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || err.Code() != tt.wantCode {
+				t.Errorf("Preflight() = %v, want code %d", err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestPreflight_AllowedDirs(t *testing.T) {
+	defer metrics.TaskTotal.Reset()
+	defer etl.SetConfig(nil)
+	c, err := etl.ParseConfig([]byte(`{"allowed_dirs": ["ndt/ndt7", "ndt/tcpinfo"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	etl.SetConfig(c)
+	attrs := &gcs.ObjectAttrs{Size: 1000}
+
+	dp, err := etl.ValidateTestPath(
+		"gs://archive-mlab-testing/ndt/tcpinfo/2019/05/25/20190525T020001.697396Z-tcpinfo-mlab4-ord01-ndt-0001.tgz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := worker.Preflight(dp, attrs); err != nil {
+		t.Errorf("Preflight() = %v, want nil", err)
+	}
+
+	dp, err = etl.ValidateTestPath(
+		"gs://archive-mlab-testing/ndt/ndt5/2019/12/01/20191201T020011.395772Z-ndt5-mlab1-bcn01-ndt.tgz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := worker.Preflight(dp, attrs); err == nil || err.Code() != http.StatusForbidden {
+		t.Errorf("Preflight() = %v, want code %d", err, http.StatusForbidden)
+	}
+}
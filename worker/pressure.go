@@ -0,0 +1,115 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"runtime/metrics"
+	"time"
+
+	etlmetrics "github.com/m-lab/etl/metrics"
+	"github.com/m-lab/etl/row"
+)
+
+// Runtime metrics used to estimate the memory held by the process.  Memory
+// released to the OS remains mapped, and free heap memory is available for
+// reuse, so neither counts against the limit.
+var memorySamples = []metrics.Sample{
+	{Name: "/memory/classes/total:bytes"},
+	{Name: "/memory/classes/heap/released:bytes"},
+	{Name: "/memory/classes/heap/free:bytes"},
+}
+
+// memoryInUse returns the bytes of memory mapped by the runtime, less memory
+// released to the OS and free heap memory.
+func memoryInUse() uint64 {
+	s := make([]metrics.Sample, len(memorySamples))
+	copy(s, memorySamples)
+	metrics.Read(s)
+	for i := range s {
+		if s[i].Value.Kind() != metrics.KindUint64 {
+			return 0 // Not supported by this runtime.
+		}
+	}
+	return s[0].Value.Uint64() - s[1].Value.Uint64() - s[2].Value.Uint64()
+}
+
+// gcCycles returns the number of completed GC cycles.
+func gcCycles() uint64 {
+	s := []metrics.Sample{{Name: "/gc/cycles/total:gc-cycles"}}
+	metrics.Read(s)
+	if s[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return s[0].Value.Uint64()
+}
+
+// PressureMonitor periodically compares the memory in use with a limit, and
+// when it nears the limit, asks all row.Base instances to commit their
+// buffered rows early.  This trades insert efficiency for survival during
+// spikes of large rows, such as tcpinfo rows with many snapshots.
+//
+// After requesting a flush, the monitor does not request another until memory
+// use drops below the threshold, or a GC has completed and so could reflect
+// the flush.  Otherwise memory that is not yet reclaimed would cause a flush
+// at every check, and every Base would commit tiny batches indefinitely.
+//
+// Bases flush on their next Put, so a Base that is blocked, or no longer
+// receiving rows, keeps its buffered rows until its own Flush.
+type PressureMonitor struct {
+	limit     uint64
+	threshold uint64
+	interval  time.Duration
+	inUse     func() uint64 // for testing.
+	gcCycles  func() uint64 // for testing.
+
+	flushed   bool   // A flush was requested, and the monitor has not re-armed.
+	flushedGC uint64 // GC cycles completed when the flush was requested.
+}
+
+// NewPressureMonitor creates a PressureMonitor that requests early flushes
+// whenever the memory in use exceeds the fraction of the limit, checking at
+// the interval.
+func NewPressureMonitor(limit uint64, fraction float64, interval time.Duration) *PressureMonitor {
+	return &PressureMonitor{
+		limit:     limit,
+		threshold: uint64(float64(limit) * fraction),
+		interval:  interval,
+		inUse:     memoryInUse,
+		gcCycles:  gcCycles,
+	}
+}
+
+// Check requests early flushes if the memory in use exceeds the threshold, and
+// the monitor is armed, and reports whether it did.
+func (m *PressureMonitor) Check() bool {
+	used := m.inUse()
+	if used <= m.threshold {
+		m.flushed = false
+		return false
+	}
+	gc := m.gcCycles()
+	if m.flushed && gc == m.flushedGC {
+		return false
+	}
+	etlmetrics.MemoryPressureCount.Inc()
+	log.Printf("Memory in use %d exceeds %d of limit %d; flushing row buffers",
+		used, m.threshold, m.limit)
+	row.RequestFlush()
+	m.flushed = true
+	m.flushedGC = gc
+	return true
+}
+
+// Run calls Check at each interval until the context is done.
+func (m *PressureMonitor) Run(ctx context.Context) {
+	t := time.NewTicker(m.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			m.Check()
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package worker_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/m-lab/etl/row"
+	"github.com/m-lab/etl/worker"
+)
+
+type countingSink struct {
+	rows int
+}
+
+func (s *countingSink) Commit(rows []interface{}, label string) (int, error) {
+	s.rows += len(rows)
+	return len(rows), nil
+}
+
+func (s *countingSink) Close() error { return nil }
+
+func TestPressureMonitor(t *testing.T) {
+	used := uint64(0)
+	gc := uint64(0)
+	m := worker.NewPressureMonitor(1000, 0.8, time.Second)
+	m.SetInUse(func() uint64 { return used })
+	m.SetGCCycles(func() uint64 { return gc })
+
+	sink := &countingSink{}
+	b := row.NewBase("test", sink, 100)
+	b.Put(struct{}{})
+
+	used = 800
+	if m.Check() {
+		t.Error("Check() = true at threshold, want false")
+	}
+	b.Put(struct{}{})
+	if sink.rows != 0 {
+		t.Errorf("committed %d rows below threshold, want 0", sink.rows)
+	}
+
+	used = 801
+	if !m.Check() {
+		t.Error("Check() = false above threshold, want true")
+	}
+	b.Put(struct{}{})
+	if sink.rows != 2 {
+		t.Errorf("committed %d rows above threshold, want 2", sink.rows)
+	}
+
+	// No further flushes until a GC completes, or memory use drops.
+	if m.Check() {
+		t.Error("Check() = true again without a GC, want false")
+	}
+	gc++
+	if !m.Check() {
+		t.Error("Check() = false after a GC, want true")
+	}
+	used = 700
+	if m.Check() {
+		t.Error("Check() = true below threshold, want false")
+	}
+	used = 900
+	if !m.Check() {
+		t.Error("Check() = false after re-arming, want true")
+	}
+}
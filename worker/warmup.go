@@ -0,0 +1,48 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/m-lab/etl/metrics"
+)
+
+// ErrWarmupFailed is returned by Warmup if any check fails.
+var ErrWarmupFailed = errors.New("warm-up failed")
+
+// WarmupCheck constructs and validates one client at startup, so that the
+// first tasks do not pay for client construction, and misconfiguration is
+// reported before any task is accepted.
+type WarmupCheck struct {
+	// Name identifies the client in logs and metrics, e.g. "gcs".
+	Name string
+	// Run constructs and validates the client.
+	Run func(ctx context.Context) error
+}
+
+// Warmup runs the checks in order, logging and counting the outcome of each.
+// All checks run, even if earlier checks fail.  It returns ErrWarmupFailed,
+// wrapping the failures, if any check fails.  The caller decides whether to
+// exit, or to continue with clients constructed lazily.
+func Warmup(ctx context.Context, checks []WarmupCheck) error {
+	var failed []string
+	for _, c := range checks {
+		start := time.Now()
+		if err := c.Run(ctx); err != nil {
+			log.Printf("Warm-up of %s failed after %v: %v", c.Name, time.Since(start), err)
+			metrics.WarmupCount.WithLabelValues(c.Name, "error").Inc()
+			failed = append(failed, fmt.Sprintf("%s: %v", c.Name, err))
+			continue
+		}
+		log.Printf("Warm-up of %s took %v", c.Name, time.Since(start))
+		metrics.WarmupCount.WithLabelValues(c.Name, "ok").Inc()
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%w: %s", ErrWarmupFailed, strings.Join(failed, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package worker_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/m-lab/etl/worker"
+)
+
+func TestWarmup(t *testing.T) {
+	var ran []string
+	check := func(name string, err error) worker.WarmupCheck {
+		return worker.WarmupCheck{Name: name, Run: func(ctx context.Context) error {
+			ran = append(ran, name)
+			return err
+		}}
+	}
+	ctx := context.Background()
+	if err := worker.Warmup(ctx, []worker.WarmupCheck{check("a", nil), check("b", nil)}); err != nil {
+		t.Errorf("Warmup() = %v, want nil", err)
+	}
+
+	ran = nil
+	err := worker.Warmup(ctx, []worker.WarmupCheck{
+		check("gcs", errors.New("no credentials")),
+		check("datastore", nil),
+		check("uuid_map", errors.New("no such bucket")),
+	})
+	if !errors.Is(err, worker.ErrWarmupFailed) {
+		t.Fatalf("Warmup() = %v, want %v", err, worker.ErrWarmupFailed)
+	}
+	// All checks run, and every failure is reported.
+	if strings.Join(ran, ",") != "gcs,datastore,uuid_map" {
+		t.Errorf("Warmup() ran %v", ran)
+	}
+	for _, want := range []string{"gcs: no credentials", "uuid_map: no such bucket"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Warmup() = %v, missing %q", err, want)
+		}
+	}
+}
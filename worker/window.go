@@ -0,0 +1,162 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// DedupStore records keys for a limited time.  Implementations backed by a
+// shared store allow all workers to recognize a duplicate delivery.
+type DedupStore interface {
+	// Add records the key until the ttl expires.
+	Add(ctx context.Context, key string, ttl time.Duration) error
+	// Contains reports whether the key was added and has not expired.
+	Contains(ctx context.Context, key string) (bool, error)
+}
+
+// MemoryStore implements DedupStore in memory, for a single worker.
+type MemoryStore struct {
+	now func() time.Time // for testing.
+
+	lock    sync.Mutex
+	expires map[string]time.Time
+	adds    int // Adds since the last sweep of expired keys.
+}
+
+// sweepInterval is the number of Adds between sweeps of expired keys.
+const sweepInterval = 100
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{now: time.Now, expires: map[string]time.Time{}}
+}
+
+// Add implements DedupStore.
+func (m *MemoryStore) Add(ctx context.Context, key string, ttl time.Duration) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	now := m.now()
+	m.expires[key] = now.Add(ttl)
+	m.adds++
+	if m.adds >= sweepInterval {
+		m.adds = 0
+		for k, exp := range m.expires {
+			if !exp.After(now) {
+				delete(m.expires, k)
+			}
+		}
+	}
+	return nil
+}
+
+// Contains implements DedupStore.
+func (m *MemoryStore) Contains(ctx context.Context, key string) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	exp, ok := m.expires[key]
+	return ok && exp.After(m.now()), nil
+}
+
+// Len returns the number of keys held, including expired keys not yet swept.
+func (m *MemoryStore) Len() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return len(m.expires)
+}
+
+// dedupKind is the Datastore kind used by DatastoreStore.
+const dedupKind = "DedupWindow"
+
+// dedupEntity is the Datastore entity for a key.  Expired entities are
+// ignored, and should be deleted by a TTL policy on the Expires property.
+type dedupEntity struct {
+	Expires time.Time
+}
+
+// DatastoreStore implements DedupStore in Datastore, shared by all workers.
+type DatastoreStore struct {
+	client    *datastore.Client
+	namespace string
+}
+
+// NewDatastoreStore creates a DatastoreStore using entities in the namespace.
+func NewDatastoreStore(client *datastore.Client, namespace string) *DatastoreStore {
+	return &DatastoreStore{client: client, namespace: namespace}
+}
+
+func (d *DatastoreStore) key(key string) *datastore.Key {
+	k := datastore.NameKey(dedupKind, key, nil)
+	k.Namespace = d.namespace
+	return k
+}
+
+// Add implements DedupStore.
+func (d *DatastoreStore) Add(ctx context.Context, key string, ttl time.Duration) error {
+	_, err := d.client.Put(ctx, d.key(key), &dedupEntity{Expires: time.Now().Add(ttl)})
+	return err
+}
+
+// Contains implements DedupStore.
+func (d *DatastoreStore) Contains(ctx context.Context, key string) (bool, error) {
+	var e dedupEntity
+	err := d.client.Get(ctx, d.key(key), &e)
+	if errors.Is(err, datastore.ErrNoSuchEntity) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return e.Expires.After(time.Now()), nil
+}
+
+// DedupWindow drops exact duplicate deliveries of an archive that was
+// processed successfully by the same parser version within a short window.
+// Such duplicates commonly occur when a task queue retries a delivery whose
+// response was lost.  Failed deliveries are not recorded, so that their
+// retries are processed.  Duplicates of deliveries still in flight are
+// handled by InFlight.
+type DedupWindow struct {
+	store DedupStore
+	ttl   time.Duration
+}
+
+// NewDedupWindow creates a DedupWindow that recognizes duplicates for ttl
+// after a successful delivery.
+func NewDedupWindow(store DedupStore, ttl time.Duration) *DedupWindow {
+	return &DedupWindow{store: store, ttl: ttl}
+}
+
+func windowKey(uri, version string) string {
+	return uri + "@" + version
+}
+
+// Duplicate reports whether the archive was processed successfully by the
+// parser version within the window.  Store errors are logged, and treated as
+// not duplicate, since reprocessing is safe.  A nil DedupWindow reports false.
+func (w *DedupWindow) Duplicate(ctx context.Context, uri, version string) bool {
+	if w == nil {
+		return false
+	}
+	ok, err := w.store.Contains(ctx, windowKey(uri, version))
+	if err != nil {
+		log.Println("dedup window:", err)
+		return false
+	}
+	return ok
+}
+
+// Done records that the archive was processed successfully by the parser
+// version.  A nil DedupWindow does nothing.
+func (w *DedupWindow) Done(ctx context.Context, uri, version string) {
+	if w == nil {
+		return
+	}
+	if err := w.store.Add(ctx, windowKey(uri, version), w.ttl); err != nil {
+		log.Println("dedup window:", err)
+	}
+}
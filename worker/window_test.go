@@ -0,0 +1,68 @@
+package worker_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/m-lab/etl/worker"
+)
+
+func TestDedupWindow(t *testing.T) {
+	now := time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC)
+	store := worker.NewMemoryStore()
+	store.SetNow(func() time.Time { return now })
+	w := worker.NewDedupWindow(store, 10*time.Minute)
+	ctx := context.Background()
+	uri := "gs://bucket/ndt/ndt7/2022/07/01/20220701T000000.000000Z-ndt7-mlab1-foo01-ndt.tgz"
+
+	if w.Duplicate(ctx, uri, "v1") {
+		t.Error("Duplicate() true before Done")
+	}
+	w.Done(ctx, uri, "v1")
+	if !w.Duplicate(ctx, uri, "v1") {
+		t.Error("Duplicate() false within the window")
+	}
+	if w.Duplicate(ctx, uri, "v2") {
+		t.Error("Duplicate() true for a different parser version")
+	}
+	now = now.Add(10 * time.Minute)
+	if w.Duplicate(ctx, uri, "v1") {
+		t.Error("Duplicate() true after the window")
+	}
+
+	// Expired keys are eventually swept.
+	for i := 0; i < 100; i++ {
+		w.Done(ctx, fmt.Sprint(uri, i), "v1")
+	}
+	if store.Len() != 100 {
+		t.Errorf("Len() = %d, want 100 after sweep", store.Len())
+	}
+
+	var nilWindow *worker.DedupWindow
+	nilWindow.Done(ctx, uri, "v1")
+	if nilWindow.Duplicate(ctx, uri, "v1") {
+		t.Error("Duplicate() true for nil window")
+	}
+}
+
+type failingStore struct{}
+
+func (failingStore) Add(ctx context.Context, key string, ttl time.Duration) error {
+	return errors.New("unavailable")
+}
+
+func (failingStore) Contains(ctx context.Context, key string) (bool, error) {
+	return true, errors.New("unavailable")
+}
+
+func TestDedupWindow_StoreErrors(t *testing.T) {
+	w := worker.NewDedupWindow(failingStore{}, time.Minute)
+	ctx := context.Background()
+	w.Done(ctx, "gs://bucket/a.tgz", "v1")
+	if w.Duplicate(ctx, "gs://bucket/a.tgz", "v1") {
+		t.Error("Duplicate() true on store error")
+	}
+}
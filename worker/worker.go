@@ -11,6 +11,8 @@ import (
 	"github.com/m-lab/etl/factory"
 	"github.com/m-lab/etl/metrics"
 	"github.com/m-lab/etl/parser"
+	"github.com/m-lab/etl/row"
+	"github.com/m-lab/etl/storage"
 	"github.com/m-lab/etl/task"
 )
 
@@ -18,6 +20,38 @@ import (
 type StandardTaskFactory struct {
 	Sink   factory.SinkFactory
 	Source factory.SourceFactory
+
+	// TestTimeout limits the time to parse each test, if non-zero.
+	TestTimeout time.Duration
+	// MemoryGate limits the estimated memory of concurrent parsing, if non-nil.
+	MemoryGate *task.MemoryGate
+	// UUIDMap provides sinks for UUID mapping rows, if non-nil.  It is only
+	// used for datatypes whose parsers implement parser.UUIDMappable.
+	UUIDMap factory.SinkFactory
+	// Suffix is the SuffixStrategy for the parser table names of datatypes
+	// whose etl.Config does not set one.
+	Suffix etl.SuffixStrategy
+	// FileStats provides sinks for the file size profile of each archive, if
+	// non-nil.
+	FileStats factory.SinkFactory
+	// Clock returns the Clock for the parser of each task, if non-nil, e.g. to
+	// fix the parse time of all rows in a task.
+	Clock func() row.Clock
+	// Checkpoints records the progress of each archive, so that a retry of a
+	// partially processed archive skips its committed tests, if non-nil.
+	// Only archives whose sink implements row.Syncer are checkpointed.
+	Checkpoints task.CheckpointStore
+	// CheckpointEntries is the number of archive entries between
+	// checkpoints, each of which closes the sink's objects and starts new
+	// ones.
+	CheckpointEntries int
+	// DeadLetter records the tests that fail to parse, if non-nil.
+	DeadLetter task.DeadLetter
+}
+
+// clocked is implemented by parsers that embed row.Base.
+type clocked interface {
+	SetClock(c row.Clock)
 }
 
 // Get implements task.Factory.Get
@@ -36,14 +70,70 @@ func (tf *StandardTaskFactory) Get(ctx context.Context, dp etl.DataPath) (*task.
 		return nil, err
 	}
 
-	p := parser.NewSinkParser(dp.GetDataType(), sink, src.Type())
+	// Feed complete test groups to parsers that need companion files.
+	src = task.NewHoldingArea(src, dp.GetDataType())
+
+	suffix := dp.GetDataType().SuffixStrategy(tf.Suffix).Suffix(src.Date())
+	p := parser.NewSinkParser(dp.GetDataType(), sink, src.Type(), suffix)
 	if p == nil {
 		e := fmt.Errorf("%v creating parser for %s", err, dp.GetDataType())
 		log.Println(e, dp.URI)
 		return nil, err
 	}
 
-	tsk := task.NewTask(dp.URI, src, p, sink)
+	if c, ok := p.(clocked); ok && tf.Clock != nil {
+		c.SetClock(tf.Clock())
+	}
+
+	closer := storage.MultiCloser{sink}
+	if mp, ok := p.(parser.UUIDMappable); ok && tf.UUIDMap != nil {
+		mapSink, err := tf.UUIDMap.Get(ctx, dp)
+		if err != nil {
+			log.Printf("%v creating uuid map sink for %s %s", err, dp.GetDataType(), dp.URI)
+			if cerr := storage.JoinErrors(src.Close(), sink.Close()); cerr != nil {
+				log.Printf("%v closing task for %s", cerr, dp.URI)
+			}
+			return nil, err
+		}
+		m := parser.NewUUIDMapper(mapSink)
+		mp.SetUUIDMapper(m)
+		// The parser is flushed before the task is closed, so closing the
+		// mapper afterwards captures all mapping rows.
+		closer = append(closer, m)
+	}
+
+	var profiler *task.FileProfiler
+	if tf.FileStats != nil {
+		statsSink, err := tf.FileStats.Get(ctx, dp)
+		if err != nil {
+			log.Printf("%v creating file stats sink for %s %s", err, dp.GetDataType(), dp.URI)
+			if cerr := storage.JoinErrors(src.Close(), closer.Close()); cerr != nil {
+				log.Printf("%v closing task for %s", cerr, dp.URI)
+			}
+			return nil, err
+		}
+		profiler = task.NewFileProfiler(statsSink, string(dp.GetDataType()), dp.URI, src.Date())
+		closer = append(closer, profiler)
+	}
+
+	tsk := task.NewTask(dp.URI, src, p, closer)
+	tsk.SetFileProfiler(profiler)
+	if c, ok := sink.(row.Counter); ok {
+		tsk.SetSinkCounter(c)
+	}
+	if d, ok := sink.(row.Discarder); ok {
+		tsk.SetSinkDiscarder(d)
+	}
+	tsk.SetTestTimeout(tf.TestTimeout)
+	tsk.SetMemoryGate(tf.MemoryGate)
+	if tf.Checkpoints != nil {
+		if s, ok := sink.(row.Syncer); ok {
+			tsk.SetCheckpointStore(tf.Checkpoints, s, tf.CheckpointEntries)
+		} else {
+			log.Printf("Not checkpointing %s, whose sink publishes rows only when closed", dp.URI)
+		}
+	}
+	tsk.SetDeadLetter(tf.DeadLetter)
 	return tsk, nil
 }
 
@@ -57,6 +147,15 @@ func ProcessGKETask(ctx context.Context, path etl.DataPath, tf task.Factory) etl
 	metrics.WorkerCount.WithLabelValues(path.DataType).Inc()
 	defer metrics.WorkerCount.WithLabelValues(path.DataType).Dec()
 
+	// Expose the profiled cost of the active workers for autoscaling.
+	prof := etl.DataType(path.DataType).Profile()
+	cpu := metrics.ProfiledLoad.WithLabelValues(path.DataType, "cpu_weight")
+	cpu.Add(float64(prof.CPUWeight))
+	defer cpu.Sub(float64(prof.CPUWeight))
+	seconds := metrics.ProfiledLoad.WithLabelValues(path.DataType, "expected_seconds")
+	seconds.Add(prof.ExpectedSeconds)
+	defer seconds.Sub(prof.ExpectedSeconds)
+
 	// These keep track of the (nested) state of the worker.
 	metrics.WorkerState.WithLabelValues(path.DataType, "worker").Inc()
 	defer metrics.WorkerState.WithLabelValues(path.DataType, "worker").Dec()
@@ -68,13 +167,19 @@ func ProcessGKETask(ctx context.Context, path etl.DataPath, tf task.Factory) etl
 		return err
 	}
 
-	defer tsk.Close()
+	defer func() {
+		if err := tsk.Close(); err != nil {
+			log.Printf("%v closing task for %s", err, path.URI)
+		}
+	}()
 	return DoGKETask(tsk, path)
 }
 
 // DoGKETask creates task, processes all tests and handle metrics
 func DoGKETask(tsk *task.Task, path etl.DataPath) etl.ProcessingError {
-	files, err := tsk.ProcessAllTests(true) // fail fast on parsing errors.
+	// Fail fast on parsing errors.
+	files, err := tsk.ProcessAllTestsParallel(true, path.GetDataType().Profile().Parallelism)
+	reportSummary(tsk.Summary(), path)
 
 	dateFormat := "20060102"
 	date, dateErr := time.Parse(dateFormat, path.PackedDate)
@@ -94,6 +199,7 @@ func DoGKETask(tsk *task.Task, path etl.DataPath) etl.ProcessingError {
 	if err != nil {
 		metrics.TaskTotal.WithLabelValues(path.DataType, "TaskError").Inc()
 		log.Printf("Error Processing Tests:  %v", err)
+		tsk.Discard()
 		return factory.NewError(
 			path.DataType, "TaskError", http.StatusInternalServerError, err)
 		// TODO - anything better we could do here?
@@ -116,3 +222,21 @@ func DoGKETask(tsk *task.Task, path etl.DataPath) etl.ProcessingError {
 	metrics.TaskTotal.WithLabelValues(path.DataType, "OK").Inc()
 	return nil
 }
+
+// reportSummary logs the task's test and row counts, and adds the row counts
+// to the TaskRowCount metric, so that dropped rows can be detected.
+func reportSummary(s task.Summary, path etl.DataPath) {
+	log.Printf("Completed %s: %d files, %d resumed, %d parsed, %d dead lettered, %d counted, %d of %d expected rows emitted, %d short tests, %d rows dropped",
+		path.URI, s.Files, s.Resumed, s.Parsed, s.DeadLettered, s.Counted, s.Emitted, s.Expected, s.Short, s.Dropped)
+	metrics.TaskRowCount.WithLabelValues(path.DataType, "expected").Add(float64(s.Expected))
+	metrics.TaskRowCount.WithLabelValues(path.DataType, "emitted").Add(float64(s.Emitted))
+	metrics.TaskRowCount.WithLabelValues(path.DataType, "dropped").Add(float64(s.Dropped))
+	if s.Empty {
+		kind := "empty"
+		if s.Files > 0 {
+			kind = "directories_only"
+		}
+		log.Printf("Empty archive %s: %d files, no tests", path.URI, s.Files)
+		metrics.EmptyArchiveCount.WithLabelValues(path.DataType, kind).Inc()
+	}
+}
@@ -2,7 +2,9 @@ package worker_test
 
 import (
 	"archive/tar"
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -13,6 +15,7 @@ import (
 	"strings"
 	"testing"
 
+	"cloud.google.com/go/civil"
 	"github.com/googleapis/google-cloud-go-testing/storage/stiface"
 	"github.com/prometheus/client_golang/prometheus"
 	dto "github.com/prometheus/client_model/go"
@@ -22,6 +25,7 @@ import (
 	"github.com/m-lab/etl/etl"
 	"github.com/m-lab/etl/factory"
 	"github.com/m-lab/etl/metrics"
+	"github.com/m-lab/etl/row"
 	etlstorage "github.com/m-lab/etl/storage"
 	"github.com/m-lab/etl/worker"
 
@@ -141,6 +145,14 @@ func TestProcessGKETask(t *testing.T) {
 	metrics.TestTotal.Collect(c)
 	checkCounter(t, c, 478)
 
+	// The task summary rows are exported.
+	if got := counterValue(metrics.TaskRowCount.WithLabelValues("ndt5", "dropped")); got != 0 {
+		t.Error("Expected no dropped rows, got", got)
+	}
+	if got := counterValue(metrics.TaskRowCount.WithLabelValues("ndt5", "emitted")); got == 0 {
+		t.Error("Expected emitted rows, got", got)
+	}
+
 	// Lookup output from task.
 	o, err := fs.GetObject("test-bucket", "test-bucket/ndt/ndt5/2019/12/01/20191201T020011.395772Z-ndt5-mlab1-bcn01-ndt.tgz.jsonl")
 	if err != nil {
@@ -154,4 +166,112 @@ func TestProcessGKETask(t *testing.T) {
 	metrics.FileCount.Reset()
 	metrics.TaskTotal.Reset()
 	metrics.TestTotal.Reset()
+	metrics.TaskRowCount.Reset()
+}
+
+// brokenSource fails to read any test.
+type brokenSource struct{}
+
+func (brokenSource) NextTest(maxSize int64) (string, []byte, error) {
+	return "", nil, errors.New("stream error")
+}
+func (brokenSource) Close() error     { return nil }
+func (brokenSource) Detail() string   { return "broken" }
+func (brokenSource) Type() string     { return "ndt5" }
+func (brokenSource) Date() civil.Date { return civil.Date{Year: 2019, Month: 12, Day: 1} }
+
+type brokenSourceFactory struct{}
+
+func (brokenSourceFactory) Get(ctx context.Context, dp etl.DataPath) (etl.TestSource, etl.ProcessingError) {
+	return brokenSource{}, nil
+}
+
+// discardingSink records whether it was discarded before it was closed.
+type discardingSink struct {
+	discarded bool
+	closed    bool
+}
+
+func (ds *discardingSink) Commit(rows []interface{}, label string) (int, error) {
+	return len(rows), nil
+}
+func (ds *discardingSink) Discard() { ds.discarded = true }
+func (ds *discardingSink) Close() error {
+	ds.closed = true
+	return nil
+}
+
+type discardingSinkFactory struct {
+	sink *discardingSink
+}
+
+func (sf *discardingSinkFactory) Get(ctx context.Context, dp etl.DataPath) (row.Sink, etl.ProcessingError) {
+	return sf.sink, nil
+}
+
+func TestProcessGKETask_Discard(t *testing.T) {
+	sink := &discardingSink{}
+	tf := worker.StandardTaskFactory{
+		Sink:   &discardingSinkFactory{sink: sink},
+		Source: brokenSourceFactory{},
+	}
+	path, err := etl.ValidateTestPath(
+		"gs://test-bucket/ndt/ndt5/2019/12/01/20191201T020011.395772Z-ndt5-mlab1-bcn01-ndt.tgz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := worker.ProcessGKETask(context.Background(), path, &tf); err == nil {
+		t.Fatal("Expected an error from the broken source")
+	}
+	if !sink.discarded || !sink.closed {
+		t.Errorf("Sink discarded %v, closed %v; want both", sink.discarded, sink.closed)
+	}
+	metrics.FileCount.Reset()
+	metrics.TaskTotal.Reset()
+	metrics.TestTotal.Reset()
+	metrics.TaskRowCount.Reset()
+}
+
+func TestProcessGKETask_EmptyArchive(t *testing.T) {
+	// A tar file holding only a directory.
+	b := &bytes.Buffer{}
+	tw := tar.NewWriter(b)
+	rtx.Must(tw.WriteHeader(&tar.Header{Name: "2019/", Typeflag: tar.TypeDir, Mode: 0755}), "writing header")
+	rtx.Must(tw.Close(), "closing tar")
+
+	dir := "ndt/ndt5/2019/12/01/"
+	server := fakestorage.NewServer([]fakestorage.Object{
+		{BucketName: "test-bucket", Name: dir + "20191201T020011.395772Z-ndt5-mlab1-bcn01-ndt.tgz"},
+		{BucketName: "test-bucket", Name: dir + "20191201T020012.395772Z-ndt5-mlab1-bcn01-ndt.tar", Content: b.Bytes()},
+	})
+	defer server.Stop()
+
+	for _, tc := range []struct{ name, kind string }{
+		{"20191201T020011.395772Z-ndt5-mlab1-bcn01-ndt.tgz", "empty"},
+		{"20191201T020012.395772Z-ndt5-mlab1-bcn01-ndt.tar", "directories_only"},
+	} {
+		sink := &discardingSink{}
+		tf := worker.StandardTaskFactory{
+			Sink:   &discardingSinkFactory{sink: sink},
+			Source: &fakeSourceFactory{client: stiface.AdaptClient(server.Client())},
+		}
+		path, err := etl.ValidateTestPath("gs://test-bucket/" + dir + tc.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := worker.ProcessGKETask(context.Background(), path, &tf); err != nil {
+			t.Errorf("ProcessGKETask(%s) = %v, want nil", tc.name, err)
+		}
+		if sink.discarded {
+			t.Errorf("ProcessGKETask(%s) discarded the sink", tc.name)
+		}
+		if got := counterValue(metrics.EmptyArchiveCount.WithLabelValues("ndt5", tc.kind)); got != 1 {
+			t.Errorf("EmptyArchiveCount{%s} = %v, want 1", tc.kind, got)
+		}
+	}
+	metrics.FileCount.Reset()
+	metrics.TaskTotal.Reset()
+	metrics.TestTotal.Reset()
+	metrics.TaskRowCount.Reset()
+	metrics.EmptyArchiveCount.Reset()
 }